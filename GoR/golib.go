@@ -5,9 +5,28 @@ package main
 */
 import "C"
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
 	"unsafe"
+
+	"simulatedAnnealing/pkg/synthpop"
 )
 
+// C interface of the synthesizer for R, Python and other hosts, built with
+//
+//	go build -buildmode=c-shared -o golib.so golib.go
+//
+// Strings cross the boundary as NUL-terminated UTF-8 and results come back as JSON.
+// Every string returned must be released with FreeString. Arrays are copied in and
+// out, so the library keeps no pointer to the caller's memory, and a panic of the
+// call is returned as an error rather than bringing down the host process.
+
 //export Square
 func Square(x C.int) C.int {
 	return x * x
@@ -28,4 +47,409 @@ func Sum(arr *C.int, length C.int) C.int {
 	return total
 }
 
+// synthesisRequest is the JSON argument of RunSynthesis: the population and
+// annealing configs, each inline as in a config file or as the path of one
+type synthesisRequest struct {
+	Population json.RawMessage `json:"population"`
+	Annealing  json.RawMessage `json:"annealing"`
+}
+
+// synthesisSummary is the JSON returned by RunSynthesis
+type synthesisSummary struct {
+	Status         string   `json:"status"` // completed or failed
+	Run            string   `json:"run,omitempty"`
+	Output         string   `json:"output,omitempty"`
+	Synthesized    int      `json:"synthesized"`
+	MeanFitness    *float64 `json:"meanFitness,omitempty"`
+	WorstFitness   *float64 `json:"worstFitness,omitempty"`
+	WorstArea      string   `json:"worstArea,omitempty"`
+	ElapsedSeconds float64  `json:"elapsedSeconds"`
+	Error          string   `json:"error,omitempty"`
+}
+
+// areaSummary is the JSON returned by SynthesizeArea
+type areaSummary struct {
+	Status        string    `json:"status"` // completed or failed
+	Fitness       *float64  `json:"fitness,omitempty"`
+	Population    float64   `json:"population"`
+	Totals        []float64 `json:"totals,omitempty"`
+	BestIteration int       `json:"bestIteration"`
+	Iterations    int       `json:"iterations"`
+	TimedOut      bool      `json:"timedOut,omitempty"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// areasSummary is the JSON returned by SynthesizeAreas
+type areasSummary struct {
+	Status       string   `json:"status"` // completed or failed
+	Areas        int      `json:"areas"`
+	Individuals  int      `json:"individuals"`
+	MeanFitness  *float64 `json:"meanFitness,omitempty"`
+	WorstFitness *float64 `json:"worstFitness,omitempty"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// RunSynthesis runs a whole synthesis as the run command does, writing the outputs
+// of the population config. A maxProcs in the population config is rejected: the
+// GOMAXPROCS of the host process is set through its environment.
+//
+// Parameters:
+//   - configJSON: {"population": ..., "annealing": ...}, each config either a JSON
+//     object or the path of its file as a JSON string
+//
+// Returns:
+//   - *C.char: The JSON synthesisSummary, to be released with FreeString
+//
+//export RunSynthesis
+func RunSynthesis(configJSON *C.char) *C.char {
+	return C.CString(runSynthesis(C.GoString(configJSON)))
+}
+
+// SynthesizeArea synthesizes one area from arrays, without reading or writing any
+// file. The variables are named V1, V2... for the variable groups of the config.
+//
+// Parameters:
+//   - constraints: The nVars constraint values of the area
+//   - nVars: The number of variables
+//   - total: The population of the area
+//   - microdata: The nRecords x nVars microdata values, row by row
+//   - nRecords: The number of microdata records
+//   - annealingJSON: The annealing config, a JSON object or the path of its file
+//   - seed: Seeds the search, for reproducible results
+//   - counts: Receives the number of copies of every record in the population,
+//     nRecords values
+//
+// Returns:
+//   - *C.char: The JSON areaSummary, to be released with FreeString
+//
+//export SynthesizeArea
+func SynthesizeArea(constraints *C.double, nVars C.int, total C.double, microdata *C.double, nRecords C.int,
+	annealingJSON *C.char, seed C.longlong, counts *C.int) *C.char {
+	if constraints == nil || microdata == nil || counts == nil || nVars <= 0 || nRecords <= 0 {
+		return C.CString(failedArea(fmt.Errorf("constraints, microdata and counts are needed, with nVars and nRecords > 0")))
+	}
+	constraint := synthpop.ConstraintData{ID: "area", Total: float64(total), Values: copyDoubles(constraints, int(nVars))}
+	microData := microRecords(copyDoubles(microdata, int(nVars)*int(nRecords)), int(nRecords), int(nVars))
+
+	recordCounts := make([]int, nRecords)
+	summary := synthesizeArea(constraint, microData, C.GoString(annealingJSON), int64(seed), recordCounts)
+	out := unsafe.Slice((*C.int)(unsafe.Pointer(counts)), int(nRecords))
+	for i, n := range recordCounts {
+		out[i] = C.int(n)
+	}
+	return C.CString(summary)
+}
+
+// SynthesizeAreas synthesizes many areas on all CPUs from flat row-major arrays, as
+// numpy holds them. Area i is searched with seed + i, so the populations do not
+// depend on the number of CPUs.
+//
+// Parameters:
+//   - constraints: The nAreas x nVars constraint values, row by row
+//   - totals: The population of every area
+//   - nAreas: The number of areas
+//   - microdata: The nRecords x nVars microdata values, row by row
+//   - nRecords: The number of microdata records
+//   - nVars: The number of variables
+//   - names: The nVars variable names for the variable groups of the config, or
+//     NULL for V1, V2...
+//   - annealingJSON: The annealing config, a JSON object or the path of its file
+//   - seed: Seeds the search, for reproducible results
+//   - fitness: Receives the fitness of every area, nAreas values
+//   - areas, records: Receive the 0-based area and record of every synthetic
+//     individual, nRows values each to be released with FreeInts
+//   - nRows: Receives the number of individuals
+//
+// Returns:
+//   - *C.char: The JSON areasSummary, to be released with FreeString
+//
+//export SynthesizeAreas
+func SynthesizeAreas(constraints *C.double, totals *C.double, nAreas C.int, microdata *C.double, nRecords C.int,
+	nVars C.int, names **C.char, annealingJSON *C.char, seed C.longlong,
+	fitness *C.double, areas **C.int, records **C.int, nRows *C.int) *C.char {
+	if constraints == nil || totals == nil || microdata == nil || fitness == nil ||
+		areas == nil || records == nil || nRows == nil || nAreas <= 0 || nRecords <= 0 || nVars <= 0 {
+		return C.CString(failedAreas(fmt.Errorf("constraints, totals, microdata and the outputs are needed, with nAreas, nRecords and nVars > 0")))
+	}
+	*areas, *records, *nRows = nil, nil, 0
+
+	width := int(nVars)
+	header := variableNames(width)
+	if names != nil {
+		for j, name := range unsafe.Slice(names, width) {
+			header[j] = C.GoString(name)
+		}
+	}
+	values, areaTotals := copyDoubles(constraints, int(nAreas)*width), copyDoubles(totals, int(nAreas))
+	constraintData := make([]synthpop.ConstraintData, nAreas)
+	for i := range constraintData {
+		constraintData[i] = synthpop.ConstraintData{ID: strconv.Itoa(i), Total: areaTotals[i],
+			Values: values[i*width : (i+1)*width : (i+1)*width]}
+	}
+	microData := microRecords(copyDoubles(microdata, int(nRecords)*width), int(nRecords), width)
+
+	config, err := synthpop.ParseAnnealingConfig(C.GoString(annealingJSON))
+	if err != nil {
+		return C.CString(failedAreas(fmt.Errorf("annealing config: %w", err)))
+	}
+	results, err := synthpop.SynthesizeAreas(constraintData, microData, header, config, int64(seed))
+	if err != nil {
+		return C.CString(failedAreas(err))
+	}
+
+	summary := areasSummary{Status: "completed", Areas: len(results)}
+	areaFitness := unsafe.Slice((*float64)(unsafe.Pointer(fitness)), int(nAreas))
+	sum, worst := 0.0, math.Inf(-1)
+	for i, res := range results {
+		areaFitness[i] = res.Fitness
+		summary.Individuals += len(res.IDs)
+		sum, worst = sum+res.Fitness, max(worst, res.Fitness)
+	}
+	summary.MeanFitness, summary.WorstFitness = finite(sum/float64(len(results))), finite(worst)
+	if summary.Individuals == 0 {
+		return C.CString(toJSON(summary))
+	}
+
+	outAreas, outRecords := mallocInts(summary.Individuals), mallocInts(summary.Individuals)
+	k := 0
+	for i, res := range results {
+		for _, id := range res.IDs {
+			record, err := recordIndex(id, int(nRecords))
+			if err != nil {
+				C.free(unsafe.Pointer(&outAreas[0]))
+				C.free(unsafe.Pointer(&outRecords[0]))
+				return C.CString(failedAreas(err))
+			}
+			outAreas[k], outRecords[k] = C.int(i), C.int(record)
+			k++
+		}
+	}
+	*areas, *records, *nRows = &outAreas[0], &outRecords[0], C.int(k)
+	return C.CString(toJSON(summary))
+}
+
+// FreeString releases a string returned by the library
+//
+//export FreeString
+func FreeString(s *C.char) {
+	C.free(unsafe.Pointer(s))
+}
+
+// FreeInts releases an array returned by SynthesizeAreas
+//
+//export FreeInts
+func FreeInts(p *C.int) {
+	C.free(unsafe.Pointer(p))
+}
+
+// R's .C interface passes every argument as a pointer and copies strings back from
+// the char* slots, so these adapters keep the last summary alive for R to copy and
+// release it on the next call
+
+var (
+	rMu      sync.Mutex
+	rSummary *C.char
+)
+
+// setRSummary stores the summary of an adapter call in its output slot
+func setRSummary(out **C.char, summary *C.char) {
+	rMu.Lock()
+	defer rMu.Unlock()
+	C.free(unsafe.Pointer(rSummary))
+	rSummary, *out = summary, summary
+}
+
+// RunSynthesisR is RunSynthesis for R's .C, with the two configs passed apart so R
+// need not build the request JSON
+//
+//export RunSynthesisR
+func RunSynthesisR(population **C.char, annealing **C.char, summary **C.char) {
+	setRSummary(summary, C.CString(synthesize(synthesisRequest{
+		Population: json.RawMessage(C.GoString(*population)),
+		Annealing:  json.RawMessage(C.GoString(*annealing)),
+	})))
+}
+
+// SynthesizeAreaR is SynthesizeArea for R's .C, with the seed as a double as R
+// integers are 32 bits
+//
+//export SynthesizeAreaR
+func SynthesizeAreaR(constraints *C.double, nVars *C.int, total *C.double, microdata *C.double, nRecords *C.int,
+	annealingJSON **C.char, seed *C.double, counts *C.int, summary **C.char) {
+	setRSummary(summary, SynthesizeArea(constraints, *nVars, *total, microdata, *nRecords,
+		*annealingJSON, C.longlong(*seed), counts))
+}
+
+// runSynthesis runs the synthesis of a RunSynthesis request and returns its JSON
+// summary
+func runSynthesis(requestJSON string) string {
+	var request synthesisRequest
+	if err := json.Unmarshal([]byte(requestJSON), &request); err != nil {
+		return toJSON(synthesisSummary{Status: "failed", Error: fmt.Sprintf("error decoding request JSON: %v", err)})
+	}
+	return synthesize(request)
+}
+
+// synthesize runs the synthesis of a decoded request and returns its JSON summary
+func synthesize(request synthesisRequest) (summary string) {
+	start := time.Now()
+	result := synthesisSummary{Status: "failed"}
+	defer func() {
+		if r := recover(); r != nil {
+			result.Status, result.Error = "failed", fmt.Sprintf("synthesis panicked: %v", r)
+		}
+		result.ElapsedSeconds = time.Since(start).Seconds()
+		summary = toJSON(result)
+	}()
+
+	popConfig, err := synthpop.ParseConfig(string(request.Population))
+	if err != nil {
+		result.Error = fmt.Sprintf("population config: %v", err)
+		return
+	}
+	if popConfig.MaxProcs > 0 {
+		// GOMAXPROCS belongs to the R or Python process hosting the library
+		result.Error = "population config: maxProcs cannot be set through the library, set GOMAXPROCS instead"
+		return
+	}
+	config, err := synthpop.ParseAnnealingConfig(string(request.Annealing))
+	if err != nil {
+		result.Error = fmt.Sprintf("annealing config: %v", err)
+		return
+	}
+	if popConfig.RunName == "" {
+		popConfig.RunName = synthpop.NewRunName()
+	}
+	popConfig, err = synthpop.ApplyRunName(popConfig)
+	if err != nil {
+		result.Error = err.Error()
+		return
+	}
+	result.Run, result.Output = popConfig.RunName, popConfig.Output.File
+
+	results := make(chan synthpop.Result)
+	controller := synthpop.NewController()
+	controller.Results = results
+	collected := make(chan struct{})
+	sum := 0.0
+	worst := math.Inf(-1)
+	go func() {
+		defer close(collected)
+		for res := range results {
+			result.Synthesized++
+			sum += res.Fitness
+			if res.Fitness > worst {
+				worst, result.WorstArea = res.Fitness, res.Area
+			}
+		}
+	}()
+	err = controller.Run(context.Background(), popConfig, config)
+	close(results)
+	<-collected
+
+	if result.Synthesized > 0 {
+		result.MeanFitness = finite(sum / float64(result.Synthesized))
+		result.WorstFitness = finite(worst)
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return
+	}
+	result.Status = "completed"
+	return
+}
+
+// synthesizeArea synthesizes one area of SynthesizeArea, counting the copies of
+// every record in counts, and returns its JSON summary
+func synthesizeArea(constraint synthpop.ConstraintData, microData []synthpop.MicroData, annealingJSON string, seed int64,
+	counts []int) (summary string) {
+	defer func() {
+		if r := recover(); r != nil {
+			summary = failedArea(fmt.Errorf("synthesis panicked: %v", r))
+		}
+	}()
+
+	config, err := synthpop.ParseAnnealingConfig(annealingJSON)
+	if err != nil {
+		return failedArea(fmt.Errorf("annealing config: %w", err))
+	}
+	header := variableNames(len(constraint.Values))
+	res, err := synthpop.SynthesizeArea(constraint, microData, header, config, rand.New(rand.NewSource(seed)))
+	if err != nil {
+		return failedArea(err)
+	}
+	for _, id := range res.IDs {
+		i, err := recordIndex(id, len(counts))
+		if err != nil {
+			return failedArea(err)
+		}
+		counts[i]++
+	}
+	return toJSON(areaSummary{Status: "completed", Fitness: finite(res.Fitness), Population: res.Population,
+		Totals: res.Totals, BestIteration: res.BestIteration, Iterations: res.Iterations, TimedOut: res.TimedOut})
+}
+
+// copyDoubles copies n doubles from C memory, so the search holds no pointer to it
+func copyDoubles(p *C.double, n int) []float64 {
+	return append([]float64(nil), unsafe.Slice((*float64)(unsafe.Pointer(p)), n)...)
+}
+
+// microRecords returns the n records of row-major values, identified by their index
+func microRecords(values []float64, n, width int) []synthpop.MicroData {
+	microData := make([]synthpop.MicroData, n)
+	for i := range microData {
+		microData[i] = synthpop.MicroData{ID: strconv.Itoa(i), Values: values[i*width : (i+1)*width : (i+1)*width]}
+	}
+	return microData
+}
+
+// recordIndex returns the index of a record of microRecords from its ID
+func recordIndex(id string, n int) (int, error) {
+	i, err := strconv.Atoi(id)
+	if err != nil || i < 0 || i >= n {
+		return 0, fmt.Errorf("unexpected record ID %q in the population", id)
+	}
+	return i, nil
+}
+
+// variableNames returns the names V1, V2... of width variables
+func variableNames(width int) []string {
+	header := make([]string, width)
+	for j := range header {
+		header[j] = "V" + strconv.Itoa(j+1)
+	}
+	return header
+}
+
+// mallocInts allocates n C ints for the caller to release with FreeInts
+func mallocInts(n int) []C.int {
+	return unsafe.Slice((*C.int)(C.malloc(C.size_t(n)*C.size_t(unsafe.Sizeof(C.int(0))))), n)
+}
+
+// failedArea returns the JSON summary of an area that could not be synthesized
+func failedArea(err error) string {
+	return toJSON(areaSummary{Status: "failed", Error: err.Error()})
+}
+
+// failedAreas returns the JSON summary of areas that could not be synthesized
+func failedAreas(err error) string {
+	return toJSON(areasSummary{Status: "failed", Error: err.Error()})
+}
+
+// finite returns a pointer to v, or nil for the infinities and NaN JSON cannot hold
+func finite(v float64) *float64 {
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return nil
+	}
+	return &v
+}
+
+func toJSON(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf(`{"status":"failed","error":%q}`, err.Error())
+	}
+	return string(data)
+}
+
 func main() {}
@@ -12,6 +12,8 @@
 
 #ifndef GO_CGO_GOSTRING_TYPEDEF
 typedef struct { const char *p; ptrdiff_t n; } _GoString_;
+extern size_t _GoStringLen(_GoString_ s);
+extern const char *_GoStringPtr(_GoString_ s);
 #endif
 
 #endif
@@ -49,10 +51,16 @@ typedef size_t GoUintptr;
 typedef float GoFloat32;
 typedef double GoFloat64;
 #ifdef _MSC_VER
+#if !defined(__cplusplus) || _MSVC_LANG <= 201402L
 #include <complex.h>
 typedef _Fcomplex GoComplex64;
 typedef _Dcomplex GoComplex128;
 #else
+#include <complex>
+typedef std::complex<float> GoComplex64;
+typedef std::complex<double> GoComplex128;
+#endif
+#else
 typedef float _Complex GoComplex64;
 typedef double _Complex GoComplex128;
 #endif
@@ -81,6 +89,13 @@ extern "C" {
 
 extern int Square(int x);
 extern int Sum(int* arr, int length);
+extern char* RunSynthesis(char* configJSON);
+extern char* SynthesizeArea(double* constraints, int nVars, double total, double* microdata, int nRecords, char* annealingJSON, long long int seed, int* counts);
+extern char* SynthesizeAreas(double* constraints, double* totals, int nAreas, double* microdata, int nRecords, int nVars, char** names, char* annealingJSON, long long int seed, double* fitness, int** areas, int** records, int* nRows);
+extern void FreeString(char* s);
+extern void FreeInts(int* p);
+extern void RunSynthesisR(char** population, char** annealing, char** summary);
+extern void SynthesizeAreaR(double* constraints, int* nVars, double* total, double* microdata, int* nRecords, char** annealingJSON, double* seed, int* counts, char** summary);
 
 #ifdef __cplusplus
 }
@@ -0,0 +1,53 @@
+"""Calls the synthesizer in golib.so from Python with ctypes."""
+
+import ctypes
+import json
+
+lib = ctypes.CDLL("./golib.so")
+lib.RunSynthesis.argtypes = [ctypes.c_char_p]
+lib.RunSynthesis.restype = ctypes.c_void_p  # Freed with FreeString, so not c_char_p
+lib.SynthesizeArea.argtypes = [
+    ctypes.POINTER(ctypes.c_double), ctypes.c_int, ctypes.c_double,
+    ctypes.POINTER(ctypes.c_double), ctypes.c_int,
+    ctypes.c_char_p, ctypes.c_longlong, ctypes.POINTER(ctypes.c_int),
+]
+lib.SynthesizeArea.restype = ctypes.c_void_p
+lib.FreeString.argtypes = [ctypes.c_void_p]
+
+
+def _summary(pointer):
+    """Decodes a JSON summary returned by the library and releases it"""
+    try:
+        return json.loads(ctypes.string_at(pointer).decode("utf-8"))
+    finally:
+        lib.FreeString(pointer)
+
+
+def _config(config):
+    """Returns a config argument: a dict as JSON, a path or JSON string as it is"""
+    return config if isinstance(config, str) else json.dumps(config)
+
+
+def run_synthesis(population, annealing):
+    """Runs a whole synthesis; each config is a dict or the path of a config file"""
+    request = json.dumps({"population": population, "annealing": annealing})
+    return _summary(lib.RunSynthesis(request.encode("utf-8")))
+
+
+def synthesize_area(constraints, total, microdata, annealing, seed=42):
+    """Synthesizes one area from its constraints and a list of microdata rows with
+    the same columns; returns the summary and the number of copies of every row"""
+    n_vars, n_records = len(constraints), len(microdata)
+    if any(len(row) != n_vars for row in microdata):
+        raise ValueError("every microdata row needs one value per constraint")
+    values = (ctypes.c_double * (n_vars * n_records))(*[v for row in microdata for v in row])
+    counts = (ctypes.c_int * n_records)()
+    summary = _summary(lib.SynthesizeArea(
+        (ctypes.c_double * n_vars)(*constraints), n_vars, total, values, n_records,
+        _config(annealing).encode("utf-8"), seed, counts))
+    return summary, list(counts)
+
+
+if __name__ == "__main__":
+    micro = [[1, 0, 1, 0], [0, 1, 1, 0], [1, 0, 0, 1], [0, 1, 0, 1]]
+    print(synthesize_area([3, 2, 4, 1], 5, micro, "../annealing_config.json"))
@@ -0,0 +1,181 @@
+"""Python bindings of the GoSynthPop synthesizer, over the C interface of golib.so.
+
+Synthesizes areas from pandas DataFrames, numpy arrays or lists of rows without
+writing CSVs, e.g. from the inputs of a command-line run:
+
+    import pandas as pd
+    import synthpop
+
+    constraints = pd.read_csv("constraints.csv", index_col=0)  # area, total, variables...
+    microdata = pd.read_csv("microdata.csv", index_col=0)      # ID, variables...
+    population = synthpop.synthesize(constraints, microdata, "annealing_config.json")
+
+The library is golib.so next to this module, or the file named by GOSYNTHPOP_LIB.
+Build it with `go build -buildmode=c-shared -o golib.so golib.go`. numpy and pandas
+are used when installed but not needed.
+"""
+
+import ctypes
+import json
+import os
+
+try:
+    import numpy as np
+except ImportError:
+    np = None
+try:
+    import pandas as pd
+except ImportError:
+    pd = None
+
+__all__ = ["synthesize", "SynthesisError"]
+
+
+class SynthesisError(RuntimeError):
+    """The library could not synthesize the areas"""
+
+
+_lib = None
+
+
+def _library():
+    """Loads golib.so and declares the functions used, once"""
+    global _lib
+    if _lib is None:
+        path = os.environ.get("GOSYNTHPOP_LIB") or os.path.join(os.path.dirname(os.path.abspath(__file__)), "golib.so")
+        lib = ctypes.CDLL(path)
+        double_p, int_p = ctypes.POINTER(ctypes.c_double), ctypes.POINTER(ctypes.c_int)
+        lib.SynthesizeAreas.argtypes = [
+            double_p, double_p, ctypes.c_int,                       # constraints, totals, nAreas
+            double_p, ctypes.c_int, ctypes.c_int,                   # microdata, nRecords, nVars
+            ctypes.POINTER(ctypes.c_char_p), ctypes.c_char_p, ctypes.c_longlong,  # names, annealing, seed
+            double_p, ctypes.POINTER(int_p), ctypes.POINTER(int_p), int_p,        # fitness, areas, records, nRows
+        ]
+        lib.SynthesizeAreas.restype = ctypes.c_void_p  # Freed with FreeString, so not c_char_p
+        lib.FreeString.argtypes = [ctypes.c_void_p]
+        lib.FreeInts.argtypes = [int_p]
+        _lib = lib
+    return _lib
+
+
+def _table(data, what):
+    """Returns the row labels, column names and rows of a DataFrame, 2-D array or
+    list of rows; the labels and names are None when the data has none"""
+    if pd is not None and isinstance(data, pd.DataFrame):
+        return [str(label) for label in data.index], [str(name) for name in data.columns], data.to_numpy(dtype=float)
+    if np is not None:
+        rows = np.asarray(data, dtype=float)
+        if rows.ndim != 2:
+            raise ValueError(f"{what} must be a 2-D table")
+        return None, None, rows
+    rows = [[float(v) for v in row] for row in data]
+    if len({len(row) for row in rows}) > 1:
+        raise ValueError(f"{what} rows differ in length")
+    return None, None, rows
+
+
+def _doubles(rows):
+    """Returns a pointer to the values of rows, row by row"""
+    if np is not None:
+        # The pointer keeps a reference to the array
+        return np.ascontiguousarray(rows, dtype=np.float64).ctypes.data_as(ctypes.POINTER(ctypes.c_double))
+    flat = [v for row in rows for v in row]
+    return (ctypes.c_double * len(flat))(*flat)
+
+
+def _ints(pointer, n):
+    """Copies n C ints into a list"""
+    if n == 0:
+        return []
+    if np is not None:
+        return np.ctypeslib.as_array(pointer, shape=(n,)).tolist()
+    return pointer[:n]
+
+
+def _select(rows, columns):
+    """Returns the given columns of rows"""
+    if np is not None:
+        return np.asarray(rows)[:, columns]
+    return [[row[j] for j in columns] for row in rows]
+
+
+def synthesize(constraints, microdata, params, totals=None, seed=42):
+    """Synthesizes the population of every area by drawing microdata records whose
+    variables best reproduce the area's constraints.
+
+    Parameters:
+        constraints: DataFrame, 2-D array or list of rows, one row per area. Without
+            totals its first column is the population of the area, as in constraint
+            CSV files. The index of a DataFrame gives the area IDs.
+        microdata: DataFrame, 2-D array or list of rows, one row per record, with the
+            variables of the constraints; the columns of DataFrames are matched by
+            name and their index gives the record IDs.
+        params: The annealing config, as a dict, its JSON or the path of its file
+        totals: The population of every area, when constraints has no total column
+        seed: Seeds the search, for reproducible results
+
+    Returns:
+        A DataFrame, or without pandas a dict of lists, with one row per synthetic
+        individual: its area, the id of the microdata record drawn and the fitness of
+        the area (lower is better). Area and record IDs are row numbers for data
+        without an index.
+
+    Raises:
+        SynthesisError: The configuration is invalid or an area could not be synthesized
+    """
+    area_ids, variables, constraint_rows = _table(constraints, "constraints")
+    record_ids, micro_variables, micro_rows = _table(microdata, "microdata")
+    if len(constraint_rows) == 0 or len(micro_rows) == 0:
+        raise ValueError("constraints and microdata need at least one row")
+    if totals is None:
+        totals = [row[0] for row in constraint_rows]
+        constraint_rows = _select(constraint_rows, range(1, len(constraint_rows[0])))
+        variables = variables[1:] if variables is not None else None
+    if len(totals) != len(constraint_rows):
+        raise ValueError("one total is needed for every area")
+    if variables is not None and micro_variables is not None:
+        missing = [v for v in variables if v not in micro_variables]
+        if missing:
+            raise ValueError(f"microdata lack the variables {', '.join(missing)}")
+        micro_rows = _select(micro_rows, [micro_variables.index(v) for v in variables])
+
+    n_areas, n_records, n_vars = len(constraint_rows), len(micro_rows), len(constraint_rows[0])
+    if len(micro_rows[0]) != n_vars:
+        raise ValueError("constraints and microdata need the same variables")
+    if area_ids is None:
+        area_ids = [str(i) for i in range(n_areas)]
+    if record_ids is None:
+        record_ids = [str(i) for i in range(n_records)]
+
+    annealing = params if isinstance(params, str) else json.dumps(params)  # A path or JSON as it is
+    names = None
+    if variables is not None:
+        names = (ctypes.c_char_p * n_vars)(*[v.encode("utf-8") for v in variables])
+
+    lib = _library()
+    constraint_values, micro_values = _doubles(constraint_rows), _doubles(micro_rows)
+    area_totals = (ctypes.c_double * n_areas)(*[float(t) for t in totals])
+    fitness = (ctypes.c_double * n_areas)()
+    areas, records, n_rows = ctypes.POINTER(ctypes.c_int)(), ctypes.POINTER(ctypes.c_int)(), ctypes.c_int()
+    pointer = lib.SynthesizeAreas(constraint_values, area_totals, n_areas, micro_values, n_records, n_vars,
+                                  names, annealing.encode("utf-8"), seed, fitness,
+                                  ctypes.byref(areas), ctypes.byref(records), ctypes.byref(n_rows))
+    try:
+        summary = json.loads(ctypes.string_at(pointer).decode("utf-8"))
+    finally:
+        lib.FreeString(pointer)
+    if summary["status"] != "completed":
+        raise SynthesisError(summary.get("error", "synthesis failed"))
+
+    try:
+        area_index, record_index = _ints(areas, n_rows.value), _ints(records, n_rows.value)
+    finally:
+        if n_rows.value:
+            lib.FreeInts(areas)
+            lib.FreeInts(records)
+    result = {
+        "area": [area_ids[i] for i in area_index],
+        "id": [record_ids[r] for r in record_index],
+        "fitness": [fitness[i] for i in area_index],
+    }
+    return pd.DataFrame(result) if pd is not None else result
@@ -0,0 +1,62 @@
+#include <Rcpp.h>
+#include <string>
+#include <vector>
+using namespace Rcpp;
+
+// Build the Go library, then this wrapper against it:
+//   cd ../go && go build -buildmode=c-shared -o libsum.so .
+//   cd ../cpp && export PKG_CPPFLAGS="$(Rscript -e 'Rcpp:::CxxFlags()')"
+//   PKG_LIBS="-L../go -lsum -Wl,-rpath,$(cd ../go && pwd)" R CMD SHLIB -o sum.so wrap.cpp synth.cpp
+
+extern "C" char* SynthesizeAreas(double* constraints, double* totals, int nAreas, double* microdata, int nRecords,
+                                 char** names, int nVars, char* annealing, long long seed,
+                                 double* fitness, int** areas, int** records, int* nRows);
+extern "C" void FreeMemory(void* p);
+
+// Synthesizes every row of constraints from the rows of microdata, called by
+// synthesize_areas in ../R/synthpop.R. Returns a list of the 1-based area and
+// record of every synthetic individual and the fitness of every area.
+extern "C" SEXP synthesize_areas(SEXP constraintsSEXP, SEXP totalsSEXP, SEXP microdataSEXP, SEXP namesSEXP,
+                                 SEXP annealingSEXP, SEXP seedSEXP) {
+BEGIN_RCPP
+    NumericMatrix constraints(constraintsSEXP);
+    NumericVector totals(totalsSEXP);
+    NumericMatrix microdata(microdataSEXP);
+    CharacterVector names(namesSEXP);
+    std::string annealing = as<std::string>(annealingSEXP);
+    long long seed = static_cast<long long>(as<double>(seedSEXP));
+
+    int nAreas = constraints.nrow(), nVars = constraints.ncol();
+    if (totals.size() != nAreas) stop("one total is needed for every area");
+    if (microdata.ncol() != nVars || names.size() != nVars) stop("constraints and microdata need the same variables");
+
+    std::vector<std::string> nameStrings(nVars);
+    std::vector<char*> namePointers(nVars);
+    for (int j = 0; j < nVars; j++) {
+        nameStrings[j] = as<std::string>(names[j]);
+        namePointers[j] = &nameStrings[j][0];
+    }
+
+    NumericVector fitness(nAreas);
+    int* areas = nullptr;
+    int* records = nullptr;
+    int nRows = 0;
+    char* error = SynthesizeAreas(REAL(constraints), REAL(totals), nAreas, REAL(microdata), microdata.nrow(),
+                                  namePointers.data(), nVars, &annealing[0], seed,
+                                  REAL(fitness), &areas, &records, &nRows);
+    if (error != nullptr) {
+        std::string message(error);
+        FreeMemory(error);
+        stop(message);
+    }
+
+    IntegerVector area(nRows), record(nRows);
+    for (int k = 0; k < nRows; k++) {
+        area[k] = areas[k] + 1;
+        record[k] = records[k] + 1;
+    }
+    FreeMemory(areas);
+    FreeMemory(records);
+    return List::create(Named("area") = area, Named("record") = record, Named("fitness") = fitness);
+END_RCPP
+}
@@ -1,3 +1,7 @@
 module sumlib
 
 go 1.24.6
+
+require simulatedAnnealing v0.0.0
+
+replace simulatedAnnealing => ../../
@@ -1,6 +1,6 @@
 /* Code generated by cmd/cgo; DO NOT EDIT. */
 
-/* package command-line-arguments */
+/* package sumlib */
 
 
 #line 1 "cgo-builtin-export-prolog"
@@ -12,6 +12,8 @@
 
 #ifndef GO_CGO_GOSTRING_TYPEDEF
 typedef struct { const char *p; ptrdiff_t n; } _GoString_;
+extern size_t _GoStringLen(_GoString_ s);
+extern const char *_GoStringPtr(_GoString_ s);
 #endif
 
 #endif
@@ -25,6 +27,12 @@ typedef struct { const char *p; ptrdiff_t n; } _GoString_;
 
 #line 1 "cgo-generated-wrapper"
 
+#line 3 "synth.go"
+
+#include <stdlib.h>
+
+#line 1 "cgo-generated-wrapper"
+
 
 /* End of preamble from import "C" comments.  */
 
@@ -49,10 +57,16 @@ typedef size_t GoUintptr;
 typedef float GoFloat32;
 typedef double GoFloat64;
 #ifdef _MSC_VER
+#if !defined(__cplusplus) || _MSVC_LANG <= 201402L
 #include <complex.h>
 typedef _Fcomplex GoComplex64;
 typedef _Dcomplex GoComplex128;
 #else
+#include <complex>
+typedef std::complex<float> GoComplex64;
+typedef std::complex<double> GoComplex128;
+#endif
+#else
 typedef float _Complex GoComplex64;
 typedef double _Complex GoComplex128;
 #endif
@@ -80,6 +94,8 @@ extern "C" {
 #endif
 
 extern double SumVec(double* vec, int length);
+extern char* SynthesizeAreas(double* constraints, double* totals, int nAreas, double* microdata, int nRecords, char** names, int nVars, char* annealing, long long int seed, double* fitness, int** areas, int** records, int* nRows);
+extern void FreeMemory(void* p);
 
 #ifdef __cplusplus
 }
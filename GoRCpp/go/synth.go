@@ -0,0 +1,124 @@
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"fmt"
+	"strconv"
+	"unsafe"
+
+	"simulatedAnnealing/pkg/synthpop"
+)
+
+// Per-area synthesis for the Rcpp wrapper (../cpp/synth.cpp): the constraints and
+// microdata arrive as R matrices, column by column, and the synthetic individuals
+// go back as the area and record index of every one of them. The matrices are
+// copied before the search, so no pointer to R's memory is kept, and the arrays
+// returned are allocated with malloc for the caller to release with FreeMemory.
+
+// SynthesizeAreas synthesizes every area (row) of a constraint matrix by drawing
+// records (rows) of a microdata matrix with the same variables
+//
+// Parameters:
+//   - constraints: The nAreas x nVars constraint matrix, column-major
+//   - totals: The population of every area
+//   - nAreas: The number of areas
+//   - microdata: The nRecords x nVars microdata matrix, column-major
+//   - nRecords: The number of microdata records
+//   - names: The nVars variable names, for the variable groups of the config
+//   - nVars: The number of variables
+//   - annealing: The annealing config file, or its JSON
+//   - seed: Seeds the search; area i is searched with seed + i as by the Python
+//     bindings, so both give the same populations
+//   - fitness: Receives the fitness of every area, nAreas values
+//   - areas, records: Receive the 0-based area and record of every individual,
+//     nRows values each to be released with FreeMemory
+//   - nRows: Receives the number of individuals
+//
+// Returns:
+//   - *C.char: NULL, or the error to be released with FreeMemory
+//
+//export SynthesizeAreas
+func SynthesizeAreas(constraints *C.double, totals *C.double, nAreas C.int, microdata *C.double, nRecords C.int,
+	names **C.char, nVars C.int, annealing *C.char, seed C.longlong,
+	fitness *C.double, areas **C.int, records **C.int, nRows *C.int) *C.char {
+	if constraints == nil || totals == nil || microdata == nil || names == nil || annealing == nil || fitness == nil ||
+		areas == nil || records == nil || nRows == nil || nAreas <= 0 || nRecords <= 0 || nVars <= 0 {
+		return C.CString("constraints, totals, microdata, names, annealing and the outputs are needed, with nAreas, nRecords and nVars > 0")
+	}
+	*areas, *records, *nRows = nil, nil, 0
+
+	header := make([]string, nVars)
+	for j, name := range unsafe.Slice(names, int(nVars)) {
+		header[j] = C.GoString(name)
+	}
+	constraintData := make([]synthpop.ConstraintData, nAreas)
+	areaTotals := unsafe.Slice((*float64)(unsafe.Pointer(totals)), int(nAreas))
+	for i, row := range fromColumns(constraints, int(nAreas), int(nVars)) {
+		constraintData[i] = synthpop.ConstraintData{ID: strconv.Itoa(i), Values: row, Total: areaTotals[i]}
+	}
+	microData := make([]synthpop.MicroData, nRecords)
+	for i, row := range fromColumns(microdata, int(nRecords), int(nVars)) {
+		microData[i] = synthpop.MicroData{ID: strconv.Itoa(i), Values: row}
+	}
+
+	config, err := synthpop.ParseAnnealingConfig(C.GoString(annealing))
+	if err != nil {
+		return C.CString(fmt.Sprintf("annealing config: %v", err))
+	}
+	results, err := synthpop.SynthesizeAreas(constraintData, microData, header, config, int64(seed))
+	if err != nil {
+		return C.CString(err.Error())
+	}
+	n := 0
+	for _, res := range results {
+		n += len(res.IDs)
+	}
+	areaFitness := unsafe.Slice((*float64)(unsafe.Pointer(fitness)), int(nAreas))
+	if n == 0 {
+		for i, res := range results {
+			areaFitness[i] = res.Fitness
+		}
+		return nil
+	}
+	outAreas, outRecords := mallocInts(n), mallocInts(n)
+	k := 0
+	for i, res := range results {
+		areaFitness[i] = res.Fitness
+		for _, id := range res.IDs {
+			record, _ := strconv.Atoi(id) // The IDs are the record indices set above
+			outAreas[k], outRecords[k] = C.int(i), C.int(record)
+			k++
+		}
+	}
+	*areas, *records, *nRows = &outAreas[0], &outRecords[0], C.int(n)
+	return nil
+}
+
+// FreeMemory releases an array or error returned by SynthesizeAreas
+//
+//export FreeMemory
+func FreeMemory(p unsafe.Pointer) {
+	C.free(p)
+}
+
+// fromColumns copies a column-major C matrix into rows sharing one backing array
+func fromColumns(matrix *C.double, rows, columns int) [][]float64 {
+	values := unsafe.Slice((*float64)(unsafe.Pointer(matrix)), rows*columns)
+	flat := make([]float64, rows*columns)
+	out := make([][]float64, rows)
+	for i := range out {
+		out[i] = flat[i*columns : (i+1)*columns : (i+1)*columns]
+		for j := range out[i] {
+			out[i][j] = values[j*rows+i]
+		}
+	}
+	return out
+}
+
+// mallocInts allocates n C ints for the caller to release with FreeMemory
+func mallocInts(n int) []C.int {
+	return unsafe.Slice((*C.int)(C.malloc(C.size_t(n)*C.size_t(unsafe.Sizeof(C.int(0))))), n)
+}
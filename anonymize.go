@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+
+	"simulatedAnnealing/pkg/synthpop"
+)
+
+// anonymizeCommand implements `anonymize <anonymization config>`: it writes a
+// shareable training microdata file from real microdata, for runnable examples that
+// can be given to external collaborators
+func anonymizeCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: anonymize <anonymization config>")
+	}
+	config, err := synthpop.LoadAnonymizeConfig(args[0])
+	if err != nil {
+		return err
+	}
+	report, err := synthpop.Anonymize(config)
+	if err != nil {
+		return err
+	}
+
+	synthpop.Printf("🕶️ Wrote %d of %d records with %d columns to %s\n", report.Written, report.Records, report.Columns, config.Output)
+	if report.Sampled > 0 {
+		synthpop.Printf("   %d records left out by the sample\n", report.Sampled)
+	}
+	if len(config.QuasiIdentifiers) > 0 {
+		synthpop.Printf("   Unique quasi-identifier combinations: %d before, %d after the rules\n", report.UniqueBefore, report.UniqueAfter)
+	}
+	if config.K > 0 {
+		synthpop.Printf("   %d records suppressed in combinations shared by fewer than %d records\n", report.Suppressed, config.K)
+	}
+	return nil
+}
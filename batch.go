@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
+	"simulatedAnnealing/pkg/synthpop"
+)
+
+// stringList collects a repeatable string flag
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// runCommand implements `run [-a annealing config] [-resume|-append] [overrides] [-f config]...`.
+// With several -f flags the configs run back-to-back, sharing any inputs they have
+// in common. A failing config does not stop the batch; failures are summarised at
+// the end. The override flags (-max-iterations, -seed, -workers...) apply to every
+// config.
+func runCommand(args []string) error {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	var configFiles stringList
+	flags.Var(&configFiles, "f", "population config file (repeat to run a batch)")
+	annealingFile := flags.String("a", "annealing_config.json", "annealing config file")
+	resume := flags.Bool("resume", false, "continue from each config's checkpoint.file, skipping completed areas")
+	appendNew := flags.Bool("append", false, "synthesize only areas missing from the existing outputs and append them")
+	overrides := addOverrideFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	// Positional config files are accepted too: run cfg1.json cfg2.json
+	configFiles = append(configFiles, flags.Args()...)
+	if len(configFiles) == 0 {
+		configFiles = stringList{"config.json"}
+	}
+	if len(configFiles) > 1 {
+		for _, name := range []string{"output", "validate"} {
+			if overrides.set(name) && !strings.Contains(flags.Lookup(name).Value.String(), synthpop.RunNamePlaceholder) {
+				return fmt.Errorf("-%s applies to every config of the batch, include %s in it", name, synthpop.RunNamePlaceholder)
+			}
+		}
+	}
+
+	annealingConfig, err := synthpop.LoadAnnealingConfig(*annealingFile)
+	if err != nil {
+		return fmt.Errorf("annealing config error: %w", err)
+	}
+	if annealingConfig, err = overrides.applyAnnealing(annealingConfig); err != nil {
+		return err
+	}
+
+	controller := synthpop.NewController() // Shares inputs between the configs
+	var failed []string
+	batchStart := time.Now()
+	for i, configFile := range configFiles {
+		if len(configFiles) > 1 {
+			synthpop.Printf("\n▶️ Run %d/%d: %s\n", i+1, len(configFiles), configFile)
+		}
+		config, err := synthpop.LoadConfig(configFile)
+		if err == nil {
+			config, err = overrides.applyPopulation(config)
+		}
+		if *resume {
+			config.Checkpoint.Resume = true
+		}
+		if *appendNew {
+			config.Output.Append = true
+		}
+		if err == nil {
+			err = withMaxProcs(config.MaxProcs, func() error {
+				return controller.Run(context.Background(), config, annealingConfig)
+			})
+		}
+		if err != nil {
+			synthpop.Printf("❌ %s failed: %v\n", configFile, err)
+			failed = append(failed, configFile)
+		}
+	}
+
+	if len(configFiles) > 1 {
+		synthpop.Printf("\n🏁 Batch of %d runs finished in %v, %d failed\n",
+			len(configFiles), time.Since(batchStart).Round(time.Second), len(failed))
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed configs: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// withMaxProcs calls fn with GOMAXPROCS set to the maxProcs of a config (0 leaves it
+// unchanged) and restores it after. The library leaves GOMAXPROCS alone as it is
+// process-wide; the configs of a batch run one at a time, so here it is the run's own.
+func withMaxProcs(maxProcs int, fn func() error) error {
+	if maxProcs > 0 {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(maxProcs))
+	}
+	return fn()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"time"
+
+	"simulatedAnnealing/pkg/synthpop"
+)
+
+// benchmarkCommand implements `benchmark [-a annealing config] [-f config] [-n areas] [overrides]`:
+// it synthesizes the first areas one after another without writing outputs and
+// reports the throughput and the expected duration of the full run
+func benchmarkCommand(args []string) error {
+	flags := flag.NewFlagSet("benchmark", flag.ContinueOnError)
+	configFile := flags.String("f", "config.json", "population config file")
+	annealingFile := flags.String("a", "annealing_config.json", "annealing config file")
+	areas := flags.Int("n", 5, "number of areas to time")
+	overrides := addOverrideFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 || *areas <= 0 {
+		return fmt.Errorf("usage: benchmark [-f config] [-a annealing config] [-n areas > 0]")
+	}
+
+	config, err := synthpop.LoadConfig(*configFile)
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+	if config, err = overrides.applyPopulation(config); err != nil {
+		return err
+	}
+	annealingConfig, err := synthpop.LoadAnnealingConfig(*annealingFile)
+	if err != nil {
+		return fmt.Errorf("annealing config error: %w", err)
+	}
+	if annealingConfig, err = overrides.applyAnnealing(annealingConfig); err != nil {
+		return err
+	}
+	return withMaxProcs(config.MaxProcs, func() error {
+		return benchmarkAreas(config, annealingConfig, *areas)
+	})
+}
+
+// benchmarkAreas times the first areas of config, under the GOMAXPROCS of the run
+func benchmarkAreas(config synthpop.PopulationConfig, annealingConfig synthpop.AnnealingConfig, areas int) error {
+	in, err := synthpop.NewController().Load(context.Background(), config)
+	if err != nil {
+		return err
+	}
+	annealingConfig = in.WithTableGroups(annealingConfig)
+	if areas > len(in.Constraints) {
+		areas = len(in.Constraints)
+	}
+
+	synthpop.Printf("⏱️ Benchmarking %d of %d areas\n", areas, len(in.Constraints))
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var total time.Duration
+	iterations := 0
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for _, constraint := range in.Constraints[:areas] {
+		start := time.Now()
+		res, err := synthpop.SynthesizeArea(constraint, in.MicroData, in.Header, annealingConfig, rng)
+		took := time.Since(start)
+		if err != nil {
+			synthpop.Printf("   %-16s failed after %v: %v\n", constraint.ID, took, err)
+			continue
+		}
+		total += took
+		iterations += res.Iterations
+		synthpop.Printf("   %-16s %10v  %8d iterations  fitness %.6g\n", constraint.ID, took.Round(time.Microsecond), res.Iterations, res.Fitness)
+	}
+	runtime.ReadMemStats(&after)
+
+	if total == 0 {
+		return fmt.Errorf("no area was synthesized")
+	}
+	perArea := total / time.Duration(areas)
+	workers := runtime.NumCPU()
+	if config.Workers > 0 {
+		workers = config.Workers
+	}
+	workers = min(workers, len(in.Constraints))
+	estimate := perArea * time.Duration(len(in.Constraints)) / time.Duration(workers)
+	synthpop.Printf("🚀 %.2f areas/s, %.0f iterations/s per worker\n",
+		float64(areas)/total.Seconds(), float64(iterations)/total.Seconds())
+	synthpop.Printf("🧠 %d allocations, %.1fKB allocated per area\n",
+		(after.Mallocs-before.Mallocs)/uint64(areas), float64(after.TotalAlloc-before.TotalAlloc)/float64(areas)/(1<<10))
+	synthpop.Printf("🕒 Full run of %d areas on %d workers: about %v\n", len(in.Constraints), workers, estimate.Round(time.Second))
+	return nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+
+	"simulatedAnnealing/pkg/synthpop"
+)
+
+// convertIDsCommand implements `convert-ids <input> <output>`, converting an ID
+// mapping CSV between the ids and counts layouts
+func convertIDsCommand(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: convert-ids <IDs file> <output file>")
+	}
+	layout, err := synthpop.ConvertIDs(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	synthpop.Printf("✅ Wrote %s in the %s layout\n", args[1], layout)
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"simulatedAnnealing/pkg/synthpop"
+)
+
+// decryptCommand implements `decrypt <input> <output>` using the key from the environment
+func decryptCommand(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: decrypt <encrypted file> <output file>")
+	}
+	key, err := synthpop.LoadEncryptionKey()
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("cannot open encrypted file: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(args[1])
+	if err != nil {
+		return fmt.Errorf("cannot create output file: %w", err)
+	}
+	defer out.Close()
+
+	if err := synthpop.DecryptStream(out, in, key); err != nil {
+		return err
+	}
+	return out.Close()
+}
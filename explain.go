@@ -0,0 +1,843 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"simulatedAnnealing/pkg/synthpop"
+)
+
+// parameterDoc describes one configuration parameter for the explain subcommand.
+// Keep this table in step with synthpop.AnnealingConfig and synthpop.PopulationConfig.
+type parameterDoc struct {
+	Name         string // JSON name, dotted for nested population config fields
+	File         string // Which config file the parameter belongs to
+	Type         string
+	Description  string
+	Range        string
+	Interactions string
+}
+
+var parameterDocs = []parameterDoc{
+	{
+		Name: "initialTemp", File: "annealing", Type: "float",
+		Description:  "Starting temperature of the annealing schedule. Higher values accept worse moves more often early on.",
+		Range:        "> minTemp, typically 1-10000 depending on the scale of the distance metric",
+		Interactions: "Reheating never drops the temperature below 10% of initialTemp.",
+	},
+	{
+		Name: "minTemp", File: "annealing", Type: "float",
+		Description:  "Temperature at which the search for an area stops.",
+		Range:        "> 0 and < initialTemp",
+		Interactions: "With coolingRate sets the longest schedule: log(minTemp/initialTemp)/log(coolingRate) iterations.",
+	},
+	{
+		Name: "coolingRate", File: "annealing", Type: "float",
+		Description:  "Factor the temperature is multiplied by after every iteration.",
+		Range:        "0 < coolingRate < 1, typically 0.99-0.99999",
+		Interactions: "Values close to 1 cool slowly and need a larger maxIterations to reach minTemp.",
+	},
+	{
+		Name: "reheatFactor", File: "annealing", Type: "float",
+		Description:  "On stagnation the temperature is raised to max(temp*(1+reheatFactor), 0.1*initialTemp).",
+		Range:        ">= 0",
+		Interactions: "Stagnation is detected with windowSize and minImprovement.",
+	},
+	{
+		Name: "fitnessThreshold", File: "annealing", Type: "float",
+		Description:  "An area stops as soon as its best fitness is at or below this value.",
+		Range:        ">= 0, in the units of the chosen distance metric",
+		Interactions: "Depends on distance: a good threshold for MANHATTEN is far larger than for KL_DIVERGENCE.",
+	},
+	{
+		Name: "minImprovement", File: "annealing", Type: "float",
+		Description:  "Relative fitness improvement across the window below which the search is considered stagnant and reheated; below a tenth of it the area stops.",
+		Range:        "0-1, typically 0.0001-0.01",
+		Interactions: "Measured over the last windowSize iterations.",
+	},
+	{
+		Name: "maxIterations", File: "annealing", Type: "int",
+		Description:  "Hard cap on the number of annealing iterations per area.",
+		Range:        "> 0",
+		Interactions: "The best_iteration column of the validate output shows how much of this budget is used.",
+	},
+	{
+		Name: "maxSecondsPerArea", File: "annealing", Type: "float",
+		Description:  "Time budget of one area in seconds: the search stops with its best solution so far once it is spent, whatever maxIterations allows.",
+		Range:        ">= 0 (default 0, no limit)",
+		Interactions: "Shared by the restarts of the area. Applies to annealing, tempering, ga and tabu; ipf is not interrupted. The clock makes results depend on the machine, so seeded runs are only reproducible when no area reaches the budget.",
+	},
+	{
+		Name: "maxTotalMinutes", File: "annealing", Type: "float",
+		Description:  "Time budget of the run in minutes. Once it is spent no more areas are handed to the workers, the areas in progress stop with their best solution so far, and the areas left unprocessed are listed in the failed areas file with the reason \"run time budget (maxTotalMinutes) exhausted\". The outputs of the processed areas are written as usual.",
+		Range:        ">= 0 (default 0, no limit)",
+		Interactions: "The unprocessed areas count as failed for the quality gate, and are synthesized by resuming the run from its checkpoint. With watch.dir the run ends at the budget even without the stop file.",
+	},
+	{
+		Name: "windowSize", File: "annealing", Type: "int",
+		Description:  "Number of recent iterations used to detect stagnation. 0 adapts the window to each area: ten replacement sweeps over the area's population, at least 100 iterations and at most maxIterations / 20.",
+		Range:        "0 (adaptive, default) or >= 1 and well below maxIterations",
+		Interactions: "Stagnation checks only start after windowSize iterations. The window and the number of reheats used for every area are in validate.summaryFile and the worker logs.",
+	},
+	{
+		Name: "change", File: "annealing", Type: "int",
+		Description:  "Budget of rejected moves per area; the search stops when it is used up.",
+		Range:        "> 0",
+		Interactions: "Acts as a second stopping rule next to maxIterations and minTemp.",
+	},
+	{
+		Name: "distance", File: "annealing", Type: "string",
+		Description:  "Distance metric used as the fitness between synthetic totals and constraints.",
+		Range:        strings.Join(synthpop.ValidMetrics, ", ") + ", or a metric defined in metrics or registered with synthpop.RegisterDistance",
+		Interactions: "Sets the scale of fitnessThreshold and the useful range of initialTemp.",
+	},
+	{
+		Name: "metrics", File: "annealing", Type: "object",
+		Description:  "Distance metrics defined by expressions, by name: {\"term\": ..., \"final\": ...}. The term is summed over the variables, with c the constraint, t the synthetic total and C and T the sums of the constraints and totals of the variables compared; final optionally transforms the sum s. Expressions use numbers, + - * /, parentheses, min, max, pow, abs, sqrt, log and exp.",
+		Range:        "names other than the built-in and registered distances",
+		Interactions: "Usable in distance and variableGroups like the built-in metrics. variableWeights multiply the terms. Lower must mean a better fit, and fitnessThreshold is on the scale of the metric.",
+	},
+	{
+		Name: "useRandomSeed", File: "annealing", Type: "string",
+		Description:  "\"yes\" seeds the random number generators from randomSeed for reproducible runs; anything else seeds from the clock.",
+		Range:        "yes | no",
+		Interactions: "Requires randomSeed when set to yes.",
+	},
+	{
+		Name: "randomSeed", File: "annealing", Type: "int",
+		Description:  "Master seed from which the random numbers of every area are derived, together with the area ID, so a rerun reproduces identical outputs whatever the worker count or scheduling.",
+		Range:        "any 64-bit integer",
+		Interactions: "Only used when useRandomSeed is yes. Also seeds the holdout split.",
+	},
+	{
+		Name: "perVariableTemperature", File: "annealing", Type: "bool",
+		Description:  "Experimental: every constraint variable carries its own temperature. Variables that already match their constraint cool faster, so moves are judged mostly on the variables that are still off.",
+		Range:        "true | false (default false)",
+		Interactions: "The per-variable temperatures are scaled from the global schedule, so initialTemp, coolingRate and reheating still apply.",
+	},
+	{
+		Name: "tieBreak", File: "annealing", Type: "string",
+		Description:  "Which solution is kept when several share the best fitness: \"first\" keeps the first one found, \"hash\" keeps the one whose sorted microdata IDs hash lowest, so reproducible runs stay reproducible when the search order changes.",
+		Range:        "first | hash (default first)",
+		Interactions: "hash costs a sort of the population for every tie, which matters mostly for integer-valued metrics such as MANHATTEN.",
+	},
+	{
+		Name: "acceptance", File: "annealing", Type: "string",
+		Description:  "Which moves that make the fitness worse the annealing keeps. \"metropolis\" keeps them with probability exp(-increase/temperature); \"greedy\" never keeps them, so the search only descends; \"threshold\" (threshold accepting) keeps those whose increase is below the temperature, without drawing a random number. Moves that do not make the fitness worse are always kept.",
+		Range:        "metropolis | greedy | threshold (default metropolis)",
+		Interactions: "The temperature schedule (initialTemp, coolingRate, reheating) is the threshold of threshold accepting and is ignored by greedy. With perVariableTemperature the rule applies to the temperature-scaled energy of the move. Greedy cannot be combined with tempering.",
+	},
+	{
+		Name: "variableGroups", File: "annealing", Type: "list of {name, variables, distance, weight}",
+		Description:  "Fits named groups of variables with their own distance metric, e.g. CHI_SQUARED for counts and JSDIVERGENCE for proportions. The fitness is the weighted sum of the group distances.",
+		Range:        "variables from the header, each in at most one group; distance from the distance list; weight > 0 (default 1)",
+		Interactions: "Variables not in any group are fitted with distance and weight 1. Group metrics differ in scale, so weights also balance the groups against each other.",
+	},
+	{
+		Name: "variableWeights", File: "annealing", Type: "map of variable name to float",
+		Description:  "Scales each variable's contribution to the distance, so hard constraints such as the total population or age bands can be prioritised. Applies to every metric: per-variable terms are multiplied by the weight (inside the square root for EUCLIDEAN and NORM_EUCLIDEAN) and COSINE uses the weighted inner product.",
+		Range:        "variables from the header; weight >= 0 (default 1, 0 ignores the variable)",
+		Interactions: "Combines with variableGroups: the variable weights apply within each group, the group weight to the group's total.",
+	},
+	{
+		Name: "denominators", File: "annealing", Type: "map of variable name to list of variable names",
+		Description:  "Subpopulation of variables that only apply to part of an area, as the variables summing to it, e.g. {\"employed\": [\"age16_64\", \"age65p\"]}. The distance compares such a variable by its share of the subpopulation (the synthetic count rescaled to the constraint's subpopulation), so an error in the number of adults does not also count as an error in employment.",
+		Range:        "variables from the header (default: every variable is a share of the area population)",
+		Interactions: "The shares in validate.errorsFile use the same denominators. Applied after roundingBase, to whichever metric, weights and groups are configured.",
+	},
+	{
+		Name: "roundingBase", File: "annealing", Type: "int",
+		Description:  "Rounding base of the constraints, e.g. 3 or 5 for UK census small-area tables randomly rounded for disclosure control. Synthetic totals within base-1 of a constraint count as exact and larger deviations are reduced by base-1 before the distance is computed, so no effort is spent fitting the rounding noise.",
+		Range:        "integer >= 0 (default 0; 0 and 1 disable)",
+		Interactions: "The reported fitness is the rounding-aware one, so fitnessThreshold is reached once every variable is within tolerance. perVariableTemperature cools variables on their raw errors.",
+	},
+	{
+		Name: "hardConstraints", File: "annealing", Type: "list of variable names",
+		Description:  "Variables whose constraints must be met, e.g. the total population or a sex split. Every unit a hard variable's synthetic count is off its constraint adds hardPenalty to the fitness, on top of the distance, while the remaining variables are fitted as soft targets.",
+		Range:        "header variable names (default none)",
+		Interactions: "Hard variables stay in the distance and any variable group too. With roundingBase the penalty starts beyond the rounding tolerance. The penalty dominates the fitness, so fitnessThreshold is only reached once the hard constraints are met.",
+	},
+	{
+		Name: "hardPenalty", File: "annealing", Type: "float",
+		Description:  "Fitness added per unit of deviation from a hard constraint. The default is the factor NORM_EUCLIDEAN weighs a count against a zero constraint by.",
+		Range:        ">= 0 (default 1000 when 0)",
+		Interactions: "Only used with hardConstraints. Should exceed the largest gain in the distance one individual can bring, on the scale of the chosen metric.",
+	},
+	{
+		Name: "algorithm", File: "annealing", Type: "string",
+		Description:  "Synthesis algorithm. \"annealing\" searches integer populations with simulated annealing; \"ipf\" fits fractional weights for the valid records of each area with iterative proportional fitting, then integerizes them. IPF is much faster for well-conditioned problems and gives a baseline for the annealing results. \"ga\" evolves a population of candidate populations with a genetic algorithm, which can escape where annealing stalls. \"tabu\" makes the best of a sample of replace moves every iteration, even a worse one, and forbids moving recently swapped records again for a while, so the search walks out of local minima without cycling.",
+		Range:        "annealing | ipf | ga | tabu (default annealing)",
+		Interactions: "With ipf, ga or tabu the temperature schedule is ignored. With ipf distance is only used to report the fitness and best_iteration holds the number of IPF sweeps; ga minimises distance and best_iteration holds the generation of the best candidate. tabu stops after maxIterations, at fitnessThreshold or when the best fitness has not improved within the stagnation window (windowSize).",
+	},
+	{
+		Name: "areaAlgorithms", File: "annealing", Type: "map of area ID to algorithm",
+		Description:  "Algorithm of particular areas, overriding algorithm, e.g. {\"E02000123\": \"tabu\"} for areas where annealing stalls. The areas share every output with the rest of the run.",
+		Range:        "annealing | ipf | ga | tabu per area",
+		Interactions: "Not supported with ipf.fractional.",
+	},
+	{
+		Name: "tabu.listSize", File: "annealing", Type: "int",
+		Description: "Number of recently swapped microdata records the tabu search remembers at most; the oldest is forgotten first.",
+		Range:       "> 0 (default 50)",
+	},
+	{
+		Name: "tabu.tenure", File: "annealing", Type: "int",
+		Description:  "Iterations for which a record taken out of or put into the population may not be swapped again, unless the move gives a new best population.",
+		Range:        "> 0 (default 20)",
+		Interactions: "Only tabu.listSize records are remembered, so a long tenure needs a list at least twice as long.",
+	},
+	{
+		Name: "tabu.candidates", File: "annealing", Type: "int",
+		Description: "Replace moves evaluated every iteration of the tabu search; the best admissible one is made.",
+		Range:       "> 0 (default 20)",
+	},
+	{
+		Name: "restarts", File: "annealing", Type: "int",
+		Description:  "Independent runs of the synthesis per area, each from a different initial population; the best is kept. A wide spread of fitness across the restarts (fitness_worst and fitness_sd in validate.summaryFile) shows an area whose solution depends on the luck of the search.",
+		Range:        "integer >= 0 (default 0; 0 and 1 run once)",
+		Interactions: "Applies to every algorithm, and multiplies the run time of every area. best_iteration, the trace and the search columns are those of the best restart.",
+	},
+	{
+		Name: "iterationScaling.by", File: "annealing", Type: "string",
+		Description:  "Scales the iteration budget of every area to its size, replacing maxIterations: \"population\" gives perUnit iterations per individual of the area's total, \"cells\" per non-zero constraint cell. Tiny areas then stop early and large ones are not under-fitted.",
+		Range:        "population | cells (empty uses maxIterations for every area)",
+		Interactions: "A fixed windowSize is scaled by the area's budget over maxIterations; the adaptive window is capped at a twentieth of the area's budget. Applies to annealing, tempering and tabu search.",
+	},
+	{
+		Name: "iterationScaling.perUnit", File: "annealing", Type: "float",
+		Description: "Iterations per individual or non-zero constraint cell of an area.",
+		Range:       "> 0, required with iterationScaling.by",
+	},
+	{
+		Name: "iterationScaling.min", File: "annealing", Type: "int",
+		Description: "Smallest iteration budget of an area, however few individuals or cells it has.",
+		Range:       ">= 0 (0 for no floor)",
+	},
+	{
+		Name: "iterationScaling.max", File: "annealing", Type: "int",
+		Description:  "Largest iteration budget of an area, however large it is.",
+		Range:        ">= iterationScaling.min (0 for no cap)",
+		Interactions: "The best_iteration column of the validate output shows whether the capped areas needed more.",
+	},
+	{
+		Name: "tempering.replicas", File: "annealing", Type: "int",
+		Description:  "Annealing chains per area for parallel tempering (replica exchange). The chains follow the temperature schedule scaled by powers of tempering.tempRatio and regularly swap populations, so a population a hot chain carried out of a local minimum is refined by the cold ones. The chains run concurrently on the cores idle workers leave free, which happens once fewer areas remain than workers, and in turn otherwise; results do not depend on the cores lent.",
+		Range:        "integer >= 0 (default 0; 0 and 1 run a single chain)",
+		Interactions: "Annealing only, not with perVariableTemperature. The hot chains replace reheating, so reheatFactor is unused; change counts the rejected moves of the coldest chain. Every tempered area costs replicas times the iterations of a single chain.",
+	},
+	{
+		Name: "tempering.tempRatio", File: "annealing", Type: "float",
+		Description: "Ratio between the temperatures of neighbouring chains; the coldest chain follows the schedule.",
+		Range:       "> 1 (default 2)",
+	},
+	{
+		Name: "tempering.exchangeInterval", File: "annealing", Type: "int",
+		Description: "Iterations every chain runs between two rounds of exchanges between neighbouring chains.",
+		Range:       "> 0 (default 100)",
+	},
+	{
+		Name: "tempering.minPopulation", File: "annealing", Type: "float",
+		Description: "Areas with a smaller total population run a single chain, keeping tempering for the large areas where one chain stagnates.",
+		Range:       ">= 0 (default 0, every area)",
+	},
+	{
+		Name: "ipf.maxIterations", File: "annealing", Type: "int",
+		Description: "Maximum number of IPF sweeps over all constraint variables.",
+		Range:       "> 0 (default 100)",
+	},
+	{
+		Name: "ipf.tolerance", File: "annealing", Type: "float",
+		Description: "IPF stops once no scaling factor in a sweep differs from 1 by more than this.",
+		Range:       "> 0 (default 1e-6)",
+	},
+	{
+		Name: "ipf.fractional", File: "annealing", Type: "bool",
+		Description:  "Keep the fractional IPF weights instead of integerizing them with truncate-replicate-sample.",
+		Range:        "true | false (default false)",
+		Interactions: "Gives no individuals, so it requires output.aggregateOnly; write the weights with output.weightsFile.",
+	},
+	{
+		Name: "ga.populationSize", File: "annealing", Type: "int",
+		Description:  "Candidate populations of an area evolved together by the genetic algorithm.",
+		Range:        "> 1 (default 50)",
+		Interactions: "Memory and time per generation grow with populationSize times the area population.",
+	},
+	{
+		Name: "ga.generations", File: "annealing", Type: "int",
+		Description:  "Maximum number of generations.",
+		Range:        "> 0 (default 500)",
+		Interactions: "The search also stops once fitnessThreshold is reached or after ga.stallLimit generations without improvement.",
+	},
+	{
+		Name: "ga.mutationRate", File: "annealing", Type: "float",
+		Description: "Share of a child's individuals replaced by random valid records after crossover, the replace move of the annealing.",
+		Range:       "0-1 (default 0.01)",
+	},
+	{
+		Name: "ga.elite", File: "annealing", Type: "int",
+		Description: "Best candidates copied unchanged into the next generation, so the best fitness never gets worse.",
+		Range:       "< ga.populationSize (default 2)",
+	},
+	{
+		Name: "ga.tournamentSize", File: "annealing", Type: "int",
+		Description: "Candidates drawn at random to select each parent; the fittest of them is the parent. Larger tournaments select harder.",
+		Range:       "> 0 (default 3)",
+	},
+	{
+		Name: "ga.stallLimit", File: "annealing", Type: "int",
+		Description: "Generations without improvement of the best candidate after which the search for an area stops.",
+		Range:       "> 0 (default 100)",
+	},
+	{
+		Name: "constraints.file", File: "population", Type: "path",
+		Description:  "CSV of area constraints: area ID, total population, then one column per constraint variable. A .gz or .zst suffix is decompressed while reading.",
+		Range:        "existing CSV file, optionally compressed",
+		Interactions: "Its variable columns must match the microdata header.",
+	},
+	{
+		Name: "microdata.file", File: "population", Type: "path",
+		Description:  "CSV of microdata records: record ID, then one column per constraint variable. A .gz or .zst suffix is decompressed while reading.",
+		Range:        "existing CSV file, optionally compressed",
+		Interactions: "Its variable columns must match the constraints header.",
+	},
+	{
+		Name: "microdata.attributesFile", File: "population", Type: "path",
+		Description:  "Optional CSV of further attributes per microdata record: microdata ID, then any categorical or continuous columns, copied as written into output.individualsFile.",
+		Range:        "existing CSV file, optionally compressed (.gz or .zst)",
+		Interactions: "Only read for output.individualsFile. Records may carry attributes that are not constraint variables.",
+	},
+	{
+		Name: "microdata.weightColumn", File: "population", Type: "string",
+		Description:  "Microdata column of survey design weights. Records are drawn in proportion to their weights for the initial populations, the replacement moves and the genetic algorithm's candidates, and IPF starts from the weights, so the synthetic population follows the survey design rather than treating all records as equally likely. The column is not a constraint variable.",
+		Range:        "a microdata column with positive weights (empty draws all records alike)",
+		Interactions: "Not supported with households. Strongly skewed weights make replacement draws slower, as they are drawn by rejection.",
+	},
+	{
+		Name: "microdata.regionColumn", File: "population", Type: "string",
+		Description:  "Microdata column of numeric region codes (e.g. gor_dv). Every area draws its individuals from the records of its own region only (see microdata.regionLookupFile), so records of one country or region are not cloned into another's areas, and replacements are drawn from the smaller regional pool. The column is not a constraint variable.",
+		Range:        "a numeric microdata column (empty draws from all records)",
+		Interactions: "Requires microdata.regionLookupFile. Every area must have a region with records. Warm-start individuals from other regions are drawn afresh. Not supported with households.",
+	},
+	{
+		Name: "microdata.regionLookupFile", File: "population", Type: "path",
+		Description:  "CSV of the region of every area (header row, then area ID and region code, as in microdata.regionColumn).",
+		Range:        "existing CSV file, optionally compressed",
+		Interactions: "Requires microdata.regionColumn. Areas added by watch.dir without a region cannot be synthesized.",
+	},
+	{
+		Name: "constraints.format", File: "population", Type: "string",
+		Description:  "Format of constraints.file. Parquet files hold the same columns as the CSV, area ID first; a flat schema with PLAIN or dictionary encoding, uncompressed, snappy or gzip pages and no nulls.",
+		Range:        "csv | parquet (default parquet for a .parquet extension, else csv)",
+		Interactions: "Not used for the household-level and person-level inputs of households runs, which are CSV.",
+	},
+	{
+		Name: "microdata.format", File: "population", Type: "string",
+		Description: "Format of microdata.file, as for constraints.format.",
+		Range:       "csv | parquet (default parquet for a .parquet extension, else csv)",
+	},
+	{
+		Name: "constraints.dsn", File: "population", Type: "string",
+		Description:  "PostgreSQL connection read instead of constraints.file, as a URL (postgres://user@host:5432/census?sslmode=require) or key=value pairs (host=db dbname=census). Missing settings come from PGHOST, PGPORT, PGUSER, PGPASSWORD, PGDATABASE and PGSSLMODE; keep the password in PGPASSWORD rather than in the config, which is copied into the manifest and bundle.",
+		Range:        "DSN; sslmode disable | prefer | require (default prefer, the certificate is not verified); cleartext, MD5 or SCRAM-SHA-256 authentication (empty reads constraints.file)",
+		Interactions: "Needs constraints.query; excludes constraints.file. Not supported with households. Query results are not cached between watch or serve runs, and not hashed into the manifest.",
+	},
+	{
+		Name: "constraints.query", File: "population", Type: "string",
+		Description:  "SQL query returning the columns of the constraints CSV: area ID, total population, then one numeric column per constraint variable, named as the variables. Cast decimal columns if needed; NULL values are rejected.",
+		Range:        "SELECT statement",
+		Interactions: "Used with constraints.dsn.",
+	},
+	{
+		Name: "constraints.tables", File: "population", Type: "list of {name, file, format, distance, weight, cells}",
+		Description:  "Several constraint tables read instead of constraints.file, e.g. cross-tabulations of age by sex and of tenure by household size, each with the layout of constraints.file. They are joined by area ID, and the fitness is the weighted sum of the table distances (each table a variable group). cells derives the joint cells missing from the microdata from its columns, e.g. {\"male_age0_15\": \"male * age0_15\"}.",
+		Range:        "tables covering the same areas with distinct cell names; weight >= 0 (default 1), distance as in the annealing config (default its distance)",
+		Interactions: "Excludes constraints.file and constraints.dsn. The first table's totals are the area populations. Microdata columns no table constrains are not fitted. The annealing variableGroups take precedence over the table groups for their variables. Not supported with households or watch.dir.",
+	},
+	{
+		Name: "microdata.dsn", File: "population", Type: "string",
+		Description: "PostgreSQL connection read instead of microdata.file, as for constraints.dsn.",
+		Range:       "DSN (empty reads microdata.file)",
+	},
+	{
+		Name: "microdata.query", File: "population", Type: "string",
+		Description:  "SQL query returning the columns of the microdata CSV: record ID, then one numeric column per constraint variable.",
+		Range:        "SELECT statement",
+		Interactions: "Used with microdata.dsn; compacted after loading when microdata.compact is set.",
+	},
+	{
+		Name: "microdata.compact", File: "population", Type: "bool",
+		Description:  "Memory-efficient microdata loading for very large files: every distinct row of values is stored once in flat blocks and shared by the records holding it, so each record costs little more than its ID. Selection and outputs are unchanged. Values stay float64, as the annealer sums them in its inner loop.",
+		Range:        "true | false (default false)",
+		Interactions: "Parquet microdata are compacted once loaded, so the peak memory of the load is not reduced. Household microdata are not compacted.",
+	},
+	{
+		Name: "output.file", File: "population", Type: "path",
+		Description:  "CSV mapping each area ID to the microdata IDs of its synthetic population. A .gz or .zst suffix compresses it, as it does every output but the bundle and the SQLite database.",
+		Range:        "writable path",
+		Interactions: "Not written when output.aggregateOnly is set. Compressed outputs cannot be resumed or appended to; Parquet outputs cannot be compressed.",
+	},
+	{
+		Name: "output.layout", File: "population", Type: "string",
+		Description:  "Rows of output.file. ids writes one row per synthetic individual (area_id, microdata_id), repeating a record once per clone; counts writes one row per area and selected record with how many times it was selected (area_id, microdata_id, count), far smaller for national runs. The convert-ids subcommand converts a CSV between the two.",
+		Range:        "ids | counts (default ids)",
+		Interactions: "Applies to CSV and Parquet outputs. Holdout evaluation and output.append read either layout; appending needs the layout of the existing file. The agents and MATSim outputs still list every individual.",
+	},
+	{
+		Name: "output.format", File: "population", Type: "string",
+		Description:  "Format of output.file. Parquet outputs have string columns area_id and microdata_id and are written uncompressed.",
+		Range:        "csv | parquet (default parquet for a .parquet extension, else csv)",
+		Interactions: "Parquet outputs cannot be resumed, appended to or used for holdout evaluation.",
+	},
+	{
+		Name: "output.aggregateOnly", File: "population", Type: "bool",
+		Description:  "Skip the ID mapping output and only write the aggregate tables.",
+		Range:        "true | false (default false)",
+		Interactions: "Incompatible with holdout evaluation.",
+	},
+	{
+		Name: "output.encrypt", File: "population", Type: "bool",
+		Description:  "Encrypt the outputs with AES-GCM using the hex key in the GOSYNTHPOP_KEY environment variable. Use the decrypt subcommand to read them.",
+		Range:        "true | false (default false)",
+		Interactions: "Incompatible with holdout evaluation.",
+	},
+	{
+		Name: "output.retries", File: "population", Type: "int",
+		Description:  "Number of times a failed output create or write is retried. An area whose ID or validation rows still cannot be written is dropped from both files and listed in output.failedAreasFile, the other areas are synthesized, and the run then fails.",
+		Range:        ">= 0 (default 0)",
+		Interactions: "Delay between attempts starts at output.retryBackoffMs and doubles. Compressed or encrypted outputs, and the other outputs, cannot drop an area: their failures stop the run.",
+	},
+	{
+		Name: "output.retryBackoffMs", File: "population", Type: "int",
+		Description:  "Delay before the first retry in milliseconds.",
+		Range:        "> 0 (default 500)",
+		Interactions: "Only used when output.retries > 0.",
+	},
+	{
+		Name: "output.agentsFile", File: "population", Type: "path",
+		Description:  "Optional agents CSV with one row per synthetic individual: agent ID, area ID, microdata ID and the microdata attributes.",
+		Range:        "writable path (empty disables)",
+		Interactions: "Needs the individual assignments, so it cannot be combined with output.aggregateOnly.",
+	},
+	{
+		Name: "output.matsimFile", File: "population", Type: "path",
+		Description:  "Optional MATSim population XML (population_v6) with one person per synthetic individual, carrying the area and microdata attributes as person attributes.",
+		Range:        "writable path (empty disables)",
+		Interactions: "Needs the individual assignments, so it cannot be combined with output.aggregateOnly.",
+	},
+	{
+		Name: "output.individualsFile", File: "population", Type: "path",
+		Description:  "Optional person-level CSV with one row per synthetic individual: person ID (area and position), area ID, microdata ID and every column of microdata.attributesFile, so the population needs no join in R or Python.",
+		Range:        "writable path (empty disables)",
+		Interactions: "Needs microdata.attributesFile, with a row for every microdata record, and the individual assignments, so it cannot be combined with output.aggregateOnly.",
+	},
+	{
+		Name: "output.geojsonFile", File: "population", Type: "path",
+		Description:  "Optional GeoJSON layer of the boundaries with per-area fitness, population, best_iteration and <variable>_error (synthetic - constraint) properties, for spatial QA in QGIS.",
+		Range:        "writable path (empty disables)",
+		Interactions: "Requires boundaries.file and boundaries.idProperty.",
+	},
+	{
+		Name: "output.inclusionFile", File: "population", Type: "path",
+		Description:  "Optional CSV of per-area record inclusion probabilities: for every selected microdata record its count, inclusion_probability (count / area population) and the area's valid donor pool_size, for design-based variance estimation.",
+		Range:        "writable path (empty disables)",
+		Interactions: "Needs the individual assignments, so it cannot be combined with output.aggregateOnly.",
+	},
+	{
+		Name: "output.append", File: "population", Type: "bool",
+		Description:  "Synthesize only the constraint areas missing from the existing validate.file and output.file, and append their rows. Use it when areas are added to the constraints file. Also set by `run -append`.",
+		Range:        "true | false (default false)",
+		Interactions: "The existing outputs must have the current header and no duplicate areas. Not supported with output.encrypt, the agents, MATSim, GeoJSON, inclusion, trace, validation and interaction outputs, or checkpoint.resume.",
+	},
+	{
+		Name: "output.weightsFile", File: "population", Type: "path",
+		Description:  "Optional CSV of fractional IPF weights: area ID, microdata ID and weight for every record of the area's valid pool.",
+		Range:        "writable path (empty disables)",
+		Interactions: "Requires algorithm ipf with ipf.fractional.",
+	},
+	{
+		Name: "output.failedAreasFile", File: "population", Type: "path",
+		Description:  "CSV (area_id, reason) of areas that could not be synthesized, e.g. because every microdata record breaks one of their zero constraints, or written once output.retries were exhausted. Such areas are skipped and the run continues.",
+		Range:        "writable path (default failed_areas.csv next to validate.file)",
+		Interactions: "Only created when an area fails. Failed areas are not checkpointed, so resume and append retry them.",
+	},
+	{
+		Name: "output.traceFile", File: "population", Type: "path",
+		Description:  "CSV of the convergence of every area (area_id, iteration, temperature, fitness, accepted), sampled every output.traceInterval iterations and at the last iteration, to diagnose convergence after a run.",
+		Range:        "writable path (empty disables)",
+		Interactions: "IPF areas have no rows; tabu rows have no temperature and for ga the iteration is the generation, the fitness that of the best candidate and accepted whether it improved. Not supported with checkpoint.resume or output.append.",
+	},
+	{
+		Name: "output.rename", File: "population", Type: "object (variable -> name)",
+		Description:  "Column names written for constraint variables, e.g. {\"age_0_15\": \"SCT-0001\"}, so downstream systems get the names they expect. Applies to validate.file (CSV and Parquet), validate.errorsFile, validate.interactionsFile, the agents and MATSim exports and the GeoJSON error properties. Variables not listed keep their names.",
+		Range:        "variables of the constraints header; output names must be unique and not geography_code or best_iteration",
+		Interactions: "Config entries that select variables (boundaries.variables, validate.interactions, variableGroups) keep using the input names. With output.append the existing validate file must already have the renamed header.",
+	},
+	{
+		Name: "output.bundleFile", File: "population", Type: "path",
+		Description:  "Archive written when the run completes, packaging its deliverables for sharing and archiving: every output that was written, the validation and diagnostic reports, the failed areas, the run manifest, the worker logs and copies of the population and annealing configs, in a directory named after the run.",
+		Range:        "writable path ending in .zip, .tar.gz or .tgz (empty disables)",
+		Interactions: "Files are named by their path relative to the bundle's directory. The status file and checkpoint are left out. Encrypted outputs stay encrypted in the bundle. Not written when the run fails, including a failed qualityGate.",
+	},
+	{
+		Name: "output.manifestFile", File: "population", Type: "path",
+		Description:  "JSON manifest written after every run for auditing and reproduction: the resolved population and annealing configs with their SHA-256, the SHA-256 and size of every input file, the master seed, the build (Go version, module version, VCS revision), the wall-clock time, the quality gate verdict and a fitness summary (mean, median, max and worst area).",
+		Range:        "writable path (default run_manifest.json next to validate.file)",
+		Interactions: "To reproduce a run without a configured seed, set useRandomSeed to yes and randomSeed to the manifest's seed. With checkpoint.resume or output.append the fitness summary covers the current invocation only.",
+	},
+	{
+		Name: "output.traceInterval", File: "population", Type: "int",
+		Description:  "Sampling interval of output.traceFile in iterations.",
+		Range:        "> 0 (default 100)",
+		Interactions: "Small intervals on long schedules make large files: rows per area are about maxIterations / traceInterval.",
+	},
+	{
+		Name: "output.diagnosticsFile", File: "population", Type: "path",
+		Description:  "Optional CSV of the move statistics of the annealing per area, for tuning initialTemp and coolingRate: proposed moves, accepted ones and the accepted_worse among them, reverted ones (turned down by the acceptance criterion), the acceptance_rate, the initial and final temperature, reheats and best_iteration. Few accepted_worse moves mean the search starts too cold to explore; many accepted worse moves at a high final temperature mean it cools too slowly for maxIterations.",
+		Range:        "writable path (empty disables)",
+		Interactions: "Areas synthesized by ipf, ga or tabu are left out. With tempering the counts are those of the coldest chain, with restarts those of the best restart. Not supported with checkpoint.resume or output.append.",
+	},
+	{
+		Name: "output.sqliteFile", File: "population", Type: "path",
+		Description:  "Optional SQLite database of the results, queried directly instead of parsing large CSVs: area_population(area_id, microdata_id, count) holds how many times each record was selected for each area, area_validation(area_id, variable, synthetic, constraint) the synthetic and constraint totals of every variable. \"constraint\" is an SQL keyword and must be quoted in queries.",
+		Range:        "writable path, e.g. results.db (empty disables)",
+		Interactions: "Written alongside the output and validate files; set output.aggregateOnly to skip the ID mapping CSV. The tables have no indexes: create one on area_id for repeated per-area queries. Variables are named as in output.rename. Cannot be combined with output.encrypt, checkpoint.resume or output.append.",
+	},
+	{
+		Name: "output.postgres.dsn", File: "population", Type: "string",
+		Description:  "PostgreSQL database the results are copied into with COPY, in the tables area_population(run_name, area_id, microdata_id, count) and area_validation(run_name, area_id, variable, synthetic, constraint), created if missing. Rows are tagged with the run name, so runs can share the tables. The DSN is written as for constraints.dsn.",
+		Range:        "DSN (empty disables)",
+		Interactions: "Cannot be combined with checkpoint.resume or output.append. Rows of a run that fails are kept: delete them by run_name before running it again under the same name.",
+	},
+	{
+		Name: "output.postgres.schema", File: "population", Type: "string",
+		Description: "Schema of the output.postgres tables, created if missing.",
+		Range:       "schema name (default public)",
+	},
+	{
+		Name: "output.postgres.batchSize", File: "population", Type: "int",
+		Description:  "Rows buffered before each COPY into PostgreSQL. Larger batches mean fewer round trips but more memory.",
+		Range:        ">= 0 (default 10000)",
+		Interactions: "Used with output.postgres.dsn.",
+	},
+	{
+		Name: "boundaries.file", File: "population", Type: "path",
+		Description:  "GeoJSON FeatureCollection of area boundaries used for the spatial outputs.",
+		Range:        "existing GeoJSON file",
+		Interactions: "Features are matched to areas through boundaries.idProperty.",
+	},
+	{
+		Name: "boundaries.idProperty", File: "population", Type: "string",
+		Description: "Name of the feature property holding the area code used in the constraints file.",
+		Range:       "property name present on the features",
+	},
+	{
+		Name: "boundaries.variables", File: "population", Type: "list of strings",
+		Description: "Constraint variables whose errors are joined to the boundaries.",
+		Range:       "names from the constraints header (default all)",
+	},
+	{
+		Name: "adjacency.file", File: "population", Type: "path",
+		Description:  "CSV of neighbouring area pairs (header row, then area and neighbour codes). After the run, Moran's I of the per-area fitness over these pairs, with row-standardised weights, reports whether poor fit clusters spatially.",
+		Range:        "existing CSV file (empty disables)",
+		Interactions: "Covers the areas synthesized in this run only, so resumed and appended runs leave out the earlier areas; areas without synthesized neighbours are left out.",
+	},
+	{
+		Name: "adjacency.reportFile", File: "population", Type: "path",
+		Description:  "JSON of the Moran's I diagnostic: I, its expectation, variance under normality, z-score, p-value and whether poor fit clusters (p < 0.05).",
+		Range:        "writable path (empty prints the diagnostic only)",
+		Interactions: "Requires adjacency.file.",
+	},
+	{
+		Name: "parent.constraintsFile", File: "population", Type: "path",
+		Description:  "Constraints of the parent geography the areas nest in (e.g. MSOAs over LSOAs), laid out like constraints.file with parent IDs. Variables only the parents constrain are apportioned to their areas by population share and fitted as further area constraints; after the run the areas are summed per parent and compared with these constraints.",
+		Range:        "existing CSV or Parquet file (empty disables)",
+		Interactions: "Requires parent.lookupFile and the parent-only variables in the microdata. Variables constrained at both levels keep the area values. Not supported with households.",
+	},
+	{
+		Name: "parent.lookupFile", File: "population", Type: "path",
+		Description:  "CSV of the parent of every area (header row, then area and parent IDs). Every area of the constraints must be listed.",
+		Range:        "existing CSV file",
+		Interactions: "Requires parent.constraintsFile.",
+	},
+	{
+		Name: "parent.validateFile", File: "population", Type: "path",
+		Description:  "Parent validation CSV with one row per parent and variable: parent_id, the number of areas summed, variable, synthetic, constraint, absolute_error and percentage_error (empty for a zero constraint). The parent TAE and SAE are printed with or without it.",
+		Range:        "writable path (empty prints the fit only)",
+		Interactions: "Covers the areas synthesized in this run only, so resumed and appended runs cannot use it.",
+	},
+	{
+		Name: "validate.file", File: "population", Type: "path",
+		Description: "CSV of the synthetic totals per area and variable, with the iteration at which the best solution was found.",
+		Range:       "writable path",
+	},
+	{
+		Name: "validate.format", File: "population", Type: "string",
+		Description:  "Format of validate.file. Parquet outputs have a string geography_code, a double per variable and an int64 best_iteration.",
+		Range:        "csv | parquet (default parquet for a .parquet extension, else csv)",
+		Interactions: "Parquet outputs cannot be resumed or appended to.",
+	},
+	{
+		Name: "validate.errorsFile", File: "population", Type: "path",
+		Description:  "Validation CSV with one row per area and variable: area_id, variable, synthetic, constraint, absolute_error, percentage_error (empty for a zero constraint), and synthetic_share and constraint_share of the variable's subpopulation (see denominators) or of the area population (empty for an empty subpopulation).",
+		Range:        "writable path (empty disables)",
+		Interactions: "Not supported with checkpoint.resume or output.append.",
+	},
+	{
+		Name: "validate.summaryFile", File: "population", Type: "path",
+		Description:  "Validation CSV with one row per area: population, TAE (total absolute error over the variables), SAE (TAE / population), fitness, best_iteration, the stagnation window_size and reheats of the annealing search (empty for areas synthesized by another algorithm and for summaries regenerated by report), and for restarted areas the number of restarts, the fitness_worst of them and the fitness_sd across them, showing how stable the solution is, and the status of the area: \"synthesized\", or \"empty\" for an area with a zero total (e.g. a non-residential zone), which gets no individuals and no search.",
+		Range:        "writable path (empty disables)",
+		Interactions: "TAE and SAE use the raw errors, so with roundingBase an area can have fitness 0 and a positive TAE. Not supported with checkpoint.resume or output.append.",
+	},
+	{
+		Name: "validate.interactions", File: "population", Type: "list of [variable, variable]",
+		Description:  "Pairs of variables whose two-way interaction is checked against the microdata, e.g. [[\"male\", \"employed\"]], to quantify how much association the selection keeps when only one-way margins are constrained.",
+		Range:        "pairs of variables from the header",
+		Interactions: "Requires validate.interactionsFile and the individual assignments (not output.aggregateOnly).",
+	},
+	{
+		Name: "validate.interactionsFile", File: "population", Type: "path",
+		Description:  "CSV with one row per area and pair, plus rows with area_id ALL over every area: the joint mean E[ab] (for 0/1 variables the share holding both) and the correlation of the pair in the synthetic population and in the microdata, and the absolute correlation difference. The correlation does not depend on the margins an area was fitted to, so a small difference means the association was preserved.",
+		Range:        "writable path (empty disables)",
+		Interactions: "Correlations of a constant variable are left empty. Not supported with checkpoint.resume or output.append.",
+	},
+	{
+		Name: "status.file", File: "population", Type: "path",
+		Description:  "Heartbeat JSON (timestamp, state, areasDone, areasTotal, pid) rewritten atomically while the run progresses, so watchdogs can tell a hung run from a slow one.",
+		Range:        "writable path (empty disables)",
+		Interactions: "Rewritten every status.intervalSeconds; the final state is completed or failed.",
+	},
+	{
+		Name: "status.intervalSeconds", File: "population", Type: "int",
+		Description: "Seconds between status file updates.",
+		Range:       "> 0 (default 10)",
+	},
+	{
+		Name: "status.metricsAddr", File: "population", Type: "string",
+		Description:  "Address serving a Prometheus /metrics endpoint while the run progresses, for Grafana dashboards of long HPC or cloud runs: areas to synthesize, completed and failed, the mean and 50/90/99th percentile fitness of the completed areas, search iterations and iterations per second, heap and process memory, and the busy seconds of every worker, each labelled with the run name.",
+		Range:        "host:port, e.g. \":9100\" (empty disables)",
+		Interactions: "Independent of status.file. The endpoint closes when the run ends, so scrape it more often than the last areas take; the run fails at start if the address cannot be listened on.",
+	},
+	{
+		Name: "watch.dir", File: "population", Type: "path",
+		Description:  "Directory of rolling constraint deliveries: every CSV or Parquet constraint file that appears there while the run progresses is read once and its new areas are added to the running job. The run ends once a file named DONE appears in the directory.",
+		Range:        "existing directory (empty disables)",
+		Interactions: "Delivered files are matched to the run's variables by name after the derived columns are added; areas already in the run are skipped and unreadable files are reported and skipped. Write deliveries elsewhere and move them in, so half-written files are never read. Not supported with household synthesis. With checkpoint.resume the delivered areas already completed are skipped.",
+	},
+	{
+		Name: "watch.intervalSeconds", File: "population", Type: "int",
+		Description: "Seconds between listings of watch.dir.",
+		Range:       ">= 0 (default 5)",
+	},
+	{
+		Name: "holdout.fraction", File: "population", Type: "float",
+		Description:  "Share of microdata records withheld from synthesis to evaluate how well their joint distribution is reproduced.",
+		Range:        "0 (disabled) or 0 < fraction < 1",
+		Interactions: "Requires the plain ID mapping output and holdout.file.",
+	},
+	{
+		Name: "holdout.file", File: "population", Type: "path",
+		Description:  "CSV of held-out versus synthetic shares for every joint attribute pattern.",
+		Range:        "writable path",
+		Interactions: "Only written when holdout.fraction > 0.",
+	},
+	{
+		Name: "households.file", File: "population", Type: "path",
+		Description:  "Household microdata CSV (household ID, household variables). Setting it switches to household-person joint synthesis: households are selected so that household and person constraints are fitted together, and constraints.file and microdata.file hold the person level.",
+		Range:        "existing CSV file (empty disables)",
+		Interactions: "Every household is fitted with its own values, the sums over its persons and its person count (the persons variable, matched to the person total of the area). Requires households.constraintsFile and households.linkColumn; headers must match exactly at each level.",
+	},
+	{
+		Name: "households.constraintsFile", File: "population", Type: "path",
+		Description:  "Household constraints CSV: area ID, number of households, then one column per household variable.",
+		Range:        "existing CSV file with the same areas as constraints.file",
+		Interactions: "Its total column sets the number of households synthesized per area.",
+	},
+	{
+		Name: "households.linkColumn", File: "population", Type: "string",
+		Description: "Column of microdata.file holding the ID of each person's household. It is not a variable and is not fitted.",
+		Range:       "column name of microdata.file",
+	},
+	{
+		Name: "households.personsOutputFile", File: "population", Type: "path",
+		Description:  "Optional CSV of the persons of the synthetic households: area ID, synthetic household ID (area code and position), microdata household ID and person ID.",
+		Range:        "writable path (empty disables)",
+		Interactions: "Rows of one synthetic household match the household's row in output.file. Cannot be combined with output.aggregateOnly.",
+	},
+	{
+		Name: "households.variables", File: "population", Type: "list of strings",
+		Description:  "Person microdata columns that hold household attributes, such as tenure, repeated on every person of the household. Used instead of households.file for person-level microdata: the households are aggregated from the persons grouped by households.linkColumn, taking these columns as household variables, and the remaining columns stay person variables.",
+		Range:        "columns of microdata.file, each the same for all persons of a household",
+		Interactions: "Mutually exclusive with households.file. households.constraintsFile must have these variables, in this order; constraints.file the remaining person variables.",
+	},
+	{
+		Name: "headerMode", File: "population", Type: "string",
+		Description:  "How the constraint and microdata variables are matched. \"exact\" requires the same variables, matched by name in any order (the microdata columns are reordered to the constraint order) and reports any missing or extra columns; \"intersection\" uses only the variables present in both and reports the ignored columns, e.g. descriptive microdata columns that are not constrained.",
+		Range:        "exact | intersection (default exact)",
+		Interactions: "Derived columns are computed after the headers are matched, from the shared variables. Household-person runs match each level by name as in exact mode.",
+	},
+	{
+		Name: "derived", File: "population", Type: "object of name: expression",
+		Description:  "Derived columns computed at load for both constraints and microdata, e.g. {\"econ_active\": \"employed + unemployed\"}. Expressions use column names, numbers, + - * /, parentheses, min(a, b) and max(a, b).",
+		Range:        "new column names; expressions over the input columns (not over other derived columns)",
+		Interactions: "Derived columns are appended to the header in name order and fitted like any other variable, so they also appear in the outputs and can be used in variableGroups.",
+	},
+	{
+		Name: "loadTimeoutSeconds", File: "population", Type: "int",
+		Description:  "Abandon the run with an error when loading the constraints and microdata takes longer. Long loads report rows read and MB/s every 2 seconds either way.",
+		Range:        ">= 0 seconds (default 0 waits indefinitely)",
+		Interactions: "Inputs already loaded by an earlier config of the same batch are reused without a timeout; household inputs are not covered.",
+	},
+	{
+		Name: "checkpoint.file", File: "population", Type: "path",
+		Description:  "JSONL file with one line per completed area (area ID and the sizes of output.file and validate.file after its rows), so a crashed run can be resumed.",
+		Range:        "writable path (empty disables)",
+		Interactions: "Not supported with output.encrypt or the agents, MATSim, GeoJSON, inclusion, trace, validation and interaction outputs. Flushes the outputs after every area.",
+	},
+	{
+		Name: "checkpoint.resume", File: "population", Type: "bool",
+		Description:  "Skip the areas recorded in checkpoint.file and append to the existing outputs, first truncating them to the last recorded area. Also set by `run -resume`.",
+		Range:        "true | false (default false)",
+		Interactions: "Requires checkpoint.file; without an existing checkpoint the run starts from the beginning.",
+	},
+	{
+		Name: "seedPopulationFile", File: "population", Type: "path",
+		Description:  "ID mapping output of a previous run, in either layout, to warm-start from: every area it lists starts from its previous population instead of a random one, so recalibrated constraints or small config changes need far fewer iterations. Individuals invalid for the new constraints, or whose records are no longer in the microdata, are replaced by random draws; a population larger than the new total is cut short.",
+		Range:        "existing CSV file, optionally compressed (empty starts from random populations)",
+		Interactions: "Only the first attempt at an area is seeded, restarts draw their own populations; parallel tempering seeds its first chain. IPF ignores it. May be the output.file of this run, which is read before it is overwritten.",
+	},
+	{
+		Name: "qualityGate.fitnessThreshold", File: "population", Type: "float",
+		Description:  "Fitness above which an area counts as poor for the post-run quality gate; areas that could not be synthesized are poor too. When the share of poor areas exceeds qualityGate.maxPoorShare the run fails: the command exits non-zero and the status file records the failed state.",
+		Range:        ">= 0 (0 disables the share check)",
+		Interactions: "The outputs are still written, for inspection. With checkpoint.resume or output.append only the areas of the current invocation are judged.",
+	},
+	{
+		Name: "qualityGate.maxPoorShare", File: "population", Type: "float",
+		Description:  "Largest share of poor areas that passes the quality gate, e.g. 0.05 for 5%.",
+		Range:        "0 to 1 (default 0: no poor area allowed)",
+		Interactions: "Requires qualityGate.fitnessThreshold.",
+	},
+	{
+		Name: "qualityGate.hardCap", File: "population", Type: "float",
+		Description:  "Fitness no area may exceed: a single area above it fails the run whatever the share of poor areas.",
+		Range:        ">= 0 (0 disables)",
+		Interactions: "Fitness is on the scale of the configured distance, so thresholds do not carry over between metrics.",
+	},
+	{
+		Name: "notifications.webhook", File: "population", Type: "URL",
+		Description:  "Chat webhook (Slack, Mattermost, Teams workflow) sent a POST of {\"text\": message} when the run finishes or fails. The message gives the outcome and runtime, the number of areas synthesized and failed, the worst fitness and its area, and notifications.reportURL.",
+		Range:        "http or https URL (empty disables)",
+		Interactions: "A failed notification is reported on the console and does not fail the run. The URL is recorded in the run manifest with the rest of the config. Runs failing before their inputs are synthesized, e.g. on an unwritable output, are announced too; config and input errors found before the run starts are not.",
+	},
+	{
+		Name: "notifications.reportURL", File: "population", Type: "URL",
+		Description:  "Link to the run's results included in the notifications, e.g. a shared directory or dashboard. May contain {run}.",
+		Range:        "any text (empty leaves the link out)",
+		Interactions: "Only used with notifications.webhook or notifications.smtp.",
+	},
+	{
+		Name: "notifications.smtp.host", File: "population", Type: "string",
+		Description:  "Mail server the notification is emailed through when the run finishes or fails, with the same message as notifications.webhook. The connection is upgraded with STARTTLS when the server offers it.",
+		Range:        "host name or address (empty disables email)",
+		Interactions: "Needs notifications.smtp.from and notifications.smtp.to. Can be combined with notifications.webhook. A failed email is reported on the console and does not fail the run.",
+	},
+	{
+		Name: "notifications.smtp.port", File: "population", Type: "int",
+		Description: "Port of the mail server.",
+		Range:       "1-65535, 0 for the default 587",
+	},
+	{
+		Name: "notifications.smtp.from", File: "population", Type: "string",
+		Description: "Sender address of the notification email.",
+		Range:       "email address, required with notifications.smtp.host",
+	},
+	{
+		Name: "notifications.smtp.to", File: "population", Type: "[string]",
+		Description: "Recipients of the notification email.",
+		Range:       "at least one email address, required with notifications.smtp.host",
+	},
+	{
+		Name: "notifications.smtp.username", File: "population", Type: "string",
+		Description:  "User the mail server is logged in as; the password is read from GOSYNTHPOP_SMTP_PASSWORD.",
+		Range:        "any text (empty sends without authentication)",
+		Interactions: "The password is never read from the config, so it stays out of the run manifest.",
+	},
+	{
+		Name: "debug.workerLogs", File: "population", Type: "bool",
+		Description:  "Write one log file per worker (worker-03.log) with the start and finish of every area it synthesized, its fitness, annealing accept/reject rates and warnings such as failed areas or pools smaller than the population. Each file is written by one worker only, so concurrency issues can be followed without interleaved console output.",
+		Range:        "true | false (default false)",
+		Interactions: "Lines carry the seconds since the run started; with a fixed seed and one worker the rest of each line is reproducible. IPF areas have no accept/reject rates.",
+	},
+	{
+		Name: "debug.dir", File: "population", Type: "path",
+		Description:  "Directory of the worker logs, created if missing. May contain {run}.",
+		Range:        "writable directory (default logs)",
+		Interactions: "Requires debug.workerLogs. Existing worker logs in the directory are overwritten.",
+	},
+	{
+		Name: "workers", File: "population", Type: "int",
+		Description:  "Number of areas synthesized in parallel. Also set by `run -workers`.",
+		Range:        ">= 0 (default 0: the number of CPUs)",
+		Interactions: "Never more workers than areas. Fewer workers than CPUs leave room on shared servers; more can help when writing the outputs is slow. Every worker holds its own scratch buffers, and with debug.workerLogs its own log file; the effective count and a memory estimate are printed when the run starts.",
+	},
+	{
+		Name: "maxProcs", File: "population", Type: "int",
+		Description:  "GOMAXPROCS of the run: the number of CPUs that execute Go code at once, restored when the run ends. Also set by `run -max-procs`. Applied by the `run` and `benchmark` commands and the classic invocation only: GOMAXPROCS is process-wide, so `serve` and the shared libraries reject it and library callers set it themselves.",
+		Range:        ">= 0 (default 0: unchanged, normally the number of CPUs)",
+		Interactions: "Caps CPU use independently of workers: more workers than maxProcs share the CPUs.",
+	},
+	{
+		Name: "scheduling", File: "population", Type: "string",
+		Description:  "Order the areas are handed to the workers in: `file` keeps the order of the constraints file, `largest` starts the most populous areas first so a large area picked up last does not run alone long after the other workers have finished.",
+		Range:        "file, largest (default file)",
+		Interactions: "Changes no population, as every area's random numbers depend only on the seed and its area ID, but the outputs are written in a different order. Areas delivered to watch.dir are handed out as they arrive. The worker busy time report printed at the end of a run shows whether the tail dominates.",
+	},
+	{
+		Name: "runName", File: "population", Type: "string",
+		Description:  "Short name of the run, shown in the console and the status file and substituted for {run} in every output path, e.g. runs/{run}/synthetic.csv. Missing directories are created.",
+		Range:        "any string usable in a path (default a generated name such as misty-falcon-42)",
+		Interactions: "Generated names change every run, so set a fixed runName when checkpoint.resume or output.append must find the outputs of an earlier run.",
+	},
+}
+
+// printParameterDoc prints one parameter description
+func printParameterDoc(doc parameterDoc) {
+	synthpop.Printf("%s (%s, %s config)\n", doc.Name, doc.Type, doc.File)
+	synthpop.Printf("  %s\n", doc.Description)
+	if doc.Range != "" {
+		synthpop.Printf("  Valid range:  %s\n", doc.Range)
+	}
+	if doc.Interactions != "" {
+		synthpop.Printf("  Interactions: %s\n", doc.Interactions)
+	}
+}
+
+// explainCommand implements `explain <parameter>` and `explain --all`
+func explainCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: explain <parameter> | explain --all")
+	}
+
+	if args[0] == "--all" || args[0] == "-all" {
+		for i, doc := range parameterDocs {
+			if i > 0 {
+				synthpop.Println()
+			}
+			printParameterDoc(doc)
+		}
+		return nil
+	}
+
+	for _, doc := range parameterDocs {
+		if strings.EqualFold(doc.Name, args[0]) {
+			printParameterDoc(doc)
+			return nil
+		}
+	}
+
+	names := make([]string, len(parameterDocs))
+	for i, doc := range parameterDocs {
+		names[i] = doc.Name
+	}
+	return fmt.Errorf("unknown parameter '%s'. Known parameters: %s", args[0], strings.Join(names, ", "))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"simulatedAnnealing/pkg/synthpop"
+)
+
+// configLeaves returns the dotted JSON names of the leaf fields of a config struct:
+// nested structs are walked, embedded ones as part of their parent as encoding/json
+// does, and every other field (slices and maps of structs too) is a parameter of its
+// own
+func configLeaves(t reflect.Type, prefix string) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" && field.Anonymous && field.Type.Kind() == reflect.Struct {
+			names = append(names, configLeaves(field.Type, prefix)...)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if field.Type.Kind() == reflect.Struct {
+			names = append(names, configLeaves(field.Type, prefix+name+".")...)
+			continue
+		}
+		names = append(names, prefix+name)
+	}
+	return names
+}
+
+// TestParameterDocs fails when a config parameter has no explain entry, or an entry
+// names a parameter the config does not have
+func TestParameterDocs(t *testing.T) {
+	documented := make(map[string]string)
+	for _, doc := range parameterDocs {
+		if _, ok := documented[doc.Name]; ok {
+			t.Errorf("%s is documented twice", doc.Name)
+		}
+		documented[doc.Name] = doc.File
+		if doc.Description == "" || doc.Type == "" {
+			t.Errorf("%s has no type or description", doc.Name)
+		}
+	}
+
+	leaves := make(map[string]bool)
+	for _, config := range []struct {
+		file string
+		t    reflect.Type
+	}{
+		{"annealing", reflect.TypeOf(synthpop.AnnealingConfig{})},
+		{"population", reflect.TypeOf(synthpop.PopulationConfig{})},
+	} {
+		for _, name := range configLeaves(config.t, "") {
+			leaves[name] = true
+			file, ok := documented[name]
+			switch {
+			case !ok:
+				t.Errorf("%s config parameter %s has no entry in parameterDocs", config.file, name)
+			case file != config.file:
+				t.Errorf("%s is documented in the %s config, it is in the %s config", name, file, config.file)
+			}
+		}
+	}
+	for name := range documented {
+		if !leaves[name] {
+			t.Errorf("parameterDocs documents %s, which no config has", name)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"simulatedAnnealing/pkg/synthpop"
+)
+
+// templateConfig is the population config written by `init`
+const templateConfig = `{
+  "constraints": {
+    "file": "data/constraints.csv"
+  },
+  "microdata": {
+    "file": "data/microdata.csv"
+  },
+  "output": {
+    "file": "results/{run}/synthetic_population.csv"
+  },
+  "validate": {
+    "file": "results/{run}/fractions.csv",
+    "summaryFile": "results/{run}/summary.csv"
+  }
+}
+`
+
+// templateAnnealingConfig is the annealing config written by `init`
+const templateAnnealingConfig = `{
+  "initialTemp": 1000.0,
+  "minTemp": 0.001,
+  "coolingRate": 0.995,
+  "reheatFactor": 0.5,
+  "fitnessThreshold": 0.0001,
+  "minImprovement": 0.0001,
+  "maxIterations": 1000000,
+  "change": 10000,
+  "distance": "EUCLIDEAN",
+  "useRandomSeed": "yes",
+  "randomSeed": 42
+}
+`
+
+// initCommand implements `init [-f config] [-a annealing config] [-force]`: it
+// writes template configs to start a new project from
+func initCommand(args []string) error {
+	flags := flag.NewFlagSet("init", flag.ContinueOnError)
+	configFile := flags.String("f", "config.json", "population config file to write")
+	annealingFile := flags.String("a", "annealing_config.json", "annealing config file to write")
+	force := flags.Bool("force", false, "overwrite existing files")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return fmt.Errorf("usage: init [-f config] [-a annealing config] [-force]")
+	}
+
+	templates := []struct{ path, content string }{
+		{*configFile, templateConfig},
+		{*annealingFile, templateAnnealingConfig},
+	}
+	if !*force {
+		for _, t := range templates {
+			if _, err := os.Stat(t.path); !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("%s already exists, use -force to overwrite it", t.path)
+			}
+		}
+	}
+	for _, t := range templates {
+		if err := os.WriteFile(t.path, []byte(t.content), 0o644); err != nil {
+			return fmt.Errorf("cannot write %s: %w", t.path, err)
+		}
+		synthpop.Printf("📝 Wrote %s\n", t.path)
+	}
+	synthpop.Printf("Edit the input paths, then check them with `validate -f %s -a %s`\n", *configFile, *annealingFile)
+	return nil
+}
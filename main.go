@@ -1,113 +1,13 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"os"
-	"reflect"
-	"time"
-)
-
-type MicroData struct {
-	ID     string
-	Values []float64
-}
-
-type ConstraintData struct {
-	ID     string
-	Values []float64
-	Total  float64
-}
-
-type results struct {
-	area              string
-	population        float64
-	synthpop_totals   []float64
-	ids               []string
-	constraint_totals []float64
-	fitness           float64
-}
-
-type AnnealingConfig struct {
-	InitialTemp      float64 `json:"initialTemp"`
-	MinTemp          float64 `json:"minTemp"`
-	CoolingRate      float64 `json:"coolingRate"`
-	ReheatFactor     float64 `json:"reheatFactor"`
-	FitnessThreshold float64 `json:"fitnessThreshold"`
-	MinImprovement   float64 `json:"minImprovement"`
-	MaxIterations    int     `json:"maxIterations"`
-	WindowSize       int     `json:"windowSize"`
-	Change           int     `json:"change"`
-	Distance         string  `json:"distance"`
-	UseRandomSeed    string  `json:"useRandomSeed"`
-	RandomSeed       *int64  `json:"randomSeed,omitempty"` // Optional seed for reproducibility
-}
-
-var ValidMetrics = []string{"CHI_SQUARED", "EUCLIDEAN", "NORM_EUCLIDEAN", "MANHATTEN", "KL_DIVERGENCE", "COSINE", "JSDIVERGENCE"}
-
-type PopulationConfig struct {
-	Constraints struct {
-		File string `json:"file"`
-	} `json:"constraints"`
-	Microdata struct {
-		File string `json:"file"`
-	} `json:"microdata"`
-	Output struct {
-		File string `json:"file"`
-	} `json:"output"`
-	Validate struct {
-		File string `json:"file"`
-	} `json:"validate"`
-}
-
-// loadConfig loads the population configuration from a JSON file.
-func loadConfig(configFileName string) (PopulationConfig, error) {
-	var config PopulationConfig
-	file, err := os.Open(configFileName)
-	if err != nil {
-		return config, fmt.Errorf("error opening config file: %w", err)
-	}
-	defer file.Close()
-
-	if err := json.NewDecoder(file).Decode(&config); err != nil {
-		return config, fmt.Errorf("error decoding config JSON: %w", err)
-	}
-	return config, nil
-}
-
-// loadAnnealingConfig loads annealing parameters from a JSON file.
-func loadAnnealingConfig(annealingFileName string) (AnnealingConfig, error) {
-	var config AnnealingConfig
-
-	file, err := os.Open(annealingFileName)
-	if err != nil {
-		return config, fmt.Errorf("error opening config: %w", err)
-	}
-	defer file.Close()
-
-	if err := json.NewDecoder(file).Decode(&config); err != nil {
-		return config, fmt.Errorf("invalid config format: %w", err)
-	}
-
-	// Validate distance metric
-	valid := false
-	for _, m := range ValidMetrics {
-		if config.Distance == m {
-			valid = true
-			break
-		}
-	}
+	"strings"
 
-	if !valid {
-		return config, fmt.Errorf(
-			"invalid distance metric '%s'. Must be one of: %v",
-			config.Distance,
-			ValidMetrics,
-		)
-	}
-
-	return config, nil
-}
+	"simulatedAnnealing/pkg/synthpop"
+)
 
 // readArgs parses command-line arguments with default fallbacks.
 func readArgs() (string, string) {
@@ -124,58 +24,110 @@ func readArgs() (string, string) {
 	return configFileName, annealingFileName
 }
 
-// loadConstraints loads constraint data from CSV and validates headers.
-func loadConstraints(constraintsFile string) ([]ConstraintData, []string, error) {
-	constraints, header, err := ReadConstraintCSV(constraintsFile)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read constraints CSV: %w", err)
-	}
-	fmt.Printf("Loaded %d constraint areas", len(constraints))
-	return constraints, header, nil
+// subcommands maps subcommand names to their implementations; any other first
+// argument is treated as the classic `<config> <annealing config>` invocation.
+var subcommands = map[string]func([]string) error{
+	"anonymize": anonymizeCommand,
+	"benchmark": benchmarkCommand,
+	"decrypt":   decryptCommand,
+	"explain":   explainCommand,
+	"init":      initCommand,
+	"report":    reportCommand,
+	"run":       runCommand,
+	"selftest":  selftestCommand,
+	"serve":     serveCommand,
+	"validate":  validateCommand,
+
+	"convert-ids":    convertIDsCommand,
+	"verify-metrics": verifyMetricsCommand,
 }
 
-// loadMicrodata loads microdata from CSV and validates headers.
-func loadMicrodata(microdataFile string) ([]MicroData, []string, error) {
-	microData, header, err := ReadMicroDataCSV(microdataFile)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read microdata CSV: %w", err)
+// noEmojiEnv switches emoji off in console output when set to any non-empty value
+const noEmojiEnv = "GOSYNTHPOP_NO_EMOJI"
+
+// stripConsoleFlags removes the global console flags from the arguments, which may
+// appear anywhere on the command line, and applies them: -no-emoji together with
+// noEmojiEnv, and -progress-format text|json
+func stripConsoleFlags(args []string) ([]string, error) {
+	plain := os.Getenv(noEmojiEnv) != ""
+	format := synthpop.ProgressText
+	kept := args[:0:0]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-no-emoji" || arg == "--no-emoji":
+			plain = true
+		case arg == "-progress-format" || arg == "--progress-format":
+			if i+1 == len(args) {
+				return nil, fmt.Errorf("flag %s needs a value", arg)
+			}
+			i++
+			format = args[i]
+		case strings.HasPrefix(arg, "-progress-format=") || strings.HasPrefix(arg, "--progress-format="):
+			_, format, _ = strings.Cut(arg, "=")
+		default:
+			kept = append(kept, arg)
+		}
 	}
-	fmt.Printf("Loaded %d microdata records", len(microData))
-	return microData, header, nil
+	synthpop.SetPlainConsole(plain)
+	return kept, synthpop.SetProgressFormat(format)
 }
 
 func main() {
-	configFileName, anellingFileName := readArgs()
-
-	config, err := loadConfig(configFileName)
+	args, err := stripConsoleFlags(os.Args)
 	if err != nil {
-		fmt.Printf("Config error: %v", err)
+		synthpop.Printf("Error: %v\n", err)
+		os.Exit(1)
 	}
-
-	annealingConfig, err := loadAnnealingConfig(anellingFileName)
+	args, profiles, err := stripProfilingFlags(args)
+	if err != nil {
+		synthpop.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	os.Args = args
+	stopProfiles, err := profiles.start()
 	if err != nil {
-		fmt.Printf("Annealing config error: %v", err)
+		synthpop.Printf("Error: %v\n", err)
+		os.Exit(1)
 	}
+	code := cliMain()
+	stopProfiles()
+	os.Exit(code)
+}
 
-	// Load data
-	constraints, constraintHeader, err := loadConstraints(config.Constraints.File)
-	if err != nil {
-		fmt.Printf("Constraint loading error: %v", err)
+// cliMain runs the subcommand or classic invocation of os.Args, returning the exit
+// code so the profiles started by main are written before the process exits
+func cliMain() int {
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				synthpop.Printf("%s error: %v\n", os.Args[1], err)
+				return 1
+			}
+			return 0
+		}
 	}
 
-	microData, microDataHeader, err := loadMicrodata(config.Microdata.File)
+	configFileName, anellingFileName := readArgs()
+
+	config, err := synthpop.LoadConfig(configFileName)
 	if err != nil {
-		fmt.Printf("Microdata loading error: %v", err)
+		synthpop.Printf("Config error: %v\n", err)
+		return 1
 	}
 
-	if reflect.DeepEqual(constraintHeader, microDataHeader) {
-		start := time.Now()
-		parallelRun(constraints, microData, microDataHeader, config.Output.File, config.Validate.File, annealingConfig)
+	annealingConfig, err := synthpop.LoadAnnealingConfig(anellingFileName)
+	if err != nil {
+		synthpop.Printf("Annealing config error: %v\n", err)
+		return 1
+	}
 
-		elapsed := time.Since(start) // Calculate duration
-		fmt.Printf("slowFunction took %s\n", elapsed)
-	} else {
-		fmt.Printf("Error: The Constraints header and the MiroData header not the same\n")
-		os.Exit(1)
+	err = withMaxProcs(config.MaxProcs, func() error {
+		return synthpop.NewController().Run(context.Background(), config, annealingConfig)
+	})
+	if err != nil {
+		synthpop.Printf("Error: %v\n", err)
+		return 1
 	}
+	return 0
 }
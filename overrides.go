@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"simulatedAnnealing/pkg/synthpop"
+)
+
+// configOverrides are flags that replace values loaded from the JSON configs, so
+// parameter sweeps can be scripted without writing a config per combination. Only
+// flags given on the command line override anything.
+type configOverrides struct {
+	flags         *flag.FlagSet
+	maxIterations *int
+	initialTemp   *float64
+	coolingRate   *float64
+	distance      *string
+	seed          *int64
+	output        *string
+	validate      *string
+	workers       *int
+	maxProcs      *int
+}
+
+// addOverrideFlags registers the override flags on flags
+func addOverrideFlags(flags *flag.FlagSet) *configOverrides {
+	return &configOverrides{
+		flags:         flags,
+		maxIterations: flags.Int("max-iterations", 0, "override maxIterations"),
+		initialTemp:   flags.Float64("initial-temp", 0, "override initialTemp"),
+		coolingRate:   flags.Float64("cooling-rate", 0, "override coolingRate"),
+		distance:      flags.String("distance", "", "override distance ("+strings.Join(synthpop.ValidMetrics, ", ")+")"),
+		seed:          flags.Int64("seed", 0, "use this random seed (sets useRandomSeed)"),
+		output:        flags.String("output", "", "override output.file"),
+		validate:      flags.String("validate", "", "override validate.file"),
+		workers:       flags.Int("workers", 0, "override workers"),
+		maxProcs:      flags.Int("max-procs", 0, "override maxProcs (GOMAXPROCS of the run)"),
+	}
+}
+
+// set reports whether the flag name was given on the command line
+func (o *configOverrides) set(name string) bool {
+	found := false
+	o.flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
+}
+
+// applyAnnealing overrides the annealing config and checks the result
+func (o *configOverrides) applyAnnealing(config synthpop.AnnealingConfig) (synthpop.AnnealingConfig, error) {
+	if o.set("max-iterations") {
+		config.MaxIterations = *o.maxIterations
+	}
+	if o.set("initial-temp") {
+		config.InitialTemp = *o.initialTemp
+	}
+	if o.set("cooling-rate") {
+		config.CoolingRate = *o.coolingRate
+	}
+	if o.set("distance") {
+		config.Distance = *o.distance
+	}
+	if o.set("seed") {
+		seed := *o.seed
+		config.UseRandomSeed = "yes"
+		config.RandomSeed = &seed
+	}
+	if err := config.Check(); err != nil {
+		return config, fmt.Errorf("annealing config error: %w", err)
+	}
+	return config, nil
+}
+
+// applyPopulation overrides a population config and checks the result
+func (o *configOverrides) applyPopulation(config synthpop.PopulationConfig) (synthpop.PopulationConfig, error) {
+	if o.set("output") {
+		config.Output.File = *o.output
+	}
+	if o.set("validate") {
+		config.Validate.File = *o.validate
+	}
+	if o.set("workers") {
+		config.Workers = *o.workers
+	}
+	if o.set("max-procs") {
+		config.MaxProcs = *o.maxProcs
+	}
+	if err := config.Check(); err != nil {
+		return config, fmt.Errorf("config error: %w", err)
+	}
+	return config, nil
+}
@@ -0,0 +1,293 @@
+package synthpop
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Anonymization methods of an AnonymizeRule
+const (
+	AnonymizeRound   = "round"   // Round to a multiple of Base
+	AnonymizeNoise   = "noise"   // Add Gaussian noise of standard deviation Scale
+	AnonymizeTopCode = "topcode" // Cap values at Max
+	AnonymizeSwap    = "swap"    // Swap values between a share Rate of the records
+	AnonymizeDrop    = "drop"    // Leave the column out
+)
+
+// AnonymizeConfig describes how real microdata are turned into a shareable training
+// file for runnable examples: quasi-identifying columns are perturbed or coarsened,
+// records whose combination of quasi-identifiers is rarer than K are suppressed and
+// the remaining records get new IDs in a random order.
+type AnonymizeConfig struct {
+	Input    string `json:"input"`    // Real microdata CSV
+	Output   string `json:"output"`   // Shareable microdata CSV
+	IDColumn string `json:"idColumn"` // Name of the ID column written (default "id")
+	Seed     *int64 `json:"seed"`     // Optional seed for a reproducible file
+	// Share of the records kept in a random sample before anything else (0 keeps all)
+	SampleFraction float64 `json:"sampleFraction"`
+	// Rules by column, applied in header order
+	Columns map[string]AnonymizeRule `json:"columns"`
+	// Columns an intruder could know, and the minimum number of records that must
+	// share each combination of their values after the rules (0 disables)
+	QuasiIdentifiers []string `json:"quasiIdentifiers"`
+	K                int      `json:"k"`
+}
+
+// AnonymizeRule is the treatment of one column
+type AnonymizeRule struct {
+	Method string  `json:"method"` // One of the Anonymize* methods
+	Base   float64 `json:"base"`   // round: rounding base; noise: optional rounding of the result
+	Scale  float64 `json:"scale"`  // noise: standard deviation
+	Max    float64 `json:"max"`    // topcode: largest value kept
+	Rate   float64 `json:"rate"`   // swap: share of records whose value is swapped
+}
+
+// AnonymizeReport summarises an anonymization
+type AnonymizeReport struct {
+	Records    int // Records read
+	Sampled    int // Records left out by the sample
+	Suppressed int // Records in quasi-identifier combinations rarer than K
+	Written    int
+	Columns    int // Columns written, without the ID
+	// Records whose quasi-identifier combination is unique, before and after
+	UniqueBefore, UniqueAfter int
+}
+
+// LoadAnonymizeConfig loads and checks an anonymization config
+func LoadAnonymizeConfig(filename string) (AnonymizeConfig, error) {
+	var config AnonymizeConfig
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return config, fmt.Errorf("error opening anonymization config: %w", err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("invalid anonymization config: %w", err)
+	}
+	if config.Input == "" || config.Output == "" {
+		return config, fmt.Errorf("anonymization config needs input and output")
+	}
+	if config.SampleFraction < 0 || config.SampleFraction > 1 {
+		return config, fmt.Errorf("sampleFraction must be between 0 and 1")
+	}
+	if config.K < 0 {
+		return config, fmt.Errorf("k must not be negative")
+	}
+	if config.K > 0 && len(config.QuasiIdentifiers) == 0 {
+		return config, fmt.Errorf("k needs quasiIdentifiers")
+	}
+	for name, rule := range config.Columns {
+		var err error
+		switch rule.Method {
+		case AnonymizeRound:
+			if rule.Base <= 0 {
+				err = fmt.Errorf("round needs a positive base")
+			}
+		case AnonymizeNoise:
+			if rule.Scale <= 0 || rule.Base < 0 {
+				err = fmt.Errorf("noise needs a positive scale and a non-negative base")
+			}
+		case AnonymizeTopCode:
+		case AnonymizeSwap:
+			if rule.Rate <= 0 || rule.Rate > 1 {
+				err = fmt.Errorf("swap needs a rate between 0 and 1")
+			}
+		case AnonymizeDrop:
+		default:
+			err = fmt.Errorf("invalid method '%s'. Must be one of: %s, %s, %s, %s, %s", rule.Method,
+				AnonymizeRound, AnonymizeNoise, AnonymizeTopCode, AnonymizeSwap, AnonymizeDrop)
+		}
+		if err != nil {
+			return config, fmt.Errorf("column '%s': %w", name, err)
+		}
+	}
+	if config.IDColumn == "" {
+		config.IDColumn = "id"
+	}
+	return config, nil
+}
+
+// Anonymize writes the shareable training microdata described by config.
+//
+// Parameters:
+//   - config: The anonymization config
+//
+// Returns:
+//   - AnonymizeReport: What was sampled, suppressed and written
+//   - error: Unreadable input, unknown columns or an output error
+func Anonymize(config AnonymizeConfig) (AnonymizeReport, error) {
+	var report AnonymizeReport
+	microData, header, err := ReadMicroDataCSV(config.Input)
+	if err != nil {
+		return report, fmt.Errorf("error reading microdata: %w", err)
+	}
+	report.Records = len(microData)
+
+	column := make(map[string]int, len(header))
+	for i, name := range header {
+		column[name] = i
+	}
+	for name := range config.Columns {
+		if _, ok := column[name]; !ok {
+			return report, fmt.Errorf("column '%s' is not in the microdata header", name)
+		}
+	}
+	quasi := make([]int, len(config.QuasiIdentifiers))
+	for i, name := range config.QuasiIdentifiers {
+		col, ok := column[name]
+		if !ok {
+			return report, fmt.Errorf("quasi-identifier '%s' is not in the microdata header", name)
+		}
+		if config.Columns[name].Method == AnonymizeDrop {
+			return report, fmt.Errorf("quasi-identifier '%s' is dropped", name)
+		}
+		quasi[i] = col
+	}
+
+	seed := time.Now().UnixNano()
+	if config.Seed != nil {
+		seed = *config.Seed
+	}
+	rng := rand.New(rand.NewSource(seed))
+
+	// Work on copies in a random order; only the values leave this function
+	records := make([][]float64, len(microData))
+	for i, j := range rng.Perm(len(microData)) {
+		records[i] = slices.Clone(microData[j].Values)
+	}
+	if config.SampleFraction > 0 && config.SampleFraction < 1 {
+		keep := int(math.Round(config.SampleFraction * float64(len(records))))
+		report.Sampled = len(records) - keep
+		records = records[:keep]
+	}
+	report.UniqueBefore = uniqueCombinations(records, quasi)
+
+	for i, name := range header {
+		rule, ok := config.Columns[name]
+		if ok {
+			applyAnonymizeRule(records, i, rule, rng)
+		}
+	}
+	report.UniqueAfter = uniqueCombinations(records, quasi)
+
+	// Suppress the records of combinations rarer than K
+	if config.K > 0 {
+		counts := quasiCounts(records, quasi)
+		kept := records[:0]
+		for _, values := range records {
+			if counts[quasiKey(values, quasi)] >= config.K {
+				kept = append(kept, values)
+			}
+		}
+		report.Suppressed = len(records) - len(kept)
+		records = kept
+	}
+
+	// Write the kept columns with new IDs
+	var columns []int
+	outHeader := []string{config.IDColumn}
+	for i, name := range header {
+		if config.Columns[name].Method != AnonymizeDrop {
+			columns = append(columns, i)
+			outHeader = append(outHeader, name)
+		}
+	}
+	file, err := os.Create(config.Output)
+	if err != nil {
+		return report, fmt.Errorf("cannot create anonymized microdata: %w", err)
+	}
+	defer file.Close()
+	writer := csv.NewWriter(file)
+	if err := writer.Write(outHeader); err != nil {
+		return report, fmt.Errorf("error writing anonymized header: %w", err)
+	}
+	row := make([]string, len(outHeader))
+	width := len(strconv.Itoa(len(records)))
+	for n, values := range records {
+		row[0] = fmt.Sprintf("R%0*d", width, n+1)
+		for i, col := range columns {
+			row[i+1] = strconv.FormatFloat(values[col], 'f', -1, 64)
+		}
+		if err := writer.Write(row); err != nil {
+			return report, fmt.Errorf("error writing anonymized record: %w", err)
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return report, fmt.Errorf("error writing anonymized microdata: %w", err)
+	}
+	report.Written = len(records)
+	report.Columns = len(columns)
+	return report, file.Close()
+}
+
+// applyAnonymizeRule applies rule to column col of every record
+func applyAnonymizeRule(records [][]float64, col int, rule AnonymizeRule, rng *rand.Rand) {
+	switch rule.Method {
+	case AnonymizeRound:
+		for _, values := range records {
+			values[col] = math.Round(values[col]/rule.Base) * rule.Base
+		}
+	case AnonymizeNoise:
+		for _, values := range records {
+			v := math.Max(values[col]+rng.NormFloat64()*rule.Scale, 0) // Counts stay non-negative
+			if rule.Base > 0 {
+				v = math.Round(v/rule.Base) * rule.Base
+			}
+			values[col] = v
+		}
+	case AnonymizeTopCode:
+		for _, values := range records {
+			values[col] = math.Min(values[col], rule.Max)
+		}
+	case AnonymizeSwap:
+		// Rotate the values of a random subset, so every chosen record gets another's
+		chosen := rng.Perm(len(records))[:int(math.Round(rule.Rate*float64(len(records))))]
+		if len(chosen) < 2 {
+			return
+		}
+		first := records[chosen[0]][col]
+		for i := 0; i < len(chosen)-1; i++ {
+			records[chosen[i]][col] = records[chosen[i+1]][col]
+		}
+		records[chosen[len(chosen)-1]][col] = first
+	}
+}
+
+// quasiKey encodes the quasi-identifier values of a record
+func quasiKey(values []float64, quasi []int) string {
+	picked := make([]float64, len(quasi))
+	for i, col := range quasi {
+		picked[i] = values[col]
+	}
+	return patternKey(picked)
+}
+
+// quasiCounts counts the records of every quasi-identifier combination
+func quasiCounts(records [][]float64, quasi []int) map[string]int {
+	counts := make(map[string]int)
+	for _, values := range records {
+		counts[quasiKey(values, quasi)]++
+	}
+	return counts
+}
+
+// uniqueCombinations counts the records alone in their quasi-identifier combination
+func uniqueCombinations(records [][]float64, quasi []int) int {
+	if len(quasi) == 0 {
+		return 0
+	}
+	unique := 0
+	for _, n := range quasiCounts(records, quasi) {
+		if n == 1 {
+			unique++
+		}
+	}
+	return unique
+}
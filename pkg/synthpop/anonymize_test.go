@@ -0,0 +1,146 @@
+package synthpop
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAnonymize(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "real.csv")
+	// Every record has its own income, which no rule touches, so the records can be
+	// followed through the shuffle
+	var data strings.Builder
+	data.WriteString("pid,age,income,sex,region\n")
+	rawIDs := make(map[string]string, 40) // income to raw ID
+	for i := 0; i < 40; i++ {
+		id := fmt.Sprintf("person-%d", 7000+i*13)
+		income := strconv.Itoa(1000 + i*10)
+		rawIDs[income] = id
+		fmt.Fprintf(&data, "%s,%d,%s,%d,%d\n", id, 21+i%47, income, i%2, i%4)
+	}
+	if err := os.WriteFile(input, []byte(data.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	seed := func(s int64) *int64 { return &s }
+	run := func(name string, config AnonymizeConfig) (AnonymizeReport, string, [][]string) {
+		t.Helper()
+		config.Input, config.Output, config.IDColumn = input, filepath.Join(dir, name), "id"
+		report, err := Anonymize(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := os.ReadFile(config.Output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := csv.NewReader(strings.NewReader(string(out))).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return report, string(out), rows
+	}
+	// mapping returns the raw ID behind every new ID
+	mapping := func(rows [][]string) map[string]string {
+		m := make(map[string]string, len(rows))
+		for _, row := range rows[1:] {
+			m[row[0]] = rawIDs[row[2]]
+		}
+		return m
+	}
+
+	rules := map[string]AnonymizeRule{
+		"age":    {Method: AnonymizeRound, Base: 5},
+		"region": {Method: AnonymizeDrop},
+	}
+	report, out, rows := run("a.csv", AnonymizeConfig{Seed: seed(7), Columns: rules})
+	if want := (AnonymizeReport{Records: 40, Written: 40, Columns: 3}); report != want {
+		t.Errorf("report %+v, want %+v", report, want)
+	}
+	if want := []string{"id", "age", "income", "sex"}; !reflect.DeepEqual(rows[0], want) {
+		t.Errorf("header %v, want %v", rows[0], want)
+	}
+	for _, id := range rawIDs {
+		if strings.Contains(out, id) {
+			t.Errorf("raw ID %s in the output", id)
+		}
+	}
+	// Every record is written once under a new ID, with its age rounded
+	seen := make(map[string]bool)
+	for _, row := range rows[1:] {
+		if !strings.HasPrefix(row[0], "R") || len(row[0]) != 3 {
+			t.Errorf("new ID %s, want R and two digits", row[0])
+		}
+		raw, ok := rawIDs[row[2]]
+		if !ok || seen[raw] {
+			t.Errorf("row %v is not one of the records, or is written twice", row)
+		}
+		seen[raw] = true
+		if age, _ := strconv.ParseFloat(row[1], 64); math.Mod(age, 5) != 0 {
+			t.Errorf("age %s not rounded to 5", row[1])
+		}
+	}
+	if len(seen) != 40 {
+		t.Errorf("%d records written, want 40", len(seen))
+	}
+	shuffled := false
+	for n, row := range rows[1:] {
+		if rawIDs[row[2]] != fmt.Sprintf("person-%d", 7000+n*13) {
+			shuffled = true
+		}
+	}
+	if !shuffled {
+		t.Error("records written in their original order")
+	}
+
+	// The same seed gives the same file, so the same new ID for every record
+	_, again, againRows := run("b.csv", AnonymizeConfig{Seed: seed(7), Columns: rules})
+	if again != out || !reflect.DeepEqual(mapping(againRows), mapping(rows)) {
+		t.Error("the same seed mapped the records to other IDs")
+	}
+	if _, _, other := run("c.csv", AnonymizeConfig{Seed: seed(8), Columns: rules}); reflect.DeepEqual(mapping(other), mapping(rows)) {
+		t.Error("another seed mapped the records to the same IDs")
+	}
+
+	// Suppression leaves no combination of the quasi-identifiers rarer than K
+	report, out, rows = run("k.csv", AnonymizeConfig{Seed: seed(7), Columns: rules,
+		QuasiIdentifiers: []string{"age", "sex"}, K: 3})
+	if report.Suppressed == 0 || report.Written+report.Suppressed != 40 || len(rows)-1 != report.Written {
+		t.Errorf("report %+v for %d rows written", report, len(rows)-1)
+	}
+	if report.UniqueAfter >= report.UniqueBefore {
+		t.Errorf("%d unique records after rounding, %d before", report.UniqueAfter, report.UniqueBefore)
+	}
+	combinations := make(map[string]int)
+	for _, row := range rows[1:] {
+		combinations[row[1]+","+row[3]]++
+	}
+	for combination, n := range combinations {
+		if n < 3 {
+			t.Errorf("%d records share age and sex %s, want at least 3", n, combination)
+		}
+	}
+	if strings.Contains(out, "person") {
+		t.Error("raw IDs in the suppressed output")
+	}
+
+	// Unknown columns and dropped quasi-identifiers are refused
+	for name, config := range map[string]AnonymizeConfig{
+		"unknown column":   {Columns: map[string]AnonymizeRule{"height": {Method: AnonymizeDrop}}},
+		"unknown quasi":    {QuasiIdentifiers: []string{"height"}, K: 2},
+		"dropped quasi-id": {Columns: rules, QuasiIdentifiers: []string{"region"}, K: 2},
+	} {
+		config.Input, config.Output = input, filepath.Join(dir, "refused.csv")
+		if _, err := Anonymize(config); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
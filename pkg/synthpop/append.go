@@ -0,0 +1,93 @@
+package synthpop
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// existingOutputs reads the outputs of a previous run that new areas are appended
+// to. It returns the areas already synthesized and the current output sizes, or a
+// nil set when there are no outputs yet. Outputs that disagree with each other or
+// with the current header (the output names of the variables) are rejected rather
+// than extended.
+func existingOutputs(popConfig PopulationConfig, outputHeader []string) (map[string]bool, checkpointEntry, error) {
+	var sizes checkpointEntry
+	validateInfo, err := os.Stat(popConfig.Validate.File)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, sizes, nil
+	}
+	if err != nil {
+		return nil, sizes, err
+	}
+	sizes.ValidateOffset = validateInfo.Size()
+
+	wantHeader := append([]string{"geography_code"}, outputHeader...)
+	wantHeader = append(wantHeader, "best_iteration")
+	done := make(map[string]bool)
+	err = readAreaColumn(popConfig.Validate.File, wantHeader, func(area string) error {
+		if done[area] {
+			return fmt.Errorf("area %s appears more than once", area)
+		}
+		done[area] = true
+		return nil
+	})
+	if err != nil {
+		return nil, sizes, fmt.Errorf("cannot append to %s: %w", popConfig.Validate.File, err)
+	}
+
+	if !popConfig.Output.AggregateOnly {
+		idsInfo, err := os.Stat(popConfig.Output.File)
+		if err != nil {
+			return nil, sizes, fmt.Errorf("cannot append to the IDs file: %w", err)
+		}
+		sizes.IDsOffset = idsInfo.Size()
+
+		err = readAreaColumn(popConfig.Output.File, idsHeader(popConfig.Output.Layout), func(area string) error {
+			if !done[area] {
+				return fmt.Errorf("area %s is missing from %s", area, popConfig.Validate.File)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, sizes, fmt.Errorf("cannot append to %s: %w", popConfig.Output.File, err)
+		}
+	}
+	return done, sizes, nil
+}
+
+// readAreaColumn checks the header of a CSV output and calls fn with the first
+// column of every row
+func readAreaColumn(path string, wantHeader []string, fn func(area string) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = len(wantHeader)
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("error reading header: %w", err)
+	}
+	if strings.Join(header, ",") != strings.Join(wantHeader, ",") {
+		return fmt.Errorf("header %v does not match %v", header, wantHeader)
+	}
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(row[0]); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package synthpop
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// bundleFile is a file of the run bundle
+type bundleFile struct {
+	name string // Name in the archive
+	path string // File on disk, empty for data
+	data []byte
+}
+
+// bundleFormat returns "zip" or "tar.gz" from the extension of a bundle path, or ""
+// for an unsupported one
+func bundleFormat(path string) string {
+	lower := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip"
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tar.gz"
+	}
+	return ""
+}
+
+// bundleFiles lists the deliverables of a finished run: the outputs that exist, the
+// failed areas (when the run had any, the file may be left from an earlier run
+// otherwise), the manifest and the worker logs, and copies of the two configs.
+// The status heartbeat and the checkpoint only serve the running job and are left
+// out. Files are named by their path relative to the bundle's directory, or by
+// their base name when they lie outside it, inside a directory named after the run.
+func bundleFiles(popConfig PopulationConfig, config AnnealingConfig, failed *failedAreasWriter) ([]bundleFile, error) {
+	paths := []string{manifestPath(popConfig)}
+	if len(failed.areas) > 0 {
+		paths = append(paths, failed.path)
+	}
+	for _, path := range outputPaths(&popConfig) {
+		switch path {
+		case &popConfig.Status.File, &popConfig.Checkpoint.File, &popConfig.Output.ManifestFile,
+			&popConfig.Output.FailedAreasFile, &popConfig.Output.BundleFile, &popConfig.Debug.Dir:
+			continue
+		}
+		paths = append(paths, *path)
+	}
+	if popConfig.Debug.WorkerLogs {
+		dir := popConfig.Debug.Dir
+		if dir == "" {
+			dir = defaultWorkerLogDir
+		}
+		logs, err := filepath.Glob(filepath.Join(dir, "worker-*.log"))
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, logs...)
+	}
+
+	base := filepath.Dir(popConfig.Output.BundleFile)
+	used := make(map[string]bool)
+	var files []bundleFile
+	add := func(name string, f bundleFile) {
+		// Outputs from different directories may share a base name
+		unique := name
+		for n := 2; used[unique]; n++ {
+			ext := filepath.Ext(name)
+			unique = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), n, ext)
+		}
+		used[unique] = true
+		f.name = popConfig.RunName + "/" + filepath.ToSlash(unique)
+		files = append(files, f)
+	}
+
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		if path == "" || seen[path] {
+			continue
+		}
+		seen[path] = true
+		if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+			continue // Not configured for this run, or never written (e.g. no failed areas)
+		}
+		name, err := filepath.Rel(base, path)
+		if err != nil || strings.HasPrefix(name, "..") {
+			name = filepath.Base(path)
+		}
+		add(name, bundleFile{path: path})
+	}
+	for _, c := range []struct {
+		name   string
+		config any
+	}{{"population_config.json", popConfig}, {"annealing_config.json", config}} {
+		data, err := json.MarshalIndent(c.config, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		add(c.name, bundleFile{data: append(data, '\n')})
+	}
+	return files, nil
+}
+
+// writeBundle packages the deliverables of a finished run into Output.BundleFile
+// (.zip or .tar.gz). Encrypted outputs are bundled as they are.
+func writeBundle(popConfig PopulationConfig, config AnnealingConfig, failed *failedAreasWriter, retry retryPolicy) error {
+	files, err := bundleFiles(popConfig, config, failed)
+	if err != nil {
+		return fmt.Errorf("cannot list run bundle files: %w", err)
+	}
+	out, err := createUncompressedOutput(popConfig.Output.BundleFile, nil, retry)
+	if err != nil {
+		return fmt.Errorf("cannot create run bundle: %w", err)
+	}
+	if bundleFormat(popConfig.Output.BundleFile) == "zip" {
+		err = writeZipBundle(out, files)
+	} else {
+		err = writeTarBundle(out, files)
+	}
+	if err != nil {
+		out.Close()
+		return fmt.Errorf("error writing run bundle: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("error writing run bundle: %w", err)
+	}
+	Printf("📦 Run bundle of %d files written to %s\n", len(files), popConfig.Output.BundleFile)
+	return nil
+}
+
+// open returns the contents of a bundle file, its size and modification time
+func (f bundleFile) open() (io.ReadCloser, int64, time.Time, error) {
+	if f.path == "" {
+		return io.NopCloser(bytes.NewReader(f.data)), int64(len(f.data)), time.Now(), nil
+	}
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, 0, time.Time{}, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, time.Time{}, err
+	}
+	return file, info.Size(), info.ModTime(), nil
+}
+
+func writeZipBundle(w io.Writer, files []bundleFile) error {
+	archive := zip.NewWriter(w)
+	for _, f := range files {
+		in, _, modified, err := f.open()
+		if err != nil {
+			return err
+		}
+		entry, err := archive.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: modified})
+		if err == nil {
+			_, err = io.Copy(entry, in)
+		}
+		in.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.name, err)
+		}
+	}
+	return archive.Close()
+}
+
+func writeTarBundle(w io.Writer, files []bundleFile) error {
+	compressed := gzip.NewWriter(w)
+	archive := tar.NewWriter(compressed)
+	for _, f := range files {
+		in, size, modified, err := f.open()
+		if err != nil {
+			return err
+		}
+		err = archive.WriteHeader(&tar.Header{Name: f.name, Mode: 0o644, Size: size, ModTime: modified,
+			Typeflag: tar.TypeReg, Format: tar.FormatPAX})
+		if err == nil {
+			_, err = io.Copy(archive, in)
+		}
+		in.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.name, err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return compressed.Close()
+}
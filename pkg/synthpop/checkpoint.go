@@ -0,0 +1,139 @@
+package synthpop
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// checkpointEntry records one completed area and the size of the outputs once its
+// rows were written. The writer goroutine appends an entry after every area.
+type checkpointEntry struct {
+	Area           string `json:"area"`
+	IDsOffset      int64  `json:"idsOffset"`
+	ValidateOffset int64  `json:"validateOffset"`
+}
+
+// checkpoint is the JSONL checkpoint file of a run. On resume, done holds the areas
+// already completed and last the entry the outputs are truncated back to, which
+// drops any rows written after the last recorded area.
+type checkpoint struct {
+	file *os.File
+	done map[string]bool
+	last *checkpointEntry // nil on a fresh run
+}
+
+// checkResumable rejects outputs that cannot be continued from a byte offset, as
+// needed by mode ("resume" or "append")
+func checkResumable(popConfig PopulationConfig, mode string) error {
+	switch {
+	case popConfig.Output.Encrypt:
+		return fmt.Errorf("%s is not supported for encrypted outputs", mode)
+	case fileFormat(popConfig.Output.File, popConfig.Output.Format) == FormatParquet ||
+		fileFormat(popConfig.Validate.File, popConfig.Validate.Format) == FormatParquet:
+		return fmt.Errorf("%s is not supported for Parquet outputs", mode)
+	case compressionOf(popConfig.Output.File) != "" || compressionOf(popConfig.Validate.File) != "":
+		return fmt.Errorf("%s is not supported for compressed outputs", mode)
+	case popConfig.Output.AgentsFile != "" || popConfig.Output.MatsimFile != "" ||
+		popConfig.Output.IndividualsFile != "" || popConfig.Output.GeoJSONFile != "" || popConfig.Output.InclusionFile != "" ||
+		popConfig.Households.PersonsOutputFile != "" || popConfig.Output.TraceFile != "" ||
+		popConfig.Output.DiagnosticsFile != "" || popConfig.Output.SQLiteFile != "" ||
+		popConfig.Output.Postgres.DSN != "" ||
+		popConfig.Validate.ErrorsFile != "" || popConfig.Validate.SummaryFile != "" ||
+		popConfig.Validate.InteractionsFile != "" || popConfig.Parent.ValidateFile != "":
+		return fmt.Errorf("%s only supports the output and validate files, disable the agents, MATSim, individuals, GeoJSON, inclusion, persons, trace, diagnostics, SQLite, PostgreSQL, validation and parent validation outputs", mode)
+	}
+	return nil
+}
+
+// openCheckpoint opens the checkpoint file, or returns nil when none is configured.
+// Without Checkpoint.Resume (or without a previous checkpoint) it starts a new one.
+func openCheckpoint(popConfig PopulationConfig) (*checkpoint, error) {
+	path := popConfig.Checkpoint.File
+	if path == "" {
+		if popConfig.Checkpoint.Resume {
+			return nil, fmt.Errorf("resume needs checkpoint.file")
+		}
+		return nil, nil
+	}
+	if err := checkResumable(popConfig, "resume"); err != nil {
+		return nil, err
+	}
+
+	ckpt := &checkpoint{done: make(map[string]bool)}
+	if popConfig.Checkpoint.Resume {
+		entries, validLength, err := readCheckpoint(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("error reading checkpoint: %w", err)
+		}
+		if len(entries) > 0 {
+			for _, entry := range entries {
+				ckpt.done[entry.Area] = true
+			}
+			ckpt.last = &entries[len(entries)-1]
+
+			// Drop a partly written last line left by a crash
+			if err := os.Truncate(path, validLength); err != nil {
+				return nil, fmt.Errorf("error repairing checkpoint: %w", err)
+			}
+			ckpt.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+			if err != nil {
+				return nil, fmt.Errorf("error opening checkpoint: %w", err)
+			}
+			return ckpt, nil
+		}
+		Printf("No checkpoint in %s, starting from the beginning\n", path)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating checkpoint: %w", err)
+	}
+	ckpt.file = file
+	return ckpt, nil
+}
+
+// readCheckpoint returns the complete entries of a checkpoint file and the length
+// of the file up to the end of the last of them
+func readCheckpoint(path string) ([]checkpointEntry, int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var entries []checkpointEntry
+	var validLength int64
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		end := validLength + int64(len(line)) + 1
+		if end > int64(len(data)) {
+			break // Last line without its newline: the write was cut short
+		}
+		var entry checkpointEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, 0, fmt.Errorf("line %d: %w", len(entries)+1, err)
+		}
+		entries = append(entries, entry)
+		validLength = end
+	}
+	return entries, validLength, scanner.Err()
+}
+
+// record appends the entry of a completed area
+func (c *checkpoint) record(entry checkpointEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = c.file.Write(append(line, '\n'))
+	return err
+}
+
+func (c *checkpoint) Close() error {
+	return c.file.Close()
+}
@@ -0,0 +1,170 @@
+package synthpop
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testRunInputs returns the inputs of a small run of areas E0, E1... writing its IDs
+// and validation outputs to a temporary directory
+func testRunInputs(t *testing.T, areas int) ([]ConstraintData, []MicroData, []string, PopulationConfig, AnnealingConfig) {
+	t.Helper()
+	quietConsole(t)
+	const variables = 6
+	rng := rand.New(rand.NewSource(3))
+	microData := testMicrodata(rng, 60, variables, false)
+	constraints := make([]ConstraintData, areas)
+	for i := range constraints {
+		constraints[i] = testConstraint(rng, variables, float64(10+i))
+		constraints[i].ID = "E" + strconv.Itoa(i)
+	}
+	dir := t.TempDir()
+	var popConfig PopulationConfig
+	popConfig.RunName = "test"
+	popConfig.Workers = 2
+	popConfig.Output.File = filepath.Join(dir, "ids.csv")
+	popConfig.Validate.File = filepath.Join(dir, "validate.csv")
+	config := testConfig()
+	config.MaxIterations = 300
+	return constraints, microData, testHeader(variables), popConfig, config
+}
+
+// outputRows reads a CSV output, checking it has its header once, on its first line,
+// and returns how many rows each area has
+func outputRows(t *testing.T, file string, header []string) map[string]int {
+	t.Helper()
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("%s: %v", file, err)
+	}
+	if len(records) == 0 || strings.Join(records[0], ",") != strings.Join(header, ",") {
+		t.Fatalf("%s does not start with its header", file)
+	}
+	rows := make(map[string]int)
+	for i, record := range records[1:] {
+		if record[0] == header[0] {
+			t.Fatalf("%s repeats its header on line %d", file, i+2)
+		}
+		rows[record[0]]++
+	}
+	return rows
+}
+
+// checkRunOutputs checks that every area of constraints is in the outputs exactly
+// once: one validation row, and one ID row per individual
+func checkRunOutputs(t *testing.T, popConfig PopulationConfig, header []string, constraints []ConstraintData) {
+	t.Helper()
+	validateHeader := append(append([]string{"geography_code"}, header...), "best_iteration")
+	validated := outputRows(t, popConfig.Validate.File, validateHeader)
+	ids := outputRows(t, popConfig.Output.File, idsHeader(""))
+	if len(validated) != len(constraints) || len(ids) != len(constraints) {
+		t.Errorf("outputs hold %d and %d areas, want %d", len(validated), len(ids), len(constraints))
+	}
+	for _, c := range constraints {
+		if validated[c.ID] != 1 {
+			t.Errorf("area %s has %d validation rows, want 1", c.ID, validated[c.ID])
+		}
+		if ids[c.ID] != int(c.Total) {
+			t.Errorf("area %s has %d IDs, want %d", c.ID, ids[c.ID], int(c.Total))
+		}
+	}
+}
+
+// TestCheckpointResume stops a run partway through, leaves the outputs and the
+// checkpoint cut mid-write as by a crash, and resumes it
+func TestCheckpointResume(t *testing.T) {
+	constraints, microData, header, popConfig, config := testRunInputs(t, 30)
+	popConfig.Checkpoint.File = filepath.Join(t.TempDir(), "checkpoint.jsonl")
+
+	// The areas in the queues and being synthesized when the run is stopped are
+	// completed, the others are left for the resumed run
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results := make(chan Result)
+	done := make(chan error, 1)
+	go func() {
+		done <- run(ctx, constraints, microData, header, popConfig, config, runHooks{results: results})
+	}()
+	var err error
+	for received := 0; ; {
+		select {
+		case <-results:
+			if received++; received == 2 {
+				cancel()
+			}
+			continue
+		case err = <-done:
+		}
+		break
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("stopped run returned %v, want it cancelled", err)
+	}
+	entries, _, err := readCheckpoint(popConfig.Checkpoint.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) < 2 || len(entries) >= len(constraints) {
+		t.Fatalf("stopped run completed %d of %d areas", len(entries), len(constraints))
+	}
+
+	// A crash while writing the next area: part of its rows and of its entry
+	appendFile := func(file, data string) {
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	appendFile(popConfig.Output.File, "E29,r1\nE29,r2\nE29,")
+	appendFile(popConfig.Validate.File, "E29,1,0")
+	appendFile(popConfig.Checkpoint.File, `{"area":"E29","idsOff`)
+
+	popConfig.Checkpoint.Resume = true
+	if err := run(context.Background(), constraints, microData, header, popConfig, config, runHooks{}); err != nil {
+		t.Fatal(err)
+	}
+	checkRunOutputs(t, popConfig, header, constraints)
+	entries, _, err = readCheckpoint(popConfig.Checkpoint.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded := make(map[string]int)
+	for _, entry := range entries {
+		recorded[entry.Area]++
+	}
+	for _, c := range constraints {
+		if recorded[c.ID] != 1 {
+			t.Errorf("area %s recorded %d times in the checkpoint, want once", c.ID, recorded[c.ID])
+		}
+	}
+
+	// Resuming a completed run writes nothing more
+	before, err := os.ReadFile(popConfig.Output.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := run(context.Background(), constraints, microData, header, popConfig, config, runHooks{}); err != nil {
+		t.Fatal(err)
+	}
+	if after, err := os.ReadFile(popConfig.Output.File); err != nil || string(after) != string(before) {
+		t.Errorf("resuming a completed run changed the IDs file (%v)", err)
+	}
+}
@@ -0,0 +1,184 @@
+package synthpop
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Compact microdata: large microdata (SPENSER-scale files have tens of millions of
+// rows) repeat the same few thousand combinations of values. The compact loader
+// stores every distinct row of values once, in flat blocks, and points the Values
+// of every record holding it at that copy; each record then costs its ID and a
+// slice header. The records are unchanged for the annealer, so selection and the
+// outputs are the same as with the plain loader.
+//
+// Values stay float64: the annealer sums them in its inner loop, and with the
+// duplicates shared a float32 copy would save little.
+
+// compactBlockSize is the number of values per flat block of distinct rows
+const compactBlockSize = 1 << 16
+
+// compactStore holds distinct rows of values in flat blocks. Blocks are never
+// reallocated, so the rows handed out stay valid.
+type compactStore struct {
+	block []float64
+	rows  map[string][]float64 // Binary encoding of a row -> its stored copy
+	key   []byte
+}
+
+func newCompactStore() *compactStore {
+	return &compactStore{rows: make(map[string][]float64)}
+}
+
+// intern returns the stored copy of values, storing it on first sight
+func (s *compactStore) intern(values []float64) []float64 {
+	s.key = s.key[:0]
+	for _, v := range values {
+		s.key = binary.LittleEndian.AppendUint64(s.key, math.Float64bits(v))
+	}
+	if row, ok := s.rows[string(s.key)]; ok {
+		return row
+	}
+	if len(values) > cap(s.block)-len(s.block) {
+		s.block = make([]float64, 0, max(compactBlockSize, len(values)))
+	}
+	start := len(s.block)
+	s.block = append(s.block, values...)
+	row := s.block[start:len(s.block):len(s.block)]
+	s.rows[string(s.key)] = row
+	return row
+}
+
+// ReadMicroDataCSVCompact reads microdata like ReadMicroDataCSVContext, but stores
+// every distinct row of values once and shares it between the records holding it
+//
+// Parameters:
+//   - ctx: Stops the load with an error once done
+//   - filename: Path to the microdata CSV
+//   - progress: Receives load progress (may be nil)
+//
+// Returns:
+//   - []MicroData: The records, whose Values must not be modified
+//   - []string: The variable names
+//   - error: Any error opening, reading or cancelling the load
+func ReadMicroDataCSVCompact(ctx context.Context, filename string, progress chan<- LoadProgress) ([]MicroData, []string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	tracker := newLoadTracker(ctx, filename, info.Size(), progress)
+	defer tracker.finish()
+
+	in, err := decompress(filename, tracker.reader(file))
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := csv.NewReader(in)
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open file %s: %w", filename, err)
+	}
+	header = append([]string(nil), header...)
+
+	store := newCompactStore()
+	var values []float64
+	var data []MicroData
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err := tracker.err(); err != nil {
+			return nil, nil, err
+		}
+		if err != nil {
+			log.Printf("Error reading row: %v", err)
+			continue
+		}
+
+		values = floatBuffer(values, len(row)-1)
+		for i, v := range row[1:] {
+			num, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				log.Printf("Invalid integer in row %v: %v", row, err)
+				continue // Left at 0, as in ReadMicroDataCSV
+			}
+			values[i] = num
+		}
+
+		tracker.rows.Add(1)
+		// Clone the ID, the reader's fields share one string per line
+		data = append(data, MicroData{ID: strings.Clone(row[0]), Values: store.intern(values)})
+	}
+	Printf("🗜️ Compacted %d microdata records to %d distinct rows of values\n", len(data), len(store.rows))
+	return data, header[1:], nil
+}
+
+// compactMicroData shares the duplicate rows of values of loaded microdata, as
+// ReadMicroDataCSVCompact does while reading
+func compactMicroData(microData []MicroData) []MicroData {
+	store := newCompactStore()
+	out := make([]MicroData, len(microData))
+	for i, md := range microData {
+		md.Values = store.intern(md.Values)
+		out[i] = md
+	}
+	Printf("🗜️ Compacted %d microdata records to %d distinct rows of values\n", len(out), len(store.rows))
+	return out
+}
+
+// sharedRowsProbe is how many records mapRows checks for shared rows before it
+// stops looking for them
+const sharedRowsProbe = 1024
+
+// mapRows returns a copy of microData with fn applied to the values of every
+// record. Records whose Values share a row (compact microdata) keep sharing the
+// mapped row; when the first sharedRowsProbe records share none, the data is
+// taken to be plain and the remaining rows are mapped without the bookkeeping.
+func mapRows(microData []MicroData, fn func(md MicroData) ([]float64, error)) ([]MicroData, error) {
+	out := make([]MicroData, len(microData))
+	mapped := make(map[*float64][]float64)
+	shared := false
+	for i, md := range microData {
+		if mapped != nil && i == sharedRowsProbe && !shared {
+			mapped = nil
+		}
+		if mapped == nil || len(md.Values) == 0 {
+			values, err := fn(md)
+			if err != nil {
+				return nil, err
+			}
+			md.Values = values
+			out[i] = md
+			continue
+		}
+		values, ok := mapped[&md.Values[0]]
+		if ok {
+			shared = true
+		} else {
+			var err error
+			if values, err = fn(md); err != nil {
+				return nil, err
+			}
+			mapped[&md.Values[0]] = values
+		}
+		md.Values = values
+		out[i] = md
+	}
+	return out, nil
+}
@@ -0,0 +1,75 @@
+package synthpop
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// BenchmarkMicroDataLayout synthesizes the same area from the same records laid out
+// as loaded (a row allocated per record) and with the duplicate rows shared
+// (compactMicroData)
+func BenchmarkMicroDataLayout(b *testing.B) {
+	const variables, records, distinct = 20, 50000, 2000
+	rng := rand.New(rand.NewSource(9))
+	prototypes := testMicrodata(rng, distinct, variables, false)
+	loaded := make([]MicroData, records)
+	for i := range loaded {
+		// The IDs are allocated between the rows, as by the loaders
+		loaded[i] = MicroData{ID: "r" + strconv.Itoa(i), Values: append([]float64(nil), prototypes[rng.Intn(distinct)].Values...)}
+	}
+	header := testHeader(variables)
+	constraint := testConstraint(rng, variables, 500)
+	config := testConfig()
+
+	for _, layout := range []struct {
+		name      string
+		microData []MicroData
+	}{
+		{"loaded", loaded},
+		{"compact", compactMicroData(loaded)},
+	} {
+		b.Run(layout.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := SynthesizeArea(constraint, layout.microData, header, config, rand.New(rand.NewSource(1))); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestMicrodataCacheCompact checks that a file loaded plain and compacted is cached
+// as two entries, each served to the loads of its own kind
+func TestMicrodataCacheCompact(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "micro.csv")
+	if err := os.WriteFile(file, []byte("pid,male,female\nP0,1,0\nP1,0,1\nP2,1,0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := NewController()
+	for _, tt := range []struct {
+		compact, cached bool
+	}{
+		{false, false},
+		{true, false},
+		{false, true},
+		{true, true},
+	} {
+		data, _, cached, err := c.microdata(context.Background(), file, "csv", tt.compact, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cached != tt.cached {
+			t.Errorf("compact %v: cached %v, want %v", tt.compact, cached, tt.cached)
+		}
+		// Compacted records share the stored copy of their row
+		shared := &data[0].Values[0] == &data[2].Values[0]
+		if shared != tt.compact {
+			t.Errorf("compact %v: rows shared %v", tt.compact, shared)
+		}
+	}
+}
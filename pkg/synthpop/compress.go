@@ -0,0 +1,59 @@
+package synthpop
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Suffixes of compressed inputs and outputs. A compressed file keeps the extension
+// of its contents before the suffix, e.g. ids.csv.gz.
+const (
+	CompressionGzip = ".gz"
+	CompressionZstd = ".zst"
+)
+
+// compressionOf returns the compression suffix of path, "" for an uncompressed file
+func compressionOf(path string) string {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case CompressionGzip, CompressionZstd:
+		return ext
+	}
+	return ""
+}
+
+// trimCompression returns path without its compression suffix
+func trimCompression(path string) string {
+	return path[:len(path)-len(compressionOf(path))]
+}
+
+// decompress returns the contents of r, read from path, decompressed as the suffix
+// of path calls for
+func decompress(path string, r io.Reader) (io.Reader, error) {
+	switch compressionOf(path) {
+	case CompressionGzip:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return gz, nil
+	case CompressionZstd:
+		return newZstdReader(r), nil
+	}
+	return r, nil
+}
+
+// newCompressor returns a writer compressing to w as the suffix of path calls for,
+// nil for an uncompressed path. Closing it completes the compressed stream but does
+// not close w.
+func newCompressor(path string, w io.Writer) io.WriteCloser {
+	switch compressionOf(path) {
+	case CompressionGzip:
+		return gzip.NewWriter(w)
+	case CompressionZstd:
+		return newZstdWriter(w)
+	}
+	return nil
+}
@@ -0,0 +1,544 @@
+package synthpop
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strings"
+)
+
+// AnnealingConfig holds the simulated annealing parameters (annealing_config.json).
+type AnnealingConfig struct {
+	InitialTemp      float64 `json:"initialTemp"`
+	MinTemp          float64 `json:"minTemp"`
+	CoolingRate      float64 `json:"coolingRate"`
+	ReheatFactor     float64 `json:"reheatFactor"`
+	FitnessThreshold float64 `json:"fitnessThreshold"`
+	MinImprovement   float64 `json:"minImprovement"`
+	MaxIterations    int     `json:"maxIterations"`
+	WindowSize       int     `json:"windowSize"` // Stagnation window (0 adapts it to each area)
+	Change           int     `json:"change"`
+	Distance         string  `json:"distance"`
+	UseRandomSeed    string  `json:"useRandomSeed"`
+	RandomSeed       *int64  `json:"randomSeed,omitempty"` // Optional seed for reproducibility
+
+	// Experimental: give every constraint variable its own temperature
+	PerVariableTemperature bool `json:"perVariableTemperature"`
+
+	// How equally fit solutions are ranked: "first" (default) or "hash"
+	TieBreak string `json:"tieBreak"`
+
+	// Which moves making the fitness worse are kept: "metropolis" (default),
+	// "greedy" or "threshold"
+	Acceptance string `json:"acceptance"`
+
+	// Optional per-group metrics and weights; ungrouped variables use Distance
+	VariableGroups []VariableGroup `json:"variableGroups,omitempty"`
+
+	// Optional weight per variable (header name -> weight, default 1) scaling its
+	// contribution to the distance, e.g. to prioritise total population or age bands
+	VariableWeights map[string]float64 `json:"variableWeights,omitempty"`
+
+	// Subpopulation of variables that apply to part of the area only (variable ->
+	// variables summing to it), e.g. "employed": ["age16_64", "age65p"]. They are
+	// fitted and validated as shares of that subpopulation instead of counts.
+	Denominators map[string][]string `json:"denominators,omitempty"`
+
+	// Rounding base of the constraints (e.g. 3 or 5 for randomly rounded census
+	// tables): deviations the rounding can explain count as no error (0 disables)
+	RoundingBase int `json:"roundingBase"`
+
+	// Variables whose constraints must be met: every unit of deviation adds
+	// HardPenalty (default DefaultHardPenalty) to the fitness, on top of the distance
+	// the remaining variables are fitted by
+	HardConstraints []string `json:"hardConstraints,omitempty"`
+	HardPenalty     float64  `json:"hardPenalty"`
+
+	// Distance metrics defined by expressions, usable by name in Distance and the
+	// variable groups, e.g. "SQUARED_REL": {"term": "(t - c) * (t - c) / (c + 1)"}
+	Metrics map[string]MetricExpression `json:"metrics,omitempty"`
+
+	// Synthesis algorithm: "annealing" (default), "ipf", "ga" or "tabu", with the
+	// settings of the IPF, genetic algorithm and tabu search
+	Algorithm string     `json:"algorithm"`
+	IPF       IPFConfig  `json:"ipf"`
+	GA        GAConfig   `json:"ga"`
+	Tabu      TabuConfig `json:"tabu"`
+
+	// Time budgets (0 for none): the search of an area stops with its best solution
+	// so far after MaxSecondsPerArea, and the run stops handing out areas after
+	// MaxTotalMinutes, listing those left in the failed areas file
+	MaxSecondsPerArea float64 `json:"maxSecondsPerArea"`
+	MaxTotalMinutes   float64 `json:"maxTotalMinutes"`
+
+	// Independent runs per area, keeping the best (0 or 1 runs once)
+	Restarts int `json:"restarts"`
+
+	// Optional iteration budget of every area scaled to its population or number
+	// of constraint cells, replacing MaxIterations
+	IterationScaling IterationScaling `json:"iterationScaling"`
+
+	// Parallel tempering of the annealing: several chains per area at different
+	// temperatures exchanging their populations, for large areas where one chain
+	// stagnates
+	Tempering TemperingConfig `json:"tempering"`
+
+	// Algorithm of particular areas (area ID -> algorithm), e.g. tabu search for
+	// the areas where annealing stalls
+	AreaAlgorithms map[string]string `json:"areaAlgorithms,omitempty"`
+}
+
+// ValidMetrics lists the accepted values of AnnealingConfig.Distance
+var ValidMetrics = []string{"CHI_SQUARED", "EUCLIDEAN", "NORM_EUCLIDEAN", "MANHATTEN", "KL_DIVERGENCE", "COSINE", "JSDIVERGENCE"}
+
+// PopulationConfig holds the input and output file settings of a run (config.json).
+type PopulationConfig struct {
+	// Short name of the run, shown in the logs and substituted for {run} in the
+	// output paths (default: a generated name such as "misty-falcon-42")
+	RunName     string `json:"runName"`
+	Constraints struct {
+		File   string `json:"file"`
+		Format string `json:"format"` // "csv" or "parquet" (default from the file extension)
+		// A PostgreSQL query read instead of File (dsn and query)
+		PostgresSource
+		// Several tables read instead of File, e.g. cross-tabulations, joined by area
+		// and fitted as a weighted sum over the tables
+		Tables []ConstraintTable `json:"tables,omitempty"`
+	} `json:"constraints"`
+	Microdata struct {
+		File   string `json:"file"`
+		Format string `json:"format"` // "csv" or "parquet" (default from the file extension)
+		// A PostgreSQL query read instead of File (dsn and query)
+		PostgresSource
+		// Store every distinct row of values once, shared by the records holding it
+		Compact bool `json:"compact"`
+		// Optional CSV of further attributes per record (microdata ID, then any
+		// columns), joined to every synthetic individual in Output.IndividualsFile
+		AttributesFile string `json:"attributesFile"`
+		// Optional column of survey design weights, by which the records are drawn;
+		// it is not a constraint variable
+		WeightColumn string `json:"weightColumn"`
+		// Optional column of numeric region codes and CSV of the region of every area
+		// (area ID, region code): each area then draws donors from its region only
+		RegionColumn     string `json:"regionColumn"`
+		RegionLookupFile string `json:"regionLookupFile"`
+	} `json:"microdata"`
+	Output struct {
+		File            string `json:"file"`
+		Format          string `json:"format"`          // Format of File: "csv" or "parquet" (default from the extension)
+		Layout          string `json:"layout"`          // Rows of File: "ids", one per individual, or "counts", one per area and record (default ids)
+		AggregateOnly   bool   `json:"aggregateOnly"`   // Skip the ID mapping output, write only the aggregate tables
+		Encrypt         bool   `json:"encrypt"`         // AES-GCM encrypt outputs with the key in GOSYNTHPOP_KEY
+		Retries         int    `json:"retries"`         // Retries for failed output create/write/flush operations
+		RetryBackoffMs  int    `json:"retryBackoffMs"`  // Initial retry delay, doubled after each attempt (default 500)
+		AgentsFile      string `json:"agentsFile"`      // Optional agents CSV (agent id, area, attributes)
+		MatsimFile      string `json:"matsimFile"`      // Optional MATSim population XML
+		IndividualsFile string `json:"individualsFile"` // Optional person-level CSV (person id, area, microdata id, Microdata.AttributesFile columns)
+		GeoJSONFile     string `json:"geojsonFile"`     // Optional boundaries joined with per-area fitness and errors
+		InclusionFile   string `json:"inclusionFile"`   // Optional per-area record inclusion probabilities
+		Append          bool   `json:"append"`          // Synthesize only areas missing from the existing outputs
+		WeightsFile     string `json:"weightsFile"`     // Fractional IPF weights per area and record
+		FailedAreasFile string `json:"failedAreasFile"` // Areas that could not be synthesized (default failed_areas.csv next to validate.file)
+		TraceFile       string `json:"traceFile"`       // Optional per-area convergence trace (iteration, temperature, fitness, accepted)
+		TraceInterval   int    `json:"traceInterval"`   // Trace sampling interval in iterations (default 100)
+		DiagnosticsFile string `json:"diagnosticsFile"` // Optional per-area move statistics and final temperature of the annealing
+		SQLiteFile      string `json:"sqliteFile"`      // Optional SQLite database of the population counts and totals per area
+		ManifestFile    string `json:"manifestFile"`    // Run manifest (default run_manifest.json next to validate.file)
+		BundleFile      string `json:"bundleFile"`      // Optional .zip or .tar.gz of the outputs, logs, manifest and configs
+		// Optional PostgreSQL database the population counts and totals are copied into
+		Postgres PostgresOutput `json:"postgres"`
+		// Output names of constraint variables (variable -> column name) used in every
+		// output that names variables, e.g. "age_0_15": "SCT-0001"
+		Rename map[string]string `json:"rename"`
+	} `json:"output"`
+	// Coarser geography the areas nest in, e.g. the MSOAs holding LSOAs, whose
+	// marginals the synthetic populations fit as well
+	Parent struct {
+		ConstraintsFile string `json:"constraintsFile"` // Parent constraints, laid out like Constraints.File
+		LookupFile      string `json:"lookupFile"`      // Area ID, parent ID of every area
+		ValidateFile    string `json:"validateFile"`    // Optional per parent and variable synthetic, constraint and errors
+	} `json:"parent"`
+	Validate struct {
+		File        string `json:"file"`
+		Format      string `json:"format"`      // "csv" or "parquet" (default from the file extension)
+		ErrorsFile  string `json:"errorsFile"`  // Optional per area and variable synthetic, constraint, absolute and percentage error
+		SummaryFile string `json:"summaryFile"` // Optional per area TAE and SAE
+		// Pairs of variables whose two-way interaction is compared with the microdata
+		// in InteractionsFile, e.g. [["male", "employed"]]
+		Interactions     [][]string `json:"interactions"`
+		InteractionsFile string     `json:"interactionsFile"`
+	} `json:"validate"`
+	Boundaries struct {
+		File       string   `json:"file"`       // GeoJSON FeatureCollection of area boundaries
+		IDProperty string   `json:"idProperty"` // Feature property holding the area code
+		Variables  []string `json:"variables"`  // Variables whose errors are joined (default all)
+	} `json:"boundaries"`
+	// Spatial autocorrelation diagnostic: Moran's I of the per-area fitness over the
+	// neighbour pairs of File, reported after the run
+	Adjacency struct {
+		File       string `json:"file"`       // CSV of neighbouring area pairs (area, neighbour)
+		ReportFile string `json:"reportFile"` // Optional JSON of the diagnostic
+	} `json:"adjacency"`
+	Status struct {
+		File            string `json:"file"`            // Heartbeat JSON rewritten while the run progresses
+		IntervalSeconds int    `json:"intervalSeconds"` // Heartbeat period (default 10)
+		MetricsAddr     string `json:"metricsAddr"`     // Address serving Prometheus /metrics during the run, e.g. ":9100"
+	} `json:"status"`
+	Holdout struct {
+		Fraction float64 `json:"fraction"` // Share of microdata withheld from synthesis (0 disables)
+		File     string  `json:"file"`     // Output CSV of held-out vs synthetic joint pattern shares
+	} `json:"holdout"`
+	// Household-person joint synthesis: households are selected to fit household and
+	// person constraints together; Constraints and Microdata hold the person level
+	Households struct {
+		File              string `json:"file"`              // Household microdata CSV (household ID, household variables)
+		ConstraintsFile   string `json:"constraintsFile"`   // Household constraints CSV (area, households, household variables)
+		LinkColumn        string `json:"linkColumn"`        // Column of the person microdata holding the household ID
+		PersonsOutputFile string `json:"personsOutputFile"` // Optional persons of the synthetic households
+		// Person microdata columns holding household attributes (e.g. tenure), used
+		// instead of File to aggregate the households from the person microdata
+		Variables []string `json:"variables"`
+	} `json:"households"`
+	// How constraint and microdata variables are matched: "exact" (default) or
+	// "intersection" to ignore columns present in only one of the inputs
+	HeaderMode string `json:"headerMode"`
+	// Derived columns (name -> expression over the input columns) computed at load
+	// for both constraints and microdata, e.g. "econ_active": "employed + unemployed"
+	Derived map[string]string `json:"derived"`
+	// Seconds after which loading the constraints and microdata is abandoned with an
+	// error (0 waits indefinitely)
+	LoadTimeoutSeconds int `json:"loadTimeoutSeconds"`
+	// Number of areas synthesized in parallel (default the number of CPUs), and
+	// the GOMAXPROCS of the run (default unchanged) to cap its CPU use on shared
+	// servers. GOMAXPROCS is process-wide, so only the CLI's runs and benchmark apply
+	// MaxProcs; Run and the Controller leave it to the process hosting them.
+	Workers  int `json:"workers"`
+	MaxProcs int `json:"maxProcs"`
+	// Order the areas are handed to the workers in: "file" (default) or "largest"
+	// to start the most populous areas first and shorten the tail of the run
+	Scheduling string `json:"scheduling"`
+	Checkpoint struct {
+		File   string `json:"file"`   // JSONL of completed areas and output offsets (empty disables)
+		Resume bool   `json:"resume"` // Skip completed areas and append to the existing outputs
+	} `json:"checkpoint"`
+	// Optional ID mapping output of a previous run, in either layout, whose
+	// populations the areas start from instead of random ones (warm start)
+	SeedPopulationFile string `json:"seedPopulationFile"`
+	// Post-run quality gate: the run fails when more than MaxPoorShare of the areas
+	// have a fitness above FitnessThreshold or could not be synthesized, or when any
+	// area exceeds HardCap. Disabled unless FitnessThreshold or HardCap is set.
+	QualityGate struct {
+		FitnessThreshold float64 `json:"fitnessThreshold"`
+		MaxPoorShare     float64 `json:"maxPoorShare"` // Share of poor areas that still passes (0-1, default 0)
+		HardCap          float64 `json:"hardCap"`
+	} `json:"qualityGate"`
+	// Directory watched for constraint files delivered while the run progresses; their
+	// new areas are added to the run, which ends once a file named DONE appears there
+	Watch struct {
+		Dir             string `json:"dir"`
+		IntervalSeconds int    `json:"intervalSeconds"` // Polling period (default 5)
+	} `json:"watch"`
+	// Message sent when the run finishes or fails, to a chat webhook and/or by email
+	Notifications NotificationConfig `json:"notifications"`
+	Debug         struct {
+		// One log file per worker (worker-03.log) with area start/finish, accept and
+		// reject rates and warnings, instead of interleaving them on the console
+		WorkerLogs bool   `json:"workerLogs"`
+		Dir        string `json:"dir"` // Directory of the worker logs (default "logs")
+	} `json:"debug"`
+}
+
+// HouseholdSynthesis reports whether the config asks for household-person joint
+// synthesis, from a household microdata file or aggregated from the persons
+func (c PopulationConfig) HouseholdSynthesis() bool {
+	return c.Households.File != "" || len(c.Households.Variables) > 0
+}
+
+// LoadConfig loads the population configuration from a JSON file.
+func LoadConfig(configFileName string) (PopulationConfig, error) {
+	var config PopulationConfig
+	file, err := os.Open(configFileName)
+	if err != nil {
+		return config, fmt.Errorf("error opening config file: %w", err)
+	}
+	defer file.Close()
+
+	if err := json.NewDecoder(file).Decode(&config); err != nil {
+		return config, fmt.Errorf("error decoding config JSON: %w", err)
+	}
+	return config, config.Check()
+}
+
+// Check checks the values of the config, e.g. after overriding some of them
+func (config PopulationConfig) Check() error {
+	switch config.HeaderMode {
+	case "", HeaderExact, HeaderIntersection:
+	default:
+		return fmt.Errorf("invalid headerMode '%s'. Must be one of: %s, %s",
+			config.HeaderMode, HeaderExact, HeaderIntersection)
+	}
+	for _, f := range []struct{ section, file, format string }{
+		{"constraints", config.Constraints.File, config.Constraints.Format},
+		{"microdata", config.Microdata.File, config.Microdata.Format},
+		{"output", config.Output.File, config.Output.Format},
+		{"validate", config.Validate.File, config.Validate.Format},
+	} {
+		if err := checkFormat(f.section, f.format); err != nil {
+			return err
+		}
+		if compressionOf(f.file) != "" && fileFormat(f.file, f.format) == FormatParquet {
+			return fmt.Errorf("%s.file: Parquet files cannot be compressed with %s", f.section, compressionOf(f.file))
+		}
+	}
+	if tables := config.Constraints.Tables; len(tables) > 0 {
+		if config.Constraints.File != "" || config.Constraints.DSN != "" {
+			return fmt.Errorf("constraints: set either file, dsn or tables, not several")
+		}
+		if config.HouseholdSynthesis() {
+			return fmt.Errorf("constraints.tables are not supported with households, which read linked files")
+		}
+		if config.Watch.Dir != "" {
+			return fmt.Errorf("constraints.tables are not supported with watch.dir")
+		}
+		for i, t := range tables {
+			if t.File == "" {
+				return fmt.Errorf("constraints.tables[%d] needs a file", i)
+			}
+			if err := checkFormat(fmt.Sprintf("constraints.tables[%d]", i), t.Format); err != nil {
+				return err
+			}
+			if compressionOf(t.File) != "" && fileFormat(t.File, t.Format) == FormatParquet {
+				return fmt.Errorf("constraints.tables[%d].file: Parquet files cannot be compressed with %s", i, compressionOf(t.File))
+			}
+			if t.Weight < 0 {
+				return fmt.Errorf("constraints.tables[%d].weight must not be negative", i)
+			}
+		}
+	}
+	switch config.Output.Layout {
+	case "", OutputLayoutIDs, OutputLayoutCounts:
+	default:
+		return fmt.Errorf("invalid output.layout '%s'. Must be one of: %s, %s",
+			config.Output.Layout, OutputLayoutIDs, OutputLayoutCounts)
+	}
+	if config.Output.BundleFile != "" && bundleFormat(config.Output.BundleFile) == "" {
+		return fmt.Errorf("output.bundleFile must end in .zip, .tar.gz or .tgz")
+	}
+	if config.Output.SQLiteFile != "" && config.Output.Encrypt {
+		return fmt.Errorf("output.sqliteFile cannot be encrypted, it is written to be queried in place")
+	}
+	if compressionOf(config.Output.SQLiteFile) != "" {
+		return fmt.Errorf("output.sqliteFile cannot be compressed, it is written to be queried in place")
+	}
+	for _, in := range []struct {
+		section, file string
+		source        PostgresSource
+	}{
+		{"constraints", config.Constraints.File, config.Constraints.PostgresSource},
+		{"microdata", config.Microdata.File, config.Microdata.PostgresSource},
+	} {
+		if in.source.DSN != "" && in.source.Query == "" {
+			return fmt.Errorf("%s.dsn needs a query", in.section)
+		}
+		if in.source.DSN != "" && in.file != "" {
+			return fmt.Errorf("%s: set either file or dsn, not both", in.section)
+		}
+		if in.source.DSN != "" && config.HouseholdSynthesis() {
+			return fmt.Errorf("%s.dsn is not supported with households, which read linked files", in.section)
+		}
+	}
+	if config.Microdata.WeightColumn != "" && config.HouseholdSynthesis() {
+		return fmt.Errorf("microdata.weightColumn is not supported with households")
+	}
+	if (config.Microdata.RegionColumn == "") != (config.Microdata.RegionLookupFile == "") {
+		return fmt.Errorf("microdata needs both regionColumn and regionLookupFile")
+	}
+	if config.Microdata.RegionColumn != "" && config.HouseholdSynthesis() {
+		return fmt.Errorf("microdata.regionColumn is not supported with households")
+	}
+	if config.Output.IndividualsFile != "" && config.Microdata.AttributesFile == "" {
+		return fmt.Errorf("output.individualsFile needs microdata.attributesFile")
+	}
+	if p := config.Parent; (p.ConstraintsFile == "") != (p.LookupFile == "") ||
+		p.ValidateFile != "" && p.ConstraintsFile == "" {
+		return fmt.Errorf("parent needs both constraintsFile and lookupFile")
+	}
+	if config.Parent.ConstraintsFile != "" && config.HouseholdSynthesis() {
+		return fmt.Errorf("parent constraints are not supported with households")
+	}
+	if config.Output.Postgres.BatchSize < 0 {
+		return fmt.Errorf("output.postgres.batchSize must not be negative")
+	}
+	if config.Output.TraceInterval < 0 {
+		return fmt.Errorf("output.traceInterval must not be negative")
+	}
+	if config.LoadTimeoutSeconds < 0 {
+		return fmt.Errorf("loadTimeoutSeconds must not be negative")
+	}
+	if config.Workers < 0 {
+		return fmt.Errorf("workers must not be negative")
+	}
+	if g := config.QualityGate; g.FitnessThreshold < 0 || g.HardCap < 0 || g.MaxPoorShare < 0 || g.MaxPoorShare > 1 {
+		return fmt.Errorf("qualityGate: thresholds must not be negative and maxPoorShare must be between 0 and 1")
+	}
+	if config.Watch.IntervalSeconds < 0 {
+		return fmt.Errorf("watch.intervalSeconds must not be negative")
+	}
+	if config.MaxProcs < 0 {
+		return fmt.Errorf("maxProcs must not be negative")
+	}
+	if err := checkScheduling(config.Scheduling); err != nil {
+		return err
+	}
+	if err := config.Notifications.check(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// LoadAnnealingConfig loads annealing parameters from a JSON file.
+func LoadAnnealingConfig(annealingFileName string) (AnnealingConfig, error) {
+	var config AnnealingConfig
+
+	file, err := os.Open(annealingFileName)
+	if err != nil {
+		return config, fmt.Errorf("error opening config: %w", err)
+	}
+	defer file.Close()
+
+	if err := json.NewDecoder(file).Decode(&config); err != nil {
+		return config, fmt.Errorf("invalid config format: %w", err)
+	}
+	return config, config.Check()
+}
+
+// ParseConfig returns the population config given inline as JSON, or loads it
+// from the file whose path is given as it is or as a JSON string. The C libraries
+// take their configs in this form.
+func ParseConfig(config string) (PopulationConfig, error) {
+	return parseConfig(config, LoadConfig)
+}
+
+// ParseAnnealingConfig returns the annealing config given inline as JSON, or loads
+// it from the file whose path is given as it is or as a JSON string.
+func ParseAnnealingConfig(config string) (AnnealingConfig, error) {
+	return parseConfig(config, LoadAnnealingConfig)
+}
+
+// parseConfig decodes a config given as a JSON object, or loads it with load from
+// the path given as a JSON string, or as it is when s is not JSON
+func parseConfig[T interface{ Check() error }](s string, load func(string) (T, error)) (T, error) {
+	var config T
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return config, fmt.Errorf("missing")
+	}
+	path := s
+	if !json.Valid([]byte(s)) || json.Unmarshal([]byte(s), &path) == nil {
+		return load(path)
+	}
+	if err := json.Unmarshal([]byte(s), &config); err != nil {
+		return config, fmt.Errorf("error decoding config JSON: %w", err)
+	}
+	return config, config.Check()
+}
+
+// Check checks the values of the annealing config, e.g. after overriding some
+// of them
+func (config AnnealingConfig) Check() error {
+
+	// Validate distance metric, built in, registered or defined in Metrics
+	if err := checkMetrics(config); err != nil {
+		return err
+	}
+	if !slices.Contains(metricNames(config), config.Distance) {
+		return fmt.Errorf(
+			"invalid distance metric '%s'. Must be one of: %v",
+			config.Distance,
+			metricNames(config),
+		)
+	}
+
+	switch config.TieBreak {
+	case "", TieBreakFirst, TieBreakHash:
+	default:
+		return fmt.Errorf("invalid tieBreak '%s'. Must be one of: %s, %s",
+			config.TieBreak, TieBreakFirst, TieBreakHash)
+	}
+
+	if config.Acceptance != "" && !slices.Contains(ValidAcceptanceRules, config.Acceptance) {
+		return fmt.Errorf("invalid acceptance '%s'. Must be one of: %s",
+			config.Acceptance, strings.Join(ValidAcceptanceRules, ", "))
+	}
+
+	if config.Algorithm != "" && !slices.Contains(validAlgorithms, config.Algorithm) {
+		return fmt.Errorf("invalid algorithm '%s'. Must be one of: %s",
+			config.Algorithm, strings.Join(validAlgorithms, ", "))
+	}
+	for area, algorithm := range config.AreaAlgorithms {
+		if !slices.Contains(validAlgorithms, algorithm) {
+			return fmt.Errorf("areaAlgorithms: invalid algorithm '%s' for area %s. Must be one of: %s",
+				algorithm, area, strings.Join(validAlgorithms, ", "))
+		}
+	}
+	if len(config.AreaAlgorithms) > 0 && config.IPF.Fractional {
+		return fmt.Errorf("areaAlgorithms cannot be combined with ipf.fractional")
+	}
+	if t := config.Tabu; t.ListSize < 0 || t.Tenure < 0 || t.Candidates < 0 {
+		return fmt.Errorf("tabu: settings must not be negative")
+	}
+	if t := config.Tempering; t.Replicas < 0 || t.TempRatio < 0 || t.ExchangeInterval < 0 || t.MinPopulation < 0 {
+		return fmt.Errorf("tempering: settings must not be negative")
+	}
+	if config.MaxSecondsPerArea < 0 || config.MaxTotalMinutes < 0 {
+		return fmt.Errorf("maxSecondsPerArea and maxTotalMinutes must not be negative")
+	}
+	if config.Restarts < 0 {
+		return fmt.Errorf("restarts must not be negative")
+	}
+	if r := config.Tempering.TempRatio; r != 0 && r <= 1 {
+		return fmt.Errorf("tempering: tempRatio must be above 1")
+	}
+	if config.Tempering.Replicas > 1 && config.PerVariableTemperature {
+		return fmt.Errorf("tempering cannot be combined with perVariableTemperature")
+	}
+	if config.Tempering.Replicas > 1 && config.Acceptance == AcceptGreedy {
+		return fmt.Errorf("tempering cannot be combined with greedy acceptance, which ignores the chain temperatures")
+	}
+	if ga := config.GA; ga.PopulationSize < 0 || ga.Generations < 0 || ga.Elite < 0 ||
+		ga.TournamentSize < 0 || ga.StallLimit < 0 || ga.MutationRate < 0 || ga.MutationRate > 1 {
+		return fmt.Errorf("ga: settings must not be negative and mutationRate must be between 0 and 1")
+	}
+
+	// Group and weight variables are checked against the header when the run starts
+	for name, weight := range config.VariableWeights {
+		if weight < 0 || math.IsNaN(weight) || math.IsInf(weight, 0) {
+			return fmt.Errorf("variableWeights: weight of '%s' must be a non-negative number", name)
+		}
+	}
+	if config.RoundingBase < 0 {
+		return fmt.Errorf("roundingBase must not be negative")
+	}
+	if config.HardPenalty < 0 {
+		return fmt.Errorf("hardPenalty must not be negative")
+	}
+	if err := config.IterationScaling.check(); err != nil {
+		return err
+	}
+	if strings.EqualFold(strings.TrimSpace(config.UseRandomSeed), "yes") && config.RandomSeed == nil {
+		return fmt.Errorf("useRandomSeed needs randomSeed")
+	}
+	if config.WindowSize < 0 {
+		return fmt.Errorf("windowSize must not be negative")
+	}
+	for _, g := range config.VariableGroups {
+		if g.Distance != "" && !slices.Contains(metricNames(config), g.Distance) {
+			return fmt.Errorf("variable group '%s': invalid distance metric '%s'. Must be one of: %v",
+				g.Name, g.Distance, metricNames(config))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package synthpop
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseAnnealingConfig(t *testing.T) {
+	config := testConfig()
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), `odd "name" \ here.json`)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	quoted, _ := json.Marshal(path)
+
+	tests := []struct {
+		name    string
+		in      string
+		wantErr string
+	}{
+		{"inline JSON", string(data), ""},
+		{"inline JSON with spaces", "\n  " + string(data) + " ", ""},
+		{"raw path", path, ""},
+		{"JSON string path", string(quoted), ""},
+		{"missing file", filepath.Join(t.TempDir(), "none.json"), "error opening config"},
+		{"empty", " ", "missing"},
+		{"invalid values", `{"distance": "NOPE"}`, "invalid distance metric"},
+		{"wrong type", `{"initialTemp": "hot"}`, "error decoding config JSON"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAnnealingConfig(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Distance != config.Distance || got.MaxIterations != config.MaxIterations || *got.RandomSeed != *config.RandomSeed {
+				t.Errorf("parsed %+v, want %+v", got, config)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package synthpop
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Console output of the package and of the command-line front-end goes through
+// Printf and Println, so emoji can be switched off in one place for terminals and
+// log aggregators that render them badly.
+var (
+	consoleMu    sync.Mutex
+	console      io.Writer = os.Stdout
+	plainConsole bool
+)
+
+// SetPlainConsole switches emoji in console output off (true) or back on
+func SetPlainConsole(plain bool) {
+	consoleMu.Lock()
+	defer consoleMu.Unlock()
+	plainConsole = plain
+}
+
+// Printf formats to the console like fmt.Printf
+func Printf(format string, args ...any) {
+	writeConsole(fmt.Sprintf(format, args...))
+}
+
+// Println prints to the console like fmt.Println
+func Println(args ...any) {
+	writeConsole(fmt.Sprintln(args...))
+}
+
+func writeConsole(s string) {
+	consoleMu.Lock()
+	defer consoleMu.Unlock()
+	if plainConsole {
+		s = StripEmoji(s)
+	}
+	io.WriteString(console, s)
+}
+
+// isEmoji reports whether r is a pictographic symbol used in console messages
+func isEmoji(r rune) bool {
+	switch {
+	case r >= 0x1F000 && r <= 0x1FAFF: // Pictographs, emoticons, transport, supplemental symbols
+		return true
+	case r >= 0x2300 && r <= 0x23FF: // Technical symbols such as ⏱ and ⏳
+		return true
+	case r >= 0x2600 && r <= 0x27BF: // Miscellaneous symbols and dingbats such as ⚠ and ✅
+		return true
+	case r >= 0x2B00 && r <= 0x2BFF, r == 0x25B6, r == 0x25C0:
+		return true
+	}
+	return false
+}
+
+// StripEmoji removes emoji from s, together with their variation selectors and
+// joiners and one space following them, so "✅ Done" becomes "Done"
+func StripEmoji(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	skipSpace := false
+	for _, r := range s {
+		switch {
+		case isEmoji(r):
+			skipSpace = true
+			continue
+		case r == 0xFE0F || r == 0x200D: // Variation selector-16, zero-width joiner
+			continue
+		case r == ' ' && skipSpace:
+			skipSpace = false
+			continue
+		}
+		skipSpace = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
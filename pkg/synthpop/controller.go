@@ -0,0 +1,610 @@
+package synthpop
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Controller runs population configs end to end: load the inputs, validate them
+// against the config, synthesize and report. The command-line subcommands go through
+// it, and so must any other front-end (GUI buttons, REST handlers), so that
+// validation, error handling and outputs cannot drift between interfaces.
+//
+// A Controller caches parsed inputs by path, so runs over several configs load
+// shared constraints and microdata only once. It is not safe for concurrent use.
+type Controller struct {
+	// Progress receives the progress of long input loads, and RunProgress the
+	// progress of the synthesis (areas done and total, elapsed time, ETA). When nil
+	// the controller prints them to the console. Reports are dropped while nobody is
+	// receiving.
+	Progress    chan<- LoadProgress
+	RunProgress chan<- RunProgress
+	// Results receives every synthesized area as soon as its outputs are written,
+	// so a front-end can show results before the run completes, e.g. collected in a
+	// ResultSet. It must be drained until Run returns, as the writer waits for it.
+	Results chan<- Result
+
+	constraintSets map[string]constraintSet
+	microdataSets  map[microdataKey]microdataSet
+}
+
+// Inputs are the loaded and validated inputs of one population config
+type Inputs struct {
+	Constraints []ConstraintData
+	MicroData   []MicroData
+	Header      []string // Variable names shared by Constraints and MicroData
+	// The variable group of every constraint table (see WithTableGroups)
+	Tables []VariableGroup
+}
+
+// fileVersion identifies the on-disk state of a cached input. Entries are keyed on
+// it as well as the path, so a long-lived session reparses a file only when it has
+// changed on disk.
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+func statVersion(file string) (fileVersion, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return fileVersion{}, err
+	}
+	return fileVersion{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+type constraintSet struct {
+	version fileVersion
+	data    []ConstraintData
+	header  []string
+}
+
+// microdataKey identifies cached microdata: a file loaded plain, compacted or
+// linked through a column are separate entries, as the compact records share their
+// values and the linked ones lack the link column
+type microdataKey struct {
+	file    string
+	compact bool
+	link    string
+}
+
+type microdataSet struct {
+	version fileVersion
+	data    []MicroData
+	header  []string
+	links   []string // The link of every record, for linked microdata
+}
+
+// NewController returns a controller with an empty input cache
+func NewController() *Controller {
+	return &Controller{
+		constraintSets: make(map[string]constraintSet),
+		microdataSets:  make(map[microdataKey]microdataSet),
+	}
+}
+
+// loadProgress returns the channel the readers report to, and a function to call
+// once the load is over. Files loaded together share the console line, each shown
+// with its latest report.
+func (c *Controller) loadProgress() (chan<- LoadProgress, func()) {
+	if c.Progress != nil {
+		return c.Progress, func() {}
+	}
+	progress := make(chan LoadProgress, 2)
+	printed := make(chan bool)
+	go func() {
+		var files []string
+		latest := make(map[string]LoadProgress)
+		for p := range progress {
+			if _, ok := latest[p.File]; !ok {
+				files = append(files, p.File)
+			}
+			latest[p.File] = p
+			if jsonEvents() {
+				emitLoad(p)
+				continue
+			}
+			line := make([]string, len(files))
+			for i, file := range files {
+				line[i] = latest[file].String()
+			}
+			Printf("\r%s", strings.Join(line, " | "))
+		}
+		printed <- len(files) > 0 && !jsonEvents()
+	}()
+	return progress, func() {
+		close(progress)
+		if <-printed {
+			Println()
+		}
+	}
+}
+
+// constraints returns the constraints in file, loading them on first use, and
+// whether they came from the cache
+func (c *Controller) constraints(ctx context.Context, file, format string, progress chan<- LoadProgress) ([]ConstraintData, []string, bool, error) {
+	version, err := statVersion(file)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if set, ok := c.constraintSets[file]; ok && set.version == version {
+		return set.data, set.header, true, nil
+	}
+	data, header, err := ReadConstraints(ctx, file, format, progress)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("failed to read constraints: %w", err)
+	}
+	c.constraintSets[file] = constraintSet{version: version, data: data, header: header}
+	return data, header, false, nil
+}
+
+// microdata returns the microdata in file, loading them on first use (compacted
+// when compact is set), and whether they came from the cache
+func (c *Controller) microdata(ctx context.Context, file, format string, compact bool, progress chan<- LoadProgress) ([]MicroData, []string, bool, error) {
+	version, err := statVersion(file)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	key := microdataKey{file: file, compact: compact}
+	if set, ok := c.microdataSets[key]; ok && set.version == version {
+		return set.data, set.header, true, nil
+	}
+	read := ReadMicroData
+	if compact {
+		read = ReadMicroDataCompact
+	}
+	data, header, err := read(ctx, file, format, progress)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("failed to read microdata: %w", err)
+	}
+	c.microdataSets[key] = microdataSet{version: version, data: data, header: header}
+	return data, header, false, nil
+}
+
+// linkedMicrodata returns the microdata in file with the link of every record (see
+// ReadLinkedMicroDataCSV), loading them on first use, and whether they came from
+// the cache
+func (c *Controller) linkedMicrodata(ctx context.Context, file, link string, progress chan<- LoadProgress) ([]MicroData,
+	[]string, []string, bool, error) {
+	version, err := statVersion(file)
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+	key := microdataKey{file: file, link: link}
+	if set, ok := c.microdataSets[key]; ok && set.version == version {
+		return set.data, set.header, set.links, true, nil
+	}
+	data, header, links, err := ReadLinkedMicroDataCSVContext(ctx, file, link, progress)
+	if err != nil {
+		return nil, nil, nil, false, fmt.Errorf("failed to read microdata: %w", err)
+	}
+	c.microdataSets[key] = microdataSet{version: version, data: data, header: header, links: links}
+	return data, header, links, false, nil
+}
+
+// loadedInputs are the inputs of a population config as loaded, before their
+// headers are matched
+type loadedInputs struct {
+	constraints      []ConstraintData
+	constraintHeader []string
+	microData        []MicroData
+	microDataHeader  []string
+
+	// Household-person joint runs: the household of every microdata record, the
+	// household constraints, and the household microdata unless they are
+	// aggregated from the persons
+	links                     []string
+	householdConstraints      []ConstraintData
+	householdConstraintHeader []string
+	households                []MicroData
+	householdHeader           []string
+}
+
+// loadBoth loads the constraints and microdata of popConfig concurrently, with
+// the household inputs of household-person joint runs. When one fails the others
+// are cancelled, so a missing file is reported without waiting for a national
+// microdata file to be parsed.
+func (c *Controller) loadBoth(ctx context.Context, popConfig PopulationConfig) (loadedInputs, error) {
+	households := popConfig.HouseholdSynthesis()
+	if households {
+		if err := checkHouseholds(popConfig); err != nil {
+			return loadedInputs{}, err
+		}
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	progress, done := c.loadProgress()
+
+	// The first failure is the one reported; the load it cancels fails after it
+	var (
+		firstErr error
+		once     sync.Once
+	)
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel(err)
+		})
+	}
+
+	var (
+		l                 loadedInputs
+		cachedConstraints bool
+		cachedHouseholds  bool
+		wg                sync.WaitGroup
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var err error
+		if popConfig.Constraints.DSN != "" {
+			// Query results are not cached: the tables may change between runs
+			l.constraints, l.constraintHeader, err = ReadConstraintsPostgres(ctx, popConfig.Constraints.PostgresSource, progress)
+		} else if len(popConfig.Constraints.Tables) > 0 {
+			l.constraints, l.constraintHeader, cachedConstraints, err = c.constraintTables(ctx,
+				popConfig.Constraints.Tables, progress)
+		} else {
+			l.constraints, l.constraintHeader, cachedConstraints, err = c.constraints(ctx,
+				popConfig.Constraints.File, popConfig.Constraints.Format, progress)
+		}
+		if err != nil {
+			fail(fmt.Errorf("constraint loading error: %w", err))
+		}
+	}()
+	if households {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hh := popConfig.Households
+			var err error
+			l.householdConstraints, l.householdConstraintHeader, cachedHouseholds, err = c.constraints(ctx,
+				hh.ConstraintsFile, "", progress)
+			if err == nil && hh.File != "" {
+				l.households, l.householdHeader, _, err = c.microdata(ctx, hh.File, "", false, progress)
+			}
+			if err != nil {
+				fail(fmt.Errorf("household loading error: %w", err))
+			}
+		}()
+	}
+	var (
+		cachedMicroData bool
+		err             error
+	)
+	switch {
+	case popConfig.Microdata.DSN != "":
+		l.microData, l.microDataHeader, err = ReadMicroDataPostgres(ctx, popConfig.Microdata.PostgresSource, progress)
+		if err == nil && popConfig.Microdata.Compact {
+			l.microData = compactMicroData(l.microData)
+		}
+	case households:
+		l.microData, l.microDataHeader, l.links, cachedMicroData, err = c.linkedMicrodata(ctx, popConfig.Microdata.File,
+			popConfig.Households.LinkColumn, progress)
+	default:
+		l.microData, l.microDataHeader, cachedMicroData, err = c.microdata(ctx, popConfig.Microdata.File,
+			popConfig.Microdata.Format, popConfig.Microdata.Compact, progress)
+	}
+	if err != nil {
+		fail(fmt.Errorf("microdata loading error: %w", err))
+	}
+	wg.Wait()
+	done()
+	if firstErr != nil {
+		return loadedInputs{}, firstErr
+	}
+
+	if cachedConstraints && len(popConfig.Constraints.Tables) > 0 {
+		Printf("Reusing %d loaded constraint areas from %d tables\n", len(l.constraints), len(popConfig.Constraints.Tables))
+	} else if cachedConstraints {
+		Printf("Reusing %d loaded constraint areas from %s\n", len(l.constraints), popConfig.Constraints.File)
+	} else {
+		Printf("Loaded %d constraint areas\n", len(l.constraints))
+	}
+	if cachedHouseholds {
+		Printf("Reusing %d loaded household constraint areas from %s\n", len(l.householdConstraints),
+			popConfig.Households.ConstraintsFile)
+	}
+	if cachedMicroData {
+		Printf("Reusing %d loaded microdata records from %s\n", len(l.microData), popConfig.Microdata.File)
+	} else {
+		Printf("Loaded %d microdata records\n", len(l.microData))
+	}
+	return l, nil
+}
+
+// Load loads the inputs of a population config and matches their headers by name
+// (see MatchHeaders, or IntersectHeaders in intersection mode), then
+// adds any derived columns. Constraint tables are joined by area, and the microdata
+// columns they do not constrain are dropped. The design weight and region columns
+// of the microdata become the Weight and Region of its records. Household-person
+// joint runs join their household and person inputs (see LoadHouseholdInputs).
+// Cached inputs are never modified, derived and selected columns are added to copies.
+//
+// Loading the constraints and microdata stops with an error once ctx is done or
+// popConfig.LoadTimeoutSeconds have passed.
+func (c *Controller) Load(ctx context.Context, popConfig PopulationConfig) (Inputs, error) {
+	if popConfig.LoadTimeoutSeconds > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, time.Duration(popConfig.LoadTimeoutSeconds)*time.Second,
+			fmt.Errorf("loadTimeoutSeconds (%d) exceeded", popConfig.LoadTimeoutSeconds))
+		defer cancel()
+	}
+
+	loaded, err := c.loadBoth(ctx, popConfig)
+	if err != nil {
+		return Inputs{}, err
+	}
+	var in Inputs
+	if popConfig.HouseholdSynthesis() {
+		if in, err = loaded.joinHouseholds(popConfig); err != nil {
+			return Inputs{}, err
+		}
+	} else {
+		constraints, constraintHeader := loaded.constraints, loaded.constraintHeader
+		microData, microDataHeader := loaded.microData, loaded.microDataHeader
+		if popConfig.Microdata.WeightColumn != "" {
+			microData, microDataHeader, err = applyDesignWeights(microData, microDataHeader, popConfig.Microdata.WeightColumn)
+			if err != nil {
+				return Inputs{}, err
+			}
+		}
+		if popConfig.Microdata.RegionColumn != "" {
+			microData, microDataHeader, err = applyRegions(microData, microDataHeader, popConfig.Microdata.RegionColumn)
+			if err != nil {
+				return Inputs{}, err
+			}
+		}
+		// The variables only the parent areas constrain are fitted by the areas too
+		parents, err := loadParentGeography(ctx, popConfig)
+		if err != nil {
+			return Inputs{}, err
+		}
+		if parents != nil {
+			if constraints, constraintHeader, err = parents.nestConstraints(constraints, constraintHeader); err != nil {
+				return Inputs{}, err
+			}
+		}
+		if len(popConfig.Constraints.Tables) > 0 {
+			microData, microDataHeader, err = deriveTableCells(popConfig.Constraints.Tables, constraintHeader,
+				microData, microDataHeader)
+			if err != nil {
+				return Inputs{}, err
+			}
+		}
+
+		switch {
+		case popConfig.HeaderMode == HeaderIntersection:
+			in, err = intersectInputs(constraints, constraintHeader, microData, microDataHeader)
+			if err != nil {
+				return Inputs{}, err
+			}
+		default:
+			if microData, err = alignMicroData(constraintHeader, microDataHeader, microData); err != nil {
+				return Inputs{}, err
+			}
+			in = Inputs{Constraints: constraints, MicroData: microData, Header: constraintHeader}
+		}
+		if len(popConfig.Constraints.Tables) > 0 {
+			in.Tables = c.tableGroups(popConfig.Constraints.Tables)
+		}
+	}
+
+	if len(popConfig.Derived) > 0 {
+		// Both inputs share the header, so the microdata call returns the derived header
+		constraints, _, err := DeriveConstraints(popConfig.Derived, in.Header, in.Constraints)
+		if err != nil {
+			return Inputs{}, fmt.Errorf("constraints: %w", err)
+		}
+		microData, header, err := DeriveMicroData(popConfig.Derived, in.Header, in.MicroData)
+		if err != nil {
+			return Inputs{}, fmt.Errorf("microdata: %w", err)
+		}
+		in = Inputs{Constraints: constraints, MicroData: microData, Header: header, Tables: in.Tables}
+		Printf("Derived %d columns\n", len(popConfig.Derived))
+	}
+	return in, nil
+}
+
+// intersectInputs restricts constraints and microdata to the variables they share,
+// reporting the columns that are ignored
+func intersectInputs(constraints []ConstraintData, constraintHeader []string,
+	microData []MicroData, microDataHeader []string) (Inputs, error) {
+	common, constraintColumns, microDataColumns := IntersectHeaders(constraintHeader, microDataHeader)
+	if len(common) == 0 {
+		return Inputs{}, fmt.Errorf("the Constraints header and the MiroData header have no variables in common")
+	}
+	if ignored := notIn(constraintHeader, common); len(ignored) > 0 {
+		Printf("Ignoring constraint columns missing from the microdata: %s\n", strings.Join(ignored, ", "))
+	}
+	if ignored := notIn(microDataHeader, common); len(ignored) > 0 {
+		Printf("Ignoring microdata columns missing from the constraints: %s\n", strings.Join(ignored, ", "))
+	}
+	return Inputs{
+		Constraints: SelectConstraintColumns(constraints, constraintColumns),
+		MicroData:   SelectMicroDataColumns(microData, microDataColumns),
+		Header:      common,
+	}, nil
+}
+
+// notIn returns the names of header that are not in subset
+func notIn(header, subset []string) []string {
+	keep := make(map[string]bool, len(subset))
+	for _, name := range subset {
+		keep[name] = true
+	}
+	var missing []string
+	for _, name := range header {
+		if !keep[name] {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// validate rejects option combinations that would only fail after the inputs were
+// loaded or the synthesis had run
+func validate(popConfig PopulationConfig) error {
+	if popConfig.Holdout.Fraction > 0 && (popConfig.Output.AggregateOnly || popConfig.Output.Encrypt) {
+		return fmt.Errorf("holdout evaluation needs a plain ID mapping output, disable aggregateOnly and encrypt")
+	}
+	if popConfig.Holdout.Fraction > 0 && fileFormat(popConfig.Output.File, popConfig.Output.Format) == FormatParquet {
+		return fmt.Errorf("holdout evaluation reads a CSV ID mapping output, set output.format to csv")
+	}
+	return nil
+}
+
+// checkOptions rejects the option combinations of a run that do not depend on the
+// inputs
+func checkOptions(popConfig PopulationConfig, config AnnealingConfig) error {
+	if err := validate(popConfig); err != nil {
+		return err
+	}
+	if popConfig.Output.Append && popConfig.Checkpoint.Resume {
+		return fmt.Errorf("use either checkpoint resume or output append, not both")
+	}
+	if popConfig.Output.Append {
+		if err := checkResumable(popConfig, "append"); err != nil {
+			return err
+		}
+	}
+	if popConfig.Checkpoint.File != "" {
+		if err := checkResumable(popConfig, "resume"); err != nil {
+			return err
+		}
+	}
+	if config.Algorithm == AlgorithmIPF && config.IPF.Fractional && !popConfig.Output.AggregateOnly {
+		return fmt.Errorf("fractional IPF weights do not give individuals, set output.aggregateOnly and use output.weightsFile")
+	}
+	if popConfig.Output.Encrypt {
+		if _, err := LoadEncryptionKey(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check validates a population config and its inputs without synthesizing: the
+// option combinations, the loading and matching of the constraints and microdata,
+// the distance configuration against the header and the output names.
+//
+// Parameters:
+//   - ctx: Cancels the loading of the inputs
+//   - popConfig: The population configuration
+//   - config: The annealing configuration
+//
+// Returns:
+//   - Inputs: The loaded inputs
+//   - error: The first problem found
+func (c *Controller) Check(ctx context.Context, popConfig PopulationConfig, config AnnealingConfig) (Inputs, error) {
+	// The inputs load while the options are checked; a bad option cancels the load
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type loaded struct {
+		in  Inputs
+		err error
+	}
+	load := make(chan loaded, 1)
+	go func() {
+		in, err := c.Load(ctx, popConfig)
+		load <- loaded{in, err}
+	}()
+	if err := checkOptions(popConfig, config); err != nil {
+		cancel()
+		<-load
+		return Inputs{}, err
+	}
+
+	l := <-load
+	in, err := l.in, l.err
+	if err != nil {
+		return in, err
+	}
+	if _, err := buildDistance(in.WithTableGroups(config), in.Header); err != nil {
+		return in, err
+	}
+	if _, err := renameHeader(in.Header, popConfig.Output.Rename); err != nil {
+		return in, err
+	}
+	return in, nil
+}
+
+// Run runs one population config: it names the run, validates the config, loads
+// the inputs, synthesizes every area with Run and finishes with the optional holdout
+// evaluation. popConfig.MaxProcs is not applied: GOMAXPROCS is the caller's to set.
+//
+// Parameters:
+//   - ctx: Cancels the loading of the inputs and the synthesis, e.g. when a user
+//     aborts a run whose first results look wrong
+//   - popConfig: The population configuration
+//   - config: The annealing configuration
+//
+// Returns:
+//   - error: The first validation, loading, synthesis or evaluation error
+func (c *Controller) Run(ctx context.Context, popConfig PopulationConfig, config AnnealingConfig) error {
+	// Name the run first so every output path, including the holdout ones, is final
+	popConfig, err := ApplyRunName(popConfig)
+	if err != nil {
+		return err
+	}
+	if err := validate(popConfig); err != nil {
+		return err
+	}
+
+	in, err := c.Load(ctx, popConfig)
+	if err != nil {
+		return err
+	}
+	return c.RunInputs(ctx, popConfig, config, in)
+}
+
+// RunInputs runs one population config on inputs the caller already holds, such as
+// the constraints and microdata a service received with a job: it synthesizes every
+// area with Run and finishes with the optional holdout evaluation. The input files
+// of popConfig are not read.
+//
+// Parameters:
+//   - ctx: Cancels the synthesis
+//   - popConfig: The population configuration
+//   - config: The annealing configuration
+//   - in: The constraints and microdata, sharing Header
+//
+// Returns:
+//   - error: The first validation, synthesis or evaluation error
+func (c *Controller) RunInputs(ctx context.Context, popConfig PopulationConfig, config AnnealingConfig, in Inputs) error {
+	popConfig, err := ApplyRunName(popConfig)
+	if err != nil {
+		return err
+	}
+	if err := validate(popConfig); err != nil {
+		return err
+	}
+
+	// Cross-validation mode: synthesize from a training subset only
+	microData := in.MicroData
+	var holdout []MicroData
+	if popConfig.Holdout.Fraction > 0 {
+		microData, holdout, err = HoldoutSplit(microData, popConfig.Holdout.Fraction, HoldoutRNG(config))
+		if err != nil {
+			return fmt.Errorf("holdout error: %w", err)
+		}
+		Printf("Holding out %d of %d microdata records\n", len(holdout), len(holdout)+len(microData))
+	}
+
+	start := time.Now()
+	if err := run(ctx, in.Constraints, microData, in.Header, popConfig, in.WithTableGroups(config),
+		runHooks{progress: c.RunProgress, results: c.Results}); err != nil {
+		return err
+	}
+	Printf("slowFunction took %s\n", time.Since(start))
+
+	if holdout != nil {
+		if err := EvaluateHoldout(popConfig.Output.File, popConfig.Holdout.File, microData, holdout); err != nil {
+			return fmt.Errorf("holdout evaluation error: %w", err)
+		}
+	}
+	return nil
+}
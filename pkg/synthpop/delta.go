@@ -0,0 +1,618 @@
+package synthpop
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+)
+
+// Incremental fitness: most metrics are a transform of sums over the variables (the
+// Euclidean distance is the square root of a sum of squared deviations), and a
+// replacement changes the synthetic totals of only the variables where the removed
+// and the added record differ. deltaFitness keeps the terms every variable adds to
+// the sums, so a proposed move evaluates the terms of the changed variables only
+// instead of the metric over the whole constraint vector. On wide tables of
+// mostly-zero indicator columns the non-zero columns of every record are indexed
+// (see sparseIndex), and a move touches nothing but the columns of the two records
+// it swaps. An accepted move adds the stored terms up
+// again in the order the metric does, so the fitness carried through the search is
+// exactly the full metric's. Distances that are not sums of per-variable terms (JS
+// divergence, registered distances, expression metrics using C or T, and
+// Denominators) are evaluated in full.
+
+// deltaSlack is the relative rounding error of an incremental fitness: the searches
+// take the exact fitness of a move that comes this close to the best one
+const deltaSlack = 1e-9
+
+// sparseShare is the largest share of non-zero microdata values at which the
+// non-zero columns of the records are indexed; denser records are compared column
+// by column
+const sparseShare = 0.25
+
+// sumMetric is a metric of the form final(sums), sums[k] the sum over the variables
+// of their k-th term
+type sumMetric struct {
+	width int                                    // Number of sums
+	term  func(w, c, t float64, terms []float64) // Terms of a variable of weight w
+	final func(sums []float64) float64
+}
+
+// sumMetricOf returns the metric called name as a sumMetric, resolved like
+// resolveMetric, or false when it is not a sum of per-variable terms. The terms are
+// computed as the metrics compute them, weighted or not (weight 1), so the sums
+// match bit for bit.
+func sumMetricOf(config AnnealingConfig, name string) (sumMetric, bool) {
+	sum := func(s []float64) float64 { return s[0] }
+	root := func(s []float64) float64 { return math.Sqrt(s[0]) }
+	if m, ok := config.Metrics[name]; ok {
+		return expressionSumMetric(m)
+	}
+	metric, ok := metricByName[name]
+	if !ok {
+		return sumMetric{}, false
+	}
+	switch metric {
+	case CHI_SQUARED:
+		return sumMetric{width: 1, final: sum, term: func(w, c, t float64, terms []float64) {
+			observed := t + EPSILON
+			expected := c + EPSILON
+			diff := observed - expected
+			terms[0] = w * (diff * diff) / expected
+		}}, true
+	case EUCLIDEAN:
+		return sumMetric{width: 1, final: root, term: func(w, c, t float64, terms []float64) {
+			diff := t - c
+			terms[0] = w * diff * diff
+		}}, true
+	case NORM_EUCLIDEAN:
+		return sumMetric{width: 1, final: root, term: func(w, c, t float64, terms []float64) {
+			terms[0] = 0
+			if math.Abs(c) < EPSILON {
+				if math.Abs(t) > EPSILON {
+					terms[0] = w * DefaultHardPenalty * t * t
+				}
+				return
+			}
+			diff := (t - c) / c
+			terms[0] = w * diff * diff
+		}}, true
+	case MANHATTEN:
+		return sumMetric{width: 1, final: sum, term: func(w, c, t float64, terms []float64) {
+			terms[0] = w * math.Abs(t-c)
+		}}, true
+	case COSINE:
+		return sumMetric{width: 3, term: func(w, c, t float64, terms []float64) {
+			terms[0] = w * c * t
+			terms[1] = w * c * c
+			terms[2] = w * t * t
+		}, final: func(s []float64) float64 {
+			dot, normConstraints, normTestData := s[0], s[1], s[2]
+			if normConstraints < EPSILON || normTestData < EPSILON {
+				if normConstraints < EPSILON && normTestData < EPSILON {
+					return 0
+				}
+				return 1
+			}
+			return 1 - dot/(math.Sqrt(normConstraints)*math.Sqrt(normTestData))
+		}}, true
+	case JSDIVERGENCE:
+		return sumMetric{}, false
+	default:
+		return sumMetric{width: 1, final: sum, term: func(w, c, t float64, terms []float64) {
+			p := c + EPSILON
+			q := t + EPSILON
+			terms[0] = w * p * math.Log(p/q)
+		}}, true
+	}
+}
+
+// expressionSumMetric returns an expression metric as a sumMetric, or false when its
+// term uses the sums C or T
+func expressionSumMetric(m MetricExpression) (sumMetric, bool) {
+	term, err := CompileExpression(m.Term, metricTermHeader[:2])
+	if err != nil {
+		return sumMetric{}, false
+	}
+	var final *Expression
+	if m.Final != "" {
+		if final, err = CompileExpression(m.Final, metricFinalHeader); err != nil {
+			return sumMetric{}, false
+		}
+	}
+	vars := make([]float64, len(metricTermHeader))
+	return sumMetric{width: 1, term: func(w, c, t float64, terms []float64) {
+		vars[0], vars[1] = c, t
+		terms[0] = term.Eval(vars) * w
+	}, final: func(s []float64) float64 {
+		if final == nil {
+			return s[0]
+		}
+		return final.Eval(s)
+	}}, true
+}
+
+// deltaPart is the metric of a variable group, or of all variables without groups
+type deltaPart struct {
+	metric  sumMetric
+	weight  float64
+	columns []int // In the order the metric sums them
+	sums    int   // Offset of the part's sums in deltaFitness.sums
+	terms   int   // Offset of the terms of its variables, in column order, in deltaFitness.terms
+}
+
+// deltaVariable places a variable in its part and its terms in deltaFitness.terms
+type deltaVariable struct {
+	part   int
+	offset int
+	weight float64 // Per-variable weight, 1 without VariableWeights
+}
+
+// deltaFitness evaluates the distance of buildDistance incrementally. It holds the
+// state of one population, so it must not be shared between goroutines.
+type deltaFitness struct {
+	parts     []deltaPart
+	grouped   bool
+	variables []deltaVariable
+	rounding  bool
+	tolerance float64 // Rounding tolerance, also of the hard constraints
+	hard      []int   // Hard columns, in the order their violations are summed
+	isHard    []bool
+	penalty   float64
+
+	terms      []float64 // Terms of every variable of the current population
+	violations []float64 // Hard constraint violation of every column
+	sums       []float64
+	violation  float64 // Total hard constraint violation
+	commits    int     // Moves committed since the terms were last added up
+
+	// The area: its constraints, the totals of the population, the microdata it draws
+	// from and their non-zero columns (nil to compare all columns of the records)
+	constraints []float64
+	totals      []float64
+	microdata   []MicroData
+	columns     *sparseColumns
+
+	// The proposed move: the sums after it, and the variables it changes with their
+	// values in the swapped records and their new terms
+	proposed          []float64
+	proposedViolation float64
+	changed           []int
+	changedValues     []float64
+	changedTerms      []float64
+	changedViolations []float64
+	buf               []float64
+}
+
+// buildDeltaFitness returns the incremental form of the distance buildDistance
+// builds for config, or nil when the distance is not a sum of per-variable terms
+func buildDeltaFitness(config AnnealingConfig, header []string) *deltaFitness {
+	if len(config.Denominators) > 0 {
+		return nil
+	}
+	var weights []float64
+	if len(config.VariableWeights) > 0 {
+		var err error
+		if weights, err = columnWeights(config, header); err != nil {
+			return nil
+		}
+	}
+
+	d := &deltaFitness{variables: make([]deltaVariable, len(header)), grouped: len(config.VariableGroups) > 0}
+	if !d.grouped {
+		all := make([]int, len(header))
+		for i := range all {
+			all[i] = i
+		}
+		metric, ok := sumMetricOf(config, config.Distance)
+		if !ok {
+			return nil
+		}
+		d.parts = []deltaPart{{metric: metric, weight: 1, columns: all}}
+	} else {
+		groups, err := resolveGroups(config, header, weights)
+		if err != nil {
+			return nil
+		}
+		// The configured groups come first, then the implicit one of the rest
+		for g, group := range groups {
+			name := config.Distance
+			if g < len(config.VariableGroups) && config.VariableGroups[g].Distance != "" {
+				name = config.VariableGroups[g].Distance
+			}
+			metric, ok := sumMetricOf(config, name)
+			if !ok {
+				return nil
+			}
+			d.parts = append(d.parts, deltaPart{metric: metric, weight: group.weight, columns: group.columns})
+		}
+	}
+
+	offset, sums, width := 0, 0, 0
+	for p := range d.parts {
+		part := &d.parts[p]
+		part.sums, part.terms = sums, offset
+		sums += part.metric.width
+		width = max(width, part.metric.width)
+		for _, column := range part.columns {
+			weight := 1.0
+			if weights != nil {
+				weight = weights[column]
+			}
+			d.variables[column] = deltaVariable{part: p, offset: offset, weight: weight}
+			offset += part.metric.width
+		}
+	}
+	d.terms = make([]float64, offset)
+	d.sums = make([]float64, sums)
+	d.proposed = make([]float64, sums)
+	d.buf = make([]float64, width)
+
+	if config.RoundingBase > 1 {
+		d.rounding, d.tolerance = true, float64(config.RoundingBase-1)
+	}
+	hard, err := resolveHardConstraints(config, header)
+	if err != nil {
+		return nil
+	}
+	if hard != nil {
+		d.hard, d.isHard = hard, make([]bool, len(header))
+		for _, column := range hard {
+			d.isHard[column] = true
+		}
+		d.penalty = config.HardPenalty
+		if d.penalty == 0 {
+			d.penalty = DefaultHardPenalty
+		}
+	}
+	d.violations = make([]float64, len(header))
+	return d
+}
+
+// adjust returns the synthetic total the metric compares, moved within the rounding
+// tolerance as withinRounding does
+func (d *deltaFitness) adjust(target, total float64) float64 {
+	if !d.rounding {
+		return total
+	}
+	deviation := total - target
+	switch {
+	case deviation > d.tolerance:
+		deviation -= d.tolerance
+	case deviation < -d.tolerance:
+		deviation += d.tolerance
+	default:
+		deviation = 0
+	}
+	return target + deviation
+}
+
+// hardViolation is the hard constraint violation of a column, as
+// withHardConstraints counts it
+func (d *deltaFitness) hardViolation(target, total float64) float64 {
+	return max(math.Abs(total-target)-d.tolerance, 0)
+}
+
+// reset computes the terms of a population of an area from scratch and returns its
+// fitness
+//
+// Parameters:
+//   - constraints: The area constraints
+//   - totals: The totals of the population, kept up to date by propose and revert
+//   - microdata: The records the population is drawn from
+//   - columns: Their non-zero columns (see sparseIndex), nil to compare all columns
+func (d *deltaFitness) reset(constraints, totals []float64, microdata []MicroData, columns *sparseColumns) float64 {
+	d.constraints, d.totals, d.microdata, d.columns = constraints, totals, microdata, columns
+	for i := range constraints {
+		v := d.variables[i]
+		width := d.parts[v.part].metric.width
+		d.parts[v.part].metric.term(v.weight, constraints[i], d.adjust(constraints[i], totals[i]), d.terms[v.offset:v.offset+width])
+		if d.isHard != nil && d.isHard[i] {
+			d.violations[i] = d.hardViolation(constraints[i], totals[i])
+		}
+	}
+	return d.exact()
+}
+
+// propose replaces the record removed by the record added in the totals and returns
+// the fitness of the population after it, evaluating the terms of the changed
+// variables only. commit keeps the move, revert restores the totals.
+func (d *deltaFitness) propose(removed, added int) float64 {
+	copy(d.proposed, d.sums)
+	d.proposedViolation = d.violation
+	d.changed, d.changedValues = d.changed[:0], d.changedValues[:0]
+	d.changedTerms, d.changedViolations = d.changedTerms[:0], d.changedViolations[:0]
+	if d.columns == nil {
+		oldValues, newValues := d.microdata[removed].Values, d.microdata[added].Values
+		for i := range oldValues {
+			if oldValues[i] != newValues[i] {
+				d.change(i, oldValues[i], newValues[i])
+			}
+		}
+	} else {
+		// Merge the sorted non-zero columns of both records, reading neither's values
+		oldColumns, oldValues := d.columns.of(removed)
+		newColumns, newValues := d.columns.of(added)
+		a, b := 0, 0
+		for a < len(oldColumns) || b < len(newColumns) {
+			switch {
+			case b == len(newColumns) || a < len(oldColumns) && oldColumns[a] < newColumns[b]:
+				d.change(int(oldColumns[a]), oldValues[a], 0)
+				a++
+			case a == len(oldColumns) || newColumns[b] < oldColumns[a]:
+				d.change(int(newColumns[b]), 0, newValues[b])
+				b++
+			default:
+				if oldValues[a] != newValues[b] {
+					d.change(int(oldColumns[a]), oldValues[a], newValues[b])
+				}
+				a++
+				b++
+			}
+		}
+	}
+	return d.combine(d.proposed, d.proposedViolation)
+}
+
+// change moves the total of variable i from a record with value from to one with
+// value to and adds the change of its terms to the proposed sums
+func (d *deltaFitness) change(i int, from, to float64) {
+	d.totals[i] = d.totals[i] - from + to
+	v := d.variables[i]
+	part := &d.parts[v.part]
+	terms := d.buf[:part.metric.width]
+	part.metric.term(v.weight, d.constraints[i], d.adjust(d.constraints[i], d.totals[i]), terms)
+	for k, term := range terms {
+		d.proposed[part.sums+k] += term - d.terms[v.offset+k]
+	}
+	d.changed = append(d.changed, i)
+	d.changedValues = append(d.changedValues, from, to)
+	d.changedTerms = append(d.changedTerms, terms...)
+	if d.isHard != nil && d.isHard[i] {
+		next := d.hardViolation(d.constraints[i], d.totals[i])
+		d.proposedViolation += next - d.violations[i]
+		d.changedViolations = append(d.changedViolations, next)
+	}
+}
+
+// revert restores the totals from before the proposed move
+func (d *deltaFitness) revert() {
+	for k, i := range d.changed {
+		d.totals[i] = d.totals[i] - d.changedValues[2*k+1] + d.changedValues[2*k]
+	}
+}
+
+// commit keeps the proposed move and returns the fitness of the population. The
+// sums are carried over from the proposal, so the fitness drifts from the exact one
+// by rounding error until exact adds the terms up again, which commit does every
+// len(terms) moves.
+func (d *deltaFitness) commit() float64 {
+	copy(d.sums, d.proposed)
+	d.violation = d.proposedViolation
+	next, hard := 0, 0
+	for _, i := range d.changed {
+		v := d.variables[i]
+		width := d.parts[v.part].metric.width
+		copy(d.terms[v.offset:v.offset+width], d.changedTerms[next:next+width])
+		next += width
+		if d.isHard != nil && d.isHard[i] {
+			d.violations[i] = d.changedViolations[hard]
+			hard++
+		}
+	}
+	if d.commits++; d.commits >= len(d.terms) {
+		return d.exact()
+	}
+	return d.combine(d.sums, d.violation)
+}
+
+// exact returns the exact fitness of the population: the full metric's, the stored
+// terms added up in its order
+func (d *deltaFitness) exact() float64 {
+	d.commits = 0
+	return d.resum()
+}
+
+// resum adds the stored terms up in the order of the metrics and returns the fitness
+func (d *deltaFitness) resum() float64 {
+	for _, part := range d.parts {
+		width := part.metric.width
+		terms := d.terms[part.terms : part.terms+len(part.columns)*width]
+		if width == 1 {
+			sum := 0.0
+			for _, term := range terms {
+				sum += term
+			}
+			d.sums[part.sums] = sum
+			continue
+		}
+		sums := d.sums[part.sums : part.sums+width]
+		for k := range sums {
+			sums[k] = 0
+		}
+		for i := 0; i < len(terms); i += width {
+			for k := range sums {
+				sums[k] += terms[i+k]
+			}
+		}
+	}
+	d.violation = 0
+	for _, column := range d.hard {
+		d.violation += d.violations[column]
+	}
+	return d.combine(d.sums, d.violation)
+}
+
+// combine returns the fitness of the sums of every part and a hard constraint
+// violation, as buildDistance combines the metrics
+func (d *deltaFitness) combine(sums []float64, violation float64) float64 {
+	var fitness float64
+	if !d.grouped {
+		part := d.parts[0]
+		fitness = part.metric.final(sums[part.sums : part.sums+part.metric.width])
+	} else {
+		for _, part := range d.parts {
+			fitness += part.weight * part.metric.final(sums[part.sums:part.sums+part.metric.width])
+		}
+	}
+	if d.hard != nil {
+		fitness += d.penalty * violation
+	}
+	return fitness
+}
+
+// sparseColumns lists the non-zero columns of every record of a microdata pool,
+// ascending, with their values next to them so a move reads a few contiguous
+// entries rather than the scattered value rows of two records
+type sparseColumns struct {
+	start   []int32 // Start of every record's columns, and the end of the last
+	columns []int32
+	values  []float64
+}
+
+// of returns the non-zero columns of a record and their values
+func (s *sparseColumns) of(record int) ([]int32, []float64) {
+	from, to := s.start[record], s.start[record+1]
+	return s.columns[from:to], s.values[from:to]
+}
+
+// sparseKey identifies a microdata pool
+type sparseKey struct {
+	first *MicroData
+	n     int
+}
+
+// sparseIndex holds the non-zero columns of the microdata pools of a run, indexed
+// when an area first draws from a pool and shared by the workers
+type sparseIndex struct {
+	mu    sync.Mutex
+	pools map[sparseKey]*sparseColumns
+}
+
+func newSparseIndex() *sparseIndex {
+	return &sparseIndex{pools: make(map[sparseKey]*sparseColumns)}
+}
+
+// of returns the non-zero columns of a pool, nil without an index or when more than
+// sparseShare of its values are non-zero
+func (x *sparseIndex) of(microdata []MicroData) *sparseColumns {
+	if x == nil || len(microdata) == 0 {
+		return nil
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	key := sparseKey{&microdata[0], len(microdata)}
+	columns, ok := x.pools[key]
+	if !ok {
+		columns = indexColumns(microdata)
+		x.pools[key] = columns
+	}
+	return columns
+}
+
+// indexColumns lists the non-zero columns of every record, nil when more than
+// sparseShare of the values are non-zero
+func indexColumns(microdata []MicroData) *sparseColumns {
+	nonzero, values := 0, 0
+	for _, md := range microdata {
+		for _, v := range md.Values {
+			if v != 0 {
+				nonzero++
+			}
+		}
+		values += len(md.Values)
+	}
+	if float64(nonzero) > sparseShare*float64(values) || nonzero > math.MaxInt32 {
+		return nil
+	}
+	s := &sparseColumns{start: make([]int32, 0, len(microdata)+1), columns: make([]int32, 0, nonzero),
+		values: make([]float64, 0, nonzero)}
+	for _, md := range microdata {
+		s.start = append(s.start, int32(len(s.columns)))
+		for i, v := range md.Values {
+			if v != 0 {
+				s.columns = append(s.columns, int32(i))
+				s.values = append(s.values, v)
+			}
+		}
+	}
+	s.start = append(s.start, int32(len(s.columns)))
+	return s
+}
+
+// CheckIncrementalFitness proposes random replacements in a random population of an
+// area and compares the incremental fitness of every move with the configured
+// distance of the totals, for the selftest command: the fitness of every move must
+// match to rounding error, and the exact fitness bit for bit.
+//
+// Parameters:
+//   - config: The annealing config whose distance is checked
+//   - header: Names of the constraint variables
+//   - constraint: The area, of a positive total
+//   - microData: The records the population is drawn from
+//   - moves: The number of replacements to propose, half of them accepted
+//   - rng: Random number generator
+//
+// Returns:
+//   - bool: Whether the distance is evaluated incrementally; nothing is checked if not
+//   - error: An invalid distance configuration, or the first mismatch
+func CheckIncrementalFitness(config AnnealingConfig, header []string, constraint ConstraintData, microData []MicroData,
+	moves int, rng *rand.Rand) (bool, error) {
+	distance, err := buildDistance(config, header)
+	if err != nil {
+		return false, err
+	}
+	delta := buildDeltaFitness(config, header)
+	if delta == nil {
+		return false, nil
+	}
+	near := func(got, want float64) bool {
+		return math.Abs(got-want) <= deltaSlack*math.Max(1, math.Abs(want))
+	}
+
+	totals := make([]float64, len(constraint.Values))
+	population := make([]int, int(constraint.Total))
+	for i := range population {
+		population[i] = rng.Intn(len(microData))
+		for j, v := range microData[population[i]].Values {
+			totals[j] += v
+		}
+	}
+	if got, want := delta.reset(constraint.Values, totals, microData, indexColumns(microData)), distance(constraint.Values, totals); got != want {
+		return true, fmt.Errorf("initial fitness %v, the distance is %v", got, want)
+	}
+	for move := 0; move < moves; move++ {
+		slot, record := rng.Intn(len(population)), rng.Intn(len(microData))
+		proposed := delta.propose(population[slot], record)
+		if want := distance(constraint.Values, totals); !near(proposed, want) {
+			return true, fmt.Errorf("move %d: incremental fitness %v, the distance is %v", move, proposed, want)
+		}
+		if rng.Intn(2) == 0 {
+			delta.revert()
+			continue
+		}
+		population[slot] = record
+		committed, want := delta.commit(), distance(constraint.Values, totals)
+		if !near(committed, want) {
+			return true, fmt.Errorf("move %d: accepted fitness %v, the distance is %v", move, committed, want)
+		}
+		if move%10 == 0 {
+			if exact := delta.exact(); exact != want {
+				return true, fmt.Errorf("move %d: exact fitness %v, the distance is %v", move, exact, want)
+			}
+		}
+	}
+	// The totals must still be those of the population
+	sums := make([]float64, len(totals))
+	for _, record := range population {
+		for j, v := range microData[record].Values {
+			sums[j] += v
+		}
+	}
+	for j := range sums {
+		if !near(totals[j], sums[j]) {
+			return true, fmt.Errorf("total of variable %d is %v, the population sums to %v", j, totals[j], sums[j])
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,183 @@
+package synthpop
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+// testHeader returns the variable names v0..v(n-1)
+func testHeader(n int) []string {
+	header := make([]string, n)
+	for i := range header {
+		header[i] = "v" + strconv.Itoa(i)
+	}
+	return header
+}
+
+// testMicrodata returns count records of n variables: dense binary ones, or sparse
+// ones with two non-zero values
+func testMicrodata(rng *rand.Rand, count, n int, sparse bool) []MicroData {
+	microData := make([]MicroData, count)
+	for i := range microData {
+		values := make([]float64, n)
+		if sparse {
+			values[rng.Intn(n)], values[rng.Intn(n)] = 1, 2
+		} else {
+			for j := range values {
+				values[j] = float64(rng.Intn(2))
+			}
+		}
+		microData[i] = MicroData{ID: "r" + strconv.Itoa(i), Values: values}
+	}
+	return microData
+}
+
+// testConstraint returns an area of n variables and the given total, one of whose
+// constraints is 0
+func testConstraint(rng *rand.Rand, n int, total float64) ConstraintData {
+	constraint := ConstraintData{ID: "area", Values: make([]float64, n), Total: total}
+	for i := range constraint.Values {
+		constraint.Values[i] = float64(rng.Intn(int(total)))
+	}
+	constraint.Values[3] = 0
+	return constraint
+}
+
+func TestDeltaFitnessMatchesDistance(t *testing.T) {
+	const variables, moves = 12, 400
+	header := testHeader(variables)
+	variants := []struct {
+		name   string
+		adjust func(*AnnealingConfig)
+	}{
+		{"plain", func(c *AnnealingConfig) {}},
+		{"weights", func(c *AnnealingConfig) {
+			c.VariableWeights = map[string]float64{"v0": 2, "v5": 0.5}
+		}},
+		{"groups", func(c *AnnealingConfig) {
+			c.VariableGroups = []VariableGroup{
+				{Name: "first", Variables: []string{"v0", "v1", "v2"}, Distance: "MANHATTEN", Weight: 3},
+				{Name: "second", Variables: []string{"v6", "v7"}, Weight: 0.5},
+			}
+		}},
+		{"rounding", func(c *AnnealingConfig) { c.RoundingBase = 3 }},
+		{"hard", func(c *AnnealingConfig) { c.HardConstraints = []string{"v4", "v1"}; c.HardPenalty = 50 }},
+		{"all", func(c *AnnealingConfig) {
+			c.VariableWeights = map[string]float64{"v0": 2, "v5": 0.5}
+			c.VariableGroups = []VariableGroup{{Name: "first", Variables: []string{"v0", "v1", "v2"}, Distance: "COSINE", Weight: 3}}
+			c.RoundingBase = 3
+			c.HardConstraints = []string{"v4", "v1"}
+		}},
+	}
+	metrics := map[string]MetricExpression{"SQUARES": {Term: "(t - c) * (t - c)", Final: "sqrt(s)"}}
+	for _, name := range append([]string{"SQUARES"}, ValidMetrics...) {
+		if name == "JSDIVERGENCE" {
+			continue // Evaluated in full, see TestDeltaFitnessFallbacks
+		}
+		for _, variant := range variants {
+			for _, sparse := range []bool{false, true} {
+				t.Run(name+"/"+variant.name+"/sparse="+strconv.FormatBool(sparse), func(t *testing.T) {
+					config := AnnealingConfig{Distance: name, Metrics: metrics}
+					variant.adjust(&config)
+					rng := rand.New(rand.NewSource(6))
+					microData := testMicrodata(rng, 100, variables, sparse)
+					constraint := testConstraint(rng, variables, 40)
+					checkDeltaFitness(t, config, header, constraint, microData, moves, rng)
+				})
+			}
+		}
+	}
+}
+
+// checkDeltaFitness proposes random moves in a random population and compares the
+// incremental fitness of every proposal and commit with the full distance
+func checkDeltaFitness(t *testing.T, config AnnealingConfig, header []string, constraint ConstraintData,
+	microData []MicroData, moves int, rng *rand.Rand) {
+	t.Helper()
+	distance, err := buildDistance(config, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delta := buildDeltaFitness(config, header)
+	if delta == nil {
+		t.Fatal("distance is not evaluated incrementally")
+	}
+	near := func(got, want float64) bool {
+		return math.Abs(got-want) <= deltaSlack*math.Max(1, math.Abs(want))
+	}
+
+	totals := make([]float64, len(constraint.Values))
+	population := make([]int, int(constraint.Total))
+	for i := range population {
+		population[i] = rng.Intn(len(microData))
+		for j, v := range microData[population[i]].Values {
+			totals[j] += v
+		}
+	}
+	columns := indexColumns(microData)
+	if got, want := delta.reset(constraint.Values, totals, microData, columns), distance(constraint.Values, totals); got != want {
+		t.Fatalf("reset: fitness %v, distance %v", got, want)
+	}
+	for move := 0; move < moves; move++ {
+		slot, record := rng.Intn(len(population)), rng.Intn(len(microData))
+		before := append([]float64(nil), totals...)
+		proposed := delta.propose(population[slot], record)
+		if want := distance(constraint.Values, totals); !near(proposed, want) {
+			t.Fatalf("move %d: proposed fitness %v, distance %v", move, proposed, want)
+		}
+		if rng.Intn(2) == 0 {
+			delta.revert()
+			for j := range totals {
+				if totals[j] != before[j] {
+					t.Fatalf("move %d: revert left total %d at %v, was %v", move, j, totals[j], before[j])
+				}
+			}
+			continue
+		}
+		population[slot] = record
+		want := distance(constraint.Values, totals)
+		if committed := delta.commit(); !near(committed, want) {
+			t.Fatalf("move %d: committed fitness %v, distance %v", move, committed, want)
+		}
+		if exact := delta.exact(); exact != want {
+			t.Fatalf("move %d: exact fitness %v, distance %v", move, exact, want)
+		}
+	}
+}
+
+func TestDeltaFitnessFallbacks(t *testing.T) {
+	header := testHeader(12)
+	if err := RegisterDistance("TEST_DELTA_MAX", func(c, t []float64) float64 {
+		worst := 0.0
+		for i := range c {
+			worst = max(worst, math.Abs(t[i]-c[i]))
+		}
+		return worst
+	}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		config AnnealingConfig
+	}{
+		{"JS divergence", AnnealingConfig{Distance: "JSDIVERGENCE"}},
+		{"JS divergence group", AnnealingConfig{Distance: "EUCLIDEAN",
+			VariableGroups: []VariableGroup{{Name: "g", Variables: []string{"v0", "v1"}, Distance: "JSDIVERGENCE"}}}},
+		{"denominators", AnnealingConfig{Distance: "EUCLIDEAN", Denominators: map[string][]string{"v2": {"v0", "v1"}}}},
+		{"registered metric", AnnealingConfig{Distance: "TEST_DELTA_MAX"}},
+		{"expression using sums", AnnealingConfig{Distance: "SHARE",
+			Metrics: map[string]MetricExpression{"SHARE": {Term: "abs(t / T - c / C)"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := buildDistance(tt.config, header); err != nil {
+				t.Fatalf("distance: %v", err)
+			}
+			if delta := buildDeltaFitness(tt.config, header); delta != nil {
+				t.Fatal("evaluated incrementally, want the full distance")
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package synthpop
+
+import (
+	"fmt"
+	"slices"
+)
+
+// resolveDenominators maps AnnealingConfig.Denominators onto the header columns: the
+// columns summing to the subpopulation of every variable, nil for variables that
+// apply to the whole area population.
+func resolveDenominators(config AnnealingConfig, header []string) ([][]int, error) {
+	if len(config.Denominators) == 0 {
+		return nil, nil
+	}
+	denominators := make([][]int, len(header))
+	for name, variables := range config.Denominators {
+		column := slices.Index(header, name)
+		if column < 0 {
+			return nil, fmt.Errorf("denominators: variable '%s' is not in the header", name)
+		}
+		if len(variables) == 0 {
+			return nil, fmt.Errorf("denominators: variable '%s' has an empty denominator", name)
+		}
+		for _, v := range variables {
+			d := slices.Index(header, v)
+			if d < 0 {
+				return nil, fmt.Errorf("denominators: '%s' in the denominator of '%s' is not in the header", v, name)
+			}
+			if slices.Contains(denominators[column], d) {
+				return nil, fmt.Errorf("denominators: '%s' appears twice in the denominator of '%s'", v, name)
+			}
+			denominators[column] = append(denominators[column], d)
+		}
+	}
+	return denominators, nil
+}
+
+// denominatorTotal sums the columns of a denominator in values
+func denominatorTotal(values []float64, columns []int) float64 {
+	total := 0.0
+	for _, column := range columns {
+		total += values[column]
+	}
+	return total
+}
+
+// subpopulation returns the total the variable in column is a share of: its
+// denominator's total in values, or population for a variable without one
+func subpopulation(values []float64, denominators [][]int, column int, population float64) float64 {
+	if denominators == nil || denominators[column] == nil {
+		return population
+	}
+	return denominatorTotal(values, denominators[column])
+}
+
+// withDenominators makes distance compare the variables that have a denominator by
+// their share of their subpopulation rather than by count: the synthetic count is
+// rescaled to the constraint's subpopulation before the metric sees it. An
+// economic activity count then shows the error of the activity rates among the
+// over-16s, not the error in the number of over-16s, which their own variables
+// already carry. Synthetic subpopulations that are empty are left unscaled.
+func withDenominators(distance DistanceFunc, denominators [][]int) DistanceFunc {
+	var scaled []float64
+	return func(constraints, testData []float64) float64 {
+		scaled = floatBuffer(scaled, len(testData))
+		for i, t := range testData {
+			scaled[i] = t
+			if denominators[i] == nil {
+				continue
+			}
+			if synthetic := denominatorTotal(testData, denominators[i]); synthetic > 0 {
+				scaled[i] = t / synthetic * denominatorTotal(constraints, denominators[i])
+			}
+		}
+		return distance(constraints, scaled)
+	}
+}
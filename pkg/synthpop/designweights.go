@@ -0,0 +1,103 @@
+package synthpop
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"sort"
+)
+
+// Design weights: survey records stand for different numbers of people. With
+// Microdata.WeightColumn set, the records are drawn in proportion to their weights
+// when populations are initialised and replacements proposed, so a synthetic
+// population drawn before any fitting follows the survey design. Weights are kept
+// relative to the largest and drawn through the cumulative weights of each area's
+// valid records (see validPool); unweighted microdata (Weight 0) draw uniformly.
+
+// applyDesignWeights moves the weight column out of the microdata values into the
+// Weight of every record, scaled so the largest weight is 1
+//
+// Parameters:
+//   - microData: The loaded microdata, left unmodified
+//   - header: Its variable names, including column
+//   - column: The name of the design weight column
+//
+// Returns:
+//   - []MicroData: Copies of the records without the weight column, weighted
+//   - []string: The header without the weight column
+//   - error: A missing column, or a weight that is not positive and finite
+func applyDesignWeights(microData []MicroData, header []string, column string) ([]MicroData, []string, error) {
+	weighted, header, weights, err := takeColumn(microData, header, column, "weight")
+	if err != nil {
+		return nil, nil, err
+	}
+	largest, smallest := 0.0, math.Inf(1)
+	for i, w := range weights {
+		if !(w > 0) || math.IsInf(w, 0) {
+			return nil, nil, fmt.Errorf("microdata record %s has weight %v, design weights must be positive", weighted[i].ID, w)
+		}
+		largest, smallest = max(largest, w), min(smallest, w)
+	}
+	for i, w := range weights {
+		weighted[i].Weight = w / largest
+	}
+	if len(weighted) > 0 {
+		Printf("⚖️ Drawing microdata records by their %s design weights (largest %.3g times the smallest)\n",
+			column, largest/smallest)
+	}
+	return weighted, header, nil
+}
+
+// takeColumn removes a column that is not a constraint variable from the
+// microdata values (what names its role in messages)
+//
+// Returns:
+//   - []MicroData: Copies of the records without the column
+//   - []string: The header without the column
+//   - []float64: The column's value of every record
+//   - error: The column is not in the header
+func takeColumn(microData []MicroData, header []string, column, what string) ([]MicroData, []string, []float64, error) {
+	taken := slices.Index(header, column)
+	if taken < 0 {
+		return nil, nil, nil, fmt.Errorf("microdata %s column %s is not in the microdata header", what, column)
+	}
+	values := make([]float64, len(microData))
+	for i, md := range microData {
+		values[i] = md.Values[taken]
+	}
+	columns := make([]int, 0, len(header)-1)
+	names := make([]string, 0, len(header)-1)
+	for i, name := range header {
+		if i != taken {
+			columns = append(columns, i)
+			names = append(names, name)
+		}
+	}
+	return SelectMicroDataColumns(microData, columns), names, values, nil
+}
+
+// poolWeights returns the cumulative design weights of the pool of records valid
+// for an area, built in buf, or nil for unweighted microdata
+func poolWeights(pool []int, microdata []MicroData, buf []float64) []float64 {
+	if len(pool) == 0 || microdata[pool[0]].Weight == 0 {
+		return nil
+	}
+	buf = floatBuffer(buf, len(pool))
+	sum := 0.0
+	for k, i := range pool {
+		sum += microdata[i].Weight
+		buf[k] = sum
+	}
+	return buf
+}
+
+// drawFromPool draws a record of the pool, in proportion to its design weight when
+// cumulative (see poolWeights) is not nil, uniformly otherwise
+func drawFromPool(pool []int, cumulative []float64, rng *rand.Rand) int {
+	if cumulative == nil {
+		return pool[rng.Intn(len(pool))]
+	}
+	k := sort.SearchFloat64s(cumulative, rng.Float64()*cumulative[len(cumulative)-1])
+	return pool[min(k, len(pool)-1)]
+}
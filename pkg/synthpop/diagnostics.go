@@ -0,0 +1,69 @@
+package synthpop
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strconv"
+)
+
+// diagnosticsWriter writes the move statistics of the annealing search of every
+// area, for tuning initialTemp and coolingRate: a search that accepts almost no
+// worse moves from the start is too cold to explore, one still accepting many when
+// it stops cooled too slowly for maxIterations.
+type diagnosticsWriter struct {
+	initialTemp float64
+	file        *outputFile
+	writer      *csv.Writer
+}
+
+// newDiagnosticsWriter creates the diagnostics file, or returns nil when none is
+// configured
+func newDiagnosticsWriter(popConfig PopulationConfig, config AnnealingConfig, key []byte, retry retryPolicy) (*diagnosticsWriter, error) {
+	if popConfig.Output.DiagnosticsFile == "" {
+		return nil, nil
+	}
+	file, err := createOutput(popConfig.Output.DiagnosticsFile, key, retry)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create diagnostics file: %w", err)
+	}
+	w := &diagnosticsWriter{initialTemp: config.InitialTemp, file: file, writer: csv.NewWriter(file)}
+	header := []string{"area_id", "proposed", "accepted", "accepted_worse", "reverted",
+		"acceptance_rate", "initial_temperature", "final_temperature", "reheats", "best_iteration"}
+	if err := w.writer.Write(header); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error writing diagnostics header: %w", err)
+	}
+	return w, nil
+}
+
+// writeArea writes the statistics of one area. Areas synthesized by an algorithm
+// other than annealing have no moves to count and are left out.
+func (w *diagnosticsWriter) writeArea(res Result) error {
+	if res.Iterations == 0 {
+		return nil
+	}
+	row := []string{res.Area,
+		strconv.Itoa(res.Iterations),
+		strconv.Itoa(res.Accepted),
+		strconv.Itoa(res.AcceptedWorse),
+		strconv.Itoa(res.Reverted),
+		formatFloat(float64(res.Accepted) / float64(res.Iterations)),
+		formatFloat(w.initialTemp),
+		formatFloat(res.FinalTemperature),
+		strconv.Itoa(res.Reheats),
+		strconv.Itoa(res.BestIteration)}
+	if err := w.writer.Write(row); err != nil {
+		return fmt.Errorf("error writing diagnostics row: %w", err)
+	}
+	return nil
+}
+
+// Close flushes and closes the diagnostics file
+func (w *diagnosticsWriter) Close() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
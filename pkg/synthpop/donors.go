@@ -0,0 +1,135 @@
+package synthpop
+
+import (
+	"fmt"
+	"iter"
+	"math/rand"
+	"sync"
+)
+
+// DonorPool gives embedding applications read access to loaded microdata, so they
+// can draw donors for their own imputation from the records a Controller already
+// holds instead of loading a second copy. The pool shares the records and their
+// Values with the inputs it was built from: callers must not modify them. A pool is
+// safe for concurrent use.
+type DonorPool struct {
+	records []MicroData
+	header  []string
+
+	indexOnce sync.Once
+	byID      map[string]int // Built on the first ByID call
+}
+
+// NewDonorPool returns a pool over microData, whose values are laid out as header
+func NewDonorPool(microData []MicroData, header []string) *DonorPool {
+	return &DonorPool{records: microData, header: header}
+}
+
+// Donors returns a pool over the loaded microdata. Keep the pool rather than
+// calling Donors again, as the ID index is built once per pool.
+func (in Inputs) Donors() *DonorPool {
+	return NewDonorPool(in.MicroData, in.Header)
+}
+
+// Len returns the number of records in the pool
+func (p *DonorPool) Len() int {
+	return len(p.records)
+}
+
+// Header returns the variable names of the record values
+func (p *DonorPool) Header() []string {
+	return p.header
+}
+
+// At returns the record at index i, which must be in [0, Len())
+func (p *DonorPool) At(i int) MicroData {
+	return p.records[i]
+}
+
+// ByID returns the record with the given ID and whether the pool holds one. When
+// IDs repeat, the first record is returned.
+func (p *DonorPool) ByID(id string) (MicroData, bool) {
+	p.indexOnce.Do(func() {
+		p.byID = make(map[string]int, len(p.records))
+		for i, md := range p.records {
+			if _, ok := p.byID[md.ID]; !ok {
+				p.byID[md.ID] = i
+			}
+		}
+	})
+	i, ok := p.byID[id]
+	if !ok {
+		return MicroData{}, false
+	}
+	return p.records[i], true
+}
+
+// Chunks iterates over the records in consecutive slices of at most size records,
+// with the index of the first record of each. The slices share the pool's memory.
+func (p *DonorPool) Chunks(size int) iter.Seq2[int, []MicroData] {
+	return func(yield func(int, []MicroData) bool) {
+		if size <= 0 {
+			size = len(p.records)
+		}
+		for start := 0; start < len(p.records); start += size {
+			if !yield(start, p.records[start:min(start+size, len(p.records))]) {
+				return
+			}
+		}
+	}
+}
+
+// Where iterates over the indices and records satisfying match
+func (p *DonorPool) Where(match func(md MicroData) bool) iter.Seq2[int, MicroData] {
+	return func(yield func(int, MicroData) bool) {
+		for i, md := range p.records {
+			if match(md) && !yield(i, md) {
+				return
+			}
+		}
+	}
+}
+
+// WhereExpression iterates over the records for which an expression over the
+// pool's header (see CompileExpression) is non-zero, e.g. "age65p" or
+// "employed * (1 - students)".
+func (p *DonorPool) WhereExpression(src string) (iter.Seq2[int, MicroData], error) {
+	expr, err := CompileExpression(src, p.header)
+	if err != nil {
+		return nil, err
+	}
+	return p.Where(func(md MicroData) bool { return expr.Eval(md.Values) != 0 }), nil
+}
+
+// Sample draws n records, with replacement, among those valid for an area: records
+// with no count in a variable whose constraint is zero, the pool the annealing
+// draws from. The constraint values must be laid out like the pool's header.
+//
+// Parameters:
+//   - constraint: The area constraints
+//   - n: Number of records to draw
+//   - rng: Random number generator of the draws
+//
+// Returns:
+//   - []MicroData: The drawn records, sharing the pool's memory
+//   - error: ErrNoValidMicrodata if no record is valid for the area
+func (p *DonorPool) Sample(constraint ConstraintData, n int, rng *rand.Rand) ([]MicroData, error) {
+	if len(constraint.Values) != len(p.header) {
+		return nil, fmt.Errorf("area %s has %d constraints for %d microdata variables",
+			constraint.ID, len(constraint.Values), len(p.header))
+	}
+	var valid []int
+	for i, md := range p.records {
+		if isValidMicrodata(md.Values, constraint.Values) {
+			valid = append(valid, i)
+		}
+	}
+	if len(valid) == 0 {
+		return nil, fmt.Errorf("area %s: %w", constraint.ID, ErrNoValidMicrodata)
+	}
+	sample := make([]MicroData, n)
+	for i := range sample {
+		sample[i] = p.records[valid[rng.Intn(len(valid))]]
+	}
+	return sample, nil
+}
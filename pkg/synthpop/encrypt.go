@@ -0,0 +1,207 @@
+package synthpop
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Encrypted outputs are a stream of AES-GCM sealed chunks so that multi-GB ID files
+// never have to be held in memory:
+//
+//	magic | salt (32 bytes) | { chunk length (4 bytes) | sealed chunk }...
+//
+// The chunks of a file are sealed with a key of its own, derived with HKDF-SHA256
+// from the long-lived key and the random salt, so the nonce is just a 64-bit chunk
+// counter and never repeats under a key whatever the number of files written. The
+// last chunk is sealed with a "final" flag as additional data so truncated files
+// fail to decrypt, and nothing may follow it.
+const (
+	// EncryptionKeyEnv names the environment variable holding the hex encoded AES key
+	EncryptionKeyEnv = "GOSYNTHPOP_KEY"
+
+	encryptionMagic     = "GSPENC2\n"
+	encryptionSaltSize  = 32
+	encryptionChunkSize = 64 * 1024
+	encryptionInfo      = "GoSynthPop output chunks"
+)
+
+// LoadEncryptionKey reads the AES key (16, 24 or 32 bytes, hex encoded) from the environment
+func LoadEncryptionKey() ([]byte, error) {
+	encoded := strings.TrimSpace(os.Getenv(EncryptionKeyEnv))
+	if encoded == "" {
+		return nil, fmt.Errorf("encryption requested but %s is not set", EncryptionKeyEnv)
+	}
+	key, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", EncryptionKeyEnv, err)
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("invalid %s: key must be 16, 24 or 32 bytes, got %d", EncryptionKeyEnv, len(key))
+	}
+}
+
+// newFileGCM returns the AES-GCM cipher of one file, keyed with the subkey derived
+// from key and the file's salt. The subkey is as long as key.
+func newFileGCM(key, salt []byte) (cipher.AEAD, error) {
+	subkey, err := hkdf.Key(sha256.New, key, salt, encryptionInfo, len(key))
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(subkey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(counter uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], counter)
+	return nonce
+}
+
+func chunkAAD(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// encryptingWriter seals everything written to it into the chunked AES-GCM format.
+// Close must be called to write the final chunk; it does not close the underlying writer.
+type encryptingWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	counter uint64
+	buf     []byte
+}
+
+// newEncryptingWriter writes the stream header to w and returns a writer sealing data into it
+func newEncryptingWriter(w io.Writer, key []byte) (*encryptingWriter, error) {
+	salt := make([]byte, encryptionSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := newFileGCM(key, salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, encryptionMagic); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(salt); err != nil {
+		return nil, err
+	}
+	return &encryptingWriter{w: w, aead: aead, buf: make([]byte, 0, encryptionChunkSize)}, nil
+}
+
+func (e *encryptingWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+		if len(e.buf) == cap(e.buf) {
+			if err := e.sealChunk(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// WriteString lets the fractions writer keep writing raw strings
+func (e *encryptingWriter) WriteString(s string) (int, error) {
+	return e.Write([]byte(s))
+}
+
+func (e *encryptingWriter) sealChunk(final bool) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.counter), e.buf, chunkAAD(final))
+	e.counter++
+	e.buf = e.buf[:0]
+
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(sealed)))
+	if _, err := e.w.Write(length[:]); err != nil {
+		return err
+	}
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+// Close seals the remaining buffered data as the final chunk
+func (e *encryptingWriter) Close() error {
+	return e.sealChunk(true)
+}
+
+// DecryptStream reverses encryptingWriter, failing on tampered or truncated input and
+// on data after the final chunk
+func DecryptStream(dst io.Writer, src io.Reader, key []byte) error {
+	header := make([]byte, len(encryptionMagic)+encryptionSaltSize)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return fmt.Errorf("error reading encryption header: %w", err)
+	}
+	if string(header[:len(encryptionMagic)]) != encryptionMagic {
+		return errors.New("input is not a GoSynthPop encrypted file")
+	}
+	aead, err := newFileGCM(key, header[len(encryptionMagic):])
+	if err != nil {
+		return err
+	}
+
+	var length [4]byte
+	sealed := make([]byte, 0, encryptionChunkSize+aead.Overhead())
+	for counter := uint64(0); ; counter++ {
+		if _, err := io.ReadFull(src, length[:]); err != nil {
+			return fmt.Errorf("encrypted file is truncated: %w", err)
+		}
+		// The length is not authenticated: bound it before reading the chunk
+		n := binary.BigEndian.Uint32(length[:])
+		if n > uint32(cap(sealed)) {
+			return fmt.Errorf("chunk %d of %d bytes is longer than a sealed chunk (corrupted file)", counter, n)
+		}
+		sealed = sealed[:n]
+		if _, err := io.ReadFull(src, sealed); err != nil {
+			return fmt.Errorf("encrypted file is truncated: %w", err)
+		}
+
+		nonce := chunkNonce(counter)
+		plain, err := aead.Open(nil, nonce, sealed, chunkAAD(false))
+		final := false
+		if err != nil {
+			// The last chunk is sealed with the final flag
+			plain, err = aead.Open(nil, nonce, sealed, chunkAAD(true))
+			if err != nil {
+				return fmt.Errorf("cannot decrypt chunk %d (wrong key or corrupted file): %w", counter, err)
+			}
+			final = true
+		}
+		if _, err := dst.Write(plain); err != nil {
+			return err
+		}
+		if final {
+			switch _, err := io.ReadFull(src, length[:1]); err {
+			case io.EOF:
+				return nil
+			case nil:
+				return errors.New("encrypted file has data after its final chunk")
+			default:
+				return err
+			}
+		}
+	}
+}
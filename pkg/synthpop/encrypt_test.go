@@ -0,0 +1,136 @@
+package synthpop
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// encryptForTest seals plain with key as the outputs are written
+func encryptForTest(t *testing.T, key, plain []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	enc, err := newEncryptingWriter(&buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Written in uneven pieces, so chunks are filled across writes
+	for len(plain) > 0 {
+		n := min(len(plain), 1000)
+		if _, err := enc.Write(plain[:n]); err != nil {
+			t.Fatal(err)
+		}
+		plain = plain[n:]
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testPlaintext(n int) []byte {
+	plain := make([]byte, n)
+	for i := range plain {
+		plain[i] = byte(i*7 + i/251)
+	}
+	return plain
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		key  []byte
+		size int
+	}{
+		{"empty", bytes.Repeat([]byte{1}, 32), 0},
+		{"one byte", bytes.Repeat([]byte{2}, 32), 1},
+		{"one chunk", bytes.Repeat([]byte{3}, 32), encryptionChunkSize},
+		{"chunk and a byte", bytes.Repeat([]byte{4}, 32), encryptionChunkSize + 1},
+		{"several chunks", bytes.Repeat([]byte{5}, 32), 3*encryptionChunkSize + 17},
+		{"AES-128", bytes.Repeat([]byte{6}, 16), 5000},
+		{"AES-192", bytes.Repeat([]byte{7}, 24), 5000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain := testPlaintext(tt.size)
+			sealed := encryptForTest(t, tt.key, plain)
+			var got bytes.Buffer
+			if err := DecryptStream(&got, bytes.NewReader(sealed), tt.key); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.Bytes(), plain) {
+				t.Errorf("decrypted %d bytes, not the %d written", got.Len(), len(plain))
+			}
+		})
+	}
+}
+
+// TestEncryptSalts checks that every file is sealed under a key of its own: the same
+// data under the same key encrypt differently
+func TestEncryptSalts(t *testing.T) {
+	key := bytes.Repeat([]byte{9}, 32)
+	plain := testPlaintext(100)
+	a, b := encryptForTest(t, key, plain), encryptForTest(t, key, plain)
+	saltEnd := len(encryptionMagic) + encryptionSaltSize
+	if bytes.Equal(a[len(encryptionMagic):saltEnd], b[len(encryptionMagic):saltEnd]) {
+		t.Error("two files have the same salt")
+	}
+	if bytes.Equal(a[saltEnd:], b[saltEnd:]) {
+		t.Error("two files have the same chunks")
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	// Two chunks: a full one, then the final one
+	sealed := encryptForTest(t, key, testPlaintext(encryptionChunkSize+100))
+	header := len(encryptionMagic) + encryptionSaltSize
+	firstChunk := header + 4 + encryptionChunkSize + 16
+	modified := func(f func(b []byte) []byte) []byte {
+		return f(bytes.Clone(sealed))
+	}
+	flip := func(at int) []byte {
+		return modified(func(b []byte) []byte { b[at] ^= 0x10; return b })
+	}
+
+	tests := []struct {
+		name  string
+		input []byte
+		key   []byte
+		want  string
+	}{
+		{"wrong key", sealed, bytes.Repeat([]byte{2}, 32), "wrong key or corrupted file"},
+		{"invalid key", sealed, []byte{1, 2, 3}, "invalid key size"},
+		{"not encrypted", []byte("area,total\nE01,3\n" + strings.Repeat(" ", 40)), key, "not a GoSynthPop encrypted file"},
+		{"short header", sealed[:len(encryptionMagic)+3], key, "error reading encryption header"},
+		{"flipped salt", flip(len(encryptionMagic)), key, "chunk 0"},
+		{"flipped first chunk", flip(header + 100), key, "chunk 0"},
+		{"flipped final chunk", flip(len(sealed) - 1), key, "chunk 1"},
+		{"flipped length", flip(header + 2), key, "longer than a sealed chunk"},
+		{"huge length", modified(func(b []byte) []byte {
+			binary.BigEndian.PutUint32(b[header:], 0xffffffff)
+			return b
+		}), key, "longer than a sealed chunk"},
+		{"shortened length", modified(func(b []byte) []byte {
+			binary.BigEndian.PutUint32(b[header:], encryptionChunkSize)
+			return b
+		}), key, "chunk 0"},
+		{"final chunk dropped", sealed[:firstChunk], key, "truncated"},
+		{"final chunk cut", sealed[:len(sealed)-5], key, "truncated"},
+		{"length cut", sealed[:firstChunk+2], key, "truncated"},
+		{"chunks swapped", modified(func(b []byte) []byte {
+			return append(append(b[:header:header], b[firstChunk:]...), sealed[header:firstChunk]...)
+		}), key, "chunk 0"},
+		{"trailing byte", append(bytes.Clone(sealed), 0), key, "data after its final chunk"},
+		{"trailing file", append(bytes.Clone(sealed), sealed...), key, "data after its final chunk"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := DecryptStream(&bytes.Buffer{}, bytes.NewReader(tt.input), tt.key)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
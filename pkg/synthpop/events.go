@@ -0,0 +1,182 @@
+package synthpop
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// Progress events: orchestration tools (Airflow, Nextflow) running a synthesis need
+// its status without scraping the emoji ticker. In the json progress format every
+// load report, progress update, finished area and the end of the run is written to
+// stdout as one JSON object per line, with an "event" field naming its kind, and
+// the console messages move to stderr so stdout holds nothing but events.
+
+// Progress formats of the console
+const (
+	ProgressText = "text" // Progress ticker and messages on stdout (default)
+	ProgressJSON = "json" // Newline-delimited JSON events on stdout, messages on stderr
+)
+
+// ValidProgressFormats lists the accepted values of SetProgressFormat
+var ValidProgressFormats = []string{ProgressText, ProgressJSON}
+
+// events receives the JSON events, nil in the text format
+var events io.Writer
+
+// SetProgressFormat switches the console between the text ticker and JSON events
+//
+// Parameters:
+//   - format: ProgressText ("" alike) or ProgressJSON
+//
+// Returns:
+//   - error: An unknown format
+func SetProgressFormat(format string) error {
+	consoleMu.Lock()
+	defer consoleMu.Unlock()
+	switch format {
+	case "", ProgressText:
+		console, events = os.Stdout, nil
+	case ProgressJSON:
+		console, events = os.Stderr, os.Stdout
+	default:
+		return fmt.Errorf("unknown progress format %q, expected one of %s", format, strings.Join(ValidProgressFormats, ", "))
+	}
+	return nil
+}
+
+// jsonEvents reports whether progress is reported as JSON events
+func jsonEvents() bool {
+	consoleMu.Lock()
+	defer consoleMu.Unlock()
+	return events != nil
+}
+
+// eventHeader starts every event
+type eventHeader struct {
+	Event string    `json:"event"` // load, progress, area or summary
+	Time  time.Time `json:"time"`
+	Run   string    `json:"run,omitempty"`
+}
+
+// loadEvent reports how far the loading of an input file has got
+type loadEvent struct {
+	eventHeader
+	File           string  `json:"file"`
+	Rows           int     `json:"rows"`
+	Bytes          int64   `json:"bytes"`
+	Size           int64   `json:"size,omitempty"`
+	ElapsedSeconds float64 `json:"elapsedSeconds"`
+}
+
+// progressEvent is the periodic progress of the synthesis
+type progressEvent struct {
+	eventHeader
+	Done           int     `json:"done"`
+	Total          int     `json:"total"`
+	ElapsedSeconds float64 `json:"elapsedSeconds"`
+	ETASeconds     float64 `json:"etaSeconds"`
+	MemoryMB       uint64  `json:"memoryMB"`
+}
+
+// areaEvent reports an area once its outputs are written, or its failure
+type areaEvent struct {
+	eventHeader
+	Area       string   `json:"area"`
+	Fitness    *float64 `json:"fitness,omitempty"`
+	Population float64  `json:"population,omitempty"`
+	Iterations int      `json:"iterations,omitempty"`
+	TimedOut   bool     `json:"timedOut,omitempty"`
+	Error      string   `json:"error,omitempty"`
+	Done       int      `json:"done"`
+	Total      int      `json:"total"`
+}
+
+// summaryEvent ends the events of a run
+type summaryEvent struct {
+	eventHeader
+	Status         string   `json:"status"` // completed or failed
+	Synthesized    int      `json:"synthesized"`
+	Failed         int      `json:"failed"`
+	WorstFitness   *float64 `json:"worstFitness,omitempty"`
+	WorstArea      string   `json:"worstArea,omitempty"`
+	ElapsedSeconds float64  `json:"elapsedSeconds"`
+	Error          string   `json:"error,omitempty"`
+}
+
+// newEventHeader returns the header of an event of the given kind
+func newEventHeader(event, run string) eventHeader {
+	return eventHeader{Event: event, Time: time.Now().UTC(), Run: run}
+}
+
+// finiteOrNil returns a pointer to v, or nil for the infinities and NaN JSON cannot hold
+func finiteOrNil(v float64) *float64 {
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return nil
+	}
+	return &v
+}
+
+// emitEvent writes event as one line of JSON, when the progress format is json
+func emitEvent(event any) {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	consoleMu.Lock()
+	defer consoleMu.Unlock()
+	if events != nil {
+		events.Write(append(line, '\n'))
+	}
+}
+
+// emitProgress writes a progress event
+func emitProgress(p RunProgress) {
+	emitEvent(progressEvent{eventHeader: newEventHeader("progress", p.RunName), Done: p.Done, Total: p.Total,
+		ElapsedSeconds: p.Elapsed.Seconds(), ETASeconds: p.ETA.Seconds(), MemoryMB: p.MemoryMB})
+}
+
+// emitLoad writes a load event
+func emitLoad(p LoadProgress) {
+	emitEvent(loadEvent{eventHeader: newEventHeader("load", ""), File: p.File, Rows: p.Rows, Bytes: p.Bytes,
+		Size: p.Size, ElapsedSeconds: p.Elapsed.Seconds()})
+}
+
+// emitArea writes the event of a finished area, failed when areaErr is not nil
+func emitArea(run string, res Result, areaErr error, done, total int) {
+	event := areaEvent{eventHeader: newEventHeader("area", run), Area: res.Area, Done: done, Total: total}
+	if areaErr != nil {
+		event.Error = areaErr.Error()
+	} else {
+		event.Fitness, event.Population, event.Iterations, event.TimedOut =
+			finiteOrNil(res.Fitness), res.Population, res.Iterations, res.TimedOut
+	}
+	emitEvent(event)
+}
+
+// emitSummary writes the last event of a run
+//
+// Parameters:
+//   - run: The run name
+//   - manifest: The run's manifest builder, nil when the run failed before it started
+//   - elapsed: The duration of the run
+//   - runErr: Why the run failed, nil when it completed
+func emitSummary(run string, manifest *manifestBuilder, elapsed time.Duration, runErr error) {
+	event := summaryEvent{eventHeader: newEventHeader("summary", run), Status: "completed",
+		ElapsedSeconds: elapsed.Seconds()}
+	if runErr != nil {
+		event.Status, event.Error = "failed", runErr.Error()
+	}
+	if manifest != nil {
+		areas := manifest.manifest.Areas
+		event.Synthesized, event.Failed = len(manifest.fitness), areas.Failed
+		if len(manifest.fitness) > 0 {
+			event.WorstFitness, event.WorstArea = finiteOrNil(areas.Max), areas.WorstArea
+		}
+	}
+	emitEvent(event)
+}
@@ -0,0 +1,136 @@
+package synthpop
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+)
+
+// agentExporter writes the synthetic population in formats that agent-based and
+// transport models read directly: a generic agents CSV (one row per agent with its
+// area and attributes) and a MATSim population XML file. Agent IDs are the area
+// code followed by the agent's position in the area, so they are unique per run.
+type agentExporter struct {
+	header    []string
+	microData []MicroData
+	byID      map[string]int
+
+	csvFile   *outputFile
+	csvWriter *csv.Writer
+	xmlFile   *outputFile
+	xmlWriter *bufio.Writer
+}
+
+// newAgentExporter creates the configured agent outputs, or returns nil when none are set
+func newAgentExporter(popConfig PopulationConfig, header []string, microData []MicroData, key []byte, retry retryPolicy) (*agentExporter, error) {
+	if popConfig.Output.AgentsFile == "" && popConfig.Output.MatsimFile == "" {
+		return nil, nil
+	}
+	if popConfig.Output.AggregateOnly {
+		return nil, fmt.Errorf("agent exports need the individual assignments, disable aggregateOnly")
+	}
+
+	exporter := &agentExporter{header: header, microData: microData, byID: make(map[string]int, len(microData))}
+	for i, md := range microData {
+		exporter.byID[md.ID] = i
+	}
+
+	if popConfig.Output.AgentsFile != "" {
+		file, err := createOutput(popConfig.Output.AgentsFile, key, retry)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create agents file: %w", err)
+		}
+		exporter.csvFile = file
+		exporter.csvWriter = csv.NewWriter(file)
+		row := append([]string{"agent_id", "area_id", "microdata_id"}, header...)
+		if err := exporter.csvWriter.Write(row); err != nil {
+			exporter.Close()
+			return nil, fmt.Errorf("error writing agents header: %w", err)
+		}
+	}
+
+	if popConfig.Output.MatsimFile != "" {
+		file, err := createOutput(popConfig.Output.MatsimFile, key, retry)
+		if err != nil {
+			exporter.Close()
+			return nil, fmt.Errorf("cannot create MATSim population file: %w", err)
+		}
+		exporter.xmlFile = file
+		exporter.xmlWriter = bufio.NewWriter(file)
+		exporter.xmlWriter.WriteString(xml.Header)
+		exporter.xmlWriter.WriteString("<!DOCTYPE population SYSTEM \"http://www.matsim.org/files/dtd/population_v6.dtd\">\n")
+		exporter.xmlWriter.WriteString("<population>\n")
+	}
+	return exporter, nil
+}
+
+// writeArea writes every synthetic individual of one area
+func (a *agentExporter) writeArea(res Result) error {
+	for n, id := range res.IDs {
+		index, ok := a.byID[id]
+		if !ok {
+			return fmt.Errorf("microdata ID %s not found", id)
+		}
+		values := a.microData[index].Values
+		agentID := res.Area + "_" + strconv.Itoa(n+1)
+
+		if a.csvWriter != nil {
+			row := make([]string, 0, len(values)+3)
+			row = append(row, agentID, res.Area, id)
+			for _, v := range values {
+				row = append(row, strconv.FormatFloat(v, 'f', -1, 64))
+			}
+			if err := a.csvWriter.Write(row); err != nil {
+				return fmt.Errorf("error writing agents row: %w", err)
+			}
+		}
+
+		if a.xmlWriter != nil {
+			w := a.xmlWriter
+			w.WriteString("\t<person id=\"")
+			xml.EscapeText(w, []byte(agentID))
+			w.WriteString("\">\n\t\t<attributes>\n")
+			writeMatsimAttribute(w, "area", "java.lang.String", res.Area)
+			writeMatsimAttribute(w, "microdataId", "java.lang.String", id)
+			for i, v := range values {
+				writeMatsimAttribute(w, a.header[i], "java.lang.Double", strconv.FormatFloat(v, 'f', -1, 64))
+			}
+			if _, err := w.WriteString("\t\t</attributes>\n\t</person>\n"); err != nil {
+				return fmt.Errorf("error writing MATSim person: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+func writeMatsimAttribute(w *bufio.Writer, name, class, value string) {
+	w.WriteString("\t\t\t<attribute name=\"")
+	xml.EscapeText(w, []byte(name))
+	w.WriteString("\" class=\"" + class + "\">")
+	xml.EscapeText(w, []byte(value))
+	w.WriteString("</attribute>\n")
+}
+
+// Close completes and closes the agent outputs, returning the first error met
+func (a *agentExporter) Close() error {
+	var firstErr error
+	keep := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if a.csvWriter != nil {
+		a.csvWriter.Flush()
+		keep(a.csvWriter.Error())
+		keep(a.csvFile.Close())
+	}
+	if a.xmlWriter != nil {
+		a.xmlWriter.WriteString("</population>\n")
+		keep(a.xmlWriter.Flush())
+		keep(a.xmlFile.Close())
+	}
+	return firstErr
+}
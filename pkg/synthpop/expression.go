@@ -0,0 +1,361 @@
+package synthpop
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expression is a compiled arithmetic expression over the columns of a record,
+// e.g. "employed + unemployed" or "(age16_64 + age65p) / 2". It supports numbers,
+// column names, + - * /, unary minus, parentheses, the functions min, max and pow
+// and the functions abs, sqrt, log and exp.
+type Expression struct {
+	source string
+	eval   func(values []float64) float64
+}
+
+// CompileExpression parses src, resolving column names against header
+func CompileExpression(src string, header []string) (*Expression, error) {
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[name] = i
+	}
+	p := &exprParser{src: src, columns: columns}
+	p.next()
+	eval, err := p.parseSum()
+	if err == nil && p.tok.kind != tokEOF {
+		err = p.errorf("unexpected %q", p.tok.text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("expression %q: %w", src, err)
+	}
+	return &Expression{source: src, eval: eval}, nil
+}
+
+// Eval evaluates the expression on a record laid out like the compile header
+func (e *Expression) Eval(values []float64) float64 {
+	return e.eval(values)
+}
+
+func (e *Expression) String() string {
+	return e.source
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokIdent
+	tokOp // + - * / ( ) ,
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value float64
+	pos   int
+}
+
+// exprParser is a recursive-descent parser that compiles straight to closures
+type exprParser struct {
+	src     string
+	pos     int
+	tok     token
+	columns map[string]int
+}
+
+func (p *exprParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("at offset %d: %s", p.tok.pos, fmt.Sprintf(format, args...))
+}
+
+func isIdentRune(r rune, first bool) bool {
+	if r == '_' || unicode.IsLetter(r) {
+		return true
+	}
+	return !first && (unicode.IsDigit(r) || r == '.')
+}
+
+// next advances to the following token
+func (p *exprParser) next() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos >= len(p.src) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return
+	}
+
+	c := rune(p.src[p.pos])
+	switch {
+	case strings.ContainsRune("+-*/(),", c):
+		p.pos++
+		p.tok = token{kind: tokOp, text: string(c), pos: start}
+	case unicode.IsDigit(c) || c == '.':
+		for p.pos < len(p.src) && (unicode.IsDigit(rune(p.src[p.pos])) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		// Exponent, e.g. 1e-3
+		if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+			p.pos++
+			if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+				p.pos++
+			}
+			for p.pos < len(p.src) && unicode.IsDigit(rune(p.src[p.pos])) {
+				p.pos++
+			}
+		}
+		text := p.src[start:p.pos]
+		value, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			p.tok = token{kind: tokOp, text: text, pos: start} // Reported as unexpected by the parser
+			return
+		}
+		p.tok = token{kind: tokNumber, text: text, value: value, pos: start}
+	case isIdentRune(c, true):
+		for p.pos < len(p.src) && isIdentRune(rune(p.src[p.pos]), false) {
+			p.pos++
+		}
+		p.tok = token{kind: tokIdent, text: p.src[start:p.pos], pos: start}
+	default:
+		p.pos++
+		p.tok = token{kind: tokOp, text: string(c), pos: start}
+	}
+}
+
+// parseSum parses term (('+' | '-') term)*
+func (p *exprParser) parseSum() (func([]float64) float64, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOp && (p.tok.text == "+" || p.tok.text == "-") {
+		op := p.tok.text
+		p.next()
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		if op == "+" {
+			left = func(v []float64) float64 { return l(v) + right(v) }
+		} else {
+			left = func(v []float64) float64 { return l(v) - right(v) }
+		}
+	}
+	return left, nil
+}
+
+// parseProduct parses unary (('*' | '/') unary)*
+func (p *exprParser) parseProduct() (func([]float64) float64, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOp && (p.tok.text == "*" || p.tok.text == "/") {
+		op := p.tok.text
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		if op == "*" {
+			left = func(v []float64) float64 { return l(v) * right(v) }
+		} else {
+			left = func(v []float64) float64 { return l(v) / right(v) }
+		}
+	}
+	return left, nil
+}
+
+// parseUnary parses '-' unary | primary
+func (p *exprParser) parseUnary() (func([]float64) float64, error) {
+	if p.tok.kind == tokOp && p.tok.text == "-" {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(v []float64) float64 { return -operand(v) }, nil
+	}
+	return p.parsePrimary()
+}
+
+// exprFunctions are the functions callable in expressions
+var exprFunctions = map[string]func(a, b float64) float64{
+	"min": math.Min,
+	"max": math.Max,
+	"pow": math.Pow,
+}
+
+// exprFunctions1 are the one-argument functions callable in expressions
+var exprFunctions1 = map[string]func(a float64) float64{
+	"abs":  math.Abs,
+	"sqrt": math.Sqrt,
+	"log":  math.Log,
+	"exp":  math.Exp,
+}
+
+// parsePrimary parses a number, a column, a function call or a parenthesised sum
+func (p *exprParser) parsePrimary() (func([]float64) float64, error) {
+	tok := p.tok
+	switch {
+	case tok.kind == tokNumber:
+		p.next()
+		return func([]float64) float64 { return tok.value }, nil
+
+	case tok.kind == tokIdent:
+		p.next()
+		if fn, ok := exprFunctions[tok.text]; ok && p.tok.kind == tokOp && p.tok.text == "(" {
+			return p.parseCall(tok.text, fn)
+		}
+		if fn, ok := exprFunctions1[tok.text]; ok && p.tok.kind == tokOp && p.tok.text == "(" {
+			return p.parseCall1(tok.text, fn)
+		}
+		column, ok := p.columns[tok.text]
+		if !ok {
+			return nil, fmt.Errorf("at offset %d: unknown column %q", tok.pos, tok.text)
+		}
+		return func(v []float64) float64 { return v[column] }, nil
+
+	case tok.kind == tokOp && tok.text == "(":
+		p.next()
+		inner, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokOp || p.tok.text != ")" {
+			return nil, p.errorf("missing )")
+		}
+		p.next()
+		return inner, nil
+
+	case tok.kind == tokEOF:
+		return nil, p.errorf("unexpected end of expression")
+	default:
+		return nil, p.errorf("unexpected %q", tok.text)
+	}
+}
+
+// parseCall parses the argument list of a two-argument function
+func (p *exprParser) parseCall(name string, fn func(a, b float64) float64) (func([]float64) float64, error) {
+	p.next() // (
+	a, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokOp || p.tok.text != "," {
+		return nil, p.errorf("%s takes two arguments", name)
+	}
+	p.next()
+	b, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokOp || p.tok.text != ")" {
+		return nil, p.errorf("missing ) after the arguments of %s", name)
+	}
+	p.next()
+	return func(v []float64) float64 { return fn(a(v), b(v)) }, nil
+}
+
+// parseCall1 parses the argument of a one-argument function
+func (p *exprParser) parseCall1(name string, fn func(a float64) float64) (func([]float64) float64, error) {
+	p.next() // (
+	a, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokOp || p.tok.text != ")" {
+		return nil, p.errorf("%s takes one argument", name)
+	}
+	p.next()
+	return func(v []float64) float64 { return fn(a(v)) }, nil
+}
+
+// derivedColumns are compiled derived column definitions, in name order
+type derivedColumns struct {
+	names       []string
+	expressions []*Expression
+}
+
+// compileDerived compiles the derived column definitions of a config against the
+// input header. Expressions refer to input columns only, not to other derived ones.
+func compileDerived(derived map[string]string, header []string) (*derivedColumns, error) {
+	existing := make(map[string]bool, len(header))
+	for _, name := range header {
+		existing[name] = true
+	}
+
+	d := &derivedColumns{}
+	for name := range derived {
+		d.names = append(d.names, name)
+	}
+	sort.Strings(d.names)
+	for _, name := range d.names {
+		if existing[name] {
+			return nil, fmt.Errorf("derived column %s already exists in the input", name)
+		}
+		expr, err := CompileExpression(derived[name], header)
+		if err != nil {
+			return nil, fmt.Errorf("derived column %s: %w", name, err)
+		}
+		d.expressions = append(d.expressions, expr)
+	}
+	return d, nil
+}
+
+// extend returns values followed by the derived columns computed from them
+func (d *derivedColumns) extend(values []float64, id string) ([]float64, error) {
+	extended := make([]float64, len(values), len(values)+len(d.expressions))
+	copy(extended, values)
+	for i, expr := range d.expressions {
+		v := expr.Eval(values)
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("derived column %s is %v for %s", d.names[i], v, id)
+		}
+		extended = append(extended, v)
+	}
+	return extended, nil
+}
+
+// DeriveConstraints returns copies of the constraints with the derived columns
+// (name -> expression over the header) appended, and the extended header.
+// The input slices are not modified, so cached inputs can be shared.
+func DeriveConstraints(derived map[string]string, header []string, constraints []ConstraintData) ([]ConstraintData, []string, error) {
+	d, err := compileDerived(derived, header)
+	if err != nil {
+		return nil, nil, err
+	}
+	out := make([]ConstraintData, len(constraints))
+	for i, c := range constraints {
+		values, err := d.extend(c.Values, "area "+c.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		c.Values = values
+		out[i] = c
+	}
+	return out, append(append([]string(nil), header...), d.names...), nil
+}
+
+// DeriveMicroData is DeriveConstraints for microdata records
+func DeriveMicroData(derived map[string]string, header []string, microData []MicroData) ([]MicroData, []string, error) {
+	d, err := compileDerived(derived, header)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err := mapRows(microData, func(md MicroData) ([]float64, error) {
+		return d.extend(md.Values, "record "+md.ID)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, append(append([]string(nil), header...), d.names...), nil
+}
@@ -0,0 +1,280 @@
+package synthpop
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestCompileExpression(t *testing.T) {
+	header := []string{"a", "b", "age16_64", "x.2", "min"}
+	values := []float64{2, 3, 10, 0.5, 7}
+	tests := []struct {
+		src  string
+		want float64
+	}{
+		{"42", 42},
+		{"1.5e2", 150},
+		{"2.5E-1", 0.25},
+		{".5", 0.5},
+		{"a", 2},
+		{"age16_64 + x.2", 10.5},
+		// Precedence and associativity
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"a + b * age16_64", 32},
+		{"10 - 4 - 3", 3},
+		{"10 - (4 - 3)", 9},
+		{"8 / 4 / 2", 1},
+		{"8 / (4 / 2)", 4},
+		{"2 * 3 / 4", 1.5},
+		{"1 - 2 * 3 + 4 / 2", -3},
+		// Unary minus binds tighter than * and /, and repeats
+		{"-a", -2},
+		{"-a * b", -6},
+		{"a * -b", -6},
+		{"- -a", 2},
+		{"--a", 2},
+		{"-(a + b)", -5},
+		{"b - -a", 5},
+		{"-2 * -3", 6},
+		// Functions, a column named like one when not called
+		{"min(a, b)", 2},
+		{"max(a, b) * 2", 6},
+		{"pow(a, b)", 8},
+		{"pow(-a, 2)", 4},
+		{"min + 1", 8},
+		{"abs(a - b)", 1},
+		{"sqrt(pow(3, 2) + pow(4, 2))", 5},
+		{"exp(0) + log(1)", 1},
+		{"max(min(a, b), x.2)", 2},
+		{"\ta*b\t", 6},
+		// Division by zero follows IEEE 754
+		{"a / 0", math.Inf(1)},
+		{"-a / 0", math.Inf(-1)},
+		{"a / (b - 3)", math.Inf(1)},
+		{"0 / 0", math.NaN()},
+		{"sqrt(-1)", math.NaN()},
+		{"log(0)", math.Inf(-1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			expr, err := CompileExpression(tt.src, header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := expr.Eval(values)
+			if got != tt.want && !(math.IsNaN(got) && math.IsNaN(tt.want)) {
+				t.Errorf("%s = %v, want %v", tt.src, got, tt.want)
+			}
+			if expr.String() != tt.src {
+				t.Errorf("String() = %q", expr.String())
+			}
+		})
+	}
+}
+
+func TestCompileExpressionErrors(t *testing.T) {
+	header := []string{"a", "b"}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		// Unknown identifiers, including functions called with the wrong name
+		{"c", `at offset 0: unknown column "c"`},
+		{"a + unknown * 2", `at offset 4: unknown column "unknown"`},
+		{"A", `unknown column "A"`},
+		{"sin(a)", `unknown column "sin"`},
+		// Malformed input
+		{"", "at offset 0: unexpected end of expression"},
+		{"   ", "unexpected end of expression"},
+		{"a +", "at offset 3: unexpected end of expression"},
+		{"a * / b", `at offset 4: unexpected "/"`},
+		{"* a", `at offset 0: unexpected "*"`},
+		{"(a + b", "at offset 6: missing )"},
+		{"((a)", "missing )"},
+		{"a + b)", `at offset 5: unexpected ")"`},
+		{"()", `unexpected ")"`},
+		{"a b", `at offset 2: unexpected "b"`},
+		{"2 a", `unexpected "a"`},
+		{"1..2", `at offset 0: unexpected "1..2"`},
+		{"1e", `unexpected "1e"`},
+		{"a $ b", `at offset 2: unexpected "$"`},
+		{"a ^ 2", `unexpected "^"`},
+		{"a, b", `unexpected ","`},
+		{"min(a)", "min takes two arguments"},
+		{"min(a, b", "missing ) after the arguments of min"},
+		{"max(a, b, 1)", "missing ) after the arguments of max"},
+		{"pow(, 2)", `unexpected ","`},
+		{"abs(a, b)", "abs takes one argument"},
+		{"sqrt(", "unexpected end of expression"},
+		{"exp()", `unexpected ")"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			_, err := CompileExpression(tt.src, header)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error %v, want one containing %q", err, tt.want)
+			}
+			if !strings.HasPrefix(err.Error(), "expression \""+tt.src+"\": ") {
+				t.Errorf("error %q does not quote the expression", err)
+			}
+		})
+	}
+}
+
+func TestDeriveColumns(t *testing.T) {
+	header := []string{"employed", "unemployed"}
+	derived := map[string]string{"share": "employed / (employed + unemployed)", "active": "employed + unemployed"}
+	constraints := []ConstraintData{{ID: "E01", Values: []float64{3, 1}}, {ID: "E02", Values: []float64{0, 5}}}
+
+	got, gotHeader, err := DeriveConstraints(derived, header, constraints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The derived columns come in name order, after the input ones
+	if strings.Join(gotHeader, ",") != "employed,unemployed,active,share" {
+		t.Errorf("header %v", gotHeader)
+	}
+	if v := got[0].Values; len(v) != 4 || v[2] != 4 || v[3] != 0.75 {
+		t.Errorf("area E01 derived as %v", v)
+	}
+	if len(constraints[0].Values) != 2 {
+		t.Error("input constraints modified")
+	}
+
+	tests := []struct {
+		name    string
+		derived map[string]string
+		want    string
+	}{
+		{"division by zero", map[string]string{"share": "employed / unemployed"}, "derived column share is +Inf for record p2"},
+		{"undefined", map[string]string{"ratio": "unemployed / employed - unemployed / employed"}, "derived column ratio is NaN for record p1"},
+		{"existing", map[string]string{"employed": "1"}, "derived column employed already exists"},
+		{"derived from derived", map[string]string{"a": "employed", "b": "a * 2"}, `derived column b: expression "a * 2": at offset 0: unknown column "a"`},
+		{"malformed", map[string]string{"a": "employed +"}, "derived column a: "},
+	}
+	microData := []MicroData{{ID: "p1", Values: []float64{0, 1}}, {ID: "p2", Values: []float64{1, 0}}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := DeriveMicroData(tt.derived, header, microData)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestExpressionMetrics evaluates expression metrics in full, against the built-in
+// metric they restate, and incrementally as sumMetricOf turns them into sums of
+// per-variable terms
+func TestExpressionMetrics(t *testing.T) {
+	tests := []struct {
+		name        string
+		metric      MetricExpression
+		builtin     string // Built-in metric computing the same, "" for none
+		c, t        []float64
+		want        float64
+		incremental bool // Whether sumMetricOf takes it, false when it uses C or T
+	}{
+		{"squares", MetricExpression{Term: "(t - c) * (t - c)", Final: "sqrt(s)"}, "EUCLIDEAN",
+			[]float64{3, 0, 1}, []float64{0, 4, 1}, 5, true},
+		{"absolute", MetricExpression{Term: "abs(t - c)"}, "MANHATTEN",
+			[]float64{3, 0, 1}, []float64{0, 4, 1}, 7, true},
+		{"chi squared", MetricExpression{Term: "(t - c) * (t - c) / c"}, "CHI_SQUARED",
+			[]float64{2, 4}, []float64{4, 2}, 3, true},
+		{"precedence", MetricExpression{Term: "t - c * 2 + c / 2"}, "",
+			[]float64{1, 2}, []float64{5, 5}, 5.5, true},
+		{"unary minus", MetricExpression{Term: "c - t", Final: "-s * 2"}, "",
+			[]float64{1, 2}, []float64{3, 5}, 10, true},
+		{"functions", MetricExpression{Term: "pow(t - c, 2)", Final: "max(s / 2, 1)"}, "",
+			[]float64{1, 2}, []float64{3, 2}, 2, true},
+		{"division by zero", MetricExpression{Term: "t / c"}, "",
+			[]float64{0, 1}, []float64{1, 1}, math.Inf(1), true},
+		{"shares", MetricExpression{Term: "abs(t / T - c / C)"}, "",
+			[]float64{1, 3}, []float64{2, 2}, 0.5, false},
+		{"total", MetricExpression{Term: "c * 0", Final: "s + 1"}, "",
+			[]float64{1, 3}, []float64{2, 2}, 1, true},
+	}
+	near := func(got, want float64) bool {
+		return got == want || math.Abs(got-want) <= 1e-9*math.Max(1, math.Abs(want))
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := AnnealingConfig{Distance: "CUSTOM", Metrics: map[string]MetricExpression{"CUSTOM": tt.metric}}
+			if err := checkMetrics(config); err != nil {
+				t.Fatal(err)
+			}
+			distance, err := Metric(config, "CUSTOM")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := distance(tt.c, tt.t); !near(got, tt.want) {
+				t.Errorf("metric = %v, want %v", got, tt.want)
+			}
+			if tt.builtin != "" {
+				builtin, err := Metric(config, tt.builtin)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := builtin(tt.c, tt.t); !near(got, tt.want) {
+					t.Errorf("%s = %v, want %v", tt.builtin, got, tt.want)
+				}
+			}
+
+			sum, ok := sumMetricOf(config, "CUSTOM")
+			if ok != tt.incremental {
+				t.Fatalf("sumMetricOf took the metric: %v, want %v", ok, tt.incremental)
+			}
+			if !ok {
+				return
+			}
+			sums := make([]float64, sum.width)
+			terms := make([]float64, sum.width)
+			for i := range tt.c {
+				sum.term(1, tt.c[i], tt.t[i], terms)
+				for k := range sums {
+					sums[k] += terms[k]
+				}
+			}
+			if got := sum.final(sums); !near(got, tt.want) {
+				t.Errorf("summed metric = %v, want %v", got, tt.want)
+			}
+			// A weight scales the terms of its variable
+			sum.term(3, tt.c[0], tt.t[0], terms)
+			scaled := terms[0]
+			sum.term(1, tt.c[0], tt.t[0], terms)
+			if !near(scaled, 3*terms[0]) && !math.IsNaN(scaled) {
+				t.Errorf("term of weight 3 is %v, want 3 × %v", scaled, terms[0])
+			}
+		})
+	}
+
+	errorTests := []struct {
+		name   string
+		metric MetricExpression
+		want   string
+	}{
+		{"no term", MetricExpression{Final: "s"}, "metrics: 'CUSTOM': term is required"},
+		{"unknown variable", MetricExpression{Term: "t - x"}, `unknown column "x"`},
+		{"term variable in final", MetricExpression{Term: "t - c", Final: "sqrt(s) / C"}, `unknown column "C"`},
+		{"malformed", MetricExpression{Term: "(t - c"}, "missing )"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := AnnealingConfig{Distance: "CUSTOM", Metrics: map[string]MetricExpression{"CUSTOM": tt.metric}}
+			err := checkMetrics(config)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error %v, want one containing %q", err, tt.want)
+			}
+			if _, ok := sumMetricOf(config, "CUSTOM"); ok {
+				t.Error("sumMetricOf took an invalid metric")
+			}
+		})
+	}
+	// Built-in names cannot be redefined
+	config := AnnealingConfig{Metrics: map[string]MetricExpression{"EUCLIDEAN": {Term: "t"}}}
+	if err := checkMetrics(config); err == nil || !strings.Contains(err.Error(), "is a built-in distance") {
+		t.Errorf("error %v, want the built-in name refused", err)
+	}
+}
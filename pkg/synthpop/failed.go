@@ -0,0 +1,77 @@
+package synthpop
+
+import (
+	"encoding/csv"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// defaultFailedAreasFile is written next to the validate file unless configured
+const defaultFailedAreasFile = "failed_areas.csv"
+
+// failedAreasWriter records areas that could not be synthesized or written, so one
+// bad area no longer stops the whole run. The file is only created once an area
+// fails.
+type failedAreasWriter struct {
+	path   string
+	key    []byte
+	retry  retryPolicy
+	file   *outputFile
+	writer *csv.Writer
+	areas  []string
+}
+
+func newFailedAreasWriter(popConfig PopulationConfig, key []byte, retry retryPolicy) *failedAreasWriter {
+	path := popConfig.Output.FailedAreasFile
+	if path == "" {
+		path = filepath.Join(filepath.Dir(popConfig.Validate.File), defaultFailedAreasFile)
+	}
+	return &failedAreasWriter{path: path, key: key, retry: retry}
+}
+
+// add records a failed area with the reason it failed
+func (w *failedAreasWriter) add(area string, reason error) error {
+	if w.file == nil {
+		file, err := createOutput(w.path, w.key, w.retry)
+		if err != nil {
+			return fmt.Errorf("cannot create failed areas file: %w", err)
+		}
+		w.file = file
+		w.writer = csv.NewWriter(file)
+		if err := w.writer.Write([]string{"area_id", "reason"}); err != nil {
+			return err
+		}
+	}
+	w.areas = append(w.areas, area)
+	return w.writer.Write([]string{area, reason.Error()})
+}
+
+// Close flushes and closes the file, if any area failed
+func (w *failedAreasWriter) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// printSummary lists the skipped areas, if any
+func (w *failedAreasWriter) printSummary() {
+	if len(w.areas) == 0 {
+		return
+	}
+	const maxListed = 10
+	listed := w.areas
+	more := ""
+	if len(listed) > maxListed {
+		listed = listed[:maxListed]
+		more = fmt.Sprintf(" and %d more", len(w.areas)-maxListed)
+	}
+	Printf("⚠️ Skipped %d areas that could not be synthesized or written (see %s): %s%s\n",
+		len(w.areas), w.path, strings.Join(listed, ", "), more)
+}
@@ -0,0 +1,149 @@
+package synthpop
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// File formats for the input and output "format" fields
+const (
+	FormatCSV     = "csv"
+	FormatParquet = "parquet"
+)
+
+// fileFormat returns the format of a file: the configured one, or else parquet for
+// a .parquet extension (before any compression suffix) and csv otherwise
+func fileFormat(path, format string) string {
+	if format != "" {
+		return format
+	}
+	if strings.EqualFold(filepath.Ext(trimCompression(path)), ".parquet") {
+		return FormatParquet
+	}
+	return FormatCSV
+}
+
+// checkFormat validates the format field of a config section
+func checkFormat(section, format string) error {
+	switch format {
+	case "", FormatCSV, FormatParquet:
+		return nil
+	}
+	return fmt.Errorf("invalid %s.format '%s'. Must be one of: %s, %s", section, format, FormatCSV, FormatParquet)
+}
+
+// ReadConstraints reads constraints in the given format (see fileFormat), reporting
+// load progress to progress (which may be nil) until ctx is done
+func ReadConstraints(ctx context.Context, filename, format string, progress chan<- LoadProgress) ([]ConstraintData, []string, error) {
+	if fileFormat(filename, format) == FormatParquet {
+		return readConstraintParquet(ctx, filename, progress)
+	}
+	return ReadConstraintCSVContext(ctx, filename, progress)
+}
+
+// ReadMicroData reads microdata in the given format (see fileFormat), reporting
+// load progress to progress (which may be nil) until ctx is done
+func ReadMicroData(ctx context.Context, filename, format string, progress chan<- LoadProgress) ([]MicroData, []string, error) {
+	if fileFormat(filename, format) == FormatParquet {
+		return readMicroDataParquet(ctx, filename, progress)
+	}
+	return ReadMicroDataCSVContext(ctx, filename, progress)
+}
+
+// ReadMicroDataCompact reads microdata like ReadMicroData with the duplicate rows
+// of values shared between records (see ReadMicroDataCSVCompact). Parquet files are
+// compacted once loaded.
+func ReadMicroDataCompact(ctx context.Context, filename, format string, progress chan<- LoadProgress) ([]MicroData, []string, error) {
+	if fileFormat(filename, format) == FormatParquet {
+		data, header, err := readMicroDataParquet(ctx, filename, progress)
+		if err != nil {
+			return nil, nil, err
+		}
+		return compactMicroData(data), header, nil
+	}
+	return ReadMicroDataCSVCompact(ctx, filename, progress)
+}
+
+// parquetIDsWriter writes the ID mapping output as Parquet (area_id, microdata_id,
+// and count in the counts layout)
+type parquetIDsWriter struct {
+	pw    *parquetWriter
+	tally *idTally // Set in the counts layout
+}
+
+func newParquetIDsWriter(path, layout string, key []byte, retry retryPolicy) (*parquetIDsWriter, error) {
+	types := []int32{parquetByteArray, parquetByteArray}
+	var tally *idTally
+	if layout == OutputLayoutCounts {
+		types = append(types, parquetInt64)
+		tally = newIDTally()
+	}
+	pw, err := newParquetWriter(path, idsHeader(layout), types, key, retry)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create IDs file: %w", err)
+	}
+	return &parquetIDsWriter{pw: pw, tally: tally}, nil
+}
+
+func (w *parquetIDsWriter) writeArea(res Result) error {
+	if w.tally != nil {
+		w.tally.reset(res.IDs)
+		for _, id := range w.tally.order {
+			w.pw.appendString(0, res.Area)
+			w.pw.appendString(1, id)
+			w.pw.appendInt64(2, int64(w.tally.counts[id]))
+			if err := w.pw.endRow(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, id := range res.IDs {
+		w.pw.appendString(0, res.Area)
+		w.pw.appendString(1, id)
+		if err := w.pw.endRow(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *parquetIDsWriter) Close() error {
+	return w.pw.Close()
+}
+
+// parquetTotalsWriter writes the validate output as Parquet: the area code, the
+// synthetic total of every variable and best_iteration
+type parquetTotalsWriter struct {
+	pw *parquetWriter
+}
+
+func newParquetTotalsWriter(path string, header []string, key []byte, retry retryPolicy) (*parquetTotalsWriter, error) {
+	names := append(append([]string{"geography_code"}, header...), "best_iteration")
+	types := make([]int32, len(names))
+	types[0] = parquetByteArray
+	for i := range header {
+		types[i+1] = parquetDouble
+	}
+	types[len(types)-1] = parquetInt64
+	pw, err := newParquetWriter(path, names, types, key, retry)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create fractions file: %w", err)
+	}
+	return &parquetTotalsWriter{pw: pw}, nil
+}
+
+func (w *parquetTotalsWriter) writeArea(res Result) error {
+	w.pw.appendString(0, res.Area)
+	for i, v := range res.Totals {
+		w.pw.appendDouble(i+1, v)
+	}
+	w.pw.appendInt64(len(res.Totals)+1, int64(res.BestIteration))
+	return w.pw.endRow()
+}
+
+func (w *parquetTotalsWriter) Close() error {
+	return w.pw.Close()
+}
@@ -0,0 +1,213 @@
+package synthpop
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// GA defaults, used when the ga section leaves them at zero
+const (
+	defaultGAPopulationSize = 50
+	defaultGAGenerations    = 500
+	defaultGAMutationRate   = 0.01
+	defaultGAElite          = 2
+	defaultGATournamentSize = 3
+	defaultGAStallLimit     = 100
+)
+
+// GAConfig holds the settings of the genetic algorithm
+type GAConfig struct {
+	PopulationSize int     `json:"populationSize"` // Candidate populations per generation (default 50)
+	Generations    int     `json:"generations"`    // Generations at most (default 500)
+	MutationRate   float64 `json:"mutationRate"`   // Share of a child's individuals replaced (default 0.01)
+	Elite          int     `json:"elite"`          // Best candidates carried over unchanged (default 2)
+	TournamentSize int     `json:"tournamentSize"` // Candidates compared to select a parent (default 3)
+	StallLimit     int     `json:"stallLimit"`     // Stop after this many generations without improvement (default 100)
+}
+
+// withDefaults fills in the zero settings
+func (c GAConfig) withDefaults() GAConfig {
+	if c.PopulationSize <= 0 {
+		c.PopulationSize = defaultGAPopulationSize
+	}
+	if c.Generations <= 0 {
+		c.Generations = defaultGAGenerations
+	}
+	if c.MutationRate <= 0 {
+		c.MutationRate = defaultGAMutationRate
+	}
+	if c.Elite <= 0 {
+		c.Elite = defaultGAElite
+	}
+	c.Elite = min(c.Elite, c.PopulationSize-1)
+	if c.TournamentSize <= 0 {
+		c.TournamentSize = defaultGATournamentSize
+	}
+	if c.StallLimit <= 0 {
+		c.StallLimit = defaultGAStallLimit
+	}
+	return c
+}
+
+// gaCandidate is one candidate population of an area
+type gaCandidate struct {
+	indices []int     // Microdata index of every individual
+	totals  []float64 // Aggregates of the individuals
+	fitness float64
+}
+
+// gaPopulation evolves candidate populations of an area with a genetic algorithm:
+// every generation keeps the elite, and fills the rest with children of parents
+// chosen by tournament. A child is its first parent with a random segment of
+// individuals exchanged for the second parent's, mutated with the replace move of
+// the annealing (a random individual swapped for a random valid record). The search
+// stops at the fitness threshold, after the configured generations or once the best
+// fitness stalls.
+//
+// Parameters:
+//   - constraint: The area constraints
+//   - microdata: The source microdata
+//   - config: Configuration holding the GA settings and FitnessThreshold
+//   - distanceFunction: Fitness function built by buildDistance for this worker
+//   - rng: Random number generator
+//   - scratch: Worker buffers; only the trace interval and valid pool are used
+//
+// Returns:
+//   - Result: The best population found; BestIteration is the generation it was found in
+//   - error: ErrNoValidMicrodata if no record satisfies the area's zero constraints
+func gaPopulation(constraint ConstraintData, microdata []MicroData, config AnnealingConfig, distanceFunction DistanceFunc,
+	rng *rand.Rand, scratch *annealScratch) (Result, error) {
+	if scratch == nil {
+		scratch = &annealScratch{}
+	}
+	ga := config.GA.withDefaults()
+
+	// The first candidate is drawn like the annealing's initial population
+	totals, indices, err := initPopulation(constraint, microdata, scratch, rng)
+	if err != nil {
+		return Result{}, err
+	}
+	pool := scratch.valid
+	size := len(indices)
+
+	newCandidate := func() *gaCandidate {
+		return &gaCandidate{indices: make([]int, size), totals: make([]float64, len(totals))}
+	}
+	current := make([]*gaCandidate, ga.PopulationSize)
+	next := make([]*gaCandidate, ga.PopulationSize)
+	for i := range current {
+		current[i], next[i] = newCandidate(), newCandidate()
+	}
+	copy(current[0].indices, indices)
+	copy(current[0].totals, totals)
+	for _, c := range current[1:] {
+		for i := range c.indices {
+			c.indices[i] = pool.draw(rng)
+			for j, v := range microdata[c.indices[i]].Values {
+				c.totals[j] += v
+			}
+		}
+	}
+	for _, c := range current {
+		c.fitness = distanceFunction(constraint.Values, c.totals)
+	}
+	byFitness := func(candidates []*gaCandidate) {
+		sort.SliceStable(candidates, func(a, b int) bool { return candidates[a].fitness < candidates[b].fitness })
+	}
+	byFitness(current)
+
+	// tournament returns the fittest of TournamentSize random candidates
+	tournament := func() *gaCandidate {
+		best := current[rng.Intn(len(current))]
+		for k := 1; k < ga.TournamentSize; k++ {
+			if c := current[rng.Intn(len(current))]; c.fitness < best.fitness {
+				best = c
+			}
+		}
+		return best
+	}
+	// breed writes a child of a and b into child
+	breed := func(child, a, b *gaCandidate) {
+		copy(child.indices, a.indices)
+		copy(child.totals, a.totals)
+		if size == 0 {
+			return
+		}
+		start := rng.Intn(size)
+		end := start + 1 + rng.Intn(size-start)
+		for i := start; i < end; i++ {
+			if a.indices[i] == b.indices[i] {
+				continue
+			}
+			oldValues, newValues := microdata[a.indices[i]].Values, microdata[b.indices[i]].Values
+			for j := range child.totals {
+				child.totals[j] += newValues[j] - oldValues[j]
+			}
+			child.indices[i] = b.indices[i]
+		}
+
+		mutations := ga.MutationRate * float64(size)
+		n := int(mutations)
+		if rng.Float64() < mutations-float64(n) {
+			n++
+		}
+		for ; n > 0; n-- {
+			replacement := pool.draw(rng)
+			i := rng.Intn(size)
+			oldValues, newValues := microdata[child.indices[i]].Values, microdata[replacement].Values
+			for j := range child.totals {
+				child.totals[j] += newValues[j] - oldValues[j]
+			}
+			child.indices[i] = replacement
+		}
+		child.fitness = distanceFunction(constraint.Values, child.totals)
+	}
+
+	var trace []TracePoint
+	bestGeneration := 0
+	timedOut := false
+	for generation := 1; generation <= ga.Generations && current[0].fitness > config.FitnessThreshold; generation++ {
+		if generation-bestGeneration > ga.StallLimit {
+			break
+		}
+		if scratch.expired() {
+			timedOut = true
+			break
+		}
+		for i := 0; i < ga.Elite; i++ {
+			next[i].fitness = current[i].fitness
+			copy(next[i].indices, current[i].indices)
+			copy(next[i].totals, current[i].totals)
+		}
+		for i := ga.Elite; i < len(next); i++ {
+			breed(next[i], tournament(), tournament())
+		}
+		byFitness(next)
+		improved := next[0].fitness < current[0].fitness
+		current, next = next, current
+		if improved {
+			bestGeneration = generation
+		}
+		if scratch.traceEvery > 0 && generation%scratch.traceEvery == 0 {
+			trace = append(trace, TracePoint{Iteration: generation, Fitness: current[0].fitness, Accepted: improved})
+		}
+	}
+
+	best := current[0]
+	res := Result{
+		Area:             constraint.ID,
+		Trace:            trace,
+		Totals:           best.totals,
+		IDs:              make([]string, size),
+		ConstraintTotals: constraint.Values,
+		Fitness:          best.fitness,
+		BestIteration:    bestGeneration,
+		Population:       constraint.Total,
+		PoolSize:         len(pool.indices),
+		TimedOut:         timedOut,
+	}
+	for i, index := range best.indices {
+		res.IDs[i] = microdata[index].ID
+	}
+	return res, nil
+}
@@ -0,0 +1,70 @@
+package synthpop
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// startFitness returns the fitness of the initial population a search of the area
+// seeded with seed starts from
+func startFitness(t *testing.T, constraint ConstraintData, microData []MicroData, distance DistanceFunc, seed int64) float64 {
+	t.Helper()
+	totals, _, err := initPopulation(constraint, microData, &annealScratch{}, rand.New(rand.NewSource(seed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return distance(constraint.Values, totals)
+}
+
+func TestGAPopulation(t *testing.T) {
+	header := testHeader(6)
+	rng := rand.New(rand.NewSource(12))
+	microData := testMicrodata(rng, 150, len(header), false)
+	constraint := testConstraint(rng, len(header), 40)
+	config := testConfig()
+	config.Algorithm = AlgorithmGA
+	config.GA = GAConfig{PopulationSize: 20, Generations: 200}
+	distance, err := buildDistance(config, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scratch := &annealScratch{traceEvery: 1}
+	res, err := gaPopulation(constraint, microData, config, distance, rand.New(rand.NewSource(3)), scratch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPopulation(t, res, constraint, microData)
+	if start := startFitness(t, constraint, microData, distance, 3); res.Fitness >= start {
+		t.Errorf("fitness %v, no better than the initial population's %v", res.Fitness, start)
+	}
+	if res.BestIteration == 0 || res.BestIteration > config.GA.Generations {
+		t.Errorf("best population found in generation %d", res.BestIteration)
+	}
+	// The elite keeps the best candidate from one generation to the next
+	for i := 1; i < len(res.Trace); i++ {
+		if res.Trace[i].Fitness > res.Trace[i-1].Fitness {
+			t.Fatalf("best fitness rose from %v to %v in generation %d", res.Trace[i-1].Fitness, res.Trace[i].Fitness, res.Trace[i].Iteration)
+		}
+	}
+	if len(res.Trace) == 0 || res.Trace[len(res.Trace)-1].Fitness != res.Fitness {
+		t.Errorf("trace of %d generations does not end at the best fitness", len(res.Trace))
+	}
+
+	again, err := gaPopulation(constraint, microData, config, distance, rand.New(rand.NewSource(3)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(again.IDs, res.IDs) || again.Fitness != res.Fitness {
+		t.Error("the same seed evolved another population")
+	}
+
+	// A single candidate per generation leaves no room for an elite
+	config.GA = GAConfig{PopulationSize: 1, Generations: 5}
+	if res, err := gaPopulation(constraint, microData, config, distance, rand.New(rand.NewSource(3)), nil); err != nil {
+		t.Fatal(err)
+	} else {
+		checkPopulation(t, res, constraint, microData)
+	}
+}
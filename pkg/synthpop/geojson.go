@@ -0,0 +1,153 @@
+package synthpop
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Spatial QA output: per-area fitness and variable errors joined onto the
+// optional boundaries file, written as GeoJSON so it can be dropped straight into
+// QGIS. (GeoPackage would need an SQLite driver, which this module doesn't carry;
+// QGIS converts the GeoJSON layer to GeoPackage in one step if needed.)
+
+type geoFeature struct {
+	Type       string                 `json:"type"`
+	ID         json.RawMessage        `json:"id,omitempty"`
+	Geometry   json.RawMessage        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoFeatureCollection struct {
+	Type     string          `json:"type"`
+	CRS      json.RawMessage `json:"crs,omitempty"`
+	Features []geoFeature    `json:"features"`
+}
+
+// areaSummary is what the spatial join keeps of each area's results
+type areaSummary struct {
+	fitness       float64
+	bestIteration int
+	population    float64
+	errors        []float64 // synthetic - constraint for the selected variables
+}
+
+// spatialJoin collects area summaries during a run and joins them to the boundaries
+type spatialJoin struct {
+	boundaries geoFeatureCollection
+	idProperty string
+	variables  []string // Selected variables, by their output names
+	columns    []int
+	areas      map[string]areaSummary
+}
+
+// newSpatialJoin loads the boundaries file, or returns nil when no GeoJSON output is configured.
+// Boundaries.Variables are selected from header and named after outputHeader.
+func newSpatialJoin(popConfig PopulationConfig, header, outputHeader []string) (*spatialJoin, error) {
+	if popConfig.Output.GeoJSONFile == "" {
+		return nil, nil
+	}
+	if popConfig.Boundaries.File == "" || popConfig.Boundaries.IDProperty == "" {
+		return nil, fmt.Errorf("GeoJSON output needs boundaries.file and boundaries.idProperty")
+	}
+
+	join := &spatialJoin{idProperty: popConfig.Boundaries.IDProperty, areas: make(map[string]areaSummary)}
+
+	// Errors are reported for the selected variables, or all of them
+	selected := popConfig.Boundaries.Variables
+	if len(selected) == 0 {
+		selected = header
+	}
+	for _, name := range selected {
+		column := -1
+		for i, h := range header {
+			if h == name {
+				column = i
+				break
+			}
+		}
+		if column < 0 {
+			return nil, fmt.Errorf("boundaries variable '%s' is not in the constraints header", name)
+		}
+		join.columns = append(join.columns, column)
+		join.variables = append(join.variables, outputHeader[column])
+	}
+
+	file, err := os.Open(popConfig.Boundaries.File)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open boundaries file: %w", err)
+	}
+	defer file.Close()
+	if err := json.NewDecoder(file).Decode(&join.boundaries); err != nil {
+		return nil, fmt.Errorf("invalid boundaries GeoJSON: %w", err)
+	}
+	if join.boundaries.Type != "FeatureCollection" {
+		return nil, fmt.Errorf("boundaries file must be a GeoJSON FeatureCollection, got '%s'", join.boundaries.Type)
+	}
+	return join, nil
+}
+
+// add records the summary of one area
+func (j *spatialJoin) add(res Result) {
+	summary := areaSummary{
+		fitness:       res.Fitness,
+		bestIteration: res.BestIteration,
+		population:    res.Population,
+		errors:        make([]float64, len(j.columns)),
+	}
+	for i, column := range j.columns {
+		summary.errors[i] = res.Totals[column] - res.ConstraintTotals[column]
+	}
+	j.areas[res.Area] = summary
+}
+
+// featureAreaID reads the area code of a feature, accepting string or numeric codes
+func (j *spatialJoin) featureAreaID(feature *geoFeature) (string, bool) {
+	switch v := feature.Properties[j.idProperty].(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	default:
+		return "", false
+	}
+}
+
+// write saves the boundaries with the area results added as feature properties.
+// Features without a synthesized area are kept with synthesized=false.
+func (j *spatialJoin) write(path string, key []byte, retry retryPolicy) error {
+	matched := 0
+	for f := range j.boundaries.Features {
+		feature := &j.boundaries.Features[f]
+		if feature.Properties == nil {
+			feature.Properties = make(map[string]interface{})
+		}
+		areaID, ok := j.featureAreaID(feature)
+		summary, found := j.areas[areaID]
+		feature.Properties["synthesized"] = ok && found
+		if !ok || !found {
+			continue
+		}
+		matched++
+		feature.Properties["fitness"] = summary.fitness
+		feature.Properties["best_iteration"] = summary.bestIteration
+		feature.Properties["population"] = summary.population
+		for i, name := range j.variables {
+			feature.Properties[name+"_error"] = summary.errors[i]
+		}
+	}
+	if matched < len(j.areas) {
+		Printf("⚠️ %d synthesized areas have no boundary in the boundaries file\n", len(j.areas)-matched)
+	}
+
+	out, err := createOutput(path, key, retry)
+	if err != nil {
+		return fmt.Errorf("cannot create GeoJSON file: %w", err)
+	}
+	if err := json.NewEncoder(out).Encode(j.boundaries); err != nil {
+		out.Close()
+		return fmt.Errorf("error writing GeoJSON: %w", err)
+	}
+	return out.Close()
+}
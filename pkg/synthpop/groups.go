@@ -0,0 +1,198 @@
+package synthpop
+
+import (
+	"fmt"
+)
+
+// VariableGroup assigns its own distance metric and weight to a set of constraint
+// variables, e.g. chi-squared for count variables and JS divergence for a block of
+// proportions. The total fitness is the weighted sum of the group distances.
+type VariableGroup struct {
+	Name      string   `json:"name"`
+	Variables []string `json:"variables"` // Header names of the variables in the group
+	Distance  string   `json:"distance"`  // Metric for the group (default: the top-level distance)
+	Weight    float64  `json:"weight"`    // Weight of the group in the total fitness (default 1)
+}
+
+// distanceGroup is a resolved VariableGroup
+type distanceGroup struct {
+	columns []int
+	metric  DistanceFunc
+	weight  float64
+}
+
+// resolveGroups maps the configured groups onto header columns. Variables not named
+// in any group form an implicit group fitted with the top-level distance and weight 1.
+// Per-variable weights (weights, nil for none) scale the columns within each group.
+func resolveGroups(config AnnealingConfig, header []string, weights []float64) ([]distanceGroup, error) {
+	columnOf := make(map[string]int, len(header))
+	for i, name := range header {
+		columnOf[name] = i
+	}
+
+	assigned := make(map[int]string)
+	groups := make([]distanceGroup, 0, len(config.VariableGroups)+1)
+	for _, g := range config.VariableGroups {
+		metricName := g.Distance
+		if metricName == "" {
+			metricName = config.Distance
+		}
+		weight := g.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		if weight < 0 {
+			return nil, fmt.Errorf("variable group '%s': weight must be positive", g.Name)
+		}
+
+		group := distanceGroup{weight: weight}
+		for _, name := range g.Variables {
+			column, ok := columnOf[name]
+			if !ok {
+				return nil, fmt.Errorf("variable group '%s': variable '%s' is not in the header", g.Name, name)
+			}
+			if other, taken := assigned[column]; taken {
+				return nil, fmt.Errorf("variable '%s' is in both group '%s' and group '%s'", name, other, g.Name)
+			}
+			assigned[column] = g.Name
+			group.columns = append(group.columns, column)
+		}
+		if len(group.columns) == 0 {
+			return nil, fmt.Errorf("variable group '%s' has no variables", g.Name)
+		}
+		metric, err := groupMetric(config, metricName, group.columns, weights)
+		if err != nil {
+			return nil, fmt.Errorf("variable group '%s': %w", g.Name, err)
+		}
+		group.metric = metric
+		groups = append(groups, group)
+	}
+
+	rest := distanceGroup{weight: 1}
+	for column := range header {
+		if _, taken := assigned[column]; !taken {
+			rest.columns = append(rest.columns, column)
+		}
+	}
+	if len(rest.columns) > 0 {
+		metric, err := groupMetric(config, config.Distance, rest.columns, weights)
+		if err != nil {
+			return nil, err
+		}
+		rest.metric = metric
+		groups = append(groups, rest)
+	}
+	return groups, nil
+}
+
+// groupMetric returns the metric of a group, weighted by the weights of its columns
+// when per-variable weights are configured
+func groupMetric(config AnnealingConfig, metric string, columns []int, weights []float64) (DistanceFunc, error) {
+	if weights == nil {
+		return resolveMetric(config, metric, nil)
+	}
+	groupWeights := make([]float64, len(columns))
+	for i, column := range columns {
+		groupWeights[i] = weights[column]
+	}
+	return resolveMetric(config, metric, groupWeights)
+}
+
+// buildDistance returns the fitness function for a run: the configured metric, or
+// the weighted sum of the group metrics when variable groups are configured, with
+// every column scaled by its entry in VariableWeights, deviations within the
+// RoundingBase tolerance ignored, the variables with Denominators compared by
+// their share of their subpopulation and the deviations from HardConstraints
+// penalised.
+//
+// The grouped and rounding-aware functions gather values into buffers they own, so
+// they must not be shared between goroutines; build one per worker.
+func buildDistance(config AnnealingConfig, header []string) (DistanceFunc, error) {
+	distance, err := buildMetric(config, header)
+	if err != nil {
+		return nil, err
+	}
+	tolerance := 0.0
+	if config.RoundingBase > 1 {
+		tolerance = float64(config.RoundingBase - 1)
+		distance = withinRounding(distance, tolerance)
+	}
+	denominators, err := resolveDenominators(config, header)
+	if err != nil {
+		return nil, err
+	}
+	if denominators != nil {
+		distance = withDenominators(distance, denominators)
+	}
+	hard, err := resolveHardConstraints(config, header)
+	if err != nil || hard == nil {
+		return distance, err
+	}
+	penalty := config.HardPenalty
+	if penalty == 0 {
+		penalty = DefaultHardPenalty
+	}
+	return withHardConstraints(distance, hard, penalty, tolerance), nil
+}
+
+// buildMetric returns the configured metric, or the weighted sum of the group metrics
+func buildMetric(config AnnealingConfig, header []string) (DistanceFunc, error) {
+	var weights []float64
+	if len(config.VariableWeights) > 0 {
+		var err error
+		if weights, err = columnWeights(config, header); err != nil {
+			return nil, err
+		}
+	}
+	if len(config.VariableGroups) == 0 {
+		return resolveMetric(config, config.Distance, weights)
+	}
+	groups, err := resolveGroups(config, header, weights)
+	if err != nil {
+		return nil, err
+	}
+
+	constraintBuf := make([][]float64, len(groups))
+	totalsBuf := make([][]float64, len(groups))
+	for g, group := range groups {
+		constraintBuf[g] = make([]float64, len(group.columns))
+		totalsBuf[g] = make([]float64, len(group.columns))
+	}
+
+	return func(constraints, testData []float64) float64 {
+		fitness := 0.0
+		for g, group := range groups {
+			c, t := constraintBuf[g], totalsBuf[g]
+			for i, column := range group.columns {
+				c[i] = constraints[column]
+				t[i] = testData[column]
+			}
+			fitness += group.weight * group.metric(c, t)
+		}
+		return fitness
+	}, nil
+}
+
+// withinRounding makes distance ignore the deviations that rounding of the
+// constraints can explain: synthetic totals within tolerance of a constraint count
+// as exact, and larger deviations are reduced by tolerance. Random rounding to base
+// b moves a count by at most b-1.
+func withinRounding(distance DistanceFunc, tolerance float64) DistanceFunc {
+	var adjusted []float64
+	return func(constraints, testData []float64) float64 {
+		adjusted = floatBuffer(adjusted, len(testData))
+		for i, target := range constraints {
+			deviation := testData[i] - target
+			switch {
+			case deviation > tolerance:
+				deviation -= tolerance
+			case deviation < -tolerance:
+				deviation += tolerance
+			default:
+				deviation = 0
+			}
+			adjusted[i] = target + deviation
+		}
+		return distance(constraints, adjusted)
+	}
+}
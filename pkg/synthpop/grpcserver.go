@@ -0,0 +1,670 @@
+package synthpop
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// gRPC service: a microsimulation platform submits synthesis jobs, follows their
+// progress and fetches the areas as they are synthesized (gosynthpop.proto). The
+// transport is gRPC's HTTP/2 framing over the standard library's cleartext HTTP/2
+// server, with the messages encoded by protobuf.go, so any gRPC client generated
+// from the .proto file can call it. Jobs run through a Controller of their own and
+// write the outputs of their population config like a command-line run; their
+// results are also kept in memory for the lifetime of the server.
+//
+// Jobs wait in a queue and are started in submission order, a configurable number
+// at a time, each in an output directory of its own when the server has an output
+// root. A job can be cancelled while queued or running; a running job stops after
+// the areas in progress, as a cancelled command-line run does.
+
+// grpcServicePrefix is the path prefix of the service's methods
+const grpcServicePrefix = "/gosynthpop.v1.GoSynthPop/"
+
+// maxGRPCMessage bounds the size of a request, inline microdata included
+const maxGRPCMessage = 1 << 30
+
+// gRPC status codes returned by the service
+const (
+	grpcOK                = 0
+	grpcInvalidArgument   = 3
+	grpcNotFound          = 5
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+)
+
+// States of a job besides those of the status file (statusRunning, statusCompleted
+// and statusFailed)
+const (
+	jobQueued    = "queued"
+	jobCancelled = "cancelled"
+)
+
+// grpcError is a failed call with its gRPC status code
+type grpcError struct {
+	code    int
+	message string
+}
+
+func (e *grpcError) Error() string {
+	return e.message
+}
+
+func grpcErrorf(code int, format string, args ...any) error {
+	return &grpcError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// JobServer runs the synthesis jobs submitted over gRPC
+type JobServer struct {
+	ctx         context.Context // Cancels the running jobs when the server stops
+	concurrency int             // Jobs run at once
+	outputRoot  string          // Directory of the jobs' output directories, "" for none
+
+	mu      sync.Mutex
+	jobs    map[string]*grpcJob
+	order   []string   // Job IDs in submission order
+	queue   []*grpcJob // Jobs waiting for a slot, in submission order
+	running int
+	next    int
+}
+
+// NewJobServer returns a server without jobs
+//
+// Parameters:
+//   - concurrency: Jobs run at once, the others wait in the queue (at least 1)
+//   - outputRoot: Directory the relative output paths of every job are placed under,
+//     in a subdirectory named after the job's run ("" keeps the configs' paths)
+func NewJobServer(concurrency int, outputRoot string) *JobServer {
+	return &JobServer{ctx: context.Background(), concurrency: max(concurrency, 1), outputRoot: outputRoot,
+		jobs: make(map[string]*grpcJob)}
+}
+
+// ListenAndServe serves the gRPC service on addr until ctx is done, which also
+// cancels the running jobs
+//
+// Parameters:
+//   - ctx: Stops the server
+//   - addr: The TCP address, e.g. ":50051"
+//
+// Returns:
+//   - error: The address could not be listened on, or the server failed
+func (s *JobServer) ListenAndServe(ctx context.Context, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("cannot serve gRPC: %w", err)
+	}
+	s.ctx = ctx
+	server := &http.Server{Handler: s, Protocols: new(http.Protocols)}
+	server.Protocols.SetUnencryptedHTTP2(true) // gRPC clients without TLS speak HTTP/2 at once
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	Printf("🛰️ gRPC service listening on %s\n", listener.Addr())
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// ServeHTTP handles a gRPC call: one request message, the response messages and the
+// status in the trailers
+func (s *JobServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	code, message := grpcOK, ""
+	if err := s.call(w, r); err != nil {
+		var callErr *grpcError
+		if errors.As(err, &callErr) {
+			code, message = callErr.code, callErr.message
+		} else {
+			code, message = grpcInternal, err.Error()
+		}
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", grpcPercentEncode(message))
+	}
+}
+
+// call reads the request of a method and writes its responses
+func (s *JobServer) call(w http.ResponseWriter, r *http.Request) error {
+	method, ok := strings.CutPrefix(r.URL.Path, grpcServicePrefix)
+	if !ok {
+		return grpcErrorf(grpcUnimplemented, "unknown service of %s", r.URL.Path)
+	}
+	request, err := readGRPCMessage(r.Body)
+	if err != nil {
+		return err
+	}
+	switch method {
+	case "SubmitJob":
+		return s.submitJob(w, request)
+	case "StreamProgress":
+		return s.streamProgress(r.Context(), w, request)
+	case "GetAreaResult":
+		return s.getAreaResult(w, request)
+	case "CancelJob":
+		return s.cancelJob(w, request)
+	case "ListJobs":
+		return s.listJobs(w)
+	}
+	return grpcErrorf(grpcUnimplemented, "unknown method %s", method)
+}
+
+// readGRPCMessage reads one length-prefixed message
+func readGRPCMessage(body io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(body, prefix[:]); err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "cannot read request: %v", err)
+	}
+	if prefix[0] != 0 {
+		return nil, grpcErrorf(grpcUnimplemented, "compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > maxGRPCMessage {
+		return nil, grpcErrorf(grpcResourceExhausted, "request of %d bytes exceeds %d", size, maxGRPCMessage)
+	}
+	message := make([]byte, size)
+	if _, err := io.ReadFull(body, message); err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "cannot read request: %v", err)
+	}
+	return message, nil
+}
+
+// writeGRPCMessage writes one length-prefixed message and sends it at once
+func writeGRPCMessage(w http.ResponseWriter, message []byte) error {
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	if _, err := w.Write(append(frame, message...)); err != nil {
+		return err
+	}
+	return http.NewResponseController(w).Flush()
+}
+
+// grpcPercentEncode encodes a status message for the grpc-message trailer
+func grpcPercentEncode(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		if c := message[i]; c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// grpcJob is a submitted synthesis. Every change closes changed and replaces it, so
+// streams wait for the next one.
+type grpcJob struct {
+	id        string
+	runName   string
+	popConfig PopulationConfig
+	config    AnnealingConfig
+	in        Inputs // Inline inputs, none to load those of popConfig
+	ctx       context.Context
+	cancel    context.CancelFunc
+
+	mu       sync.Mutex
+	results  *ResultSet
+	progress RunProgress
+	state    string // jobQueued, statusRunning, statusCompleted, statusFailed or jobCancelled
+	err      error
+	changed  chan struct{}
+}
+
+// finished reports whether the job has ended, called with its lock held
+func (j *grpcJob) finished() bool {
+	return j.state != jobQueued && j.state != statusRunning
+}
+
+// status encodes the JobStatus of the job, called with its lock held
+func (j *grpcJob) status() []byte {
+	var w protoWriter
+	w.string(1, j.state)
+	if j.err != nil {
+		w.string(2, j.err.Error())
+	}
+	return w.buf
+}
+
+// update changes the job under its lock and wakes the streams
+func (j *grpcJob) update(change func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	change()
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// submitJobRequest is a decoded SubmitJobRequest
+type submitJobRequest struct {
+	popConfig, annealingConfig string
+	in                         Inputs
+	outputDir                  string
+}
+
+// submitJob queues a job and returns its ID
+func (s *JobServer) submitJob(w http.ResponseWriter, message []byte) error {
+	request, err := decodeSubmitJob(message)
+	if err != nil {
+		return grpcErrorf(grpcInvalidArgument, "%v", err)
+	}
+	var popConfig PopulationConfig
+	if err := json.Unmarshal([]byte(request.popConfig), &popConfig); err != nil {
+		return grpcErrorf(grpcInvalidArgument, "error decoding population config JSON: %v", err)
+	}
+	var config AnnealingConfig
+	if err := json.Unmarshal([]byte(request.annealingConfig), &config); err != nil {
+		return grpcErrorf(grpcInvalidArgument, "error decoding annealing config JSON: %v", err)
+	}
+	if err := popConfig.Check(); err != nil {
+		return grpcErrorf(grpcInvalidArgument, "population config: %v", err)
+	}
+	if err := config.Check(); err != nil {
+		return grpcErrorf(grpcInvalidArgument, "annealing config: %v", err)
+	}
+	if err := checkOptions(popConfig, config); err != nil {
+		return grpcErrorf(grpcInvalidArgument, "%v", err)
+	}
+	if popConfig.MaxProcs > 0 {
+		// GOMAXPROCS is shared by the jobs running at once
+		return grpcErrorf(grpcInvalidArgument, "maxProcs cannot be set per job, start the server with GOMAXPROCS instead")
+	}
+	inline := len(request.in.Constraints) > 0
+	if inline {
+		if err := checkInlineInputs(&request.in); err != nil {
+			return grpcErrorf(grpcInvalidArgument, "%v", err)
+		}
+	}
+	if !inline {
+		request.in = Inputs{}
+	}
+
+	// Outputs in the job's own directory, named after the run
+	if popConfig.RunName == "" {
+		popConfig.RunName = NewRunName()
+	}
+	dir := request.outputDir
+	if dir == "" && s.outputRoot != "" {
+		dir = filepath.Join(s.outputRoot, popConfig.RunName)
+	}
+	if popConfig, err = placeOutputs(popConfig, dir); err != nil {
+		return err
+	}
+	if popConfig, err = ApplyRunName(popConfig); err != nil {
+		return err
+	}
+
+	job := &grpcJob{runName: popConfig.RunName, popConfig: popConfig, config: config, in: request.in,
+		results: NewResultSet(), state: jobQueued, changed: make(chan struct{})}
+	job.ctx, job.cancel = context.WithCancel(s.ctx)
+	s.mu.Lock()
+	s.next++
+	job.id = strconv.Itoa(s.next)
+	s.jobs[job.id] = job
+	s.order = append(s.order, job.id)
+	s.queue = append(s.queue, job)
+	queued := len(s.queue)
+	s.dispatch()
+	s.mu.Unlock()
+	Printf("📨 Job %s submitted as run %s (%d in the queue)\n", job.id, popConfig.RunName, queued)
+
+	var response protoWriter
+	response.string(1, job.id)
+	response.string(2, popConfig.RunName)
+	return writeGRPCMessage(w, response.buf)
+}
+
+// dispatch starts queued jobs while there are free slots, called with s.mu held
+func (s *JobServer) dispatch() {
+	for s.running < s.concurrency && len(s.queue) > 0 {
+		job := s.queue[0]
+		s.queue = s.queue[1:]
+		s.running++
+		job.update(func() { job.state = statusRunning })
+		go s.runJob(job)
+	}
+}
+
+// checkInlineInputs checks the constraints and microdata sent with a job against
+// their header, and scales their design weights as applyDesignWeights does
+func checkInlineInputs(in *Inputs) error {
+	if len(in.MicroData) == 0 {
+		return fmt.Errorf("constraints were sent without microdata")
+	}
+	for _, c := range in.Constraints {
+		if len(c.Values) != len(in.Header) {
+			return fmt.Errorf("area %s has %d values for %d header variables", c.ID, len(c.Values), len(in.Header))
+		}
+	}
+	largest := 0.0
+	for _, md := range in.MicroData {
+		if len(md.Values) != len(in.Header) {
+			return fmt.Errorf("microdata record %s has %d values for %d header variables", md.ID, len(md.Values), len(in.Header))
+		}
+		largest = max(largest, md.Weight)
+	}
+	if largest > 0 {
+		for i := range in.MicroData {
+			w := in.MicroData[i].Weight
+			if !(w > 0) || math.IsInf(w, 0) {
+				return fmt.Errorf("microdata record %s has weight %v, design weights must be positive", in.MicroData[i].ID, w)
+			}
+			in.MicroData[i].Weight = w / largest
+		}
+	}
+	return nil
+}
+
+// runJob synthesizes a job, recording its progress and areas as they come, then
+// hands its slot to the next queued job
+func (s *JobServer) runJob(job *grpcJob) {
+	defer func() {
+		s.mu.Lock()
+		s.running--
+		s.dispatch()
+		s.mu.Unlock()
+	}()
+	Printf("▶️ Job %s started\n", job.id)
+
+	progress := make(chan RunProgress, 1)
+	results := make(chan Result)
+	controller := NewController()
+	controller.RunProgress, controller.Results = progress, results
+
+	var forwarded sync.WaitGroup
+	forwarded.Add(2)
+	go func() {
+		defer forwarded.Done()
+		for p := range progress {
+			job.update(func() { job.progress = p })
+		}
+	}()
+	go func() {
+		defer forwarded.Done()
+		for res := range results {
+			job.update(func() { job.results.Add(res) })
+		}
+	}()
+
+	var err error
+	if len(job.in.Constraints) > 0 {
+		err = controller.RunInputs(job.ctx, job.popConfig, job.config, job.in)
+	} else {
+		err = controller.Run(job.ctx, job.popConfig, job.config)
+	}
+	close(progress)
+	close(results)
+	forwarded.Wait()
+
+	job.update(func() {
+		job.state, job.err = statusCompleted, err
+		switch {
+		case err != nil && job.ctx.Err() != nil:
+			job.state = jobCancelled
+		case err != nil:
+			job.state = statusFailed
+		}
+		job.in = Inputs{} // The inputs are not needed any more
+	})
+	Printf("🏁 Job %s %s\n", job.id, job.state)
+}
+
+// cancelJob cancels a queued or running job and returns its status. A running job
+// stops after the areas in progress, which its stream reports with the cancelled
+// status; cancelling a finished job changes nothing.
+func (s *JobServer) cancelJob(w http.ResponseWriter, message []byte) error {
+	ref, err := decodeStrings(message)
+	if err != nil {
+		return grpcErrorf(grpcInvalidArgument, "%v", err)
+	}
+	job, err := s.job(ref[1])
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if i := slices.Index(s.queue, job); i >= 0 {
+		s.queue = slices.Delete(s.queue, i, i+1)
+		job.update(func() { job.state, job.in = jobCancelled, Inputs{} })
+	}
+	s.mu.Unlock()
+	job.cancel()
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return writeGRPCMessage(w, job.status())
+}
+
+// listJobs returns every job of the server in submission order
+func (s *JobServer) listJobs(w http.ResponseWriter) error {
+	s.mu.Lock()
+	jobs := make([]*grpcJob, len(s.order))
+	for i, id := range s.order {
+		jobs[i] = s.jobs[id]
+	}
+	s.mu.Unlock()
+
+	var list protoWriter
+	for _, job := range jobs {
+		var info protoWriter
+		job.mu.Lock()
+		info.string(1, job.id)
+		info.string(2, job.runName)
+		info.message(3, job.status())
+		info.message(4, encodeProgress(job.progress))
+		job.mu.Unlock()
+		list.message(1, info.buf)
+	}
+	return writeGRPCMessage(w, list.buf)
+}
+
+// job returns the job of a request's job ID
+func (s *JobServer) job(id string) (*grpcJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, grpcErrorf(grpcNotFound, "no job %q", id)
+	}
+	return job, nil
+}
+
+// streamProgress sends the progress and areas of a job until it ends, then its status
+func (s *JobServer) streamProgress(ctx context.Context, w http.ResponseWriter, message []byte) error {
+	ref, err := decodeStrings(message)
+	if err != nil {
+		return grpcErrorf(grpcInvalidArgument, "%v", err)
+	}
+	job, err := s.job(ref[1])
+	if err != nil {
+		return err
+	}
+	// Send the headers at once, so the client sees the stream open while a queued
+	// job has no event yet
+	if err := http.NewResponseController(w).Flush(); err != nil {
+		return err
+	}
+	sent := 0
+	var progress RunProgress
+	for {
+		job.mu.Lock()
+		areas := job.results.results[sent:len(job.results.results):len(job.results.results)]
+		latest, finished, status, changed := job.progress, job.finished(), job.status(), job.changed
+		job.mu.Unlock()
+
+		if latest != progress {
+			progress = latest
+			var event protoWriter
+			event.message(1, encodeProgress(progress))
+			if err := writeGRPCMessage(w, event.buf); err != nil {
+				return err
+			}
+		}
+		for _, res := range areas {
+			var event protoWriter
+			event.message(2, encodeAreaResult(res))
+			if err := writeGRPCMessage(w, event.buf); err != nil {
+				return err
+			}
+		}
+		sent += len(areas)
+		if finished {
+			var event protoWriter
+			event.message(3, status)
+			return writeGRPCMessage(w, event.buf)
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return grpcErrorf(grpcUnavailable, "stream cancelled")
+		}
+	}
+}
+
+// getAreaResult returns the result of one area of a job
+func (s *JobServer) getAreaResult(w http.ResponseWriter, message []byte) error {
+	ref, err := decodeStrings(message)
+	if err != nil {
+		return grpcErrorf(grpcInvalidArgument, "%v", err)
+	}
+	job, err := s.job(ref[1])
+	if err != nil {
+		return err
+	}
+	job.mu.Lock()
+	res, ok := job.results.ByArea(ref[2])
+	finished := job.finished()
+	job.mu.Unlock()
+	if !ok && !finished {
+		return grpcErrorf(grpcUnavailable, "area %q of job %s is not synthesized yet", ref[2], ref[1])
+	}
+	if !ok {
+		return grpcErrorf(grpcNotFound, "job %s has no area %q", ref[1], ref[2])
+	}
+	return writeGRPCMessage(w, encodeAreaResult(res))
+}
+
+// decodeSubmitJob decodes a SubmitJobRequest
+func decodeSubmitJob(message []byte) (submitJobRequest, error) {
+	var request submitJobRequest
+	err := readProto(message, func(f protoField) error {
+		switch f.number {
+		case 1:
+			request.popConfig = f.string()
+		case 2:
+			request.annealingConfig = f.string()
+		case 3:
+			request.in.Header = append(request.in.Header, f.string())
+		case 4:
+			c, err := decodeConstraintArea(f.data)
+			if err != nil {
+				return fmt.Errorf("constraints: %w", err)
+			}
+			request.in.Constraints = append(request.in.Constraints, c)
+		case 5:
+			md, err := decodeMicroRecord(f.data)
+			if err != nil {
+				return fmt.Errorf("microdata: %w", err)
+			}
+			request.in.MicroData = append(request.in.MicroData, md)
+		case 6:
+			request.outputDir = f.string()
+		}
+		return nil
+	})
+	return request, err
+}
+
+// decodeConstraintArea decodes a ConstraintArea
+func decodeConstraintArea(message []byte) (ConstraintData, error) {
+	var c ConstraintData
+	err := readProto(message, func(f protoField) (err error) {
+		switch f.number {
+		case 1:
+			c.ID = f.string()
+		case 2:
+			c.Values, err = f.appendDoubles(c.Values)
+		case 3:
+			c.Total = f.double()
+		case 4:
+			c.PersonTotal = f.double()
+		}
+		return err
+	})
+	return c, err
+}
+
+// decodeMicroRecord decodes a MicroRecord
+func decodeMicroRecord(message []byte) (MicroData, error) {
+	var md MicroData
+	err := readProto(message, func(f protoField) (err error) {
+		switch f.number {
+		case 1:
+			md.ID = f.string()
+		case 2:
+			md.Values, err = f.appendDoubles(md.Values)
+		case 3:
+			md.Weight = f.double()
+		}
+		return err
+	})
+	return md, err
+}
+
+// decodeStrings decodes a message of string fields (JobRef, AreaRef) by field number
+func decodeStrings(message []byte) (map[int]string, error) {
+	fields := make(map[int]string)
+	err := readProto(message, func(f protoField) error {
+		if f.wire == protoBytes {
+			fields[f.number] = f.string()
+		}
+		return nil
+	})
+	return fields, err
+}
+
+// encodeProgress encodes a Progress
+func encodeProgress(p RunProgress) []byte {
+	var w protoWriter
+	w.int32(1, p.Done)
+	w.int32(2, p.Total)
+	w.double(3, p.Elapsed.Seconds())
+	w.double(4, p.ETA.Seconds())
+	w.uvarint(5, p.MemoryMB)
+	return w.buf
+}
+
+// encodeAreaResult encodes an AreaResult
+func encodeAreaResult(res Result) []byte {
+	var w protoWriter
+	w.string(1, res.Area)
+	w.double(2, res.Fitness)
+	w.double(3, res.Population)
+	w.doubles(4, res.Totals)
+	w.doubles(5, res.ConstraintTotals)
+	w.strings(6, res.IDs)
+	w.int32(7, res.BestIteration)
+	w.int32(8, res.Iterations)
+	w.bool(9, res.TimedOut)
+	return w.buf
+}
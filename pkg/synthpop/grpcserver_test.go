@@ -0,0 +1,491 @@
+package synthpop
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// quietConsole discards the console output of the test
+func quietConsole(t *testing.T) {
+	consoleMu.Lock()
+	saved := console
+	console = io.Discard
+	consoleMu.Unlock()
+	t.Cleanup(func() {
+		consoleMu.Lock()
+		console = saved
+		consoleMu.Unlock()
+	})
+}
+
+// grpcTestClient calls a JobServer over cleartext HTTP/2, as gRPC clients do
+type grpcTestClient struct {
+	t      *testing.T
+	client *http.Client
+	base   string
+}
+
+// startJobServer serves a JobServer running concurrency jobs at once on a local
+// port, until the test ends
+func startJobServer(t *testing.T, concurrency int) (*JobServer, *grpcTestClient) {
+	t.Helper()
+	quietConsole(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewJobServer(concurrency, "")
+	s.ctx = ctx
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: s, Protocols: new(http.Protocols)}
+	server.Protocols.SetUnencryptedHTTP2(true)
+	go server.Serve(listener)
+
+	transport := &http.Transport{Protocols: new(http.Protocols)}
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	t.Cleanup(func() {
+		cancel()
+		server.Close()
+		transport.CloseIdleConnections()
+		// The jobs stop after the areas in progress, before the outputs are removed
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			s.mu.Lock()
+			running := s.running
+			s.mu.Unlock()
+			if running == 0 {
+				return
+			}
+		}
+		t.Error("jobs still running after the server stopped")
+	})
+	return s, &grpcTestClient{t: t, client: &http.Client{Transport: transport}, base: "http://" + listener.Addr().String()}
+}
+
+// grpcTestStream is the response of a call, read message by message
+type grpcTestStream struct {
+	resp *http.Response
+}
+
+// open calls a method with one request message
+func (c *grpcTestClient) open(method string, request []byte) *grpcTestStream {
+	c.t.Helper()
+	frame := binary.BigEndian.AppendUint32([]byte{0}, uint32(len(request)))
+	req, err := http.NewRequest(http.MethodPost, c.base+grpcServicePrefix+method, bytes.NewReader(append(frame, request...)))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if resp.ProtoMajor != 2 {
+		c.t.Fatalf("response over %s, want HTTP/2", resp.Proto)
+	}
+	return &grpcTestStream{resp: resp}
+}
+
+// next returns the next response message, or io.EOF after the last one
+func (s *grpcTestStream) next() ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(s.resp.Body, prefix[:]); err != nil {
+		return nil, err
+	}
+	message := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+	if _, err := io.ReadFull(s.resp.Body, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// status returns the gRPC status of the trailers, once the messages are read
+func (s *grpcTestStream) status() (int, string) {
+	s.resp.Body.Close()
+	code, err := strconv.Atoi(s.resp.Trailer.Get("Grpc-Status"))
+	if err != nil {
+		return -1, fmt.Sprintf("no grpc-status trailer in %v", s.resp.Trailer)
+	}
+	message, _ := url.PathUnescape(s.resp.Trailer.Get("Grpc-Message"))
+	return code, message
+}
+
+// call calls a method and returns its response messages and status
+func (c *grpcTestClient) call(method string, request []byte) ([][]byte, int, string) {
+	c.t.Helper()
+	stream := c.open(method, request)
+	var messages [][]byte
+	for {
+		message, err := stream.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.t.Fatalf("%s: %v", method, err)
+		}
+		messages = append(messages, message)
+	}
+	code, message := stream.status()
+	return messages, code, message
+}
+
+// unary calls a method that must succeed with one response message
+func (c *grpcTestClient) unary(method string, request []byte) []byte {
+	c.t.Helper()
+	messages, code, message := c.call(method, request)
+	if code != grpcOK || len(messages) != 1 {
+		c.t.Fatalf("%s: status %d %q with %d messages", method, code, message, len(messages))
+	}
+	return messages[0]
+}
+
+// testJob is a job submitted with inline inputs
+type testJob struct {
+	popConfig   string
+	config      AnnealingConfig
+	header      []string
+	constraints []ConstraintData
+	microData   []MicroData
+	outputDir   string
+}
+
+// request encodes the SubmitJobRequest of the job
+func (j testJob) request(t *testing.T) []byte {
+	config, err := json.Marshal(j.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	field := func(name string) int { return protoNumber(t, "SubmitJobRequest", name) }
+	var w protoWriter
+	w.string(field("population_config"), j.popConfig)
+	w.string(field("annealing_config"), string(config))
+	w.strings(field("header"), j.header)
+	for _, c := range j.constraints {
+		var area protoWriter
+		area.string(protoNumber(t, "ConstraintArea", "id"), c.ID)
+		area.doubles(protoNumber(t, "ConstraintArea", "values"), c.Values)
+		area.double(protoNumber(t, "ConstraintArea", "total"), c.Total)
+		w.message(field("constraints"), area.buf)
+	}
+	for _, md := range j.microData {
+		var record protoWriter
+		record.string(protoNumber(t, "MicroRecord", "id"), md.ID)
+		record.doubles(protoNumber(t, "MicroRecord", "values"), md.Values)
+		w.message(field("microdata"), record.buf)
+	}
+	w.string(field("output_dir"), j.outputDir)
+	return w.buf
+}
+
+// newTestJob returns a job of areas over generated microdata, writing its
+// population to a temporary directory
+func newTestJob(t *testing.T, areas int) testJob {
+	rng := rand.New(rand.NewSource(21))
+	job := testJob{
+		popConfig: `{"output": {"file": "population.csv"}, "validate": {"file": "validation.csv"}}`,
+		config:    testConfig(),
+		header:    testHeader(4),
+		outputDir: t.TempDir(),
+	}
+	job.config.MaxIterations = 2000
+	job.microData = testMicrodata(rng, 60, len(job.header), false)
+	for i := range areas {
+		c := testConstraint(rng, len(job.header), 20)
+		c.ID = fmt.Sprintf("area%d", i)
+		job.constraints = append(job.constraints, c)
+	}
+	return job
+}
+
+// submit submits a job and returns its ID and run name
+func (c *grpcTestClient) submit(job testJob) (string, string) {
+	c.t.Helper()
+	response := c.unary("SubmitJob", job.request(c.t))
+	fields, err := decodeStrings(response)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return fields[protoNumber(c.t, "SubmitJobResponse", "job_id")], fields[protoNumber(c.t, "SubmitJobResponse", "run_name")]
+}
+
+// jobRef encodes the JobRef or AreaRef of a job
+func jobRef(t *testing.T, id string, area ...string) []byte {
+	var w protoWriter
+	w.string(protoNumber(t, "JobRef", "job_id"), id)
+	if len(area) > 0 {
+		w.string(protoNumber(t, "AreaRef", "area"), area[0])
+	}
+	return w.buf
+}
+
+// testJobEvent is a decoded JobEvent, one of its fields set
+type testJobEvent struct {
+	progress *RunProgress
+	area     *Result
+	state    string
+}
+
+// decodeTestJobEvent decodes a JobEvent by the field numbers of gosynthpop.proto
+func decodeTestJobEvent(t *testing.T, message []byte) testJobEvent {
+	var event testJobEvent
+	err := readProto(message, func(f protoField) error {
+		switch f.number {
+		case protoNumber(t, "JobEvent", "progress"):
+			event.progress = &RunProgress{}
+			return readProto(f.data, func(p protoField) error {
+				switch p.number {
+				case protoNumber(t, "Progress", "done"):
+					event.progress.Done = p.int()
+				case protoNumber(t, "Progress", "total"):
+					event.progress.Total = p.int()
+				}
+				return nil
+			})
+		case protoNumber(t, "JobEvent", "area"):
+			res, err := decodeTestAreaResult(t, f.data)
+			event.area = &res
+			return err
+		case protoNumber(t, "JobEvent", "status"):
+			event.state = decodeTestState(t, f.data)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+// decodeTestAreaResult decodes an AreaResult by the field numbers of gosynthpop.proto
+func decodeTestAreaResult(t *testing.T, message []byte) (Result, error) {
+	var res Result
+	err := readProto(message, func(f protoField) (err error) {
+		switch f.number {
+		case protoNumber(t, "AreaResult", "area"):
+			res.Area = f.string()
+		case protoNumber(t, "AreaResult", "fitness"):
+			res.Fitness = f.double()
+		case protoNumber(t, "AreaResult", "totals"):
+			res.Totals, err = f.appendDoubles(res.Totals)
+		case protoNumber(t, "AreaResult", "constraint_totals"):
+			res.ConstraintTotals, err = f.appendDoubles(res.ConstraintTotals)
+		case protoNumber(t, "AreaResult", "ids"):
+			res.IDs = append(res.IDs, f.string())
+		}
+		return err
+	})
+	return res, err
+}
+
+// decodeTestState returns the state of a JobStatus
+func decodeTestState(t *testing.T, message []byte) string {
+	fields, err := decodeStrings(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fields[protoNumber(t, "JobStatus", "state")]
+}
+
+// TestJobServerStream submits a job with inline inputs over HTTP/2, follows its
+// stream to the end and fetches its areas and the job list
+func TestJobServerStream(t *testing.T) {
+	_, client := startJobServer(t, 2)
+	job := newTestJob(t, 3)
+	id, runName := client.submit(job)
+	if id != "1" || runName == "" {
+		t.Fatalf("job submitted as %q, run %q", id, runName)
+	}
+
+	stream := client.open("StreamProgress", jobRef(t, id))
+	areas := make(map[string]Result)
+	var events []testJobEvent
+	for {
+		message, err := stream.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, decodeTestJobEvent(t, message))
+	}
+	if code, message := stream.status(); code != grpcOK {
+		t.Fatalf("stream ended with status %d %q", code, message)
+	}
+	for i, event := range events {
+		switch {
+		case event.progress != nil:
+			if p := event.progress; p.Total != len(job.constraints) || p.Done > p.Total {
+				t.Errorf("progress %d of %d areas", p.Done, p.Total)
+			}
+		case event.area != nil:
+			areas[event.area.Area] = *event.area
+		case i != len(events)-1:
+			t.Errorf("status event %d is not the last of %d", i, len(events))
+		case event.state != statusCompleted:
+			t.Errorf("job ended %q, want %q", event.state, statusCompleted)
+		}
+	}
+	if len(areas) != len(job.constraints) {
+		t.Fatalf("streamed %d areas, want %d", len(areas), len(job.constraints))
+	}
+	for _, c := range job.constraints {
+		res := areas[c.ID]
+		checkPopulation(t, res, c, job.microData)
+		if fetched, err := decodeTestAreaResult(t, client.unary("GetAreaResult", jobRef(t, id, c.ID))); err != nil ||
+			strings.Join(fetched.IDs, ",") != strings.Join(res.IDs, ",") || fetched.Fitness != res.Fitness {
+			t.Errorf("area %s fetched differs from the one streamed (%v)", c.ID, err)
+		}
+	}
+
+	// The list has the finished job
+	var infos [][]byte
+	if err := readProto(client.unary("ListJobs", nil), func(f protoField) error {
+		infos = append(infos, f.data)
+		return nil
+	}); err != nil || len(infos) != 1 {
+		t.Fatalf("listed %d jobs (%v), want 1", len(infos), err)
+	}
+	err := readProto(infos[0], func(f protoField) error {
+		switch f.number {
+		case protoNumber(t, "JobInfo", "job_id"):
+			if f.string() != id {
+				t.Errorf("listed job %q, want %q", f.string(), id)
+			}
+		case protoNumber(t, "JobInfo", "run_name"):
+			if f.string() != runName {
+				t.Errorf("listed run %q, want %q", f.string(), runName)
+			}
+		case protoNumber(t, "JobInfo", "status"):
+			if state := decodeTestState(t, f.data); state != statusCompleted {
+				t.Errorf("listed job %s, want %s", state, statusCompleted)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(job.outputDir, "population.csv")); err != nil {
+		t.Errorf("job output: %v", err)
+	}
+}
+
+// TestJobServerErrors checks the status codes of rejected calls
+func TestJobServerErrors(t *testing.T) {
+	_, client := startJobServer(t, 1)
+	maxProcs := newTestJob(t, 1)
+	maxProcs.popConfig = `{"maxProcs": 2, "output": {"file": "population.csv"}}`
+	badJSON := newTestJob(t, 1)
+	badJSON.popConfig = `{"output": `
+	noMicrodata := newTestJob(t, 1)
+	noMicrodata.microData = nil
+	badConfig := newTestJob(t, 1)
+	badConfig.config.Distance = "NOPE"
+
+	tests := []struct {
+		name    string
+		method  string
+		request []byte
+		code    int
+		want    string
+	}{
+		{"maxProcs", "SubmitJob", maxProcs.request(t), grpcInvalidArgument, "maxProcs cannot be set per job"},
+		{"population config JSON", "SubmitJob", badJSON.request(t), grpcInvalidArgument, "error decoding population config JSON"},
+		{"annealing config", "SubmitJob", badConfig.request(t), grpcInvalidArgument, "annealing config: invalid distance metric 'NOPE'"},
+		{"no microdata", "SubmitJob", noMicrodata.request(t), grpcInvalidArgument, "constraints were sent without microdata"},
+		{"malformed request", "SubmitJob", []byte{0x0b}, grpcInvalidArgument, "unsupported wire type 3"},
+		{"unknown job", "StreamProgress", jobRef(t, "42"), grpcNotFound, `no job "42"`},
+		{"unknown method", "Synthesize", nil, grpcUnimplemented, "unknown method Synthesize"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, code, message := client.call(tt.method, tt.request)
+			if code != tt.code || !strings.Contains(message, tt.want) || len(messages) != 0 {
+				t.Errorf("status %d %q with %d messages, want %d and a message containing %q", code, message, len(messages), tt.code, tt.want)
+			}
+		})
+	}
+
+	// Requests other than gRPC are refused before any call
+	resp, err := client.client.Post(client.base+grpcServicePrefix+"ListJobs", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("JSON request answered %s, want %d", resp.Status, http.StatusUnsupportedMediaType)
+	}
+}
+
+// TestJobServerCancel cancels a queued job and a running one while their progress
+// is streamed
+func TestJobServerCancel(t *testing.T) {
+	_, client := startJobServer(t, 1)
+	// Enough long areas for the first job to be running while the test goes on
+	long := newTestJob(t, 3000)
+	long.config.MaxIterations, long.config.MinImprovement, long.config.FitnessThreshold = 20000, 0, 0
+	running, _ := client.submit(long)
+	queued, _ := client.submit(newTestJob(t, 1))
+
+	// The running job streams areas; the queued one has only its status to send
+	runningStream := client.open("StreamProgress", jobRef(t, running))
+	for {
+		message, err := runningStream.next()
+		if err != nil {
+			t.Fatalf("stream of the running job: %v", err)
+		}
+		if event := decodeTestJobEvent(t, message); event.area != nil {
+			break
+		}
+	}
+	queuedStream := client.open("StreamProgress", jobRef(t, queued))
+
+	for _, id := range []string{queued, running} {
+		if state := decodeTestState(t, client.unary("CancelJob", jobRef(t, id))); id == queued && state != jobCancelled {
+			t.Errorf("queued job %s after CancelJob, want %s", state, jobCancelled)
+		}
+	}
+	for name, stream := range map[string]*grpcTestStream{"queued": queuedStream, "running": runningStream} {
+		var last testJobEvent
+		areas := 0
+		for {
+			message, err := stream.next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("stream of the %s job: %v", name, err)
+			}
+			if last = decodeTestJobEvent(t, message); last.area != nil {
+				areas++
+			}
+		}
+		if code, message := stream.status(); code != grpcOK {
+			t.Errorf("stream of the %s job ended with status %d %q", name, code, message)
+		}
+		if last.state != jobCancelled {
+			t.Errorf("%s job ended %q, want %q", name, last.state, jobCancelled)
+		}
+		if name == "queued" && areas > 0 {
+			t.Errorf("cancelled queued job streamed %d areas", areas)
+		}
+	}
+	if _, code, _ := client.call("GetAreaResult", jobRef(t, queued, "area0")); code != grpcNotFound {
+		t.Errorf("area of the cancelled queued job: status %d, want %d", code, grpcNotFound)
+	}
+}
@@ -0,0 +1,45 @@
+package synthpop
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+// DefaultHardPenalty is the fitness added per unit of deviation from a hard
+// constraint when AnnealingConfig.HardPenalty is not set. NORM_EUCLIDEAN weighs the
+// squared count of a variable constrained to zero by it, as a built-in hard
+// constraint.
+const DefaultHardPenalty = 1000.0
+
+// resolveHardConstraints maps AnnealingConfig.HardConstraints onto the header
+// columns, nil when there are none
+func resolveHardConstraints(config AnnealingConfig, header []string) ([]int, error) {
+	var columns []int
+	for _, name := range config.HardConstraints {
+		column := slices.Index(header, name)
+		if column < 0 {
+			return nil, fmt.Errorf("hardConstraints: variable '%s' is not in the header", name)
+		}
+		if slices.Contains(columns, column) {
+			return nil, fmt.Errorf("hardConstraints: variable '%s' is listed twice", name)
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// withHardConstraints adds to distance a penalty per unit of deviation of the hard
+// columns from their constraints beyond tolerance (see withinRounding), so a
+// population missing a hard constraint is always worse than one meeting it while
+// the soft variables are off by less than the penalty. The hard columns stay in
+// distance like the soft ones.
+func withHardConstraints(distance DistanceFunc, columns []int, penalty, tolerance float64) DistanceFunc {
+	return func(constraints, testData []float64) float64 {
+		violation := 0.0
+		for _, column := range columns {
+			violation += max(math.Abs(testData[column]-constraints[column])-tolerance, 0)
+		}
+		return distance(constraints, testData) + penalty*violation
+	}
+}
@@ -0,0 +1,117 @@
+package synthpop
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Header modes for PopulationConfig.HeaderMode
+const (
+	HeaderExact        = "exact"        // Constraint and microdata variables must be the same, in any order (default)
+	HeaderIntersection = "intersection" // Use the variables present in both, ignoring the rest
+)
+
+// IntersectHeaders returns the names present in both headers, in the order of a,
+// with their column positions in a and in b
+func IntersectHeaders(a, b []string) (common []string, aColumns, bColumns []int) {
+	inB := make(map[string]int, len(b))
+	for i, name := range b {
+		inB[name] = i
+	}
+	for i, name := range a {
+		if j, ok := inB[name]; ok {
+			common = append(common, name)
+			aColumns = append(aColumns, i)
+			bColumns = append(bColumns, j)
+		}
+	}
+	return common, aColumns, bColumns
+}
+
+// MatchHeaders matches the microdata columns to the constraint columns by name.
+// Both headers must hold the same variables, but not necessarily in the same order.
+//
+// Returns:
+//   - columns: For every constraint column, the microdata column of the same name
+//   - reordered: Whether the microdata order differs from the constraint order
+//   - error: A report of the duplicated, missing and extra variables
+func MatchHeaders(constraintHeader, microDataHeader []string) (columns []int, reordered bool, err error) {
+	if dup := duplicateNames(constraintHeader); len(dup) > 0 {
+		return nil, false, fmt.Errorf("duplicate constraint columns: %s", strings.Join(dup, ", "))
+	}
+	if dup := duplicateNames(microDataHeader); len(dup) > 0 {
+		return nil, false, fmt.Errorf("duplicate microdata columns: %s", strings.Join(dup, ", "))
+	}
+
+	common, _, columns := IntersectHeaders(constraintHeader, microDataHeader)
+	if len(common) != len(constraintHeader) || len(common) != len(microDataHeader) {
+		var problems []string
+		if missing := notIn(constraintHeader, common); len(missing) > 0 {
+			problems = append(problems, "missing from the microdata: "+strings.Join(missing, ", "))
+		}
+		if extra := notIn(microDataHeader, common); len(extra) > 0 {
+			problems = append(problems, "missing from the constraints: "+strings.Join(extra, ", "))
+		}
+		return nil, false, fmt.Errorf("the constraint and microdata variables differ (%s); set headerMode to %s to ignore unmatched columns",
+			strings.Join(problems, "; "), HeaderIntersection)
+	}
+	for i, column := range columns {
+		if column != i {
+			reordered = true
+		}
+	}
+	return columns, reordered, nil
+}
+
+// duplicateNames returns the names that appear more than once in header
+func duplicateNames(header []string) []string {
+	count := make(map[string]int, len(header))
+	var dup []string
+	for _, name := range header {
+		if count[name]++; count[name] == 2 {
+			dup = append(dup, name)
+		}
+	}
+	return dup
+}
+
+// alignMicroData matches microdata to the constraint header by name, reordering
+// (copies of) the microdata values when the column orders differ
+func alignMicroData(constraintHeader, microDataHeader []string, microData []MicroData) ([]MicroData, error) {
+	columns, reordered, err := MatchHeaders(constraintHeader, microDataHeader)
+	if err != nil {
+		return nil, err
+	}
+	if reordered {
+		Printf("Reordering microdata columns to match the constraints\n")
+		microData = SelectMicroDataColumns(microData, columns)
+	}
+	return microData, nil
+}
+
+// SelectConstraintColumns returns copies of the constraints holding only the given
+// value columns, in that order
+func SelectConstraintColumns(constraints []ConstraintData, columns []int) []ConstraintData {
+	out := make([]ConstraintData, len(constraints))
+	for i, c := range constraints {
+		c.Values = selectValues(c.Values, columns)
+		out[i] = c
+	}
+	return out
+}
+
+// SelectMicroDataColumns is SelectConstraintColumns for microdata records
+func SelectMicroDataColumns(microData []MicroData, columns []int) []MicroData {
+	out, _ := mapRows(microData, func(md MicroData) ([]float64, error) {
+		return selectValues(md.Values, columns), nil
+	})
+	return out
+}
+
+func selectValues(values []float64, columns []int) []float64 {
+	selected := make([]float64, len(columns))
+	for i, column := range columns {
+		selected[i] = values[column]
+	}
+	return selected
+}
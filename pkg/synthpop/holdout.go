@@ -0,0 +1,191 @@
+package synthpop
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HoldoutSplit randomly partitions microdata into a training set used for synthesis
+// and a held-out set used only for evaluation.
+//
+// Parameters:
+//   - microData: The full microdata pool
+//   - fraction: Share of records to hold out (0 < fraction < 1)
+//   - rng: Random number generator
+//
+// Returns:
+//   - training: Records available to the synthesizer
+//   - holdout: Records withheld from the synthesizer
+func HoldoutSplit(microData []MicroData, fraction float64, rng *rand.Rand) ([]MicroData, []MicroData, error) {
+	if fraction <= 0 || fraction >= 1 {
+		return nil, nil, fmt.Errorf("holdout fraction must be between 0 and 1, got %v", fraction)
+	}
+	nHoldout := int(math.Round(fraction * float64(len(microData))))
+	if nHoldout == 0 || nHoldout == len(microData) {
+		return nil, nil, fmt.Errorf("holdout fraction %v leaves an empty training or holdout set for %d records",
+			fraction, len(microData))
+	}
+
+	perm := rng.Perm(len(microData))
+	holdout := make([]MicroData, 0, nHoldout)
+	training := make([]MicroData, 0, len(microData)-nHoldout)
+	for i, idx := range perm {
+		if i < nHoldout {
+			holdout = append(holdout, microData[idx])
+		} else {
+			training = append(training, microData[idx])
+		}
+	}
+	return training, holdout, nil
+}
+
+// patternKey encodes a record's full attribute vector, so identical joint
+// combinations of variables share a key.
+func patternKey(values []float64) string {
+	var buf strings.Builder
+	for i, v := range values {
+		if i > 0 {
+			buf.WriteByte('|')
+		}
+		buf.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+	}
+	return buf.String()
+}
+
+// synthesizedPatternCounts reads an ID mapping output back and counts the joint
+// attribute patterns of every synthetic individual.
+func synthesizedPatternCounts(idsFileName string, training []MicroData) (map[string]float64, float64, error) {
+	byID := make(map[string][]float64, len(training))
+	for _, md := range training {
+		byID[md.ID] = md.Values
+	}
+
+	file, err := os.Open(idsFileName)
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot open IDs file: %w", err)
+	}
+	defer file.Close()
+
+	in, err := decompress(idsFileName, file)
+	if err != nil {
+		return nil, 0, err
+	}
+	reader := csv.NewReader(in)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, 0, fmt.Errorf("error reading IDs header: %w", err)
+	}
+	// In the counts layout every row stands for count individuals
+	withCounts := slices.Equal(header, idsHeader(OutputLayoutCounts))
+
+	counts := make(map[string]float64)
+	total := 0.0
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("error reading IDs row: %w", err)
+		}
+		values, ok := byID[row[1]]
+		if !ok {
+			return nil, 0, fmt.Errorf("microdata ID %s in %s is not in the training set", row[1], idsFileName)
+		}
+		n := 1.0
+		if withCounts {
+			if n, err = strconv.ParseFloat(row[2], 64); err != nil {
+				return nil, 0, fmt.Errorf("invalid count %q in %s", row[2], idsFileName)
+			}
+		}
+		counts[patternKey(values)] += n
+		total += n
+	}
+	return counts, total, nil
+}
+
+// EvaluateHoldout compares the joint attribute distribution of the held-out records
+// with the one reproduced by the synthetic population, writes the per-pattern shares to
+// outputFile and prints a summary.
+//
+// Reported measures:
+//   - Total variation distance between the two pattern distributions (0 = identical, 1 = disjoint)
+//   - Coverage: share of held-out records whose joint pattern appears in the synthetic population
+func EvaluateHoldout(idsFileName string, outputFile string, training []MicroData, holdout []MicroData) error {
+	synthCounts, synthTotal, err := synthesizedPatternCounts(idsFileName, training)
+	if err != nil {
+		return err
+	}
+	if synthTotal == 0 {
+		return fmt.Errorf("synthetic population in %s is empty", idsFileName)
+	}
+
+	holdoutCounts := make(map[string]float64)
+	for _, md := range holdout {
+		holdoutCounts[patternKey(md.Values)]++
+	}
+	holdoutTotal := float64(len(holdout))
+
+	// Union of patterns, sorted for a stable output
+	patterns := make([]string, 0, len(holdoutCounts)+len(synthCounts))
+	for p := range holdoutCounts {
+		patterns = append(patterns, p)
+	}
+	for p := range synthCounts {
+		if _, ok := holdoutCounts[p]; !ok {
+			patterns = append(patterns, p)
+		}
+	}
+	sort.Strings(patterns)
+
+	file, err := os.Create(outputFile)
+	if err != nil {
+		return fmt.Errorf("cannot create holdout file: %w", err)
+	}
+	defer file.Close()
+	writer := csv.NewWriter(file)
+	defer writer.Flush()
+
+	if err := writer.Write([]string{"pattern", "holdout_share", "synthetic_share"}); err != nil {
+		return fmt.Errorf("error writing holdout header: %w", err)
+	}
+
+	tvd, covered := 0.0, 0.0
+	for _, p := range patterns {
+		holdoutShare := holdoutCounts[p] / holdoutTotal
+		synthShare := synthCounts[p] / synthTotal
+		tvd += math.Abs(holdoutShare - synthShare)
+		if synthCounts[p] > 0 {
+			covered += holdoutCounts[p]
+		}
+		row := []string{p,
+			strconv.FormatFloat(holdoutShare, 'f', -1, 64),
+			strconv.FormatFloat(synthShare, 'f', -1, 64)}
+		if err := writer.Write(row); err != nil {
+			return fmt.Errorf("error writing holdout row: %w", err)
+		}
+	}
+	tvd /= 2
+
+	Printf("🧪 Holdout evaluation: %d held-out records, %d joint patterns\n", len(holdout), len(holdoutCounts))
+	Printf("   Total variation distance: %.4f | Pattern coverage: %.1f%%\n", tvd, covered/holdoutTotal*100)
+	return writer.Error()
+}
+
+// HoldoutRNG returns the RNG used for the holdout split, seeded like the annealing
+// workers so deterministic runs hold out the same records.
+func HoldoutRNG(config AnnealingConfig) *rand.Rand {
+	if strings.ToLower(strings.TrimSpace(config.UseRandomSeed)) == "yes" && config.RandomSeed != nil {
+		return rand.New(rand.NewSource(*config.RandomSeed))
+	}
+	return rand.New(rand.NewSource(time.Now().UnixNano()))
+}
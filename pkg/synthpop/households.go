@@ -0,0 +1,373 @@
+package synthpop
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+)
+
+// PersonsColumn is the joint variable holding the number of persons of a household
+// (microdata) or of an area (constraints) in household-person joint synthesis
+const PersonsColumn = "persons"
+
+// Household-person joint synthesis
+//
+// Households are the units selected by the annealing. Every household record is
+// extended with the summed values of its persons and its person count, and every
+// area's household constraints are extended with its person constraints and person
+// total, so a single search fits both levels at once. The persons of the selected
+// households then form the area's person population, which is consistent with the
+// household population by construction.
+
+// LoadHouseholdInputs loads the inputs of a household-person joint run:
+// Households.ConstraintsFile and Households.File at household level, Constraints.File
+// and Microdata.File at person level, with persons linked to their household through
+// the Households.LinkColumn column of Microdata.File. Without Households.File the
+// household microdata are aggregated from the person microdata, taking the
+// Households.Variables columns as household attributes (see AggregateHouseholds).
+// Controller.Load loads them the same way, through its cache.
+//
+// Returns:
+//   - constraints: Per area, the household constraints followed by the person
+//     constraints and the person total; Total is the number of households
+//   - microData: Per household, its values followed by the sums over its persons
+//     and its person count
+//   - header: The joint variable names
+//   - error: Any loading or consistency error
+func LoadHouseholdInputs(popConfig PopulationConfig) ([]ConstraintData, []MicroData, []string, error) {
+	loaded, err := NewController().loadBoth(context.Background(), popConfig)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	in, err := loaded.joinHouseholds(popConfig)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return in.Constraints, in.MicroData, in.Header, nil
+}
+
+// checkHouseholds rejects household settings that cannot be loaded
+func checkHouseholds(popConfig PopulationConfig) error {
+	hh := popConfig.Households
+	if hh.ConstraintsFile == "" || hh.LinkColumn == "" {
+		return fmt.Errorf("household synthesis needs households.constraintsFile and households.linkColumn")
+	}
+	if hh.File != "" && len(hh.Variables) > 0 {
+		return fmt.Errorf("set either households.file or households.variables, not both")
+	}
+	return nil
+}
+
+// joinHouseholds joins the household and person inputs of a joint run into single
+// constraints and microdata (see LoadHouseholdInputs). The loaded inputs are not
+// modified.
+func (l loadedInputs) joinHouseholds(popConfig PopulationConfig) (Inputs, error) {
+	persons, personHeader := l.microData, l.microDataHeader
+	households, hhHeader := l.households, l.householdHeader
+	if popConfig.Households.File == "" {
+		var err error
+		households, hhHeader, persons, personHeader, err = AggregateHouseholds(persons, personHeader, l.links,
+			popConfig.Households.Variables)
+		if err != nil {
+			return Inputs{}, fmt.Errorf("household aggregation error: %w", err)
+		}
+	}
+	Printf("Loaded %d households with %d persons for %d areas\n", len(households), len(persons), len(l.constraints))
+
+	households, err := alignMicroData(l.householdConstraintHeader, hhHeader, households)
+	if err != nil {
+		return Inputs{}, fmt.Errorf("households: %w", err)
+	}
+	if persons, err = alignMicroData(l.constraintHeader, personHeader, persons); err != nil {
+		return Inputs{}, fmt.Errorf("persons: %w", err)
+	}
+
+	header, err := jointHeader(l.householdConstraintHeader, l.constraintHeader)
+	if err != nil {
+		return Inputs{}, err
+	}
+	microData, err := joinPersons(households, persons, l.links, len(personHeader))
+	if err != nil {
+		return Inputs{}, err
+	}
+	constraints, err := joinConstraints(l.householdConstraints, l.constraints)
+	if err != nil {
+		return Inputs{}, err
+	}
+	return Inputs{Constraints: constraints, MicroData: microData, Header: header}, nil
+}
+
+// AggregateHouseholds collapses linked person microdata into a household table and
+// a person table, for microdata that only exist at person level. Each household is
+// identified by the link of its persons and takes the variables columns from them;
+// these household attributes must be the same for every person of a household.
+//
+// Parameters:
+//   - persons: Person records, as read by ReadLinkedMicroDataCSV
+//   - header: The person variable names
+//   - links: The household ID of every person
+//   - variables: The columns holding household attributes
+//
+// Returns:
+//   - households: One record per household, in order of first appearance
+//   - hhHeader: The household variable names (variables)
+//   - people: The person records without the household columns
+//   - personHeader: The remaining person variable names
+//   - error: A missing column or a household whose persons disagree
+func AggregateHouseholds(persons []MicroData, header []string, links []string, variables []string) (
+	households []MicroData, hhHeader []string, people []MicroData, personHeader []string, err error) {
+	columnOf := make(map[string]int, len(header))
+	for i, name := range header {
+		columnOf[name] = i
+	}
+	hhColumns := make([]int, len(variables))
+	isHousehold := make(map[int]bool, len(variables))
+	for i, name := range variables {
+		column, ok := columnOf[name]
+		if !ok {
+			return nil, nil, nil, nil, fmt.Errorf("household variable %s is not in the person microdata", name)
+		}
+		if isHousehold[column] {
+			return nil, nil, nil, nil, fmt.Errorf("household variable %s is listed twice", name)
+		}
+		hhColumns[i] = column
+		isHousehold[column] = true
+	}
+	var personColumns []int
+	for i, name := range header {
+		if !isHousehold[i] {
+			personColumns = append(personColumns, i)
+			personHeader = append(personHeader, name)
+		}
+	}
+
+	index := make(map[string]int)
+	people = make([]MicroData, len(persons))
+	for p, person := range persons {
+		values := selectValues(person.Values, hhColumns)
+		i, seen := index[links[p]]
+		if !seen {
+			index[links[p]] = len(households)
+			households = append(households, MicroData{ID: links[p], Values: values})
+		} else if !reflect.DeepEqual(households[i].Values, values) {
+			return nil, nil, nil, nil, fmt.Errorf("persons of household %s disagree on the household variables", links[p])
+		}
+		people[p] = MicroData{ID: person.ID, Values: selectValues(person.Values, personColumns)}
+	}
+	return households, append([]string(nil), variables...), people, personHeader, nil
+}
+
+// jointHeader is the household header, the person header and PersonsColumn
+func jointHeader(hhHeader, personHeader []string) ([]string, error) {
+	header := make([]string, 0, len(hhHeader)+len(personHeader)+1)
+	seen := make(map[string]bool)
+	for _, name := range append(append(append(header, hhHeader...), personHeader...), PersonsColumn) {
+		if seen[name] {
+			return nil, fmt.Errorf("variable %s is used at both household and person level, rename one of them", name)
+		}
+		seen[name] = true
+		header = append(header, name)
+	}
+	return header, nil
+}
+
+// joinPersons extends every household with the summed values and count of its persons
+func joinPersons(households, persons []MicroData, links []string, personVariables int) ([]MicroData, error) {
+	index := make(map[string]int, len(households))
+	joint := make([]MicroData, len(households))
+	for i, h := range households {
+		index[h.ID] = i
+		values := make([]float64, len(h.Values), len(h.Values)+personVariables+1)
+		copy(values, h.Values)
+		joint[i] = MicroData{ID: h.ID, Values: append(values, make([]float64, personVariables+1)...)}
+	}
+
+	for p, person := range persons {
+		i, ok := index[links[p]]
+		if !ok {
+			return nil, fmt.Errorf("person %s belongs to unknown household %s", person.ID, links[p])
+		}
+		values := joint[i].Values[len(households[i].Values):]
+		for j, v := range person.Values {
+			values[j] += v
+		}
+		values[personVariables]++
+	}
+	return joint, nil
+}
+
+// joinConstraints appends every area's person constraints and person total to its
+// household constraints
+func joinConstraints(hhConstraints, personConstraints []ConstraintData) ([]ConstraintData, error) {
+	byArea := make(map[string]ConstraintData, len(personConstraints))
+	for _, c := range personConstraints {
+		byArea[c.ID] = c
+	}
+	if len(byArea) != len(hhConstraints) {
+		return nil, fmt.Errorf("%d areas have household constraints but %d have person constraints",
+			len(hhConstraints), len(byArea))
+	}
+
+	joint := make([]ConstraintData, len(hhConstraints))
+	for i, h := range hhConstraints {
+		p, ok := byArea[h.ID]
+		if !ok {
+			return nil, fmt.Errorf("area %s has no person constraints", h.ID)
+		}
+		values := make([]float64, 0, len(h.Values)+len(p.Values)+1)
+		values = append(append(append(values, h.Values...), p.Values...), p.Total)
+		joint[i] = ConstraintData{ID: h.ID, Values: values, Total: h.Total, PersonTotal: p.Total}
+	}
+	return joint, nil
+}
+
+// ReadLinkedMicroDataCSV reads person microdata carrying a link column, e.g. the ID
+// of the person's household. The link column is not a variable: it is removed from
+// the values and the header and returned separately, one entry per record.
+func ReadLinkedMicroDataCSV(filename, linkColumn string) ([]MicroData, []string, []string, error) {
+	return ReadLinkedMicroDataCSVContext(context.Background(), filename, linkColumn, nil)
+}
+
+// ReadLinkedMicroDataCSVContext reads linked microdata like ReadLinkedMicroDataCSV,
+// reporting the rows and bytes read to progress (which may be nil) and stopping
+// with an error once ctx is done
+func ReadLinkedMicroDataCSVContext(ctx context.Context, filename, linkColumn string,
+	progress chan<- LoadProgress) ([]MicroData, []string, []string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	tracker := newLoadTracker(ctx, filename, info.Size(), progress)
+	defer tracker.finish()
+
+	in, err := decompress(filename, tracker.reader(file))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	reader := csv.NewReader(in)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to read header of %s: %w", filename, err)
+	}
+	link := -1
+	for i, name := range header {
+		if i > 0 && name == linkColumn {
+			link = i
+		}
+	}
+	if link < 0 {
+		return nil, nil, nil, fmt.Errorf("%s has no link column %s", filename, linkColumn)
+	}
+
+	variables := make([]string, 0, len(header)-2)
+	for i, name := range header[1:] {
+		if i+1 != link {
+			variables = append(variables, name)
+		}
+	}
+
+	var data []MicroData
+	var links []string
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err := tracker.err(); err != nil {
+			return nil, nil, nil, err
+		}
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		values := make([]float64, 0, len(variables))
+		for i, v := range row[1:] {
+			if i+1 == link {
+				continue
+			}
+			num, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("%s line %d, column %s: %w", filename, line, header[i+1], err)
+			}
+			values = append(values, num)
+		}
+		tracker.rows.Add(1)
+		data = append(data, MicroData{ID: row[0], Values: values})
+		links = append(links, row[link])
+	}
+	return data, variables, links, nil
+}
+
+// personsWriter expands the selected households of every area into their persons
+type personsWriter struct {
+	members map[string][]string // Household ID -> person IDs
+	file    *outputFile
+	writer  *csv.Writer
+}
+
+// newPersonsWriter creates Households.PersonsOutputFile, or returns nil when it is
+// not configured. The household membership is read again from Microdata.File.
+func newPersonsWriter(popConfig PopulationConfig, key []byte, retry retryPolicy) (*personsWriter, error) {
+	path := popConfig.Households.PersonsOutputFile
+	if path == "" {
+		return nil, nil
+	}
+	if !popConfig.HouseholdSynthesis() {
+		return nil, fmt.Errorf("households.personsOutputFile needs household synthesis, set households.file or households.variables")
+	}
+	if popConfig.Output.AggregateOnly {
+		return nil, fmt.Errorf("the persons output needs the household assignments, disable aggregateOnly")
+	}
+
+	persons, _, links, err := ReadLinkedMicroDataCSV(popConfig.Microdata.File, popConfig.Households.LinkColumn)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read household membership: %w", err)
+	}
+	members := make(map[string][]string)
+	for i, person := range persons {
+		members[links[i]] = append(members[links[i]], person.ID)
+	}
+
+	file, err := createOutput(path, key, retry)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create persons file: %w", err)
+	}
+	w := &personsWriter{members: members, file: file, writer: csv.NewWriter(file)}
+	if err := w.writer.Write([]string{"area_id", "household_id", "microdata_household_id", "person_id"}); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error writing persons header: %w", err)
+	}
+	return w, nil
+}
+
+// writeArea writes the persons of every synthetic household of one area. Synthetic
+// household IDs are the area code followed by the household's position in the area,
+// so a microdata household selected twice yields two distinct households.
+func (w *personsWriter) writeArea(res Result) error {
+	for n, household := range res.IDs {
+		householdID := res.Area + "_" + strconv.Itoa(n+1)
+		for _, person := range w.members[household] {
+			if err := w.writer.Write([]string{res.Area, householdID, household, person}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *personsWriter) Close() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
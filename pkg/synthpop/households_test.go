@@ -0,0 +1,158 @@
+package synthpop
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testHouseholdConfig returns a joint run over testdata/households: six households
+// of one to three persons, and two areas of three households that some of them fit
+// exactly
+func testHouseholdConfig() PopulationConfig {
+	dir := filepath.Join("testdata", "households")
+	var popConfig PopulationConfig
+	popConfig.Constraints.File = filepath.Join(dir, "pcons.csv")
+	popConfig.Microdata.File = filepath.Join(dir, "persons.csv")
+	popConfig.Households.File = filepath.Join(dir, "hh.csv")
+	popConfig.Households.ConstraintsFile = filepath.Join(dir, "hcons.csv")
+	popConfig.Households.LinkColumn = "hid"
+	return popConfig
+}
+
+func TestLoadHouseholds(t *testing.T) {
+	quietConsole(t)
+	popConfig := testHouseholdConfig()
+	c := NewController()
+	in, err := c.Load(context.Background(), popConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(in.Header, ","); got != "own,rent,adult,child,"+PersonsColumn {
+		t.Errorf("joint header %s", got)
+	}
+	// A household holds its values, then the sums over its persons and their count
+	households := make(map[string][]float64)
+	for _, md := range in.MicroData {
+		households[md.ID] = md.Values
+	}
+	if len(households) != 6 || !reflect.DeepEqual(households["H3"], []float64{1, 0, 2, 1, 3}) {
+		t.Errorf("household H3 joined as %v, of %d households", households["H3"], len(households))
+	}
+	want := []ConstraintData{
+		{ID: "A1", Values: []float64{1, 2, 4, 1, 5}, Total: 3, PersonTotal: 5},
+		{ID: "A2", Values: []float64{2, 1, 4, 2, 6}, Total: 3, PersonTotal: 6},
+	}
+	if !reflect.DeepEqual(in.Constraints, want) {
+		t.Errorf("joint constraints %+v, want %+v", in.Constraints, want)
+	}
+
+	// The linked persons are cached, and loaded again only when they change
+	key := microdataKey{file: popConfig.Microdata.File, link: "hid"}
+	set, ok := c.microdataSets[key]
+	if !ok || len(set.links) != 11 || set.links[0] != "H1" {
+		t.Fatalf("linked persons cached as %+v", set)
+	}
+	again, err := c.Load(context.Background(), popConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &c.microdataSets[key].data[0] != &set.data[0] {
+		t.Error("persons loaded again from an unchanged file")
+	}
+	if !reflect.DeepEqual(again, in) {
+		t.Error("cached inputs joined differently")
+	}
+
+	// Households aggregated from the attributes of their persons are the same
+	aggregated := popConfig
+	aggregated.Microdata.File = filepath.Join("testdata", "households", "persons_tenure.csv")
+	aggregated.Households.File = ""
+	aggregated.Households.Variables = []string{"own", "rent"}
+	if got, err := c.Load(context.Background(), aggregated); err != nil || !reflect.DeepEqual(got, in) {
+		t.Errorf("aggregated households loaded as %+v (%v)", got, err)
+	}
+	if _, _, got, err := LoadHouseholdInputs(popConfig); err != nil || !reflect.DeepEqual(got, in.Header) {
+		t.Errorf("LoadHouseholdInputs header %v (%v)", got, err)
+	}
+
+	// Loading stops with the context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewController().Load(ctx, popConfig); !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v, want the load cancelled", err)
+	}
+
+	tests := []struct {
+		name  string
+		apply func(*PopulationConfig)
+		want  string
+	}{
+		{"no link", func(c *PopulationConfig) { c.Households.LinkColumn = "" }, "needs households.constraintsFile and households.linkColumn"},
+		{"file and variables", func(c *PopulationConfig) { c.Households.Variables = []string{"own"} }, "either households.file or households.variables"},
+		{"unknown link", func(c *PopulationConfig) { c.Households.LinkColumn = "household" }, "has no link column household"},
+		{"missing households", func(c *PopulationConfig) { c.Households.File = "missing.csv" }, "household loading error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			popConfig := testHouseholdConfig()
+			tt.apply(&popConfig)
+			if _, err := NewController().Load(context.Background(), popConfig); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestHouseholdSynthesis checks that the persons of the synthesized households
+// match the person constraints of their area
+func TestHouseholdSynthesis(t *testing.T) {
+	quietConsole(t)
+	popConfig := testHouseholdConfig()
+	in, err := NewController().Load(context.Background(), popConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	persons, personHeader, links, err := ReadLinkedMicroDataCSV(popConfig.Microdata.File, popConfig.Households.LinkColumn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	members := make(map[string][]MicroData)
+	for i, person := range persons {
+		members[links[i]] = append(members[links[i]], person)
+	}
+
+	results, err := SynthesizeAreas(in.Constraints, in.MicroData, in.Header, testConfig(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	personConstraints, _, err := ReadConstraintCSV(popConfig.Constraints.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, res := range results {
+		checkPopulation(t, res, in.Constraints[i], in.MicroData)
+		if res.Fitness != 0 {
+			t.Errorf("area %s fitted to %v, want an exact fit", res.Area, res.Fitness)
+		}
+		// The persons of the households sum to the person constraints
+		sums := make([]float64, len(personHeader))
+		count := 0
+		for _, household := range res.IDs {
+			for _, person := range members[household] {
+				for j, v := range person.Values {
+					sums[j] += v
+				}
+				count++
+			}
+		}
+		want := personConstraints[i]
+		if count != int(want.Total) || !reflect.DeepEqual(sums, want.Values) {
+			t.Errorf("area %s has %d persons with totals %v, want %v and %v", res.Area, count, sums, want.Total, want.Values)
+		}
+	}
+}
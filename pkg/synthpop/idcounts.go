@@ -0,0 +1,175 @@
+package synthpop
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+)
+
+// Layouts of the ID mapping output (Output.Layout)
+const (
+	// One row per synthetic individual: area_id, microdata_id
+	OutputLayoutIDs = "ids"
+	// One row per area and selected record: area_id, microdata_id, count. National
+	// runs clone the same records many times, so this is far smaller.
+	OutputLayoutCounts = "counts"
+)
+
+// idsHeader returns the header of the ID mapping output in a layout
+func idsHeader(layout string) []string {
+	if layout == OutputLayoutCounts {
+		return []string{"area_id", "microdata_id", "count"}
+	}
+	return []string{"area_id", "microdata_id"}
+}
+
+// idTally counts how many times each record appears in a population, keeping the
+// order records first appear in so outputs follow the population
+type idTally struct {
+	counts map[string]int
+	order  []string
+}
+
+func newIDTally() *idTally {
+	return &idTally{counts: make(map[string]int)}
+}
+
+// reset replaces the tally with the counts of ids
+func (t *idTally) reset(ids []string) {
+	clear(t.counts)
+	t.order = t.order[:0]
+	for _, id := range ids {
+		t.add(id, 1)
+	}
+}
+
+func (t *idTally) add(id string, n int) {
+	if _, ok := t.counts[id]; !ok {
+		t.order = append(t.order, id)
+	}
+	t.counts[id] += n
+}
+
+// writeIDRows writes the ID mapping rows of an area to a CSV output, one per
+// individual, or one per record with its count when tally is set (counts layout)
+func writeIDRows(w *csv.Writer, tally *idTally, res Result) error {
+	if tally == nil {
+		for _, id := range res.IDs {
+			if err := w.Write([]string{res.Area, id}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	tally.reset(res.IDs)
+	for _, id := range tally.order {
+		if err := w.Write([]string{res.Area, id, strconv.Itoa(tally.counts[id])}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConvertIDs converts an ID mapping CSV between the ids and counts layouts,
+// detecting the layout of in from its header, and returns the layout written to
+// out. Either file may be compressed (.gz or .zst). Converting to counts groups the consecutive rows of each area, as runs write
+// them; converting to ids repeats every record count times.
+func ConvertIDs(inPath, outPath string) (string, error) {
+	in, err := os.Open(inPath)
+	if err != nil {
+		return "", fmt.Errorf("cannot open IDs file: %w", err)
+	}
+	defer in.Close()
+	data, err := decompress(inPath, bufio.NewReaderSize(in, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	reader := csv.NewReader(data)
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		return "", fmt.Errorf("error reading IDs header: %w", err)
+	}
+	var toLayout string
+	switch {
+	case slices.Equal(header, idsHeader(OutputLayoutIDs)):
+		toLayout = OutputLayoutCounts
+	case slices.Equal(header, idsHeader(OutputLayoutCounts)):
+		toLayout = OutputLayoutIDs
+	default:
+		return "", fmt.Errorf("header %v is neither %v nor %v", header,
+			idsHeader(OutputLayoutIDs), idsHeader(OutputLayoutCounts))
+	}
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return "", fmt.Errorf("cannot create output file: %w", err)
+	}
+	defer out.Close()
+	var sink io.Writer = out
+	comp := newCompressor(outPath, out)
+	if comp != nil {
+		sink = comp
+	}
+	writer := csv.NewWriter(sink)
+	if err := writer.Write(idsHeader(toLayout)); err != nil {
+		return "", err
+	}
+
+	tally := newIDTally()
+	area := ""
+	flush := func() error {
+		for _, id := range tally.order {
+			if err := writer.Write([]string{area, id, strconv.Itoa(tally.counts[id])}); err != nil {
+				return err
+			}
+		}
+		tally.reset(nil)
+		return nil
+	}
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("error reading IDs row: %w", err)
+		}
+		if toLayout == OutputLayoutCounts {
+			if row[0] != area {
+				if err := flush(); err != nil {
+					return "", err
+				}
+				area = row[0]
+			}
+			tally.add(row[1], 1)
+			continue
+		}
+		n, err := strconv.Atoi(row[2])
+		if err != nil || n < 0 {
+			return "", fmt.Errorf("line %d: invalid count %q", line, row[2])
+		}
+		for range n {
+			if err := writer.Write(row[:2]); err != nil {
+				return "", err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return "", err
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return "", fmt.Errorf("error writing %s: %w", outPath, err)
+	}
+	if comp != nil {
+		if err := comp.Close(); err != nil {
+			return "", fmt.Errorf("error writing %s: %w", outPath, err)
+		}
+	}
+	return toLayout, out.Close()
+}
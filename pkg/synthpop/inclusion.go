@@ -0,0 +1,75 @@
+package synthpop
+
+import (
+	"encoding/csv"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// inclusionWriter writes per-area record inclusion probabilities for design-based
+// variance estimation downstream. For every microdata record selected in an area it
+// reports how often it was drawn, the estimated inclusion probability (times
+// selected / total draws, i.e. count / area population) and the size of the area's
+// valid donor pool, from which other estimators (e.g. count / pool size) follow.
+type inclusionWriter struct {
+	file   *outputFile
+	writer *csv.Writer
+}
+
+// newInclusionWriter creates the inclusion file, or returns nil when none is configured
+func newInclusionWriter(popConfig PopulationConfig, key []byte, retry retryPolicy) (*inclusionWriter, error) {
+	if popConfig.Output.InclusionFile == "" {
+		return nil, nil
+	}
+	if popConfig.Output.AggregateOnly {
+		return nil, fmt.Errorf("inclusion probabilities need the individual assignments, disable aggregateOnly")
+	}
+	file, err := createOutput(popConfig.Output.InclusionFile, key, retry)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create inclusion file: %w", err)
+	}
+	w := &inclusionWriter{file: file, writer: csv.NewWriter(file)}
+	header := []string{"area_id", "microdata_id", "count", "inclusion_probability", "pool_size"}
+	if err := w.writer.Write(header); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error writing inclusion header: %w", err)
+	}
+	return w, nil
+}
+
+// writeArea writes one row per distinct record selected in the area, sorted by ID
+func (w *inclusionWriter) writeArea(res Result) error {
+	counts := make(map[string]int)
+	for _, id := range res.IDs {
+		counts[id]++
+	}
+	ids := make([]string, 0, len(counts))
+	for id := range counts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	draws := float64(len(res.IDs))
+	pool := strconv.Itoa(res.PoolSize)
+	for _, id := range ids {
+		row := []string{res.Area, id,
+			strconv.Itoa(counts[id]),
+			strconv.FormatFloat(float64(counts[id])/draws, 'f', -1, 64),
+			pool}
+		if err := w.writer.Write(row); err != nil {
+			return fmt.Errorf("error writing inclusion row: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close flushes and closes the inclusion file
+func (w *inclusionWriter) Close() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
@@ -0,0 +1,130 @@
+package synthpop
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// ReadAttributesCSV reads the attributes of the microdata records: a CSV with a
+// header row, the microdata ID in the first column and any attributes, categorical
+// or continuous, in the others. Values are kept as written.
+//
+// Parameters:
+//   - filename: Path to the attributes CSV, optionally compressed (.gz or .zst)
+//
+// Returns:
+//   - map[string][]string: The attribute values of every listed record
+//   - []string: The attribute names
+//   - error: Any error reading the file, or a record listed twice
+func ReadAttributesCSV(filename string) (map[string][]string, []string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot open attributes file: %w", err)
+	}
+	defer file.Close()
+	in, err := decompress(filename, file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reader := csv.NewReader(in)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot read attributes header: %w", err)
+	}
+	if len(header) < 2 {
+		return nil, nil, fmt.Errorf("attributes file needs a microdata ID column and at least one attribute")
+	}
+	attributes := make(map[string][]string)
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("attributes file line %d: %w", line, err)
+		}
+		if _, ok := attributes[record[0]]; ok {
+			return nil, nil, fmt.Errorf("attributes file line %d: microdata ID %s is listed twice", line, record[0])
+		}
+		attributes[record[0]] = record[1:]
+	}
+	return attributes, header[1:], nil
+}
+
+// individualsWriter writes the person-level output: every synthetic individual
+// with its area and the attributes of its microdata record, so the population can
+// be analysed without joining the ID mappings back to the microdata
+type individualsWriter struct {
+	file       *outputFile
+	writer     *csv.Writer
+	attributes map[string][]string
+	row        []string
+}
+
+// newIndividualsWriter creates Output.IndividualsFile and reads the attributes
+// joined into it, or returns nil when it is not configured. Every microdata record
+// must have attributes, so a gap is reported before the run rather than part way.
+func newIndividualsWriter(popConfig PopulationConfig, microData []MicroData, key []byte, retry retryPolicy) (*individualsWriter, error) {
+	path := popConfig.Output.IndividualsFile
+	if path == "" {
+		return nil, nil
+	}
+	if popConfig.Output.AggregateOnly {
+		return nil, fmt.Errorf("the individuals file needs the individual assignments, disable aggregateOnly")
+	}
+	attributes, header, err := ReadAttributesCSV(popConfig.Microdata.AttributesFile)
+	if err != nil {
+		return nil, err
+	}
+	missing, first := 0, ""
+	for _, md := range microData {
+		if _, ok := attributes[md.ID]; !ok {
+			if missing == 0 {
+				first = md.ID
+			}
+			missing++
+		}
+	}
+	if missing > 0 {
+		return nil, fmt.Errorf("%d microdata records have no attributes in %s, e.g. %s",
+			missing, popConfig.Microdata.AttributesFile, first)
+	}
+
+	file, err := createOutput(path, key, retry)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create individuals file: %w", err)
+	}
+	w := &individualsWriter{file: file, writer: csv.NewWriter(file), attributes: attributes}
+	if err := w.writer.Write(append([]string{"person_id", "area_id", "microdata_id"}, header...)); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error writing individuals header: %w", err)
+	}
+	return w, nil
+}
+
+// writeArea writes every synthetic individual of one area. Person IDs are the
+// area code followed by the individual's position in the area, like agent IDs.
+func (w *individualsWriter) writeArea(res Result) error {
+	for n, id := range res.IDs {
+		w.row = append(w.row[:0], res.Area+"_"+strconv.Itoa(n+1), res.Area, id)
+		w.row = append(w.row, w.attributes[id]...)
+		if err := w.writer.Write(w.row); err != nil {
+			return fmt.Errorf("error writing individuals row: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close flushes and closes the individuals file
+func (w *individualsWriter) Close() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
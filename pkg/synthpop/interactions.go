@@ -0,0 +1,191 @@
+package synthpop
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Two-way interaction preservation: only one-way margins are constrained, so how
+// much of the association between two variables survives the selection is up to
+// the microdata. For every declared pair the interactions output compares the
+// synthetic population of each area, and all areas together, with the microdata:
+// the joint mean E[ab] (for 0/1 variables the share holding both) and the
+// correlation of the pair, which is independent of the margins the area was fitted
+// to. A small correlation difference means the selection kept the association.
+
+// interactionAllAreas is the area_id of the rows over all areas
+const interactionAllAreas = "ALL"
+
+// pairStats accumulates the moments of a pair of variables
+type pairStats struct {
+	n, sumA, sumB, sumAA, sumBB, sumAB float64
+}
+
+func (p *pairStats) add(a, b, weight float64) {
+	p.n += weight
+	p.sumA += weight * a
+	p.sumB += weight * b
+	p.sumAA += weight * a * a
+	p.sumBB += weight * b * b
+	p.sumAB += weight * a * b
+}
+
+func (p *pairStats) merge(q pairStats) {
+	p.n += q.n
+	p.sumA += q.sumA
+	p.sumB += q.sumB
+	p.sumAA += q.sumAA
+	p.sumBB += q.sumBB
+	p.sumAB += q.sumAB
+}
+
+// joint returns E[ab]
+func (p pairStats) joint() float64 {
+	if p.n == 0 {
+		return math.NaN()
+	}
+	return p.sumAB / p.n
+}
+
+// correlation returns the Pearson correlation, NaN when a variable is constant
+func (p pairStats) correlation() float64 {
+	if p.n == 0 {
+		return math.NaN()
+	}
+	meanA, meanB := p.sumA/p.n, p.sumB/p.n
+	cov := p.sumAB/p.n - meanA*meanB
+	varA := p.sumAA/p.n - meanA*meanA
+	varB := p.sumBB/p.n - meanB*meanB
+	if varA <= EPSILON || varB <= EPSILON {
+		return math.NaN()
+	}
+	return cov / math.Sqrt(varA*varB)
+}
+
+// interactionPair is a declared pair resolved to header columns
+type interactionPair struct {
+	a, b       string // Output names of the variables
+	colA, colB int
+	microdata  pairStats // Reference over all microdata records
+	overall    pairStats // Synthetic, over all areas
+}
+
+// interactionWriter writes the interaction preservation scores of every area and
+// of all areas together
+type interactionWriter struct {
+	file    *outputFile
+	writer  *csv.Writer
+	pairs   []interactionPair
+	records map[string][]float64 // Microdata values by ID
+}
+
+// newInteractionWriter resolves the declared pairs against header and creates the
+// interactions file, naming the variables after outputHeader, or returns nil when
+// none is configured
+func newInteractionWriter(popConfig PopulationConfig, header, outputHeader []string, microData []MicroData,
+	key []byte, retry retryPolicy) (*interactionWriter, error) {
+	if popConfig.Validate.InteractionsFile == "" {
+		if len(popConfig.Validate.Interactions) > 0 {
+			return nil, fmt.Errorf("validate.interactions needs validate.interactionsFile")
+		}
+		return nil, nil
+	}
+	if len(popConfig.Validate.Interactions) == 0 {
+		return nil, fmt.Errorf("validate.interactionsFile needs at least one pair in validate.interactions")
+	}
+	if popConfig.Output.AggregateOnly {
+		return nil, fmt.Errorf("interaction scores need the individual assignments, disable aggregateOnly")
+	}
+
+	columnOf := make(map[string]int, len(header))
+	for i, name := range header {
+		columnOf[name] = i
+	}
+	w := &interactionWriter{records: make(map[string][]float64, len(microData))}
+	for _, pair := range popConfig.Validate.Interactions {
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("validate.interactions: %v is not a pair of variables", pair)
+		}
+		colA, okA := columnOf[pair[0]]
+		colB, okB := columnOf[pair[1]]
+		if !okA || !okB {
+			return nil, fmt.Errorf("validate.interactions: %v has a variable that is not in the header", pair)
+		}
+		w.pairs = append(w.pairs, interactionPair{a: outputHeader[colA], b: outputHeader[colB], colA: colA, colB: colB})
+	}
+	for _, md := range microData {
+		w.records[md.ID] = md.Values
+		for i := range w.pairs {
+			p := &w.pairs[i]
+			p.microdata.add(md.Values[p.colA], md.Values[p.colB], 1)
+		}
+	}
+
+	file, err := createOutput(popConfig.Validate.InteractionsFile, key, retry)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create interactions file: %w", err)
+	}
+	w.file, w.writer = file, csv.NewWriter(file)
+	columns := []string{"area_id", "variable_a", "variable_b", "synthetic_joint", "microdata_joint",
+		"synthetic_correlation", "microdata_correlation", "correlation_difference"}
+	if err := w.writer.Write(columns); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error writing interactions header: %w", err)
+	}
+	return w, nil
+}
+
+// formatScore formats a score, leaving undefined ones empty
+func formatScore(v float64) string {
+	if math.IsNaN(v) {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func (w *interactionWriter) writeRow(area string, p *interactionPair, synthetic pairStats) error {
+	synCorr, microCorr := synthetic.correlation(), p.microdata.correlation()
+	row := []string{area, p.a, p.b,
+		formatScore(synthetic.joint()), formatScore(p.microdata.joint()),
+		formatScore(synCorr), formatScore(microCorr), formatScore(math.Abs(synCorr - microCorr))}
+	if err := w.writer.Write(row); err != nil {
+		return fmt.Errorf("error writing interactions row: %w", err)
+	}
+	return nil
+}
+
+// writeArea scores the synthetic population of one area
+func (w *interactionWriter) writeArea(res Result) error {
+	for i := range w.pairs {
+		p := &w.pairs[i]
+		var synthetic pairStats
+		for _, id := range res.IDs {
+			values := w.records[id]
+			synthetic.add(values[p.colA], values[p.colB], 1)
+		}
+		p.overall.merge(synthetic)
+		if err := w.writeRow(res.Area, p, synthetic); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close writes the rows over all areas and closes the file
+func (w *interactionWriter) Close() error {
+	for i := range w.pairs {
+		p := &w.pairs[i]
+		if err := w.writeRow(interactionAllAreas, p, p.overall); err != nil {
+			w.file.Close()
+			return err
+		}
+	}
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
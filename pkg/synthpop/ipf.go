@@ -0,0 +1,234 @@
+package synthpop
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+)
+
+// IPF defaults, used when the ipf section leaves them at zero
+const (
+	defaultIPFMaxIterations = 100
+	defaultIPFTolerance     = 1e-6
+)
+
+// IPFConfig holds the settings of the IPF algorithm
+type IPFConfig struct {
+	MaxIterations int     `json:"maxIterations"` // Fitting sweeps over all constraints (default 100)
+	Tolerance     float64 `json:"tolerance"`     // Stop when no weight changes by more than this factor (default 1e-6)
+	Fractional    bool    `json:"fractional"`    // Keep fractional weights instead of integerizing
+}
+
+// ipfPopulation fits fractional weights for the valid microdata records of an area
+// with classic iterative proportional fitting: for every constraint variable in
+// turn, the weights of the records that have the attribute are scaled so that the
+// weighted total matches the constraint. Unless fractional weights are requested,
+// the weights are then integerized with truncate-replicate-sample.
+//
+// Parameters:
+//   - constraint: The area constraints
+//   - microdata: The source microdata
+//   - config: Configuration holding the IPF settings
+//   - distanceFunction: Fitness function used to report the fit of the result
+//   - rng: Random number generator for the integerization
+//
+// Returns:
+//   - Result: The fitted population; BestIteration is the number of sweeps used
+//   - error: ErrNoValidMicrodata if no record satisfies the area's zero constraints
+func ipfPopulation(constraint ConstraintData, microdata []MicroData, config AnnealingConfig, distanceFunction DistanceFunc,
+	rng *rand.Rand) (Result, error) {
+	maxIterations := config.IPF.MaxIterations
+	if maxIterations <= 0 {
+		maxIterations = defaultIPFMaxIterations
+	}
+	tolerance := config.IPF.Tolerance
+	if tolerance <= 0 {
+		tolerance = defaultIPFTolerance
+	}
+
+	var pool []int
+	for i, md := range microdata {
+		if isValidMicrodata(md.Values, constraint.Values) {
+			pool = append(pool, i)
+		}
+	}
+	if len(pool) == 0 {
+		return Result{}, fmt.Errorf("area %s: %w", constraint.ID, ErrNoValidMicrodata)
+	}
+
+	// Start from a uniform distribution of the area population over the pool, or
+	// from its distribution by design weight
+	weights := make([]float64, len(pool))
+	cumulative := poolWeights(pool, microdata, nil)
+	for k, i := range pool {
+		if cumulative == nil {
+			weights[k] = constraint.Total / float64(len(pool))
+		} else {
+			weights[k] = constraint.Total * microdata[i].Weight / cumulative[len(cumulative)-1]
+		}
+	}
+
+	sweeps := 0
+	for sweeps < maxIterations {
+		sweeps++
+		maxChange := 0.0
+		for j, target := range constraint.Values {
+			current := 0.0
+			for k, i := range pool {
+				current += weights[k] * microdata[i].Values[j]
+			}
+			if current < EPSILON {
+				continue // No record in the pool has the attribute, it cannot be fitted
+			}
+			factor := target / current
+			if factor == 1 {
+				continue
+			}
+			for k, i := range pool {
+				if microdata[i].Values[j] != 0 {
+					weights[k] *= factor
+				}
+			}
+			maxChange = math.Max(maxChange, math.Abs(factor-1))
+		}
+		if maxChange < tolerance {
+			break
+		}
+	}
+
+	// Scale to the area population, which the marginals need not imply exactly
+	sum := 0.0
+	for _, w := range weights {
+		sum += w
+	}
+	if sum > 0 {
+		for k := range weights {
+			weights[k] *= constraint.Total / sum
+		}
+	}
+
+	res := Result{
+		Area:             constraint.ID,
+		Population:       constraint.Total,
+		ConstraintTotals: constraint.Values,
+		BestIteration:    sweeps,
+		PoolSize:         len(pool),
+	}
+	res.Totals = make([]float64, len(constraint.Values))
+	if config.IPF.Fractional {
+		res.Weights = make(map[string]float64, len(pool))
+		for k, i := range pool {
+			res.Weights[microdata[i].ID] += weights[k]
+			for j, v := range microdata[i].Values {
+				res.Totals[j] += weights[k] * v
+			}
+		}
+	} else {
+		counts := integerize(weights, int(constraint.Total), rng)
+		res.IDs = make([]string, 0, int(constraint.Total))
+		for k, i := range pool {
+			for c := 0; c < counts[k]; c++ {
+				res.IDs = append(res.IDs, microdata[i].ID)
+				for j, v := range microdata[i].Values {
+					res.Totals[j] += v
+				}
+			}
+		}
+	}
+	res.Fitness = distanceFunction(constraint.Values, res.Totals)
+	return res, nil
+}
+
+// integerize turns weights summing to total into integer counts with the
+// truncate-replicate-sample method: every record keeps the integer part of its
+// weight and the remaining individuals are drawn without replacement with
+// probability proportional to the fractional parts.
+func integerize(weights []float64, total int, rng *rand.Rand) []int {
+	counts := make([]int, len(weights))
+	fractions := make([]float64, len(weights))
+	assigned := 0
+	for k, w := range weights {
+		counts[k] = int(math.Floor(w))
+		fractions[k] = w - float64(counts[k])
+		assigned += counts[k]
+	}
+
+	for remaining := total - assigned; remaining > 0; {
+		sum := 0.0
+		for _, f := range fractions {
+			sum += f
+		}
+		if sum < EPSILON {
+			// Rounding left no fractions to draw from, fall back to the weights
+			// (kept above zero so every record stays drawable)
+			for k, w := range weights {
+				fractions[k] = w + EPSILON
+			}
+			continue
+		}
+		draw := rng.Float64() * sum
+		k := 0
+		for ; k < len(fractions)-1 && draw >= fractions[k]; k++ {
+			draw -= fractions[k]
+		}
+		counts[k]++
+		fractions[k] = 0
+		remaining--
+	}
+	return counts
+}
+
+// weightsWriter writes the fractional IPF weights of every area
+type weightsWriter struct {
+	file   *outputFile
+	writer *csv.Writer
+}
+
+// newWeightsWriter creates Output.WeightsFile, or returns nil when it is not configured
+func newWeightsWriter(popConfig PopulationConfig, config AnnealingConfig, key []byte, retry retryPolicy) (*weightsWriter, error) {
+	if popConfig.Output.WeightsFile == "" {
+		return nil, nil
+	}
+	if config.Algorithm != AlgorithmIPF || !config.IPF.Fractional {
+		return nil, fmt.Errorf("output.weightsFile needs algorithm ipf with ipf.fractional")
+	}
+	file, err := createOutput(popConfig.Output.WeightsFile, key, retry)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create weights file: %w", err)
+	}
+	w := &weightsWriter{file: file, writer: csv.NewWriter(file)}
+	if err := w.writer.Write([]string{"area_id", "microdata_id", "weight"}); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error writing weights header: %w", err)
+	}
+	return w, nil
+}
+
+// writeArea writes one row per weighted record of the area, sorted by ID
+func (w *weightsWriter) writeArea(res Result) error {
+	ids := make([]string, 0, len(res.Weights))
+	for id := range res.Weights {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		row := []string{res.Area, id, strconv.FormatFloat(res.Weights[id], 'f', -1, 64)}
+		if err := w.writer.Write(row); err != nil {
+			return fmt.Errorf("error writing weights row: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close flushes and closes the weights file
+func (w *weightsWriter) Close() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
@@ -0,0 +1,137 @@
+package synthpop
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// testIPFInputs returns four records crossing sex and age, one more excluded by the
+// area's zero constraint, and an area whose marginals the IPF fit reaches as their
+// product divided by the total: 1.8, 4.2, 1.2 and 2.8 individuals
+func testIPFInputs() (ConstraintData, []MicroData, []string) {
+	header := []string{"male", "female", "young", "old", "disabled"}
+	microData := []MicroData{
+		{ID: "my", Values: []float64{1, 0, 1, 0, 0}},
+		{ID: "mo", Values: []float64{1, 0, 0, 1, 0}},
+		{ID: "fy", Values: []float64{0, 1, 1, 0, 0}},
+		{ID: "fo", Values: []float64{0, 1, 0, 1, 0}},
+		{ID: "mod", Values: []float64{1, 0, 0, 1, 1}},
+	}
+	constraint := ConstraintData{ID: "A", Values: []float64{6, 4, 3, 7, 0}, Total: 10}
+	return constraint, microData, header
+}
+
+func TestIPFPopulation(t *testing.T) {
+	constraint, microData, header := testIPFInputs()
+	config := testConfig()
+	config.Algorithm = AlgorithmIPF
+	config.IPF.Fractional = true
+	distance, err := buildDistance(config, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := ipfPopulation(constraint, microData, config, distance, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{"my": 1.8, "mo": 4.2, "fy": 1.2, "fo": 2.8}
+	if len(res.Weights) != len(want) || res.PoolSize != 4 {
+		t.Fatalf("weights %v over a pool of %d, want %v", res.Weights, res.PoolSize, want)
+	}
+	for id, w := range want {
+		if math.Abs(res.Weights[id]-w) > 1e-9 {
+			t.Errorf("weight of %s is %v, want %v", id, res.Weights[id], w)
+		}
+	}
+	// One sweep fits both margins, the second finds nothing left to change
+	if res.BestIteration != 2 || res.Fitness > 1e-9 || res.IDs != nil {
+		t.Errorf("fitted in %d sweeps to %v with IDs %v", res.BestIteration, res.Fitness, res.IDs)
+	}
+	for j, v := range constraint.Values {
+		if math.Abs(res.Totals[j]-v) > 1e-9 {
+			t.Errorf("%s total %v, want %v", header[j], res.Totals[j], v)
+		}
+	}
+
+	// Design weights change the starting point, not the margins reached
+	weighted := append([]MicroData(nil), microData...)
+	for i := range weighted {
+		weighted[i].Weight = float64(i+1) / float64(len(weighted))
+	}
+	res, err = ipfPopulation(constraint, weighted, config, distance, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Fitness > 1e-6 || math.Abs(res.Weights["my"]-1.8) < 1e-3 {
+		t.Errorf("design-weighted fit %v with weights %v", res.Fitness, res.Weights)
+	}
+
+	// A single sweep is counted as such
+	config.IPF.MaxIterations = 1
+	if res, err = ipfPopulation(constraint, microData, config, distance, rand.New(rand.NewSource(1))); err != nil || res.BestIteration != 1 {
+		t.Errorf("%d sweeps (%v), want 1", res.BestIteration, err)
+	}
+
+	// Integerized, every record keeps the whole part of its weight and at most one more
+	config = testConfig()
+	config.Algorithm = AlgorithmIPF
+	for seed := int64(1); seed <= 20; seed++ {
+		res, err := synthesizeArea(constraint, microData, config, distance, rand.New(rand.NewSource(seed)), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkPopulation(t, res, constraint, microData)
+		counts := make(map[string]int)
+		for _, id := range res.IDs {
+			counts[id]++
+		}
+		for id, w := range want {
+			if c := float64(counts[id]); c < math.Floor(w) || c > math.Ceil(w) {
+				t.Fatalf("seed %d: %s drawn %d times for a weight of %v", seed, id, counts[id], w)
+			}
+		}
+		if res.Weights != nil || counts["mod"] != 0 {
+			t.Fatalf("seed %d: integer population %v with weights %v", seed, counts, res.Weights)
+		}
+	}
+
+	// No record fits the zero constraints
+	constraint.Values[0] = 0
+	constraint.Values[1] = 10
+	microData = microData[:2]
+	if _, err := ipfPopulation(constraint, microData, config, distance, rand.New(rand.NewSource(1))); !errors.Is(err, ErrNoValidMicrodata) {
+		t.Errorf("error %v, want ErrNoValidMicrodata", err)
+	}
+}
+
+func TestIntegerize(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	tests := []struct {
+		name    string
+		weights []float64
+		total   int
+	}{
+		{"fractions", []float64{1.5, 2.25, 0.25}, 4},
+		{"whole", []float64{2, 1, 3}, 6},
+		// Whole weights short of the total leave no fractions, the rest is drawn by weight
+		{"no fractions left", []float64{1, 1}, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counts := integerize(tt.weights, tt.total, rng)
+			sum := 0
+			for k, c := range counts {
+				if float64(c) < math.Floor(tt.weights[k]) {
+					t.Errorf("count %d of weight %v", c, tt.weights[k])
+				}
+				sum += c
+			}
+			if sum != tt.total {
+				t.Errorf("counts %v sum to %d, want %d", counts, sum, tt.total)
+			}
+		})
+	}
+}
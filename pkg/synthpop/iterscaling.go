@@ -0,0 +1,69 @@
+package synthpop
+
+import (
+	"fmt"
+	"math"
+)
+
+// Units AnnealingConfig.IterationScaling scales the iteration budget by
+const (
+	ScaleByPopulation = "population" // The area's total population
+	ScaleByCells      = "cells"      // The area's non-zero constraint cells
+)
+
+// IterationScaling sets the iteration budget of every area from its size instead
+// of one MaxIterations for all: tiny areas stop early and large ones get the
+// iterations they need, evening out the per-area fit.
+type IterationScaling struct {
+	By      string  `json:"by"`      // ScaleByPopulation or ScaleByCells (empty disables)
+	PerUnit float64 `json:"perUnit"` // Iterations per individual or cell
+	Min     int     `json:"min"`     // Smallest budget of an area (0 for none)
+	Max     int     `json:"max"`     // Largest budget of an area (0 for none)
+}
+
+// check validates the scaling settings
+func (s IterationScaling) check() error {
+	switch s.By {
+	case "":
+		return nil
+	case ScaleByPopulation, ScaleByCells:
+	default:
+		return fmt.Errorf("invalid iterationScaling.by '%s'. Must be one of: %s, %s", s.By, ScaleByPopulation, ScaleByCells)
+	}
+	if !(s.PerUnit > 0) {
+		return fmt.Errorf("iterationScaling.perUnit must be positive")
+	}
+	if s.Min < 0 || s.Max < 0 || s.Max > 0 && s.Max < s.Min {
+		return fmt.Errorf("iterationScaling: min and max must not be negative, and max must be at least min")
+	}
+	return nil
+}
+
+// areaBudget returns config with MaxIterations scaled to the size of an area (see
+// IterationScaling) and a fixed WindowSize scaled in proportion, so the stagnation
+// window keeps its share of the budget; an adaptive window is capped by the scaled
+// budget already. Without scaling config is returned as it is.
+func areaBudget(config AnnealingConfig, constraint ConstraintData) AnnealingConfig {
+	s := config.IterationScaling
+	if s.By == "" {
+		return config
+	}
+	units := constraint.Total
+	if s.By == ScaleByCells {
+		units = 0
+		for _, v := range constraint.Values {
+			if v != 0 {
+				units++
+			}
+		}
+	}
+	budget := max(int(math.Round(s.PerUnit*units)), s.Min, 1)
+	if s.Max > 0 {
+		budget = min(budget, s.Max)
+	}
+	if config.WindowSize > 0 && config.MaxIterations > 0 {
+		config.WindowSize = max(int(math.Round(float64(config.WindowSize)*float64(budget)/float64(config.MaxIterations))), 1)
+	}
+	config.MaxIterations = budget
+	return config
+}
@@ -0,0 +1,120 @@
+package synthpop
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// loadProgressInterval is how often the readers report load progress
+const loadProgressInterval = 2 * time.Second
+
+// LoadProgress reports how far the loading of an input file has got
+type LoadProgress struct {
+	File    string
+	Rows    int           // Rows read so far
+	Bytes   int64         // Bytes read so far
+	Size    int64         // Size of the file
+	Elapsed time.Duration // Time since loading started
+}
+
+// MBPerSec returns the read rate so far
+func (p LoadProgress) MBPerSec() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Bytes) / 1e6 / p.Elapsed.Seconds()
+}
+
+func (p LoadProgress) String() string {
+	if p.Size <= 0 {
+		// A database query, whose size is not known in advance
+		return fmt.Sprintf("📥 Loading %s: %d rows, %.0f MB (%.1f MB/s)",
+			p.File, p.Rows, float64(p.Bytes)/1e6, p.MBPerSec())
+	}
+	return fmt.Sprintf("📥 Loading %s: %d rows, %.0f/%.0f MB (%.1f MB/s)",
+		filepath.Base(p.File), p.Rows, float64(p.Bytes)/1e6, float64(p.Size)/1e6, p.MBPerSec())
+}
+
+// loadTracker counts the bytes and rows read from one input file, reports them to
+// a progress channel every loadProgressInterval and stops the read once its
+// context is done
+type loadTracker struct {
+	ctx   context.Context
+	file  string
+	size  int64
+	start time.Time
+	bytes atomic.Int64
+	rows  atomic.Int64
+	stop  chan struct{}
+}
+
+// newLoadTracker starts tracking the load of file. progress may be nil; reports are
+// dropped rather than block loading when nobody is receiving.
+func newLoadTracker(ctx context.Context, file string, size int64, progress chan<- LoadProgress) *loadTracker {
+	t := &loadTracker{ctx: ctx, file: file, size: size, start: time.Now(), stop: make(chan struct{})}
+	if progress == nil {
+		return t
+	}
+	go func() {
+		ticker := time.NewTicker(loadProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				select {
+				case progress <- t.progress():
+				default:
+				}
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+	return t
+}
+
+func (t *loadTracker) progress() LoadProgress {
+	return LoadProgress{
+		File:    t.file,
+		Rows:    int(t.rows.Load()),
+		Bytes:   t.bytes.Load(),
+		Size:    t.size,
+		Elapsed: time.Since(t.start),
+	}
+}
+
+// reader wraps r to count the bytes read and fail once the context is done
+func (t *loadTracker) reader(r io.Reader) io.Reader {
+	return &trackedReader{r: r, t: t}
+}
+
+// err returns the cancellation error once the context is done, or nil
+func (t *loadTracker) err() error {
+	if err := t.ctx.Err(); err != nil {
+		return fmt.Errorf("loading %s stopped after %d rows: %w", t.file, t.rows.Load(), context.Cause(t.ctx))
+	}
+	return nil
+}
+
+// finish stops the progress reports
+func (t *loadTracker) finish() {
+	close(t.stop)
+}
+
+type trackedReader struct {
+	r io.Reader
+	t *loadTracker
+}
+
+func (r *trackedReader) Read(p []byte) (int, error) {
+	if err := r.t.err(); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(p)
+	r.t.bytes.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,239 @@
+package synthpop
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"time"
+)
+
+// defaultManifestFile is written next to the validate file unless configured
+const defaultManifestFile = "run_manifest.json"
+
+// RunManifest records what a run did so its results can be audited and reproduced:
+// the resolved configs, the inputs by hash, the seed, the build and a summary of
+// the fit of every area
+type RunManifest struct {
+	RunName          string           `json:"runName"`
+	StartedAt        time.Time        `json:"startedAt"`
+	FinishedAt       time.Time        `json:"finishedAt"`
+	WallClockSeconds float64          `json:"wallClockSeconds"`
+	QualityGate      string           `json:"qualityGate,omitempty"` // "passed" or the failure
+	Seed             int64            `json:"seed"`                  // Master seed; set randomSeed to it to reproduce the run
+	ConfigHash       string           `json:"configHash"`            // SHA-256 of the two configs as recorded here
+	Build            BuildInfo        `json:"build"`
+	PopulationConfig PopulationConfig `json:"populationConfig"`
+	AnnealingConfig  AnnealingConfig  `json:"annealingConfig"`
+	Inputs           []InputFile      `json:"inputs"`
+	Areas            FitnessSummary   `json:"areas"`
+}
+
+// BuildInfo identifies the program that ran
+type BuildInfo struct {
+	GoVersion   string `json:"goVersion"`
+	Module      string `json:"module"`
+	Version     string `json:"version"`
+	VCSRevision string `json:"vcsRevision,omitempty"`
+	VCSTime     string `json:"vcsTime,omitempty"`
+	VCSModified bool   `json:"vcsModified,omitempty"` // Built from a tree with uncommitted changes
+}
+
+// InputFile is an input of the run with its content hash
+type InputFile struct {
+	Role   string `json:"role"` // Config entry the file was named by, e.g. "constraints.file"
+	Path   string `json:"path"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// FitnessSummary summarises the fitness of the synthesized areas
+type FitnessSummary struct {
+	Synthesized int     `json:"synthesized"`
+	Failed      int     `json:"failed"` // Areas that could not be synthesized
+	Mean        float64 `json:"meanFitness"`
+	Median      float64 `json:"medianFitness"`
+	Max         float64 `json:"maxFitness"`
+	WorstArea   string  `json:"worstArea,omitempty"`
+}
+
+// manifestPath returns the configured manifest path or the default next to the
+// validate file
+func manifestPath(popConfig PopulationConfig) string {
+	if popConfig.Output.ManifestFile != "" {
+		return popConfig.Output.ManifestFile
+	}
+	return filepath.Join(filepath.Dir(popConfig.Validate.File), defaultManifestFile)
+}
+
+// currentBuild reads the build information embedded by the Go toolchain
+func currentBuild() BuildInfo {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return BuildInfo{Version: "unknown"}
+	}
+	build := BuildInfo{GoVersion: info.GoVersion, Module: info.Main.Path, Version: info.Main.Version}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			build.VCSRevision = setting.Value
+		case "vcs.time":
+			build.VCSTime = setting.Value
+		case "vcs.modified":
+			build.VCSModified = setting.Value == "true"
+		}
+	}
+	return build
+}
+
+// hashInputs hashes the input files named in the config
+func hashInputs(popConfig PopulationConfig) ([]InputFile, error) {
+	var inputs []InputFile
+	files := []struct{ role, path string }{
+		{"constraints.file", popConfig.Constraints.File},
+		{"microdata.file", popConfig.Microdata.File},
+		{"microdata.attributesFile", popConfig.Microdata.AttributesFile},
+		{"microdata.regionLookupFile", popConfig.Microdata.RegionLookupFile},
+		{"households.file", popConfig.Households.File},
+		{"households.constraintsFile", popConfig.Households.ConstraintsFile},
+		{"boundaries.file", popConfig.Boundaries.File},
+		{"adjacency.file", popConfig.Adjacency.File},
+		{"parent.constraintsFile", popConfig.Parent.ConstraintsFile},
+		{"parent.lookupFile", popConfig.Parent.LookupFile},
+		{"seedPopulationFile", popConfig.SeedPopulationFile},
+	}
+	for i, t := range popConfig.Constraints.Tables {
+		files = append(files, struct{ role, path string }{fmt.Sprintf("constraints.tables[%d].file", i), t.File})
+	}
+	for _, in := range files {
+		if in.path == "" {
+			continue
+		}
+		file, err := os.Open(in.path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot hash %s: %w", in.role, err)
+		}
+		h := sha256.New()
+		n, err := io.Copy(h, file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cannot hash %s: %w", in.role, err)
+		}
+		inputs = append(inputs, InputFile{Role: in.role, Path: in.path, Bytes: n, SHA256: hex.EncodeToString(h.Sum(nil))})
+	}
+	return inputs, nil
+}
+
+// manifestBuilder collects the manifest while the run progresses. The inputs are
+// hashed in the background from the start of the run, so large inputs do not add
+// to its wall-clock time.
+type manifestBuilder struct {
+	manifest RunManifest
+	fitness  []float64
+	inputs   chan manifestInputs
+}
+
+type manifestInputs struct {
+	files []InputFile
+	err   error
+}
+
+// newManifestBuilder starts the manifest of a run and hashes its inputs
+func newManifestBuilder(popConfig PopulationConfig, config AnnealingConfig, seed int64) *manifestBuilder {
+	b := &manifestBuilder{
+		manifest: RunManifest{
+			RunName:          popConfig.RunName,
+			StartedAt:        time.Now(),
+			Seed:             seed,
+			Build:            currentBuild(),
+			PopulationConfig: popConfig,
+			AnnealingConfig:  config,
+		},
+		inputs: make(chan manifestInputs, 1),
+	}
+	go func() {
+		files, err := hashInputs(popConfig)
+		b.inputs <- manifestInputs{files, err}
+	}()
+	return b
+}
+
+// add records the fitness of a synthesized area
+func (b *manifestBuilder) add(res Result) {
+	b.fitness = append(b.fitness, res.Fitness)
+	if len(b.fitness) == 1 || res.Fitness > b.manifest.Areas.Max {
+		b.manifest.Areas.Max, b.manifest.Areas.WorstArea = res.Fitness, res.Area
+	}
+}
+
+// fail records an area that could not be synthesized
+func (b *manifestBuilder) fail() {
+	b.manifest.Areas.Failed++
+}
+
+// write completes the manifest with the quality gate verdict (nil when it passed
+// or there is none) and writes it to path
+func (b *manifestBuilder) write(path string, gateErr error, gated bool, key []byte, retry retryPolicy) error {
+	m := &b.manifest
+	m.FinishedAt = time.Now()
+	m.WallClockSeconds = m.FinishedAt.Sub(m.StartedAt).Seconds()
+	switch {
+	case gateErr != nil:
+		m.QualityGate = gateErr.Error()
+	case gated:
+		m.QualityGate = "passed"
+	}
+
+	m.Areas.Synthesized = len(b.fitness)
+	if n := len(b.fitness); n > 0 {
+		sort.Float64s(b.fitness)
+		sum := 0.0
+		for _, f := range b.fitness {
+			sum += f
+		}
+		m.Areas.Mean = sum / float64(n)
+		m.Areas.Median = (b.fitness[(n-1)/2] + b.fitness[n/2]) / 2
+	}
+	for _, v := range []*float64{&m.Areas.Mean, &m.Areas.Median, &m.Areas.Max} {
+		if math.IsInf(*v, 0) || math.IsNaN(*v) {
+			*v = -1 // Not representable in JSON
+		}
+	}
+
+	in := <-b.inputs
+	if in.err != nil {
+		return in.err
+	}
+	m.Inputs = in.files
+
+	configs, err := json.Marshal([]any{m.PopulationConfig, m.AnnealingConfig})
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(configs)
+	m.ConfigHash = hex.EncodeToString(sum[:])
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	out, err := createOutput(path, key, retry)
+	if err != nil {
+		return fmt.Errorf("cannot create run manifest: %w", err)
+	}
+	if _, err := out.Write(append(data, '\n')); err != nil {
+		out.Close()
+		return fmt.Errorf("error writing run manifest: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("error writing run manifest: %w", err)
+	}
+	Printf("🧾 Run manifest written to %s\n", path)
+	return nil
+}
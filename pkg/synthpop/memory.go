@@ -0,0 +1,41 @@
+package synthpop
+
+import "math"
+
+// Approximate sizes in bytes used by estimateRunMemory
+const (
+	sliceHeaderBytes  = 24
+	stringHeaderBytes = 16
+	float64Bytes      = 8
+	intBytes          = 8
+)
+
+// estimateRunMemory returns a rough estimate of the memory a run needs in bytes:
+// the inputs, the scratch buffers of every worker sized for the largest area and
+// the results queued for the writer. It ignores the optional outputs and is meant
+// to warn before a run on a shared server, not to size it exactly.
+func estimateRunMemory(constraints []ConstraintData, microData []MicroData, numWorkers int, config AnnealingConfig) uint64 {
+	var bytes, largest float64
+	for _, constraint := range constraints {
+		bytes += float64(stringHeaderBytes + len(constraint.ID) + sliceHeaderBytes + len(constraint.Values)*float64Bytes)
+		largest = math.Max(largest, constraint.Total)
+	}
+	for _, md := range microData {
+		bytes += float64(stringHeaderBytes + len(md.ID) + sliceHeaderBytes + len(md.Values)*float64Bytes)
+	}
+
+	variables := 0
+	if len(microData) > 0 {
+		variables = len(microData[0].Values)
+	}
+	window := float64(stagnationWindow(config, largest))
+	perWorker := float64(len(microData))*intBytes + // Valid pool
+		2*largest*intBytes + // Current and best assignment
+		largest*stringHeaderBytes + // Sorted IDs of the hash tie-break
+		window*float64Bytes +
+		4*float64(variables)*float64Bytes
+	// Results carry the IDs of their area; up to two per worker wait for the writer
+	perResult := largest*stringHeaderBytes + 2*float64(variables)*float64Bytes
+	bytes += float64(numWorkers) * (perWorker + 2*perResult)
+	return uint64(bytes)
+}
@@ -0,0 +1,190 @@
+package synthpop
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// MetricExpression defines a distance metric in the expression language of
+// CompileExpression, for experimenting with goodness-of-fit measures without
+// changing the code. The metric is Final applied to the sum of Term over the
+// variables, e.g. the Euclidean distance is Term "(t - c) * (t - c)" with Final
+// "sqrt(s)".
+type MetricExpression struct {
+	// Term of one variable: c is its constraint, t its synthetic total and C and T
+	// the sums of the constraints and totals of the variables compared (those of
+	// the group in a variable group)
+	Term string `json:"term"`
+	// Optional transform of the sum s of the terms (default the sum itself)
+	Final string `json:"final"`
+}
+
+// metricTermHeader and metricFinalHeader are the variables of the two expressions
+var (
+	metricTermHeader  = []string{"c", "t", "C", "T"}
+	metricFinalHeader = []string{"s"}
+)
+
+var (
+	registeredMu      sync.RWMutex
+	registeredMetrics = make(map[string]DistanceFunc)
+)
+
+// RegisterDistance makes a Go distance function available under name, for use in
+// AnnealingConfig.Distance and variable groups like the built-in metrics. It is
+// meant to be called from an init function of a program embedding the package.
+// The function is shared by all workers, so it must be safe for concurrent use,
+// and it receives the constraints and synthetic totals of the same variables in the
+// same order.
+//
+// Parameters:
+//   - name: The metric name; built-in names cannot be replaced
+//   - fn: The distance, lower is a better fit and 0 a perfect one
+//
+// Returns:
+//   - error: An empty or taken name, or a nil function
+func RegisterDistance(name string, fn DistanceFunc) error {
+	if name == "" || fn == nil {
+		return fmt.Errorf("a registered distance needs a name and a function")
+	}
+	if _, ok := metricByName[name]; ok {
+		return fmt.Errorf("distance '%s' is built in", name)
+	}
+	registeredMu.Lock()
+	defer registeredMu.Unlock()
+	if _, ok := registeredMetrics[name]; ok {
+		return fmt.Errorf("distance '%s' is already registered", name)
+	}
+	registeredMetrics[name] = fn
+	return nil
+}
+
+// registeredDistance returns the function registered under name
+func registeredDistance(name string) (DistanceFunc, bool) {
+	registeredMu.RLock()
+	defer registeredMu.RUnlock()
+	fn, ok := registeredMetrics[name]
+	return fn, ok
+}
+
+// metricNames lists the metrics a config can name: the built-in ones, the
+// registered ones and those defined in its Metrics
+func metricNames(config AnnealingConfig) []string {
+	names := slices.Clone(ValidMetrics)
+	var extra []string
+	registeredMu.RLock()
+	for name := range registeredMetrics {
+		extra = append(extra, name)
+	}
+	registeredMu.RUnlock()
+	for name := range config.Metrics {
+		extra = append(extra, name)
+	}
+	sort.Strings(extra)
+	return append(names, extra...)
+}
+
+// MetricNames lists the metrics config can name in Distance and the variable
+// groups: the built-in ones, those registered with RegisterDistance and those
+// defined in its Metrics.
+func MetricNames(config AnnealingConfig) []string {
+	return metricNames(config)
+}
+
+// Metric returns the distance function called name, built in, registered or
+// defined in config.Metrics. Expression metrics reuse a buffer, so the function
+// must not be shared between goroutines.
+//
+// Parameters:
+//   - config: The annealing config holding the expression metrics
+//   - name: The metric name
+//
+// Returns:
+//   - DistanceFunc: The unweighted distance
+//   - error: An unknown name or an invalid expression
+func Metric(config AnnealingConfig, name string) (DistanceFunc, error) {
+	return resolveMetric(config, name, nil)
+}
+
+// checkMetrics checks the metric names and compiles the expressions of config
+func checkMetrics(config AnnealingConfig) error {
+	for name, m := range config.Metrics {
+		if _, ok := metricByName[name]; ok {
+			return fmt.Errorf("metrics: '%s' is a built-in distance", name)
+		}
+		if _, ok := registeredDistance(name); ok {
+			return fmt.Errorf("metrics: '%s' is a registered distance", name)
+		}
+		if _, err := compileMetricExpression(m, nil); err != nil {
+			return fmt.Errorf("metrics: '%s': %w", name, err)
+		}
+	}
+	return nil
+}
+
+// resolveMetric returns the metric called name, scaled per column by weights when
+// they are given (nil for none)
+func resolveMetric(config AnnealingConfig, name string, weights []float64) (DistanceFunc, error) {
+	if m, ok := config.Metrics[name]; ok {
+		return compileMetricExpression(m, weights)
+	}
+	if _, ok := metricByName[name]; ok {
+		if weights != nil {
+			return weightedDistanceFunc(name, weights), nil
+		}
+		return distanceFunc(AnnealingConfig{Distance: name}), nil
+	}
+	if fn, ok := registeredDistance(name); ok {
+		if weights != nil {
+			return nil, fmt.Errorf("variableWeights cannot scale the registered distance '%s'", name)
+		}
+		return fn, nil
+	}
+	return nil, fmt.Errorf("invalid distance metric '%s'. Must be one of: %v", name, metricNames(config))
+}
+
+// compileMetricExpression compiles an expression metric, with every term scaled by
+// its column's weight when weights are given. The function reuses a buffer, so it
+// must not be shared between goroutines.
+func compileMetricExpression(m MetricExpression, weights []float64) (DistanceFunc, error) {
+	if m.Term == "" {
+		return nil, fmt.Errorf("term is required")
+	}
+	term, err := CompileExpression(m.Term, metricTermHeader)
+	if err != nil {
+		return nil, err
+	}
+	var final *Expression
+	if m.Final != "" {
+		if final, err = CompileExpression(m.Final, metricFinalHeader); err != nil {
+			return nil, err
+		}
+	}
+
+	vars := make([]float64, len(metricTermHeader))
+	sum := make([]float64, len(metricFinalHeader))
+	return func(constraints, testData []float64) float64 {
+		sumC, sumT := 0.0, 0.0
+		for i := range constraints {
+			sumC += constraints[i]
+			sumT += testData[i]
+		}
+		vars[2], vars[3] = sumC, sumT
+		s := 0.0
+		for i := range constraints {
+			vars[0], vars[1] = constraints[i], testData[i]
+			v := term.Eval(vars)
+			if weights != nil {
+				v *= weights[i]
+			}
+			s += v
+		}
+		if final == nil {
+			return s
+		}
+		sum[0] = s
+		return final.Eval(sum)
+	}, nil
+}
@@ -0,0 +1,223 @@
+package synthpop
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+// Spatial autocorrelation of fitness: Moran's I of the per-area fitness over the
+// neighbour pairs of the adjacency file, with row-standardised weights (the default
+// of spdep and PySAL). A significantly positive I means poorly fitted areas sit next
+// to each other, which usually points at a regional pattern the microdata cannot
+// reproduce rather than at annealing noise.
+
+// moranSignificance is the two-sided p-value below which clustering is reported
+const moranSignificance = 0.05
+
+// MoranResult is the Moran's I diagnostic of a run. The variance, z-score and
+// p-value use the normality assumption.
+type MoranResult struct {
+	Areas     int     `json:"areas"`     // Areas with at least one synthesized neighbour
+	Islands   int     `json:"islands"`   // Synthesized areas left out for having no neighbour
+	Links     int     `json:"links"`     // Neighbour pairs between the included areas
+	I         float64 `json:"moransI"`   // Moran's I of fitness
+	Expected  float64 `json:"expected"`  // E[I] = -1/(n-1)
+	Variance  float64 `json:"variance"`  // Var[I] under normality
+	Z         float64 `json:"z"`         // (I - E[I]) / sqrt(Var[I])
+	P         float64 `json:"p"`         // Two-sided p-value of Z
+	Clustered bool    `json:"clustered"` // Poor fit clusters spatially (I > E[I] and P < 0.05)
+}
+
+// moranDiagnostic collects the fitness of every area during a run
+type moranDiagnostic struct {
+	neighbours map[string][]string
+	fitness    map[string]float64
+}
+
+// newMoranDiagnostic loads the adjacency file, or returns nil when none is configured
+func newMoranDiagnostic(popConfig PopulationConfig) (*moranDiagnostic, error) {
+	if popConfig.Adjacency.File == "" {
+		if popConfig.Adjacency.ReportFile != "" {
+			return nil, fmt.Errorf("adjacency.reportFile needs adjacency.file")
+		}
+		return nil, nil
+	}
+	neighbours, err := ReadAdjacencyCSV(popConfig.Adjacency.File)
+	if err != nil {
+		return nil, err
+	}
+	return &moranDiagnostic{neighbours: neighbours, fitness: make(map[string]float64)}, nil
+}
+
+// ReadAdjacencyCSV reads neighbouring area pairs from a CSV with a header row and
+// the two area codes in its first two columns. Pairs are symmetric, so listing
+// each pair once is enough; self-pairs and repeats are ignored.
+//
+// Parameters:
+//   - filename: Path to the adjacency CSV
+//
+// Returns:
+//   - map[string][]string: The neighbours of every listed area
+//   - error: Any error reading or parsing the file
+func ReadAdjacencyCSV(filename string) (map[string][]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open adjacency file: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	if _, err := reader.Read(); err != nil {
+		return nil, fmt.Errorf("cannot read adjacency header: %w", err)
+	}
+
+	seen := make(map[[2]string]bool)
+	neighbours := make(map[string][]string)
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("adjacency file line %d: %w", line, err)
+		}
+		if len(record) < 2 {
+			return nil, fmt.Errorf("adjacency file line %d: needs an area and a neighbour", line)
+		}
+		a, b := record[0], record[1]
+		if a == b || seen[[2]string{a, b}] {
+			continue
+		}
+		seen[[2]string{a, b}], seen[[2]string{b, a}] = true, true
+		neighbours[a] = append(neighbours[a], b)
+		neighbours[b] = append(neighbours[b], a)
+	}
+	return neighbours, nil
+}
+
+// add records the fitness of one area
+func (m *moranDiagnostic) add(res Result) {
+	m.fitness[res.Area] = res.Fitness
+}
+
+// MoransI computes Moran's I of values over the neighbour pairs. Areas without a
+// value are dropped, and so are the areas left without neighbours (islands).
+//
+// Parameters:
+//   - values: The value of every area
+//   - neighbours: The neighbours of every area, as read by ReadAdjacencyCSV
+//
+// Returns:
+//   - MoranResult: The statistic and its significance
+//   - error: If fewer than three areas are linked or their values are all equal
+func MoransI(values map[string]float64, neighbours map[string][]string) (MoranResult, error) {
+	// Included areas in a stable order, with their linked neighbours
+	var areas []string
+	links := make(map[string][]string)
+	for area := range values {
+		for _, neighbour := range neighbours[area] {
+			if _, ok := values[neighbour]; ok {
+				links[area] = append(links[area], neighbour)
+			}
+		}
+		if len(links[area]) > 0 {
+			areas = append(areas, area)
+		}
+	}
+	sort.Strings(areas)
+	result := MoranResult{Areas: len(areas), Islands: len(values) - len(areas)}
+	n := float64(len(areas))
+	if len(areas) < 3 {
+		return result, fmt.Errorf("Moran's I needs at least 3 neighbouring areas, got %d", len(areas))
+	}
+
+	mean := 0.0
+	for _, area := range areas {
+		mean += values[area]
+	}
+	mean /= n
+	deviation := make(map[string]float64, len(areas))
+	m2 := 0.0
+	for _, area := range areas {
+		z := values[area] - mean
+		deviation[area] = z
+		m2 += z * z
+	}
+	if m2 < EPSILON {
+		return result, fmt.Errorf("Moran's I is undefined when every area has the same fitness")
+	}
+
+	// Row-standardised weights w_ij = 1/|N(i)|, so S0 = n
+	cross, s1, s2 := 0.0, 0.0, 0.0
+	colSums := make(map[string]float64, len(areas))
+	for _, area := range areas {
+		w := 1 / float64(len(links[area]))
+		for _, neighbour := range links[area] {
+			cross += w * deviation[area] * deviation[neighbour]
+			colSums[neighbour] += w
+			// (w_ij + w_ji)^2, with w_ji = 1/|N(j)| since links are symmetric
+			wji := 1 / float64(len(links[neighbour]))
+			s1 += (w + wji) * (w + wji)
+		}
+		result.Links += len(links[area])
+	}
+	result.Links /= 2
+	s0 := n
+	s1 /= 2
+	for _, area := range areas {
+		s2 += (1 + colSums[area]) * (1 + colSums[area]) // Every row sums to 1
+	}
+
+	result.I = n / s0 * cross / m2
+	result.Expected = -1 / (n - 1)
+	result.Variance = (n*n*s1-n*s2+3*s0*s0)/((n*n-1)*s0*s0) - result.Expected*result.Expected
+	if result.Variance > 0 {
+		result.Z = (result.I - result.Expected) / math.Sqrt(result.Variance)
+		result.P = math.Erfc(math.Abs(result.Z) / math.Sqrt2)
+	} else {
+		result.P = 1
+	}
+	result.Clustered = result.I > result.Expected && result.P < moranSignificance
+	return result, nil
+}
+
+// report computes Moran's I of the collected fitness, prints it and writes the
+// optional JSON report
+func (m *moranDiagnostic) report(path string, key []byte, retry retryPolicy) error {
+	result, err := MoransI(m.fitness, m.neighbours)
+	if err != nil {
+		Printf("⚠️ Spatial autocorrelation skipped: %v\n", err)
+		return nil
+	}
+	Printf("🗺️ Moran's I of fitness: %.4f (expected %.4f, z %.2f, p %.4g) over %d areas and %d neighbour pairs\n",
+		result.I, result.Expected, result.Z, result.P, result.Areas, result.Links)
+	if result.Islands > 0 {
+		Printf("   %d areas without synthesized neighbours were left out\n", result.Islands)
+	}
+	if result.Clustered {
+		Printf("   Poor fit clusters spatially: check the constraints of the worst areas and their neighbours\n")
+	} else {
+		Printf("   No significant spatial clustering of fitness\n")
+	}
+
+	if path == "" {
+		return nil
+	}
+	out, err := createOutput(path, key, retry)
+	if err != nil {
+		return fmt.Errorf("cannot create adjacency report: %w", err)
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		out.Close()
+		return fmt.Errorf("error writing adjacency report: %w", err)
+	}
+	return out.Close()
+}
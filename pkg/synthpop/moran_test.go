@@ -0,0 +1,101 @@
+package synthpop
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestMoransI checks the statistic against values worked out by hand
+func TestMoransI(t *testing.T) {
+	// A path A-B-C-D with fitness rising along it. The deviations from the mean 2.5
+	// are -1.5, -0.5, 0.5 and 1.5, so m2 = 5, and with row-standardised weights the
+	// cross products sum to 0.75 + 0.25 + 0.25 + 0.75 = 2, so I = 2/5. Pairs weigh
+	// (1 + 0.5)² at the ends and (0.5 + 0.5)² in the middle, so S1 = 11/2; the
+	// columns sum to 0.5, 1.5, 1.5 and 0.5, so S2 = 17. With n = S0 = 4,
+	// Var[I] = (16·S1 - 4·S2 + 3·16) / (15·16) - 1/9 = 31/180.
+	path := map[string][]string{"A": {"B"}, "B": {"A", "C"}, "C": {"B", "D"}, "D": {"C"}}
+	got, err := MoransI(map[string]float64{"A": 1, "B": 2, "C": 3, "D": 4}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := (0.4 + 1.0/3) / math.Sqrt(31.0/180)
+	want := MoranResult{Areas: 4, Links: 3, I: 0.4, Expected: -1.0 / 3, Variance: 31.0 / 180, Z: z,
+		P: math.Erfc(z / math.Sqrt2)}
+	checkMoran(t, "path", got, want)
+	if math.Abs(got.P-0.0772) > 1e-4 || got.Clustered {
+		t.Errorf("p-value %v, clustered %v: want about 0.0772, not significant", got.P, got.Clustered)
+	}
+
+	// A cycle A-B-C-D-A alternating between good and poor fit: every neighbour
+	// deviates the other way, so I = -1. Each pair weighs (0.5 + 0.5)², so S1 = 4,
+	// every column sums to 1, so S2 = 16, and Var[I] = (64 - 64 + 48) / 240 - 1/9.
+	cycle := map[string][]string{"A": {"B", "D"}, "B": {"A", "C"}, "C": {"B", "D"}, "D": {"C", "A"}}
+	got, err = MoransI(map[string]float64{"A": 1, "B": 0, "C": 1, "D": 0}, cycle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	variance := 0.2 - 1.0/9
+	z = (-1 + 1.0/3) / math.Sqrt(variance)
+	checkMoran(t, "cycle", got, MoranResult{Areas: 4, Links: 4, I: -1, Expected: -1.0 / 3, Variance: variance, Z: z,
+		P: math.Erfc(math.Abs(z) / math.Sqrt2)})
+	if got.Clustered {
+		t.Error("dispersed fitness reported as clustered")
+	}
+
+	// An island and a neighbour without fitness leave the path's statistic as it was
+	withIsland := map[string][]string{"A": {"B", "X"}, "B": {"A", "C"}, "C": {"B", "D"}, "D": {"C"}, "X": {"A"}}
+	got, err = MoransI(map[string]float64{"A": 1, "B": 2, "C": 3, "D": 4, "E": 9}, withIsland)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Areas != 4 || got.Islands != 1 || got.Links != 3 || math.Abs(got.I-0.4) > 1e-12 {
+		t.Errorf("with an island: %+v", got)
+	}
+
+	for name, values := range map[string]map[string]float64{
+		"too few areas": {"A": 1, "B": 2},
+		"equal fitness": {"A": 1, "B": 1, "C": 1},
+	} {
+		if _, err := MoransI(values, path); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func checkMoran(t *testing.T, name string, got, want MoranResult) {
+	t.Helper()
+	near := func(a, b float64) bool { return math.Abs(a-b) <= 1e-12 }
+	if got.Areas != want.Areas || got.Islands != want.Islands || got.Links != want.Links ||
+		!near(got.I, want.I) || !near(got.Expected, want.Expected) || !near(got.Variance, want.Variance) ||
+		!near(got.Z, want.Z) || !near(got.P, want.P) {
+		t.Errorf("%s: %+v\nwant %+v", name, got, want)
+	}
+}
+
+func TestReadAdjacencyCSV(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "adjacency.csv")
+	// Pairs in both directions, repeats and self-pairs count once or not at all
+	data := "area,neighbour,length\nA,B,10\nB,A\nB,C\nC,C\nA,B,3\nC,D\n"
+	if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadAdjacencyCSV(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"A": {"B"}, "B": {"A", "C"}, "C": {"B", "D"}, "D": {"C"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("neighbours %v, want %v", got, want)
+	}
+
+	if err := os.WriteFile(file, []byte("area,neighbour\nA\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadAdjacencyCSV(file); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("error %v, want the short line reported", err)
+	}
+}
@@ -0,0 +1,286 @@
+package synthpop
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Nested geographies: the areas (e.g. LSOAs) nest in parent areas (e.g. MSOAs) with
+// constraints of their own. The parent marginals are fitted hierarchically: the
+// variables only the parents constrain are apportioned to their areas by population
+// share and fitted as further area constraints, then the synthetic populations are
+// summed per parent and compared with the parent constraints, so fit is reported at
+// both levels. Variables constrained at both levels keep the area values, the finer
+// of the two.
+
+// parentGeography holds the parent constraints and the parent of every area
+type parentGeography struct {
+	constraints map[string]ConstraintData // By parent ID
+	order       []string                  // Parent IDs in file order
+	header      []string
+	parentOf    map[string]string
+}
+
+// loadParentGeography reads Parent.ConstraintsFile and Parent.LookupFile, or
+// returns nil when no parent geography is configured
+func loadParentGeography(ctx context.Context, popConfig PopulationConfig) (*parentGeography, error) {
+	if popConfig.Parent.ConstraintsFile == "" {
+		return nil, nil
+	}
+	constraints, header, err := ReadConstraints(ctx, popConfig.Parent.ConstraintsFile, "", nil)
+	if err != nil {
+		return nil, fmt.Errorf("parent constraints: %w", err)
+	}
+	parentOf, err := ReadParentLookupCSV(popConfig.Parent.LookupFile)
+	if err != nil {
+		return nil, err
+	}
+	g := &parentGeography{constraints: make(map[string]ConstraintData, len(constraints)), header: header, parentOf: parentOf}
+	for _, c := range constraints {
+		if _, ok := g.constraints[c.ID]; ok {
+			return nil, fmt.Errorf("parent constraints: parent %s is listed twice", c.ID)
+		}
+		g.constraints[c.ID] = c
+		g.order = append(g.order, c.ID)
+	}
+	return g, nil
+}
+
+// ReadParentLookupCSV reads the parent of every area from a CSV with a header row,
+// the area ID in its first column and the parent ID in its second
+//
+// Parameters:
+//   - filename: Path to the lookup CSV, optionally compressed (.gz or .zst)
+//
+// Returns:
+//   - map[string]string: The parent ID of every listed area
+//   - error: Any error reading the file, or an area listed with two parents
+func ReadParentLookupCSV(filename string) (map[string]string, error) {
+	return readAreaLookupCSV(filename, "parent")
+}
+
+// readAreaLookupCSV reads a CSV with a header row, an area ID in its first column
+// and the area's parent, region or other grouping (what, in messages) in its second
+func readAreaLookupCSV(filename, what string) (map[string]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open %s lookup file: %w", what, err)
+	}
+	defer file.Close()
+	in, err := decompress(filename, file)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(in)
+	reader.FieldsPerRecord = -1
+	if _, err := reader.Read(); err != nil {
+		return nil, fmt.Errorf("cannot read %s lookup header: %w", what, err)
+	}
+	lookup := make(map[string]string)
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s lookup line %d: %w", what, line, err)
+		}
+		if len(record) < 2 {
+			return nil, fmt.Errorf("%s lookup line %d: needs an area and its %s", what, line, what)
+		}
+		if group, ok := lookup[record[0]]; ok && group != record[1] {
+			return nil, fmt.Errorf("%s lookup line %d: area %s is in both %s and %s", what, line, record[0], group, record[1])
+		}
+		lookup[record[0]] = record[1]
+	}
+	return lookup, nil
+}
+
+// nestConstraints returns copies of the area constraints extended with the
+// variables only the parents constrain, each area taking the share of its parent's
+// value that its total is of the parent total. Every area must have a parent.
+func (g *parentGeography) nestConstraints(constraints []ConstraintData, header []string) ([]ConstraintData, []string, error) {
+	var columns []int
+	for i, name := range g.header {
+		if !slices.Contains(header, name) {
+			columns = append(columns, i)
+		}
+	}
+	nested := make([]ConstraintData, len(constraints))
+	parents := make(map[string]bool)
+	for i, c := range constraints {
+		parentID, ok := g.parentOf[c.ID]
+		if !ok {
+			return nil, nil, fmt.Errorf("area %s has no parent in the parent lookup", c.ID)
+		}
+		parent, ok := g.constraints[parentID]
+		if !ok {
+			return nil, nil, fmt.Errorf("parent %s of area %s is not in the parent constraints", parentID, c.ID)
+		}
+		parents[parentID] = true
+		share := 0.0
+		if parent.Total > 0 {
+			share = c.Total / parent.Total
+		}
+		values := make([]float64, 0, len(c.Values)+len(columns))
+		values = append(values, c.Values...)
+		for _, col := range columns {
+			values = append(values, parent.Values[col]*share)
+		}
+		c.Values = values
+		nested[i] = c
+	}
+
+	header = slices.Clone(header)
+	for _, col := range columns {
+		header = append(header, g.header[col])
+	}
+	if len(columns) > 0 {
+		names := make([]string, len(columns))
+		for i, col := range columns {
+			names[i] = g.header[col]
+		}
+		Printf("🧭 Apportioned %d parent variables (%s) to %d areas in %d parents\n",
+			len(columns), strings.Join(names, ", "), len(nested), len(parents))
+	}
+	return nested, header, nil
+}
+
+// parentValidation sums the synthetic populations of the areas per parent and
+// compares them with the parent constraints
+type parentValidation struct {
+	geography *parentGeography
+	columns   []int    // Run column of every parent variable, -1 for one the run lacks
+	names     []string // Output name of every parent variable
+	totals    map[string][]float64
+	areas     map[string]int
+}
+
+// newParentValidation loads the parent geography, or returns nil when none is
+// configured
+//
+// Parameters:
+//   - ctx: Cancels the loading of the parent constraints
+//   - popConfig: The population configuration of the run
+//   - header: The variables of the run
+//   - outputHeader: Their output names (see Output.Rename)
+//
+// Returns:
+//   - *parentValidation: The empty sums, nil without a parent geography
+//   - error: Any error reading the parent constraints or lookup
+func newParentValidation(ctx context.Context, popConfig PopulationConfig, header, outputHeader []string) (*parentValidation, error) {
+	g, err := loadParentGeography(ctx, popConfig)
+	if g == nil || err != nil {
+		return nil, err
+	}
+	v := &parentValidation{geography: g, totals: make(map[string][]float64), areas: make(map[string]int)}
+	var skipped []string
+	for _, name := range g.header {
+		column := slices.Index(header, name)
+		v.columns = append(v.columns, column)
+		if column < 0 {
+			skipped = append(skipped, name)
+			v.names = append(v.names, name)
+		} else {
+			v.names = append(v.names, outputHeader[column])
+		}
+	}
+	if len(skipped) > 0 {
+		Printf("⚠️ Parent variables missing from the run are not validated: %s\n", strings.Join(skipped, ", "))
+	}
+	return v, nil
+}
+
+// add adds the synthetic totals of an area to its parent's
+func (v *parentValidation) add(res Result) {
+	parent, ok := v.geography.parentOf[res.Area]
+	if !ok {
+		return
+	}
+	totals := v.totals[parent]
+	if totals == nil {
+		totals = make([]float64, len(v.columns))
+		v.totals[parent] = totals
+	}
+	for i, column := range v.columns {
+		if column >= 0 {
+			totals[i] += res.Totals[column]
+		}
+	}
+	v.areas[parent]++
+}
+
+// report prints the fit at parent level and writes the optional validation file of
+// every parent with synthesized areas and variable: the number of areas summed,
+// the synthetic and constraint counts, the absolute and percentage errors
+func (v *parentValidation) report(path string, key []byte, retry retryPolicy) error {
+	var w *csv.Writer
+	var out *outputFile
+	if path != "" {
+		var err error
+		if out, err = createOutput(path, key, retry); err != nil {
+			return fmt.Errorf("cannot create parent validation file: %w", err)
+		}
+		w = csv.NewWriter(out)
+		w.Write([]string{"parent_id", "areas", "variable", "synthetic", "constraint", "absolute_error", "percentage_error"})
+	}
+
+	parents, tae, population := 0, 0.0, 0.0
+	worst, worstTAE := "", -1.0
+	for _, id := range v.geography.order {
+		totals, ok := v.totals[id]
+		if !ok {
+			continue
+		}
+		parent := v.geography.constraints[id]
+		parentTAE := 0.0
+		for i, column := range v.columns {
+			if column < 0 {
+				continue
+			}
+			absError := math.Abs(totals[i] - parent.Values[i])
+			parentTAE += absError
+			if w == nil {
+				continue
+			}
+			pctError := ""
+			if parent.Values[i] != 0 {
+				pctError = formatFloat(100 * absError / parent.Values[i])
+			}
+			w.Write([]string{id, strconv.Itoa(v.areas[id]), v.names[i], formatFloat(totals[i]),
+				formatFloat(parent.Values[i]), formatFloat(absError), pctError})
+		}
+		parents++
+		tae += parentTAE
+		population += parent.Total
+		if parentTAE > worstTAE {
+			worst, worstTAE = id, parentTAE
+		}
+	}
+
+	if parents > 0 {
+		sae := 0.0
+		if population > 0 {
+			sae = tae / population
+		}
+		Printf("🧭 Parent geography fit over %d parents: TAE %s, SAE %.4f, worst parent %s (TAE %s)\n",
+			parents, formatFloat(tae), sae, worst, formatFloat(worstTAE))
+	}
+	if out == nil {
+		return nil
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		out.Close()
+		return fmt.Errorf("error writing parent validation file: %w", err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,110 @@
+package synthpop
+
+import (
+	"context"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testNestedConfig returns a run over testdata/nested: three areas constraining sex,
+// in two parents that constrain employment as well, and twelve persons of every
+// combination of the two
+func testNestedConfig() PopulationConfig {
+	dir := filepath.Join("testdata", "nested")
+	var popConfig PopulationConfig
+	popConfig.Constraints.File = filepath.Join(dir, "areas.csv")
+	popConfig.Microdata.File = filepath.Join(dir, "persons.csv")
+	popConfig.Parent.ConstraintsFile = filepath.Join(dir, "parents.csv")
+	popConfig.Parent.LookupFile = filepath.Join(dir, "lookup.csv")
+	return popConfig
+}
+
+func TestNestedGeography(t *testing.T) {
+	quietConsole(t)
+	popConfig := testNestedConfig()
+	popConfig.Parent.ValidateFile = filepath.Join(t.TempDir(), "parents.csv")
+	in, err := NewController().Load(context.Background(), popConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The areas take their population's share of the parent employment, and keep
+	// their own counts of the sexes
+	if got := strings.Join(in.Header, ","); got != "male,female,employed,unemployed" {
+		t.Errorf("nested header %s", got)
+	}
+	want := []ConstraintData{
+		{ID: "A1", Values: []float64{2, 2, 2, 2}, Total: 4},
+		{ID: "A2", Values: []float64{3, 3, 3, 3}, Total: 6},
+		{ID: "A3", Values: []float64{1, 3, 2, 2}, Total: 4},
+	}
+	if !reflect.DeepEqual(in.Constraints, want) {
+		t.Errorf("nested constraints %+v, want %+v", in.Constraints, want)
+	}
+
+	// The synthetic populations of the children sum to their parent's constraints
+	results, err := SynthesizeAreas(in.Constraints, in.MicroData, in.Header, testConfig(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parents, err := newParentValidation(context.Background(), popConfig, in.Header, in.Header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sums := map[string][]float64{"P1": make([]float64, 4), "P2": make([]float64, 4)}
+	for i, res := range results {
+		checkPopulation(t, res, in.Constraints[i], in.MicroData)
+		parents.add(res)
+		for j, v := range res.Totals {
+			sums[parents.geography.parentOf[res.Area]][j] += v
+		}
+	}
+	for id, sum := range sums {
+		if parent := parents.geography.constraints[id]; !reflect.DeepEqual(sum, parent.Values) {
+			t.Errorf("areas of %s sum to %v, want %v", id, sum, parent.Values)
+		}
+	}
+
+	if err := parents.report(popConfig.Parent.ValidateFile, nil, retryPolicy{}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(popConfig.Parent.ValidateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1+2*4 {
+		t.Fatalf("%d rows in the parent validation file, want a header and 4 per parent", len(rows))
+	}
+	for _, row := range rows[1:] {
+		areas := map[string]string{"P1": "2", "P2": "1"}[row[0]]
+		if row[1] != areas || row[3] != row[4] || row[5] != "0" || row[6] != "0" {
+			t.Errorf("parent validation row %v, want %s areas fitting the parent exactly", row, areas)
+		}
+	}
+
+	// Every area needs a parent with constraints
+	lookup := filepath.Join(t.TempDir(), "lookup.csv")
+	for name, data := range map[string]string{
+		"no parent":         "area,parent\nA1,P1\nA2,P1\n",
+		"unknown parent":    "area,parent\nA1,P1\nA2,P1\nA3,P3\n",
+		"two parents":       "area,parent\nA1,P1\nA2,P1\nA3,P2\nA1,P2\n",
+		"no parent in line": "area,parent\nA1\n",
+	} {
+		if err := os.WriteFile(lookup, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		popConfig.Parent.LookupFile = lookup
+		if _, err := NewController().Load(context.Background(), popConfig); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package synthpop
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTPPasswordEnv names the environment variable holding the password of
+// notifications.smtp.username, kept out of the config and the run manifest
+const SMTPPasswordEnv = "GOSYNTHPOP_SMTP_PASSWORD"
+
+const (
+	defaultSMTPPort = 587
+	notifyTimeout   = 30 * time.Second
+)
+
+// NotificationConfig holds where the message announcing the end of a run is sent
+type NotificationConfig struct {
+	Webhook   string `json:"webhook"`   // URL receiving a POST of {"text": message} (Slack, Mattermost, Teams workflows)
+	ReportURL string `json:"reportURL"` // Link to the results put in the message, {run} replaced by the run name
+	SMTP      struct {
+		Host     string   `json:"host"`
+		Port     int      `json:"port"` // Default 587
+		From     string   `json:"from"`
+		To       []string `json:"to"`
+		Username string   `json:"username"` // Password in GOSYNTHPOP_SMTP_PASSWORD; empty sends without authentication
+	} `json:"smtp"`
+}
+
+// check validates the notification settings
+func (c NotificationConfig) check() error {
+	if c.Webhook != "" && !strings.HasPrefix(c.Webhook, "https://") && !strings.HasPrefix(c.Webhook, "http://") {
+		return fmt.Errorf("notifications.webhook must be an http or https URL")
+	}
+	s := c.SMTP
+	if s.Host != "" && (s.From == "" || len(s.To) == 0) {
+		return fmt.Errorf("notifications.smtp needs from and to with a host")
+	}
+	if s.Port < 0 {
+		return fmt.Errorf("notifications.smtp.port must not be negative")
+	}
+	return nil
+}
+
+// runNotifier sends a summary of a run when it finishes or fails, so an overnight
+// run does not go unnoticed until someone checks on it
+type runNotifier struct {
+	config    NotificationConfig
+	runName   string
+	startedAt time.Time
+}
+
+// newNotifier returns the notifier of a run, nil when no notification is configured
+func newNotifier(popConfig PopulationConfig) *runNotifier {
+	c := popConfig.Notifications
+	if c.Webhook == "" && c.SMTP.Host == "" {
+		return nil
+	}
+	return &runNotifier{config: c, runName: popConfig.RunName, startedAt: time.Now()}
+}
+
+// send announces the end of the run. A notification failure does not change the
+// outcome of the run: it is reported and ignored.
+//
+// Parameters:
+//   - manifest: The run's manifest builder, nil when the run failed before it started
+//   - runErr: Why the run failed, nil when it finished
+func (n *runNotifier) send(manifest *manifestBuilder, runErr error) {
+	if n == nil {
+		return
+	}
+	subject, text := n.message(manifest, runErr)
+	if n.config.Webhook != "" {
+		if err := n.postWebhook(text); err != nil {
+			Printf("⚠️ Webhook notification failed: %v\n", err)
+		}
+	}
+	if n.config.SMTP.Host != "" {
+		if err := n.sendMail(subject, text); err != nil {
+			Printf("⚠️ Email notification failed: %v\n", err)
+		}
+	}
+}
+
+// message returns the subject and text of the notification: the outcome and
+// runtime, the areas synthesized and failed, the worst fitness and the report link
+func (n *runNotifier) message(manifest *manifestBuilder, runErr error) (string, string) {
+	elapsed := time.Since(n.startedAt).Round(time.Second)
+	var subject, text strings.Builder
+	if runErr == nil {
+		fmt.Fprintf(&subject, "GoSynthPop run %s finished", n.runName)
+		fmt.Fprintf(&text, "✅ Run %s finished in %v\n", n.runName, elapsed)
+	} else {
+		fmt.Fprintf(&subject, "GoSynthPop run %s failed", n.runName)
+		fmt.Fprintf(&text, "❌ Run %s failed after %v: %v\n", n.runName, elapsed, runErr)
+	}
+	if manifest != nil {
+		areas := manifest.manifest.Areas
+		fmt.Fprintf(&text, "Areas: %d synthesized, %d failed\n", len(manifest.fitness), areas.Failed)
+		if len(manifest.fitness) > 0 {
+			fmt.Fprintf(&text, "Worst fitness: %s (area %s)\n", formatFloat(areas.Max), areas.WorstArea)
+		}
+	}
+	if n.config.ReportURL != "" {
+		fmt.Fprintf(&text, "Report: %s\n", strings.ReplaceAll(n.config.ReportURL, RunNamePlaceholder, n.runName))
+	}
+	return subject.String(), text.String()
+}
+
+func (n *runNotifier) postWebhook(text string) error {
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: notifyTimeout}
+	resp, err := client.Post(n.config.Webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		if message := strings.TrimSpace(string(message)); message != "" {
+			return fmt.Errorf("%s %s", resp.Status, message)
+		}
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// sendMail sends the notification by SMTP, upgrading to TLS when the server offers
+// STARTTLS
+func (n *runNotifier) sendMail(subject, text string) error {
+	s := n.config.SMTP
+	port := s.Port
+	if port == 0 {
+		port = defaultSMTPPort
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(s.Host, strconv.Itoa(port)), notifyTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(notifyTimeout))
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: s.Host}); err != nil {
+			return err
+		}
+	}
+	if s.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.Username, os.Getenv(SMTPPasswordEnv), s.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(s.From); err != nil {
+		return err
+	}
+	for _, to := range s.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s: %w", to, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s",
+		s.From, strings.Join(s.To, ", "), subject, time.Now().Format(time.RFC1123Z), strings.ReplaceAll(text, "\n", "\r\n"))
+	if err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,269 @@
+package synthpop
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSMTP is a mail server on 127.0.0.1 recording the commands and message of
+// every session. It answers 250 to everything but the commands named in reject,
+// and offers AUTH PLAIN but not STARTTLS.
+type fakeSMTP struct {
+	port     int
+	reject   map[string]string // Command prefix, e.g. "RCPT TO:<b@x>" -> reply
+	mu       sync.Mutex
+	commands []string
+	data     string
+}
+
+func startFakeSMTP(t *testing.T, reject map[string]string) *fakeSMTP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	f := &fakeSMTP{port: ln.Addr().(*net.TCPAddr).Port, reject: reject}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.session(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeSMTP) session(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(s string) { io.WriteString(conn, s+"\r\n") }
+	reply("220 localhost ESMTP fake")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.TrimRight(line, "\r\n")
+		f.mu.Lock()
+		f.commands = append(f.commands, command)
+		f.mu.Unlock()
+		if rejected, ok := f.rejected(command); ok {
+			reply(rejected)
+			continue
+		}
+		switch verb := strings.ToUpper(strings.Fields(command + " x")[0]); verb {
+		case "EHLO":
+			reply("250-localhost\r\n250-8BITMIME\r\n250 AUTH PLAIN")
+		case "AUTH":
+			reply("235 2.7.0 Authentication successful")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			f.mu.Lock()
+			f.data = data.String()
+			f.mu.Unlock()
+			if rejected, ok := f.rejected("."); ok {
+				reply(rejected)
+			} else {
+				reply("250 2.0.0 Ok: queued")
+			}
+		case "QUIT":
+			reply("221 2.0.0 Bye")
+			return
+		default:
+			reply("250 2.0.0 Ok")
+		}
+	}
+}
+
+func (f *fakeSMTP) rejected(command string) (string, bool) {
+	for prefix, reply := range f.reject {
+		if strings.HasPrefix(command, prefix) {
+			return reply, true
+		}
+	}
+	return "", false
+}
+
+func (f *fakeSMTP) received() ([]string, string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.commands...), f.data
+}
+
+func testNotifier(runName string) *runNotifier {
+	var c NotificationConfig
+	c.ReportURL = "https://reports.example/{run}/index.html"
+	c.SMTP.Host = "127.0.0.1"
+	c.SMTP.From = "synthpop@example.org"
+	c.SMTP.To = []string{"a@example.org", "b@example.org"}
+	return &runNotifier{config: c, runName: runName, startedAt: time.Now()}
+}
+
+func TestSendMail(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		reject   map[string]string
+		want     []string // Commands in order, from MAIL on
+		err      string
+	}{
+		{"plain", "", nil,
+			[]string{"MAIL FROM:<synthpop@example.org> BODY=8BITMIME", "RCPT TO:<a@example.org>", "RCPT TO:<b@example.org>", "DATA", "QUIT"}, ""},
+		{"authenticated", "synth", nil,
+			[]string{"AUTH PLAIN " + base64.StdEncoding.EncodeToString([]byte("\x00synth\x00hunter2")),
+				"MAIL FROM:<synthpop@example.org> BODY=8BITMIME", "RCPT TO:<a@example.org>", "RCPT TO:<b@example.org>", "DATA", "QUIT"}, ""},
+		{"sender rejected", "", map[string]string{"MAIL": "553 5.7.1 Sender rejected"},
+			[]string{"MAIL FROM:<synthpop@example.org> BODY=8BITMIME"}, "Sender rejected"},
+		{"recipient rejected", "", map[string]string{"RCPT TO:<b@": "550 5.1.1 No such user"},
+			[]string{"MAIL FROM:<synthpop@example.org> BODY=8BITMIME", "RCPT TO:<a@example.org>", "RCPT TO:<b@example.org>"},
+			"recipient b@example.org: 550"},
+		{"message rejected", "", map[string]string{".": "554 5.6.0 Message refused"},
+			[]string{"MAIL FROM:<synthpop@example.org> BODY=8BITMIME", "RCPT TO:<a@example.org>", "RCPT TO:<b@example.org>", "DATA"},
+			"Message refused"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startFakeSMTP(t, tt.reject)
+			t.Setenv(SMTPPasswordEnv, "hunter2")
+			n := testNotifier("calib")
+			n.config.SMTP.Port = server.port
+			n.config.SMTP.Username = tt.username
+
+			err := n.sendMail("GoSynthPop run calib finished", "✅ Run calib finished\nAreas: 3\n")
+			if tt.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("error %v, want one containing %q", err, tt.err)
+			}
+			commands, data := server.received()
+			if len(commands) == 0 || !strings.HasPrefix(commands[0], "EHLO ") {
+				t.Fatalf("session began with %q, want EHLO", commands)
+			}
+			if got := strings.Join(commands[1:], "\n"); got != strings.Join(tt.want, "\n") {
+				t.Errorf("commands after EHLO\n%s\nwant\n%s", got, strings.Join(tt.want, "\n"))
+			}
+			if tt.err != "" {
+				return
+			}
+			for _, want := range []string{
+				"From: synthpop@example.org\r\n",
+				"To: a@example.org, b@example.org\r\n",
+				"Subject: GoSynthPop run calib finished\r\n",
+				"Content-Type: text/plain; charset=utf-8\r\n\r\n✅ Run calib finished\r\nAreas: 3\r\n",
+			} {
+				if !strings.Contains(data, want) {
+					t.Errorf("message lacks %q:\n%s", want, data)
+				}
+			}
+		})
+	}
+
+	// Nothing listening on the port
+	n := testNotifier("calib")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.config.SMTP.Port = ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	if err := n.sendMail("s", "t"); err == nil {
+		t.Error("mail sent with no server")
+	}
+}
+
+func TestPostWebhook(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		reply  string
+		err    string
+	}{
+		{"ok", http.StatusOK, "ok", ""},
+		{"no content", http.StatusNoContent, "", ""},
+		{"forbidden", http.StatusForbidden, "invalid_token\n", "403 Forbidden invalid_token"},
+		{"server error", http.StatusInternalServerError, "", "500 Internal Server Error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var method, contentType string
+			var body map[string]string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				method, contentType = r.Method, r.Header.Get("Content-Type")
+				json.NewDecoder(r.Body).Decode(&body)
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.reply)
+			}))
+			defer server.Close()
+			n := testNotifier("calib")
+			n.config.Webhook = server.URL + "/hooks/run"
+
+			err := n.postWebhook("✅ Run calib finished\n")
+			if tt.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.err != "" && (err == nil || err.Error() != tt.err) {
+				t.Fatalf("error %v, want %q", err, tt.err)
+			}
+			if method != http.MethodPost || contentType != "application/json" || body["text"] != "✅ Run calib finished\n" {
+				t.Errorf("posted %s %s %v", method, contentType, body)
+			}
+		})
+	}
+}
+
+// TestNotifierSend checks the message sent to both channels at the end of a run
+func TestNotifierSend(t *testing.T) {
+	quietConsole(t)
+	var posted map[string]string
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&posted)
+	}))
+	defer hook.Close()
+	mail := startFakeSMTP(t, nil)
+	n := testNotifier("calib")
+	n.config.Webhook = hook.URL
+	n.config.SMTP.Port = mail.port
+	manifest := &manifestBuilder{fitness: []float64{0.1, 0.5}}
+	manifest.manifest.Areas = FitnessSummary{Failed: 1, Max: 0.5, WorstArea: "E02"}
+
+	n.send(manifest, errors.New("quality gate failed"))
+	for _, want := range []string{
+		"❌ Run calib failed after 0s: quality gate failed\n",
+		"Areas: 2 synthesized, 1 failed\n",
+		"Worst fitness: 0.5 (area E02)\n",
+		"Report: https://reports.example/calib/index.html\n",
+	} {
+		if !strings.Contains(posted["text"], want) {
+			t.Errorf("webhook text lacks %q:\n%s", want, posted["text"])
+		}
+	}
+	if _, data := mail.received(); !strings.Contains(data, "Subject: GoSynthPop run calib failed\r\n") ||
+		!strings.Contains(data, "Report: https://reports.example/calib/index.html\r\n") {
+		t.Errorf("mail sent as\n%s", data)
+	}
+}
@@ -0,0 +1,273 @@
+package synthpop
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// retryPolicy retries transient I/O failures (e.g. on network filesystems) with
+// exponential backoff. The zero value performs a single attempt.
+type retryPolicy struct {
+	retries int
+	backoff time.Duration
+}
+
+// defaultRetryBackoff is used when retries are enabled without an explicit backoff
+const defaultRetryBackoff = 500 * time.Millisecond
+
+// newRetryPolicy builds the policy from the output config
+func newRetryPolicy(popConfig PopulationConfig) retryPolicy {
+	backoff := time.Duration(popConfig.Output.RetryBackoffMs) * time.Millisecond
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	return retryPolicy{retries: popConfig.Output.Retries, backoff: backoff}
+}
+
+// do runs fn, retrying failed attempts until the policy is exhausted
+func (r retryPolicy) do(what string, fn func() error) error {
+	wait := r.backoff
+	err := fn()
+	for attempt := 1; err != nil && attempt <= r.retries; attempt++ {
+		log.Printf("%s failed (%v), retry %d/%d in %v", what, err, attempt, r.retries, wait)
+		time.Sleep(wait)
+		wait *= 2
+		err = fn()
+	}
+	return err
+}
+
+// retryWriter retries failed writes, resuming after any bytes already written
+type retryWriter struct {
+	w      io.Writer
+	name   string
+	policy retryPolicy
+}
+
+func (r *retryWriter) Write(p []byte) (int, error) {
+	written := 0
+	err := r.policy.do("write to "+r.name, func() error {
+		n, err := r.w.Write(p[written:])
+		written += n
+		return err
+	})
+	return written, err
+}
+
+// outputFile is a run output file, optionally compressed by the suffix of its path
+// and sealed with AES-GCM when a key is given. All writes go through it so outputs
+// are encrypted before they reach shared storage and transient write failures are
+// retried.
+type outputFile struct {
+	file    *os.File
+	w       io.Writer
+	enc     *encryptingWriter
+	comp    io.WriteCloser // Compressor in front of enc or w, nil when uncompressed
+	written int64          // Bytes written so far, including any the file held when resumed
+	policy  retryPolicy
+}
+
+// createOutput creates path for writing, compressed when path ends in .gz or .zst;
+// a non-nil key encrypts everything written, after compression
+func createOutput(path string, key []byte, policy retryPolicy) (*outputFile, error) {
+	out, err := createUncompressedOutput(path, key, policy)
+	if err != nil {
+		return nil, err
+	}
+	if out.enc != nil {
+		out.comp = newCompressor(path, out.enc)
+	} else {
+		out.comp = newCompressor(path, out.w)
+	}
+	return out, nil
+}
+
+// createUncompressedOutput creates path like createOutput whatever its suffix, for
+// formats compressing themselves (e.g. .tar.gz bundles)
+func createUncompressedOutput(path string, key []byte, policy retryPolicy) (*outputFile, error) {
+	var file *os.File
+	err := policy.do("create "+path, func() error {
+		var err error
+		file, err = os.Create(path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := &outputFile{file: file, w: &retryWriter{w: file, name: path, policy: policy}, policy: policy}
+	if key != nil {
+		out.enc, err = newEncryptingWriter(out.w, key)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("cannot initialise encryption for %s: %w", path, err)
+		}
+	}
+	return out, nil
+}
+
+// resumeOutput truncates an existing unencrypted output to offset and opens it for
+// appending, discarding anything written after the last checkpoint
+func resumeOutput(path string, offset int64, policy retryPolicy) (*outputFile, error) {
+	var file *os.File
+	err := policy.do("reopen "+path, func() error {
+		if err := os.Truncate(path, offset); err != nil {
+			return err
+		}
+		var err error
+		file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &outputFile{file: file, w: &retryWriter{w: file, name: path, policy: policy}, written: offset, policy: policy}, nil
+}
+
+func (o *outputFile) Write(p []byte) (int, error) {
+	var n int
+	var err error
+	if o.comp != nil {
+		n, err = o.comp.Write(p)
+	} else if o.enc != nil {
+		n, err = o.enc.Write(p)
+	} else {
+		n, err = o.w.Write(p)
+	}
+	o.written += int64(n)
+	return n, err
+}
+
+// offset is the size of an unencrypted, uncompressed output after everything
+// written so far
+func (o *outputFile) offset() int64 {
+	return o.written
+}
+
+func (o *outputFile) WriteString(s string) (int, error) {
+	return o.Write([]byte(s))
+}
+
+// writeArea writes the rows of one area at once. When the write still fails once
+// its retries are exhausted, the part of the rows written is truncated away so the
+// output holds whole areas only, and isolated is set: the run can carry on without
+// the area. Compressed and encrypted streams cannot drop part of their bytes, so
+// their failures are never isolated.
+func (o *outputFile) writeArea(rows []byte) (isolated bool, err error) {
+	start := o.written
+	if _, err = o.Write(rows); err == nil {
+		return false, nil
+	}
+	if o.comp != nil || o.enc != nil {
+		return false, err
+	}
+	if terr := o.truncate(start); terr != nil {
+		return false, fmt.Errorf("%w; cannot drop the rows written: %v", err, terr)
+	}
+	return true, err
+}
+
+// truncate drops everything written to a plain output after offset, so the next
+// write continues from there
+func (o *outputFile) truncate(offset int64) error {
+	if o.comp != nil || o.enc != nil {
+		return fmt.Errorf("%s is compressed or encrypted", o.file.Name())
+	}
+	err := o.policy.do("truncate "+o.file.Name(), func() error {
+		if err := o.file.Truncate(offset); err != nil {
+			return err
+		}
+		_, err := o.file.Seek(offset, io.SeekStart)
+		return err
+	})
+	if err == nil {
+		o.written = offset
+	}
+	return err
+}
+
+// Close completes the compressed stream and seals the final encrypted chunk (if
+// any) and closes the file
+func (o *outputFile) Close() error {
+	if o.comp != nil {
+		if err := o.comp.Close(); err != nil {
+			o.file.Close()
+			return err
+		}
+	}
+	if o.enc != nil {
+		if err := o.enc.Close(); err != nil {
+			o.file.Close()
+			return err
+		}
+	}
+	return o.file.Close()
+}
+
+// areaRows writes the ID mapping and validation rows of every area to the CSV
+// outputs, either of which may be nil. An output takes the rows of an area in a
+// single write, so an area that cannot be written once the retries are exhausted is
+// dropped from both, and the run carries on with the other areas.
+type areaRows struct {
+	ids      *outputFile
+	validate *outputFile
+	tally    *idTally // Counts of the records of an area in the counts layout
+	buf      bytes.Buffer
+	rows     *csv.Writer // Writes to buf
+}
+
+func newAreaRows(ids, validate *outputFile, tally *idTally) *areaRows {
+	w := &areaRows{ids: ids, validate: validate, tally: tally}
+	w.rows = csv.NewWriter(&w.buf)
+	return w
+}
+
+// write writes the rows of one area
+//
+// Returns:
+//   - isolated: The area failed alone: it is in neither output and the run can go on
+//   - error: The write failure, which leaves the outputs unusable unless isolated
+func (w *areaRows) write(res Result) (isolated bool, err error) {
+	var idsStart int64
+	if w.ids != nil {
+		idsStart = w.ids.offset()
+		w.buf.Reset()
+		if err := writeIDRows(w.rows, w.tally, res); err != nil {
+			return false, fmt.Errorf("error writing ID rows for area %s: %w", res.Area, err)
+		}
+		w.rows.Flush()
+		if isolated, err := w.ids.writeArea(w.buf.Bytes()); err != nil {
+			return isolated, fmt.Errorf("error writing ID rows for area %s: %w", res.Area, err)
+		}
+	}
+
+	if w.validate != nil {
+		// Build the unquoted CSV line
+		w.buf.Reset()
+		w.buf.WriteString(res.Area)
+		for _, val := range res.Totals {
+			w.buf.WriteByte(',')
+			w.buf.WriteString(strconv.FormatFloat(val, 'f', -1, 64))
+		}
+		// Iteration at which the best solution was found, to help size MaxIterations
+		w.buf.WriteByte(',')
+		w.buf.WriteString(strconv.Itoa(res.BestIteration))
+		w.buf.WriteByte('\n')
+		if isolated, err := w.validate.writeArea(w.buf.Bytes()); err != nil {
+			err = fmt.Errorf("error writing fraction row for area %s: %w", res.Area, err)
+			// The area's ID rows go too, when they can
+			if isolated && w.ids != nil {
+				if terr := w.ids.truncate(idsStart); terr != nil {
+					return false, fmt.Errorf("%w; cannot drop its ID rows: %v", err, terr)
+				}
+			}
+			return isolated, err
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,132 @@
+package synthpop
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flakyWriter fails its first failures writes, each after writing half of what it
+// was given, as a network filesystem dropping a write part way
+type flakyWriter struct {
+	w        io.Writer
+	failures int
+	writes   int
+}
+
+func (f *flakyWriter) Write(p []byte) (int, error) {
+	f.writes++
+	if f.failures > 0 {
+		f.failures--
+		n, _ := f.w.Write(p[:len(p)/2])
+		return n, errors.New("stale file handle")
+	}
+	return f.w.Write(p)
+}
+
+// quietLog discards the retry messages of the standard logger until the test ends
+func quietLog(t *testing.T) {
+	saved := log.Writer()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(saved) })
+}
+
+func TestRetryWriter(t *testing.T) {
+	quietLog(t)
+	policy := retryPolicy{retries: 2, backoff: time.Microsecond}
+	for failures := 0; failures <= 3; failures++ {
+		var out strings.Builder
+		flaky := &flakyWriter{w: &out, failures: failures}
+		w := &retryWriter{w: flaky, name: "ids.csv", policy: policy}
+		n, err := w.Write([]byte("E01,r1\nE01,r2\n"))
+		if failures <= policy.retries {
+			// Every retry resumes after the bytes already written
+			if err != nil || n != 14 || out.String() != "E01,r1\nE01,r2\n" || flaky.writes != failures+1 {
+				t.Errorf("%d failures: wrote %d bytes %q in %d attempts (%v)", failures, n, out.String(), flaky.writes, err)
+			}
+			continue
+		}
+		if err == nil || n == 14 || flaky.writes != policy.retries+1 {
+			t.Errorf("%d failures: wrote %d bytes in %d attempts without failing", failures, n, flaky.writes)
+		}
+	}
+}
+
+func TestAreaRowsIsolation(t *testing.T) {
+	quietLog(t)
+	dir := t.TempDir()
+	policy := retryPolicy{retries: 1, backoff: time.Microsecond}
+	open := func(name string) (*outputFile, *flakyWriter) {
+		out, err := createOutput(filepath.Join(dir, name), nil, policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { out.Close() })
+		flaky := &flakyWriter{w: out.file}
+		out.w = &retryWriter{w: flaky, name: name, policy: policy}
+		return out, flaky
+	}
+	ids, idsFlaky := open("ids.csv")
+	validate, validateFlaky := open("validate.csv")
+	rows := newAreaRows(ids, validate, nil)
+	area := func(id string) Result {
+		return Result{Area: id, IDs: []string{"r1", "r2"}, Totals: []float64{1, 0.5}, BestIteration: 7}
+	}
+
+	tests := []struct {
+		area                          string
+		idsFailures, validateFailures int
+		isolated                      bool
+	}{
+		{"A", 0, 0, false},
+		{"B", 2, 0, true},  // Out of retries for the ID rows
+		{"C", 1, 1, false}, // Retried
+		{"D", 0, 2, true},  // Out of retries for the validation row, its ID rows are dropped too
+		{"E", 0, 0, false},
+	}
+	for _, tt := range tests {
+		idsFlaky.failures, validateFlaky.failures = tt.idsFailures, tt.validateFailures
+		isolated, err := rows.write(area(tt.area))
+		if isolated != tt.isolated || (err != nil) != tt.isolated {
+			t.Fatalf("area %s: isolated %v, error %v", tt.area, isolated, err)
+		}
+		if tt.isolated && (!strings.Contains(err.Error(), "area "+tt.area) || !strings.Contains(err.Error(), "stale file handle")) {
+			t.Errorf("area %s: error %v does not name the area and the cause", tt.area, err)
+		}
+	}
+
+	for _, f := range []struct {
+		out  *outputFile
+		want string
+	}{
+		{ids, "A,r1\nA,r2\nC,r1\nC,r2\nE,r1\nE,r2\n"},
+		{validate, "A,1,0.5,7\nC,1,0.5,7\nE,1,0.5,7\n"},
+	} {
+		data, err := os.ReadFile(f.out.file.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != f.want {
+			t.Errorf("%s holds\n%s\nwant\n%s", f.out.file.Name(), data, f.want)
+		}
+		// The offsets checkpointed are those of whole areas
+		if f.out.offset() != int64(len(f.want)) {
+			t.Errorf("%s offset %d, want %d", f.out.file.Name(), f.out.offset(), len(f.want))
+		}
+	}
+
+	// Compressed outputs cannot drop the rows of an area
+	compressed, err := createOutput(filepath.Join(dir, "ids.csv.gz"), nil, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer compressed.Close()
+	if err := compressed.truncate(0); err == nil {
+		t.Error("compressed output truncated")
+	}
+}
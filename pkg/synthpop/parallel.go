@@ -0,0 +1,909 @@
+package synthpop
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// runSeed returns the master seed of a run: the configured one in deterministic
+// mode, otherwise a fresh one. Either way it is recorded in the run manifest, so
+// any run can be reproduced.
+func runSeed(config AnnealingConfig) int64 {
+	if strings.ToLower(strings.TrimSpace(config.UseRandomSeed)) != "yes" {
+		return time.Now().UnixNano() // Production mode (non-deterministic)
+	}
+	return *config.RandomSeed
+}
+
+// areaSeed derives the seed of an area from the master seed and the area ID, so an
+// area gets the same random numbers whichever worker picks it up and whenever
+func areaSeed(seed int64, areaID string) int64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(seed))
+	h.Write(buf[:])
+	h.Write([]byte(areaID))
+	return int64(h.Sum64())
+}
+
+// areaWriter is an optional output written area by area
+type areaWriter interface {
+	writeArea(res Result) error
+	Close() error
+}
+
+// extraOutput is an optional area writer with the name used in its error messages
+type extraOutput struct {
+	name string
+	w    areaWriter
+}
+
+// areaOutcome is what a worker hands to the writer: a result, or why the area failed
+type areaOutcome struct {
+	res Result
+	err error
+}
+
+// ErrTimeBudget is the reason recorded for the areas a run left unprocessed when
+// its maxTotalMinutes ran out
+var ErrTimeBudget = errors.New("run time budget (maxTotalMinutes) exhausted")
+
+// Run executes population synthesis in parallel across multiple workers.
+// It takes constraint data, microdata, output file paths, and annealing configuration,
+// then distributes the work across CPU cores and writes results to CSV files.
+//
+// Parameters:
+//   - constraints: Slice of ConstraintData defining each geographical area's constraints
+//   - microData: Slice of MicroData containing individual population records
+//   - microdataHeader: Names of the constraint variables, used for output headers
+//   - popConfig: PopulationConfig with the output paths:
+//     Output.File is the CSV mapping area IDs to synthetic population IDs, or to record
+//     counts in the counts Output.Layout (skipped when Output.AggregateOnly is set), Validate.File is the CSV comparing synthetic vs
+//     constraint fractions, with a trailing best_iteration column per area.
+//     RunName names the run and is substituted for {run} in the paths (see ApplyRunName)
+//   - config: AnnealingConfig with optimization parameters
+//
+// Returns:
+//   - error: Any error encountered during processing
+func Run(constraints []ConstraintData, microData []MicroData, microdataHeader []string, popConfig PopulationConfig, config AnnealingConfig) error {
+	return run(context.Background(), constraints, microData, microdataHeader, popConfig, config, runHooks{})
+}
+
+// runHooks connect a run to a front-end
+type runHooks struct {
+	progress chan<- RunProgress // Progress statistics, printed to the console when nil
+	results  chan<- Result      // Every synthesized area once its outputs are written
+}
+
+// run is Run with front-end hooks. Cancelling ctx stops handing out areas; the areas
+// already being synthesized are completed and written before run returns the cause.
+func run(ctx context.Context, constraints []ConstraintData, microData []MicroData, microdataHeader []string,
+	popConfig PopulationConfig, config AnnealingConfig, hooks runHooks) (err error) {
+	// Name the run and expand {run} in the output paths (a no-op when the caller did)
+	popConfig, err = ApplyRunName(popConfig)
+	if err != nil {
+		return err
+	}
+	Printf("🏷️ Run name: %s\n", popConfig.RunName)
+
+	// Optional message announcing how the run ended
+	notifier := newNotifier(popConfig)
+	var manifest *manifestBuilder
+	defer func() { notifier.send(manifest, err) }()
+	runStart := time.Now()
+	defer func() { emitSummary(popConfig.RunName, manifest, time.Since(runStart), err) }()
+
+	// Variable names written to the outputs
+	outputHeader, err := renameHeader(microdataHeader, popConfig.Output.Rename)
+	if err != nil {
+		return err
+	}
+
+	// Optional checkpoint; when resuming, only the areas it lacks are synthesized
+	ckpt, err := openCheckpoint(popConfig)
+	if err != nil {
+		return err
+	}
+	if ckpt != nil {
+		defer ckpt.Close()
+	}
+
+	// Areas already in the outputs when resuming or appending, and the output
+	// sizes to continue from
+	var done map[string]bool
+	var resumeAt checkpointEntry
+	switch {
+	case popConfig.Output.Append && popConfig.Checkpoint.Resume:
+		return fmt.Errorf("use either checkpoint resume or output append, not both")
+	case popConfig.Output.Append:
+		if err := checkResumable(popConfig, "append"); err != nil {
+			return err
+		}
+		if done, resumeAt, err = existingOutputs(popConfig, outputHeader); err != nil {
+			return err
+		}
+		if done == nil {
+			Printf("No existing outputs at %s, writing new ones\n", popConfig.Validate.File)
+		}
+	case ckpt != nil && ckpt.last != nil:
+		done, resumeAt = ckpt.done, *ckpt.last
+	}
+	continuing := done != nil
+
+	// Optional directory of constraint deliveries added to the run as they arrive
+	watcher, err := newConstraintWatcher(popConfig, microdataHeader, constraints)
+	if err != nil {
+		return err
+	}
+	if watcher != nil {
+		for area := range done {
+			watcher.areas[area] = true
+		}
+	}
+
+	if continuing {
+		remaining := make([]ConstraintData, 0, len(constraints))
+		seen := make(map[string]bool, len(constraints))
+		for _, constraint := range constraints {
+			if seen[constraint.ID] {
+				return fmt.Errorf("area %s appears more than once in the constraints", constraint.ID)
+			}
+			seen[constraint.ID] = true
+			if !done[constraint.ID] {
+				remaining = append(remaining, constraint)
+			}
+		}
+		if popConfig.Output.Append {
+			Printf("➕ Appending: %d of %d areas already in the outputs\n", len(constraints)-len(remaining), len(constraints))
+		} else {
+			Printf("⏩ Resuming: %d of %d areas already completed\n", len(constraints)-len(remaining), len(constraints))
+		}
+		if len(remaining) == 0 && watcher == nil {
+			return nil
+		}
+		constraints = remaining
+	}
+
+	// Optional populations of a previous run the areas start from, read before the
+	// outputs are created in case they are the same file
+	var seeds map[string][]int
+	if popConfig.SeedPopulationFile != "" {
+		var missing int
+		if seeds, missing, err = ReadSeedPopulation(popConfig.SeedPopulationFile, microData); err != nil {
+			return err
+		}
+		seeded := 0
+		for _, constraint := range constraints {
+			if _, ok := seeds[constraint.ID]; ok {
+				seeded++
+			}
+		}
+		Printf("🌱 Warm start from %s: %d of %d areas seeded\n", popConfig.SeedPopulationFile, seeded, len(constraints))
+		if missing > 0 {
+			Printf("⚠️ %d seed individuals have records missing from the microdata and are drawn afresh\n", missing)
+		}
+	}
+
+	// Optional regional donor pools, each area drawing from its region's records
+	regions, err := newRegionPools(popConfig, constraints, microData)
+	if err != nil {
+		return err
+	}
+	if regions != nil && seeds != nil {
+		regions.localSeeds(seeds, microData)
+	}
+
+	// Order the areas are handed out in
+	constraints = scheduleAreas(constraints, popConfig.Scheduling)
+
+	// Dynamic worker count - use either the configured workers (default the CPU
+	// count) or constraint count, whichever is smaller. A watched run keeps them all
+	// for the areas still to come.
+	numWorkers := runtime.NumCPU()
+	if popConfig.Workers > 0 {
+		numWorkers = popConfig.Workers
+	}
+	if len(constraints) < numWorkers && watcher == nil {
+		numWorkers = len(constraints)
+	}
+	Printf("🚀 Starting %d workers for %d population areas\n", numWorkers, len(constraints))
+	Printf("🧮 %d CPUs (GOMAXPROCS %d), estimated memory %.1fMB\n", runtime.NumCPU(), runtime.GOMAXPROCS(0),
+		float64(estimateRunMemory(constraints, microData, numWorkers, config))/(1<<20))
+
+	// Master seed; every area's random numbers are derived from it and the area ID
+	seed := runSeed(config)
+	manifest = newManifestBuilder(popConfig, config, seed)
+
+	// End of the run's time budget: areas are no longer handed out after it, and
+	// the searches in progress stop with their best solution so far
+	var runDeadline time.Time
+	budgetDone := make(<-chan struct{}) // Never closed without a budget
+	if config.MaxTotalMinutes > 0 {
+		runDeadline = time.Now().Add(time.Duration(config.MaxTotalMinutes * float64(time.Minute)))
+		budget, cancelBudget := context.WithDeadline(context.Background(), runDeadline)
+		defer cancelBudget()
+		budgetDone = budget.Done()
+	}
+	tracker := newTracker()
+
+	// Check the distance configuration once; each worker builds its own copy below
+	if _, err = buildDistance(config, microdataHeader); err != nil {
+		return err
+	}
+
+	// Setup communication channels:
+	// - jobs: feeds constraints to workers
+	// - resultsChan: collects processed results from workers
+	// - errChan: receives any processing errors (buffered to prevent deadlocks)
+	jobs := make(chan ConstraintData, numWorkers*2)
+	resultsChan := make(chan areaOutcome, numWorkers*2)
+	errChan := make(chan error, 1)
+
+	// Create output files for:
+	// 1. ID mappings (area_id → synthetic population IDs), unless only aggregates are wanted
+	// 2. Fraction comparisons (synthetic vs constraint fractions by variable)
+	aggregateOnly := popConfig.Output.AggregateOnly
+	if config.Algorithm == AlgorithmIPF && config.IPF.Fractional && !aggregateOnly {
+		return fmt.Errorf("fractional IPF weights do not give individuals, set output.aggregateOnly and use output.weightsFile")
+	}
+	var key []byte
+	if popConfig.Output.Encrypt {
+		var err error
+		if key, err = LoadEncryptionKey(); err != nil {
+			return err
+		}
+	}
+
+	retry := newRetryPolicy(popConfig)
+
+	// openOutput creates an output, or continues it when resuming or appending
+	openOutput := func(path string, offset int64) (*outputFile, error) {
+		if continuing {
+			return resumeOutput(path, offset, retry)
+		}
+		return createOutput(path, key, retry)
+	}
+
+	// Parquet outputs are written with the optional per-area outputs below
+	idsParquet := fileFormat(popConfig.Output.File, popConfig.Output.Format) == FormatParquet
+	validateParquet := fileFormat(popConfig.Validate.File, popConfig.Validate.Format) == FormatParquet
+
+	var idsFile *outputFile
+	var idsTally *idTally // Counts of the records of an area in the counts layout
+	if popConfig.Output.Layout == OutputLayoutCounts {
+		idsTally = newIDTally()
+	}
+	if !aggregateOnly && !idsParquet {
+		idsFile, err = openOutput(popConfig.Output.File, resumeAt.IDsOffset)
+		if err != nil {
+			return fmt.Errorf("cannot create IDs file: %w", err)
+		}
+		defer idsFile.Close()
+
+		if !continuing {
+			idsWriter := csv.NewWriter(idsFile)
+			idsWriter.Write(idsHeader(popConfig.Output.Layout))
+			idsWriter.Flush()
+			if err := idsWriter.Error(); err != nil {
+				return fmt.Errorf("error writing IDs headers: %w", err)
+			}
+		}
+	}
+
+	var fractionsFile *outputFile
+	if !validateParquet {
+		fractionsFile, err = openOutput(popConfig.Validate.File, resumeAt.ValidateOffset)
+		if err != nil {
+			return fmt.Errorf("cannot create fractions file: %w", err)
+		}
+		defer fractionsFile.Close()
+
+		fractionsWriter := csv.NewWriter(fractionsFile)
+		defer fractionsWriter.Flush()
+
+		// Write CSV header for the fractions file
+		if !continuing {
+			header := append([]string{"geography_code"}, outputHeader...)
+			header = append(header, "best_iteration")
+			if err := fractionsWriter.Write(header); err != nil {
+				return fmt.Errorf("error writing fractions headers: %w", err)
+			}
+			fractionsWriter.Flush() // This will write the line to file immediately
+			if err := fractionsWriter.Error(); err != nil {
+				return fmt.Errorf("error flushing fractions headers: %w", err)
+			}
+		}
+	}
+
+	// Optional spatial QA layer joined to the boundaries file
+	spatial, err := newSpatialJoin(popConfig, microdataHeader, outputHeader)
+	if err != nil {
+		return err
+	}
+
+	// Optional Moran's I of fitness over the adjacency file
+	moran, err := newMoranDiagnostic(popConfig)
+	if err != nil {
+		return err
+	}
+
+	// Fit of the summed areas to the constraints of the parent geography
+	parents, err := newParentValidation(ctx, popConfig, microdataHeader, outputHeader)
+	if err != nil {
+		return err
+	}
+
+	// Optional post-run quality gate
+	gate := newQualityGate(popConfig)
+
+	// Optional per-area outputs, written after the ID mappings of every area
+	var extras []extraOutput
+	abort := func(err error) error {
+		for _, extra := range extras {
+			extra.w.Close()
+		}
+		return err
+	}
+
+	// Parquet ID mappings and fractions
+	if idsParquet && !aggregateOnly {
+		ids, err := newParquetIDsWriter(popConfig.Output.File, popConfig.Output.Layout, key, retry)
+		if err != nil {
+			return abort(err)
+		}
+		extras = append(extras, extraOutput{"ID mappings", ids})
+	}
+	if validateParquet {
+		totals, err := newParquetTotalsWriter(popConfig.Validate.File, outputHeader, key, retry)
+		if err != nil {
+			return abort(err)
+		}
+		extras = append(extras, extraOutput{"fractions", totals})
+	}
+
+	// Validation errors and summary, with shares of the declared subpopulations
+	denominators, err := resolveDenominators(config, microdataHeader)
+	if err != nil {
+		return abort(err)
+	}
+	validation, err := newValidationWriter(popConfig, outputHeader, denominators, key, retry)
+	if err != nil {
+		return abort(err)
+	}
+	if validation != nil {
+		extras = append(extras, extraOutput{"validation outputs", validation})
+	}
+
+	// Two-way interaction preservation
+	interactions, err := newInteractionWriter(popConfig, microdataHeader, outputHeader, microData, key, retry)
+	if err != nil {
+		return abort(err)
+	}
+	if interactions != nil {
+		extras = append(extras, extraOutput{"interaction scores", interactions})
+	}
+
+	// Convergence trace
+	tracer, err := newTraceWriter(popConfig, key, retry)
+	if err != nil {
+		return abort(err)
+	}
+	if tracer != nil {
+		extras = append(extras, extraOutput{"convergence trace", tracer})
+	}
+
+	// Move statistics of the annealing
+	diagnostics, err := newDiagnosticsWriter(popConfig, config, key, retry)
+	if err != nil {
+		return abort(err)
+	}
+	if diagnostics != nil {
+		extras = append(extras, extraOutput{"diagnostics", diagnostics})
+	}
+
+	// Population counts and totals in a SQLite database
+	database, err := newSQLiteOutput(popConfig, outputHeader, retry)
+	if err != nil {
+		return abort(err)
+	}
+	if database != nil {
+		extras = append(extras, extraOutput{"SQLite file", database})
+	}
+
+	// Population counts and totals copied into PostgreSQL
+	postgres, err := newPostgresWriter(popConfig, outputHeader)
+	if err != nil {
+		return abort(err)
+	}
+	if postgres != nil {
+		extras = append(extras, extraOutput{"PostgreSQL output", postgres})
+	}
+
+	// Record inclusion probabilities
+	inclusion, err := newInclusionWriter(popConfig, key, retry)
+	if err != nil {
+		return abort(err)
+	}
+	if inclusion != nil {
+		extras = append(extras, extraOutput{"inclusion probabilities", inclusion})
+	}
+
+	// Agent-based model exports (agents CSV, MATSim population XML)
+	agents, err := newAgentExporter(popConfig, outputHeader, microData, key, retry)
+	if err != nil {
+		return abort(err)
+	}
+	if agents != nil {
+		extras = append(extras, extraOutput{"agent exports", agents})
+	}
+
+	// Synthetic individuals with the attributes of their records
+	individuals, err := newIndividualsWriter(popConfig, microData, key, retry)
+	if err != nil {
+		return abort(err)
+	}
+	if individuals != nil {
+		extras = append(extras, extraOutput{"individuals", individuals})
+	}
+
+	// Persons of the synthetic households
+	persons, err := newPersonsWriter(popConfig, key, retry)
+	if err != nil {
+		return abort(err)
+	}
+	if persons != nil {
+		extras = append(extras, extraOutput{"persons", persons})
+	}
+
+	// Fractional IPF weights
+	weights, err := newWeightsWriter(popConfig, config, key, retry)
+	if err != nil {
+		return abort(err)
+	}
+	if weights != nil {
+		extras = append(extras, extraOutput{"weights", weights})
+	}
+
+	// Per-worker debug logs
+	workerLogs, err := openWorkerLogs(popConfig, numWorkers)
+	if err != nil {
+		return abort(err)
+	}
+
+	// Areas that cannot be synthesized are recorded and skipped
+	failed := newFailedAreasWriter(popConfig, key, retry)
+
+	// closeExtras completes the optional per-area outputs, the failed areas and the
+	// worker logs once the workers are done
+	closeExtras := func() error {
+		firstErr := failed.Close()
+		if firstErr != nil {
+			firstErr = fmt.Errorf("error completing failed areas file: %w", firstErr)
+		}
+		if err := closeWorkerLogs(workerLogs); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("error completing worker logs: %w", err)
+		}
+		for _, extra := range extras {
+			if err := extra.w.Close(); err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("error completing %s: %w", extra.name, err)
+			}
+		}
+		return firstErr
+	}
+	// Progress tracking setup
+	var (
+		processed      atomic.Int32                      // Thread-safe counter for completed jobs
+		totalJobs      atomic.Int32                      // Grows as a watched directory delivers areas
+		startTime      = time.Now()                      // Capture start time for ETA calculation
+		progressTicker = time.NewTicker(2 * time.Second) // Update progress every 2s
+	)
+	defer progressTicker.Stop()
+	totalJobs.Store(int32(len(constraints)))
+
+	// progressNow computes the current progress statistics
+	progressNow := func() RunProgress {
+		elapsed := time.Since(startTime).Round(time.Second)
+		done, total := int(processed.Load()), int(totalJobs.Load())
+
+		// Calculate ETA based on current processing rate
+		var eta time.Duration
+		if done > 0 {
+			perItem := elapsed / time.Duration(done)
+			eta = time.Duration(total-done) * perItem
+		}
+
+		// Include memory usage in progress report
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+
+		return RunProgress{RunName: popConfig.RunName, Done: done, Total: total,
+			Elapsed: elapsed, ETA: eta, MemoryMB: m.Alloc / 1024 / 1024}
+	}
+	// reportProgress prints the statistics, or writes them as an event in the json
+	// progress format, or hands them to the front-end's channel without blocking
+	// when one is given
+	reportProgress := func() {
+		if hooks.progress == nil {
+			if jsonEvents() {
+				emitProgress(progressNow())
+			} else {
+				Printf("\r%s", progressNow())
+			}
+			return
+		}
+		select {
+		case hooks.progress <- progressNow():
+		default:
+		}
+	}
+
+	// Progress reporter goroutine - displays real-time statistics
+	go func() {
+		for range progressTicker.C {
+			reportProgress()
+		}
+	}()
+
+	// Heartbeat status file for external watchdogs
+	status := startStatusReporter(popConfig, func() int { return int(totalJobs.Load()) },
+		func() int { return int(processed.Load()) })
+
+	// Prometheus endpoint for dashboards
+	metrics, err := startMetricsServer(popConfig, numWorkers, func() int { return int(totalJobs.Load()) })
+	if err != nil {
+		status.finish(err)
+		return err
+	}
+	defer metrics.close()
+
+	// Writer goroutine - handles all output file writing
+	var writerWg sync.WaitGroup
+	rows := newAreaRows(idsFile, fractionsFile, idsTally)
+	// Areas skipped and stopped by the time budgets, and areas that could not be
+	// written, counted by the writer
+	unprocessed, timedOut, unwritten := 0, 0, 0
+	writerWg.Add(1)
+	go func() {
+		defer writerWg.Done()
+		// failArea records an area that could not be synthesized or written, and
+		// reports whether the run can go on
+		failArea := func(res Result, reason error) bool {
+			if gate != nil {
+				gate.fail()
+			}
+			manifest.fail()
+			metrics.fail()
+			if err := failed.add(res.Area, reason); err != nil {
+				select {
+				case errChan <- err:
+				default:
+				}
+				return false
+			}
+			emitArea(popConfig.RunName, res, reason, int(processed.Add(1)), int(totalJobs.Load()))
+			return true
+		}
+		for outcome := range resultsChan {
+			if outcome.err != nil {
+				if errors.Is(outcome.err, ErrTimeBudget) {
+					unprocessed++
+				}
+				if !failArea(outcome.res, outcome.err) {
+					return
+				}
+				continue
+			}
+			res := outcome.res
+			areaId := res.Area
+
+			// Write the CSV ID mappings and validation row first (Parquet is written
+			// with the extras): an area they cannot take once the retries are
+			// exhausted is left out of every output, and the run goes on
+			if isolated, err := rows.write(res); isolated {
+				unwritten++
+				if !failArea(res, err) {
+					return
+				}
+				continue
+			} else if err != nil {
+				select {
+				case errChan <- err:
+				default:
+				}
+				return
+			}
+			if res.TimedOut {
+				timedOut++
+			}
+
+			if spatial != nil {
+				spatial.add(res)
+			}
+			if moran != nil {
+				moran.add(res)
+			}
+			if parents != nil {
+				parents.add(res)
+			}
+			if gate != nil {
+				gate.add(res)
+			}
+			manifest.add(res)
+			tracker.add(res)
+			metrics.add(res)
+
+			for _, extra := range extras {
+				if err := extra.w.writeArea(res); err != nil {
+					select {
+					case errChan <- fmt.Errorf("error writing %s for area %s: %w", extra.name, areaId, err):
+					default:
+					}
+					return
+				}
+			}
+
+			// Record the area once its rows are on disk, so a resumed run can continue after it
+			if ckpt != nil {
+				entry := checkpointEntry{Area: areaId, ValidateOffset: fractionsFile.offset()}
+				if idsFile != nil {
+					entry.IDsOffset = idsFile.offset()
+				}
+				if err := ckpt.record(entry); err != nil {
+					select {
+					case errChan <- fmt.Errorf("error writing checkpoint for area %s: %w", areaId, err):
+					default:
+					}
+					return
+				}
+			}
+
+			emitArea(popConfig.RunName, res, nil, int(processed.Add(1)), int(totalJobs.Load()))
+
+			// Stream the area to the front-end, unless the run was cancelled and
+			// nobody is receiving any more
+			if hooks.results != nil {
+				select {
+				case hooks.results <- res:
+				case <-ctx.Done():
+				}
+			}
+		}
+	}()
+
+	// Worker pool - processes constraints in parallel
+	schedule := newScheduleStats(numWorkers)
+	var cores coreBudget
+	if config.Tempering.Replicas > 1 {
+		cores = newCoreBudget(numWorkers)
+	}
+	// Non-zero columns of the microdata pools and valid records of every zero
+	// pattern, indexed once for all workers
+	columns, validity := newSparseIndex(), newValidityIndex()
+	var workerWg sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		workerWg.Add(1)
+		go func(workerID int) {
+			defer workerWg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			// Reused by every area this worker processes
+			scratch := &annealScratch{traceEvery: traceInterval(popConfig), cores: cores, runDeadline: runDeadline}
+			distance, _ := buildDistance(config, microdataHeader)
+			scratch.delta, scratch.columns = buildDeltaFitness(config, microdataHeader), columns
+			scratch.validity = validity
+			scratch.newDistance = func() DistanceFunc {
+				d, _ := buildDistance(config, microdataHeader)
+				return d
+			}
+			stats := &schedule.workers[workerID]
+			defer func() { stats.finished = time.Now() }()
+			var wlog *workerLog
+			if workerLogs != nil {
+				wlog = workerLogs[workerID]
+			}
+			for constraint := range jobs {
+				// Areas still queued when the run's budget runs out are skipped
+				if !runDeadline.IsZero() && time.Now().After(runDeadline) {
+					select {
+					case resultsChan <- areaOutcome{res: Result{Area: constraint.ID}, err: ErrTimeBudget}:
+						continue
+					case <-errChan:
+						return
+					}
+				}
+				// Generate synthetic population for this constraint area
+				if wlog != nil {
+					wlog.areaStart(constraint)
+				}
+				// Reproducible regardless of scheduling: reseed for every area
+				rng.Seed(areaSeed(seed, constraint.ID))
+				areaStart := time.Now()
+				scratch.seed = seeds[constraint.ID]
+				cores.acquire()
+				donors := microData
+				if regions != nil {
+					donors = regions.pool(constraint.ID)
+				}
+				res, err := synthesizeArea(constraint, donors, config, distance, rng, scratch)
+				cores.release()
+				took := time.Since(areaStart)
+				stats.busy += took
+				stats.areas++
+				metrics.work(workerID, took)
+				res.Area = constraint.ID
+				if wlog != nil {
+					wlog.areaFinish(res, err, took)
+				}
+				if aggregateOnly {
+					res.IDs = nil // Don't hold assignments in the results queue
+				}
+
+				// Send result or abort if error occurred
+				select {
+				case resultsChan <- areaOutcome{res: res, err: err}:
+				case <-errChan: // Channel closed means error occurred
+					return
+				}
+			}
+		}(i)
+	}
+
+	// feed hands areas to the workers, stopping when the caller cancels the run or
+	// a writer fails
+	feed := func(areas []ConstraintData) error {
+		for i, constraint := range areas {
+			select {
+			case jobs <- constraint: // Send next job
+			case <-ctx.Done(): // Cancelled by the caller
+				return fmt.Errorf("run cancelled: %w", context.Cause(ctx))
+			case err := <-errChan: // Handle any errors from writers
+				return err
+			case <-budgetDone: // Out of time: the areas left are recorded as failed
+				for _, left := range areas[i:] {
+					select {
+					case resultsChan <- areaOutcome{res: Result{Area: left.ID}, err: ErrTimeBudget}:
+					case err := <-errChan:
+						return err
+					}
+				}
+				return ErrTimeBudget
+			}
+		}
+		return nil
+	}
+	err = feed(constraints)
+
+	// Keep feeding the areas delivered to the watched directory until its stop file
+	// appears
+	for watcher != nil && err == nil {
+		var delivered []ConstraintData
+		var stop bool
+		if delivered, stop, err = watcher.poll(ctx); err != nil {
+			break
+		}
+		totalJobs.Add(int32(len(delivered)))
+		if err = feed(delivered); err != nil || stop {
+			break
+		}
+		select {
+		case <-time.After(watcher.interval):
+		case <-ctx.Done():
+			err = fmt.Errorf("run cancelled: %w", context.Cause(ctx))
+		case err = <-errChan:
+		case <-budgetDone:
+			err = ErrTimeBudget
+		}
+	}
+	if errors.Is(err, ErrTimeBudget) {
+		Printf("\n⏱️ maxTotalMinutes (%g) reached, no more areas are handed out\n", config.MaxTotalMinutes)
+		err = nil
+	}
+	if err != nil {
+		close(jobs)        // Signal workers to stop
+		workerWg.Wait()    // Wait for workers to finish
+		close(resultsChan) // Close results channel
+		writerWg.Wait()    // Wait for writer to finish
+		closeExtras()
+		status.finish(err)
+		return err
+	}
+	close(jobs) // All jobs sent
+
+	// Wait for completion
+	workerWg.Wait()    // All workers finished
+	close(resultsChan) // No more results coming
+	writerWg.Wait()    // All results written
+
+	// The writer may have failed after the last job was handed out
+	select {
+	case err := <-errChan:
+		closeExtras()
+		status.finish(err)
+		return err
+	default:
+	}
+
+	if err := closeExtras(); err != nil {
+		status.finish(err)
+		return err
+	}
+
+	if spatial != nil {
+		if err := spatial.write(popConfig.Output.GeoJSONFile, key, retry); err != nil {
+			status.finish(err)
+			return err
+		}
+	}
+
+	if moran != nil {
+		if err := moran.report(popConfig.Adjacency.ReportFile, key, retry); err != nil {
+			status.finish(err)
+			return err
+		}
+	}
+
+	if parents != nil {
+		if err := parents.report(popConfig.Parent.ValidateFile, key, retry); err != nil {
+			status.finish(err)
+			return err
+		}
+	}
+
+	var gateErr error
+	if gate != nil {
+		gateErr = gate.check()
+	}
+	if err := manifest.write(manifestPath(popConfig), gateErr, gate != nil, key, retry); err != nil {
+		status.finish(err)
+		return err
+	}
+	artifacts := []string{manifestPath(popConfig), popConfig.Validate.SummaryFile}
+	if gateErr == nil && popConfig.Output.BundleFile != "" {
+		if err := writeBundle(popConfig, config, failed, retry); err != nil {
+			status.finish(err)
+			return err
+		}
+		artifacts = append(artifacts, popConfig.Output.BundleFile)
+	}
+	tracker.log(&manifest.manifest, gateErr, artifacts)
+	if gateErr != nil {
+		status.finish(gateErr)
+		return gateErr
+	}
+
+	// The areas the outputs could not take fail the run once the others are done
+	var writeErr error
+	if unwritten > 0 {
+		writeErr = fmt.Errorf("%d areas could not be written after %d retries, they are listed in %s",
+			unwritten, retry.retries, failed.path)
+	}
+	status.finish(writeErr)
+	if hooks.progress != nil || jsonEvents() {
+		reportProgress() // Completed, so progress bars end full
+	}
+
+	// Final performance report
+	elapsed := time.Since(startTime).Round(time.Second)
+	total := int(totalJobs.Load())
+	Printf("\n✅ Run %s completed %d populations in %v (avg %.2f/sec)\n",
+		popConfig.RunName, total-len(failed.areas), elapsed, float64(total)/elapsed.Seconds())
+	failed.printSummary()
+	if unprocessed > 0 {
+		Printf("⏱️ %d areas were left unprocessed by maxTotalMinutes; they are listed in %s\n", unprocessed, failed.path)
+	}
+	if timedOut > 0 {
+		Printf("⏱️ %d areas were stopped by their time budget with their best solution so far\n", timedOut)
+	}
+	schedule.report()
+
+	return writeErr
+}
@@ -0,0 +1,60 @@
+package synthpop
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestOutputAppend appends new areas to the outputs of an earlier run
+func TestOutputAppend(t *testing.T) {
+	constraints, microData, header, popConfig, config := testRunInputs(t, 10)
+	popConfig.Output.Append = true
+
+	// Without outputs, appending writes new ones
+	if err := run(context.Background(), constraints[:6], microData, header, popConfig, config, runHooks{}); err != nil {
+		t.Fatal(err)
+	}
+	checkRunOutputs(t, popConfig, header, constraints[:6])
+
+	// The areas already written are skipped, the others added under the same header
+	if err := run(context.Background(), constraints, microData, header, popConfig, config, runHooks{}); err != nil {
+		t.Fatal(err)
+	}
+	checkRunOutputs(t, popConfig, header, constraints)
+	data, err := os.ReadFile(popConfig.Output.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := 0
+	for _, c := range constraints {
+		rows += int(c.Total)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != rows+1 {
+		t.Errorf("IDs file has %d lines, want %d rows and the header", lines, rows)
+	}
+
+	// Nothing is left to append
+	if err := run(context.Background(), constraints, microData, header, popConfig, config, runHooks{}); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := os.ReadFile(popConfig.Output.File); err != nil || string(again) != string(data) {
+		t.Errorf("appending no area changed the IDs file (%v)", err)
+	}
+
+	// Outputs of other variables are not appended to
+	other := append([]string(nil), header...)
+	other[0] = "renamed"
+	err = run(context.Background(), constraints, microData, other, popConfig, config, runHooks{})
+	if err == nil || !strings.Contains(err.Error(), "cannot append to "+popConfig.Validate.File) {
+		t.Errorf("error %v, want the header mismatch reported", err)
+	}
+	popConfig.Checkpoint.File = filepath.Join(t.TempDir(), "checkpoint.jsonl")
+	popConfig.Checkpoint.Resume = true
+	err = run(context.Background(), constraints, microData, header, popConfig, config, runHooks{})
+	if err == nil || !strings.Contains(err.Error(), "either checkpoint resume or output append") {
+		t.Errorf("error %v, want append and resume refused together", err)
+	}
+}
@@ -0,0 +1,852 @@
+package synthpop
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+// Parquet support for large inputs and outputs, without external dependencies.
+//
+// The reader handles flat files as written by the common tools (pyarrow, Spark,
+// DuckDB, R arrow): columns of type BOOLEAN, INT32, INT64, FLOAT, DOUBLE or
+// BYTE_ARRAY, PLAIN or dictionary encoded, in v1 or v2 data pages, uncompressed or
+// compressed with SNAPPY or GZIP. Nested schemas, nulls and the other codecs (ZSTD,
+// LZ4, BROTLI) are rejected with an error naming the problem.
+//
+// The writer produces required columns, PLAIN encoded and uncompressed, which every
+// Parquet reader supports.
+
+// parquetMagic starts and ends every Parquet file
+const parquetMagic = "PAR1"
+
+// Parquet physical types
+const (
+	parquetBoolean   = 0
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetFloat     = 4
+	parquetDouble    = 5
+	parquetByteArray = 6
+)
+
+// Parquet page types, encodings, codecs and repetitions used here
+const (
+	parquetDataPage       = 0
+	parquetDictionaryPage = 2
+	parquetDataPageV2     = 3
+
+	parquetPlain         = 0
+	parquetPlainDict     = 2
+	parquetRLE           = 3
+	parquetRLEDictionary = 8
+	parquetUncompressed  = 0
+	parquetSnappy        = 1
+	parquetGzip          = 2
+	parquetRequired      = 0
+	parquetOptional      = 1
+	parquetConvertedUTF8 = 0
+)
+
+const (
+	parquetRowGroupRows   = 1 << 18 // Rows buffered per row group by the writer
+	parquetMaxFooterBytes = 1 << 28 // Larger footers are taken as corruption
+)
+
+// parquetValues holds decoded values of one physical kind: integers and booleans
+// in ints, floating point numbers in floats and byte arrays in strs
+type parquetValues struct {
+	ints   []int64
+	floats []float64
+	strs   []string
+}
+
+func (v *parquetValues) len() int {
+	return len(v.ints) + len(v.floats) + len(v.strs)
+}
+
+// float returns value i as a number
+func (v *parquetValues) float(i int) (float64, error) {
+	switch {
+	case v.ints != nil:
+		return float64(v.ints[i]), nil
+	case v.floats != nil:
+		return v.floats[i], nil
+	default:
+		return strconv.ParseFloat(v.strs[i], 64)
+	}
+}
+
+// string returns value i as text
+func (v *parquetValues) string(i int) string {
+	switch {
+	case v.ints != nil:
+		return strconv.FormatInt(v.ints[i], 10)
+	case v.floats != nil:
+		return strconv.FormatFloat(v.floats[i], 'f', -1, 64)
+	default:
+		return v.strs[i]
+	}
+}
+
+// gather looks the dictionary indices up in dict
+func (v *parquetValues) gather(dict *parquetValues, indices []uint32) error {
+	size := uint32(dict.len())
+	for _, i := range indices {
+		if i >= size {
+			return fmt.Errorf("dictionary index %d out of range", i)
+		}
+		switch {
+		case dict.ints != nil:
+			v.ints = append(v.ints, dict.ints[i])
+		case dict.floats != nil:
+			v.floats = append(v.floats, dict.floats[i])
+		default:
+			v.strs = append(v.strs, dict.strs[i])
+		}
+	}
+	return nil
+}
+
+// decodePlain decodes n PLAIN encoded values of a physical type
+func decodePlain(ptype int64, data []byte, n int) (parquetValues, error) {
+	var v parquetValues
+	short := fmt.Errorf("page holds fewer than %d values", n)
+	switch ptype {
+	case parquetBoolean:
+		if len(data)*8 < n {
+			return v, short
+		}
+		v.ints = make([]int64, n)
+		for i := range v.ints {
+			v.ints[i] = int64(data[i/8]>>(i%8)) & 1
+		}
+	case parquetInt32:
+		if len(data) < 4*n {
+			return v, short
+		}
+		v.ints = make([]int64, n)
+		for i := range v.ints {
+			v.ints[i] = int64(int32(binary.LittleEndian.Uint32(data[4*i:])))
+		}
+	case parquetInt64:
+		if len(data) < 8*n {
+			return v, short
+		}
+		v.ints = make([]int64, n)
+		for i := range v.ints {
+			v.ints[i] = int64(binary.LittleEndian.Uint64(data[8*i:]))
+		}
+	case parquetFloat:
+		if len(data) < 4*n {
+			return v, short
+		}
+		v.floats = make([]float64, n)
+		for i := range v.floats {
+			v.floats[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:])))
+		}
+	case parquetDouble:
+		if len(data) < 8*n {
+			return v, short
+		}
+		v.floats = make([]float64, n)
+		for i := range v.floats {
+			v.floats[i] = math.Float64frombits(binary.LittleEndian.Uint64(data[8*i:]))
+		}
+	case parquetByteArray:
+		v.strs = make([]string, n)
+		pos := 0
+		for i := range v.strs {
+			if pos+4 > len(data) {
+				return v, short
+			}
+			length := int(binary.LittleEndian.Uint32(data[pos:]))
+			pos += 4
+			if length < 0 || length > len(data)-pos {
+				return v, short
+			}
+			v.strs[i] = string(data[pos : pos+length])
+			pos += length
+		}
+	default:
+		return v, fmt.Errorf("unsupported physical type %d", ptype)
+	}
+	return v, nil
+}
+
+// decodeHybrid decodes n values of the RLE/bit-packing hybrid encoding used for
+// levels and dictionary indices
+func decodeHybrid(data []byte, bitWidth int, n int) ([]uint32, error) {
+	if bitWidth < 0 || bitWidth > 32 {
+		return nil, fmt.Errorf("invalid bit width %d", bitWidth)
+	}
+	out := make([]uint32, 0, n)
+	pos := 0
+	for len(out) < n {
+		header, k := binary.Uvarint(data[pos:])
+		if k <= 0 {
+			return nil, fmt.Errorf("truncated RLE data")
+		}
+		pos += k
+		if header&1 == 0 {
+			// RLE run: a repeat count and one value in ceil(bitWidth/8) bytes
+			count := header >> 1
+			width := (bitWidth + 7) / 8
+			if pos+width > len(data) {
+				return nil, fmt.Errorf("truncated RLE data")
+			}
+			var value uint32
+			for b := 0; b < width; b++ {
+				value |= uint32(data[pos+b]) << (8 * b)
+			}
+			pos += width
+			for c := uint64(0); c < count && len(out) < n; c++ {
+				out = append(out, value)
+			}
+			continue
+		}
+		// Bit-packed run: groups of 8 values, least significant bit first
+		count := int(header>>1) * 8
+		size := int(header>>1) * bitWidth
+		if size > len(data)-pos {
+			return nil, fmt.Errorf("truncated bit-packed data")
+		}
+		packed := data[pos : pos+size]
+		pos += size
+		for i := 0; i < count && len(out) < n; i++ {
+			var value uint32
+			for b := 0; b < bitWidth; b++ {
+				bit := i*bitWidth + b
+				value |= uint32(packed[bit/8]>>(bit%8)&1) << b
+			}
+			out = append(out, value)
+		}
+	}
+	return out, nil
+}
+
+// snappyDecode decompresses a snappy block (the raw format used by Parquet)
+func snappyDecode(src []byte) ([]byte, error) {
+	corrupt := errors.New("corrupt snappy data")
+	length, k := binary.Uvarint(src)
+	if k <= 0 || length > math.MaxInt32 {
+		return nil, corrupt
+	}
+	dst := make([]byte, 0, length)
+	for s := k; s < len(src); {
+		tag := src[s]
+		var n, offset int
+		switch tag & 3 {
+		case 0: // Literal
+			n = int(tag>>2) + 1
+			s++
+			if extra := n - 60; extra > 0 { // Length in the following 1-4 bytes
+				if s+extra > len(src) {
+					return nil, corrupt
+				}
+				n = 0
+				for b := 0; b < extra; b++ {
+					n |= int(src[s+b]) << (8 * b)
+				}
+				n++
+				s += extra
+			}
+			if n <= 0 || n > len(src)-s {
+				return nil, corrupt
+			}
+			dst = append(dst, src[s:s+n]...)
+			s += n
+			continue
+		case 1: // Copy with a 1-byte offset
+			if s+2 > len(src) {
+				return nil, corrupt
+			}
+			n = 4 + int(tag>>2)&7
+			offset = int(tag&0xe0)<<3 | int(src[s+1])
+			s += 2
+		case 2: // Copy with a 2-byte offset
+			if s+3 > len(src) {
+				return nil, corrupt
+			}
+			n = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[s+1:]))
+			s += 3
+		case 3: // Copy with a 4-byte offset
+			if s+5 > len(src) {
+				return nil, corrupt
+			}
+			n = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[s+1:]))
+			s += 5
+		}
+		if offset <= 0 || offset > len(dst) || uint64(len(dst)+n) > length {
+			return nil, corrupt
+		}
+		for i := 0; i < n; i++ { // Byte by byte, copies may overlap their output
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	if uint64(len(dst)) != length {
+		return nil, corrupt
+	}
+	return dst, nil
+}
+
+// decompressPage expands a page body compressed with codec
+func decompressPage(codec int64, data []byte) ([]byte, error) {
+	switch codec {
+	case parquetUncompressed:
+		return data, nil
+	case parquetSnappy:
+		return snappyDecode(data)
+	case parquetGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	default:
+		return nil, fmt.Errorf("unsupported compression codec %d, rewrite the file with SNAPPY, GZIP or no compression", codec)
+	}
+}
+
+// parquetLeaf is one column of a flat schema
+type parquetLeaf struct {
+	name     string
+	ptype    int64
+	optional bool
+}
+
+// parquetFile is an open Parquet file with its decoded metadata
+type parquetFile struct {
+	file    *os.File
+	leaves  []parquetLeaf
+	groups  []thriftFields
+	numRows int64
+}
+
+// openParquet opens path and decodes its footer
+func openParquet(path string) (*parquetFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	pf := &parquetFile{file: file}
+	if err := pf.readFooter(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return pf, nil
+}
+
+func (pf *parquetFile) readFooter() error {
+	info, err := pf.file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	tail := make([]byte, 8)
+	head := make([]byte, 4)
+	if size < 12 {
+		return fmt.Errorf("not a Parquet file")
+	}
+	if _, err := pf.file.ReadAt(head, 0); err != nil {
+		return err
+	}
+	if _, err := pf.file.ReadAt(tail, size-8); err != nil {
+		return err
+	}
+	if string(head) != parquetMagic || string(tail[4:]) != parquetMagic {
+		if string(tail[4:]) == "PARE" {
+			return fmt.Errorf("encrypted Parquet files are not supported")
+		}
+		return fmt.Errorf("not a Parquet file")
+	}
+	footerLen := int64(binary.LittleEndian.Uint32(tail))
+	if footerLen > size-12 || footerLen > parquetMaxFooterBytes {
+		return fmt.Errorf("invalid footer length %d", footerLen)
+	}
+	footer := make([]byte, footerLen)
+	if _, err := pf.file.ReadAt(footer, size-8-footerLen); err != nil {
+		return err
+	}
+	r := &thriftReader{data: footer}
+	meta, err := r.readStruct(0)
+	if err != nil {
+		return fmt.Errorf("invalid metadata: %w", err)
+	}
+
+	// FileMetaData: 2 schema, 3 num_rows, 4 row_groups
+	schema := meta.list(2)
+	if len(schema) == 0 {
+		return fmt.Errorf("missing schema")
+	}
+	for _, e := range schema[1:] {
+		// SchemaElement: 1 type, 3 repetition_type, 4 name, 5 num_children
+		element, _ := e.(thriftFields)
+		name := element.str(4)
+		if element.int(5) > 0 {
+			return fmt.Errorf("column %s: nested schemas are not supported", name)
+		}
+		if !element.has(1) {
+			return fmt.Errorf("column %s has no physical type", name)
+		}
+		switch element.int(3) {
+		case parquetRequired, parquetOptional:
+		default:
+			return fmt.Errorf("column %s: repeated columns are not supported", name)
+		}
+		pf.leaves = append(pf.leaves, parquetLeaf{
+			name:     name,
+			ptype:    element.int(1),
+			optional: element.int(3) == parquetOptional,
+		})
+	}
+	pf.numRows = meta.int(3)
+	for _, g := range meta.list(4) {
+		group, _ := g.(thriftFields)
+		if len(group.list(1)) != len(pf.leaves) {
+			return fmt.Errorf("row group has %d columns, the schema %d", len(group.list(1)), len(pf.leaves))
+		}
+		pf.groups = append(pf.groups, group)
+	}
+	return nil
+}
+
+func (pf *parquetFile) Close() error {
+	return pf.file.Close()
+}
+
+// chunkSize returns the stored size of column col of row group g
+func (pf *parquetFile) chunkSize(g, col int) int64 {
+	chunk, _ := pf.groups[g].list(1)[col].(thriftFields)
+	return chunk.child(3).int(7)
+}
+
+// readChunk decodes column col of row group g
+func (pf *parquetFile) readChunk(g, col int) (parquetValues, error) {
+	leaf := pf.leaves[col]
+	chunk, _ := pf.groups[g].list(1)[col].(thriftFields)
+	if chunk.str(1) != "" {
+		return parquetValues{}, fmt.Errorf("column %s is stored in external file %s", leaf.name, chunk.str(1))
+	}
+	// ColumnMetaData: 4 codec, 5 num_values, 7 total_compressed_size,
+	// 9 data_page_offset, 11 dictionary_page_offset
+	meta := chunk.child(3)
+	if meta == nil {
+		return parquetValues{}, fmt.Errorf("column %s has no metadata", leaf.name)
+	}
+	start := meta.int(9)
+	if dict := meta.int(11); meta.has(11) && dict > 0 && dict < start {
+		start = dict
+	}
+	size := meta.int(7)
+	if size < 0 || size > math.MaxInt32 {
+		return parquetValues{}, fmt.Errorf("column %s: invalid chunk size %d", leaf.name, size)
+	}
+	buf := make([]byte, size)
+	if _, err := pf.file.ReadAt(buf, start); err != nil {
+		return parquetValues{}, fmt.Errorf("column %s: %w", leaf.name, err)
+	}
+
+	codec := meta.int(4)
+	total := int(meta.int(5))
+	var values, dict parquetValues
+	haveDict := false
+	for pos := 0; values.len() < total; {
+		if pos >= len(buf) {
+			return values, fmt.Errorf("column %s: chunk ends after %d of %d values", leaf.name, values.len(), total)
+		}
+		r := &thriftReader{data: buf[pos:]}
+		// PageHeader: 1 type, 2 uncompressed_page_size, 3 compressed_page_size,
+		// 5 data_page_header, 7 dictionary_page_header, 8 data_page_header_v2
+		header, err := r.readStruct(0)
+		if err != nil {
+			return values, fmt.Errorf("column %s: invalid page header: %w", leaf.name, err)
+		}
+		pos += r.pos
+		compressed := int(header.int(3))
+		if compressed < 0 || compressed > len(buf)-pos {
+			return values, fmt.Errorf("column %s: truncated page", leaf.name)
+		}
+		body := buf[pos : pos+compressed]
+		pos += compressed
+
+		switch header.int(1) {
+		case parquetDictionaryPage:
+			data, err := decompressPage(codec, body)
+			if err != nil {
+				return values, fmt.Errorf("column %s: %w", leaf.name, err)
+			}
+			if dict, err = decodePlain(leaf.ptype, data, int(header.child(7).int(1))); err != nil {
+				return values, fmt.Errorf("column %s dictionary: %w", leaf.name, err)
+			}
+			haveDict = true
+
+		case parquetDataPage:
+			// DataPageHeader: 1 num_values, 2 encoding
+			page := header.child(5)
+			data, err := decompressPage(codec, body)
+			if err != nil {
+				return values, fmt.Errorf("column %s: %w", leaf.name, err)
+			}
+			n := int(page.int(1))
+			if leaf.optional {
+				// Definition levels, prefixed by their length; a level of 0 is a null
+				if len(data) < 4 {
+					return values, fmt.Errorf("column %s: truncated page", leaf.name)
+				}
+				levelsLen := int(binary.LittleEndian.Uint32(data))
+				if levelsLen < 0 || levelsLen > len(data)-4 {
+					return values, fmt.Errorf("column %s: truncated definition levels", leaf.name)
+				}
+				levels, err := decodeHybrid(data[4:4+levelsLen], 1, n)
+				if err != nil {
+					return values, fmt.Errorf("column %s: %w", leaf.name, err)
+				}
+				for _, level := range levels {
+					if level == 0 {
+						return values, fmt.Errorf("column %s has missing values, fill them before loading", leaf.name)
+					}
+				}
+				data = data[4+levelsLen:]
+			}
+			if err := decodePage(&values, leaf.ptype, page.int(2), data, n, &dict, haveDict); err != nil {
+				return values, fmt.Errorf("column %s: %w", leaf.name, err)
+			}
+
+		case parquetDataPageV2:
+			// DataPageHeaderV2: 1 num_values, 2 num_nulls, 4 encoding,
+			// 5 definition_levels_byte_length, 6 repetition_levels_byte_length,
+			// 7 is_compressed (default true). Levels are never compressed.
+			page := header.child(8)
+			if page.int(2) > 0 {
+				return values, fmt.Errorf("column %s has missing values, fill them before loading", leaf.name)
+			}
+			levels := int(page.int(5) + page.int(6))
+			if levels < 0 || levels > len(body) {
+				return values, fmt.Errorf("column %s: truncated levels", leaf.name)
+			}
+			data := body[levels:]
+			if !page.has(7) || page.bool(7) {
+				if data, err = decompressPage(codec, data); err != nil {
+					return values, fmt.Errorf("column %s: %w", leaf.name, err)
+				}
+			}
+			if err := decodePage(&values, leaf.ptype, page.int(4), data, int(page.int(1)), &dict, haveDict); err != nil {
+				return values, fmt.Errorf("column %s: %w", leaf.name, err)
+			}
+		}
+	}
+	return values, nil
+}
+
+// decodePage appends the n values of a data page to values
+func decodePage(values *parquetValues, ptype, encoding int64, data []byte, n int, dict *parquetValues, haveDict bool) error {
+	switch encoding {
+	case parquetPlain:
+		page, err := decodePlain(ptype, data, n)
+		if err != nil {
+			return err
+		}
+		values.ints = append(values.ints, page.ints...)
+		values.floats = append(values.floats, page.floats...)
+		values.strs = append(values.strs, page.strs...)
+		return nil
+	case parquetPlainDict, parquetRLEDictionary:
+		if !haveDict {
+			return fmt.Errorf("dictionary encoded page without a dictionary")
+		}
+		if len(data) == 0 {
+			return fmt.Errorf("truncated page")
+		}
+		indices, err := decodeHybrid(data[1:], int(data[0]), n)
+		if err != nil {
+			return err
+		}
+		return values.gather(dict, indices)
+	default:
+		return fmt.Errorf("unsupported encoding %d, rewrite the file with PLAIN or dictionary encoding", encoding)
+	}
+}
+
+// readParquetRecords reads a flat Parquet table whose first column identifies the
+// record and whose other columns are numeric.
+//
+// Returns:
+//   - header: All column names
+//   - ids: The first column as text
+//   - values: Per row, the other columns
+//   - error: Any read, format or conversion error
+func readParquetRecords(ctx context.Context, filename string, progress chan<- LoadProgress) ([]string, []string, [][]float64, error) {
+	pf, err := openParquet(filename)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer pf.Close()
+	info, err := pf.file.Stat()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	tracker := newLoadTracker(ctx, filename, info.Size(), progress)
+	defer tracker.finish()
+	if len(pf.leaves) < 2 {
+		return nil, nil, nil, fmt.Errorf("%s: needs an ID column and at least one value column", filename)
+	}
+
+	header := make([]string, len(pf.leaves))
+	for i, leaf := range pf.leaves {
+		header[i] = leaf.name
+	}
+	width := len(pf.leaves) - 1
+	ids := make([]string, 0, pf.numRows)
+	flat := make([]float64, 0, pf.numRows*int64(width)) // One backing array for all rows
+	for g := range pf.groups {
+		if err := tracker.err(); err != nil {
+			return nil, nil, nil, err
+		}
+		groupStart := len(ids)
+		for col := range pf.leaves {
+			values, err := pf.readChunk(g, col)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("%s: %w", filename, err)
+			}
+			tracker.bytes.Add(pf.chunkSize(g, col))
+			if col == 0 {
+				for i := 0; i < values.len(); i++ {
+					ids = append(ids, values.string(i))
+				}
+				flat = append(flat, make([]float64, values.len()*width)...)
+				continue
+			}
+			if values.len() != len(ids)-groupStart {
+				return nil, nil, nil, fmt.Errorf("%s: column %s has %d values, %s has %d",
+					filename, header[col], values.len(), header[0], len(ids)-groupStart)
+			}
+			for i := 0; i < values.len(); i++ {
+				v, err := values.float(i)
+				if err != nil {
+					return nil, nil, nil, fmt.Errorf("%s row %d, column %s: %w", filename, groupStart+i+1, header[col], err)
+				}
+				flat[(groupStart+i)*width+col-1] = v
+			}
+		}
+		tracker.rows.Store(int64(len(ids)))
+	}
+
+	rows := make([][]float64, len(ids))
+	for i := range rows {
+		rows[i] = flat[i*width : (i+1)*width : (i+1)*width]
+	}
+	return header, ids, rows, nil
+}
+
+// ReadConstraintParquet reads constraints from a Parquet file laid out like the
+// constraints CSV: area ID, total, then one column per variable
+func ReadConstraintParquet(filename string) ([]ConstraintData, []string, error) {
+	return readConstraintParquet(context.Background(), filename, nil)
+}
+
+func readConstraintParquet(ctx context.Context, filename string, progress chan<- LoadProgress) ([]ConstraintData, []string, error) {
+	header, ids, rows, err := readParquetRecords(ctx, filename, progress)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(header) < 3 {
+		return nil, nil, fmt.Errorf("%s: needs area, total and variable columns", filename)
+	}
+	data := make([]ConstraintData, len(ids))
+	for i, id := range ids {
+		data[i] = ConstraintData{ID: id, Values: rows[i][1:], Total: rows[i][0]}
+	}
+	return data, header[2:], nil
+}
+
+// ReadMicroDataParquet reads microdata from a Parquet file laid out like the
+// microdata CSV: record ID, then one column per variable
+func ReadMicroDataParquet(filename string) ([]MicroData, []string, error) {
+	return readMicroDataParquet(context.Background(), filename, nil)
+}
+
+func readMicroDataParquet(ctx context.Context, filename string, progress chan<- LoadProgress) ([]MicroData, []string, error) {
+	header, ids, rows, err := readParquetRecords(ctx, filename, progress)
+	if err != nil {
+		return nil, nil, err
+	}
+	data := make([]MicroData, len(ids))
+	for i, id := range ids {
+		data[i] = MicroData{ID: id, Values: rows[i]}
+	}
+	return data, header[1:], nil
+}
+
+// parquetChunk records where a column chunk was written
+type parquetChunk struct {
+	offset int64
+	size   int64
+}
+
+// parquetRowGroup records a written row group
+type parquetRowGroup struct {
+	rows   int64
+	chunks []parquetChunk
+}
+
+// parquetWriter writes a flat table of required columns, buffering one row group
+type parquetWriter struct {
+	out    *outputFile
+	names  []string
+	types  []int32  // Physical type of every column
+	pages  [][]byte // PLAIN encoded values of the current row group, per column
+	rows   int64    // Rows in the current row group
+	groups []parquetRowGroup
+}
+
+// newParquetWriter creates a Parquet output with the given columns and types
+// (parquetByteArray, parquetDouble or parquetInt64)
+func newParquetWriter(path string, names []string, types []int32, key []byte, retry retryPolicy) (*parquetWriter, error) {
+	out, err := createOutput(path, key, retry)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := out.WriteString(parquetMagic); err != nil {
+		out.Close()
+		return nil, err
+	}
+	return &parquetWriter{out: out, names: names, types: types, pages: make([][]byte, len(names))}, nil
+}
+
+func (w *parquetWriter) appendString(col int, s string) {
+	w.pages[col] = binary.LittleEndian.AppendUint32(w.pages[col], uint32(len(s)))
+	w.pages[col] = append(w.pages[col], s...)
+}
+
+func (w *parquetWriter) appendDouble(col int, v float64) {
+	w.pages[col] = binary.LittleEndian.AppendUint64(w.pages[col], math.Float64bits(v))
+}
+
+func (w *parquetWriter) appendInt64(col int, v int64) {
+	w.pages[col] = binary.LittleEndian.AppendUint64(w.pages[col], uint64(v))
+}
+
+// endRow completes a row whose columns were all appended, flushing full row groups
+func (w *parquetWriter) endRow() error {
+	w.rows++
+	if w.rows >= parquetRowGroupRows {
+		return w.flushRowGroup()
+	}
+	return nil
+}
+
+// flushRowGroup writes the buffered rows as one data page per column
+func (w *parquetWriter) flushRowGroup() error {
+	if w.rows == 0 {
+		return nil
+	}
+	group := parquetRowGroup{rows: w.rows}
+	for col, page := range w.pages {
+		header := &thriftWriter{}
+		header.beginStruct()
+		header.i32Field(1, parquetDataPage)
+		header.i32Field(2, int32(len(page)))
+		header.i32Field(3, int32(len(page)))
+		header.structField(5)
+		header.i32Field(1, int32(w.rows))
+		header.i32Field(2, parquetPlain)
+		header.i32Field(3, parquetRLE)
+		header.i32Field(4, parquetRLE)
+		header.endStruct()
+		header.endStruct()
+
+		chunk := parquetChunk{offset: w.out.offset(), size: int64(len(header.buf) + len(page))}
+		if _, err := w.out.Write(header.buf); err != nil {
+			return err
+		}
+		if _, err := w.out.Write(page); err != nil {
+			return err
+		}
+		group.chunks = append(group.chunks, chunk)
+		w.pages[col] = page[:0]
+	}
+	w.groups = append(w.groups, group)
+	w.rows = 0
+	return nil
+}
+
+// Close writes the last row group and the footer, then closes the file
+func (w *parquetWriter) Close() error {
+	if err := w.flushRowGroup(); err != nil {
+		w.out.Close()
+		return err
+	}
+
+	var total int64
+	for _, g := range w.groups {
+		total += g.rows
+	}
+	meta := &thriftWriter{}
+	meta.beginStruct()
+	meta.i32Field(1, 1) // version
+	meta.listField(2, thriftStruct, len(w.names)+1)
+	meta.beginStruct() // Schema root
+	meta.stringField(4, "schema")
+	meta.i32Field(5, int32(len(w.names)))
+	meta.endStruct()
+	for col, name := range w.names {
+		meta.beginStruct()
+		meta.i32Field(1, w.types[col])
+		meta.i32Field(3, parquetRequired)
+		meta.stringField(4, name)
+		if w.types[col] == parquetByteArray {
+			meta.i32Field(6, parquetConvertedUTF8)
+		}
+		meta.endStruct()
+	}
+	meta.i64Field(3, total)
+	meta.listField(4, thriftStruct, len(w.groups))
+	for _, g := range w.groups {
+		meta.beginStruct()
+		meta.listField(1, thriftStruct, len(g.chunks))
+		var groupBytes int64
+		for col, chunk := range g.chunks {
+			groupBytes += chunk.size
+			meta.beginStruct()
+			meta.i64Field(2, chunk.offset) // file_offset
+			meta.structField(3)            // ColumnMetaData
+			meta.i32Field(1, w.types[col])
+			meta.listField(2, thriftI32, 2)
+			meta.i32Elem(parquetPlain)
+			meta.i32Elem(parquetRLE)
+			meta.listField(3, thriftBinary, 1)
+			meta.stringElem(w.names[col])
+			meta.i32Field(4, parquetUncompressed)
+			meta.i64Field(5, g.rows)
+			meta.i64Field(6, chunk.size)
+			meta.i64Field(7, chunk.size)
+			meta.i64Field(9, chunk.offset)
+			meta.endStruct()
+			meta.endStruct()
+		}
+		meta.i64Field(2, groupBytes)
+		meta.i64Field(3, g.rows)
+		meta.endStruct()
+	}
+	meta.stringField(6, "GoSynthPop")
+	meta.endStruct()
+
+	footer := binary.LittleEndian.AppendUint32(meta.buf, uint32(len(meta.buf)))
+	footer = append(footer, parquetMagic...)
+	if _, err := w.out.Write(footer); err != nil {
+		w.out.Close()
+		return err
+	}
+	return w.out.Close()
+}
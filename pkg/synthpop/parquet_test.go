@@ -0,0 +1,432 @@
+package synthpop
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParquetRoundTrip(t *testing.T) {
+	// More rows than a row group, so the reader joins several
+	rows := parquetRowGroupRows + 1000
+	path := filepath.Join(t.TempDir(), "constraints.parquet")
+	w, err := newParquetWriter(path, []string{"area", "total", "age", "count"},
+		[]int32{parquetByteArray, parquetDouble, parquetDouble, parquetInt64}, nil, retryPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range rows {
+		w.appendString(0, "E"+strconv.Itoa(i))
+		w.appendDouble(1, float64(i%500)+0.25)
+		w.appendDouble(2, -float64(i)/3)
+		w.appendInt64(3, int64(i)<<33)
+		if err := w.endRow(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	constraints, header, err := ReadConstraintParquet(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(header, ",") != "age,count" {
+		t.Errorf("header %v, want [age count]", header)
+	}
+	if len(constraints) != rows {
+		t.Fatalf("read %d areas, wrote %d", len(constraints), rows)
+	}
+	for i, c := range constraints {
+		want := ConstraintData{ID: "E" + strconv.Itoa(i), Total: float64(i%500) + 0.25,
+			Values: []float64{-float64(i) / 3, float64(int64(i) << 33)}}
+		if c.ID != want.ID || c.Total != want.Total || c.Values[0] != want.Values[0] || c.Values[1] != want.Values[1] {
+			t.Fatalf("area %d read as %+v, written as %+v", i, c, want)
+		}
+	}
+}
+
+func TestParquetTotalsOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "validate.parquet")
+	w, err := newParquetTotalsWriter(path, []string{"male", "female"}, nil, retryPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := []Result{
+		{Area: "E01", Totals: []float64{12, 30}, BestIteration: 400},
+		{Area: "E02", Totals: []float64{0, 7.5}, BestIteration: 0},
+	}
+	for _, res := range results {
+		if err := w.writeArea(res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, header, err := ReadMicroDataParquet(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(header, ",") != "male,female,best_iteration" {
+		t.Errorf("header %v", header)
+	}
+	for i, res := range results {
+		want := append(append([]float64(nil), res.Totals...), float64(res.BestIteration))
+		if records[i].ID != res.Area || !slices.Equal(records[i].Values, want) {
+			t.Errorf("row %d read as %+v, want %s %v", i, records[i], res.Area, want)
+		}
+	}
+}
+
+// testParquetColumn is a column chunk of a hand-made Parquet file: its schema and
+// its pages, headers included
+type testParquetColumn struct {
+	name     string
+	ptype    int32
+	optional bool
+	children int32 // Set to make the column a group, which the reader rejects
+	codec    int32
+	values   int64
+	pages    []byte
+}
+
+// testParquetPage returns a page header of the given type followed by body.
+// Data pages are PLAIN or dictionary encoded; v2 pages take levels bytes of
+// definition levels from the start of body.
+func testParquetPage(pageType int32, n, encoding int32, body []byte, uncompressed, levels int) []byte {
+	h := &thriftWriter{}
+	h.beginStruct()
+	h.i32Field(1, pageType)
+	h.i32Field(2, int32(uncompressed))
+	h.i32Field(3, int32(len(body)))
+	switch pageType {
+	case parquetDataPage:
+		h.structField(5)
+		h.i32Field(1, n)
+		h.i32Field(2, encoding)
+		h.i32Field(3, parquetRLE)
+		h.i32Field(4, parquetRLE)
+		h.endStruct()
+	case parquetDictionaryPage:
+		h.structField(7)
+		h.i32Field(1, n)
+		h.i32Field(2, parquetPlain)
+		h.endStruct()
+	case parquetDataPageV2:
+		h.structField(8)
+		h.i32Field(1, n)
+		h.i32Field(2, 0)
+		h.i32Field(3, n)
+		h.i32Field(4, encoding)
+		h.i32Field(5, int32(levels))
+		h.i32Field(6, 0)
+		h.endStruct()
+	}
+	h.endStruct()
+	return append(h.buf, body...)
+}
+
+// writeTestParquet writes a file of one row group holding columns
+func writeTestParquet(t *testing.T, rows int64, columns ...testParquetColumn) string {
+	t.Helper()
+	file := []byte(parquetMagic)
+	offsets := make([]int64, len(columns))
+	for i, c := range columns {
+		offsets[i] = int64(len(file))
+		file = append(file, c.pages...)
+	}
+
+	meta := &thriftWriter{}
+	meta.beginStruct()
+	meta.i32Field(1, 1)
+	meta.listField(2, thriftStruct, len(columns)+1)
+	meta.beginStruct()
+	meta.stringField(4, "schema")
+	meta.i32Field(5, int32(len(columns)))
+	meta.endStruct()
+	for _, c := range columns {
+		meta.beginStruct()
+		meta.i32Field(1, c.ptype)
+		repetition := int32(parquetRequired)
+		if c.optional {
+			repetition = parquetOptional
+		}
+		meta.i32Field(3, repetition)
+		meta.stringField(4, c.name)
+		if c.children > 0 {
+			meta.i32Field(5, c.children)
+		}
+		meta.endStruct()
+	}
+	meta.i64Field(3, rows)
+	meta.listField(4, thriftStruct, 1)
+	meta.beginStruct()
+	meta.listField(1, thriftStruct, len(columns))
+	for i, c := range columns {
+		meta.beginStruct()
+		meta.i64Field(2, offsets[i])
+		meta.structField(3)
+		meta.i32Field(1, c.ptype)
+		meta.listField(2, thriftI32, 1)
+		meta.i32Elem(parquetPlain)
+		meta.listField(3, thriftBinary, 1)
+		meta.stringElem(c.name)
+		meta.i32Field(4, c.codec)
+		meta.i64Field(5, c.values)
+		meta.i64Field(6, int64(len(c.pages)))
+		meta.i64Field(7, int64(len(c.pages)))
+		meta.i64Field(9, offsets[i])
+		meta.endStruct()
+		meta.endStruct()
+	}
+	meta.i64Field(3, rows)
+	meta.endStruct()
+	meta.endStruct()
+
+	file = append(file, meta.buf...)
+	file = binary.LittleEndian.AppendUint32(file, uint32(len(meta.buf)))
+	file = append(file, parquetMagic...)
+	path := filepath.Join(t.TempDir(), "test.parquet")
+	if err := os.WriteFile(path, file, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Plain encodings of the test columns
+func plainStrings(values ...string) []byte {
+	var b []byte
+	for _, s := range values {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(s)))
+		b = append(b, s...)
+	}
+	return b
+}
+
+func plainDoubles(values ...float64) []byte {
+	var b []byte
+	for _, v := range values {
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+	}
+	return b
+}
+
+func plainInt64s(values ...int64) []byte {
+	var b []byte
+	for _, v := range values {
+		b = binary.LittleEndian.AppendUint64(b, uint64(v))
+	}
+	return b
+}
+
+func plainInt32s(values ...int32) []byte {
+	var b []byte
+	for _, v := range values {
+		b = binary.LittleEndian.AppendUint32(b, uint32(v))
+	}
+	return b
+}
+
+func plainFloats(values ...float32) []byte {
+	var b []byte
+	for _, v := range values {
+		b = binary.LittleEndian.AppendUint32(b, math.Float32bits(v))
+	}
+	return b
+}
+
+// gzipped compresses data with gzip
+func gzipped(t *testing.T, data []byte) []byte {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// snappyLiteral returns data as a snappy block of one literal of up to 60 bytes
+func snappyLiteral(data []byte) []byte {
+	b := binary.AppendUvarint(nil, uint64(len(data)))
+	b = append(b, byte(len(data)-1)<<2)
+	return append(b, data...)
+}
+
+// TestParquetReaderEncodings reads a hand-made file with the encodings, codecs and
+// page versions the writer does not produce
+func TestParquetReaderEncodings(t *testing.T) {
+	ids := plainStrings("a", "b", "c", "d")
+	dictionary := plainDoubles(1.5, 2.5)
+	indices := []byte{1, 3, 0x0d} // Bit width 1, one bit-packed group: 1, 0, 1, 1
+	counts := plainInt64s(10, 20, 30, 40)
+	codes := plainInt32s(-1, 0, 7, 1<<30)
+	shares := plainFloats(0.5, 0.25, 2, 8)
+	levels := []byte{4 << 1, 1} // RLE run of four definition levels of 1
+	flags := []byte{0x09}       // true, false, false, true
+	flagsPage := append(binary.LittleEndian.AppendUint32(nil, uint32(len(levels))), levels...)
+	flagsPage = append(flagsPage, flags...)
+
+	path := writeTestParquet(t, 4,
+		testParquetColumn{name: "id", ptype: parquetByteArray, values: 4,
+			pages: testParquetPage(parquetDataPage, 4, parquetPlain, ids, len(ids), 0)},
+		testParquetColumn{name: "dict", ptype: parquetDouble, values: 4,
+			pages: append(testParquetPage(parquetDictionaryPage, 2, parquetPlain, dictionary, len(dictionary), 0),
+				testParquetPage(parquetDataPage, 4, parquetRLEDictionary, indices, len(indices), 0)...)},
+		testParquetColumn{name: "gzip", ptype: parquetInt64, codec: parquetGzip, values: 4,
+			pages: testParquetPage(parquetDataPage, 4, parquetPlain, gzipped(t, counts), len(counts), 0)},
+		testParquetColumn{name: "snappy", ptype: parquetInt32, codec: parquetSnappy, values: 4,
+			pages: testParquetPage(parquetDataPage, 4, parquetPlain, snappyLiteral(codes), len(codes), 0)},
+		testParquetColumn{name: "v2", ptype: parquetFloat, optional: true, values: 4,
+			pages: testParquetPage(parquetDataPageV2, 4, parquetPlain, append(append([]byte(nil), levels...), shares...),
+				len(levels)+len(shares), len(levels))},
+		testParquetColumn{name: "flag", ptype: parquetBoolean, optional: true, values: 4,
+			pages: testParquetPage(parquetDataPage, 4, parquetPlain, flagsPage, len(flagsPage), 0)},
+	)
+
+	records, header, err := ReadMicroDataParquet(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(header, ",") != "dict,gzip,snappy,v2,flag" {
+		t.Errorf("header %v", header)
+	}
+	want := []MicroData{
+		{ID: "a", Values: []float64{2.5, 10, -1, 0.5, 1}},
+		{ID: "b", Values: []float64{1.5, 20, 0, 0.25, 0}},
+		{ID: "c", Values: []float64{2.5, 30, 7, 2, 0}},
+		{ID: "d", Values: []float64{2.5, 40, 1 << 30, 8, 1}},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("read %d records, want %d", len(records), len(want))
+	}
+	for i := range want {
+		if records[i].ID != want[i].ID || !slices.Equal(records[i].Values, want[i].Values) {
+			t.Errorf("record %d read as %+v, want %+v", i, records[i], want[i])
+		}
+	}
+}
+
+func TestParquetReaderErrors(t *testing.T) {
+	ids := plainStrings("a", "b")
+	values := plainDoubles(1, 2)
+	idColumn := testParquetColumn{name: "id", ptype: parquetByteArray, values: 2,
+		pages: testParquetPage(parquetDataPage, 2, parquetPlain, ids, len(ids), 0)}
+	nulls := append(binary.LittleEndian.AppendUint32(nil, 2), 1<<1|1, 0x01) // One bit-packed group: levels 1, 0
+	nulls = append(nulls, plainDoubles(1)...)
+
+	write := func(content []byte) string {
+		path := filepath.Join(t.TempDir(), "bad.parquet")
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"not Parquet", write([]byte("id,value\na,1\nb,2\n")), "not a Parquet file"},
+		{"too short", write([]byte("PAR1PAR1")), "not a Parquet file"},
+		{"encrypted", write([]byte("PAR1\x00\x00\x00\x00\x04\x00\x00\x00PARE")), "encrypted"},
+		{"footer too long", write([]byte("PAR1\x00\x00\x00\x00\xff\x00\x00\x00PAR1")), "invalid footer length"},
+		{"nested", writeTestParquet(t, 2, idColumn,
+			testParquetColumn{name: "group", ptype: parquetDouble, children: 2, values: 2}), "nested schemas"},
+		{"null", writeTestParquet(t, 2, idColumn,
+			testParquetColumn{name: "value", ptype: parquetDouble, optional: true, values: 2,
+				pages: testParquetPage(parquetDataPage, 2, parquetPlain, nulls, len(nulls), 0)}), "missing values"},
+		{"codec", writeTestParquet(t, 2, idColumn,
+			testParquetColumn{name: "value", ptype: parquetDouble, codec: 6, values: 2,
+				pages: testParquetPage(parquetDataPage, 2, parquetPlain, values, len(values), 0)}), "unsupported compression codec"},
+		{"encoding", writeTestParquet(t, 2, idColumn,
+			testParquetColumn{name: "value", ptype: parquetDouble, values: 2,
+				pages: testParquetPage(parquetDataPage, 2, 5, values, len(values), 0)}), "unsupported encoding"},
+		{"dictionary missing", writeTestParquet(t, 2, idColumn,
+			testParquetColumn{name: "value", ptype: parquetDouble, values: 2,
+				pages: testParquetPage(parquetDataPage, 2, parquetRLEDictionary, []byte{1, 3, 1}, 3, 0)}), "without a dictionary"},
+		{"short page", writeTestParquet(t, 2, idColumn,
+			testParquetColumn{name: "value", ptype: parquetDouble, values: 2,
+				pages: testParquetPage(parquetDataPage, 2, parquetPlain, values[:12], 12, 0)}), "fewer than 2 values"},
+		{"short chunk", writeTestParquet(t, 2, idColumn,
+			testParquetColumn{name: "value", ptype: parquetDouble, values: 3,
+				pages: testParquetPage(parquetDataPage, 2, parquetPlain, values, len(values), 0)}), "chunk ends after 2 of 3 values"},
+		{"corrupt snappy", writeTestParquet(t, 2, idColumn,
+			testParquetColumn{name: "value", ptype: parquetDouble, codec: parquetSnappy, values: 2,
+				pages: testParquetPage(parquetDataPage, 2, parquetPlain, []byte{16, 0x01, 0x10}, 16, 0)}), "corrupt snappy data"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := ReadMicroDataParquet(tt.path)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestThriftRoundTrip(t *testing.T) {
+	w := &thriftWriter{}
+	w.beginStruct()
+	w.i32Field(1, -7)
+	w.i64Field(3, math.MaxInt64)
+	w.stringField(4, "name")
+	w.structField(20) // A field ID delta over 15
+	w.i32Field(1, 1<<30)
+	w.endStruct()
+	w.listField(21, thriftI32, 20) // A list length over 14
+	for i := range 20 {
+		w.i32Elem(int32(i - 10))
+	}
+	w.listField(22, thriftBinary, 2)
+	w.stringElem("x")
+	w.stringElem("")
+	w.listField(23, thriftStruct, 1)
+	w.beginStruct()
+	w.i64Field(2, math.MinInt64)
+	w.endStruct()
+	w.endStruct()
+
+	r := &thriftReader{data: w.buf}
+	fields, err := r.readStruct(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.pos != len(w.buf) {
+		t.Errorf("read %d of %d bytes", r.pos, len(w.buf))
+	}
+	if fields.int(1) != -7 || fields.int(3) != math.MaxInt64 || fields.str(4) != "name" || fields.child(20).int(1) != 1<<30 {
+		t.Errorf("scalar fields read as %v", fields)
+	}
+	list := fields.list(21)
+	if len(list) != 20 || list[0] != int64(-10) || list[19] != int64(9) {
+		t.Errorf("list read as %v", list)
+	}
+	if strs := fields.list(22); len(strs) != 2 || string(strs[0].([]byte)) != "x" || len(strs[1].([]byte)) != 0 {
+		t.Errorf("string list read as %v", strs)
+	}
+	if structs := fields.list(23); len(structs) != 1 || structs[0].(thriftFields).int(2) != math.MinInt64 {
+		t.Errorf("struct list read as %v", structs)
+	}
+	if fields.has(2) || fields.int(2) != 0 || fields.str(2) != "" || fields.child(2) != nil {
+		t.Error("absent field 2 reads as set")
+	}
+
+	for n := range len(w.buf) {
+		if _, err := (&thriftReader{data: w.buf[:n]}).readStruct(0); err == nil {
+			t.Fatalf("struct truncated to %d of %d bytes read without error", n, len(w.buf))
+		}
+	}
+}
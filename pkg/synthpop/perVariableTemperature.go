@@ -0,0 +1,94 @@
+package synthpop
+
+import (
+	"math"
+	"math/rand"
+)
+
+// In the experimental per-variable temperature mode each constraint variable i has
+// its own temperature temp*scale[i]. A move changes the absolute error of every
+// variable it touches, and its energy is the sum of those error changes divided by
+// the variable temperatures. Variables already matching their constraint cool
+// faster, so once they are fitted they effectively stop accepting worse moves while
+// variables that differ in scale or difficulty keep exploring.
+
+// resetTemperatureScales returns the per-variable scales for a new area, all set to 1
+func resetTemperatureScales(scales []float64, n int) []float64 {
+	scales = floatBuffer(scales, n)
+	for i := range scales {
+		scales[i] = 1
+	}
+	return scales
+}
+
+// coolVariables applies an extra cooling step to every variable that matches its
+// constraint and warms unmatched ones back towards the global temperature
+//
+// Parameters:
+//   - scales: Per-variable temperature scales, updated in place
+//   - constraints: The area constraint values
+//   - synthPopTotals: Current aggregate statistics
+//   - coolingRate: The global cooling rate
+func coolVariables(scales, constraints, synthPopTotals []float64, coolingRate float64) {
+	for i := range scales {
+		if math.Abs(synthPopTotals[i]-constraints[i]) < 0.5 {
+			scales[i] = math.Max(scales[i]*coolingRate, EPSILON)
+		} else {
+			scales[i] = math.Min(scales[i]/coolingRate, 1)
+		}
+	}
+}
+
+// replacePerVariable performs a replacement judged with per-variable temperatures
+//
+// Parameters:
+//   - microdata: The source microdata records
+//   - constraint: The area constraints
+//   - pool: The records valid for the area, the replacement is drawn from
+//   - synthPopTotals: Current aggregate statistics
+//   - synthPopMicrodataIndexess: Current population indices
+//   - fitness: Current fitness score
+//   - temp: Current global temperature
+//   - scales: Per-variable temperature scales
+//   - rule: Acceptance rule of the move, applied to the energy at temperature 1
+//   - rng: Random number generator
+//   - distfunc: Distance used to report the fitness of the new state
+//
+// Returns:
+//   - newFitness: The fitness after replacement
+//   - moveOutcome: Whether the replacement was accepted or turned down
+func replacePerVariable(microdata []MicroData, constraint ConstraintData, pool validPool, synthPopTotals []float64,
+	synthPopMicrodataIndexess []int, fitness float64, temp float64, scales []float64, rule string, rng *rand.Rand, distfunc DistanceFunc) (float64, moveOutcome) {
+
+	newIndex := pool.draw(rng)
+	newValues := microdata[newIndex].Values
+
+	slot := rng.Intn(len(synthPopMicrodataIndexess))
+	oldValues := microdata[synthPopMicrodataIndexess[slot]].Values
+
+	// Energy of the move, summed only over the variables it changes
+	energy := 0.0
+	for i := range synthPopTotals {
+		if newValues[i] == oldValues[i] {
+			continue
+		}
+		updated := synthPopTotals[i] - oldValues[i] + newValues[i]
+		errorChange := math.Abs(updated-constraint.Values[i]) - math.Abs(synthPopTotals[i]-constraint.Values[i])
+		energy += errorChange / (temp*scales[i] + EPSILON)
+	}
+
+	// The energy is already scaled by the variable temperatures
+	if !acceptMove(rule, energy, 1, rng) {
+		return fitness, moveReverted
+	}
+
+	for i := range synthPopTotals {
+		synthPopTotals[i] = synthPopTotals[i] - oldValues[i] + newValues[i]
+	}
+	synthPopMicrodataIndexess[slot] = newIndex
+	newFitness := distfunc(constraint.Values, synthPopTotals)
+	if newFitness > fitness {
+		return newFitness, moveAcceptedWorse
+	}
+	return newFitness, moveImproved
+}
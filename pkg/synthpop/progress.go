@@ -0,0 +1,30 @@
+package synthpop
+
+import (
+	"fmt"
+	"time"
+)
+
+// RunProgress is a snapshot of the progress of a run, reported every two seconds
+// and once more when every area is done
+type RunProgress struct {
+	RunName  string
+	Done     int           // Areas completed, including failed ones
+	Total    int           // Areas to synthesize in this run
+	Elapsed  time.Duration // Time since synthesis started
+	ETA      time.Duration // Estimated time to completion at the current rate
+	MemoryMB uint64        // Heap in use
+}
+
+// Fraction returns the share of areas completed, for progress bars
+func (p RunProgress) Fraction() float64 {
+	if p.Total == 0 {
+		return 1
+	}
+	return float64(p.Done) / float64(p.Total)
+}
+
+func (p RunProgress) String() string {
+	return fmt.Sprintf("📊 Progress: %d/%d (%.1f%%) | ⏱️ Elapsed: %v | 🕒 ETA: %v | 🧠 Memory: %vMB",
+		p.Done, p.Total, p.Fraction()*100, p.Elapsed, p.ETA.Round(time.Second), p.MemoryMB)
+}
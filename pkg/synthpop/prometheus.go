@@ -0,0 +1,177 @@
+package synthpop
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"runtime"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Prometheus endpoint: with Status.MetricsAddr set, a run serves /metrics in the
+// Prometheus text format while it progresses, so HPC and cloud runs can be
+// followed in Grafana. Every series carries the run name as its run label. The
+// endpoint closes when the run ends; the final state is in the status file and
+// the manifest.
+
+// fitnessQuantiles are the quantiles of the area fitness exported
+var fitnessQuantiles = []float64{0.5, 0.9, 0.99}
+
+// runMetrics collects the statistics served on the endpoint. A nil collector (no
+// address configured) ignores all calls.
+type runMetrics struct {
+	mu         sync.Mutex
+	runName    string
+	startedAt  time.Time
+	total      func() int
+	completed  int
+	failed     int
+	fitness    []float64       // Finite fitness of every completed area
+	iterations int64           // Search iterations of the completed areas
+	busy       []time.Duration // Synthesis time of every worker
+	listener   net.Listener
+}
+
+// startMetricsServer starts serving the metrics of a run
+//
+// Parameters:
+//   - popConfig: The population config, with the address in Status.MetricsAddr
+//   - numWorkers: The number of workers whose busy time is reported
+//   - total: Returns the number of areas of the run, which a watched directory grows
+//
+// Returns:
+//   - *runMetrics: The collector, nil when no address is configured
+//   - error: The address could not be listened on
+func startMetricsServer(popConfig PopulationConfig, numWorkers int, total func() int) (*runMetrics, error) {
+	if popConfig.Status.MetricsAddr == "" {
+		return nil, nil
+	}
+	listener, err := net.Listen("tcp", popConfig.Status.MetricsAddr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot serve metrics: %w", err)
+	}
+	m := &runMetrics{runName: popConfig.RunName, startedAt: time.Now(), total: total,
+		busy: make([]time.Duration, numWorkers), listener: listener}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	go http.Serve(listener, mux)
+	Printf("📈 Metrics on http://%s/metrics\n", listener.Addr())
+	return m, nil
+}
+
+// add records a completed area
+func (m *runMetrics) add(res Result) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.completed++
+	m.iterations += int64(res.Iterations)
+	if finiteOrNil(res.Fitness) != nil {
+		m.fitness = append(m.fitness, res.Fitness)
+	}
+}
+
+// fail records an area that could not be synthesized
+func (m *runMetrics) fail() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failed++
+}
+
+// work adds the time a worker spent synthesizing an area
+func (m *runMetrics) work(worker int, took time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.busy[worker] += took
+}
+
+// close stops serving the metrics
+func (m *runMetrics) close() {
+	if m != nil {
+		m.listener.Close()
+	}
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format
+func (m *runMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.write(w)
+}
+
+// write writes the current metrics to w
+func (m *runMetrics) write(w io.Writer) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	m.mu.Lock()
+	elapsed := time.Since(m.startedAt).Seconds()
+	completed, failed, iterations := m.completed, m.failed, m.iterations
+	fitness := slices.Clone(m.fitness)
+	busy := slices.Clone(m.busy)
+	m.mu.Unlock()
+	slices.Sort(fitness)
+
+	run := fmt.Sprintf("run=%q", m.runName)
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	sample := func(name, labels string, v float64) {
+		fmt.Fprintf(w, "%s{%s} %s\n", name, labels, strconv.FormatFloat(v, 'g', -1, 64))
+	}
+
+	metric("gosynthpop_areas", "gauge", "Areas to synthesize in the run.")
+	sample("gosynthpop_areas", run, float64(m.total()))
+	metric("gosynthpop_areas_completed_total", "counter", "Areas synthesized and written.")
+	sample("gosynthpop_areas_completed_total", run, float64(completed))
+	metric("gosynthpop_areas_failed_total", "counter", "Areas that could not be synthesized.")
+	sample("gosynthpop_areas_failed_total", run, float64(failed))
+
+	metric("gosynthpop_area_fitness", "summary", "Fitness of the completed areas.")
+	sum := 0.0
+	for _, f := range fitness {
+		sum += f
+	}
+	for _, q := range fitnessQuantiles {
+		if len(fitness) > 0 {
+			sample("gosynthpop_area_fitness", fmt.Sprintf("%s,quantile=\"%g\"", run, q),
+				fitness[min(int(q*float64(len(fitness))), len(fitness)-1)])
+		}
+	}
+	sample("gosynthpop_area_fitness_sum", run, sum)
+	sample("gosynthpop_area_fitness_count", run, float64(len(fitness)))
+	metric("gosynthpop_area_fitness_mean", "gauge", "Mean fitness of the completed areas.")
+	if len(fitness) > 0 {
+		sample("gosynthpop_area_fitness_mean", run, sum/float64(len(fitness)))
+	}
+
+	metric("gosynthpop_iterations_total", "counter", "Search iterations of the completed areas.")
+	sample("gosynthpop_iterations_total", run, float64(iterations))
+	metric("gosynthpop_iterations_per_second", "gauge", "Search iterations of the completed areas per second of the run.")
+	if elapsed > 0 {
+		sample("gosynthpop_iterations_per_second", run, float64(iterations)/elapsed)
+	}
+
+	metric("gosynthpop_memory_heap_bytes", "gauge", "Heap in use.")
+	sample("gosynthpop_memory_heap_bytes", run, float64(mem.HeapAlloc))
+	metric("gosynthpop_memory_sys_bytes", "gauge", "Memory obtained from the operating system.")
+	sample("gosynthpop_memory_sys_bytes", run, float64(mem.Sys))
+
+	metric("gosynthpop_worker_busy_seconds_total", "counter", "Time every worker spent synthesizing areas.")
+	for i, b := range busy {
+		sample("gosynthpop_worker_busy_seconds_total", fmt.Sprintf("%s,worker=\"%d\"", run, i), b.Seconds())
+	}
+	metric("gosynthpop_elapsed_seconds", "gauge", "Time since the run started.")
+	sample("gosynthpop_elapsed_seconds", run, elapsed)
+}
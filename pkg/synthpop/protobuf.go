@@ -0,0 +1,173 @@
+package synthpop
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Protocol buffers wire format, for the messages of the gRPC service
+// (gosynthpop.proto). Like the Thrift codec of the Parquet reader it is written by
+// hand for the few messages needed: the encoder appends fields, skipping proto3
+// defaults, and the decoder walks the fields of a message and hands each to the
+// message's own switch on the field number, skipping unknown ones.
+
+// Wire types
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+var errProtoTruncated = errors.New("truncated protobuf message")
+
+// protoWriter appends the fields of a message to buf
+type protoWriter struct {
+	buf []byte
+}
+
+func (w *protoWriter) tag(field, wire int) {
+	w.buf = binary.AppendUvarint(w.buf, uint64(field)<<3|uint64(wire))
+}
+
+func (w *protoWriter) uvarint(field int, v uint64) {
+	if v != 0 {
+		w.tag(field, protoVarint)
+		w.buf = binary.AppendUvarint(w.buf, v)
+	}
+}
+
+// int32 writes a signed field as the two's complement varint of int32 fields
+func (w *protoWriter) int32(field int, v int) {
+	w.uvarint(field, uint64(int64(v)))
+}
+
+func (w *protoWriter) bool(field int, v bool) {
+	if v {
+		w.uvarint(field, 1)
+	}
+}
+
+func (w *protoWriter) double(field int, v float64) {
+	if v != 0 {
+		w.tag(field, protoFixed64)
+		w.buf = binary.LittleEndian.AppendUint64(w.buf, math.Float64bits(v))
+	}
+}
+
+func (w *protoWriter) string(field int, s string) {
+	if s != "" {
+		w.tag(field, protoBytes)
+		w.buf = binary.AppendUvarint(w.buf, uint64(len(s)))
+		w.buf = append(w.buf, s...)
+	}
+}
+
+// message writes an embedded message, also when empty so a oneof is set
+func (w *protoWriter) message(field int, m []byte) {
+	w.tag(field, protoBytes)
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(m)))
+	w.buf = append(w.buf, m...)
+}
+
+// doubles writes a packed repeated double field
+func (w *protoWriter) doubles(field int, vs []float64) {
+	if len(vs) == 0 {
+		return
+	}
+	w.tag(field, protoBytes)
+	w.buf = binary.AppendUvarint(w.buf, uint64(8*len(vs)))
+	for _, v := range vs {
+		w.buf = binary.LittleEndian.AppendUint64(w.buf, math.Float64bits(v))
+	}
+}
+
+func (w *protoWriter) strings(field int, ss []string) {
+	for _, s := range ss {
+		w.tag(field, protoBytes)
+		w.buf = binary.AppendUvarint(w.buf, uint64(len(s)))
+		w.buf = append(w.buf, s...)
+	}
+}
+
+// protoField is one decoded field: the varint or fixed value in v, the payload of
+// length-delimited fields in data
+type protoField struct {
+	number int
+	wire   int
+	v      uint64
+	data   []byte
+}
+
+// int returns the value of a varint field as a signed integer
+func (f protoField) int() int {
+	return int(int64(f.v))
+}
+
+func (f protoField) double() float64 {
+	return math.Float64frombits(f.v)
+}
+
+func (f protoField) string() string {
+	return string(f.data)
+}
+
+// appendDoubles appends the values of a repeated double field, packed or not
+func (f protoField) appendDoubles(vs []float64) ([]float64, error) {
+	switch f.wire {
+	case protoFixed64:
+		return append(vs, f.double()), nil
+	case protoBytes:
+		if len(f.data)%8 != 0 {
+			return vs, fmt.Errorf("field %d: packed doubles of %d bytes", f.number, len(f.data))
+		}
+		for i := 0; i < len(f.data); i += 8 {
+			vs = append(vs, math.Float64frombits(binary.LittleEndian.Uint64(f.data[i:])))
+		}
+		return vs, nil
+	}
+	return vs, fmt.Errorf("field %d: wire type %d is not a double", f.number, f.wire)
+}
+
+// readProto calls field for every field of a message in turn
+func readProto(data []byte, field func(protoField) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errProtoTruncated
+		}
+		data = data[n:]
+		f := protoField{number: int(key >> 3), wire: int(key & 7)}
+		switch f.wire {
+		case protoVarint:
+			if f.v, n = binary.Uvarint(data); n <= 0 {
+				return errProtoTruncated
+			}
+			data = data[n:]
+		case protoFixed64:
+			if len(data) < 8 {
+				return errProtoTruncated
+			}
+			f.v, data = binary.LittleEndian.Uint64(data), data[8:]
+		case protoFixed32:
+			if len(data) < 4 {
+				return errProtoTruncated
+			}
+			f.v, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		case protoBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return errProtoTruncated
+			}
+			f.data, data = data[n:n+int(size)], data[n+int(size):]
+		default:
+			return fmt.Errorf("field %d: unsupported wire type %d", f.number, f.wire)
+		}
+		if err := field(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package synthpop
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	protoNumbersOnce sync.Once
+	protoNumbers     map[string]map[string]int
+	protoNumbersErr  error
+)
+
+// protoNumber returns the number of a field of gosynthpop.proto, so the tests follow
+// the service definition rather than the numbers of the hand-written codec
+func protoNumber(t testing.TB, message, field string) int {
+	t.Helper()
+	protoNumbersOnce.Do(func() {
+		protoNumbers, protoNumbersErr = readProtoNumbers("gosynthpop.proto")
+	})
+	if protoNumbersErr != nil {
+		t.Fatal(protoNumbersErr)
+	}
+	n, ok := protoNumbers[message][field]
+	if !ok {
+		t.Fatalf("gosynthpop.proto has no field %s.%s", message, field)
+	}
+	return n
+}
+
+// readProtoNumbers reads the field numbers of every message of a .proto file, the
+// fields of a oneof counting as the message's
+func readProtoNumbers(path string) (map[string]map[string]int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	messageRE := regexp.MustCompile(`^message (\w+) \{`)
+	fieldRE := regexp.MustCompile(`^(?:repeated )?\w+ (\w+) = (\d+);`)
+	numbers := make(map[string]map[string]int)
+	var message string
+	depth := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if m := messageRE.FindStringSubmatch(line); m != nil {
+			message = m[1]
+			numbers[message] = make(map[string]int)
+		}
+		if m := fieldRE.FindStringSubmatch(line); m != nil && message != "" {
+			numbers[message][m[1]], _ = strconv.Atoi(m[2])
+		}
+		depth += strings.Count(line, "{") - strings.Count(line, "}")
+		if depth == 0 {
+			message = ""
+		}
+	}
+	return numbers, scanner.Err()
+}
+
+// protoKey returns the key of a field of gosynthpop.proto with its wire type
+func protoKey(t testing.TB, message, field string, wire int) []byte {
+	t.Helper()
+	return binary.AppendUvarint(nil, uint64(protoNumber(t, message, field))<<3|uint64(wire))
+}
+
+// protoLen returns the length prefix and content of a length-delimited field
+func protoLen(content ...[]byte) []byte {
+	joined := slices.Concat(content...)
+	return append(binary.AppendUvarint(nil, uint64(len(joined))), joined...)
+}
+
+// unhex decodes the little-endian values and varints written out in the tests
+func unhex(t testing.TB, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestProtoEncode(t *testing.T) {
+	key := func(message, field string, wire int) []byte { return protoKey(t, message, field, wire) }
+	tests := []struct {
+		name string
+		got  []byte
+		want []byte
+	}{
+		{"AreaResult", encodeAreaResult(Result{
+			Area: "E01", Fitness: 0.5, Population: 3, Totals: []float64{1, 2}, ConstraintTotals: []float64{1},
+			IDs: []string{"a", "", "bc"}, BestIteration: 300, Iterations: -1, TimedOut: true,
+		}), slices.Concat(
+			key("AreaResult", "area", protoBytes), protoLen([]byte("E01")),
+			key("AreaResult", "fitness", protoFixed64), unhex(t, "000000000000e03f"),
+			key("AreaResult", "population", protoFixed64), unhex(t, "0000000000000840"),
+			key("AreaResult", "totals", protoBytes), protoLen(unhex(t, "000000000000f03f 0000000000000040")),
+			key("AreaResult", "constraint_totals", protoBytes), protoLen(unhex(t, "000000000000f03f")),
+			key("AreaResult", "ids", protoBytes), protoLen([]byte("a")),
+			key("AreaResult", "ids", protoBytes), protoLen(),
+			key("AreaResult", "ids", protoBytes), protoLen([]byte("bc")),
+			key("AreaResult", "best_iteration", protoVarint), unhex(t, "ac02"),
+			// Negative int32 values take ten bytes, as the sign extended int64
+			key("AreaResult", "iterations", protoVarint), unhex(t, "ffffffffffffffffff01"),
+			key("AreaResult", "timed_out", protoVarint), unhex(t, "01"),
+		)},
+		{"AreaResult defaults", encodeAreaResult(Result{}), nil},
+		{"Progress", encodeProgress(RunProgress{Done: 3, Total: 300, Elapsed: 1500 * time.Millisecond, MemoryMB: 1 << 20}),
+			slices.Concat(
+				key("Progress", "done", protoVarint), unhex(t, "03"),
+				key("Progress", "total", protoVarint), unhex(t, "ac02"),
+				key("Progress", "elapsed_seconds", protoFixed64), unhex(t, "000000000000f83f"),
+				key("Progress", "memory_mb", protoVarint), unhex(t, "808040"),
+			)},
+		{"JobStatus", (&grpcJob{state: statusFailed, err: errProtoTruncated}).status(), slices.Concat(
+			key("JobStatus", "state", protoBytes), protoLen([]byte("failed")),
+			key("JobStatus", "error", protoBytes), protoLen([]byte(errProtoTruncated.Error())),
+		)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if hex.EncodeToString(tt.got) != hex.EncodeToString(tt.want) {
+				t.Errorf("encoded\n%x\nwant\n%x", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeSubmitJob(t *testing.T) {
+	key := func(message, field string, wire int) []byte { return protoKey(t, message, field, wire) }
+	// A field number the messages do not use, of every wire type
+	unknown := func(wire int) []byte { return binary.AppendUvarint(nil, 99<<3|uint64(wire)) }
+	packed := slices.Concat(
+		key("ConstraintArea", "id", protoBytes), protoLen([]byte("E01")),
+		key("ConstraintArea", "values", protoBytes), protoLen(unhex(t, "000000000000f03f 0000000000000040")),
+		key("ConstraintArea", "total", protoFixed64), unhex(t, "0000000000000840"),
+		key("ConstraintArea", "person_total", protoFixed64), unhex(t, "0000000000001040"),
+		unknown(protoVarint), unhex(t, "ff01"),
+	)
+	// Repeated doubles may also come one field each, or in several packed runs
+	unpacked := slices.Concat(
+		key("ConstraintArea", "values", protoFixed64), unhex(t, "0000000000001440"),
+		key("ConstraintArea", "id", protoBytes), protoLen([]byte("E02")),
+		key("ConstraintArea", "values", protoBytes), protoLen(unhex(t, "0000000000001840")),
+		key("ConstraintArea", "values", protoFixed64), unhex(t, "0000000000001c40"),
+	)
+	record := slices.Concat(
+		key("MicroRecord", "id", protoBytes), protoLen([]byte("p1")),
+		key("MicroRecord", "values", protoBytes), protoLen(unhex(t, "0000000000000000 000000000000f03f")),
+		key("MicroRecord", "weight", protoFixed64), unhex(t, "000000000000e03f"),
+		unknown(protoFixed32), unhex(t, "01020304"),
+	)
+	message := slices.Concat(
+		key("SubmitJobRequest", "population_config", protoBytes), protoLen([]byte(`{"runName":"r"}`)),
+		unknown(protoBytes), protoLen([]byte("skipped")),
+		key("SubmitJobRequest", "annealing_config", protoBytes), protoLen([]byte(`{}`)),
+		key("SubmitJobRequest", "header", protoBytes), protoLen([]byte("male")),
+		key("SubmitJobRequest", "header", protoBytes), protoLen([]byte("female")),
+		key("SubmitJobRequest", "constraints", protoBytes), protoLen(packed),
+		key("SubmitJobRequest", "constraints", protoBytes), protoLen(unpacked),
+		key("SubmitJobRequest", "microdata", protoBytes), protoLen(record),
+		key("SubmitJobRequest", "microdata", protoBytes), protoLen(),
+		unknown(protoFixed64), unhex(t, "0102030405060708"),
+		key("SubmitJobRequest", "output_dir", protoBytes), protoLen([]byte("jobs/r")),
+	)
+
+	got, err := decodeSubmitJob(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.popConfig != `{"runName":"r"}` || got.annealingConfig != "{}" || got.outputDir != "jobs/r" {
+		t.Errorf("configs decoded as %q, %q and %q", got.popConfig, got.annealingConfig, got.outputDir)
+	}
+	if !slices.Equal(got.in.Header, []string{"male", "female"}) {
+		t.Errorf("header decoded as %v", got.in.Header)
+	}
+	wantConstraints := []ConstraintData{
+		{ID: "E01", Values: []float64{1, 2}, Total: 3, PersonTotal: 4},
+		{ID: "E02", Values: []float64{5, 6, 7}},
+	}
+	if len(got.in.Constraints) != len(wantConstraints) {
+		t.Fatalf("decoded %d constraints, want %d", len(got.in.Constraints), len(wantConstraints))
+	}
+	for i, want := range wantConstraints {
+		c := got.in.Constraints[i]
+		if c.ID != want.ID || !slices.Equal(c.Values, want.Values) || c.Total != want.Total || c.PersonTotal != want.PersonTotal {
+			t.Errorf("constraint %d decoded as %+v, want %+v", i, c, want)
+		}
+	}
+	if len(got.in.MicroData) != 2 {
+		t.Fatalf("decoded %d microdata records, want 2", len(got.in.MicroData))
+	}
+	if md := got.in.MicroData[0]; md.ID != "p1" || !slices.Equal(md.Values, []float64{0, 1}) || md.Weight != 0.5 {
+		t.Errorf("microdata record decoded as %+v", md)
+	}
+	if md := got.in.MicroData[1]; md.ID != "" || md.Values != nil || md.Weight != 0 {
+		t.Errorf("empty microdata record decoded as %+v", md)
+	}
+}
+
+func TestReadProtoErrors(t *testing.T) {
+	key := func(message, field string, wire int) []byte { return protoKey(t, message, field, wire) }
+	id := key("ConstraintArea", "id", protoBytes)
+	values := key("ConstraintArea", "values", protoBytes)
+	tests := []struct {
+		name    string
+		message []byte
+		want    string
+	}{
+		{"truncated key", unhex(t, "80"), errProtoTruncated.Error()},
+		{"truncated varint", slices.Concat(unhex(t, "18"), unhex(t, "ff")), errProtoTruncated.Error()},
+		{"truncated fixed64", slices.Concat(key("ConstraintArea", "total", protoFixed64), unhex(t, "0000")), errProtoTruncated.Error()},
+		{"truncated fixed32", slices.Concat(unhex(t, "1d"), unhex(t, "000000")), errProtoTruncated.Error()},
+		{"truncated length", slices.Concat(id, unhex(t, "05"), []byte("E01")), errProtoTruncated.Error()},
+		{"length overflow", slices.Concat(id, unhex(t, "ffffffffffffffffff01")), errProtoTruncated.Error()},
+		{"group", unhex(t, "0b"), "unsupported wire type 3"},
+		{"packed doubles", slices.Concat(values, protoLen(unhex(t, "00000000000000"))), "packed doubles of 7 bytes"},
+		{"varint double", slices.Concat(key("ConstraintArea", "values", protoVarint), unhex(t, "01")), "is not a double"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeConstraintArea(tt.message)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+	// Errors of embedded messages name the field of the request
+	request := slices.Concat(key("SubmitJobRequest", "constraints", protoBytes), protoLen(unhex(t, "0b")))
+	if _, err := decodeSubmitJob(request); err == nil || !strings.HasPrefix(err.Error(), "constraints: ") {
+		t.Errorf("error %v, want one of the constraints", err)
+	}
+}
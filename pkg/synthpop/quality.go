@@ -0,0 +1,70 @@
+package synthpop
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrQualityGate is wrapped by the error of a run whose populations fail the
+// quality gate, so pipelines can tell poor quality from other failures
+var ErrQualityGate = errors.New("quality gate failed")
+
+// qualityGate checks the fit of every area after the run, so pipelines stop on
+// poor populations instead of silently publishing them. An area is poor when its
+// fitness exceeds the threshold or it could not be synthesized at all.
+type qualityGate struct {
+	threshold float64 // Fitness above which an area is poor
+	maxShare  float64 // Largest share of poor areas that passes
+	hardCap   float64 // Fitness no area may exceed (0 disables)
+	areas     int
+	poor      int
+	capped    []string // Areas above hardCap
+}
+
+// newQualityGate returns the configured gate, or nil when there is none
+func newQualityGate(popConfig PopulationConfig) *qualityGate {
+	gate := popConfig.QualityGate
+	if gate.FitnessThreshold <= 0 && gate.HardCap <= 0 {
+		return nil
+	}
+	return &qualityGate{threshold: gate.FitnessThreshold, maxShare: gate.MaxPoorShare, hardCap: gate.HardCap}
+}
+
+// add records the fit of a synthesized area
+func (g *qualityGate) add(res Result) {
+	g.areas++
+	if g.threshold > 0 && res.Fitness > g.threshold {
+		g.poor++
+	}
+	if g.hardCap > 0 && res.Fitness > g.hardCap {
+		g.capped = append(g.capped, res.Area)
+	}
+}
+
+// fail records an area that could not be synthesized
+func (g *qualityGate) fail() {
+	g.areas++
+	g.poor++
+}
+
+// check prints the verdict and returns an error wrapping ErrQualityGate when the
+// run fails the gate
+func (g *qualityGate) check() error {
+	if len(g.capped) > 0 {
+		Printf("🚦 Quality gate failed: %d areas above the hard cap %g, e.g. %s\n", len(g.capped), g.hardCap, g.capped[0])
+		return fmt.Errorf("%w: %d areas have fitness above %g", ErrQualityGate, len(g.capped), g.hardCap)
+	}
+	if g.threshold <= 0 || g.areas == 0 {
+		Printf("🚦 Quality gate passed: no area above the hard cap %g\n", g.hardCap)
+		return nil
+	}
+	share := float64(g.poor) / float64(g.areas)
+	if share > g.maxShare {
+		Printf("🚦 Quality gate failed: %d of %d areas (%.1f%%) are poor (fitness above %g or not synthesized), at most %.1f%% allowed\n",
+			g.poor, g.areas, share*100, g.threshold, g.maxShare*100)
+		return fmt.Errorf("%w: %.1f%% of the areas are poor, at most %.1f%% allowed", ErrQualityGate, share*100, g.maxShare*100)
+	}
+	Printf("🚦 Quality gate passed: %d of %d areas (%.1f%%) are poor, at most %.1f%% allowed\n",
+		g.poor, g.areas, share*100, g.maxShare*100)
+	return nil
+}
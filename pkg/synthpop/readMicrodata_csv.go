@@ -1,6 +1,7 @@
-package main
+package synthpop
 
 import (
+	"context"
 	"encoding/csv"
 	"fmt"
 	"io"
@@ -10,13 +11,30 @@ import (
 )
 
 func ReadMicroDataCSV(filename string) ([]MicroData, []string, error) {
+	return ReadMicroDataCSVContext(context.Background(), filename, nil)
+}
+
+// ReadMicroDataCSVContext reads microdata like ReadMicroDataCSV, reporting the rows and
+// bytes read to progress (which may be nil) and stopping with an error once ctx
+// is done
+func ReadMicroDataCSVContext(ctx context.Context, filename string, progress chan<- LoadProgress) ([]MicroData, []string, error) {
 	file, err := os.Open(filename)
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, err
 	}
 	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	tracker := newLoadTracker(ctx, filename, info.Size(), progress)
+	defer tracker.finish()
 
-	reader := csv.NewReader(file)
+	in, err := decompress(filename, tracker.reader(file))
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := csv.NewReader(in)
 
 	header, err := reader.Read()
 	if err != nil {
@@ -29,6 +47,9 @@ func ReadMicroDataCSV(filename string) ([]MicroData, []string, error) {
 		if err == io.EOF {
 			break
 		}
+		if err := tracker.err(); err != nil {
+			return nil, nil, err
+		}
 		if err != nil {
 			log.Printf("Error reading row: %v", err)
 			continue
@@ -48,6 +69,7 @@ func ReadMicroDataCSV(filename string) ([]MicroData, []string, error) {
 			values[i] = num
 		}
 
+		tracker.rows.Add(1)
 		data = append(data, MicroData{ID: id, Values: values})
 	} // Uses Record struct without importing
 	return data, header[1:], nil
@@ -0,0 +1,102 @@
+package synthpop
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Region-restricted donor pools: national microdata mix regions whose populations
+// differ (e.g. Scottish records have no place in London LSOAs). With
+// Microdata.RegionColumn and Microdata.RegionLookupFile set, the records are split
+// by region and every area draws its individuals from its own region's records
+// only, which also shrinks the pool every replacement is drawn from.
+
+// applyRegions moves the region column out of the microdata values into the Region
+// of every record. Region codes are numeric in the microdata (e.g. gor_dv) and
+// compared as written in the lookup, so 7 and 7.0 are the same region.
+func applyRegions(microData []MicroData, header []string, column string) ([]MicroData, []string, error) {
+	regional, header, codes, err := takeColumn(microData, header, column, "region")
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, code := range codes {
+		regional[i].Region = formatFloat(code)
+	}
+	return regional, header, nil
+}
+
+// regionPools are the microdata split by region, with the region of every area
+type regionPools struct {
+	pools    map[string][]MicroData
+	regionOf map[string]string
+	position []int // Index of every run microdata record within its region's pool
+}
+
+// newRegionPools splits the microdata by region, or returns nil when the run has no
+// region lookup. Every area of constraints must have a region with records.
+//
+// Parameters:
+//   - popConfig: The population configuration, for Microdata.RegionLookupFile
+//   - constraints: The areas of the run
+//   - microData: The microdata of the run, regions applied (see applyRegions)
+//
+// Returns:
+//   - *regionPools: The pools, nil without a region lookup
+//   - error: An unreadable lookup, or an area without a region or records
+func newRegionPools(popConfig PopulationConfig, constraints []ConstraintData, microData []MicroData) (*regionPools, error) {
+	if popConfig.Microdata.RegionLookupFile == "" {
+		return nil, nil
+	}
+	lookup, err := readAreaLookupCSV(popConfig.Microdata.RegionLookupFile, "region")
+	if err != nil {
+		return nil, err
+	}
+	// Codes are compared in the canonical form of the microdata values
+	regionOf := make(map[string]string, len(lookup))
+	for area, code := range lookup {
+		value, err := strconv.ParseFloat(strings.TrimSpace(code), 64)
+		if err != nil {
+			return nil, fmt.Errorf("region lookup: area %s has a non-numeric region %q", area, code)
+		}
+		regionOf[area] = formatFloat(value)
+	}
+
+	r := &regionPools{pools: make(map[string][]MicroData), regionOf: regionOf, position: make([]int, len(microData))}
+	for i, md := range microData {
+		r.position[i] = len(r.pools[md.Region])
+		r.pools[md.Region] = append(r.pools[md.Region], md)
+	}
+	for _, c := range constraints {
+		region, ok := regionOf[c.ID]
+		if !ok {
+			return nil, fmt.Errorf("area %s has no region in the region lookup", c.ID)
+		}
+		if len(r.pools[region]) == 0 && c.Total > 0 {
+			return nil, fmt.Errorf("region %s of area %s has no microdata records", region, c.ID)
+		}
+	}
+	Printf("🗺️ Drawing donors from %d regional microdata pools\n", len(r.pools))
+	return r, nil
+}
+
+// pool returns the microdata an area draws from: its region's records, none for an
+// area without a region (e.g. one added by watch.dir)
+func (r *regionPools) pool(area string) []MicroData {
+	return r.pools[r.regionOf[area]]
+}
+
+// localSeeds maps the seed population of every area (see ReadSeedPopulation) to
+// indices into its region's pool, dropping the individuals of other regions
+func (r *regionPools) localSeeds(seeds map[string][]int, microData []MicroData) {
+	for area, seed := range seeds {
+		region := r.regionOf[area]
+		local := seed[:0]
+		for _, index := range seed {
+			if microData[index].Region == region {
+				local = append(local, r.position[index])
+			}
+		}
+		seeds[area] = local
+	}
+}
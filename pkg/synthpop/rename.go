@@ -0,0 +1,47 @@
+package synthpop
+
+import "fmt"
+
+// renameHeader returns the variable names written to the outputs: the header with
+// the names in rename (constraint variable -> output name) replaced, so downstream
+// systems get the column names they expect without post-processing every run.
+//
+// Returns:
+//   - []string: The output names, the header itself when rename is empty
+//   - error: A renamed variable that is not in the header, or two variables with
+//     the same output name
+func renameHeader(header []string, rename map[string]string) ([]string, error) {
+	if len(rename) == 0 {
+		return header, nil
+	}
+	inHeader := make(map[string]bool, len(header))
+	for _, name := range header {
+		inHeader[name] = true
+	}
+	for from, to := range rename {
+		if !inHeader[from] {
+			return nil, fmt.Errorf("output.rename: variable '%s' is not in the constraints header", from)
+		}
+		if to == "" {
+			return nil, fmt.Errorf("output.rename: empty output name for '%s'", from)
+		}
+	}
+
+	renamed := make([]string, len(header))
+	seen := map[string]string{"geography_code": "", "best_iteration": ""} // Columns of the validate file
+	for i, name := range header {
+		out := name
+		if to, ok := rename[name]; ok {
+			out = to
+		}
+		if other, dup := seen[out]; dup {
+			if other == "" {
+				return nil, fmt.Errorf("output.rename: '%s' is a reserved output column", out)
+			}
+			return nil, fmt.Errorf("output.rename: '%s' and '%s' would both be written as '%s'", other, name, out)
+		}
+		seen[out] = name
+		renamed[i] = out
+	}
+	return renamed, nil
+}
@@ -0,0 +1,244 @@
+package synthpop
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// OutputReport summarises how well the existing outputs of a run fit the constraints
+type OutputReport struct {
+	Areas       int      // Areas in the validate file
+	Missing     []string // Constraint areas without a row in the validate file
+	Population  float64  // Total population of the reported areas
+	TAE         float64  // Total absolute error over all areas and variables
+	SAE         float64  // TAE / Population
+	MeanFitness float64
+	MaxFitness  float64
+	WorstArea   string // Area with MaxFitness
+	Variables   []VariableReport
+	Results     *ResultSet // Areas of the validate file, with their totals and recomputed fitness
+}
+
+// VariableReport is the error of one variable over all areas
+type VariableReport struct {
+	Name      string  // Output name of the variable
+	TAE       float64 // Total absolute error over the areas
+	MaxError  float64 // Largest absolute error of an area
+	WorstArea string  // Area with MaxError
+}
+
+// Report recomputes the validation statistics of a finished run from its validate
+// file and the constraints, without synthesizing anything. The validation errors
+// and summary files, the GeoJSON layer, the Moran's I report and the parent
+// validation are regenerated when configured; fitness is recomputed with the distance of config.
+//
+// Parameters:
+//   - ctx: Cancels the loading of the inputs
+//   - popConfig: The population configuration of the run
+//   - config: The annealing configuration, for the distance function
+//
+// Returns:
+//   - OutputReport: The summary over all areas
+//   - error: An unreadable or mismatched validate file, or an output error
+func (c *Controller) Report(ctx context.Context, popConfig PopulationConfig, config AnnealingConfig) (OutputReport, error) {
+	var report OutputReport
+	if popConfig.Output.Encrypt {
+		return report, fmt.Errorf("report reads plain outputs, decrypt the validate file and disable output.encrypt")
+	}
+	if fileFormat(popConfig.Validate.File, popConfig.Validate.Format) == FormatParquet {
+		return report, fmt.Errorf("report reads a CSV validate file, set validate.format to csv")
+	}
+
+	in, err := c.Load(ctx, popConfig)
+	if err != nil {
+		return report, err
+	}
+	outputHeader, err := renameHeader(in.Header, popConfig.Output.Rename)
+	if err != nil {
+		return report, err
+	}
+	distance, err := buildDistance(in.WithTableGroups(config), in.Header)
+	if err != nil {
+		return report, err
+	}
+	constraints := make(map[string]ConstraintData, len(in.Constraints))
+	for _, constraint := range in.Constraints {
+		constraints[constraint.ID] = constraint
+	}
+
+	// Outputs regenerated from the reported areas
+	retry := newRetryPolicy(popConfig)
+	denominators, err := resolveDenominators(config, in.Header)
+	if err != nil {
+		return report, err
+	}
+	validation, err := newValidationWriter(popConfig, outputHeader, denominators, nil, retry)
+	if err != nil {
+		return report, err
+	}
+	defer func() {
+		if validation != nil { // Not completed below
+			validation.Close()
+		}
+	}()
+	spatial, err := newSpatialJoin(popConfig, in.Header, outputHeader)
+	if err != nil {
+		return report, err
+	}
+	moran, err := newMoranDiagnostic(popConfig)
+	if err != nil {
+		return report, err
+	}
+	parents, err := newParentValidation(ctx, popConfig, in.Header, outputHeader)
+	if err != nil {
+		return report, err
+	}
+
+	file, err := os.Open(popConfig.Validate.File)
+	if err != nil {
+		return report, fmt.Errorf("cannot open validate file: %w", err)
+	}
+	defer file.Close()
+	data, err := decompress(popConfig.Validate.File, file)
+	if err != nil {
+		return report, err
+	}
+	reader := csv.NewReader(data)
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		return report, fmt.Errorf("error reading validate header: %w", err)
+	}
+	wantHeader := append(append([]string{"geography_code"}, outputHeader...), "best_iteration")
+	if strings.Join(header, ",") != strings.Join(wantHeader, ",") {
+		return report, fmt.Errorf("validate header %v does not match %v", header, wantHeader)
+	}
+
+	report.Variables = make([]VariableReport, len(outputHeader))
+	for i, name := range outputHeader {
+		report.Variables[i].Name = name
+	}
+	report.Results = NewResultSet()
+	fitnessSum := 0.0
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, fmt.Errorf("error reading validate file: %w", err)
+		}
+		res, err := reportedArea(record, constraints)
+		if err != nil {
+			return report, fmt.Errorf("validate file line %d: %w", line, err)
+		}
+		if _, ok := report.Results.ByArea(res.Area); ok {
+			return report, fmt.Errorf("validate file line %d: area %s appears more than once", line, res.Area)
+		}
+		res.Fitness = distance(res.Totals, res.ConstraintTotals)
+
+		report.Areas++
+		report.Population += res.Population
+		fitnessSum += res.Fitness
+		if report.Areas == 1 || res.Fitness > report.MaxFitness {
+			report.MaxFitness, report.WorstArea = res.Fitness, res.Area
+		}
+		for i := range report.Variables {
+			v := &report.Variables[i]
+			absError := math.Abs(res.Totals[i] - res.ConstraintTotals[i])
+			v.TAE += absError
+			report.TAE += absError
+			if v.WorstArea == "" || absError > v.MaxError {
+				v.MaxError, v.WorstArea = absError, res.Area
+			}
+		}
+
+		report.Results.Add(res)
+
+		if validation != nil {
+			if err := validation.writeArea(res); err != nil {
+				return report, err
+			}
+		}
+		if spatial != nil {
+			spatial.add(res)
+		}
+		if moran != nil {
+			moran.add(res)
+		}
+		if parents != nil {
+			parents.add(res)
+		}
+	}
+
+	for _, constraint := range in.Constraints {
+		if _, ok := report.Results.ByArea(constraint.ID); !ok {
+			report.Missing = append(report.Missing, constraint.ID)
+		}
+	}
+	if report.Areas > 0 {
+		report.MeanFitness = fitnessSum / float64(report.Areas)
+	}
+	if report.Population > 0 {
+		report.SAE = report.TAE / report.Population
+	}
+
+	if validation != nil {
+		err := validation.Close()
+		validation = nil
+		if err != nil {
+			return report, fmt.Errorf("error completing validation outputs: %w", err)
+		}
+	}
+	if spatial != nil {
+		if err := spatial.write(popConfig.Output.GeoJSONFile, nil, retry); err != nil {
+			return report, err
+		}
+	}
+	if moran != nil {
+		if err := moran.report(popConfig.Adjacency.ReportFile, nil, retry); err != nil {
+			return report, err
+		}
+	}
+	if parents != nil {
+		if err := parents.report(popConfig.Parent.ValidateFile, nil, retry); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// reportedArea rebuilds the result of an area from its validate file row
+func reportedArea(record []string, constraints map[string]ConstraintData) (Result, error) {
+	constraint, ok := constraints[record[0]]
+	if !ok {
+		return Result{}, fmt.Errorf("area %s is not in the constraints", record[0])
+	}
+	n := len(record) - 2
+	res := Result{
+		Area:             record[0],
+		Population:       constraint.Total,
+		Totals:           make([]float64, n),
+		ConstraintTotals: constraint.Values,
+		Empty:            constraint.Total == 0,
+	}
+	for i := range res.Totals {
+		v, err := strconv.ParseFloat(record[i+1], 64)
+		if err != nil {
+			return Result{}, fmt.Errorf("invalid total '%s': %w", record[i+1], err)
+		}
+		res.Totals[i] = v
+	}
+	bestIteration, err := strconv.Atoi(record[n+1])
+	if err != nil {
+		return Result{}, fmt.Errorf("invalid best_iteration '%s': %w", record[n+1], err)
+	}
+	res.BestIteration = bestIteration
+	return res, nil
+}
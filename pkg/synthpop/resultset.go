@@ -0,0 +1,56 @@
+package synthpop
+
+import "iter"
+
+// ResultSet holds area results in the order they were added, with lookup by area
+// ID. It is the representation shared by front-ends collecting Controller.Results,
+// the report command and library callers of SynthesizeArea.
+type ResultSet struct {
+	results []Result
+	byArea  map[string]int
+}
+
+// NewResultSet returns a set of results; a later result of an area replaces an
+// earlier one
+func NewResultSet(results ...Result) *ResultSet {
+	s := &ResultSet{byArea: make(map[string]int, len(results))}
+	for _, res := range results {
+		s.Add(res)
+	}
+	return s
+}
+
+// Add adds the result of an area, replacing the area's previous result if any
+func (s *ResultSet) Add(res Result) {
+	if i, ok := s.byArea[res.Area]; ok {
+		s.results[i] = res
+		return
+	}
+	s.byArea[res.Area] = len(s.results)
+	s.results = append(s.results, res)
+}
+
+// Len returns the number of areas in the set
+func (s *ResultSet) Len() int {
+	return len(s.results)
+}
+
+// ByArea returns the result of an area and whether the set holds it
+func (s *ResultSet) ByArea(id string) (Result, bool) {
+	i, ok := s.byArea[id]
+	if !ok {
+		return Result{}, false
+	}
+	return s.results[i], true
+}
+
+// All iterates over the results in the order they were added
+func (s *ResultSet) All() iter.Seq[Result] {
+	return func(yield func(Result) bool) {
+		for _, res := range s.results {
+			if !yield(res) {
+				return
+			}
+		}
+	}
+}
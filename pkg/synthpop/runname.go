@@ -0,0 +1,108 @@
+package synthpop
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RunNamePlaceholder in an output path is replaced by the run name, e.g.
+// "runs/{run}/synthetic.csv" writes every run to its own directory
+const RunNamePlaceholder = "{run}"
+
+var (
+	runNameAdjectives = []string{
+		"amber", "brisk", "calm", "dusty", "eager", "fuzzy", "gentle", "hazy",
+		"icy", "jolly", "keen", "lucky", "misty", "nimble", "olive", "proud",
+		"quiet", "rapid", "sunny", "tidy", "urban", "vivid", "witty", "young",
+	}
+	runNameNouns = []string{
+		"badger", "canyon", "delta", "falcon", "glacier", "harbor", "island", "jaguar",
+		"kestrel", "lagoon", "meadow", "nebula", "orchard", "pelican", "quarry", "river",
+		"summit", "tundra", "valley", "walrus", "yarrow", "zephyr", "otter", "prairie",
+	}
+)
+
+// NewRunName returns a short human-readable run name such as "misty-falcon-42"
+func NewRunName() string {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return fmt.Sprintf("%s-%s-%02d",
+		runNameAdjectives[rng.Intn(len(runNameAdjectives))],
+		runNameNouns[rng.Intn(len(runNameNouns))],
+		rng.Intn(100))
+}
+
+// outputPaths returns the paths of every file a run writes
+func outputPaths(popConfig *PopulationConfig) []*string {
+	return []*string{
+		&popConfig.Output.File,
+		&popConfig.Output.AgentsFile,
+		&popConfig.Output.MatsimFile,
+		&popConfig.Output.IndividualsFile,
+		&popConfig.Output.GeoJSONFile,
+		&popConfig.Output.InclusionFile,
+		&popConfig.Output.WeightsFile,
+		&popConfig.Output.FailedAreasFile,
+		&popConfig.Output.TraceFile,
+		&popConfig.Output.DiagnosticsFile,
+		&popConfig.Output.SQLiteFile,
+		&popConfig.Output.ManifestFile,
+		&popConfig.Output.BundleFile,
+		&popConfig.Validate.File,
+		&popConfig.Validate.ErrorsFile,
+		&popConfig.Validate.SummaryFile,
+		&popConfig.Validate.InteractionsFile,
+		&popConfig.Parent.ValidateFile,
+		&popConfig.Adjacency.ReportFile,
+		&popConfig.Status.File,
+		&popConfig.Holdout.File,
+		&popConfig.Checkpoint.File,
+		&popConfig.Households.PersonsOutputFile,
+		&popConfig.Debug.Dir,
+	}
+}
+
+// placeOutputs moves the relative output paths of a config under dir, creating the
+// directories they need, so runs of configs sharing output names do not overwrite
+// each other's outputs. Absolute paths are kept; an empty dir changes nothing.
+func placeOutputs(popConfig PopulationConfig, dir string) (PopulationConfig, error) {
+	if dir == "" {
+		return popConfig, nil
+	}
+	for _, path := range outputPaths(&popConfig) {
+		if *path == "" || filepath.IsAbs(*path) {
+			continue
+		}
+		*path = filepath.Join(dir, *path)
+		if err := os.MkdirAll(filepath.Dir(*path), 0o755); err != nil {
+			return popConfig, fmt.Errorf("cannot create output directory: %w", err)
+		}
+	}
+	return popConfig, nil
+}
+
+// ApplyRunName names the run and substitutes the name for RunNamePlaceholder in the
+// output paths, creating the directories they need. A configured RunName is kept,
+// otherwise one is generated. Applying it to an already named config changes nothing.
+//
+// Returns:
+//   - PopulationConfig: The config with RunName set and the output paths expanded
+//   - error: A directory that cannot be created
+func ApplyRunName(popConfig PopulationConfig) (PopulationConfig, error) {
+	if popConfig.RunName == "" {
+		popConfig.RunName = NewRunName()
+	}
+	for _, path := range outputPaths(&popConfig) {
+		if !strings.Contains(*path, RunNamePlaceholder) {
+			continue
+		}
+		*path = strings.ReplaceAll(*path, RunNamePlaceholder, popConfig.RunName)
+		if err := os.MkdirAll(filepath.Dir(*path), 0o755); err != nil {
+			return popConfig, fmt.Errorf("cannot create output directory: %w", err)
+		}
+	}
+	return popConfig, nil
+}
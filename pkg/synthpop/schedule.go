@@ -0,0 +1,109 @@
+package synthpop
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Orders the areas are handed to the workers in (PopulationConfig.Scheduling)
+const (
+	SchedulingFile    = "file"    // The order of the constraints file (default)
+	SchedulingLargest = "largest" // Descending total population, so the largest areas start first
+)
+
+// A scheduling hint is printed when the tail takes more than tailHintShare of the
+// run and at least tailHintMin, so short runs are not flagged for noise
+const (
+	tailHintShare = 0.2
+	tailHintMin   = time.Second
+)
+
+// workerStats is the scheduling record of one worker. Each worker writes only its
+// own entry, so no locking is needed; the report is read after all have finished.
+type workerStats struct {
+	busy     time.Duration // Time spent synthesizing, excluding waits for jobs or the writer
+	areas    int
+	finished time.Time
+}
+
+// scheduleStats collects per-worker busy time to diagnose long single-threaded
+// tails, e.g. when a few huge areas are picked up at the end of the run
+type scheduleStats struct {
+	start   time.Time
+	workers []workerStats
+}
+
+func newScheduleStats(numWorkers int) *scheduleStats {
+	return &scheduleStats{start: time.Now(), workers: make([]workerStats, numWorkers)}
+}
+
+// report prints the busy time spread and the tail, the time between the first
+// worker running out of areas and the last one finishing
+func (s *scheduleStats) report() {
+	if len(s.workers) < 2 {
+		return
+	}
+	end := s.start
+	firstIdle := s.workers[0].finished
+	var total, minBusy, maxBusy time.Duration
+	slowest := 0
+	for i, w := range s.workers {
+		total += w.busy
+		if i == 0 || w.busy < minBusy {
+			minBusy = w.busy
+		}
+		if w.busy > maxBusy {
+			maxBusy, slowest = w.busy, i
+		}
+		if w.finished.After(end) {
+			end = w.finished
+		}
+		if w.finished.Before(firstIdle) {
+			firstIdle = w.finished
+		}
+	}
+	elapsed := end.Sub(s.start)
+	if elapsed <= 0 {
+		return
+	}
+	mean := total / time.Duration(len(s.workers))
+	utilisation := float64(total) / (float64(elapsed) * float64(len(s.workers))) * 100
+	tail := end.Sub(firstIdle)
+	tailShare := float64(tail) / float64(elapsed)
+
+	Printf("🧵 Worker busy time: min %v, mean %v, max %v (worker %d, %d areas), utilisation %.0f%%\n",
+		minBusy.Round(time.Millisecond), mean.Round(time.Millisecond), maxBusy.Round(time.Millisecond),
+		slowest, s.workers[slowest].areas, utilisation)
+	Printf("⏳ Tail with idle workers: %v (%.0f%% of the run)\n", tail.Round(time.Millisecond), tailShare*100)
+	if tailShare > tailHintShare && tail >= tailHintMin {
+		Println("💡 The tail dominates: large areas were probably picked up late. Set \"scheduling\": \"largest\" " +
+			"so the largest areas start first, or split the largest areas.")
+	}
+}
+
+// checkScheduling validates PopulationConfig.Scheduling
+func checkScheduling(scheduling string) error {
+	switch scheduling {
+	case "", SchedulingFile, SchedulingLargest:
+		return nil
+	}
+	return fmt.Errorf("invalid scheduling '%s'. Must be one of: %s, %s", scheduling, SchedulingFile, SchedulingLargest)
+}
+
+// scheduleAreas returns the areas in the order they are handed to the workers.
+// Largest-first keeps a big area processed last from running alone long after the
+// other workers ran out of areas; areas of equal size keep their file order. The
+// outputs are written as areas complete either way, and every area's random numbers
+// depend only on its ID, so the order changes no population.
+func scheduleAreas(constraints []ConstraintData, scheduling string) []ConstraintData {
+	if scheduling != SchedulingLargest {
+		return constraints
+	}
+	ordered := slices.Clone(constraints)
+	slices.SortStableFunc(ordered, func(a, b ConstraintData) int {
+		return cmp.Compare(b.Total, a.Total)
+	})
+	return ordered
+}
@@ -0,0 +1,805 @@
+package synthpop
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Constants defining distance metrics and numerical stability parameters
+const (
+	// EPSILON is a small value to prevent division by zero and ensure numerical stability
+	EPSILON = 1e-10
+
+	// Distance metric types
+	KL_DIVERGENCE  = iota // Kullback-Leibler divergence
+	CHI_SQUARED           // Chi-squared distance
+	EUCLIDEAN             // Standard Euclidean distance
+	NORM_EUCLIDEAN        // Normalized Euclidean distance
+	MANHATTEN             // Manhattan distance
+	COSINE                // Cosine distance
+	JSDIVERGENCE          // Jensen-Shannon divergence
+)
+
+// metricByName maps the names accepted in AnnealingConfig.Distance (see ValidMetrics)
+// to the metric constants
+var metricByName = map[string]int{
+	"KL_DIVERGENCE":  KL_DIVERGENCE,
+	"CHI_SQUARED":    CHI_SQUARED,
+	"EUCLIDEAN":      EUCLIDEAN,
+	"NORM_EUCLIDEAN": NORM_EUCLIDEAN,
+	"MANHATTEN":      MANHATTEN,
+	"COSINE":         COSINE,
+	"JSDIVERGENCE":   JSDIVERGENCE,
+}
+
+type DistanceFunc func([]float64, []float64) float64
+
+// distanceFunc returns the appropriate distance calculation function based on the configured metric.
+// It serves as a factory function for distance metrics used in simulated annealing.
+//
+// Parameters:
+//   - config: AnnealingConfig containing the distance metric specification
+//
+// Returns:
+//   - DistanceFunc: The selected distance calculation function
+//
+// Supported metrics:
+//   - "CHI_SQUARED": Chi-squared distance
+//   - "EUCLIDEAN": Standard Euclidean distance
+//   - "NORM_EUCLIDEAN": Normalized Euclidean distance
+//   - "MANHATTEN": Manhattan distance (L1 norm)
+//   - "COSINE": Cosine distance
+//   - "JSDIVERGENCE": Jensen-Shannon divergence
+//   - Default: KL Divergence
+func distanceFunc(config AnnealingConfig) DistanceFunc {
+	switch metricByName[config.Distance] {
+	case CHI_SQUARED:
+		return ChiSquaredDistance
+	case EUCLIDEAN:
+		return EuclideanDistance
+	case NORM_EUCLIDEAN:
+		return NormalizedEuclideanDistance
+	case MANHATTEN:
+		return ManhattanDistance
+	case COSINE:
+		return CosineDistance
+	case JSDIVERGENCE:
+		return JSDivergence
+	default:
+		return KLDivergence
+	}
+}
+
+// CosineDistance calculates one minus the cosine similarity of two vectors
+//
+// Parameters:
+//   - constraints: The first vector
+//   - testData: The second vector
+//
+// Returns:
+//   - The cosine distance, 0 for vectors pointing the same way, up to 1 for
+//     orthogonal non-negative vectors
+//
+// Note:
+//   - Only the direction of the vectors matters, not their scale
+//   - Two zero vectors are at distance 0; a zero and a non-zero vector at distance 1
+func CosineDistance(constraints, testData []float64) float64 {
+	dot, normConstraints, normTestData := 0.0, 0.0, 0.0
+	for i := range constraints {
+		dot += constraints[i] * testData[i]
+		normConstraints += constraints[i] * constraints[i]
+		normTestData += testData[i] * testData[i]
+	}
+	if normConstraints < EPSILON || normTestData < EPSILON {
+		if normConstraints < EPSILON && normTestData < EPSILON {
+			return 0
+		}
+		return 1
+	}
+	return 1 - dot/(math.Sqrt(normConstraints)*math.Sqrt(normTestData))
+}
+
+// JSDivergence calculates the Jensen-Shannon divergence between two distributions
+//
+// Parameters:
+//   - constraints: The target counts (P)
+//   - testData: The approximate counts (Q)
+//
+// Returns:
+//   - The JS divergence 0.5*KL(P||M) + 0.5*KL(Q||M) with M = (P+Q)/2, in nats
+//
+// Note:
+//   - Both vectors are normalised to probability distributions first
+//   - Symmetric and bounded by ln(2); a zero vector is treated as maximally distant
+func JSDivergence(constraints, testData []float64) float64 {
+	sumP, sumQ := 0.0, 0.0
+	for i := range constraints {
+		sumP += constraints[i]
+		sumQ += testData[i]
+	}
+	if sumP < EPSILON || sumQ < EPSILON {
+		if sumP < EPSILON && sumQ < EPSILON {
+			return 0
+		}
+		return math.Ln2
+	}
+
+	divergence := 0.0
+	for i := range constraints {
+		p := constraints[i] / sumP
+		q := testData[i] / sumQ
+		m := (p + q) / 2
+		if p > 0 {
+			divergence += 0.5 * p * math.Log(p/m)
+		}
+		if q > 0 {
+			divergence += 0.5 * q * math.Log(q/m)
+		}
+	}
+	return divergence
+}
+
+// KLDivergence calculates the Kullback-Leibler divergence between two distributions
+//
+// Parameters:
+//   - constraints: The target probability distribution (P)
+//   - testData: The approximate distribution (Q)
+//
+// Returns:
+//   - The KL divergence D(P||Q)
+//
+// Note:
+//   - Uses EPSILON to avoid numerical instability
+func KLDivergence(constraints, testData []float64) float64 {
+	divergence := 0.0
+	for i := range constraints {
+		p := constraints[i] + EPSILON
+		q := testData[i] + EPSILON
+		divergence += p * math.Log(p/q)
+	}
+	return divergence
+}
+
+// ChiSquaredDistance calculates the chi-squared distance between observed and expected values
+//
+// Parameters:
+//   - constraints: The expected values
+//   - testData: The observed values
+//
+// Returns:
+//   - The chi-squared statistic
+func ChiSquaredDistance(constraints, testData []float64) float64 {
+	distance := 0.0
+	for i := range constraints {
+		observed := testData[i] + EPSILON
+		expected := constraints[i] + EPSILON
+		diff := observed - expected
+		distance += (diff * diff) / expected
+	}
+	return distance
+}
+
+// EuclideanDistance calculates the standard Euclidean distance between two vectors
+//
+// Parameters:
+//   - constraints: The first vector
+//   - testData: The second vector
+//
+// Returns:
+//   - The Euclidean distance (L2 norm)
+func EuclideanDistance(constraints, testData []float64) float64 {
+	distance := 0.0
+	for i := range constraints {
+		diff := testData[i] - constraints[i]
+		distance += diff * diff
+	}
+	return math.Sqrt(distance)
+}
+
+// NormalizedEuclideanDistance calculates a normalized version of Euclidean distance
+//
+// Parameters:
+//   - constraints: The reference vector used for normalization
+//   - testData: The vector to compare
+//
+// Returns:
+//   - The normalized Euclidean distance
+//
+// Note:
+//   - Applies special handling for zero/very small constraints
+//   - Adds large penalty for violating zero constraints
+func NormalizedEuclideanDistance(constraints, testData []float64) float64 {
+	distance := 0.0
+	for i := range constraints {
+		norm := constraints[i]
+		if math.Abs(norm) < EPSILON {
+			if math.Abs(testData[i]) > EPSILON {
+				distance += DefaultHardPenalty * testData[i] * testData[i]
+			}
+			continue
+		}
+		diff := (testData[i] - constraints[i]) / norm
+		distance += diff * diff
+	}
+	return math.Sqrt(distance)
+}
+
+// ManhattanDistance calculates the Manhattan distance (L1 norm) between two vectors
+//
+// Parameters:
+//   - constraints: The first vector
+//   - testData: The second vector
+//
+// Returns:
+//   - The Manhattan distance
+func ManhattanDistance(constraints, testData []float64) float64 {
+	distance := 0.0
+	for i := range constraints {
+		distance += math.Abs(testData[i] - constraints[i])
+	}
+	return distance
+}
+
+// replaceValue copies values from new slice to old slice
+//
+// Parameters:
+//   - old: The destination slice
+//   - new: The source slice
+//
+// Note:
+//   - Modifies the old slice in-place
+func replaceValue(old []float64, new []float64) {
+	for i := range old {
+		old[i] = new[i]
+	}
+}
+
+// isValidMicrodata checks if microdata values satisfy all constraints
+//
+// Parameters:
+//   - mdValues: The microdata values to check
+//   - constraints: The constraints to validate against
+//
+// Returns:
+//   - true if all zero constraints are satisfied, false otherwise
+func isValidMicrodata(mdValues, constraints []float64) bool {
+	for i, constraintVal := range constraints {
+		if constraintVal == 0 && mdValues[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// moveOutcome is what became of a proposed replace move
+type moveOutcome int
+
+const (
+	moveImproved      moveOutcome = iota // Accepted, and the fitness did not get worse
+	moveAcceptedWorse                    // Accepted although the fitness got worse
+	moveReverted                         // Turned down by the acceptance criterion
+)
+
+// accepted reports whether the move was kept
+func (m moveOutcome) accepted() bool {
+	return m == moveImproved || m == moveAcceptedWorse
+}
+
+// moveStats counts the outcomes of the moves proposed for an area
+type moveStats struct {
+	accepted, acceptedWorse, reverted int
+}
+
+func (s *moveStats) add(m moveOutcome) {
+	switch m {
+	case moveImproved:
+		s.accepted++
+	case moveAcceptedWorse:
+		s.accepted++
+		s.acceptedWorse++
+	case moveReverted:
+		s.reverted++
+	}
+}
+
+// setResult copies the counts into the result of the area
+func (s moveStats) setResult(res *Result) {
+	res.Accepted, res.AcceptedWorse, res.Reverted = s.accepted, s.acceptedWorse, s.reverted
+}
+
+// Acceptance rules of the annealing moves
+const (
+	AcceptMetropolis = "metropolis" // Worse moves kept with probability exp(-delta/temp) (default)
+	AcceptGreedy     = "greedy"     // Worse moves never kept
+	AcceptThreshold  = "threshold"  // Worse moves kept when delta is below temp
+)
+
+// ValidAcceptanceRules lists the accepted values of AnnealingConfig.Acceptance
+var ValidAcceptanceRules = []string{AcceptMetropolis, AcceptGreedy, AcceptThreshold}
+
+// acceptMove decides whether to keep a move that changes the fitness by delta
+// (positive when it gets worse). Moves that do not make the fitness worse are
+// always kept; rng is only drawn from by the Metropolis rule, for worse moves.
+//
+// Parameters:
+//   - rule: The acceptance rule, "" for the Metropolis criterion
+//   - delta: New fitness minus current fitness
+//   - temp: Current temperature
+//   - rng: Random number generator
+//
+// Returns:
+//   - true if the move is kept
+func acceptMove(rule string, delta, temp float64, rng *rand.Rand) bool {
+	if delta <= 0 {
+		return true
+	}
+	switch rule {
+	case AcceptGreedy:
+		return false
+	case AcceptThreshold:
+		return delta < temp
+	default:
+		return rng.Float64() < math.Exp(-delta/temp)
+	}
+}
+
+// replace performs a replacement operation in the synthetic population using simulated annealing
+//
+// Parameters:
+//   - microdata: The source microdata records
+//   - constraint: The area constraints
+//   - pool: The records valid for the area, the replacement is drawn from
+//   - synthPopTotals: Current aggregate statistics
+//   - synthPopMicrodataIndexess: Current population indices
+//   - fitness: Current fitness score
+//   - temp: Current temperature
+//   - rule: Acceptance rule of the move (see acceptMove)
+//   - rng: Random number generator
+//   - distfunc: Fitness function
+//   - delta: Incremental form of distfunc holding the current population's terms
+//     (see deltaFitness.reset), nil to evaluate distfunc in full
+//
+// Returns:
+//   - newFitness: The fitness after replacement
+//   - moveOutcome: Whether the replacement was accepted or reverted
+func replace(microdata []MicroData, constraint ConstraintData, pool validPool, synthPopTotals []float64,
+	synthPopMicrodataIndexess []int, fitness float64, temp float64, rule string, rng *rand.Rand, distfunc DistanceFunc,
+	delta *deltaFitness) (float64, moveOutcome) {
+
+	outcome := moveImproved
+
+	// Draw a valid replacement candidate
+	randomReplacmentIndex := pool.draw(rng)
+	newValues := microdata[randomReplacmentIndex].Values
+
+	// Perform replacement
+	randomReplceIndex := rng.Intn(len(synthPopMicrodataIndexess))
+	replacementIndex := synthPopMicrodataIndexess[randomReplceIndex]
+	oldValues := microdata[replacementIndex].Values
+
+	// Update aggregates, only where the records differ when evaluating incrementally
+	var newFitness float64
+	if delta != nil {
+		newFitness = delta.propose(replacementIndex, randomReplacmentIndex)
+	} else {
+		for i := 0; i < len(synthPopTotals); i++ {
+			synthPopTotals[i] = synthPopTotals[i] - oldValues[i] + newValues[i]
+		}
+		newFitness = distfunc(constraint.Values, synthPopTotals)
+	}
+
+	if !acceptMove(rule, newFitness-fitness, temp, rng) {
+		// Revert changes
+		if delta != nil {
+			delta.revert()
+		} else {
+			for i := 0; i < len(synthPopTotals); i++ {
+				synthPopTotals[i] = synthPopTotals[i] - newValues[i] + oldValues[i]
+			}
+		}
+		newFitness = fitness
+		outcome = moveReverted
+	} else {
+		// Accept changes
+		synthPopMicrodataIndexess[randomReplceIndex] = randomReplacmentIndex
+		if delta != nil {
+			newFitness = delta.commit()
+		}
+		if newFitness > fitness {
+			outcome = moveAcceptedWorse
+		}
+	}
+
+	return newFitness, outcome
+}
+
+// Tie-breaking rules for solutions with equal best fitness
+const (
+	TieBreakFirst = "first" // Keep the first solution found (default)
+	TieBreakHash  = "hash"  // Keep the solution with the smallest assignment hash
+)
+
+// assignmentHash is an FNV-1a hash of the sorted microdata IDs of a population. It
+// depends only on which records are selected, not on their order in the population
+// or in the microdata file.
+func assignmentHash(indices []int, microdata []MicroData, scratch *annealScratch) uint64 {
+	ids := scratch.sortedIDs[:0]
+	for _, index := range indices {
+		ids = append(ids, microdata[index].ID)
+	}
+	sort.Strings(ids)
+	scratch.sortedIDs = ids
+
+	hash := fnv.New64a()
+	for _, id := range ids {
+		hash.Write([]byte(id))
+		hash.Write([]byte{0})
+	}
+	return hash.Sum64()
+}
+
+// annealScratch holds the working buffers of one worker. They are sized to the
+// constraint vector and population of the current area and reused across areas,
+// so a worker allocates only when it meets a larger area than any seen before.
+type annealScratch struct {
+	totals       []float64
+	bestTotals   []float64
+	window       []float64
+	indices      []int
+	bestIndices  []int
+	valid        validPool      // Records valid for the current area, set by initPopulation
+	validity     *validityIndex // Valid records of the run's zero patterns, shared by the workers
+	validIndices []int          // Valid records of areas not in the validity index
+	validWeights []float64      // Cumulative design weights of validIndices, nil when unweighted
+	zeros        []byte
+	seed         []int // Microdata indices a previous run chose for the area (see SeedPopulationFile), set per area
+	tempScales   []float64
+	sortedIDs    []string
+	traceEvery   int           // Trace sampling interval in iterations (0 disables tracing)
+	delta        *deltaFitness // Incremental form of the worker's distance, nil when it has none
+	columns      *sparseIndex  // Non-zero columns of the microdata, shared by the workers
+	indexed      sparseKey     // Microdata the indexes of a pooled scratch were built for (see indexFor)
+
+	// Parallel tempering: the chains after the first, a factory of distance
+	// functions for them (nil runs the chains in turn on the worker's function) and
+	// the cores they may borrow
+	replicas    []*temperingReplica
+	newDistance func() DistanceFunc
+	cores       coreBudget
+
+	// Time budgets: the end of the run (zero for none), and the time the search of
+	// the current area stops at, set by synthesizeArea
+	runDeadline time.Time
+	deadline    time.Time
+}
+
+// scratchPool holds the buffers of areas synthesized outside a run's workers (see
+// SynthesizeArea), so library callers and the benchmark looping over areas reuse
+// them as the workers do instead of allocating a fresh set for every area
+var scratchPool = sync.Pool{New: func() any { return new(annealScratch) }}
+
+// indexFor gives a pooled scratch the sparse and validity indexes of microData. The
+// indexes it holds are kept when they were built for the same records, so a caller
+// synthesizing area after area from one microdata slice indexes it once; a scratch
+// holds the indexes of one slice at a time.
+func (s *annealScratch) indexFor(microData []MicroData) {
+	var key sparseKey
+	if len(microData) > 0 {
+		key = sparseKey{&microData[0], len(microData)}
+	}
+	if s.columns == nil || s.validity == nil || s.indexed != key {
+		s.columns, s.validity, s.indexed = newSparseIndex(), newValidityIndex(), key
+	}
+}
+
+// deadlineCheckInterval is how many iterations the searches run between looks at
+// the clock, which costs more than an iteration of a small area
+const deadlineCheckInterval = 256
+
+// expired reports whether the time budget of the current area is spent
+func (s *annealScratch) expired() bool {
+	return !s.deadline.IsZero() && time.Now().After(s.deadline)
+}
+
+// areaDeadline returns the time the search of an area starting now must stop at:
+// after MaxSecondsPerArea, and no later than the end of the run
+func areaDeadline(config AnnealingConfig, runDeadline time.Time) time.Time {
+	if config.MaxSecondsPerArea <= 0 {
+		return runDeadline
+	}
+	deadline := time.Now().Add(time.Duration(config.MaxSecondsPerArea * float64(time.Second)))
+	if !runDeadline.IsZero() && runDeadline.Before(deadline) {
+		return runDeadline
+	}
+	return deadline
+}
+
+// Bounds of the adaptive stagnation window
+const (
+	minStagnationWindow     = 100 // Iterations
+	windowSweeps            = 10  // Replacement sweeps over the population per window
+	windowBudgetDenominator = 20  // The window is at most this share of maxIterations
+)
+
+// stagnationWindow returns the number of recent iterations used to detect
+// stagnation: config.WindowSize when set, otherwise a window scaled with the area.
+// A fixed window misfires both ways: in a tiny area a thousand iterations are many
+// sweeps over the population and stagnation is noticed late, in a huge area they
+// do not touch every individual once and the search is stopped while it still
+// improves. The adaptive window covers windowSweeps sweeps over the population,
+// at least minStagnationWindow iterations and at most 1/windowBudgetDenominator of
+// maxIterations, so stagnation can still be detected within the budget.
+func stagnationWindow(config AnnealingConfig, population float64) int {
+	if config.WindowSize > 0 {
+		return config.WindowSize
+	}
+	window := max(int(math.Ceil(population))*windowSweeps, minStagnationWindow)
+	if config.MaxIterations > 0 {
+		window = min(window, max(config.MaxIterations/windowBudgetDenominator, 1))
+	}
+	return window
+}
+
+// floatBuffer returns buf resized to n zeroed elements, reallocating only when needed
+func floatBuffer(buf []float64, n int) []float64 {
+	if cap(buf) < n {
+		return make([]float64, n)
+	}
+	buf = buf[:n]
+	for i := range buf {
+		buf[i] = 0
+	}
+	return buf
+}
+
+// intBuffer returns buf resized to n elements, reallocating only when needed
+func intBuffer(buf []int, n int) []int {
+	if cap(buf) < n {
+		return make([]int, n)
+	}
+	return buf[:n]
+}
+
+// ErrNoValidMicrodata is returned for an area where every microdata record has a
+// non-zero value for a variable the area constrains to zero
+var ErrNoValidMicrodata = errors.New("no valid microdata records match the constraints")
+
+// initPopulation creates an initial synthetic population for an area, from the
+// area's seed population first when warm-starting
+//
+// Parameters:
+//   - constraint: The area constraints
+//   - microdata: The source microdata
+//   - scratch: Worker buffers the population is built in
+//   - rng: Random number generator drawing the initial individuals
+//
+// Returns:
+//   - synthPopTotals: Initial aggregate statistics
+//   - synthPopMicrodataIndexs: Indices of selected microdata records
+//   - error: ErrNoValidMicrodata if no record satisfies the area's zero constraints
+func initPopulation(constraint ConstraintData, microdata []MicroData, scratch *annealScratch, rng *rand.Rand) ([]float64, []int, error) {
+	scratch.totals = floatBuffer(scratch.totals, len(constraint.Values))
+	scratch.indices = intBuffer(scratch.indices, int(constraint.Total))
+	synthPopTotals := scratch.totals
+	synthPopMicrodataIndexs := scratch.indices
+
+	// Valid microdata, shared with the areas of the same zero pattern
+	scratch.valid = scratch.validity.pool(microdata, constraint.Values, scratch)
+	if len(scratch.valid.indices) == 0 {
+		return nil, nil, fmt.Errorf("area %s: %w", constraint.ID, ErrNoValidMicrodata)
+	}
+
+	// Create initial population: the individuals of the area's seed valid for it,
+	// when warm-starting, then records drawn by design weight
+	n := 0
+	for _, index := range scratch.seed {
+		if n == len(synthPopMicrodataIndexs) {
+			break
+		}
+		if isValidMicrodata(microdata[index].Values, constraint.Values) {
+			synthPopMicrodataIndexs[n] = index
+			n++
+		}
+	}
+	scratch.seed = nil // Restarts start from populations of their own
+	for i := n; i < len(synthPopMicrodataIndexs); i++ {
+		synthPopMicrodataIndexs[i] = scratch.valid.draw(rng)
+	}
+	for _, index := range synthPopMicrodataIndexs {
+		for j, v := range microdata[index].Values {
+			synthPopTotals[j] += v
+		}
+	}
+
+	return synthPopTotals, synthPopMicrodataIndexs, nil
+}
+
+// syntheticPopulation generates a synthetic population for one area using simulated annealing
+//
+// Parameters:
+//   - constraint: The area constraints
+//   - microdata: The source microdata
+//   - config: Annealing configuration parameters
+//   - distanceFunction: Fitness function built by buildDistance for this worker
+//   - scratch: Worker buffers reused between areas (nil allocates fresh ones)
+//
+// Returns:
+//   - Result: The best solution found, including the iteration at which it was found
+//   - error: The area cannot be synthesized from this microdata
+func syntheticPopulation(constraint ConstraintData, microdata []MicroData, config AnnealingConfig, distanceFunction DistanceFunc,
+	rng *rand.Rand, scratch *annealScratch) (Result, error) {
+	var synthPopResults Result
+	if scratch == nil {
+		scratch = &annealScratch{}
+	}
+
+	// Initialize population and fitness
+	synthPopTotals, synthPopIDs, err := initPopulation(constraint, microdata, scratch, rng)
+	if err != nil {
+		return synthPopResults, err
+	}
+	fitness := distanceFunction(constraint.Values, synthPopTotals)
+	delta := scratch.delta
+	if config.PerVariableTemperature {
+		delta = nil
+	}
+	if delta != nil {
+		delta.reset(constraint.Values, synthPopTotals, microdata, scratch.columns.of(microdata))
+	}
+
+	// Setup annealing parameters
+	changes := config.Change
+	temp := config.InitialTemp
+	windowSize := stagnationWindow(config, constraint.Total)
+	scratch.window = floatBuffer(scratch.window, windowSize)
+	improvementWindow := scratch.window
+	windowIndex := 0
+	bestFitness := fitness
+	bestIteration := 0
+	improvementWindow[windowIndex] = fitness
+	windowIndex = (windowIndex + 1) % windowSize
+
+	if config.PerVariableTemperature {
+		scratch.tempScales = resetTemperatureScales(scratch.tempScales, len(constraint.Values))
+	}
+
+	// Ties in best fitness are kept first-found unless hash tie-breaking is on,
+	// which makes the kept solution independent of the order ties are found in
+	useHashTieBreak := config.TieBreak == TieBreakHash
+	var bestHash uint64
+	if useHashTieBreak {
+		bestHash = assignmentHash(synthPopIDs, microdata, scratch)
+	}
+
+	// Track best solution
+	scratch.bestTotals = floatBuffer(scratch.bestTotals, len(synthPopTotals))
+	bestSynthPopTotals := scratch.bestTotals
+	copy(bestSynthPopTotals, synthPopTotals)
+	scratch.bestIndices = intBuffer(scratch.bestIndices, len(synthPopIDs))
+	bestSynthPopIDs := scratch.bestIndices
+	copy(bestSynthPopIDs, synthPopIDs)
+
+	// Convergence trace, sampled every traceEvery iterations and at the last one
+	var trace []TracePoint
+	var lastPoint TracePoint
+	sampled := false
+	iterations, reheats := 0, 0
+	var moves moveStats
+	timedOut := false
+
+	// Main optimization loop
+	for iteration := 0; iteration < config.MaxIterations && changes > 0 && temp > config.MinTemp; iteration++ {
+		if iteration%deadlineCheckInterval == 0 && scratch.expired() {
+			timedOut = true
+			break
+		}
+		var outcome moveOutcome
+		if config.PerVariableTemperature {
+			fitness, outcome = replacePerVariable(microdata, constraint, scratch.valid, synthPopTotals, synthPopIDs, fitness, temp, scratch.tempScales, config.Acceptance, rng, distanceFunction)
+			coolVariables(scratch.tempScales, constraint.Values, synthPopTotals, config.CoolingRate)
+		} else {
+			fitness, outcome = replace(microdata, constraint, scratch.valid, synthPopTotals, synthPopIDs, fitness, temp, config.Acceptance, rng, distanceFunction, delta)
+		}
+		iterations++
+		moves.add(outcome)
+		flag := outcome.accepted()
+		if delta != nil && flag && fitness <= bestFitness+deltaSlack*math.Max(1, math.Abs(bestFitness)) {
+			// A possible new best or tie is compared at the exact fitness
+			fitness = delta.exact()
+		}
+		if scratch.traceEvery > 0 {
+			lastPoint = TracePoint{Iteration: iteration, Temperature: temp, Fitness: fitness, Accepted: flag}
+			if sampled = iteration%scratch.traceEvery == 0; sampled {
+				trace = append(trace, lastPoint)
+			}
+		}
+
+		// Update best solution
+		improved := fitness < bestFitness
+		if !improved && useHashTieBreak && fitness == bestFitness && flag {
+			// Equal fitness: keep the assignment with the smaller hash
+			if hash := assignmentHash(synthPopIDs, microdata, scratch); hash < bestHash {
+				improved = true
+				bestHash = hash
+			}
+		}
+		if improved {
+			if useHashTieBreak && fitness < bestFitness {
+				bestHash = assignmentHash(synthPopIDs, microdata, scratch)
+			}
+			bestFitness = fitness
+			bestIteration = iteration
+			copy(bestSynthPopTotals, synthPopTotals)
+			copy(bestSynthPopIDs, synthPopIDs)
+
+			if bestFitness <= config.FitnessThreshold {
+				break
+			}
+		}
+
+		// Track improvements
+		improvementWindow[windowIndex] = fitness
+		windowIndex = (windowIndex + 1) % windowSize
+
+		// Check for stagnation
+		if iteration >= windowSize {
+			windowBest, windowWorst := improvementWindow[0], improvementWindow[0]
+			for _, val := range improvementWindow {
+				if val < windowBest {
+					windowBest = val
+				}
+				if val > windowWorst {
+					windowWorst = val
+				}
+			}
+
+			relativeImprovement := (windowWorst - windowBest) / windowWorst
+			if relativeImprovement < config.MinImprovement {
+				reheats++
+				temp = math.Max(temp*(1+config.ReheatFactor), config.InitialTemp*0.1)
+				if relativeImprovement < config.MinImprovement/10 {
+					break
+				}
+			}
+		}
+
+		temp *= config.CoolingRate
+
+		if !flag {
+			changes--
+		}
+	}
+
+	if !sampled && lastPoint.Iteration > 0 {
+		trace = append(trace, lastPoint)
+	}
+
+	// Prepare results
+	synthPopResults.Area = constraint.ID
+	synthPopResults.Trace = trace
+	// Results outlive the scratch buffers, so they get their own copy of the totals
+	synthPopResults.Totals = append([]float64(nil), bestSynthPopTotals...)
+	synthPopResults.IDs = make([]string, len(bestSynthPopIDs))
+	for i, id := range bestSynthPopIDs {
+		synthPopResults.IDs[i] = microdata[id].ID
+	}
+	synthPopResults.ConstraintTotals = constraint.Values
+	synthPopResults.Fitness = bestFitness
+	synthPopResults.BestIteration = bestIteration
+	synthPopResults.Iterations = iterations
+	moves.setResult(&synthPopResults)
+	synthPopResults.FinalTemperature = temp
+	synthPopResults.WindowSize = windowSize
+	synthPopResults.Reheats = reheats
+	synthPopResults.TimedOut = timedOut
+	synthPopResults.Population = constraint.Total
+	synthPopResults.PoolSize = len(scratch.valid.indices)
+
+	return synthPopResults, nil
+}
@@ -0,0 +1,132 @@
+package synthpop
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestAcceptMove(t *testing.T) {
+	const draws = 20000
+	tests := []struct {
+		name  string
+		rule  string
+		delta float64
+		temp  float64
+		want  float64 // Share of the moves kept
+	}{
+		{"metropolis improving", AcceptMetropolis, -1, 1e-9, 1},
+		{"metropolis unchanged", AcceptMetropolis, 0, 1e-9, 1},
+		{"metropolis worse hot", AcceptMetropolis, 1, 1e6, math.Exp(-1e-6)},
+		{"metropolis worse warm", AcceptMetropolis, 1, 1, math.Exp(-1)},
+		{"metropolis worse cold", AcceptMetropolis, 1, 1e-9, 0},
+		{"greedy improving", AcceptGreedy, -1, 1e6, 1},
+		{"greedy unchanged", AcceptGreedy, 0, 1e-9, 1},
+		{"greedy worse hot", AcceptGreedy, 1e-6, 1e6, 0},
+		{"greedy worse cold", AcceptGreedy, 1, 1e-9, 0},
+		{"threshold improving", AcceptThreshold, -1, 1e-9, 1},
+		{"threshold worse below temperature", AcceptThreshold, 1, 1e6, 1},
+		{"threshold worse at temperature", AcceptThreshold, 1, 1, 0},
+		{"threshold worse above temperature", AcceptThreshold, 1, 1e-9, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(42))
+			kept := 0
+			for range draws {
+				if acceptMove(tt.rule, tt.delta, tt.temp, rng) {
+					kept++
+				}
+			}
+			// Three standard deviations of the share kept
+			tolerance := 3 * math.Sqrt(tt.want*(1-tt.want)/draws)
+			if got := float64(kept) / draws; math.Abs(got-tt.want) > tolerance {
+				t.Errorf("kept %v of the moves, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAcceptMoveDrawsOnlyForWorseMetropolis(t *testing.T) {
+	for _, rule := range ValidAcceptanceRules {
+		for _, delta := range []float64{-1, 0, 1} {
+			rng, reference := rand.New(rand.NewSource(7)), rand.New(rand.NewSource(7))
+			acceptMove(rule, delta, 1, rng)
+			if delta > 0 && rule == AcceptMetropolis {
+				reference.Float64()
+			}
+			if got, want := rng.Int63(), reference.Int63(); got != want {
+				t.Errorf("%q with delta %v: drew from the generator differently", rule, delta)
+			}
+		}
+	}
+}
+
+func TestCosineDistance(t *testing.T) {
+	tests := []struct {
+		name string
+		c, q []float64
+		want float64
+	}{
+		{"identical", []float64{3, 4, 5}, []float64{3, 4, 5}, 0},
+		{"same direction", []float64{1, 2}, []float64{2, 4}, 0},
+		{"orthogonal", []float64{1, 0}, []float64{0, 1}, 1},
+		{"diagonal", []float64{1, 0}, []float64{1, 1}, 1 - 1/math.Sqrt2},
+		{"rotated", []float64{3, 4}, []float64{4, 3}, 1 - 24.0/25},
+		{"opposite", []float64{1, 2}, []float64{-1, -2}, 2},
+		{"zero target", []float64{0, 0}, []float64{1, 2}, 1},
+		{"zero synthetic", []float64{1, 2}, []float64{0, 0}, 1},
+		{"both zero", []float64{0, 0}, []float64{0, 0}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := CosineDistance(tt.c, tt.q)
+			if math.IsNaN(got) || math.Abs(got-tt.want) > 1e-12 {
+				t.Errorf("CosineDistance(%v, %v) = %v, want %v", tt.c, tt.q, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSDivergence(t *testing.T) {
+	tests := []struct {
+		name string
+		p, q []float64
+		want float64
+	}{
+		{"identical", []float64{2, 5, 3}, []float64{2, 5, 3}, 0},
+		{"same shares", []float64{1, 2}, []float64{10, 20}, 0},
+		{"disjoint", []float64{1, 0}, []float64{0, 1}, math.Ln2},
+		// P = (1/2, 1/2), Q = (1, 0), M = (3/4, 1/4)
+		{"half overlap", []float64{1, 1}, []float64{1, 0},
+			0.25*math.Log(2.0/3) + 0.25*math.Log(2) + 0.5*math.Log(4.0/3)},
+		{"zero target", []float64{0, 0}, []float64{1, 2}, math.Ln2},
+		{"both zero", []float64{0, 0}, []float64{0, 0}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := JSDivergence(tt.p, tt.q)
+			if math.IsNaN(got) || math.Abs(got-tt.want) > 1e-12 {
+				t.Errorf("JSDivergence(%v, %v) = %v, want %v", tt.p, tt.q, got, tt.want)
+			}
+			if back := JSDivergence(tt.q, tt.p); math.Abs(back-got) > 1e-12 {
+				t.Errorf("JSDivergence is not symmetric: %v one way, %v the other", got, back)
+			}
+		})
+	}
+
+	rng := rand.New(rand.NewSource(3))
+	for i := 0; i < 1000; i++ {
+		p, q := make([]float64, 5), make([]float64, 5)
+		for j := range p {
+			p[j], q[j] = float64(rng.Intn(4)), float64(rng.Intn(4))
+		}
+		d := JSDivergence(p, q)
+		if math.IsNaN(d) || d < 0 || d > math.Ln2+1e-12 {
+			t.Fatalf("JSDivergence(%v, %v) = %v, outside [0, ln 2]", p, q, d)
+		}
+		if back := JSDivergence(q, p); math.Abs(back-d) > 1e-12 {
+			t.Fatalf("JSDivergence(%v, %v) = %v one way, %v the other", p, q, d, back)
+		}
+	}
+}
@@ -0,0 +1,427 @@
+package synthpop
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// SQLite output without external dependencies.
+//
+// The writer produces a database in the SQLite 3 file format holding rowid tables
+// only: leaf pages are filled and written as the rows arrive, the interior pages
+// of every table are appended once all rows are in, and page 1 (the database
+// header and the schema table) is written last over the space reserved for it.
+// Records too large for a leaf page keep their start in the page and spill the
+// rest into a chain of overflow pages, written as soon as the row arrives.
+
+const (
+	sqlitePageSize  = 4096
+	sqliteMaxLocal  = sqlitePageSize - 35             // Largest record stored without overflow pages
+	sqliteMinLocal  = (sqlitePageSize-12)*32/255 - 23 // Least of a spilled record kept on its leaf
+	sqliteHeaderLen = 100                             // Database header at the start of page 1
+	sqliteVersion   = 3045000                         // SQLite version recorded as the writer's
+)
+
+// SQLite b-tree page types
+const (
+	sqliteTableInterior = 0x05
+	sqliteTableLeaf     = 0x0d
+)
+
+// sqlitePage assembles a b-tree page: the cell pointers grow after the page header,
+// the cells from the end of the page towards them
+type sqlitePage struct {
+	buf      [sqlitePageSize]byte
+	kind     byte
+	headerAt int // 100 on page 1, after the database header
+	cells    int
+	content  int // Start of the cell content area
+}
+
+func (p *sqlitePage) reset(kind byte, headerAt int) {
+	p.buf = [sqlitePageSize]byte{}
+	p.kind, p.headerAt, p.cells, p.content = kind, headerAt, 0, sqlitePageSize
+}
+
+func (p *sqlitePage) headerSize() int {
+	if p.kind == sqliteTableInterior {
+		return 12
+	}
+	return 8
+}
+
+// fits reports whether a cell of n bytes and its pointer fit the page
+func (p *sqlitePage) fits(n int) bool {
+	return p.headerAt+p.headerSize()+2*(p.cells+1) <= p.content-n
+}
+
+func (p *sqlitePage) add(cell []byte) {
+	p.content -= len(cell)
+	copy(p.buf[p.content:], cell)
+	binary.BigEndian.PutUint16(p.buf[p.headerAt+p.headerSize()+2*p.cells:], uint16(p.content))
+	p.cells++
+}
+
+// finish writes the page header; right is the right-most child of interior pages
+func (p *sqlitePage) finish(right uint32) []byte {
+	h := p.buf[p.headerAt:]
+	h[0] = p.kind
+	binary.BigEndian.PutUint16(h[3:], uint16(p.cells))
+	binary.BigEndian.PutUint16(h[5:], uint16(p.content))
+	if p.kind == sqliteTableInterior {
+		binary.BigEndian.PutUint32(h[8:], right)
+	}
+	return p.buf[:]
+}
+
+// appendSQLiteVarint appends v in the SQLite variable-length integer encoding
+func appendSQLiteVarint(buf []byte, v uint64) []byte {
+	if v > 1<<56-1 {
+		// Nine bytes: eight groups of seven bits, then a full byte
+		var b [9]byte
+		b[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			b[i] = byte(v&0x7f) | 0x80
+			v >>= 7
+		}
+		return append(buf, b[:]...)
+	}
+	var b [8]byte
+	n := 0
+	for {
+		b[n] = byte(v & 0x7f)
+		n++
+		v >>= 7
+		if v == 0 {
+			break
+		}
+	}
+	for i := n - 1; i >= 0; i-- {
+		if i > 0 {
+			buf = append(buf, b[i]|0x80)
+		} else {
+			buf = append(buf, b[i])
+		}
+	}
+	return buf
+}
+
+func sqliteVarintLen(v uint64) int {
+	return len(appendSQLiteVarint(nil, v))
+}
+
+// appendSQLiteRecord appends the record of a row of string, int64 and float64
+// values: a header of serial types followed by the values
+func appendSQLiteRecord(buf []byte, values ...any) []byte {
+	var types, body []byte
+	for _, value := range values {
+		switch v := value.(type) {
+		case string:
+			types = appendSQLiteVarint(types, uint64(2*len(v)+13))
+			body = append(body, v...)
+		case float64:
+			types = append(types, 7)
+			body = binary.BigEndian.AppendUint64(body, math.Float64bits(v))
+		case int64:
+			switch {
+			case v == 0:
+				types = append(types, 8)
+			case v == 1:
+				types = append(types, 9)
+			default:
+				// Smallest of the 1, 2, 3, 4, 6 and 8 byte integers holding v
+				sizes := []struct {
+					serial byte
+					bytes  int
+				}{{1, 1}, {2, 2}, {3, 3}, {4, 4}, {5, 6}, {6, 8}}
+				for _, s := range sizes {
+					if s.bytes == 8 || (v >= -1<<(8*s.bytes-1) && v < 1<<(8*s.bytes-1)) {
+						types = append(types, s.serial)
+						for i := s.bytes - 1; i >= 0; i-- {
+							body = append(body, byte(v>>(8*i)))
+						}
+						break
+					}
+				}
+			}
+		default:
+			panic(fmt.Sprintf("unsupported SQLite value %T", value))
+		}
+	}
+	// The header size counts itself
+	size := len(types) + 1
+	for size != len(types)+sqliteVarintLen(uint64(size)) {
+		size = len(types) + sqliteVarintLen(uint64(size))
+	}
+	buf = appendSQLiteVarint(buf, uint64(size))
+	buf = append(buf, types...)
+	return append(buf, body...)
+}
+
+// sqliteLocalSize returns how many bytes of a record of n bytes its leaf cell
+// holds, following the format's rule so that readers find the overflow pointer
+func sqliteLocalSize(n int) int {
+	if n <= sqliteMaxLocal {
+		return n
+	}
+	local := sqliteMinLocal + (n-sqliteMinLocal)%(sqlitePageSize-4)
+	if local > sqliteMaxLocal {
+		return sqliteMinLocal
+	}
+	return local
+}
+
+// sqliteChild is a written page of a table with the largest rowid under it
+type sqliteChild struct {
+	page     uint32
+	maxRowid int64
+}
+
+// sqliteTable is a table being written
+type sqliteTable struct {
+	name   string
+	sql    string
+	leaf   sqlitePage
+	rowid  int64 // Of the last row inserted
+	leaves []sqliteChild
+}
+
+// sqliteWriter writes a database of rowid tables row by row
+type sqliteWriter struct {
+	out    *outputFile
+	retry  retryPolicy
+	pages  uint32 // Pages written so far, page 1 included
+	tables []*sqliteTable
+	record []byte
+	cell   []byte
+}
+
+// newSQLiteWriter creates the database at path with tables named by their CREATE
+// TABLE statements, in that order
+func newSQLiteWriter(path string, tables [][2]string, retry retryPolicy) (*sqliteWriter, error) {
+	out, err := createOutput(path, nil, retry)
+	if err != nil {
+		return nil, err
+	}
+	// Page 1 is written last, once the size and the table roots are known
+	if _, err := out.Write(make([]byte, sqlitePageSize)); err != nil {
+		out.Close()
+		return nil, err
+	}
+	w := &sqliteWriter{out: out, retry: retry, pages: 1}
+	for _, t := range tables {
+		table := &sqliteTable{name: t[0], sql: t[1]}
+		table.leaf.reset(sqliteTableLeaf, 0)
+		w.tables = append(w.tables, table)
+	}
+	return w, nil
+}
+
+// insert appends a row to table t
+func (w *sqliteWriter) insert(t *sqliteTable, values ...any) error {
+	w.record = appendSQLiteRecord(w.record[:0], values...)
+	local := sqliteLocalSize(len(w.record))
+	t.rowid++
+	w.cell = appendSQLiteVarint(w.cell[:0], uint64(len(w.record)))
+	w.cell = appendSQLiteVarint(w.cell, uint64(t.rowid))
+	w.cell = append(w.cell, w.record[:local]...)
+	if local < len(w.record) {
+		first, err := w.writeOverflow(w.record[local:])
+		if err != nil {
+			return err
+		}
+		w.cell = binary.BigEndian.AppendUint32(w.cell, first)
+	}
+	if !t.leaf.fits(len(w.cell)) {
+		if err := w.flushLeaf(t, t.rowid-1); err != nil {
+			return err
+		}
+	}
+	t.leaf.add(w.cell)
+	return nil
+}
+
+// writeOverflow writes data to a chain of overflow pages and returns the first.
+// Each page starts with the number of the next, 0 on the last one.
+func (w *sqliteWriter) writeOverflow(data []byte) (uint32, error) {
+	first := w.pages + 1
+	var page [sqlitePageSize]byte
+	for len(data) > 0 {
+		n := copy(page[4:], data)
+		clear(page[4+n:])
+		next := w.pages + 2
+		if n == len(data) {
+			next = 0
+		}
+		binary.BigEndian.PutUint32(page[:4], next)
+		if _, err := w.out.Write(page[:]); err != nil {
+			return 0, err
+		}
+		w.pages++
+		data = data[n:]
+	}
+	return first, nil
+}
+
+// flushLeaf writes the filled leaf page of t, whose last row is maxRowid
+func (w *sqliteWriter) flushLeaf(t *sqliteTable, maxRowid int64) error {
+	if _, err := w.out.Write(t.leaf.finish(0)); err != nil {
+		return err
+	}
+	w.pages++
+	t.leaves = append(t.leaves, sqliteChild{page: w.pages, maxRowid: maxRowid})
+	t.leaf.reset(sqliteTableLeaf, 0)
+	return nil
+}
+
+// sqliteInteriorFanout is the most children an interior page holds: cells of a
+// page number and a rowid varint of up to 9 bytes, plus the right-most pointer
+const sqliteInteriorFanout = (sqlitePageSize-12)/(4+9+2) + 1
+
+// writeInterior writes the interior pages over the leaves of t and returns its
+// root page. A table with a single leaf has it as its root.
+func (w *sqliteWriter) writeInterior(t *sqliteTable) (uint32, error) {
+	children := t.leaves
+	var page sqlitePage
+	var cell []byte
+	for len(children) > 1 {
+		// Children spread evenly over the pages, so none is left with one child
+		pages := (len(children) + sqliteInteriorFanout - 1) / sqliteInteriorFanout
+		var parents []sqliteChild
+		for p := 0; p < pages; p++ {
+			group := children[p*len(children)/pages : (p+1)*len(children)/pages]
+			page.reset(sqliteTableInterior, 0)
+			for _, child := range group[:len(group)-1] {
+				cell = binary.BigEndian.AppendUint32(cell[:0], child.page)
+				cell = appendSQLiteVarint(cell, uint64(child.maxRowid))
+				page.add(cell)
+			}
+			last := group[len(group)-1]
+			if _, err := w.out.Write(page.finish(last.page)); err != nil {
+				return 0, err
+			}
+			w.pages++
+			parents = append(parents, sqliteChild{page: w.pages, maxRowid: last.maxRowid})
+		}
+		children = parents
+	}
+	return children[0].page, nil
+}
+
+// Close writes the last leaves, the interior pages and page 1, then closes the file
+func (w *sqliteWriter) Close() error {
+	roots := make([]uint32, len(w.tables))
+	for i, t := range w.tables {
+		if t.leaf.cells > 0 || len(t.leaves) == 0 {
+			if err := w.flushLeaf(t, t.rowid); err != nil {
+				w.out.Close()
+				return err
+			}
+		}
+		root, err := w.writeInterior(t)
+		if err != nil {
+			w.out.Close()
+			return err
+		}
+		roots[i] = root
+	}
+
+	// Schema table, rooted on page 1 after the database header
+	var page sqlitePage
+	page.reset(sqliteTableLeaf, sqliteHeaderLen)
+	for i, t := range w.tables {
+		record := appendSQLiteRecord(nil, "table", t.name, t.name, int64(roots[i]), t.sql)
+		cell := appendSQLiteVarint(nil, uint64(len(record)))
+		cell = appendSQLiteVarint(cell, uint64(i+1))
+		cell = append(cell, record...)
+		if !page.fits(len(cell)) {
+			w.out.Close()
+			return fmt.Errorf("SQLite schema does not fit page 1")
+		}
+		page.add(cell)
+	}
+	first := page.finish(0)
+	h := first[:sqliteHeaderLen]
+	copy(h, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(h[16:], sqlitePageSize)
+	h[18], h[19] = 1, 1                   // Legacy journal mode
+	h[21], h[22], h[23] = 64, 32, 32      // Payload fractions, fixed by the format
+	binary.BigEndian.PutUint32(h[24:], 1) // File change counter
+	binary.BigEndian.PutUint32(h[28:], w.pages)
+	binary.BigEndian.PutUint32(h[40:], 1) // Schema cookie
+	binary.BigEndian.PutUint32(h[44:], 4) // Schema format
+	binary.BigEndian.PutUint32(h[56:], 1) // UTF-8
+	binary.BigEndian.PutUint32(h[92:], 1) // Version-valid-for, the change counter
+	binary.BigEndian.PutUint32(h[96:], sqliteVersion)
+
+	err := w.retry.do("write page 1 of "+w.out.file.Name(), func() error {
+		_, err := w.out.file.WriteAt(first, 0)
+		return err
+	})
+	if err != nil {
+		w.out.Close()
+		return err
+	}
+	return w.out.Close()
+}
+
+// sqliteOutput writes the results to the area_population and area_validation
+// tables of Output.SQLiteFile, for analysis with SQL instead of parsing the CSVs
+type sqliteOutput struct {
+	db         *sqliteWriter
+	header     []string
+	population *sqliteTable
+	validation *sqliteTable
+	counts     map[string]int64
+	order      []string
+}
+
+// newSQLiteOutput creates the database, or returns nil when none is configured
+func newSQLiteOutput(popConfig PopulationConfig, outputHeader []string, retry retryPolicy) (*sqliteOutput, error) {
+	if popConfig.Output.SQLiteFile == "" {
+		return nil, nil
+	}
+	db, err := newSQLiteWriter(popConfig.Output.SQLiteFile, [][2]string{
+		{"area_population", "CREATE TABLE area_population(area_id TEXT, microdata_id TEXT, count INTEGER)"},
+		{"area_validation", `CREATE TABLE area_validation(area_id TEXT, variable TEXT, synthetic REAL, "constraint" REAL)`},
+	}, retry)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create SQLite file: %w", err)
+	}
+	return &sqliteOutput{db: db, header: outputHeader, population: db.tables[0], validation: db.tables[1],
+		counts: make(map[string]int64)}, nil
+}
+
+// writeArea writes how many times every record was selected for the area, in the
+// order the records first appear in the population, and its totals per variable
+func (o *sqliteOutput) writeArea(res Result) error {
+	clear(o.counts)
+	o.order = o.order[:0]
+	for _, id := range res.IDs {
+		if o.counts[id] == 0 {
+			o.order = append(o.order, id)
+		}
+		o.counts[id]++
+	}
+	for _, id := range o.order {
+		if err := o.db.insert(o.population, res.Area, id, o.counts[id]); err != nil {
+			return fmt.Errorf("error writing SQLite population: %w", err)
+		}
+	}
+	for i, name := range o.header {
+		if i >= len(res.Totals) {
+			break
+		}
+		if err := o.db.insert(o.validation, res.Area, name, res.Totals[i], res.ConstraintTotals[i]); err != nil {
+			return fmt.Errorf("error writing SQLite validation: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close completes the database
+func (o *sqliteOutput) Close() error {
+	return o.db.Close()
+}
@@ -0,0 +1,176 @@
+package synthpop
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sqliteQuery runs sql on the database at path with the sqlite3 command-line tool,
+// skipping the test when it is not installed
+func sqliteQuery(t *testing.T, path, sql string) string {
+	t.Helper()
+	tool, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 command-line tool not installed")
+	}
+	out, err := exec.Command(tool, "-readonly", path, sql).CombinedOutput()
+	if err != nil {
+		t.Fatalf("sqlite3 %q: %v\n%s", sql, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestSQLiteVarint(t *testing.T) {
+	tests := []struct {
+		v    uint64
+		want string
+	}{
+		{0, "00"},
+		{127, "7f"},
+		{128, "8100"},
+		{16383, "ff7f"},
+		{16384, "818000"},
+		{1<<56 - 1, "ffffffffffffff7f"},
+		{1 << 56, "80c080808080808000"},
+		{math.MaxUint64, "ffffffffffffffffff"},
+	}
+	for _, tt := range tests {
+		got := hex.EncodeToString(appendSQLiteVarint(nil, tt.v))
+		if got != tt.want || sqliteVarintLen(tt.v) != len(tt.want)/2 {
+			t.Errorf("varint of %d is %s, want %s", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestSQLiteLocalSize(t *testing.T) {
+	for _, n := range []int{0, sqliteMaxLocal, sqliteMaxLocal + 1, 10000, 1 << 20} {
+		local := sqliteLocalSize(n)
+		if n <= sqliteMaxLocal && local != n {
+			t.Errorf("record of %d bytes keeps %d on its leaf, want all of it", n, local)
+		}
+		if n > sqliteMaxLocal && (local < sqliteMinLocal || local > sqliteMaxLocal) {
+			t.Errorf("record of %d bytes keeps %d on its leaf, outside [%d, %d]", n, local, sqliteMinLocal, sqliteMaxLocal)
+		}
+	}
+}
+
+// sqliteText returns a string of n bytes that differs along its length
+func sqliteText(n int) string {
+	var b strings.Builder
+	for i := 0; b.Len() < n; i++ {
+		fmt.Fprintf(&b, "%d,", i)
+	}
+	return b.String()[:n]
+}
+
+// TestSQLiteWriter writes rows around the page and overflow sizes, integers of
+// every serial type and enough rows for two levels of interior pages, then checks
+// the database and its contents with the sqlite3 command-line tool
+func TestSQLiteWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.sqlite")
+	w, err := newSQLiteWriter(path, [][2]string{
+		{"wide", "CREATE TABLE wide(n INTEGER, s TEXT, r REAL)"},
+		{"long", "CREATE TABLE long(n INTEGER)"},
+	}, retryPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Text sizes fill a leaf exactly, spill by a byte, end an overflow page exactly
+	// or by one byte, and switch between the two local sizes of spilled records
+	var sizes []int
+	for _, n := range []int{0, 1, 1000, sqliteMaxLocal, sqlitePageSize, 10000, 100000, 1 << 20} {
+		for d := -12; d <= 12; d += 3 {
+			if n+d >= 0 {
+				sizes = append(sizes, n+d)
+			}
+		}
+	}
+	for k := 1; k <= 3; k++ {
+		sizes = append(sizes, sqliteMaxLocal+k*(sqlitePageSize-4)-8, sqliteMinLocal+k*(sqlitePageSize-4)-8)
+	}
+	integers := []int64{0, 1, -1, 127, 128, -128, -129, 32767, 32768, 1<<23 - 1, 1 << 23,
+		1<<31 - 1, -1 << 31, 1<<47 - 1, 1 << 47, -1 << 47, math.MaxInt64, math.MinInt64}
+	for i, size := range sizes {
+		if err := w.insert(w.tables[0], integers[i%len(integers)], sqliteText(size), float64(i)/4-3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const rows = 400000
+	for i := range rows {
+		if err := w.insert(w.tables[1], int64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := sqliteQuery(t, path, "PRAGMA integrity_check"); got != "ok" {
+		t.Fatalf("integrity check failed:\n%s", got)
+	}
+	if got := sqliteQuery(t, path, "SELECT count(*), min(n), max(n), sum(n) FROM long"); got != fmt.Sprintf("%d|0|%d|%d", rows, rows-1, rows*(rows-1)/2) {
+		t.Errorf("table long reads as %s", got)
+	}
+	lines := strings.Split(sqliteQuery(t, path, "SELECT rowid, n, length(s), printf('%.2f', r) FROM wide ORDER BY rowid"), "\n")
+	if len(lines) != len(sizes) {
+		t.Fatalf("table wide has %d rows, wrote %d", len(lines), len(sizes))
+	}
+	for i, size := range sizes {
+		want := fmt.Sprintf("%d|%d|%d|%.2f", i+1, integers[i%len(integers)], size, float64(i)/4-3)
+		if lines[i] != want {
+			t.Errorf("row %d reads as %s, want %s", i+1, lines[i], want)
+		}
+	}
+	// The text itself, through every overflow page of the longest rows
+	for _, row := range []int{len(sizes) - 7, len(sizes) - 1} {
+		got := sqliteQuery(t, path, fmt.Sprintf("SELECT hex(s) FROM wide WHERE rowid = %d", row+1))
+		if want := strings.ToUpper(hex.EncodeToString([]byte(sqliteText(sizes[row])))); got != want {
+			t.Errorf("text of row %d (%d bytes) differs from the one written", row+1, sizes[row])
+		}
+	}
+}
+
+func TestSQLiteOutput(t *testing.T) {
+	var popConfig PopulationConfig
+	popConfig.Output.SQLiteFile = filepath.Join(t.TempDir(), "output.sqlite")
+	o, err := newSQLiteOutput(popConfig, []string{"male", "female"}, retryPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := []Result{
+		{Area: "E01", IDs: []string{"p2", "p1", "p2", "p2"}, Totals: []float64{3, 1}, ConstraintTotals: []float64{2.5, 1.5}},
+		// An ID long enough to need overflow pages
+		{Area: "E02", IDs: []string{strings.Repeat("x", 9000)}, Totals: []float64{0, 1}, ConstraintTotals: []float64{0, 1}},
+	}
+	for _, res := range results {
+		if err := o.writeArea(res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := sqliteQuery(t, popConfig.Output.SQLiteFile, "PRAGMA integrity_check"); got != "ok" {
+		t.Fatalf("integrity check failed:\n%s", got)
+	}
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT area_id, microdata_id, count FROM area_population WHERE area_id = 'E01'", "E01|p2|3\nE01|p1|1"},
+		{"SELECT area_id, length(microdata_id), count FROM area_population WHERE area_id = 'E02'", "E02|9000|1"},
+		{`SELECT area_id, variable, synthetic, "constraint" FROM area_validation`,
+			"E01|male|3.0|2.5\nE01|female|1.0|1.5\nE02|male|0.0|0.0\nE02|female|1.0|1.0"},
+	}
+	for _, tt := range tests {
+		if got := sqliteQuery(t, popConfig.Output.SQLiteFile, tt.sql); got != tt.want {
+			t.Errorf("%s:\ngot  %q\nwant %q", tt.sql, got, tt.want)
+		}
+	}
+}
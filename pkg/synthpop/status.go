@@ -0,0 +1,145 @@
+package synthpop
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Run states reported in the status file
+const (
+	statusRunning   = "running"
+	statusCompleted = "completed"
+	statusFailed    = "failed"
+)
+
+// defaultStatusInterval is used when a status file is set without an interval
+const defaultStatusInterval = 10 * time.Second
+
+// runStatus is the heartbeat written for external watchdogs. A watchdog can treat a
+// stale timestamp as a hung run and an advancing areasDone as a slow but live one.
+type runStatus struct {
+	RunName    string    `json:"runName"`
+	Timestamp  time.Time `json:"timestamp"`
+	State      string    `json:"state"`
+	AreasDone  int       `json:"areasDone"`
+	AreasTotal int       `json:"areasTotal"`
+	StartedAt  time.Time `json:"startedAt"`
+	PID        int       `json:"pid"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// writeStatus replaces the status file atomically so readers never see a partial file
+func writeStatus(path string, status runStatus) error {
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".status-*.json")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// statusInterval returns the configured heartbeat period
+func statusInterval(popConfig PopulationConfig) time.Duration {
+	if popConfig.Status.IntervalSeconds > 0 {
+		return time.Duration(popConfig.Status.IntervalSeconds) * time.Second
+	}
+	return defaultStatusInterval
+}
+
+// statusReporter rewrites the status file on a timer until the run finishes.
+// A nil reporter (no status file configured) ignores all calls.
+type statusReporter struct {
+	mu        sync.Mutex
+	path      string
+	runName   string
+	startedAt time.Time
+	total     func() int
+	done      func() int
+	finished  bool
+	stop      chan struct{}
+}
+
+// startStatusReporter writes an initial running status and starts the heartbeat
+func startStatusReporter(popConfig PopulationConfig, total, done func() int) *statusReporter {
+	if popConfig.Status.File == "" {
+		return nil
+	}
+	r := &statusReporter{
+		path:      popConfig.Status.File,
+		runName:   popConfig.RunName,
+		startedAt: time.Now(),
+		total:     total,
+		done:      done,
+		stop:      make(chan struct{}),
+	}
+	r.report(statusRunning, nil)
+
+	ticker := time.NewTicker(statusInterval(popConfig))
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.report(statusRunning, nil)
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+	return r
+}
+
+func (r *statusReporter) report(state string, runErr error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.finished {
+		return
+	}
+	status := runStatus{
+		RunName:    r.runName,
+		Timestamp:  time.Now(),
+		State:      state,
+		AreasDone:  r.done(),
+		AreasTotal: r.total(),
+		StartedAt:  r.startedAt,
+		PID:        os.Getpid(),
+	}
+	if runErr != nil {
+		status.Error = runErr.Error()
+	}
+	if err := writeStatus(r.path, status); err != nil {
+		log.Printf("cannot write status file: %v", err)
+	}
+}
+
+// finish stops the heartbeat and writes the final completed or failed state
+func (r *statusReporter) finish(runErr error) {
+	if r == nil {
+		return
+	}
+	state := statusCompleted
+	if runErr != nil {
+		state = statusFailed
+	}
+	close(r.stop)
+	r.report(state, runErr)
+	r.mu.Lock()
+	r.finished = true
+	r.mu.Unlock()
+}
@@ -0,0 +1,263 @@
+// Package synthpop generates spatial synthetic populations by selecting microdata
+// records for every area so that their aggregate totals match the area's census
+// constraints, using simulated annealing.
+//
+// The main entry points are:
+//   - Controller: load, validate, synthesize and report a population config; every
+//     front-end runs configs through it
+//   - Run: synthesize every area in parallel and write the configured outputs
+//   - SynthesizeArea / SynthesizeAreas: synthesize one or many areas in memory
+//   - ReadConstraintCSV / ReadMicroDataCSV: load the inputs
+//   - LoadHouseholdInputs: load linked household and person inputs for joint synthesis
+//   - LoadConfig / LoadAnnealingConfig: load the JSON configuration files, and
+//     ParseConfig / ParseAnnealingConfig configs given inline or by path
+//
+// The command-line front-end in the repository root is a thin wrapper around this package.
+package synthpop
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Synthesis algorithms for AnnealingConfig.Algorithm
+const (
+	AlgorithmAnnealing = "annealing" // Simulated annealing over integer populations (default)
+	AlgorithmIPF       = "ipf"       // Iterative proportional fitting of record weights
+	AlgorithmGA        = "ga"        // Genetic algorithm over candidate populations
+	AlgorithmTabu      = "tabu"      // Tabu search over integer populations
+)
+
+// validAlgorithms lists the accepted values of AnnealingConfig.Algorithm
+var validAlgorithms = []string{AlgorithmAnnealing, AlgorithmIPF, AlgorithmGA, AlgorithmTabu}
+
+// MicroData is one microdata (survey) record: its ID, its value for every
+// constraint variable, in header order, and its relative design weight.
+type MicroData struct {
+	ID     string
+	Values []float64
+	Weight float64 // Design weight scaled so the largest is 1; 0 for unweighted microdata
+	Region string  // Region code of the record (see Microdata.RegionColumn), empty when unused
+}
+
+// ConstraintData holds the census constraints of one area: its ID, the target
+// count for every constraint variable, and the total population to synthesize.
+// In household-person joint synthesis (see LoadHouseholdInputs) Values holds the
+// household constraints followed by the person constraints, Total counts households
+// and PersonTotal counts persons.
+type ConstraintData struct {
+	ID          string
+	Values      []float64
+	Total       float64
+	PersonTotal float64
+}
+
+// Result is the synthetic population found for one area.
+type Result struct {
+	Area             string    // Area ID
+	Population       float64   // Number of individuals synthesized
+	Totals           []float64 // Aggregate totals of the synthetic population per variable
+	IDs              []string  // Microdata IDs of the synthetic individuals
+	ConstraintTotals []float64 // The area's constraint values
+	Fitness          float64   // Distance between Totals and ConstraintTotals
+	BestIteration    int       // Iteration at which the best solution was found
+	PoolSize         int       // Number of microdata records valid for the area's constraints
+	Iterations       int       // Annealing iterations run
+	Accepted         int       // Annealing moves accepted
+	AcceptedWorse    int       // Accepted moves that made the fitness worse
+	Reverted         int       // Moves turned down by the acceptance criterion
+	FinalTemperature float64   // Annealing temperature when the search stopped
+	WindowSize       int       // Stagnation window used for the area (fixed or adaptive)
+	Reheats          int       // Stagnation detections that reheated the search
+	RestartFitness   []float64 // Best fitness of every restart, in order, when the area was restarted
+	TimedOut         bool      // The search was stopped by maxSecondsPerArea or maxTotalMinutes
+	Empty            bool      // The area has a zero total and no individuals; no search was run
+
+	// Fractional record weights by microdata ID, set instead of IDs by IPF with
+	// fractional weights
+	Weights map[string]float64
+
+	// Sampled convergence of the annealing search, when the trace output is on
+	Trace []TracePoint
+}
+
+// SynthesizeArea generates the synthetic population of a single area with the
+// algorithm in config: simulated annealing (default), IPF or a genetic algorithm.
+//
+// Parameters:
+//   - constraint: The area constraints
+//   - microData: The microdata pool to draw individuals from
+//   - header: Names of the constraint variables (used by variable groups)
+//   - config: Annealing configuration parameters
+//   - rng: Random number generator (seed it for reproducible results)
+//
+// Returns:
+//   - Result: The best solution found
+//   - error: An invalid distance configuration, or ErrNoValidMicrodata
+//
+// The non-zero columns and the valid records of microData are indexed on the first
+// call and reused by the calls that follow with the same slice, so change the values
+// of records only in a new slice.
+func SynthesizeArea(constraint ConstraintData, microData []MicroData, header []string, config AnnealingConfig, rng *rand.Rand) (Result, error) {
+	distance, err := buildDistance(config, header)
+	if err != nil {
+		return Result{}, err
+	}
+	scratch := scratchPool.Get().(*annealScratch)
+	defer scratchPool.Put(scratch)
+	scratch.delta = buildDeltaFitness(config, header)
+	scratch.indexFor(microData)
+	return synthesizeArea(constraint, microData, config, distance, rng, scratch)
+}
+
+// SynthesizeAreas synthesizes many areas in memory on a goroutine per CPU
+// (GOMAXPROCS). Area i is searched with a generator seeded with seed + i, so the
+// populations depend neither on the number of CPUs nor on the front-end: the R and
+// Python bindings give the same ones for the same inputs. As in a run, the
+// microdata are indexed once for all the areas and every worker keeps its buffers
+// and incremental fitness from area to area.
+//
+// Parameters:
+//   - constraints: The areas to synthesize
+//   - microData: The microdata pool to draw individuals from
+//   - header: Names of the constraint variables (used by variable groups)
+//   - config: Annealing configuration parameters
+//   - seed: Seeds the search of the first area
+//
+// Returns:
+//   - []Result: The result of every area, in the order of constraints
+//   - error: The error of the first area that failed, a panic of the engine included
+func SynthesizeAreas(constraints []ConstraintData, microData []MicroData, header []string, config AnnealingConfig,
+	seed int64) ([]Result, error) {
+	results := make([]Result, len(constraints))
+	errs := make([]error, len(constraints))
+	// Non-zero columns and valid records of the microdata, shared by the workers
+	columns, validity := newSparseIndex(), newValidityIndex()
+	newScratch := func() *annealScratch {
+		return &annealScratch{delta: buildDeltaFitness(config, header), columns: columns, validity: validity}
+	}
+	var next atomic.Int64
+	var workers sync.WaitGroup
+	for range min(runtime.GOMAXPROCS(0), len(constraints)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			distance, err := buildDistance(config, header)
+			scratch := newScratch()
+			for i := int(next.Add(1) - 1); i < len(constraints); i = int(next.Add(1) - 1) {
+				if err != nil {
+					errs[i] = err
+					continue
+				}
+				results[i], errs[i] = synthesizeRecovered(constraints[i], microData, config, distance, seed+int64(i), scratch)
+				if errs[i] != nil {
+					scratch = newScratch() // A panic can leave the buffers half updated
+				}
+			}
+		}()
+	}
+	workers.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("area %s: %w", constraints[i].ID, err)
+		}
+	}
+	return results, nil
+}
+
+// synthesizeRecovered synthesizes one area of SynthesizeAreas, returning a panic as
+// an error as it runs on a goroutine of its own
+func synthesizeRecovered(constraint ConstraintData, microData []MicroData, config AnnealingConfig, distance DistanceFunc,
+	seed int64, scratch *annealScratch) (res Result, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("synthesis panicked: %v", r)
+		}
+	}()
+	return synthesizeArea(constraint, microData, config, distance, rand.New(rand.NewSource(seed)), scratch)
+}
+
+// areaAlgorithm returns the algorithm configured for an area
+func areaAlgorithm(config AnnealingConfig, area string) string {
+	if algorithm, ok := config.AreaAlgorithms[area]; ok {
+		return algorithm
+	}
+	return config.Algorithm
+}
+
+// synthesizeArea runs the algorithm configured for one area, Restarts times when
+// set, and keeps the best result. The restarts continue the area's random stream,
+// so each starts from a different initial population. The restarts share the time
+// budget of the area; those it leaves no time for are not run. The iteration budget
+// is scaled to the area first (see IterationScaling).
+func synthesizeArea(constraint ConstraintData, microdata []MicroData, config AnnealingConfig, distanceFunction DistanceFunc,
+	rng *rand.Rand, scratch *annealScratch) (Result, error) {
+	// Areas without individuals, including totals rounded down to none, have
+	// nothing to search
+	switch {
+	case constraint.Total < 0:
+		return Result{}, fmt.Errorf("area %s: negative total %g", constraint.ID, constraint.Total)
+	case int(constraint.Total) == 0:
+		return emptyArea(constraint, distanceFunction), nil
+	}
+	if scratch == nil {
+		scratch = &annealScratch{}
+	}
+	config = areaBudget(config, constraint)
+	scratch.deadline = areaDeadline(config, scratch.runDeadline)
+	best, err := synthesizeOnce(constraint, microdata, config, distanceFunction, rng, scratch)
+	if err != nil || config.Restarts <= 1 {
+		return best, err
+	}
+	fitness := []float64{best.Fitness}
+	for r := 1; r < config.Restarts && !scratch.expired(); r++ {
+		res, err := synthesizeOnce(constraint, microdata, config, distanceFunction, rng, scratch)
+		if err != nil {
+			return res, err
+		}
+		fitness = append(fitness, res.Fitness)
+		timedOut := best.TimedOut || res.TimedOut
+		if res.Fitness < best.Fitness {
+			best = res
+		}
+		best.TimedOut = timedOut
+	}
+	best.RestartFitness = fitness
+	return best, nil
+}
+
+// emptyArea returns the result of an area with a zero total, e.g. a
+// non-residential zone: no individuals, and the fitness of empty totals against
+// the constraints, zero when they are all zero too.
+func emptyArea(constraint ConstraintData, distanceFunction DistanceFunc) Result {
+	totals := make([]float64, len(constraint.Values))
+	return Result{
+		Area:             constraint.ID,
+		Totals:           totals,
+		IDs:              []string{},
+		ConstraintTotals: constraint.Values,
+		Fitness:          distanceFunction(constraint.Values, totals),
+		Population:       constraint.Total,
+		Empty:            true,
+	}
+}
+
+// synthesizeOnce runs the algorithm configured for one area once
+func synthesizeOnce(constraint ConstraintData, microdata []MicroData, config AnnealingConfig, distanceFunction DistanceFunc,
+	rng *rand.Rand, scratch *annealScratch) (Result, error) {
+	switch areaAlgorithm(config, constraint.ID) {
+	case AlgorithmIPF:
+		return ipfPopulation(constraint, microdata, config, distanceFunction, rng)
+	case AlgorithmGA:
+		return gaPopulation(constraint, microdata, config, distanceFunction, rng, scratch)
+	case AlgorithmTabu:
+		return tabuPopulation(constraint, microdata, config, distanceFunction, rng, scratch)
+	}
+	if tempered(config, constraint) {
+		return temperedPopulation(constraint, microdata, config, distanceFunction, rng, scratch)
+	}
+	return syntheticPopulation(constraint, microdata, config, distanceFunction, rng, scratch)
+}
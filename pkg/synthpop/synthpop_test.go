@@ -0,0 +1,238 @@
+package synthpop
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testConfig returns a short annealing search, as used by the selftest command
+func testConfig() AnnealingConfig {
+	seed := int64(42)
+	return AnnealingConfig{
+		InitialTemp:      10,
+		MinTemp:          0.00001,
+		CoolingRate:      0.999,
+		ReheatFactor:     0.8,
+		FitnessThreshold: 0.0001,
+		MinImprovement:   0.0001,
+		MaxIterations:    5000,
+		WindowSize:       500,
+		Change:           2000,
+		Distance:         "EUCLIDEAN",
+		UseRandomSeed:    "yes",
+		RandomSeed:       &seed,
+	}
+}
+
+// checkPopulation verifies that res has the area's population size and that its
+// totals are the sums of the selected records
+func checkPopulation(t *testing.T, res Result, constraint ConstraintData, microData []MicroData) {
+	t.Helper()
+	if len(res.IDs) != int(constraint.Total) {
+		t.Fatalf("population has %d individuals, want %d", len(res.IDs), int(constraint.Total))
+	}
+	byID := make(map[string][]float64, len(microData))
+	for _, md := range microData {
+		byID[md.ID] = md.Values
+	}
+	sums := make([]float64, len(constraint.Values))
+	for _, id := range res.IDs {
+		values, ok := byID[id]
+		if !ok {
+			t.Fatalf("population contains unknown record %s", id)
+		}
+		for j, v := range values {
+			sums[j] += v
+		}
+	}
+	for j := range sums {
+		if math.Abs(sums[j]-res.Totals[j]) > 1e-9 {
+			t.Fatalf("total %d is %v, the records sum to %v", j, res.Totals[j], sums[j])
+		}
+	}
+}
+
+// TestSynthesizeAreaMetrics checks that every metric is used throughout the search:
+// the reported fitness must be the configured metric of the final totals, and the
+// metrics must not all accept the same moves and settle on the same population.
+func TestSynthesizeAreaMetrics(t *testing.T) {
+	metrics := map[string]DistanceFunc{
+		"KL_DIVERGENCE":  KLDivergence,
+		"CHI_SQUARED":    ChiSquaredDistance,
+		"EUCLIDEAN":      EuclideanDistance,
+		"NORM_EUCLIDEAN": NormalizedEuclideanDistance,
+		"MANHATTEN":      ManhattanDistance,
+		"COSINE":         CosineDistance,
+		"JSDIVERGENCE":   JSDivergence,
+	}
+	header := testHeader(5)
+	microData := testMicrodata(rand.New(rand.NewSource(4)), 200, 5, false)
+	constraint := ConstraintData{ID: "metrics", Values: []float64{30, 12, 25, 5, 18}, Total: 40}
+
+	populations, acceptance := make(map[string]bool), make(map[string]bool)
+	for _, name := range ValidMetrics {
+		t.Run(name, func(t *testing.T) {
+			config := testConfig()
+			config.Distance = name
+			res, err := SynthesizeArea(constraint, microData, header, config, rand.New(rand.NewSource(1)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkPopulation(t, res, constraint, microData)
+			if want := metrics[name](constraint.Values, res.Totals); math.Abs(res.Fitness-want) > 1e-9*math.Max(1, math.Abs(want)) {
+				t.Errorf("reported fitness %v, the metric of the totals is %v", res.Fitness, want)
+			}
+			populations[fmt.Sprint(res.Totals)] = true
+			acceptance[fmt.Sprint(res.Accepted, res.AcceptedWorse, res.Reverted)] = true
+		})
+	}
+	if len(populations) == 1 {
+		t.Error("all metrics produced the same population")
+	}
+	if len(acceptance) == 1 {
+		t.Error("all metrics accepted the same moves")
+	}
+}
+
+// BenchmarkSynthesizeArea synthesizes an area with the buffers of scratchPool, as
+// SynthesizeArea does, and with a fresh set of buffers for every area
+func BenchmarkSynthesizeArea(b *testing.B) {
+	header := testHeader(20)
+	rng := rand.New(rand.NewSource(8))
+	microData := testMicrodata(rng, 2000, len(header), false)
+	constraint := testConstraint(rng, len(header), 300)
+	config := testConfig()
+	config.MaxIterations = 2000
+
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, err := SynthesizeArea(constraint, microData, header, config, rand.New(rand.NewSource(1))); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("fresh", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			distance, err := buildDistance(config, header)
+			if err != nil {
+				b.Fatal(err)
+			}
+			scratch := new(annealScratch)
+			scratch.delta, scratch.columns = buildDeltaFitness(config, header), newSparseIndex()
+			if _, err := synthesizeArea(constraint, microData, config, distance, rand.New(rand.NewSource(1)), scratch); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// TestScratchIndexFor checks that a pooled scratch keeps the indexes of the
+// microdata it was last given, and that areas searched with them find the
+// populations of freshly indexed ones
+func TestScratchIndexFor(t *testing.T) {
+	header := testHeader(8)
+	rng := rand.New(rand.NewSource(11))
+	microData := testMicrodata(rng, 200, len(header), true)
+	other := testMicrodata(rng, 200, len(header), true)
+	config := testConfig()
+	config.MaxIterations = 1000
+
+	scratch := new(annealScratch)
+	scratch.indexFor(microData)
+	columns, validity := scratch.columns, scratch.validity
+	for i := range 3 {
+		constraint := testConstraint(rng, len(header), 40)
+		constraint.Values[1] = 0 // A zero pattern the areas share
+		scratch.indexFor(microData)
+		if scratch.columns != columns || scratch.validity != validity {
+			t.Fatalf("area %d: indexes rebuilt for the same microdata", i)
+		}
+		distance, err := buildDistance(config, header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scratch.delta = buildDeltaFitness(config, header)
+		got, err := synthesizeArea(constraint, microData, config, distance, rand.New(rand.NewSource(5)), scratch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fresh := &annealScratch{delta: buildDeltaFitness(config, header), columns: newSparseIndex()}
+		want, err := synthesizeArea(constraint, microData, config, distance, rand.New(rand.NewSource(5)), fresh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got.IDs, want.IDs) || got.Fitness != want.Fitness {
+			t.Errorf("area %d: population differs with the kept indexes", i)
+		}
+		checkPopulation(t, got, constraint, microData)
+	}
+	if len(validity.pools) == 0 {
+		t.Error("the valid records of the areas were not kept")
+	}
+
+	// Other records, or fewer of them, are indexed afresh
+	for name, records := range map[string][]MicroData{"other": other, "shorter": microData[:100]} {
+		scratch.indexFor(records)
+		if scratch.columns == columns || scratch.validity == validity {
+			t.Errorf("%s microdata: indexes kept", name)
+		}
+		scratch.indexFor(microData)
+		columns, validity = scratch.columns, scratch.validity
+	}
+}
+
+// TestSynthesizeAreasSeeds checks that SynthesizeAreas searches area i as
+// SynthesizeArea does with seed + i, whatever the number of CPUs
+func TestSynthesizeAreasSeeds(t *testing.T) {
+	header := testHeader(6)
+	rng := rand.New(rand.NewSource(10))
+	// Sparse records are compared through the index of their non-zero columns the
+	// workers share
+	pools := map[bool][]MicroData{
+		false: testMicrodata(rng, 100, len(header), false),
+		true:  testMicrodata(rng, 100, len(header), true),
+	}
+	constraints := make([]ConstraintData, 7)
+	for i := range constraints {
+		constraints[i] = testConstraint(rng, len(header), 30)
+		constraints[i].ID = fmt.Sprintf("area%d", i)
+	}
+	config := testConfig()
+	config.MaxIterations = 1000
+
+	for _, test := range []struct {
+		procs  int
+		sparse bool
+	}{{1, false}, {4, false}, {4, true}} {
+		microData := pools[test.sparse]
+		t.Run(fmt.Sprintf("GOMAXPROCS=%d/sparse=%v", test.procs, test.sparse), func(t *testing.T) {
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(test.procs))
+			results, err := SynthesizeAreas(constraints, microData, header, config, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, constraint := range constraints {
+				want, err := SynthesizeArea(constraint, microData, header, config, rand.New(rand.NewSource(100+int64(i))))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := results[i]; got.Area != constraint.ID || !slices.Equal(got.IDs, want.IDs) || got.Fitness != want.Fitness {
+					t.Errorf("area %d: population differs from SynthesizeArea with seed %d", i, 100+i)
+				}
+			}
+		})
+	}
+
+	bad := config
+	bad.Distance = "NOPE"
+	if _, err := SynthesizeAreas(constraints, pools[false], header, bad, 1); err == nil || !strings.Contains(err.Error(), "area0") {
+		t.Errorf("error %v, want the first area's", err)
+	}
+}
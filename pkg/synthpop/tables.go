@@ -0,0 +1,186 @@
+package synthpop
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ConstraintTable is one of several constraint tables of the same areas, e.g. a
+// cross-tabulation of age by sex with a column per combination. Its columns are
+// variables like those of a single constraints file, and the microdata needs one of
+// each: a column of its own or a cell derived from its marginal columns.
+type ConstraintTable struct {
+	Name     string  `json:"name"`     // Name in the logs and of its variable group (default: File)
+	File     string  `json:"file"`     // Area ID, total and the cells of the table, like Constraints.File
+	Format   string  `json:"format"`   // "csv" or "parquet" (default from the file extension)
+	Distance string  `json:"distance"` // Metric of the table (default: the annealing distance)
+	Weight   float64 `json:"weight"`   // Weight of the table in the fitness (default 1)
+	// Microdata expressions of the cells missing from the microdata (cell name ->
+	// expression over the microdata columns), e.g. "male_age0_15": "male * age0_15"
+	Cells map[string]string `json:"cells,omitempty"`
+}
+
+// name returns the name of the table in messages and its variable group
+func (t ConstraintTable) name() string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return t.File
+}
+
+// constraintTables loads the constraint tables, through the cache, and joins them
+// by area ID into one set of constraints: the areas in the order of the first table,
+// with its totals, and the cells of every table in table order. Every table must
+// cover the same areas and no two tables may share a cell name.
+//
+// Returns:
+//   - []ConstraintData: The joined constraints, new slices never shared with the cache
+//   - []string: The cell names of all tables
+//   - bool: Whether every table came from the cache
+//   - error: Any error reading a table, or tables that do not join
+func (c *Controller) constraintTables(ctx context.Context, tables []ConstraintTable, progress chan<- LoadProgress) ([]ConstraintData, []string, bool, error) {
+	var (
+		joined []ConstraintData
+		header []string
+		byID   map[string]int
+		owner  = make(map[string]string)
+		cached = true
+	)
+	for n, t := range tables {
+		data, tableHeader, fromCache, err := c.constraints(ctx, t.File, t.Format, progress)
+		if err != nil {
+			return nil, nil, false, fmt.Errorf("constraint table %s: %w", t.name(), err)
+		}
+		cached = cached && fromCache
+		for _, name := range tableHeader {
+			if other, ok := owner[name]; ok {
+				return nil, nil, false, fmt.Errorf("variable %s is in both constraint table %s and %s", name, other, t.name())
+			}
+			owner[name] = t.name()
+		}
+		header = append(header, tableHeader...)
+
+		if n == 0 {
+			joined = make([]ConstraintData, len(data))
+			byID = make(map[string]int, len(data))
+			for i, area := range data {
+				area.Values = slices.Clone(area.Values)
+				joined[i] = area
+				byID[area.ID] = i
+			}
+			continue
+		}
+		if len(data) != len(joined) {
+			return nil, nil, false, fmt.Errorf("constraint table %s has %d areas, table %s has %d",
+				t.name(), len(data), tables[0].name(), len(joined))
+		}
+		otherTotals := 0
+		for _, area := range data {
+			i, ok := byID[area.ID]
+			if !ok {
+				return nil, nil, false, fmt.Errorf("area %s of constraint table %s is not in table %s",
+					area.ID, t.name(), tables[0].name())
+			}
+			if len(joined[i].Values) != len(header)-len(tableHeader) {
+				return nil, nil, false, fmt.Errorf("area %s is listed twice in constraint table %s", area.ID, t.name())
+			}
+			if area.Total != joined[i].Total {
+				otherTotals++
+			}
+			joined[i].Values = append(joined[i].Values, area.Values...)
+		}
+		if otherTotals > 0 {
+			Printf("⚠️ %d areas have another total in constraint table %s than in %s, whose totals are used\n",
+				otherTotals, t.name(), tables[0].name())
+		}
+	}
+	return joined, header, cached, nil
+}
+
+// deriveTableCells adds the cells the tables derive from the microdata columns,
+// then drops the microdata columns no table (or parent geography) constrains: with
+// constraint tables, the marginal columns the cells are derived from need not be
+// fitted themselves.
+func deriveTableCells(tables []ConstraintTable, constraintHeader []string, microData []MicroData,
+	microDataHeader []string) ([]MicroData, []string, error) {
+	cells := make(map[string]string)
+	for _, t := range tables {
+		for name, expr := range t.Cells {
+			if _, ok := cells[name]; ok {
+				return nil, nil, fmt.Errorf("cell %s is derived by two constraint tables", name)
+			}
+			cells[name] = expr
+		}
+	}
+	if len(cells) > 0 {
+		var err error
+		if microData, microDataHeader, err = DeriveMicroData(cells, microDataHeader, microData); err != nil {
+			return nil, nil, fmt.Errorf("constraint table cells: %w", err)
+		}
+		Printf("Derived %d constraint table cells from the microdata\n", len(cells))
+	}
+
+	unused := notIn(microDataHeader, constraintHeader)
+	if len(unused) == 0 {
+		return microData, microDataHeader, nil
+	}
+	Printf("Not fitting microdata columns outside the constraint tables: %s\n", strings.Join(unused, ", "))
+	var columns []int
+	var header []string
+	for i, name := range microDataHeader {
+		if slices.Contains(constraintHeader, name) {
+			columns = append(columns, i)
+			header = append(header, name)
+		}
+	}
+	return SelectMicroDataColumns(microData, columns), header, nil
+}
+
+// tableGroups returns the variable group of every constraint table, with the table
+// name, distance and weight, from the headers the tables were loaded with
+func (c *Controller) tableGroups(tables []ConstraintTable) []VariableGroup {
+	groups := make([]VariableGroup, len(tables))
+	for i, t := range tables {
+		groups[i] = VariableGroup{
+			Name:      t.name(),
+			Variables: slices.Clone(c.constraintSets[t.File].header),
+			Distance:  t.Distance,
+			Weight:    t.Weight,
+		}
+	}
+	return groups
+}
+
+// WithTableGroups returns config with the fitness summed over the constraint tables
+// of the inputs, each table a variable group with its own distance and weight. The
+// variable groups of config come first: their variables are left out of the table
+// groups, as are the variables missing from the header (see HeaderIntersection).
+// Without constraint tables config is returned as it is.
+func (in Inputs) WithTableGroups(config AnnealingConfig) AnnealingConfig {
+	if len(in.Tables) == 0 {
+		return config
+	}
+	grouped := make(map[string]bool)
+	for _, g := range config.VariableGroups {
+		for _, name := range g.Variables {
+			grouped[name] = true
+		}
+	}
+	groups := slices.Clone(config.VariableGroups)
+	for _, table := range in.Tables {
+		g := table
+		g.Variables = nil
+		for _, name := range table.Variables {
+			if !grouped[name] && slices.Contains(in.Header, name) {
+				g.Variables = append(g.Variables, name)
+			}
+		}
+		if len(g.Variables) > 0 {
+			groups = append(groups, g)
+		}
+	}
+	config.VariableGroups = groups
+	return config
+}
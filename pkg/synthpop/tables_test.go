@@ -0,0 +1,108 @@
+package synthpop
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testTablesConfig returns a run over testdata/tables: two areas with an age by sex
+// table and a tenure table listing the areas in another order, and eight persons
+// whose table cells are derived from their sex, age and tenure
+func testTablesConfig() PopulationConfig {
+	dir := filepath.Join("testdata", "tables")
+	var popConfig PopulationConfig
+	popConfig.Constraints.Tables = []ConstraintTable{
+		{Name: "age by sex", File: filepath.Join(dir, "agesex.csv"), Cells: map[string]string{
+			"male_young":   "male * young",
+			"male_old":     "male * (1 - young)",
+			"female_young": "(1 - male) * young",
+			"female_old":   "(1 - male) * (1 - young)",
+		}},
+		{File: filepath.Join(dir, "tenure.csv"), Weight: 2, Cells: map[string]string{"rent": "1 - own"}},
+	}
+	popConfig.Microdata.File = filepath.Join(dir, "persons.csv")
+	return popConfig
+}
+
+// formatInputs lays out the joined inputs as in testdata/tables/joined.golden: the
+// header, then a line per area with its total and a line per record
+func formatInputs(in Inputs) string {
+	var b strings.Builder
+	b.WriteString("id,total," + strings.Join(in.Header, ",") + "\n")
+	line := func(id, total string, values []float64) {
+		b.WriteString(id + "," + total)
+		for _, v := range values {
+			b.WriteString("," + formatFloat(v))
+		}
+		b.WriteString("\n")
+	}
+	for _, c := range in.Constraints {
+		line(c.ID, formatFloat(c.Total), c.Values)
+	}
+	for _, md := range in.MicroData {
+		line(md.ID, "", md.Values)
+	}
+	return b.String()
+}
+
+func TestConstraintTables(t *testing.T) {
+	quietConsole(t)
+	popConfig := testTablesConfig()
+	in, err := NewController().Load(context.Background(), popConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The tables are joined by area and the cells derived from the persons, whose
+	// sex, age and income no table constrains
+	golden, err := os.ReadFile(filepath.Join("testdata", "tables", "joined.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formatInputs(in); got != string(golden) {
+		t.Errorf("joined inputs\n%s\nwant\n%s", got, golden)
+	}
+	want := []VariableGroup{
+		{Name: "age by sex", Variables: []string{"male_young", "male_old", "female_young", "female_old"}},
+		{Name: popConfig.Constraints.Tables[1].File, Variables: []string{"own", "rent"}, Weight: 2},
+	}
+	if !reflect.DeepEqual(in.Tables, want) {
+		t.Errorf("table groups %+v, want %+v", in.Tables, want)
+	}
+
+	// The synthetic populations reproduce both tables of every area
+	config := in.WithTableGroups(testConfig())
+	results, err := SynthesizeAreas(in.Constraints, in.MicroData, in.Header, config, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, res := range results {
+		checkPopulation(t, res, in.Constraints[i], in.MicroData)
+		if res.Fitness != 0 || !reflect.DeepEqual(res.Totals, in.Constraints[i].Values) {
+			t.Errorf("area %s cross-tabulates to %v (fitness %v), want %v", res.Area, res.Totals, res.Fitness, in.Constraints[i].Values)
+		}
+	}
+
+	// Tables must cover the same areas and name every cell once
+	dir := t.TempDir()
+	for name, tt := range map[string]struct{ table, err string }{
+		"missing area":  {"area,people,own,rent\nA1,5,3,2\n", "has 1 areas"},
+		"other area":    {"area,people,own,rent\nA1,5,3,2\nA3,4,1,3\n", "area A3"},
+		"repeated area": {"area,people,own,rent\nA1,5,3,2\nA1,5,3,2\n", "listed twice"},
+		"shared cell":   {"area,people,own,male_old\nA1,5,3,2\nA2,4,1,3\n", "variable male_old"},
+	} {
+		file := filepath.Join(dir, strings.ReplaceAll(name, " ", "_")+".csv")
+		if err := os.WriteFile(file, []byte(tt.table), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		popConfig := testTablesConfig()
+		popConfig.Constraints.Tables[1].File = file
+		if _, err := NewController().Load(context.Background(), popConfig); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want %q", name, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package synthpop
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Tabu search defaults, used when the tabu section leaves them at zero
+const (
+	defaultTabuListSize   = 50
+	defaultTabuTenure     = 20
+	defaultTabuCandidates = 20
+)
+
+// TabuConfig holds the settings of the tabu search
+type TabuConfig struct {
+	ListSize   int `json:"listSize"`   // Microdata records remembered at most (default 50)
+	Tenure     int `json:"tenure"`     // Iterations a swapped record stays tabu (default 20)
+	Candidates int `json:"candidates"` // Replace moves evaluated per iteration (default 20)
+}
+
+// tabuList remembers the microdata records of recent moves, for Tenure iterations
+// and at most ListSize of them
+type tabuList struct {
+	until  map[int]int // Record -> last iteration it is tabu in
+	recent []int       // Records in the order they were made tabu, oldest first
+	size   int
+	tenure int
+}
+
+func newTabuList(config TabuConfig) *tabuList {
+	size, tenure := config.ListSize, config.Tenure
+	if size <= 0 {
+		size = defaultTabuListSize
+	}
+	if tenure <= 0 {
+		tenure = defaultTabuTenure
+	}
+	return &tabuList{until: make(map[int]int, size), size: size, tenure: tenure}
+}
+
+// add makes record tabu from iteration on, dropping the oldest record when full
+func (t *tabuList) add(record, iteration int) {
+	if _, ok := t.until[record]; !ok {
+		if len(t.recent) == t.size {
+			delete(t.until, t.recent[0])
+			t.recent = t.recent[1:]
+		}
+		t.recent = append(t.recent, record)
+	}
+	t.until[record] = iteration + t.tenure
+}
+
+// tabu reports whether record may not be moved at iteration
+func (t *tabuList) tabu(record, iteration int) bool {
+	until, ok := t.until[record]
+	return ok && iteration <= until
+}
+
+// tabuPopulation searches the population of an area with tabu search: every
+// iteration evaluates a sample of replace moves (a random individual swapped for a
+// random valid record) and makes the best one, even when it is worse than the
+// current population, so the search walks out of local minima. Moves that would
+// put back or take out a record swapped within the tenure are tabu, which keeps the
+// search from cycling, unless they give a new best population. The search stops at
+// the fitness threshold, after MaxIterations or when the best fitness has not
+// improved within the stagnation window.
+//
+// Parameters:
+//   - constraint: The area constraints
+//   - microdata: The source microdata
+//   - config: Configuration holding the tabu settings, MaxIterations and FitnessThreshold
+//   - distanceFunction: Fitness function built by buildDistance for this worker
+//   - rng: Random number generator
+//   - scratch: Worker buffers reused between areas (nil allocates fresh ones)
+//
+// Returns:
+//   - Result: The best population found, including the iteration at which it was found
+//   - error: ErrNoValidMicrodata if no record satisfies the area's zero constraints
+func tabuPopulation(constraint ConstraintData, microdata []MicroData, config AnnealingConfig, distanceFunction DistanceFunc,
+	rng *rand.Rand, scratch *annealScratch) (Result, error) {
+	if scratch == nil {
+		scratch = &annealScratch{}
+	}
+	totals, indices, err := initPopulation(constraint, microdata, scratch, rng)
+	if err != nil {
+		return Result{}, err
+	}
+	fitness := distanceFunction(constraint.Values, totals)
+
+	candidates := config.Tabu.Candidates
+	if candidates <= 0 {
+		candidates = defaultTabuCandidates
+	}
+	list := newTabuList(config.Tabu)
+	window := stagnationWindow(config, constraint.Total)
+
+	scratch.bestTotals = floatBuffer(scratch.bestTotals, len(totals))
+	bestTotals := scratch.bestTotals
+	copy(bestTotals, totals)
+	scratch.bestIndices = intBuffer(scratch.bestIndices, len(indices))
+	bestIndices := scratch.bestIndices
+	copy(bestIndices, indices)
+	bestFitness, bestIteration := fitness, 0
+
+	// apply moves the individual at position to record, updating the totals
+	apply := func(position, record int) {
+		oldValues, newValues := microdata[indices[position]].Values, microdata[record].Values
+		for j := range totals {
+			totals[j] += newValues[j] - oldValues[j]
+		}
+		indices[position] = record
+	}
+
+	var trace []TracePoint
+	timedOut := false
+	for iteration := 1; iteration <= config.MaxIterations && bestFitness > config.FitnessThreshold && len(indices) > 0; iteration++ {
+		if iteration-bestIteration > window {
+			break
+		}
+		if iteration%deadlineCheckInterval == 0 && scratch.expired() {
+			timedOut = true
+			break
+		}
+
+		// The best admissible move of the sample
+		movePosition, moveRecord, moveFitness := -1, 0, math.Inf(1)
+		for c := 0; c < candidates; c++ {
+			record := scratch.valid.draw(rng)
+			position := rng.Intn(len(indices))
+			old := indices[position]
+			if old == record {
+				continue
+			}
+			apply(position, record)
+			f := distanceFunction(constraint.Values, totals)
+			apply(position, old)
+
+			isTabu := list.tabu(record, iteration) || list.tabu(old, iteration)
+			if (!isTabu || f < bestFitness) && f < moveFitness {
+				movePosition, moveRecord, moveFitness = position, record, f
+			}
+		}
+		if movePosition < 0 {
+			continue // Every sampled move was tabu
+		}
+
+		list.add(indices[movePosition], iteration)
+		list.add(moveRecord, iteration)
+		apply(movePosition, moveRecord)
+		fitness = moveFitness
+
+		improved := fitness < bestFitness
+		if improved {
+			bestFitness, bestIteration = fitness, iteration
+			copy(bestTotals, totals)
+			copy(bestIndices, indices)
+		}
+		if scratch.traceEvery > 0 && iteration%scratch.traceEvery == 0 {
+			trace = append(trace, TracePoint{Iteration: iteration, Fitness: fitness, Accepted: improved})
+		}
+	}
+
+	// Results outlive the scratch buffers, so they get their own copies
+	res := Result{
+		Area:             constraint.ID,
+		Trace:            trace,
+		Totals:           append([]float64(nil), bestTotals...),
+		IDs:              make([]string, len(bestIndices)),
+		ConstraintTotals: constraint.Values,
+		Fitness:          bestFitness,
+		BestIteration:    bestIteration,
+		Population:       constraint.Total,
+		PoolSize:         len(scratch.valid.indices),
+		TimedOut:         timedOut,
+	}
+	for i, index := range bestIndices {
+		res.IDs[i] = microdata[index].ID
+	}
+	return res, nil
+}
@@ -0,0 +1,77 @@
+package synthpop
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestTabuPopulation(t *testing.T) {
+	header := testHeader(6)
+	rng := rand.New(rand.NewSource(13))
+	microData := testMicrodata(rng, 150, len(header), false)
+	constraint := testConstraint(rng, len(header), 40)
+	config := testConfig()
+	config.Algorithm = AlgorithmTabu
+	config.MaxIterations = 1000
+	distance, err := buildDistance(config, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scratch := &annealScratch{traceEvery: 1}
+	res, err := tabuPopulation(constraint, microData, config, distance, rand.New(rand.NewSource(3)), scratch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPopulation(t, res, constraint, microData)
+	if start := startFitness(t, constraint, microData, distance, 3); res.Fitness >= start {
+		t.Errorf("fitness %v, no better than the initial population's %v", res.Fitness, start)
+	}
+	if res.BestIteration == 0 || res.BestIteration > config.MaxIterations {
+		t.Errorf("best population found in iteration %d", res.BestIteration)
+	}
+	// Every iteration makes its best move, so the search also walks uphill
+	uphill := false
+	for i, point := range res.Trace {
+		if point.Fitness < res.Fitness {
+			t.Fatalf("iteration %d reached %v, better than the best %v", point.Iteration, point.Fitness, res.Fitness)
+		}
+		if i > 0 && point.Fitness > res.Trace[i-1].Fitness {
+			uphill = true
+		}
+	}
+	if !uphill {
+		t.Error("the search never made a worse move")
+	}
+
+	again, err := tabuPopulation(constraint, microData, config, distance, rand.New(rand.NewSource(3)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(again.IDs, res.IDs) || again.Fitness != res.Fitness {
+		t.Error("the same seed searched another population")
+	}
+}
+
+func TestTabuList(t *testing.T) {
+	list := newTabuList(TabuConfig{ListSize: 2, Tenure: 3})
+	list.add(1, 10)
+	if !list.tabu(1, 10) || !list.tabu(1, 13) || list.tabu(1, 14) {
+		t.Error("record 1 is not tabu for exactly its tenure")
+	}
+	// Moving a record again extends its tenure without taking a second place
+	list.add(1, 12)
+	list.add(2, 12)
+	if !list.tabu(1, 15) || !list.tabu(2, 15) {
+		t.Error("records 1 and 2 not both tabu")
+	}
+	// A full list forgets its oldest record
+	list.add(3, 13)
+	if list.tabu(1, 14) || !list.tabu(2, 14) || !list.tabu(3, 14) {
+		t.Error("the oldest record was not dropped from the full list")
+	}
+	if list.tabu(4, 14) {
+		t.Error("a record never moved is tabu")
+	}
+}
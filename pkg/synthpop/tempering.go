@@ -0,0 +1,279 @@
+package synthpop
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+// Parallel tempering defaults, used when the tempering section leaves them at zero
+const (
+	defaultTemperingRatio    = 2.0
+	defaultTemperingInterval = 100
+)
+
+// TemperingConfig holds the settings of parallel tempering (replica exchange)
+type TemperingConfig struct {
+	Replicas         int     `json:"replicas"`         // Chains per area (0 or 1 runs a single chain)
+	TempRatio        float64 `json:"tempRatio"`        // Temperature ratio of neighbouring chains (default 2)
+	ExchangeInterval int     `json:"exchangeInterval"` // Iterations between exchange attempts (default 100)
+	MinPopulation    float64 `json:"minPopulation"`    // Areas with a smaller total run a single chain
+}
+
+// tempered reports whether the area runs several chains
+func tempered(config AnnealingConfig, constraint ConstraintData) bool {
+	return config.Tempering.Replicas > 1 && !config.PerVariableTemperature &&
+		constraint.Total >= config.Tempering.MinPopulation
+}
+
+// coreBudget counts the cores busy with the synthesis: a worker holds one while it
+// synthesizes an area, and tempered areas borrow the idle ones for their chains.
+// A nil budget lends no cores.
+type coreBudget chan struct{}
+
+func newCoreBudget(cores int) coreBudget {
+	return make(coreBudget, cores)
+}
+
+// acquire takes a core, waiting for one to be free
+func (b coreBudget) acquire() {
+	if b != nil {
+		b <- struct{}{}
+	}
+}
+
+// tryAcquire takes a core if one is idle
+func (b coreBudget) tryAcquire() bool {
+	if b == nil {
+		return false
+	}
+	select {
+	case b <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b coreBudget) release() {
+	if b != nil {
+		<-b
+	}
+}
+
+// temperingReplica holds the buffers and distance function of a chain after the
+// first, reused by the areas of a worker
+type temperingReplica struct {
+	scratch  annealScratch
+	distance DistanceFunc
+}
+
+// temperingChain is one annealing chain of a tempered area. Exchanges swap the
+// states of two chains; the temperature scale, generator and buffers stay.
+type temperingChain struct {
+	totals  []float64
+	indices []int
+	fitness float64
+
+	scale    float64 // Temperature of the chain relative to the schedule
+	rng      *rand.Rand
+	distance DistanceFunc
+	scratch  *annealScratch
+
+	// Best state of the current round
+	bestFitness float64
+	bestStep    int // -1 when the round did not improve on the start
+	moves       moveStats
+}
+
+// round runs steps replace moves starting at temperature temp
+func (c *temperingChain) round(constraint ConstraintData, microdata []MicroData, temp, coolingRate float64, rule string, steps int) {
+	c.bestFitness, c.bestStep = c.fitness, -1
+	c.moves = moveStats{}
+	for step := 0; step < steps; step++ {
+		var outcome moveOutcome
+		c.fitness, outcome = replace(microdata, constraint, c.scratch.valid, c.totals, c.indices, c.fitness, temp*c.scale, rule, c.rng, c.distance, nil)
+		c.moves.add(outcome)
+		if c.fitness < c.bestFitness {
+			c.bestFitness, c.bestStep = c.fitness, step
+			copy(c.scratch.bestTotals, c.totals)
+			copy(c.scratch.bestIndices, c.indices)
+		}
+		temp *= coolingRate
+	}
+}
+
+// temperedPopulation anneals an area with parallel tempering: Replicas chains
+// follow the temperature schedule scaled by powers of TempRatio, the first at the
+// schedule itself. Every ExchangeInterval iterations neighbouring chains swap their
+// populations with the replica exchange criterion, so a population the hot chains
+// carried out of a local minimum moves down to the cold chains that refine it. The
+// chains of a round run concurrently on the cores other workers leave idle, which
+// happens once fewer areas remain than workers, and in turn on the worker's own
+// core otherwise; the result does not depend on how many cores were lent. The hot
+// chains take the place of reheating. The search stops like the single chain, at
+// the fitness threshold, after MaxIterations, below MinTemp, after Change rejected
+// moves of the coldest chain, or when the best fitness has not improved within the
+// stagnation window.
+//
+// Parameters:
+//   - constraint: The area constraints
+//   - microdata: The source microdata
+//   - config: Annealing configuration holding the tempering settings
+//   - distanceFunction: Fitness function built by buildDistance for this worker
+//   - rng: Random number generator, seeding the chains and drawing the exchanges
+//   - scratch: Worker buffers reused between areas (nil allocates fresh ones)
+//
+// Returns:
+//   - Result: The best population of any chain; Iterations and the move counts are
+//     those of the coldest chain
+//   - error: ErrNoValidMicrodata if no record satisfies the area's zero constraints
+func temperedPopulation(constraint ConstraintData, microdata []MicroData, config AnnealingConfig, distanceFunction DistanceFunc,
+	rng *rand.Rand, scratch *annealScratch) (Result, error) {
+	if scratch == nil {
+		scratch = &annealScratch{}
+	}
+	ratio := config.Tempering.TempRatio
+	if ratio <= 0 {
+		ratio = defaultTemperingRatio
+	}
+	interval := config.Tempering.ExchangeInterval
+	if interval <= 0 {
+		interval = defaultTemperingInterval
+	}
+
+	// Without a distance factory the chains share the worker's distance function
+	// and run in turn
+	replicas := config.Tempering.Replicas
+	for len(scratch.replicas) < replicas-1 {
+		r := &temperingReplica{distance: distanceFunction}
+		if scratch.newDistance != nil {
+			r.distance = scratch.newDistance()
+		}
+		scratch.replicas = append(scratch.replicas, r)
+	}
+	concurrent := scratch.newDistance != nil
+
+	chains := make([]*temperingChain, replicas)
+	for k := range chains {
+		c := &temperingChain{scale: math.Pow(ratio, float64(k)), rng: rand.New(rand.NewSource(rng.Int63()))}
+		if k == 0 {
+			c.scratch, c.distance = scratch, distanceFunction
+		} else {
+			c.scratch, c.distance = &scratch.replicas[k-1].scratch, scratch.replicas[k-1].distance
+			c.scratch.validity = scratch.validity
+		}
+		var err error
+		if c.totals, c.indices, err = initPopulation(constraint, microdata, c.scratch, c.rng); err != nil {
+			return Result{}, err
+		}
+		c.fitness = c.distance(constraint.Values, c.totals)
+		c.scratch.bestTotals = floatBuffer(c.scratch.bestTotals, len(c.totals))
+		c.scratch.bestIndices = intBuffer(c.scratch.bestIndices, len(c.indices))
+		chains[k] = c
+	}
+
+	best := chains[0]
+	for _, c := range chains[1:] {
+		if c.fitness < best.fitness {
+			best = c
+		}
+	}
+	bestFitness, bestIteration := best.fitness, 0
+	bestTotals := append([]float64(nil), best.totals...)
+	bestIndices := append([]int(nil), best.indices...)
+
+	window := stagnationWindow(config, constraint.Total)
+	temp, changes := config.InitialTemp, config.Change
+	iterations := 0
+	var moves moveStats // Of the coldest chain
+	var trace []TracePoint
+	timedOut := false
+
+	for iterations < config.MaxIterations && changes > 0 && temp > config.MinTemp &&
+		bestFitness > config.FitnessThreshold && iterations-bestIteration <= window && len(bestIndices) > 0 {
+		if scratch.expired() {
+			timedOut = true
+			break
+		}
+		steps := min(interval, config.MaxIterations-iterations)
+
+		// Run the round on the worker's core and the idle ones it can borrow
+		lent := 0
+		for concurrent && lent < replicas-1 && scratch.cores.tryAcquire() {
+			lent++
+		}
+		var next atomic.Int32
+		runChains := func() {
+			for k := int(next.Add(1)) - 1; k < len(chains); k = int(next.Add(1)) - 1 {
+				chains[k].round(constraint, microdata, temp, config.CoolingRate, config.Acceptance, steps)
+			}
+		}
+		var wg sync.WaitGroup
+		for i := 0; i < lent; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				runChains()
+			}()
+		}
+		runChains()
+		wg.Wait()
+		for i := 0; i < lent; i++ {
+			scratch.cores.release()
+		}
+
+		for _, c := range chains {
+			if c.bestStep >= 0 && c.bestFitness < bestFitness {
+				bestFitness, bestIteration = c.bestFitness, iterations+c.bestStep
+				copy(bestTotals, c.scratch.bestTotals)
+				copy(bestIndices, c.scratch.bestIndices)
+			}
+		}
+		cold := chains[0].moves
+		changes -= cold.reverted
+		moves.accepted += cold.accepted
+		moves.acceptedWorse += cold.acceptedWorse
+		moves.reverted += cold.reverted
+		iterations += steps
+		temp *= math.Pow(config.CoolingRate, float64(steps))
+		if scratch.traceEvery > 0 && iterations/scratch.traceEvery != (iterations-steps)/scratch.traceEvery {
+			trace = append(trace, TracePoint{Iteration: iterations, Temperature: temp, Fitness: chains[0].fitness,
+				Accepted: cold.accepted > 0})
+		}
+
+		// Exchange neighbouring chains, pairing them alternately from the first and
+		// the second chain so every pair gets its turn
+		for k := (iterations / interval) % 2; k+1 < len(chains); k += 2 {
+			cold, hot := chains[k], chains[k+1]
+			delta := (cold.fitness - hot.fitness) * (1/(temp*cold.scale) - 1/(temp*hot.scale))
+			if delta >= 0 || rng.Float64() < math.Exp(delta) {
+				cold.totals, hot.totals = hot.totals, cold.totals
+				cold.indices, hot.indices = hot.indices, cold.indices
+				cold.fitness, hot.fitness = hot.fitness, cold.fitness
+			}
+		}
+	}
+
+	res := Result{
+		Area:             constraint.ID,
+		Trace:            trace,
+		Totals:           bestTotals,
+		IDs:              make([]string, len(bestIndices)),
+		ConstraintTotals: constraint.Values,
+		Fitness:          bestFitness,
+		BestIteration:    bestIteration,
+		Iterations:       iterations,
+		FinalTemperature: temp,
+		WindowSize:       window,
+		Population:       constraint.Total,
+		PoolSize:         len(scratch.valid.indices),
+		TimedOut:         timedOut,
+	}
+	moves.setResult(&res)
+	for i, index := range bestIndices {
+		res.IDs[i] = microdata[index].ID
+	}
+	return res, nil
+}
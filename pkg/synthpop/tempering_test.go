@@ -0,0 +1,93 @@
+package synthpop
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestTemperedPopulation(t *testing.T) {
+	header := testHeader(6)
+	rng := rand.New(rand.NewSource(14))
+	microData := testMicrodata(rng, 150, len(header), false)
+	constraint := testConstraint(rng, len(header), 40)
+	config := testConfig()
+	config.MaxIterations = 2000
+	config.Tempering = TemperingConfig{Replicas: 4, ExchangeInterval: 50}
+	distance, err := buildDistance(config, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := temperedPopulation(constraint, microData, config, distance, rand.New(rand.NewSource(3)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPopulation(t, res, constraint, microData)
+	// The coldest chain is seeded with the first number drawn from the area's stream
+	chainSeed := rand.New(rand.NewSource(3)).Int63()
+	if start := startFitness(t, constraint, microData, distance, chainSeed); res.Fitness >= start {
+		t.Errorf("fitness %v, no better than the coldest chain's start %v", res.Fitness, start)
+	}
+	if res.Iterations == 0 || res.Iterations > config.MaxIterations || res.BestIteration > res.Iterations {
+		t.Errorf("best population found in iteration %d of %d", res.BestIteration, res.Iterations)
+	}
+
+	// The same seed gives the same population, whether the chains run in turn or
+	// concurrently on lent cores
+	again, err := temperedPopulation(constraint, microData, config, distance, rand.New(rand.NewSource(3)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(again.IDs, res.IDs) || again.Fitness != res.Fitness || again.Iterations != res.Iterations {
+		t.Error("the same seed tempered another population")
+	}
+	lent := &annealScratch{cores: newCoreBudget(4), newDistance: func() DistanceFunc {
+		d, err := buildDistance(config, header)
+		if err != nil {
+			t.Error(err)
+		}
+		return d
+	}}
+	concurrent, err := temperedPopulation(constraint, microData, config, distance, rand.New(rand.NewSource(3)), lent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(concurrent.IDs, res.IDs) || concurrent.Fitness != res.Fitness {
+		t.Error("concurrent chains tempered another population")
+	}
+	if len(lent.cores) != 0 {
+		t.Errorf("%d lent cores not given back", len(lent.cores))
+	}
+	other, err := temperedPopulation(constraint, microData, config, distance, rand.New(rand.NewSource(4)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slices.Equal(other.IDs, res.IDs) {
+		t.Error("another seed tempered the same population")
+	}
+}
+
+func TestTempered(t *testing.T) {
+	constraint := ConstraintData{Total: 50}
+	tests := []struct {
+		name      string
+		tempering TemperingConfig
+		perVar    bool
+		want      bool
+	}{
+		{"single chain", TemperingConfig{Replicas: 1}, false, false},
+		{"replicas", TemperingConfig{Replicas: 3}, false, true},
+		{"small area", TemperingConfig{Replicas: 3, MinPopulation: 51}, false, false},
+		{"large enough area", TemperingConfig{Replicas: 3, MinPopulation: 50}, false, true},
+		{"per-variable temperature", TemperingConfig{Replicas: 3}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := AnnealingConfig{Tempering: tt.tempering, PerVariableTemperature: tt.perVar}
+			if got := tempered(config, constraint); got != tt.want {
+				t.Errorf("tempered = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package synthpop
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Thrift compact protocol, as used by the Parquet file metadata and page headers.
+// The decoder reads any struct generically into a field ID -> value map, so the
+// Parquet reader picks the fields it needs and skips the rest; the encoder writes
+// the few structs the Parquet writer produces.
+
+// Compact protocol type codes
+const (
+	thriftStop   = 0
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftByte   = 3
+	thriftI16    = 4
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftDouble = 7
+	thriftBinary = 8
+	thriftList   = 9
+	thriftSet    = 10
+	thriftMap    = 11
+	thriftStruct = 12
+)
+
+// maxThriftDepth bounds struct nesting so a corrupt file cannot exhaust the stack
+const maxThriftDepth = 64
+
+var errThriftTruncated = errors.New("truncated thrift data")
+
+// thriftFields is a decoded struct. Integers of every width decode to int64,
+// binary fields to []byte, lists and sets to []any and nested structs to
+// thriftFields; maps are skipped.
+type thriftFields map[int16]any
+
+func (f thriftFields) has(id int16) bool {
+	_, ok := f[id]
+	return ok
+}
+
+func (f thriftFields) int(id int16) int64 {
+	v, _ := f[id].(int64)
+	return v
+}
+
+func (f thriftFields) bool(id int16) bool {
+	v, _ := f[id].(bool)
+	return v
+}
+
+func (f thriftFields) str(id int16) string {
+	v, _ := f[id].([]byte)
+	return string(v)
+}
+
+func (f thriftFields) list(id int16) []any {
+	v, _ := f[id].([]any)
+	return v
+}
+
+func (f thriftFields) child(id int16) thriftFields {
+	v, _ := f[id].(thriftFields)
+	return v
+}
+
+// thriftReader decodes compact protocol values from a byte slice
+type thriftReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *thriftReader) readByte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, errThriftTruncated
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *thriftReader) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		return 0, errThriftTruncated
+	}
+	r.pos += n
+	return v, nil
+}
+
+// varint reads a zigzag-encoded signed integer
+func (r *thriftReader) varint() (int64, error) {
+	u, err := r.uvarint()
+	return int64(u>>1) ^ -int64(u&1), err
+}
+
+func (r *thriftReader) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(r.data)-r.pos) {
+		return nil, errThriftTruncated
+	}
+	b := r.data[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b, nil
+}
+
+// readStruct decodes the struct at the current position
+func (r *thriftReader) readStruct(depth int) (thriftFields, error) {
+	if depth > maxThriftDepth {
+		return nil, fmt.Errorf("thrift structs nested too deeply")
+	}
+	fields := make(thriftFields)
+	var id int16
+	for {
+		header, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if header == thriftStop {
+			return fields, nil
+		}
+		if delta := int16(header >> 4); delta != 0 {
+			id += delta
+		} else {
+			long, err := r.varint()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(long)
+		}
+
+		t := header & 0x0f
+		switch t {
+		case thriftTrue, thriftFalse: // Booleans are held in the field header
+			fields[id] = t == thriftTrue
+			continue
+		}
+		value, err := r.readValue(t, depth)
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			fields[id] = value
+		}
+	}
+}
+
+// readValue decodes one value of type t
+func (r *thriftReader) readValue(t byte, depth int) (any, error) {
+	switch t {
+	case thriftTrue, thriftFalse: // Only in lists, where the element is a byte
+		b, err := r.readByte()
+		return b == thriftTrue, err
+	case thriftByte:
+		b, err := r.readByte()
+		return int64(int8(b)), err
+	case thriftI16, thriftI32, thriftI64:
+		return r.varint()
+	case thriftDouble:
+		b, err := r.bytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	case thriftBinary:
+		n, err := r.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		return r.bytes(n)
+	case thriftList, thriftSet:
+		header, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		n := uint64(header >> 4)
+		if n == 15 {
+			if n, err = r.uvarint(); err != nil {
+				return nil, err
+			}
+		}
+		if n > uint64(len(r.data)-r.pos) { // Every element takes at least one byte
+			return nil, errThriftTruncated
+		}
+		elems := make([]any, n)
+		for i := range elems {
+			if elems[i], err = r.readValue(header&0x0f, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return elems, nil
+	case thriftMap:
+		n, err := r.uvarint()
+		if err != nil || n == 0 {
+			return nil, err
+		}
+		types, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < n; i++ {
+			if _, err := r.readValue(types>>4, depth+1); err != nil {
+				return nil, err
+			}
+			if _, err := r.readValue(types&0x0f, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	case thriftStruct:
+		return r.readStruct(depth + 1)
+	default:
+		return nil, fmt.Errorf("unknown thrift type %d", t)
+	}
+}
+
+// thriftWriter encodes compact protocol structs. Fields must be written in
+// increasing ID order within each struct.
+type thriftWriter struct {
+	buf  []byte
+	last []int16 // Last field ID written in each open struct
+}
+
+func (w *thriftWriter) uvarint(v uint64) {
+	w.buf = binary.AppendUvarint(w.buf, v)
+}
+
+func (w *thriftWriter) varint(v int64) {
+	w.uvarint(uint64(v<<1) ^ uint64(v>>63))
+}
+
+func (w *thriftWriter) fieldHeader(id int16, t byte) {
+	last := &w.last[len(w.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		w.buf = append(w.buf, byte(delta)<<4|t)
+	} else {
+		w.buf = append(w.buf, t)
+		w.varint(int64(id))
+	}
+	*last = id
+}
+
+// beginStruct opens a top-level struct or a struct list element
+func (w *thriftWriter) beginStruct() {
+	w.last = append(w.last, 0)
+}
+
+func (w *thriftWriter) endStruct() {
+	w.buf = append(w.buf, thriftStop)
+	w.last = w.last[:len(w.last)-1]
+}
+
+// structField opens a nested struct field, closed with endStruct
+func (w *thriftWriter) structField(id int16) {
+	w.fieldHeader(id, thriftStruct)
+	w.beginStruct()
+}
+
+func (w *thriftWriter) i32Field(id int16, v int32) {
+	w.fieldHeader(id, thriftI32)
+	w.varint(int64(v))
+}
+
+func (w *thriftWriter) i64Field(id int16, v int64) {
+	w.fieldHeader(id, thriftI64)
+	w.varint(v)
+}
+
+func (w *thriftWriter) stringField(id int16, s string) {
+	w.fieldHeader(id, thriftBinary)
+	w.stringElem(s)
+}
+
+// listField starts a list of n elements of type elem, written with the *Elem
+// methods (or beginStruct/endStruct for struct elements)
+func (w *thriftWriter) listField(id int16, elem byte, n int) {
+	w.fieldHeader(id, thriftList)
+	if n < 15 {
+		w.buf = append(w.buf, byte(n)<<4|elem)
+	} else {
+		w.buf = append(w.buf, 0xf0|elem)
+		w.uvarint(uint64(n))
+	}
+}
+
+func (w *thriftWriter) i32Elem(v int32) {
+	w.varint(int64(v))
+}
+
+func (w *thriftWriter) stringElem(s string) {
+	w.uvarint(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
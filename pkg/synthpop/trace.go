@@ -0,0 +1,79 @@
+package synthpop
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strconv"
+)
+
+// defaultTraceInterval is the sampling interval of the trace output in iterations
+const defaultTraceInterval = 100
+
+// TracePoint is one sample of the annealing search of an area
+type TracePoint struct {
+	Iteration   int
+	Temperature float64
+	Fitness     float64 // Fitness of the current population after the iteration
+	Accepted    bool    // Whether the iteration's move was accepted
+}
+
+// traceInterval returns the configured sampling interval, or 0 when tracing is off
+func traceInterval(popConfig PopulationConfig) int {
+	if popConfig.Output.TraceFile == "" {
+		return 0
+	}
+	if popConfig.Output.TraceInterval > 0 {
+		return popConfig.Output.TraceInterval
+	}
+	return defaultTraceInterval
+}
+
+// traceWriter writes the convergence trace of every area, so researchers can
+// diagnose after a run how fitness evolved
+type traceWriter struct {
+	file   *outputFile
+	writer *csv.Writer
+}
+
+// newTraceWriter creates the trace file, or returns nil when none is configured
+func newTraceWriter(popConfig PopulationConfig, key []byte, retry retryPolicy) (*traceWriter, error) {
+	if popConfig.Output.TraceFile == "" {
+		return nil, nil
+	}
+	file, err := createOutput(popConfig.Output.TraceFile, key, retry)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create trace file: %w", err)
+	}
+	w := &traceWriter{file: file, writer: csv.NewWriter(file)}
+	header := []string{"area_id", "iteration", "temperature", "fitness", "accepted"}
+	if err := w.writer.Write(header); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error writing trace header: %w", err)
+	}
+	return w, nil
+}
+
+// writeArea writes the sampled trace of one area
+func (w *traceWriter) writeArea(res Result) error {
+	for _, p := range res.Trace {
+		row := []string{res.Area,
+			strconv.Itoa(p.Iteration),
+			strconv.FormatFloat(p.Temperature, 'g', -1, 64),
+			strconv.FormatFloat(p.Fitness, 'g', -1, 64),
+			strconv.FormatBool(p.Accepted)}
+		if err := w.writer.Write(row); err != nil {
+			return fmt.Errorf("error writing trace row: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close flushes and closes the trace file
+func (w *traceWriter) Close() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
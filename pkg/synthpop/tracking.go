@@ -0,0 +1,349 @@
+package synthpop
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Environment variables of the experiment tracking, named as in the MLflow clients
+const (
+	trackingURIEnv        = "MLFLOW_TRACKING_URI"
+	trackingExperimentEnv = "MLFLOW_EXPERIMENT_NAME"
+	trackingTokenEnv      = "MLFLOW_TRACKING_TOKEN"
+	trackingUserEnv       = "MLFLOW_TRACKING_USERNAME"
+	trackingPasswordEnv   = "MLFLOW_TRACKING_PASSWORD"
+)
+
+const (
+	defaultTrackingExperiment = "GoSynthPop"
+	trackingTimeout           = 30 * time.Second
+	maxTrackedParamLength     = 6000 // MLflow rejects longer parameter values
+	trackedParamsPerBatch     = 100  // MLflow limits of one log-batch request
+	trackedMetricsPerBatch    = 1000
+)
+
+// mlflowTracker logs a run to an MLflow tracking server: the two configs as
+// parameters, the fit of every area and of the run as metrics, and the manifest,
+// validation summary and bundle as artifacts. Calibration runs over many configs can
+// then be compared in the MLflow UI. The server is taken from MLFLOW_TRACKING_URI;
+// without it nothing is tracked.
+type mlflowTracker struct {
+	uri        string
+	experiment string
+	client     *http.Client
+	areas      []trackedArea // In the order the areas finished
+}
+
+type trackedArea struct {
+	fitness       float64
+	bestIteration int
+}
+
+// newTracker returns the tracker configured in the environment, nil when tracking
+// is off
+func newTracker() *mlflowTracker {
+	uri := strings.TrimRight(os.Getenv(trackingURIEnv), "/")
+	if uri == "" {
+		return nil
+	}
+	experiment := os.Getenv(trackingExperimentEnv)
+	if experiment == "" {
+		experiment = defaultTrackingExperiment
+	}
+	return &mlflowTracker{uri: uri, experiment: experiment, client: &http.Client{Timeout: trackingTimeout}}
+}
+
+// add records the fit of a synthesized area
+func (t *mlflowTracker) add(res Result) {
+	if t != nil {
+		t.areas = append(t.areas, trackedArea{fitness: res.Fitness, bestIteration: res.BestIteration})
+	}
+}
+
+// log sends the finished run to the tracking server. A tracking failure does not
+// fail the synthesis: it is reported and the run carries on.
+//
+// Parameters:
+//   - m: The manifest of the run, already written
+//   - gateErr: The quality gate failure, which marks the tracked run failed
+//   - artifacts: Files to upload, skipped when empty or missing
+func (t *mlflowTracker) log(m *RunManifest, gateErr error, artifacts []string) {
+	if t == nil {
+		return
+	}
+	runID, err := t.send(m, gateErr, artifacts)
+	if err != nil {
+		Printf("⚠️ Experiment tracking failed: %v\n", err)
+		return
+	}
+	Printf("📈 Run tracked in MLflow experiment %s as %s\n", t.experiment, runID)
+}
+
+func (t *mlflowTracker) send(m *RunManifest, gateErr error, artifacts []string) (string, error) {
+	experimentID, err := t.experimentID()
+	if err != nil {
+		return "", err
+	}
+	var created struct {
+		Run struct {
+			Info struct {
+				RunID       string `json:"run_id"`
+				ArtifactURI string `json:"artifact_uri"`
+			} `json:"info"`
+		} `json:"run"`
+	}
+	err = t.call("runs/create", map[string]any{
+		"experiment_id": experimentID,
+		"run_name":      m.RunName,
+		"start_time":    m.StartedAt.UnixMilli(),
+		"tags": []map[string]string{
+			{"key": "mlflow.source.name", "value": "GoSynthPop"},
+			{"key": "seed", "value": strconv.FormatInt(m.Seed, 10)},
+			{"key": "config_hash", "value": m.ConfigHash},
+			{"key": "vcs_revision", "value": m.Build.VCSRevision},
+		},
+	}, &created)
+	if err != nil {
+		return "", err
+	}
+	runID := created.Run.Info.RunID
+
+	status := "FINISHED"
+	if gateErr != nil {
+		status = "FAILED"
+	}
+	// The run is closed whatever fails on the way, so it does not stay RUNNING
+	finish := func() error {
+		return t.call("runs/update", map[string]any{"run_id": runID, "status": status,
+			"end_time": m.FinishedAt.UnixMilli()}, nil)
+	}
+
+	params, err := trackedParams(m.PopulationConfig, m.AnnealingConfig)
+	if err == nil {
+		err = t.logParams(runID, params)
+	}
+	if err == nil {
+		err = t.logMetrics(runID, m)
+	}
+	if err == nil {
+		err = t.uploadArtifacts(created.Run.Info.ArtifactURI, artifacts)
+	}
+	if finishErr := finish(); err == nil {
+		err = finishErr
+	}
+	return runID, err
+}
+
+// experimentID returns the ID of the experiment, creating it on first use
+func (t *mlflowTracker) experimentID() (string, error) {
+	var found struct {
+		Experiment struct {
+			ID string `json:"experiment_id"`
+		} `json:"experiment"`
+	}
+	err := t.call("experiments/get-by-name?experiment_name="+url.QueryEscape(t.experiment), nil, &found)
+	if err == nil {
+		return found.Experiment.ID, nil
+	}
+	var created struct {
+		ID string `json:"experiment_id"`
+	}
+	if createErr := t.call("experiments/create", map[string]any{"name": t.experiment}, &created); createErr != nil {
+		return "", fmt.Errorf("cannot find or create experiment %s: %w", t.experiment, createErr)
+	}
+	return created.ID, nil
+}
+
+type trackedParam struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type trackedMetric struct {
+	Key       string  `json:"key"`
+	Value     float64 `json:"value"`
+	Timestamp int64   `json:"timestamp"`
+	Step      int     `json:"step"`
+}
+
+func (t *mlflowTracker) logParams(runID string, params []trackedParam) error {
+	for start := 0; start < len(params); start += trackedParamsPerBatch {
+		batch := params[start:min(start+trackedParamsPerBatch, len(params))]
+		if err := t.call("runs/log-batch", map[string]any{"run_id": runID, "params": batch}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logMetrics logs the fitness and best iteration of every area, stepped by the
+// order the areas finished in, and the summary of the run
+func (t *mlflowTracker) logMetrics(runID string, m *RunManifest) error {
+	now := m.FinishedAt.UnixMilli()
+	metrics := []trackedMetric{
+		{Key: "areas_synthesized", Value: float64(m.Areas.Synthesized), Timestamp: now},
+		{Key: "areas_failed", Value: float64(m.Areas.Failed), Timestamp: now},
+		{Key: "fitness_mean", Value: m.Areas.Mean, Timestamp: now},
+		{Key: "fitness_median", Value: m.Areas.Median, Timestamp: now},
+		{Key: "fitness_max", Value: m.Areas.Max, Timestamp: now},
+		{Key: "wall_clock_seconds", Value: m.WallClockSeconds, Timestamp: now},
+	}
+	for step, area := range t.areas {
+		if math.IsInf(area.fitness, 0) || math.IsNaN(area.fitness) {
+			continue // Not representable in JSON
+		}
+		metrics = append(metrics,
+			trackedMetric{Key: "area_fitness", Value: area.fitness, Timestamp: now, Step: step},
+			trackedMetric{Key: "area_best_iteration", Value: float64(area.bestIteration), Timestamp: now, Step: step})
+	}
+	for start := 0; start < len(metrics); start += trackedMetricsPerBatch {
+		batch := metrics[start:min(start+trackedMetricsPerBatch, len(metrics))]
+		if err := t.call("runs/log-batch", map[string]any{"run_id": runID, "metrics": batch}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uploadArtifacts uploads files through the artifact proxy of the tracking server.
+// Runs whose artifacts go straight to a cloud store need the MLflow client
+// libraries and keep their artifacts local.
+func (t *mlflowTracker) uploadArtifacts(artifactURI string, files []string) error {
+	const proxied = "mlflow-artifacts:"
+	if !strings.HasPrefix(artifactURI, proxied) {
+		Printf("⚠️ Artifacts not uploaded: the tracking server stores them at %s, not through its proxy\n", artifactURI)
+		return nil
+	}
+	dir := strings.TrimLeft(strings.TrimPrefix(artifactURI, proxied), "/")
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		target := t.uri + "/api/2.0/mlflow-artifacts/artifacts/" + dir + "/" + url.PathEscape(filepath.Base(file))
+		req, err := http.NewRequest(http.MethodPut, target, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		if err := t.do(req, nil); err != nil {
+			return fmt.Errorf("cannot upload %s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+// call posts body as JSON to an MLflow REST endpoint, or gets it when body is nil,
+// and decodes the response into out (nil to ignore it)
+func (t *mlflowTracker) call(endpoint string, body any, out any) error {
+	method, reader := http.MethodGet, io.Reader(nil)
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		method, reader = http.MethodPost, bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, t.uri+"/api/2.0/mlflow/"+endpoint, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return t.do(req, out)
+}
+
+// do sends a request with the configured credentials
+func (t *mlflowTracker) do(req *http.Request, out any) error {
+	if token := os.Getenv(trackingTokenEnv); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if user := os.Getenv(trackingUserEnv); user != "" {
+		req.SetBasicAuth(user, os.Getenv(trackingPasswordEnv))
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(message)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// trackedParams flattens the two configs into parameters named by their JSON
+// paths, e.g. annealing.coolingRate. Lists and maps are logged as JSON, and unset
+// values are left out.
+func trackedParams(popConfig PopulationConfig, config AnnealingConfig) ([]trackedParam, error) {
+	var params []trackedParam
+	for _, c := range []struct {
+		prefix string
+		config any
+	}{{"population", popConfig}, {"annealing", config}} {
+		data, err := json.Marshal(c.config)
+		if err != nil {
+			return nil, err
+		}
+		var tree map[string]any
+		if err := json.Unmarshal(data, &tree); err != nil {
+			return nil, err
+		}
+		params = flattenParams(params, c.prefix, tree)
+	}
+	sort.Slice(params, func(a, b int) bool { return params[a].Key < params[b].Key })
+	return params, nil
+}
+
+func flattenParams(params []trackedParam, prefix string, tree map[string]any) []trackedParam {
+	for key, value := range tree {
+		name := prefix + "." + key
+		var text string
+		switch v := value.(type) {
+		case nil:
+			continue
+		case map[string]any:
+			params = flattenParams(params, name, v)
+			continue
+		case string:
+			if v == "" {
+				continue
+			}
+			text = v
+		case []any:
+			if len(v) == 0 {
+				continue
+			}
+			data, _ := json.Marshal(v)
+			text = string(data)
+		default:
+			data, _ := json.Marshal(v)
+			text = string(data)
+		}
+		if len(text) > maxTrackedParamLength {
+			text = text[:maxTrackedParamLength]
+		}
+		params = append(params, trackedParam{Key: name, Value: text})
+	}
+	return params
+}
@@ -0,0 +1,239 @@
+package synthpop
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mlflowRequest is a request received by fakeMLflow, its JSON body decoded
+type mlflowRequest struct {
+	method, path, query, auth string
+	body                      map[string]any
+	raw                       string
+}
+
+// fakeMLflow is a tracking server answering the endpoints the tracker calls. It has
+// no experiment until one is created, and fails the endpoints named in fail.
+type fakeMLflow struct {
+	mu       sync.Mutex
+	requests []mlflowRequest
+	fail     map[string]bool
+}
+
+func (f *fakeMLflow) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	raw, _ := io.ReadAll(r.Body)
+	req := mlflowRequest{method: r.Method, path: r.URL.Path, query: r.URL.RawQuery, auth: r.Header.Get("Authorization"), raw: string(raw)}
+	if r.Header.Get("Content-Type") == "application/json" {
+		if err := json.Unmarshal(raw, &req.body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	failing := f.fail[strings.TrimPrefix(r.URL.Path, "/api/2.0/mlflow/")]
+	f.mu.Unlock()
+
+	switch endpoint := strings.TrimPrefix(r.URL.Path, "/api/2.0/mlflow/"); {
+	case failing:
+		http.Error(w, `{"error_code":"INTERNAL_ERROR"}`, http.StatusInternalServerError)
+	case endpoint == "experiments/get-by-name":
+		http.Error(w, `{"error_code":"RESOURCE_DOES_NOT_EXIST"}`, http.StatusNotFound)
+	case endpoint == "experiments/create":
+		io.WriteString(w, `{"experiment_id":"7"}`)
+	case endpoint == "runs/create":
+		io.WriteString(w, `{"run":{"info":{"run_id":"r1","artifact_uri":"mlflow-artifacts:/7/r1/artifacts"}}}`)
+	default:
+		io.WriteString(w, `{}`)
+	}
+}
+
+// sent returns the requests made to an endpoint of the MLflow API, or to a path
+func (f *fakeMLflow) sent(endpoint string) []mlflowRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var found []mlflowRequest
+	for _, r := range f.requests {
+		if r.path == "/api/2.0/mlflow/"+endpoint || r.path == endpoint {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+func startFakeMLflow(t *testing.T, fail ...string) (*fakeMLflow, *mlflowTracker) {
+	t.Helper()
+	quietConsole(t)
+	fake := &fakeMLflow{fail: make(map[string]bool)}
+	for _, endpoint := range fail {
+		fake.fail[endpoint] = true
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	t.Setenv(trackingURIEnv, server.URL+"/")
+	t.Setenv(trackingExperimentEnv, "calibration")
+	t.Setenv(trackingTokenEnv, "secret")
+	return fake, newTracker()
+}
+
+func testManifest() *RunManifest {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	m := &RunManifest{
+		RunName: "calib", StartedAt: start, FinishedAt: start.Add(90 * time.Second), WallClockSeconds: 90,
+		Seed: 42, ConfigHash: "abc", Build: BuildInfo{VCSRevision: "0123"},
+		AnnealingConfig: testConfig(),
+		Areas:           FitnessSummary{Synthesized: 3, Failed: 1, Mean: 0.25, Median: 0.2, Max: 0.5},
+	}
+	m.PopulationConfig.RunName = "calib"
+	return m
+}
+
+func TestTrackerSend(t *testing.T) {
+	fake, tracker := startFakeMLflow(t)
+	tracker.add(Result{Fitness: 0.5, BestIteration: 10})
+	tracker.add(Result{Fitness: 0.25, BestIteration: 20})
+	manifest := filepath.Join(t.TempDir(), "run_manifest.json")
+	if err := os.WriteFile(manifest, []byte(`{"runName":"calib"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m := testManifest()
+
+	runID, err := tracker.send(m, nil, []string{manifest, "", filepath.Join(t.TempDir(), "missing.csv")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runID != "r1" {
+		t.Errorf("run ID %q, want r1", runID)
+	}
+
+	if got := fake.sent("experiments/get-by-name"); len(got) != 1 || got[0].query != "experiment_name=calibration" {
+		t.Errorf("experiment looked up as %+v", got)
+	}
+	if got := fake.sent("experiments/create"); len(got) != 1 || got[0].body["name"] != "calibration" {
+		t.Errorf("experiment created as %+v", got)
+	}
+
+	created := fake.sent("runs/create")
+	if len(created) != 1 {
+		t.Fatalf("%d runs created, want 1", len(created))
+	}
+	body := created[0].body
+	if body["experiment_id"] != "7" || body["run_name"] != "calib" || body["start_time"] != float64(m.StartedAt.UnixMilli()) {
+		t.Errorf("run created with %v", body)
+	}
+	tags := make(map[string]any)
+	for _, tag := range body["tags"].([]any) {
+		tag := tag.(map[string]any)
+		tags[tag["key"].(string)] = tag["value"]
+	}
+	for key, want := range map[string]string{"mlflow.source.name": "GoSynthPop", "seed": "42", "config_hash": "abc", "vcs_revision": "0123"} {
+		if tags[key] != want {
+			t.Errorf("tag %s = %v, want %s", key, tags[key], want)
+		}
+	}
+
+	// Every batch names the run; the parameters and metrics come in separate batches
+	params := make(map[string]any)
+	metrics := make(map[string][]map[string]any)
+	for _, batch := range fake.sent("runs/log-batch") {
+		if batch.body["run_id"] != "r1" {
+			t.Errorf("batch logged to run %v", batch.body["run_id"])
+		}
+		ps, _ := batch.body["params"].([]any)
+		if len(ps) > trackedParamsPerBatch {
+			t.Errorf("batch of %d parameters", len(ps))
+		}
+		for _, p := range ps {
+			p := p.(map[string]any)
+			params[p["key"].(string)] = p["value"]
+		}
+		ms, _ := batch.body["metrics"].([]any)
+		for _, metric := range ms {
+			metric := metric.(map[string]any)
+			metrics[metric["key"].(string)] = append(metrics[metric["key"].(string)], metric)
+		}
+	}
+	want, err := trackedParams(m.PopulationConfig, m.AnnealingConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(params) != len(want) {
+		t.Errorf("%d parameters logged, want %d", len(params), len(want))
+	}
+	if params["population.runName"] != "calib" || params["annealing.maxIterations"] != "5000" {
+		t.Errorf("parameters logged as %v", params)
+	}
+	if got := metrics["areas_synthesized"]; len(got) != 1 || got[0]["value"] != 3.0 || got[0]["timestamp"] != float64(m.FinishedAt.UnixMilli()) {
+		t.Errorf("areas_synthesized logged as %v", got)
+	}
+	if got := metrics["area_fitness"]; len(got) != 2 || got[0]["value"] != 0.5 || got[1]["value"] != 0.25 || got[1]["step"] != 1.0 {
+		t.Errorf("area_fitness logged as %v", got)
+	}
+
+	uploaded := fake.sent("/api/2.0/mlflow-artifacts/artifacts/7/r1/artifacts/run_manifest.json")
+	if len(uploaded) != 1 || uploaded[0].method != http.MethodPut || uploaded[0].raw != `{"runName":"calib"}` {
+		t.Errorf("manifest uploaded as %+v", uploaded)
+	}
+
+	updated := fake.sent("runs/update")
+	if len(updated) != 1 {
+		t.Fatalf("run terminated %d times, want once", len(updated))
+	}
+	if b := updated[0].body; b["run_id"] != "r1" || b["status"] != "FINISHED" || b["end_time"] != float64(m.FinishedAt.UnixMilli()) {
+		t.Errorf("run terminated with %v", b)
+	}
+	for _, r := range fake.requests {
+		if r.auth != "Bearer secret" {
+			t.Errorf("%s sent with authorization %q", r.path, r.auth)
+		}
+	}
+}
+
+func TestTrackerErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		fail    string
+		gateErr error
+		want    string
+		status  string // Status the run is terminated with, "" when it is not created
+	}{
+		{"experiment", "experiments/create", nil, "cannot find or create experiment calibration", ""},
+		{"run", "runs/create", nil, "runs/create: 500", ""},
+		{"batch", "runs/log-batch", nil, "runs/log-batch: 500", "FINISHED"},
+		{"terminate", "runs/update", nil, "runs/update: 500", "FINISHED"},
+		{"failed gate", "runs/log-batch", errors.New("gate"), "runs/log-batch: 500", "FAILED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, tracker := startFakeMLflow(t, tt.fail)
+			_, err := tracker.send(testManifest(), tt.gateErr, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) || !strings.Contains(err.Error(), "INTERNAL_ERROR") {
+				t.Fatalf("error %v, want one containing %q and the server's message", err, tt.want)
+			}
+			// A created run is terminated whatever failed after
+			updated := fake.sent("runs/update")
+			if tt.status == "" && len(updated) != 0 {
+				t.Errorf("run terminated %d times without being created", len(updated))
+			}
+			if tt.status != "" && (len(updated) != 1 || updated[0].body["status"] != tt.status) {
+				t.Errorf("run terminated as %+v, want once with status %s", updated, tt.status)
+			}
+		})
+	}
+	// Tracking is off without a server, and a failure does not fail the run
+	t.Setenv(trackingURIEnv, "")
+	if newTracker() != nil {
+		t.Error("tracker created without a tracking URI")
+	}
+	_, tracker := startFakeMLflow(t, "runs/create")
+	tracker.log(testManifest(), nil, nil)
+}
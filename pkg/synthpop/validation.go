@@ -0,0 +1,165 @@
+package synthpop
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// validationWriter writes the validation product of a run: the error of every area
+// and variable (synthetic count, constraint count, absolute and percentage error)
+// and a summary per area with the total absolute error (TAE), the standardized
+// absolute error (SAE = TAE / population), the stagnation window and reheats of the
+// search, for restarted areas the spread of the fitness over the restarts, and the
+// status of the area ("empty" for a zero total). Either file may be left
+// unconfigured.
+type validationWriter struct {
+	header       []string
+	denominators [][]int // Columns of each variable's subpopulation, nil for the area population
+	errorsFile   *outputFile
+	errorsCSV    *csv.Writer
+	summaryFile  *outputFile
+	summaryCSV   *csv.Writer
+}
+
+// newValidationWriter creates the validation files, or returns nil when neither is
+// configured
+func newValidationWriter(popConfig PopulationConfig, header []string, denominators [][]int, key []byte,
+	retry retryPolicy) (*validationWriter, error) {
+	if popConfig.Validate.ErrorsFile == "" && popConfig.Validate.SummaryFile == "" {
+		return nil, nil
+	}
+	w := &validationWriter{header: header, denominators: denominators}
+	if popConfig.Validate.ErrorsFile != "" {
+		file, err := createOutput(popConfig.Validate.ErrorsFile, key, retry)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create validation errors file: %w", err)
+		}
+		w.errorsFile, w.errorsCSV = file, csv.NewWriter(file)
+		columns := []string{"area_id", "variable", "synthetic", "constraint", "absolute_error", "percentage_error",
+			"synthetic_share", "constraint_share"}
+		if err := w.errorsCSV.Write(columns); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("error writing validation errors header: %w", err)
+		}
+	}
+	if popConfig.Validate.SummaryFile != "" {
+		file, err := createOutput(popConfig.Validate.SummaryFile, key, retry)
+		if err != nil {
+			w.Close()
+			return nil, fmt.Errorf("cannot create validation summary file: %w", err)
+		}
+		w.summaryFile, w.summaryCSV = file, csv.NewWriter(file)
+		columns := []string{"area_id", "population", "tae", "sae", "fitness", "best_iteration", "window_size", "reheats",
+			"restarts", "fitness_worst", "fitness_sd", "status"}
+		if err := w.summaryCSV.Write(columns); err != nil {
+			w.Close()
+			return nil, fmt.Errorf("error writing validation summary header: %w", err)
+		}
+	}
+	return w, nil
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// share formats v / total, empty when total is zero
+func share(v, total float64) string {
+	if total == 0 {
+		return ""
+	}
+	return formatFloat(v / total)
+}
+
+// writeArea writes the rows of one area. The shares are of the variable's
+// subpopulation (see AnnealingConfig.Denominators) or of the area population. The
+// percentage error of a zero constraint and shares of an empty total are left empty.
+func (w *validationWriter) writeArea(res Result) error {
+	tae := 0.0
+	for i, name := range w.header {
+		absError := math.Abs(res.Totals[i] - res.ConstraintTotals[i])
+		tae += absError
+		if w.errorsCSV == nil {
+			continue
+		}
+		pctError := ""
+		if res.ConstraintTotals[i] != 0 {
+			pctError = formatFloat(100 * absError / res.ConstraintTotals[i])
+		}
+		row := []string{res.Area, name, formatFloat(res.Totals[i]), formatFloat(res.ConstraintTotals[i]),
+			formatFloat(absError), pctError,
+			share(res.Totals[i], subpopulation(res.Totals, w.denominators, i, res.Population)),
+			share(res.ConstraintTotals[i], subpopulation(res.ConstraintTotals, w.denominators, i, res.Population))}
+		if err := w.errorsCSV.Write(row); err != nil {
+			return fmt.Errorf("error writing validation errors row: %w", err)
+		}
+	}
+	if w.summaryCSV == nil {
+		return nil
+	}
+	sae := ""
+	if res.Population > 0 {
+		sae = formatFloat(tae / res.Population)
+	}
+	// The search columns are left empty for areas without an annealing search (other
+	// algorithms, or summaries regenerated by report)
+	window, reheats := "", ""
+	if res.WindowSize > 0 {
+		window, reheats = strconv.Itoa(res.WindowSize), strconv.Itoa(res.Reheats)
+	}
+	restarts, worst, sd := "", "", ""
+	if len(res.RestartFitness) > 0 {
+		restarts = strconv.Itoa(len(res.RestartFitness))
+		w, s := restartSpread(res.RestartFitness)
+		worst, sd = formatFloat(w), formatFloat(s)
+	}
+	status := "synthesized"
+	if res.Empty {
+		status = "empty"
+	}
+	row := []string{res.Area, formatFloat(res.Population), formatFloat(tae), sae,
+		formatFloat(res.Fitness), strconv.Itoa(res.BestIteration), window, reheats, restarts, worst, sd, status}
+	if err := w.summaryCSV.Write(row); err != nil {
+		return fmt.Errorf("error writing validation summary row: %w", err)
+	}
+	return nil
+}
+
+// restartSpread returns the worst fitness of the restarts of an area and the
+// standard deviation of their fitness
+func restartSpread(fitness []float64) (float64, float64) {
+	worst, mean := fitness[0], 0.0
+	for _, f := range fitness {
+		worst = max(worst, f)
+		mean += f
+	}
+	mean /= float64(len(fitness))
+	variance := 0.0
+	for _, f := range fitness {
+		variance += (f - mean) * (f - mean)
+	}
+	return worst, math.Sqrt(variance / float64(len(fitness)))
+}
+
+// Close flushes and closes the validation files
+func (w *validationWriter) Close() error {
+	var firstErr error
+	for _, f := range []struct {
+		file   *outputFile
+		writer *csv.Writer
+	}{{w.errorsFile, w.errorsCSV}, {w.summaryFile, w.summaryCSV}} {
+		if f.file == nil {
+			continue
+		}
+		f.writer.Flush()
+		if err := f.writer.Error(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := f.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
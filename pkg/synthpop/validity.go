@@ -0,0 +1,131 @@
+package synthpop
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// Valid pools: a record can join an area only if it is zero wherever the area's
+// constraints are zero. Areas sharing a zero pattern, which with census tables is
+// most of them, admit the same records, so the pool of valid records is worked out
+// once per pattern and shared by the workers instead of filtering the microdata
+// again for every area. Replacements are drawn from the pool directly, never
+// rejected, so a move always has a candidate however few records are valid.
+
+// validityCacheLimit is the number of record indices the cached pools of a run may
+// hold in all; the pools of further patterns are built in the worker's buffers
+const validityCacheLimit = 1 << 22
+
+// validPool is the records valid for an area, read-only once built
+type validPool struct {
+	indices    []int
+	cumulative []float64 // Cumulative design weights of indices, nil when unweighted
+}
+
+// draw returns a valid record, in proportion to its design weight
+func (p validPool) draw(rng *rand.Rand) int {
+	return drawFromPool(p.indices, p.cumulative, rng)
+}
+
+// validityKey identifies the zero pattern of an area in a microdata pool
+type validityKey struct {
+	microdata sparseKey
+	zeros     string // Bitset of the zero constraints
+}
+
+// validityIndex holds the valid pools of the zero patterns met in a run, shared
+// by the workers
+type validityIndex struct {
+	mu     sync.Mutex
+	pools  map[validityKey]validPool
+	cached int // Indices held by the pools
+}
+
+func newValidityIndex() *validityIndex {
+	return &validityIndex{pools: make(map[validityKey]validPool)}
+}
+
+// pool returns the records of microdata valid for constraints: from the index when
+// an area with the same zero pattern built them, otherwise filtered and added to it
+//
+// Parameters:
+//   - microdata: The records the area draws from
+//   - constraints: The area's constraint values
+//   - scratch: Worker buffers the pool is built in when it is not cached (x nil or full)
+//
+// Returns:
+//   - validPool: The valid records, empty if there are none
+func (x *validityIndex) pool(microdata []MicroData, constraints []float64, scratch *annealScratch) validPool {
+	if x == nil || len(microdata) == 0 {
+		return scratch.buildPool(microdata, constraints)
+	}
+	scratch.zeros = zeroPattern(constraints, scratch.zeros)
+	key := validityKey{sparseKey{&microdata[0], len(microdata)}, string(scratch.zeros)}
+	x.mu.Lock()
+	pool, ok := x.pools[key]
+	full := x.cached >= validityCacheLimit
+	x.mu.Unlock()
+	if ok {
+		return pool
+	}
+	if full {
+		return scratch.buildPool(microdata, constraints)
+	}
+
+	// Filtered outside the lock, so workers meeting new patterns do not wait on
+	// each other; a pattern two workers meet at once is kept once
+	indices := validIndices(microdata, constraints, nil)
+	pool = validPool{indices: indices, cumulative: poolWeights(indices, microdata, nil)}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if kept, ok := x.pools[key]; ok {
+		return kept
+	}
+	x.pools[key] = pool
+	x.cached += len(indices)
+	return pool
+}
+
+// buildPool filters the valid records of an area into the worker's own buffers
+func (s *annealScratch) buildPool(microdata []MicroData, constraints []float64) validPool {
+	s.validIndices = validIndices(microdata, constraints, s.validIndices)
+	s.validWeights = poolWeights(s.validIndices, microdata, s.validWeights)
+	return validPool{indices: s.validIndices, cumulative: s.validWeights}
+}
+
+// zeroPattern returns the bitset of the zero constraints, built in buf
+func zeroPattern(constraints []float64, buf []byte) []byte {
+	buf = buf[:0]
+	for i := 0; i < len(constraints); i += 8 {
+		var b byte
+		for j := i; j < min(i+8, len(constraints)); j++ {
+			if constraints[j] == 0 {
+				b |= 1 << (j - i)
+			}
+		}
+		buf = append(buf, b)
+	}
+	return buf
+}
+
+// validIndices appends to buf[:0] the indices of the records that are zero wherever
+// constraints are, checking only the zero columns
+func validIndices(microdata []MicroData, constraints []float64, buf []int) []int {
+	var zeros []int
+	for i, c := range constraints {
+		if c == 0 {
+			zeros = append(zeros, i)
+		}
+	}
+	buf = buf[:0]
+records:
+	for i, md := range microdata {
+		for _, z := range zeros {
+			if md.Values[z] != 0 {
+				continue records
+			}
+		}
+		buf = append(buf, i)
+	}
+	return buf
+}
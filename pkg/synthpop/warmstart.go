@@ -0,0 +1,85 @@
+package synthpop
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ReadSeedPopulation reads the populations of a previous run from its ID mapping
+// output, in either layout, as indices into microData, so a rerun with recalibrated
+// constraints or a tweaked config starts every area from where the previous run
+// ended instead of from a random population. Records missing from microData (e.g.
+// after the microdata changed) are skipped and drawn afresh.
+//
+// Parameters:
+//   - path: The ID mapping CSV, optionally compressed (.gz or .zst)
+//   - microData: The microdata of the run
+//
+// Returns:
+//   - map[string][]int: The microdata indices of every area in the file
+//   - int: The number of individuals whose record is not in microData
+//   - error: Any error reading the file
+func ReadSeedPopulation(path string, microData []MicroData) (map[string][]int, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot open seed population file: %w", err)
+	}
+	defer file.Close()
+	in, err := decompress(path, bufio.NewReaderSize(file, 1<<20))
+	if err != nil {
+		return nil, 0, err
+	}
+	reader := csv.NewReader(in)
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, 0, fmt.Errorf("error reading seed population header: %w", err)
+	}
+	withCounts := slices.Equal(header, idsHeader(OutputLayoutCounts))
+	if !withCounts && !slices.Equal(header, idsHeader(OutputLayoutIDs)) {
+		return nil, 0, fmt.Errorf("seed population header %v is neither %v nor %v", header,
+			idsHeader(OutputLayoutIDs), idsHeader(OutputLayoutCounts))
+	}
+
+	indexOf := make(map[string]int, len(microData))
+	for i, md := range microData {
+		indexOf[md.ID] = i
+	}
+	seeds := make(map[string][]int)
+	missing := 0
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("seed population line %d: %w", line, err)
+		}
+		n := 1
+		if withCounts {
+			if n, err = strconv.Atoi(row[2]); err != nil || n < 0 {
+				return nil, 0, fmt.Errorf("seed population line %d: invalid count %q", line, row[2])
+			}
+		}
+		index, ok := indexOf[row[1]]
+		if !ok {
+			missing += n
+			continue
+		}
+		area, ok := seeds[row[0]]
+		if !ok {
+			row[0] = strings.Clone(row[0])
+		}
+		for range n {
+			area = append(area, index)
+		}
+		seeds[row[0]] = area
+	}
+	return seeds, missing, nil
+}
@@ -0,0 +1,142 @@
+package synthpop
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// watchStopFile ends a watched run once it appears in the watched directory
+const watchStopFile = "DONE"
+
+// defaultWatchInterval is how often the watched directory is listed
+const defaultWatchInterval = 5 * time.Second
+
+// constraintWatcher hands the areas of constraint files delivered to a directory to
+// a running job. A file is read once; its columns are derived and matched to the
+// run's variables by name, and areas already in the run are skipped. Files must be
+// complete when they appear, so deliveries should be written elsewhere and moved in.
+type constraintWatcher struct {
+	dir      string
+	interval time.Duration
+	header   []string
+	derived  map[string]string
+	files    map[string]bool // Files already read
+	areas    map[string]bool // Areas already in the run
+}
+
+// newConstraintWatcher returns the watcher of popConfig.Watch.Dir, or nil when no
+// directory is watched
+//
+// Parameters:
+//   - popConfig: The population configuration
+//   - header: The variables of the run
+//   - constraints: The areas the run started with
+func newConstraintWatcher(popConfig PopulationConfig, header []string, constraints []ConstraintData) (*constraintWatcher, error) {
+	if popConfig.Watch.Dir == "" {
+		return nil, nil
+	}
+	if popConfig.HouseholdSynthesis() {
+		return nil, fmt.Errorf("watch.dir is not supported with household synthesis")
+	}
+	if info, err := os.Stat(popConfig.Watch.Dir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("watch.dir %s is not a directory", popConfig.Watch.Dir)
+	}
+	w := &constraintWatcher{
+		dir:      popConfig.Watch.Dir,
+		interval: defaultWatchInterval,
+		header:   header,
+		derived:  popConfig.Derived,
+		files:    make(map[string]bool),
+		areas:    make(map[string]bool, len(constraints)),
+	}
+	if popConfig.Watch.IntervalSeconds > 0 {
+		w.interval = time.Duration(popConfig.Watch.IntervalSeconds) * time.Second
+	}
+	for _, constraint := range constraints {
+		w.areas[constraint.ID] = true
+	}
+	Printf("👀 Watching %s for new constraint areas, create %s there to finish the run\n",
+		w.dir, filepath.Join(w.dir, watchStopFile))
+	return w, nil
+}
+
+// poll reads the constraint files that appeared since the last poll and returns
+// their new areas, and whether the stop file is present. The stop file is checked
+// before listing, so files delivered before it are always read. A file that
+// cannot be used is reported and skipped rather than ending the run.
+func (w *constraintWatcher) poll(ctx context.Context) ([]ConstraintData, bool, error) {
+	_, err := os.Stat(filepath.Join(w.dir, watchStopFile))
+	stop := err == nil
+
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, stop, fmt.Errorf("cannot list watch.dir: %w", err)
+	}
+	var added []ConstraintData
+	for _, entry := range entries {
+		name := entry.Name()
+		ext := strings.ToLower(filepath.Ext(name))
+		if entry.IsDir() || w.files[name] || (ext != ".csv" && ext != ".parquet") {
+			continue
+		}
+		w.files[name] = true
+		areas, err := w.read(ctx, filepath.Join(w.dir, name))
+		if err != nil {
+			Printf("\n⚠️ Skipping delivered constraints %s: %v\n", name, err)
+			continue
+		}
+		skipped := 0
+		for _, area := range areas {
+			if w.areas[area.ID] {
+				skipped++
+				continue
+			}
+			w.areas[area.ID] = true
+			added = append(added, area)
+		}
+		Printf("\n📥 %s: %d new areas", name, len(areas)-skipped)
+		if skipped > 0 {
+			Printf(", %d already in the run skipped", skipped)
+		}
+		Println()
+	}
+	return added, stop, nil
+}
+
+// read loads a delivered constraint file with the run's derived columns and
+// variables
+func (w *constraintWatcher) read(ctx context.Context, path string) ([]ConstraintData, error) {
+	areas, header, err := ReadConstraints(ctx, path, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(w.derived) > 0 {
+		if areas, header, err = DeriveConstraints(w.derived, header, areas); err != nil {
+			return nil, err
+		}
+	}
+	if slices.Equal(header, w.header) {
+		return areas, nil
+	}
+
+	// Select the run's variables by name
+	columns := make([]int, len(w.header))
+	for i, name := range w.header {
+		if columns[i] = slices.Index(header, name); columns[i] < 0 {
+			return nil, fmt.Errorf("variable '%s' is missing", name)
+		}
+	}
+	for i, area := range areas {
+		values := make([]float64, len(columns))
+		for j, col := range columns {
+			values[j] = area.Values[col]
+		}
+		areas[i].Values = values
+	}
+	return areas, nil
+}
@@ -0,0 +1,142 @@
+package synthpop
+
+import (
+	"fmt"
+	"math"
+)
+
+// columnWeights maps AnnealingConfig.VariableWeights onto the header columns.
+// Variables without a weight keep weight 1.
+func columnWeights(config AnnealingConfig, header []string) ([]float64, error) {
+	columnOf := make(map[string]int, len(header))
+	for i, name := range header {
+		columnOf[name] = i
+	}
+	weights := make([]float64, len(header))
+	for i := range weights {
+		weights[i] = 1
+	}
+	for name, weight := range config.VariableWeights {
+		column, ok := columnOf[name]
+		if !ok {
+			return nil, fmt.Errorf("variableWeights: variable '%s' is not in the header", name)
+		}
+		weights[column] = weight
+	}
+	return weights, nil
+}
+
+// weightedDistanceFunc returns the named metric with every column's contribution
+// scaled by its weight. Weights multiply the per-column terms of the separable
+// metrics (inside the square root for the Euclidean ones); the cosine distance uses
+// the weighted inner product. With all weights 1 each metric equals its unweighted
+// version.
+//
+// Parameters:
+//   - metric: A name from ValidMetrics (unknown names fall back to KL divergence
+//     like distanceFunc)
+//   - weights: Non-negative weight per column
+//
+// Returns:
+//   - DistanceFunc: The weighted metric
+func weightedDistanceFunc(metric string, weights []float64) DistanceFunc {
+	switch metricByName[metric] {
+	case CHI_SQUARED:
+		return func(constraints, testData []float64) float64 {
+			distance := 0.0
+			for i := range constraints {
+				observed := testData[i] + EPSILON
+				expected := constraints[i] + EPSILON
+				diff := observed - expected
+				distance += weights[i] * (diff * diff) / expected
+			}
+			return distance
+		}
+	case EUCLIDEAN:
+		return func(constraints, testData []float64) float64 {
+			distance := 0.0
+			for i := range constraints {
+				diff := testData[i] - constraints[i]
+				distance += weights[i] * diff * diff
+			}
+			return math.Sqrt(distance)
+		}
+	case NORM_EUCLIDEAN:
+		return func(constraints, testData []float64) float64 {
+			distance := 0.0
+			for i := range constraints {
+				norm := constraints[i]
+				if math.Abs(norm) < EPSILON {
+					if math.Abs(testData[i]) > EPSILON {
+						distance += weights[i] * DefaultHardPenalty * testData[i] * testData[i]
+					}
+					continue
+				}
+				diff := (testData[i] - constraints[i]) / norm
+				distance += weights[i] * diff * diff
+			}
+			return math.Sqrt(distance)
+		}
+	case MANHATTEN:
+		return func(constraints, testData []float64) float64 {
+			distance := 0.0
+			for i := range constraints {
+				distance += weights[i] * math.Abs(testData[i]-constraints[i])
+			}
+			return distance
+		}
+	case COSINE:
+		return func(constraints, testData []float64) float64 {
+			dot, normConstraints, normTestData := 0.0, 0.0, 0.0
+			for i := range constraints {
+				dot += weights[i] * constraints[i] * testData[i]
+				normConstraints += weights[i] * constraints[i] * constraints[i]
+				normTestData += weights[i] * testData[i] * testData[i]
+			}
+			if normConstraints < EPSILON || normTestData < EPSILON {
+				if normConstraints < EPSILON && normTestData < EPSILON {
+					return 0
+				}
+				return 1
+			}
+			return 1 - dot/(math.Sqrt(normConstraints)*math.Sqrt(normTestData))
+		}
+	case JSDIVERGENCE:
+		return func(constraints, testData []float64) float64 {
+			sumP, sumQ := 0.0, 0.0
+			for i := range constraints {
+				sumP += constraints[i]
+				sumQ += testData[i]
+			}
+			if sumP < EPSILON || sumQ < EPSILON {
+				if sumP < EPSILON && sumQ < EPSILON {
+					return 0
+				}
+				return math.Ln2
+			}
+			divergence := 0.0
+			for i := range constraints {
+				p := constraints[i] / sumP
+				q := testData[i] / sumQ
+				m := (p + q) / 2
+				if p > 0 {
+					divergence += weights[i] * 0.5 * p * math.Log(p/m)
+				}
+				if q > 0 {
+					divergence += weights[i] * 0.5 * q * math.Log(q/m)
+				}
+			}
+			return divergence
+		}
+	default:
+		return func(constraints, testData []float64) float64 {
+			divergence := 0.0
+			for i := range constraints {
+				p := constraints[i] + EPSILON
+				q := testData[i] + EPSILON
+				divergence += weights[i] * p * math.Log(p/q)
+			}
+			return divergence
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package synthpop
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultWorkerLogDir is where the worker logs are written when Debug.Dir is empty
+const defaultWorkerLogDir = "logs"
+
+// workerLog is the log file of one worker (worker-03.log). Only its worker writes
+// to it, so the lines of an area are never interleaved with other workers' and
+// the file can be followed while the run progresses.
+type workerLog struct {
+	file   *os.File
+	writer *bufio.Writer
+	start  time.Time
+}
+
+// openWorkerLogs creates one log file per worker, or returns nil when worker logs
+// are off
+func openWorkerLogs(popConfig PopulationConfig, numWorkers int) ([]*workerLog, error) {
+	if !popConfig.Debug.WorkerLogs {
+		return nil, nil
+	}
+	dir := popConfig.Debug.Dir
+	if dir == "" {
+		dir = defaultWorkerLogDir
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("cannot create worker log directory: %w", err)
+	}
+
+	logs := make([]*workerLog, numWorkers)
+	start := time.Now()
+	for i := range logs {
+		file, err := os.Create(filepath.Join(dir, fmt.Sprintf("worker-%02d.log", i)))
+		if err != nil {
+			closeWorkerLogs(logs)
+			return nil, fmt.Errorf("cannot create worker log: %w", err)
+		}
+		logs[i] = &workerLog{file: file, writer: bufio.NewWriter(file), start: start}
+		logs[i].printf("worker %d of run %s", i, popConfig.RunName)
+	}
+	Printf("🪵 Worker logs in %s\n", dir)
+	return logs, nil
+}
+
+// closeWorkerLogs flushes and closes the logs, returning the first error
+func closeWorkerLogs(logs []*workerLog) error {
+	var errs []error
+	for _, l := range logs {
+		if l == nil {
+			continue
+		}
+		errs = append(errs, l.writer.Flush(), l.file.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// printf writes a line prefixed with the time since the run started. Write errors
+// are reported when the log is closed.
+func (l *workerLog) printf(format string, args ...any) {
+	fmt.Fprintf(l.writer, "%10.3fs ", time.Since(l.start).Seconds())
+	fmt.Fprintf(l.writer, format, args...)
+	l.writer.WriteByte('\n')
+}
+
+// areaStart logs the start of an area
+func (l *workerLog) areaStart(constraint ConstraintData) {
+	l.printf("start  %s population=%g", constraint.ID, constraint.Total)
+}
+
+// areaFinish logs the outcome of an area with its accept/reject rates and any
+// warnings, and flushes the log so a hung or crashed run shows the last area
+func (l *workerLog) areaFinish(res Result, err error, took time.Duration) {
+	if err != nil {
+		l.printf("WARN   %s failed after %v: %v", res.Area, took, err)
+		l.writer.Flush()
+		return
+	}
+	if res.Empty {
+		l.printf("finish %s empty (zero total) fitness=%g", res.Area, res.Fitness)
+		l.writer.Flush()
+		return
+	}
+	rates := "accept=n/a reject=n/a"
+	if res.Iterations > 0 {
+		acceptRate := float64(res.Accepted) / float64(res.Iterations)
+		rates = fmt.Sprintf("iterations=%d accept=%.3f reject=%.3f window=%d reheats=%d",
+			res.Iterations, acceptRate, 1-acceptRate, res.WindowSize, res.Reheats)
+	}
+	l.printf("finish %s in %v fitness=%g best_iteration=%d %s", res.Area, took, res.Fitness, res.BestIteration, rates)
+	if res.PoolSize > 0 && float64(res.PoolSize) < res.Population {
+		l.printf("WARN   %s pool of %d valid records is smaller than the population %g, records are repeated",
+			res.Area, res.PoolSize, res.Population)
+	}
+	l.writer.Flush()
+}
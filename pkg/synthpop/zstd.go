@@ -0,0 +1,1604 @@
+package synthpop
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// Zstandard (RFC 8878) without external dependencies, for .zst inputs and outputs.
+//
+// The reader decodes the frames written by any zstd compressor: raw, RLE and
+// compressed blocks with Huffman-coded literals and predefined, RLE, FSE or
+// repeated sequence tables, skippable frames and content checksums. Frames needing
+// a dictionary are rejected. The writer produces a single frame with a 4 MB window:
+// lazy LZ77 matching over hash chains, Huffman-coded literals and sequences coded
+// with the predefined tables or ones fitted to each block.
+
+const (
+	zstdMagic         = 0xFD2FB528
+	zstdSkippableMask = 0xFFFFFFF0
+	zstdSkippable     = 0x184D2A50
+	zstdMaxBlockSize  = 128 << 10
+	zstdMaxWindow     = 1 << 30 // Largest window the reader accepts (zstd --long uses 128 MB)
+
+	zstdWriterWindowLog = 22
+	zstdWriterWindow    = 1 << zstdWriterWindowLog
+	zstdHashLog         = 17
+	zstdChainLog        = 16
+	zstdChainDepth      = 16
+	zstdMinMatch        = 4
+	zstdMaxHuffmanBits  = 11
+)
+
+var errZstdCorrupt = errors.New("corrupt zstd data")
+
+// Baselines and extra bits of the literal length and match length codes
+var (
+	zstdLLBase = [36]uint32{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768, 65536}
+	zstdLLBits = [36]uint8{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	zstdMLBase = [53]uint32{3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22,
+		23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051, 4099, 8195, 16387, 32771, 65539}
+	zstdMLBits = [53]uint8{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+)
+
+// Predefined distributions of the sequence codes, with their accuracy logs
+var (
+	zstdLLDefault = []int16{4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1, 2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1}
+	zstdMLDefault = []int16{1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1, -1, -1}
+	zstdOFDefault = []int16{1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1}
+
+	zstdLLDecode = mustZstdFSETable(zstdLLDefault, 6)
+	zstdMLDecode = mustZstdFSETable(zstdMLDefault, 6)
+	zstdOFDecode = mustZstdFSETable(zstdOFDefault, 5)
+	zstdLLEncode = newZstdFSEEncoder(zstdLLDefault, 6)
+	zstdMLEncode = newZstdFSEEncoder(zstdMLDefault, 6)
+	zstdOFEncode = newZstdFSEEncoder(zstdOFDefault, 5)
+)
+
+// zstdOffset resolves the offset value of a sequence against the repeat offsets,
+// which it updates. Values 1 to 3 name a repeat offset (shifted by one when the
+// sequence has no literals), larger values are the offset plus 3.
+func zstdOffset(rep *[3]int, value uint32, litLen int) int {
+	if value > 3 {
+		offset := int(value - 3)
+		rep[2], rep[1], rep[0] = rep[1], rep[0], offset
+		return offset
+	}
+	if litLen == 0 {
+		value++
+	}
+	switch value {
+	case 1:
+		return rep[0]
+	case 2:
+		rep[0], rep[1] = rep[1], rep[0]
+	case 3:
+		rep[0], rep[1], rep[2] = rep[2], rep[0], rep[1]
+	default:
+		rep[0], rep[1], rep[2] = rep[0]-1, rep[0], rep[1]
+	}
+	return rep[0]
+}
+
+// zstdLLCode returns the code of a literal length
+func zstdLLCode(n uint32) uint8 {
+	if n < 16 {
+		return uint8(n)
+	}
+	code := uint8(35)
+	for zstdLLBase[code] > n {
+		code--
+	}
+	return code
+}
+
+// zstdMLCode returns the code of a match length
+func zstdMLCode(n uint32) uint8 {
+	if n < 35 {
+		return uint8(n - 3)
+	}
+	code := uint8(52)
+	for zstdMLBase[code] > n {
+		code--
+	}
+	return code
+}
+
+// zstdXXH64 is the 64-bit xxHash with seed 0, whose low 32 bits are a frame's
+// content checksum
+type zstdXXH64 struct {
+	v     [4]uint64
+	total uint64
+	mem   [32]byte
+	n     int
+}
+
+const (
+	xxhPrime1 uint64 = 11400714785074694791
+	xxhPrime2 uint64 = 14029467366897019727
+	xxhPrime3 uint64 = 1609587929392839161
+	xxhPrime4 uint64 = 9650029242287828579
+	xxhPrime5 uint64 = 2870177450012600261
+)
+
+func (h *zstdXXH64) reset() {
+	p1 := xxhPrime1
+	*h = zstdXXH64{v: [4]uint64{p1 + xxhPrime2, xxhPrime2, 0, -p1}}
+}
+
+func xxhRound(acc, input uint64) uint64 {
+	return bits.RotateLeft64(acc+input*xxhPrime2, 31) * xxhPrime1
+}
+
+func (h *zstdXXH64) Write(p []byte) (int, error) {
+	n := len(p)
+	h.total += uint64(n)
+	if h.n > 0 {
+		c := copy(h.mem[h.n:], p)
+		h.n += c
+		p = p[c:]
+		if h.n < 32 {
+			return n, nil
+		}
+		h.stripes(h.mem[:])
+		h.n = 0
+	}
+	full := len(p) &^ 31
+	h.stripes(p[:full])
+	h.n = copy(h.mem[:], p[full:])
+	return n, nil
+}
+
+func (h *zstdXXH64) stripes(p []byte) {
+	for ; len(p) >= 32; p = p[32:] {
+		for i := range h.v {
+			h.v[i] = xxhRound(h.v[i], binary.LittleEndian.Uint64(p[8*i:]))
+		}
+	}
+}
+
+func (h *zstdXXH64) Sum64() uint64 {
+	var sum uint64
+	if h.total >= 32 {
+		sum = bits.RotateLeft64(h.v[0], 1) + bits.RotateLeft64(h.v[1], 7) +
+			bits.RotateLeft64(h.v[2], 12) + bits.RotateLeft64(h.v[3], 18)
+		for _, v := range h.v {
+			sum = (sum^xxhRound(0, v))*xxhPrime1 + xxhPrime4
+		}
+	} else {
+		sum = xxhPrime5
+	}
+	sum += h.total
+	p := h.mem[:h.n]
+	for ; len(p) >= 8; p = p[8:] {
+		sum = bits.RotateLeft64(sum^xxhRound(0, binary.LittleEndian.Uint64(p)), 27)*xxhPrime1 + xxhPrime4
+	}
+	if len(p) >= 4 {
+		sum = bits.RotateLeft64(sum^uint64(binary.LittleEndian.Uint32(p))*xxhPrime1, 23)*xxhPrime2 + xxhPrime3
+		p = p[4:]
+	}
+	for _, b := range p {
+		sum = bits.RotateLeft64(sum^uint64(b)*xxhPrime5, 11) * xxhPrime1
+	}
+	sum ^= sum >> 33
+	sum *= xxhPrime2
+	sum ^= sum >> 29
+	sum *= xxhPrime3
+	return sum ^ sum>>32
+}
+
+// zstdForwardBits reads the little-endian bit fields of an FSE table description
+type zstdForwardBits struct {
+	data []byte
+	pos  int // In bits
+}
+
+func (b *zstdForwardBits) peek(n uint8) uint32 {
+	var v uint64
+	for i := min((b.pos+int(n)+7)>>3, len(b.data)) - 1; i >= b.pos>>3; i-- {
+		v = v<<8 | uint64(b.data[i])
+	}
+	return uint32(v>>(b.pos&7)) & (1<<n - 1)
+}
+
+// zstdBackwardBits reads a bitstream written forwards from its end: the highest set
+// bit of the last byte marks the end, the fields before it are read last to first
+type zstdBackwardBits struct {
+	data []byte
+	pos  int // Bits left to read; negative once the stream is overrun
+}
+
+func newZstdBackwardBits(data []byte) (zstdBackwardBits, error) {
+	if len(data) == 0 || data[len(data)-1] == 0 {
+		return zstdBackwardBits{}, errZstdCorrupt
+	}
+	return zstdBackwardBits{data: data, pos: (len(data)-1)*8 + bits.Len8(data[len(data)-1]) - 1}, nil
+}
+
+// peek returns the next n bits (at most 56), padded with zeros past the start
+func (b *zstdBackwardBits) peek(n uint8) uint64 {
+	start := b.pos - int(n)
+	if n == 0 || b.pos <= 0 {
+		return 0
+	}
+	if start < 0 {
+		return b.at(0, uint8(b.pos)) << -start
+	}
+	return b.at(start, n)
+}
+
+func (b *zstdBackwardBits) at(start int, n uint8) uint64 {
+	i := start >> 3
+	var v uint64
+	if i+8 <= len(b.data) {
+		v = binary.LittleEndian.Uint64(b.data[i:])
+	} else {
+		for k := len(b.data) - 1; k >= i; k-- {
+			v = v<<8 | uint64(b.data[k])
+		}
+	}
+	return v >> (start & 7) & (1<<n - 1)
+}
+
+func (b *zstdBackwardBits) read(n uint8) uint64 {
+	v := b.peek(n)
+	b.pos -= int(n)
+	return v
+}
+
+// zstdBitWriter writes a bitstream read back by zstdBackwardBits
+type zstdBitWriter struct {
+	out []byte
+	acc uint64
+	n   uint8
+}
+
+func (w *zstdBitWriter) add(v uint64, n uint8) {
+	w.acc |= (v & (1<<n - 1)) << w.n
+	w.n += n
+	for w.n >= 8 {
+		w.out = append(w.out, byte(w.acc))
+		w.acc >>= 8
+		w.n -= 8
+	}
+}
+
+// close writes the end mark and the last partial byte
+func (w *zstdBitWriter) close() []byte {
+	w.add(1, 1)
+	if w.n > 0 {
+		w.out = append(w.out, byte(w.acc))
+	}
+	return w.out
+}
+
+// zstdStates returns the number of states of a normalized probability: -1 ("less
+// than 1") takes one
+func zstdStates(p int16) int {
+	if p == -1 {
+		return 1
+	}
+	return int(p)
+}
+
+// zstdSpread lays the symbols of a normalized distribution out over the states of
+// its FSE table. Symbols of probability -1 ("less than 1") take the last states.
+func zstdSpread(probs []int16, log uint8) ([]uint8, error) {
+	size := 1 << log
+	total := 0
+	for _, p := range probs {
+		total += zstdStates(p)
+	}
+	if total != size || len(probs) > 256 {
+		return nil, errZstdCorrupt
+	}
+	symbols := make([]uint8, size)
+	high := size - 1
+	for s, p := range probs {
+		if p == -1 {
+			symbols[high] = uint8(s)
+			high--
+		}
+	}
+	pos, step, mask := 0, size>>1+size>>3+3, size-1
+	for s, p := range probs {
+		for range max(int(p), 0) {
+			symbols[pos] = uint8(s)
+			for pos = (pos + step) & mask; pos > high; pos = (pos + step) & mask {
+			}
+		}
+	}
+	if pos != 0 {
+		return nil, errZstdCorrupt
+	}
+	return symbols, nil
+}
+
+// zstdFSETable is an FSE decoding table: the symbol of every state and how to find
+// the next state from the bits read after it
+type zstdFSETable struct {
+	log    uint8
+	states []zstdFSEState
+}
+
+type zstdFSEState struct {
+	symbol uint8
+	nbBits uint8
+	base   uint16
+}
+
+func newZstdFSETable(probs []int16, log uint8) (*zstdFSETable, error) {
+	symbols, err := zstdSpread(probs, log)
+	if err != nil {
+		return nil, err
+	}
+	next := make([]uint16, len(probs))
+	for s, p := range probs {
+		next[s] = uint16(max(p, 1))
+	}
+	t := &zstdFSETable{log: log, states: make([]zstdFSEState, len(symbols))}
+	for u, s := range symbols {
+		n := next[s]
+		next[s]++
+		nb := log + 1 - uint8(bits.Len16(n))
+		t.states[u] = zstdFSEState{symbol: s, nbBits: nb, base: n<<nb - uint16(len(symbols))}
+	}
+	return t, nil
+}
+
+func mustZstdFSETable(probs []int16, log uint8) *zstdFSETable {
+	t, err := newZstdFSETable(probs, log)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// zstdRLETable is the table of a single symbol, read without bits
+func zstdRLETable(symbol uint8) *zstdFSETable {
+	return &zstdFSETable{states: []zstdFSEState{{symbol: symbol}}}
+}
+
+// readZstdFSEDistribution reads an FSE table description, returning the table and
+// the bytes it took
+func readZstdFSEDistribution(data []byte, maxSymbol int, maxLog uint8) (*zstdFSETable, int, error) {
+	if len(data) == 0 {
+		return nil, 0, errZstdCorrupt
+	}
+	log := data[0]&15 + 5
+	if log > maxLog {
+		return nil, 0, errZstdCorrupt
+	}
+	b := zstdForwardBits{data: data, pos: 4}
+	remaining := 1<<log + 1
+	threshold := 1 << log
+	nbBits := log + 1
+	var probs []int16
+	zero := false
+	for remaining > 1 {
+		if zero {
+			for {
+				repeat := b.peek(2)
+				b.pos += 2
+				for range repeat {
+					probs = append(probs, 0)
+				}
+				if repeat != 3 {
+					break
+				}
+			}
+		}
+		if len(probs) > maxSymbol || b.pos > len(data)*8 {
+			return nil, 0, errZstdCorrupt
+		}
+		limit := 2*threshold - 1 - remaining
+		count := int(b.peek(nbBits - 1))
+		if count < limit {
+			b.pos += int(nbBits) - 1
+		} else {
+			count = int(b.peek(nbBits))
+			if count >= threshold {
+				count -= limit
+			}
+			b.pos += int(nbBits)
+		}
+		count--
+		remaining -= max(count, -count)
+		probs = append(probs, int16(count))
+		zero = count == 0
+		if remaining < 1 {
+			return nil, 0, errZstdCorrupt
+		}
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	if b.pos > len(data)*8 || len(probs) > maxSymbol+1 {
+		return nil, 0, errZstdCorrupt
+	}
+	table, err := newZstdFSETable(probs, log)
+	return table, (b.pos + 7) / 8, err
+}
+
+// zstdFSEEncoder encodes symbols with the FSE table of a distribution
+type zstdFSEEncoder struct {
+	log    uint8
+	states []uint16 // Next state by symbol slot
+	deltas []struct {
+		nbBits    uint32 // Number of bits of a transition, in 16.16 fixed point
+		findState int32
+	}
+}
+
+func newZstdFSEEncoder(probs []int16, log uint8) *zstdFSEEncoder {
+	symbols, err := zstdSpread(probs, log)
+	if err != nil {
+		panic(err)
+	}
+	size := 1 << log
+	e := &zstdFSEEncoder{log: log, states: make([]uint16, size)}
+	cumul := make([]int, len(probs)+1)
+	for s, p := range probs {
+		cumul[s+1] = cumul[s] + zstdStates(p)
+	}
+	next := append([]int(nil), cumul...)
+	for u, s := range symbols {
+		e.states[next[s]] = uint16(size + u)
+		next[s]++
+	}
+	e.deltas = make([]struct {
+		nbBits    uint32
+		findState int32
+	}, len(probs))
+	for s, p := range probs {
+		d := &e.deltas[s]
+		switch {
+		case p == 0:
+			d.nbBits = uint32(log+1)<<16 - uint32(size)
+		case p == -1 || p == 1:
+			d.nbBits = uint32(log)<<16 - uint32(size)
+			d.findState = int32(cumul[s] - 1)
+		default:
+			maxBits := uint32(log) - uint32(bits.Len16(uint16(p-1))-1)
+			d.nbBits = maxBits<<16 - uint32(p)<<maxBits
+			d.findState = int32(cumul[s] - int(p))
+		}
+	}
+	return e
+}
+
+// init returns the state encoding symbol, without writing bits
+func (e *zstdFSEEncoder) init(symbol uint8) uint32 {
+	d := e.deltas[symbol]
+	nbBits := (d.nbBits + 1<<15) >> 16
+	value := nbBits<<16 - d.nbBits
+	return uint32(e.states[int32(value>>nbBits)+d.findState])
+}
+
+// encode writes the transition from state to the state encoding symbol
+func (e *zstdFSEEncoder) encode(w *zstdBitWriter, state uint32, symbol uint8) uint32 {
+	d := e.deltas[symbol]
+	nbBits := (state + d.nbBits) >> 16
+	w.add(uint64(state), uint8(nbBits))
+	return uint32(e.states[int32(state>>nbBits)+d.findState])
+}
+
+// flush writes the final state
+func (e *zstdFSEEncoder) flush(w *zstdBitWriter, state uint32) {
+	w.add(uint64(state), e.log)
+}
+
+// zstdHuffman is a Huffman decoding table indexed by the next maxBits bits
+type zstdHuffman struct {
+	maxBits uint8
+	table   []struct{ symbol, nbBits uint8 }
+}
+
+// newZstdHuffman builds the table of the symbol weights, the last symbol's weight
+// being implied
+func newZstdHuffman(weights []uint8) (*zstdHuffman, error) {
+	var count [zstdMaxHuffmanBits + 2]int
+	total := 0
+	for _, w := range weights {
+		if w > zstdMaxHuffmanBits {
+			return nil, errZstdCorrupt
+		}
+		count[w]++
+		if w > 0 {
+			total += 1 << (w - 1)
+		}
+	}
+	if total == 0 || len(weights) > 255 {
+		return nil, errZstdCorrupt
+	}
+	maxBits := uint8(bits.Len(uint(total)))
+	rest := 1<<maxBits - total
+	if maxBits > zstdMaxHuffmanBits || rest&(rest-1) != 0 {
+		return nil, errZstdCorrupt
+	}
+	last := uint8(bits.Len(uint(rest)))
+	weights = append(weights, last)
+	count[last]++
+
+	h := &zstdHuffman{maxBits: maxBits, table: make([]struct{ symbol, nbBits uint8 }, 1<<maxBits)}
+	var start [zstdMaxHuffmanBits + 2]int
+	for w, next := 1, 0; w <= int(maxBits); w++ {
+		start[w] = next
+		next += count[w] << (w - 1)
+	}
+	for s, w := range weights {
+		if w == 0 {
+			continue
+		}
+		for i := range 1 << (w - 1) {
+			h.table[start[w]+i] = struct{ symbol, nbBits uint8 }{uint8(s), maxBits + 1 - w}
+		}
+		start[w] += 1 << (w - 1)
+	}
+	return h, nil
+}
+
+// readZstdHuffman reads a Huffman tree description, returning the table and the
+// bytes it took
+func readZstdHuffman(data []byte) (*zstdHuffman, int, error) {
+	if len(data) == 0 {
+		return nil, 0, errZstdCorrupt
+	}
+	header := int(data[0])
+	var weights []uint8
+	if header >= 128 {
+		n := header - 127
+		if 1+(n+1)/2 > len(data) {
+			return nil, 0, errZstdCorrupt
+		}
+		for i := range n {
+			w := data[1+i/2]
+			if i%2 == 0 {
+				w >>= 4
+			}
+			weights = append(weights, w&15)
+		}
+		h, err := newZstdHuffman(weights)
+		return h, 1 + (n+1)/2, err
+	}
+
+	// Weights compressed with FSE, decoded by two interleaved states
+	if 1+header > len(data) {
+		return nil, 0, errZstdCorrupt
+	}
+	table, n, err := readZstdFSEDistribution(data[1:1+header], 255, 6)
+	if err != nil {
+		return nil, 0, err
+	}
+	b, err := newZstdBackwardBits(data[1+n : 1+header])
+	if err != nil {
+		return nil, 0, err
+	}
+	state := [2]uint64{b.read(table.log), b.read(table.log)}
+	for i := 0; ; i ^= 1 {
+		e := table.states[state[i]]
+		weights = append(weights, e.symbol)
+		state[i] = uint64(e.base) + b.read(e.nbBits)
+		if b.pos < 0 {
+			weights = append(weights, table.states[state[i^1]].symbol)
+			break
+		}
+		if len(weights) > 255 {
+			return nil, 0, errZstdCorrupt
+		}
+	}
+	h, err := newZstdHuffman(weights)
+	return h, 1 + header, err
+}
+
+// decode appends the n symbols of a Huffman-coded stream to dst
+func (h *zstdHuffman) decode(dst, stream []byte, n int) ([]byte, error) {
+	b, err := newZstdBackwardBits(stream)
+	if err != nil {
+		return nil, err
+	}
+	for range n {
+		e := h.table[b.peek(h.maxBits)]
+		dst = append(dst, e.symbol)
+		b.pos -= int(e.nbBits)
+	}
+	if b.pos != 0 {
+		return nil, errZstdCorrupt
+	}
+	return dst, nil
+}
+
+// zstdReader decompresses a zstd stream
+type zstdReader struct {
+	r   *bufio.Reader
+	err error
+
+	// The decoded data of the frame: the history matches may refer to, followed by
+	// the output not read yet from buf[read:]
+	buf        []byte
+	read       int
+	frameStart int // Matches cannot reach before the frame in buf
+	window     int
+
+	inFrame  bool
+	checksum bool
+	hash     zstdXXH64
+	huffman  *zstdHuffman
+	tables   [3]*zstdFSETable // Literal length, offset and match length tables, for repeat mode
+	rep      [3]int
+	block    []byte
+	literals []byte
+}
+
+func newZstdReader(r io.Reader) *zstdReader {
+	return &zstdReader{r: bufio.NewReaderSize(r, 1<<16)}
+}
+
+func (z *zstdReader) Read(p []byte) (int, error) {
+	for z.read == len(z.buf) {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.err = z.next()
+	}
+	n := copy(p, z.buf[z.read:])
+	z.read += n
+	return n, nil
+}
+
+// next reads the next frame header or block
+func (z *zstdReader) next() error {
+	if !z.inFrame {
+		return z.readFrameHeader()
+	}
+	// Only the window is needed once everything was read
+	if len(z.buf) > 2*z.window+zstdMaxBlockSize {
+		drop := len(z.buf) - z.window
+		z.buf = z.buf[:copy(z.buf, z.buf[drop:])]
+		z.read -= drop
+		z.frameStart = max(z.frameStart-drop, 0)
+	}
+
+	var header [3]byte
+	if _, err := io.ReadFull(z.r, header[:]); err != nil {
+		return zstdTruncated(err)
+	}
+	h := uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16
+	last, kind, size := h&1 == 1, h>>1&3, int(h>>3)
+	if size > zstdMaxBlockSize {
+		return errZstdCorrupt
+	}
+	start := len(z.buf)
+	switch kind {
+	case 0: // Raw
+		z.buf = append(z.buf, make([]byte, size)...)
+		if _, err := io.ReadFull(z.r, z.buf[start:]); err != nil {
+			return zstdTruncated(err)
+		}
+	case 1: // RLE: one byte repeated size times
+		b, err := z.r.ReadByte()
+		if err != nil {
+			return zstdTruncated(err)
+		}
+		for range size {
+			z.buf = append(z.buf, b)
+		}
+	case 2:
+		z.block = append(z.block[:0], make([]byte, size)...)
+		if _, err := io.ReadFull(z.r, z.block); err != nil {
+			return zstdTruncated(err)
+		}
+		if err := z.decodeBlock(z.block); err != nil {
+			return err
+		}
+		if len(z.buf)-start > zstdMaxBlockSize {
+			return errZstdCorrupt
+		}
+	default:
+		return errZstdCorrupt
+	}
+	if z.checksum {
+		z.hash.Write(z.buf[start:])
+	}
+
+	if last {
+		z.inFrame = false
+		if z.checksum {
+			var sum [4]byte
+			if _, err := io.ReadFull(z.r, sum[:]); err != nil {
+				return zstdTruncated(err)
+			}
+			if binary.LittleEndian.Uint32(sum[:]) != uint32(z.hash.Sum64()) {
+				return fmt.Errorf("zstd checksum mismatch")
+			}
+		}
+	}
+	return nil
+}
+
+func zstdTruncated(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readFrameHeader starts the next frame, skipping skippable frames, or returns
+// io.EOF at the end of the stream
+func (z *zstdReader) readFrameHeader() error {
+	var magic [4]byte
+	if _, err := io.ReadFull(z.r, magic[:]); err != nil {
+		if err == io.EOF {
+			return io.EOF
+		}
+		return zstdTruncated(err)
+	}
+	m := binary.LittleEndian.Uint32(magic[:])
+	if m&zstdSkippableMask == zstdSkippable {
+		if _, err := io.ReadFull(z.r, magic[:]); err != nil {
+			return zstdTruncated(err)
+		}
+		_, err := z.r.Discard(int(binary.LittleEndian.Uint32(magic[:])))
+		return zstdTruncated(err)
+	}
+	if m != zstdMagic {
+		return fmt.Errorf("not zstd data")
+	}
+
+	descriptor, err := z.r.ReadByte()
+	if err != nil {
+		return zstdTruncated(err)
+	}
+	single := descriptor>>5&1 == 1
+	if descriptor>>3&1 == 1 {
+		return errZstdCorrupt
+	}
+	window := uint64(0)
+	if !single {
+		w, err := z.r.ReadByte()
+		if err != nil {
+			return zstdTruncated(err)
+		}
+		base := uint64(1) << (10 + w>>3)
+		window = base + base/8*uint64(w&7)
+	}
+	var field [8]byte
+	dictSize := [4]int{0, 1, 2, 4}[descriptor&3]
+	if _, err := io.ReadFull(z.r, field[:dictSize]); err != nil {
+		return zstdTruncated(err)
+	}
+	if binary.LittleEndian.Uint32(field[:4]) != 0 {
+		return fmt.Errorf("zstd frames compressed with a dictionary are not supported")
+	}
+	sizeBytes := [4]int{0, 2, 4, 8}[descriptor>>6]
+	if sizeBytes == 0 && single {
+		sizeBytes = 1
+	}
+	field = [8]byte{}
+	if _, err := io.ReadFull(z.r, field[:sizeBytes]); err != nil {
+		return zstdTruncated(err)
+	}
+	if single {
+		window = binary.LittleEndian.Uint64(field[:])
+		if sizeBytes == 2 {
+			window += 256
+		}
+	}
+	if window > zstdMaxWindow {
+		return fmt.Errorf("zstd window of %d MB is too large", window>>20)
+	}
+
+	z.buf = z.buf[:copy(z.buf, z.buf[z.read:])]
+	z.read, z.frameStart = 0, len(z.buf)
+	z.window = max(int(window), 1<<10)
+	z.inFrame = true
+	z.checksum = descriptor>>2&1 == 1
+	z.hash.reset()
+	z.huffman = nil
+	z.tables = [3]*zstdFSETable{}
+	z.rep = [3]int{1, 4, 8}
+	return nil
+}
+
+// decodeBlock appends the content of a compressed block to buf
+func (z *zstdReader) decodeBlock(block []byte) error {
+	n, err := z.decodeLiterals(block)
+	if err != nil {
+		return err
+	}
+	block = block[n:]
+
+	// Sequences section header
+	if len(block) == 0 {
+		return errZstdCorrupt
+	}
+	count := int(block[0])
+	switch {
+	case count == 0:
+		z.buf = append(z.buf, z.literals...)
+		return nil
+	case count < 128:
+		block = block[1:]
+	case count < 255:
+		if len(block) < 2 {
+			return errZstdCorrupt
+		}
+		count = (count-128)<<8 + int(block[1])
+		block = block[2:]
+	default:
+		if len(block) < 3 {
+			return errZstdCorrupt
+		}
+		count = int(block[1]) + int(block[2])<<8 + 0x7F00
+		block = block[3:]
+	}
+	if len(block) == 0 {
+		return errZstdCorrupt
+	}
+	modes := block[0]
+	block = block[1:]
+	for i, t := range []struct {
+		predefined *zstdFSETable
+		maxSymbol  int
+		maxLog     uint8
+	}{{zstdLLDecode, 35, 9}, {zstdOFDecode, 31, 8}, {zstdMLDecode, 52, 9}} {
+		switch modes >> (6 - 2*i) & 3 {
+		case 0:
+			z.tables[i] = t.predefined
+		case 1:
+			if len(block) == 0 || int(block[0]) > t.maxSymbol {
+				return errZstdCorrupt
+			}
+			z.tables[i] = zstdRLETable(block[0])
+			block = block[1:]
+		case 2:
+			table, n, err := readZstdFSEDistribution(block, t.maxSymbol, t.maxLog)
+			if err != nil {
+				return err
+			}
+			z.tables[i] = table
+			block = block[n:]
+		case 3:
+			if z.tables[i] == nil {
+				return errZstdCorrupt
+			}
+		}
+	}
+	ll, of, ml := z.tables[0], z.tables[1], z.tables[2]
+
+	b, err := newZstdBackwardBits(block)
+	if err != nil {
+		return err
+	}
+	llState, ofState, mlState := b.read(ll.log), b.read(of.log), b.read(ml.log)
+	literals := z.literals
+	for i := range count {
+		lle, ofe, mle := ll.states[llState], of.states[ofState], ml.states[mlState]
+		offsetValue := uint32(1)<<ofe.symbol + uint32(b.read(ofe.symbol))
+		matchLen := int(zstdMLBase[mle.symbol]) + int(b.read(zstdMLBits[mle.symbol]))
+		litLen := int(zstdLLBase[lle.symbol]) + int(b.read(zstdLLBits[lle.symbol]))
+		if i < count-1 {
+			llState = uint64(lle.base) + b.read(lle.nbBits)
+			mlState = uint64(mle.base) + b.read(mle.nbBits)
+			ofState = uint64(ofe.base) + b.read(ofe.nbBits)
+		}
+		if b.pos < 0 || litLen > len(literals) {
+			return errZstdCorrupt
+		}
+		z.buf = append(z.buf, literals[:litLen]...)
+		literals = literals[litLen:]
+
+		offset := zstdOffset(&z.rep, offsetValue, litLen)
+		if offset <= 0 || offset > len(z.buf)-z.frameStart {
+			return errZstdCorrupt
+		}
+		for start := len(z.buf) - offset; matchLen > 0; {
+			n := min(matchLen, len(z.buf)-start)
+			z.buf = append(z.buf, z.buf[start:start+n]...)
+			matchLen -= n
+		}
+	}
+	if b.pos != 0 {
+		return errZstdCorrupt
+	}
+	z.buf = append(z.buf, literals...)
+	return nil
+}
+
+// decodeLiterals decodes the literals section of a block into z.literals,
+// returning its size
+func (z *zstdReader) decodeLiterals(block []byte) (int, error) {
+	if len(block) == 0 {
+		return 0, errZstdCorrupt
+	}
+	kind, format := block[0]&3, block[0]>>2&3
+	z.literals = z.literals[:0]
+
+	if kind < 2 { // Raw or RLE
+		var size, header int
+		switch format {
+		case 0, 2:
+			size, header = int(block[0]>>3), 1
+		case 1:
+			if len(block) < 2 {
+				return 0, errZstdCorrupt
+			}
+			size, header = int(block[0]>>4)+int(block[1])<<4, 2
+		case 3:
+			if len(block) < 3 {
+				return 0, errZstdCorrupt
+			}
+			size, header = int(block[0]>>4)+int(block[1])<<4+int(block[2])<<12, 3
+		}
+		if size > zstdMaxBlockSize {
+			return 0, errZstdCorrupt
+		}
+		if kind == 0 {
+			if header+size > len(block) {
+				return 0, errZstdCorrupt
+			}
+			z.literals = append(z.literals, block[header:header+size]...)
+			return header + size, nil
+		}
+		if header >= len(block) {
+			return 0, errZstdCorrupt
+		}
+		for range size {
+			z.literals = append(z.literals, block[header])
+		}
+		return header + 1, nil
+	}
+
+	// Huffman-coded, with a new table (compressed) or the previous one (treeless)
+	header, sizeBits, streams := [4]int{3, 3, 4, 5}[format], [4]uint{10, 10, 14, 18}[format], 4
+	if format == 0 {
+		streams = 1
+	}
+	if len(block) < header {
+		return 0, errZstdCorrupt
+	}
+	var h uint64
+	for i := header - 1; i >= 0; i-- {
+		h = h<<8 | uint64(block[i])
+	}
+	regenerated := int(h >> 4 & (1<<sizeBits - 1))
+	compressed := int(h >> (4 + sizeBits) & (1<<sizeBits - 1))
+	if regenerated > zstdMaxBlockSize || header+compressed > len(block) {
+		return 0, errZstdCorrupt
+	}
+	data := block[header : header+compressed]
+	if kind == 2 {
+		huffman, n, err := readZstdHuffman(data)
+		if err != nil {
+			return 0, err
+		}
+		z.huffman = huffman
+		data = data[n:]
+	} else if z.huffman == nil {
+		return 0, errZstdCorrupt
+	}
+
+	if streams == 1 {
+		literals, err := z.huffman.decode(z.literals, data, regenerated)
+		if err != nil {
+			return 0, err
+		}
+		z.literals = literals
+		return header + compressed, nil
+	}
+	if len(data) < 6 {
+		return 0, errZstdCorrupt
+	}
+	sizes := [4]int{int(binary.LittleEndian.Uint16(data)), int(binary.LittleEndian.Uint16(data[2:])),
+		int(binary.LittleEndian.Uint16(data[4:]))}
+	sizes[3] = len(data) - 6 - sizes[0] - sizes[1] - sizes[2]
+	if sizes[3] < 0 {
+		return 0, errZstdCorrupt
+	}
+	segment := (regenerated + 3) / 4
+	if 3*segment > regenerated {
+		return 0, errZstdCorrupt
+	}
+	data = data[6:]
+	for i, size := range sizes {
+		n := segment
+		if i == 3 {
+			n = regenerated - 3*segment
+		}
+		literals, err := z.huffman.decode(z.literals, data[:size], n)
+		if err != nil {
+			return 0, err
+		}
+		z.literals = literals
+		data = data[size:]
+	}
+	return header + compressed, nil
+}
+
+// zstdSequence is a run of literals followed by a match
+type zstdSequence struct {
+	litLen, matchLen uint32
+	offsetValue      uint32
+}
+
+// zstdWriter compresses to a single zstd frame
+type zstdWriter struct {
+	w   io.Writer
+	err error
+
+	hist       []byte  // The window followed by the block being filled
+	blockStart int     // Start of the block in hist
+	table      []int32 // Last position in hist+1 of each 4-byte hash, 0 if none
+	chain      []int32 // Previous position+1 with the same hash, by position modulo its size
+	rep        [3]int
+	restoreRep [3]int // Repeat offsets before the block, restored when it is stored
+	hash       zstdXXH64
+	started    bool
+
+	sequences []zstdSequence
+	codes     []uint8 // Literal length, offset and match length code of each sequence
+	literals  []byte
+	out       []byte
+}
+
+func newZstdWriter(w io.Writer) *zstdWriter {
+	z := &zstdWriter{w: w, table: make([]int32, 1<<zstdHashLog), chain: make([]int32, 1<<zstdChainLog),
+		rep: [3]int{1, 4, 8}}
+	z.hash.reset()
+	return z
+}
+
+func (z *zstdWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 && z.err == nil {
+		n := min(len(p), zstdMaxBlockSize-(len(z.hist)-z.blockStart))
+		z.hist = append(z.hist, p[:n]...)
+		p = p[n:]
+		written += n
+		if len(z.hist)-z.blockStart == zstdMaxBlockSize {
+			z.err = z.writeBlock(false)
+		}
+	}
+	return written, z.err
+}
+
+// Close writes the last block and the checksum. It does not close the underlying
+// writer.
+func (z *zstdWriter) Close() error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.err = z.writeBlock(true); z.err != nil {
+		return z.err
+	}
+	var sum [4]byte
+	binary.LittleEndian.PutUint32(sum[:], uint32(z.hash.Sum64()))
+	if _, err := z.w.Write(sum[:]); err != nil {
+		z.err = err
+		return err
+	}
+	z.err = errors.New("zstd writer is closed")
+	return nil
+}
+
+// writeBlock compresses the pending block, or stores it when that does not pay
+func (z *zstdWriter) writeBlock(last bool) error {
+	z.out = z.out[:0]
+	if !z.started {
+		// Frame header: content checksum, window descriptor, no size or dictionary
+		z.out = binary.LittleEndian.AppendUint32(z.out, zstdMagic)
+		z.out = append(z.out, 1<<2, (zstdWriterWindowLog-10)<<3)
+		z.started = true
+	}
+	src := z.hist[z.blockStart:]
+	z.hash.Write(src)
+
+	headerAt := len(z.out)
+	z.out = append(z.out, 0, 0, 0)
+	kind := 0
+	if len(src) > 0 {
+		z.findSequences()
+		z.out = appendZstdLiterals(z.out, z.literals)
+		z.out = z.appendSequences(z.out)
+		if len(z.out)-headerAt-3 < len(src) {
+			kind = 2
+		} else {
+			// Stored: the matches found are discarded, so are the repeat offsets
+			z.out = append(z.out[:headerAt+3], src...)
+			z.rep = z.restoreRep
+		}
+	}
+	h := uint32(len(z.out)-headerAt-3)<<3 | uint32(kind)<<1
+	if last {
+		h |= 1
+	}
+	z.out[headerAt], z.out[headerAt+1], z.out[headerAt+2] = byte(h), byte(h>>8), byte(h>>16)
+	if _, err := z.w.Write(z.out); err != nil {
+		return err
+	}
+
+	// Keep the window of history, sliding the hash tables with it. Dropping a
+	// multiple of the chain size keeps positions in their chain slots.
+	z.blockStart = len(z.hist)
+	if len(z.hist) >= 2*zstdWriterWindow {
+		drop := (len(z.hist) - zstdWriterWindow) &^ (1<<zstdChainLog - 1)
+		z.hist = z.hist[:copy(z.hist, z.hist[drop:])]
+		z.blockStart -= drop
+		for _, table := range [][]int32{z.table, z.chain} {
+			for i, pos := range table {
+				table[i] = max(pos-int32(drop), 0)
+			}
+		}
+	}
+	return nil
+}
+
+func zstdHash(v uint32) uint32 {
+	return v * 2654435761 >> (32 - zstdHashLog)
+}
+
+// findSequences splits the pending block into literals and matches against the
+// window, with lazy matching: a match is given up for a longer one at the next byte
+func (z *zstdWriter) findSequences() {
+	hist := z.hist
+	z.sequences, z.literals = z.sequences[:0], z.literals[:0]
+	z.restoreRep = z.rep
+	litStart, end := z.blockStart, len(hist)
+	for pos := z.blockStart; pos+zstdMinMatch <= end; {
+		n, offset := z.longestMatch(pos)
+		z.insert(pos)
+		if n == 0 {
+			pos += 1 + (pos-litStart)>>6
+			continue
+		}
+		if pos+1+zstdMinMatch <= end {
+			if n2, offset2 := z.longestMatch(pos + 1); n2 > n+1 {
+				pos, n, offset = pos+1, n2, offset2
+				z.insert(pos)
+			}
+		}
+		for pos > litStart && pos > offset && hist[pos-1] == hist[pos-offset-1] {
+			pos--
+			n++
+		}
+
+		litLen := pos - litStart
+		value := uint32(offset + 3)
+		if litLen > 0 && offset == z.rep[0] {
+			value = 1
+		}
+		zstdOffset(&z.rep, value, litLen)
+		z.sequences = append(z.sequences, zstdSequence{uint32(litLen), uint32(n), value})
+		z.literals = append(z.literals, hist[litStart:pos]...)
+		for p := pos + 1; p < pos+n && p+zstdMinMatch <= end; p++ {
+			z.insert(p)
+		}
+		pos += n
+		litStart = pos
+	}
+	z.literals = append(z.literals, hist[litStart:]...)
+}
+
+// insert records position pos of the history under its hash
+func (z *zstdWriter) insert(pos int) {
+	h := zstdHash(binary.LittleEndian.Uint32(z.hist[pos:]))
+	if int(z.table[h]) != pos+1 {
+		z.chain[pos&(1<<zstdChainLog-1)] = z.table[h]
+		z.table[h] = int32(pos + 1)
+	}
+}
+
+// longestMatch returns the length and offset of the longest match at pos among
+// the last repeat offset and the latest positions sharing its hash, 0 if none
+func (z *zstdWriter) longestMatch(pos int) (int, int) {
+	hist, end := z.hist, len(z.hist)
+	v := binary.LittleEndian.Uint32(hist[pos:])
+	bestLen, bestOffset := 0, 0
+	try := func(candidate int) {
+		if binary.LittleEndian.Uint32(hist[candidate:]) != v {
+			return
+		}
+		n := zstdMinMatch
+		for pos+n < end && hist[candidate+n] == hist[pos+n] {
+			n++
+		}
+		if n > bestLen {
+			bestLen, bestOffset = n, pos-candidate
+		}
+	}
+	if rep := z.rep[0]; pos >= rep {
+		try(pos - rep)
+	}
+	candidate := int(z.table[zstdHash(v)]) - 1
+	for depth := 0; candidate >= 0 && depth < zstdChainDepth && pos-candidate <= zstdWriterWindow; depth++ {
+		try(candidate)
+		if pos-candidate >= 1<<zstdChainLog {
+			break // Its chain entry was overwritten
+		}
+		candidate = int(z.chain[candidate&(1<<zstdChainLog-1)]) - 1
+	}
+	return bestLen, bestOffset
+}
+
+// appendZstdLiterals appends the literals section, Huffman-coded when that is smaller
+func appendZstdLiterals(out, literals []byte) []byte {
+	raw := func(out []byte) []byte {
+		n := len(literals)
+		switch {
+		case n < 32:
+			out = append(out, byte(n<<3))
+		case n < 4096:
+			out = append(out, byte(n&15)<<4|1<<2, byte(n>>4))
+		default:
+			out = append(out, byte(n&15)<<4|3<<2, byte(n>>4), byte(n>>12))
+		}
+		return append(out, literals...)
+	}
+	if len(literals) < 64 {
+		return raw(out)
+	}
+
+	var freq [256]int
+	for _, b := range literals {
+		freq[b]++
+	}
+	lengths, ok := zstdHuffmanLengths(freq)
+	if !ok {
+		return raw(out)
+	}
+
+	// Weights of every symbol but the last, 4 bits each, and the canonical codes
+	maxBits, lastSymbol := uint8(0), 0
+	for s, l := range lengths {
+		if l > 0 {
+			maxBits, lastSymbol = max(maxBits, l), s
+		}
+	}
+	var weights [256]uint8
+	var count [zstdMaxHuffmanBits + 2]int
+	for s, l := range lengths {
+		if l > 0 {
+			weights[s] = maxBits + 1 - l
+			count[weights[s]]++
+		}
+	}
+	var start [zstdMaxHuffmanBits + 2]int
+	for w, next := 1, 0; w <= int(maxBits); w++ {
+		start[w] = next
+		next += count[w] << (w - 1)
+	}
+	var codes [256]uint16
+	for s, w := range weights {
+		if w > 0 {
+			codes[s] = uint16(start[w] >> (w - 1))
+			start[w] += 1 << (w - 1)
+		}
+	}
+	tree := []byte{byte(127 + lastSymbol)}
+	for i := 0; i < lastSymbol; i += 2 {
+		tree = append(tree, weights[i]<<4|weights[i+1]*uint8(min(lastSymbol-i-1, 1)))
+	}
+
+	encode := func(dst, src []byte) []byte {
+		w := zstdBitWriter{out: dst}
+		for i := len(src) - 1; i >= 0; i-- {
+			w.add(uint64(codes[src[i]]), lengths[src[i]])
+		}
+		return w.close()
+	}
+	body := tree
+	streams := 1
+	if len(literals) <= 1023 {
+		body = encode(body, literals)
+	} else {
+		streams = 4
+		segment := (len(literals) + 3) / 4
+		jump := len(body)
+		body = append(body, make([]byte, 6)...)
+		for i := range 4 {
+			streamStart := len(body)
+			body = encode(body, literals[i*segment:min((i+1)*segment, len(literals))])
+			if i < 3 {
+				binary.LittleEndian.PutUint16(body[jump+2*i:], uint16(len(body)-streamStart))
+			}
+		}
+	}
+
+	n, c := len(literals), len(body)
+	var format, header int
+	var sizeBits uint
+	switch {
+	case streams == 1 && c <= 1023:
+		format, header, sizeBits = 0, 3, 10
+	case streams == 1:
+		return raw(out)
+	case n <= 1023 && c <= 1023:
+		format, header, sizeBits = 1, 3, 10
+	case n <= 16383 && c <= 16383:
+		format, header, sizeBits = 2, 4, 14
+	default:
+		format, header, sizeBits = 3, 5, 18
+	}
+	if header+c >= len(literals)+3 {
+		return raw(out)
+	}
+	h := uint64(2) | uint64(format)<<2 | uint64(n)<<4 | uint64(c)<<(4+sizeBits)
+	for i := range header {
+		out = append(out, byte(h>>(8*i)))
+	}
+	return append(out, body...)
+}
+
+// zstdHuffmanLengths returns Huffman code lengths of at most zstdMaxHuffmanBits
+// bits for the byte frequencies, or false when they cannot be described with 4-bit
+// weights (a single symbol, or symbols above 128)
+func zstdHuffmanLengths(freq [256]int) ([256]uint8, bool) {
+	var lengths [256]uint8
+	type node struct {
+		weight      int
+		symbol      int // -1 for inner nodes
+		left, right int
+	}
+	var symbols []int
+	for s, f := range freq {
+		if f > 0 {
+			symbols = append(symbols, s)
+		}
+	}
+	if len(symbols) < 2 || symbols[len(symbols)-1] > 128 {
+		return lengths, false
+	}
+	weights := freq
+	for {
+		nodes := make([]node, 0, 2*len(symbols))
+		for _, s := range symbols {
+			nodes = append(nodes, node{weights[s], s, -1, -1})
+		}
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i].weight < nodes[j].weight })
+		// Two-queue construction: leaves sorted by weight, inner nodes created in order
+		leaf, inner := 0, len(nodes)
+		pick := func() int {
+			if leaf < len(symbols) && (inner >= len(nodes) || nodes[leaf].weight <= nodes[inner].weight) {
+				leaf++
+				return leaf - 1
+			}
+			inner++
+			return inner - 1
+		}
+		for range len(symbols) - 1 {
+			a, b := pick(), pick()
+			nodes = append(nodes, node{nodes[a].weight + nodes[b].weight, -1, a, b})
+		}
+		depth := make([]uint8, len(nodes))
+		longest := uint8(0)
+		for i := len(nodes) - 1; i >= 0; i-- {
+			if n := nodes[i]; n.symbol < 0 {
+				depth[n.left], depth[n.right] = depth[i]+1, depth[i]+1
+			} else {
+				lengths[n.symbol] = depth[i]
+				longest = max(longest, depth[i])
+			}
+		}
+		if longest <= zstdMaxHuffmanBits {
+			return lengths, true
+		}
+		// Too deep: flatten the distribution and try again
+		for _, s := range symbols {
+			weights[s] = (weights[s] + 1) / 2
+		}
+	}
+}
+
+// zstdSequenceTable is how one field of the sequences of a block is coded
+type zstdSequenceTable struct {
+	mode    byte            // 0 predefined, 1 RLE, 2 table described in the block
+	encoder *zstdFSEEncoder // nil in RLE mode, where no bits are written
+	header  []byte          // RLE symbol or table description
+}
+
+// chooseZstdTable picks the cheapest way to code the codes of a field: a single
+// repeated symbol, the predefined distribution or one normalized from the block
+func chooseZstdTable(codes []uint8, maxLog uint8, predefined []int16, predefinedLog uint8,
+	predefinedEncoder *zstdFSEEncoder) zstdSequenceTable {
+	var count [256]int
+	distinct, last := 0, 0
+	for _, c := range codes {
+		if count[c] == 0 {
+			distinct++
+		}
+		count[c]++
+		last = max(last, int(c))
+	}
+	if distinct == 1 {
+		return zstdSequenceTable{mode: 1, header: []byte{codes[0]}}
+	}
+	cost := func(probs []int16, log uint8) float64 {
+		bits := 0.0
+		for s, n := range count[:last+1] {
+			if n == 0 {
+				continue
+			}
+			if s >= len(probs) || probs[s] == 0 {
+				return math.Inf(1)
+			}
+			bits += float64(n) * (float64(log) - math.Log2(float64(zstdStates(probs[s]))))
+		}
+		return bits
+	}
+	best := zstdSequenceTable{encoder: predefinedEncoder}
+	bestCost := cost(predefined, predefinedLog)
+
+	log := uint8(min(int(maxLog), max(5, bits.Len(uint(len(codes)))-2)))
+	if probs, ok := normalizeZstdCounts(count[:last+1], len(codes), log); ok {
+		header := appendZstdFSEDistribution(nil, probs, log)
+		if c := cost(probs, log) + float64(8*len(header)); c < bestCost {
+			best = zstdSequenceTable{mode: 2, encoder: newZstdFSEEncoder(probs, log), header: header}
+		}
+	}
+	return best
+}
+
+// normalizeZstdCounts scales symbol counts to a distribution over 1<<log states,
+// every symbol present keeping at least one state
+func normalizeZstdCounts(count []int, total int, log uint8) ([]int16, bool) {
+	size := 1 << log
+	probs := make([]int16, len(count))
+	sum, largest := 0, 0
+	for s, n := range count {
+		if n == 0 {
+			continue
+		}
+		p := max(1, int(math.Round(float64(n)*float64(size)/float64(total))))
+		probs[s] = int16(p)
+		sum += p
+		if p > int(probs[largest]) {
+			largest = s
+		}
+	}
+	// The rounding error goes to the most frequent symbol, which can afford it
+	p := int(probs[largest]) + size - sum
+	if p < 1 || 2*p < int(probs[largest]) {
+		return nil, false
+	}
+	probs[largest] = int16(p)
+	return probs, true
+}
+
+// appendZstdFSEDistribution appends the description of an FSE table, read back by
+// readZstdFSEDistribution
+func appendZstdFSEDistribution(out []byte, probs []int16, log uint8) []byte {
+	var acc uint64
+	var n uint8
+	add := func(v uint64, bits uint8) {
+		acc |= v << n
+		n += bits
+		for n >= 8 {
+			out = append(out, byte(acc))
+			acc >>= 8
+			n -= 8
+		}
+	}
+	add(uint64(log-5), 4)
+	remaining := 1<<log + 1
+	threshold := 1 << log
+	nbBits := log + 1
+	zero := false
+	for s := 0; s < len(probs) && remaining > 1; {
+		if zero {
+			start := s
+			for probs[s] == 0 {
+				s++
+			}
+			for ; s-start >= 3; start += 3 {
+				add(3, 2)
+			}
+			add(uint64(s-start), 2)
+		}
+		count := int(probs[s])
+		s++
+		limit := 2*threshold - 1 - remaining
+		remaining -= max(count, -count)
+		count++
+		if count >= threshold {
+			count += limit
+		}
+		if count < limit {
+			add(uint64(count), nbBits-1)
+		} else {
+			add(uint64(count), nbBits)
+		}
+		zero = count == 1
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	if n > 0 {
+		out = append(out, byte(acc))
+	}
+	return out
+}
+
+// appendSequences appends the sequences section, each field coded with the table
+// chooseZstdTable finds cheapest
+func (z *zstdWriter) appendSequences(out []byte) []byte {
+	n := len(z.sequences)
+	switch {
+	case n < 128:
+		out = append(out, byte(n))
+	case n < 0x7F00:
+		out = append(out, byte(n>>8+128), byte(n))
+	default:
+		out = append(out, 255, byte(n-0x7F00), byte((n-0x7F00)>>8))
+	}
+	if n == 0 {
+		return out
+	}
+
+	z.codes = z.codes[:0]
+	for _, s := range z.sequences {
+		z.codes = append(z.codes, zstdLLCode(s.litLen), uint8(bits.Len32(s.offsetValue)-1), zstdMLCode(s.matchLen))
+	}
+	field := func(i int) []uint8 {
+		codes := make([]uint8, n)
+		for k := range codes {
+			codes[k] = z.codes[3*k+i]
+		}
+		return codes
+	}
+	tables := [3]zstdSequenceTable{
+		chooseZstdTable(field(0), 9, zstdLLDefault, 6, zstdLLEncode),
+		chooseZstdTable(field(1), 8, zstdOFDefault, 5, zstdOFEncode),
+		chooseZstdTable(field(2), 9, zstdMLDefault, 6, zstdMLEncode),
+	}
+	out = append(out, tables[0].mode<<6|tables[1].mode<<4|tables[2].mode<<2)
+	for _, t := range tables {
+		out = append(out, t.header...)
+	}
+
+	// Sequences are written last to first, the states of each field in the order
+	// the reader updates them reversed
+	w := zstdBitWriter{out: out}
+	var states [3]uint32
+	extra := func(k int) {
+		s := z.sequences[k]
+		ll, of, ml := z.codes[3*k], z.codes[3*k+1], z.codes[3*k+2]
+		w.add(uint64(s.litLen-zstdLLBase[ll]), zstdLLBits[ll])
+		w.add(uint64(s.matchLen-zstdMLBase[ml]), zstdMLBits[ml])
+		w.add(uint64(s.offsetValue-1<<of), of)
+	}
+	for i, t := range tables {
+		if t.encoder != nil {
+			states[i] = t.encoder.init(z.codes[3*(n-1)+i])
+		}
+	}
+	extra(n - 1)
+	for k := n - 2; k >= 0; k-- {
+		for _, i := range [3]int{1, 2, 0} {
+			if e := tables[i].encoder; e != nil {
+				states[i] = e.encode(&w, states[i], z.codes[3*k+i])
+			}
+		}
+		extra(k)
+	}
+	for _, i := range [3]int{2, 1, 0} {
+		if e := tables[i].encoder; e != nil {
+			e.flush(&w, states[i])
+		}
+	}
+	return w.close()
+}
@@ -0,0 +1,306 @@
+package synthpop
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// zstdLines returns n lines of CSV, as compressed into testdata/zstd by the zstd
+// command-line tool
+func zstdLines(n int) []byte {
+	var b bytes.Buffer
+	for i := range n {
+		answer := "yes"
+		if i%3 == 0 {
+			answer = "no"
+		}
+		fmt.Fprintf(&b, "area%05d,%d,%d,%s\n", i, i*7%13, i*i%101, answer)
+	}
+	return b.Bytes()
+}
+
+// zstdCompress compresses data with the package's writer
+func zstdCompress(t testing.TB, data []byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	w := newZstdWriter(&b)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// zstdDecompress decompresses data with the package's reader
+func zstdDecompress(data []byte) ([]byte, error) {
+	return io.ReadAll(newZstdReader(bytes.NewReader(data)))
+}
+
+// zstdBlock returns the header and content of a block of the given kind (0 raw,
+// 1 RLE, 2 compressed, 3 reserved) and decoded size
+func zstdBlock(last bool, kind, size int, content []byte) []byte {
+	h := kind<<1 | size<<3
+	if last {
+		h |= 1
+	}
+	return append([]byte{byte(h), byte(h >> 8), byte(h >> 16)}, content...)
+}
+
+// zstdSingleFrame returns a frame of one segment holding size bytes, without a
+// checksum, made of blocks
+func zstdSingleFrame(size byte, blocks ...[]byte) []byte {
+	frame := []byte{0x28, 0xb5, 0x2f, 0xfd, 0x20, size}
+	for _, block := range blocks {
+		frame = append(frame, block...)
+	}
+	return frame
+}
+
+func TestZstdRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(11))
+	random := make([]byte, 300<<10)
+	rng.Read(random)
+	// Long repeats further apart than a block, and matches at every distance
+	repeats := append(append(append([]byte(nil), random[:200<<10]...), zstdLines(2000)...), random[:200<<10]...)
+	mixed := make([]byte, 0, 1<<20)
+	for len(mixed) < 1<<20 {
+		n := 1 + rng.Intn(64)
+		if rng.Intn(3) == 0 || len(mixed) < n {
+			mixed = append(mixed, random[:n]...)
+		} else {
+			start := len(mixed) - 1 - rng.Intn(min(len(mixed), 1<<16)-1)
+			for i := range n {
+				mixed = append(mixed, mixed[start+i])
+			}
+		}
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"one byte", []byte{42}},
+		{"short text", []byte("hello, zstd\n")},
+		{"single byte repeated", bytes.Repeat([]byte{'a'}, 1000)},
+		{"lines", zstdLines(400)},
+		{"lines over many blocks", zstdLines(30000)},
+		{"incompressible", random},
+		{"long repeats", repeats},
+		{"overlapping matches", mixed},
+		{"all byte values", bytes.Repeat([]byte(func() string {
+			var b strings.Builder
+			for i := range 256 {
+				b.WriteByte(byte(i))
+			}
+			return b.String()
+		}()), 600)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compressed := zstdCompress(t, tt.data)
+			got, err := zstdDecompress(compressed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.data) {
+				t.Fatalf("decompressed %d bytes differing from the %d written", len(got), len(tt.data))
+			}
+			// The same through one-byte reads and writes
+			var b bytes.Buffer
+			w := newZstdWriter(&b)
+			if _, err := io.Copy(w, iotest.OneByteReader(bytes.NewReader(tt.data[:min(len(tt.data), 4096)]))); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			got, err = io.ReadAll(iotest.OneByteReader(newZstdReader(&b)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.data[:min(len(tt.data), 4096)]) {
+				t.Fatal("byte-wise round trip differs")
+			}
+		})
+	}
+}
+
+func TestZstdWriterClosed(t *testing.T) {
+	w := newZstdWriter(io.Discard)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("late")); err == nil {
+		t.Error("wrote to a closed writer")
+	}
+}
+
+// TestZstdDecodeVectors decodes frames written by the zstd command-line tool
+// (v1.5.6) and by hand for the block types it did not produce
+func TestZstdDecodeVectors(t *testing.T) {
+	read := func(name string) []byte {
+		data, err := os.ReadFile(filepath.Join("testdata", "zstd", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	hello, lines := read("hello.zst"), read("lines.zst")
+	tests := []struct {
+		name  string
+		input []byte
+		want  []byte
+	}{
+		{"raw literals", hello, []byte("hello, zstd\n")},
+		{"RLE literals and a repeated match", read("rle.zst"), bytes.Repeat([]byte{'a'}, 1000)},
+		{"Huffman literals and FSE tables", lines, zstdLines(400)},
+		{"no checksum", read("lines_nocheck.zst"), zstdLines(400)},
+		{"several blocks with repeated tables", read("lines_blocks.zst"), zstdLines(10000)},
+		{"concatenated frames", append(append([]byte(nil), hello...), lines...),
+			append([]byte("hello, zstd\n"), zstdLines(400)...)},
+		{"skippable frame", append([]byte{0x5a, 0x2a, 0x4d, 0x18, 3, 0, 0, 0, 'x', 'y', 'z'}, hello...),
+			[]byte("hello, zstd\n")},
+		{"raw blocks", zstdSingleFrame(6, zstdBlock(false, 0, 3, []byte("abc")), zstdBlock(true, 0, 3, []byte("def"))),
+			[]byte("abcdef")},
+		{"RLE block", zstdSingleFrame(5, zstdBlock(true, 1, 5, []byte{'z'})), []byte("zzzzz")},
+		{"empty frame", zstdSingleFrame(0, zstdBlock(true, 0, 0, nil)), []byte{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := zstdDecompress(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Fatalf("decoded %q, want %q", truncate(got), truncate(tt.want))
+			}
+		})
+	}
+}
+
+// truncate shortens data for an error message
+func truncate(data []byte) []byte {
+	if len(data) > 40 {
+		return append(data[:40:40], "..."...)
+	}
+	return data
+}
+
+func TestZstdCorrupt(t *testing.T) {
+	hello, err := os.ReadFile(filepath.Join("testdata", "zstd", "hello.zst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	badChecksum := append([]byte(nil), hello...)
+	badChecksum[len(badChecksum)-1] ^= 0xff
+
+	tests := []struct {
+		name  string
+		input []byte
+		want  string
+	}{
+		{"not zstd", []byte("plain text, not compressed"), "not zstd data"},
+		{"checksum", badChecksum, "checksum mismatch"},
+		{"reserved descriptor bit", []byte{0x28, 0xb5, 0x2f, 0xfd, 0x28, 0}, errZstdCorrupt.Error()},
+		{"dictionary", []byte{0x28, 0xb5, 0x2f, 0xfd, 0x21, 7, 0}, "dictionary"},
+		{"window too large", []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0xf8}, "too large"},
+		{"reserved block type", zstdSingleFrame(1, zstdBlock(true, 3, 1, []byte{0})), errZstdCorrupt.Error()},
+		{"block too large", zstdSingleFrame(1, zstdBlock(true, 0, zstdMaxBlockSize+1, nil)), errZstdCorrupt.Error()},
+		{"truncated header", hello[:5], io.ErrUnexpectedEOF.Error()},
+		{"truncated block", hello[:12], io.ErrUnexpectedEOF.Error()},
+		{"truncated checksum", hello[:len(hello)-2], io.ErrUnexpectedEOF.Error()},
+		{"truncated skippable frame", []byte{0x50, 0x2a, 0x4d, 0x18, 9, 0, 0, 0, 1}, "EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := zstdDecompress(tt.input)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestZstdDamaged checks that every truncation and random damage of compressed
+// frames fails with an error, or yields different data, rather than panicking
+func TestZstdDamaged(t *testing.T) {
+	data := zstdLines(3000)
+	for _, source := range []string{"writer", "zstd tool"} {
+		var compressed []byte
+		if source == "writer" {
+			compressed = zstdCompress(t, data)
+		} else {
+			var err error
+			if compressed, err = os.ReadFile(filepath.Join("testdata", "zstd", "lines_nocheck.zst")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for n := range len(compressed) {
+			if _, err := zstdDecompress(compressed[:n]); err == nil && n > 0 {
+				t.Fatalf("%s: frame truncated to %d of %d bytes decoded without error", source, n, len(compressed))
+			}
+		}
+		rng := rand.New(rand.NewSource(12))
+		for range 2000 {
+			damaged := append([]byte(nil), compressed...)
+			for range 1 + rng.Intn(3) {
+				damaged[4+rng.Intn(len(damaged)-4)] ^= byte(1 + rng.Intn(255))
+			}
+			got, err := zstdDecompress(damaged)
+			if err == nil && source == "writer" && !bytes.Equal(got, data) {
+				t.Fatalf("%s: damaged frame decoded to other data without a checksum error", source)
+			}
+		}
+	}
+}
+
+// TestZstdTool checks the writer's frames with the zstd command-line tool and the
+// reader on the tool's output, when the tool is installed
+func TestZstdTool(t *testing.T) {
+	tool, err := exec.LookPath("zstd")
+	if err != nil {
+		t.Skip("zstd command-line tool not installed")
+	}
+	data := append(zstdLines(20000), bytes.Repeat([]byte("0,1,0,0,1\n"), 50000)...)
+
+	cmd := exec.Command(tool, "-q", "-d", "-c")
+	cmd.Stdin = bytes.NewReader(zstdCompress(t, data))
+	got, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("zstd -d rejected the writer's frame: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("zstd -d decoded the writer's frame to other data")
+	}
+
+	for _, level := range []string{"-1", "-9", "-19", "--ultra", "--long"} {
+		args := []string{"-q", "-c", level}
+		if level == "--ultra" {
+			args = append(args, "-22")
+		}
+		cmd := exec.Command(tool, args...)
+		cmd.Stdin = bytes.NewReader(data)
+		compressed, err := cmd.Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := zstdDecompress(compressed)
+		if err != nil {
+			t.Fatalf("zstd %s: %v", level, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("zstd %s: decoded other data", level)
+		}
+	}
+}
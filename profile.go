@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	_ "net/http/pprof" // Registers the /debug/pprof handlers served by -pprof
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"strings"
+
+	"simulatedAnnealing/pkg/synthpop"
+)
+
+// profiling holds the global profiling flags, which like -no-emoji may appear
+// anywhere on the command line, for every subcommand
+type profiling struct {
+	pprofAddr  string // Address of the live net/http/pprof server, e.g. ":6060"
+	cpuProfile string // File the CPU profile of the whole command is written to
+	memProfile string // File a heap profile is written to when the command ends
+	traceFile  string // File the execution trace of the whole command is written to
+}
+
+// stripProfilingFlags removes the profiling flags and their values from the arguments
+//
+// Parameters:
+//   - args: The command line, program name first
+//
+// Returns:
+//   - []string: The arguments without the profiling flags
+//   - profiling: The flags found
+//   - error: A profiling flag without a value
+func stripProfilingFlags(args []string) ([]string, profiling, error) {
+	var p profiling
+	targets := map[string]*string{
+		"pprof": &p.pprofAddr, "cpuprofile": &p.cpuProfile, "memprofile": &p.memProfile, "trace": &p.traceFile,
+	}
+	kept := args[:0:0]
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		target, ok := targets[name]
+		if i == 0 || !ok || !strings.HasPrefix(args[i], "-") {
+			kept = append(kept, args[i])
+			continue
+		}
+		if !hasValue {
+			if i+1 == len(args) {
+				return nil, p, fmt.Errorf("flag %s needs a value", args[i])
+			}
+			i++
+			value = args[i]
+		}
+		*target = value
+	}
+	return kept, p, nil
+}
+
+// start starts the requested profiles
+//
+// Returns:
+//   - func(): Stops the profiles and writes the heap profile, to call when the command ends
+//   - error: A profile file or the pprof address could not be opened
+func (p profiling) start() (func(), error) {
+	var stops []func()
+	stop := func() {
+		for i := len(stops) - 1; i >= 0; i-- {
+			stops[i]()
+		}
+	}
+
+	if p.pprofAddr != "" {
+		listener, err := net.Listen("tcp", p.pprofAddr)
+		if err != nil {
+			return nil, fmt.Errorf("cannot start pprof server: %w", err)
+		}
+		go http.Serve(listener, nil)
+		synthpop.Printf("🔬 pprof server on http://%s/debug/pprof/\n", listener.Addr())
+		stops = append(stops, func() { listener.Close() })
+	}
+	if p.cpuProfile != "" {
+		file, err := os.Create(p.cpuProfile)
+		if err != nil {
+			stop()
+			return nil, fmt.Errorf("cannot create CPU profile: %w", err)
+		}
+		if err := pprof.StartCPUProfile(file); err != nil {
+			file.Close()
+			stop()
+			return nil, fmt.Errorf("cannot start CPU profile: %w", err)
+		}
+		stops = append(stops, func() {
+			pprof.StopCPUProfile()
+			file.Close()
+			synthpop.Printf("🔬 CPU profile written to %s\n", p.cpuProfile)
+		})
+	}
+	if p.traceFile != "" {
+		file, err := os.Create(p.traceFile)
+		if err != nil {
+			stop()
+			return nil, fmt.Errorf("cannot create execution trace: %w", err)
+		}
+		if err := trace.Start(file); err != nil {
+			file.Close()
+			stop()
+			return nil, fmt.Errorf("cannot start execution trace: %w", err)
+		}
+		stops = append(stops, func() {
+			trace.Stop()
+			file.Close()
+			synthpop.Printf("🔬 Execution trace written to %s\n", p.traceFile)
+		})
+	}
+	if p.memProfile != "" {
+		file, err := os.Create(p.memProfile)
+		if err != nil {
+			stop()
+			return nil, fmt.Errorf("cannot create heap profile: %w", err)
+		}
+		stops = append(stops, func() {
+			defer file.Close()
+			runtime.GC() // Up-to-date statistics of the memory still in use
+			if err := pprof.WriteHeapProfile(file); err != nil {
+				synthpop.Printf("⚠️ Cannot write heap profile: %v\n", err)
+				return
+			}
+			synthpop.Printf("🔬 Heap profile written to %s\n", p.memProfile)
+		})
+	}
+	return stop, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"simulatedAnnealing/pkg/synthpop"
+)
+
+// reportCommand implements `report [-a annealing config] [-f config]`: it recomputes
+// the validation statistics of a finished run from its outputs and regenerates the
+// configured validation, GeoJSON and Moran's I outputs
+func reportCommand(args []string) error {
+	flags := flag.NewFlagSet("report", flag.ContinueOnError)
+	configFile := flags.String("f", "config.json", "population config file of the run")
+	annealingFile := flags.String("a", "annealing_config.json", "annealing config file (for the distance)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return fmt.Errorf("usage: report [-f config] [-a annealing config]")
+	}
+
+	config, err := synthpop.LoadConfig(*configFile)
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+	annealingConfig, err := synthpop.LoadAnnealingConfig(*annealingFile)
+	if err != nil {
+		return fmt.Errorf("annealing config error: %w", err)
+	}
+	if strings.Contains(config.Validate.File, synthpop.RunNamePlaceholder) {
+		if config.RunName == "" {
+			return fmt.Errorf("the outputs are named after the run, set runName in %s", *configFile)
+		}
+		if config, err = synthpop.ApplyRunName(config); err != nil {
+			return err
+		}
+	}
+
+	report, err := synthpop.NewController().Report(context.Background(), config, annealingConfig)
+	if err != nil {
+		return err
+	}
+
+	synthpop.Printf("📋 %s: %d areas, population %g\n", config.Validate.File, report.Areas, report.Population)
+	if len(report.Missing) > 0 {
+		synthpop.Printf("⚠️ %d constraint areas have no output, e.g. %s\n", len(report.Missing), report.Missing[0])
+	}
+	synthpop.Printf("   TAE %.6g | SAE %.6g | fitness mean %.6g, max %.6g (%s)\n",
+		report.TAE, report.SAE, report.MeanFitness, report.MaxFitness, report.WorstArea)
+	synthpop.Printf("   %-24s %14s %14s  %s\n", "variable", "TAE", "max error", "worst area")
+	for _, v := range report.Variables {
+		synthpop.Printf("   %-24s %14.6g %14.6g  %s\n", v.Name, v.TAE, v.MaxError, v.WorstArea)
+	}
+	return nil
+}
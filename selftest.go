@@ -0,0 +1,416 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+
+	"simulatedAnnealing/pkg/synthpop"
+)
+
+// selfTest is one bundled miniature scenario with the behaviour it expects
+type selfTest struct {
+	name string
+	run  func(config synthpop.AnnealingConfig) error
+}
+
+// selfTests is the battery run by the selftest subcommand. The scenarios are built
+// in code so the check needs nothing but the binary on the machine being verified.
+var selfTests = []selfTest{
+	{"zero constraints are respected", selfTestZeroConstraints},
+	{"infeasible area is rejected", selfTestInfeasible},
+	{"wide table", selfTestWideTable},
+	{"single-record pool", selfTestSingleRecord},
+	{"parallel run writes all outputs", selfTestParallelRun},
+	{"configured metric drives the search", selfTestMetrics},
+	{"acceptance rules", selfTestAcceptance},
+	{"incremental fitness matches the distance", selfTestIncrementalFitness},
+}
+
+// selfTestConfig is a short, seeded annealing schedule for the scenarios
+func selfTestConfig() synthpop.AnnealingConfig {
+	seed := int64(42)
+	return synthpop.AnnealingConfig{
+		InitialTemp:      10,
+		MinTemp:          0.00001,
+		CoolingRate:      0.999,
+		ReheatFactor:     0.8,
+		FitnessThreshold: 0.0001,
+		MinImprovement:   0.0001,
+		MaxIterations:    5000,
+		WindowSize:       500,
+		Change:           2000,
+		Distance:         "EUCLIDEAN",
+		UseRandomSeed:    "yes",
+		RandomSeed:       &seed,
+	}
+}
+
+// selfTestHeader returns the variable names v0..v(n-1)
+func selfTestHeader(n int) []string {
+	header := make([]string, n)
+	for i := range header {
+		header[i] = "v" + strconv.Itoa(i)
+	}
+	return header
+}
+
+// randomMicrodata builds count records of n binary variables
+func randomMicrodata(rng *rand.Rand, count, n int) []synthpop.MicroData {
+	microData := make([]synthpop.MicroData, count)
+	for i := range microData {
+		values := make([]float64, n)
+		for j := range values {
+			values[j] = float64(rng.Intn(2))
+		}
+		microData[i] = synthpop.MicroData{ID: "r" + strconv.Itoa(i), Values: values}
+	}
+	return microData
+}
+
+// synthesizeChecked runs one area and turns a panic of the engine into an error
+func synthesizeChecked(constraint synthpop.ConstraintData, microData []synthpop.MicroData, header []string,
+	config synthpop.AnnealingConfig) (res synthpop.Result, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return synthpop.SynthesizeArea(constraint, microData, header, config, rand.New(rand.NewSource(1)))
+}
+
+// checkResult verifies that a result has the area's population size and that its
+// totals are the sums of the selected records
+func checkResult(res synthpop.Result, constraint synthpop.ConstraintData, microData []synthpop.MicroData) error {
+	if len(res.IDs) != int(constraint.Total) {
+		return fmt.Errorf("population has %d individuals, want %d", len(res.IDs), int(constraint.Total))
+	}
+	byID := make(map[string][]float64, len(microData))
+	for _, md := range microData {
+		byID[md.ID] = md.Values
+	}
+	sums := make([]float64, len(constraint.Values))
+	for _, id := range res.IDs {
+		values, ok := byID[id]
+		if !ok {
+			return fmt.Errorf("population contains unknown record %s", id)
+		}
+		for i, v := range values {
+			sums[i] += v
+		}
+	}
+	for i := range sums {
+		if sums[i] != res.Totals[i] {
+			return fmt.Errorf("total of variable %d is %v, the selected records sum to %v", i, res.Totals[i], sums[i])
+		}
+	}
+	return nil
+}
+
+func selfTestZeroConstraints(config synthpop.AnnealingConfig) error {
+	header := selfTestHeader(3)
+	microData := randomMicrodata(rand.New(rand.NewSource(1)), 50, 3)
+	constraint := synthpop.ConstraintData{ID: "zero", Values: []float64{10, 0, 8}, Total: 20}
+
+	res, err := synthesizeChecked(constraint, microData, header, config)
+	if err != nil {
+		return err
+	}
+	if err := checkResult(res, constraint, microData); err != nil {
+		return err
+	}
+	if res.Totals[1] != 0 {
+		return fmt.Errorf("variable constrained to 0 has total %v", res.Totals[1])
+	}
+	return nil
+}
+
+func selfTestInfeasible(config synthpop.AnnealingConfig) error {
+	header := selfTestHeader(2)
+	// Every record has both variables set, so none fits an area with a zero constraint
+	microData := []synthpop.MicroData{
+		{ID: "a", Values: []float64{1, 1}},
+		{ID: "b", Values: []float64{1, 1}},
+	}
+	constraint := synthpop.ConstraintData{ID: "infeasible", Values: []float64{5, 0}, Total: 5}
+
+	_, err := synthesizeChecked(constraint, microData, header, config)
+	if err == nil {
+		return fmt.Errorf("area without a valid record was synthesized")
+	}
+	if !errors.Is(err, synthpop.ErrNoValidMicrodata) {
+		return fmt.Errorf("unexpected error: %w", err)
+	}
+	return nil
+}
+
+func selfTestWideTable(config synthpop.AnnealingConfig) error {
+	const variables = 150
+	rng := rand.New(rand.NewSource(2))
+	header := selfTestHeader(variables)
+	microData := randomMicrodata(rng, 400, variables)
+
+	// Constraints taken from a real draw, so an exact fit exists
+	constraint := synthpop.ConstraintData{ID: "wide", Values: make([]float64, variables), Total: 100}
+	for i := 0; i < int(constraint.Total); i++ {
+		for j, v := range microData[rng.Intn(len(microData))].Values {
+			constraint.Values[j] += v
+		}
+	}
+
+	res, err := synthesizeChecked(constraint, microData, header, config)
+	if err != nil {
+		return err
+	}
+	return checkResult(res, constraint, microData)
+}
+
+func selfTestSingleRecord(config synthpop.AnnealingConfig) error {
+	header := selfTestHeader(3)
+	microData := []synthpop.MicroData{{ID: "only", Values: []float64{1, 0, 1}}}
+	constraint := synthpop.ConstraintData{ID: "single", Values: []float64{4, 0, 6}, Total: 6}
+
+	res, err := synthesizeChecked(constraint, microData, header, config)
+	if err != nil {
+		return err
+	}
+	if err := checkResult(res, constraint, microData); err != nil {
+		return err
+	}
+	for i, want := range []float64{6, 0, 6} {
+		if res.Totals[i] != want {
+			return fmt.Errorf("total of variable %d is %v, want %v", i, res.Totals[i], want)
+		}
+	}
+	return nil
+}
+
+func selfTestParallelRun(config synthpop.AnnealingConfig) error {
+	dir, err := os.MkdirTemp("", "gosynthpop-selftest")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	rng := rand.New(rand.NewSource(3))
+	header := selfTestHeader(4)
+	microData := randomMicrodata(rng, 100, 4)
+	areas := 2 * runtime.NumCPU()
+	constraints := make([]synthpop.ConstraintData, areas)
+	population := 0
+	for i := range constraints {
+		total := 5 + rng.Intn(20)
+		values := make([]float64, len(header))
+		for j := range values {
+			values[j] = float64(rng.Intn(total + 1))
+		}
+		constraints[i] = synthpop.ConstraintData{ID: "A" + strconv.Itoa(i), Values: values, Total: float64(total)}
+		population += total
+	}
+
+	var popConfig synthpop.PopulationConfig
+	popConfig.Output.File = filepath.Join(dir, "ids.csv")
+	popConfig.Validate.File = filepath.Join(dir, "validate.csv")
+	if err := synthpop.Run(constraints, microData, header, popConfig, config); err != nil {
+		return err
+	}
+
+	for _, check := range []struct {
+		file string
+		rows int
+	}{{popConfig.Output.File, population}, {popConfig.Validate.File, areas}} {
+		rows, err := countCSVRows(check.file)
+		if err != nil {
+			return err
+		}
+		if rows != check.rows {
+			return fmt.Errorf("%s has %d data rows, want %d", filepath.Base(check.file), rows, check.rows)
+		}
+	}
+	return nil
+}
+
+// selfTestMetrics checks that every metric is used throughout the search: the
+// reported fitness must be the configured metric of the final totals, and the
+// metrics must not all settle on the same population.
+func selfTestMetrics(config synthpop.AnnealingConfig) error {
+	metrics := map[string]synthpop.DistanceFunc{
+		"KL_DIVERGENCE":  synthpop.KLDivergence,
+		"CHI_SQUARED":    synthpop.ChiSquaredDistance,
+		"EUCLIDEAN":      synthpop.EuclideanDistance,
+		"NORM_EUCLIDEAN": synthpop.NormalizedEuclideanDistance,
+		"MANHATTEN":      synthpop.ManhattanDistance,
+		"COSINE":         synthpop.CosineDistance,
+		"JSDIVERGENCE":   synthpop.JSDivergence,
+	}
+
+	header := selfTestHeader(5)
+	microData := randomMicrodata(rand.New(rand.NewSource(4)), 200, 5)
+	constraint := synthpop.ConstraintData{ID: "metrics", Values: []float64{30, 12, 25, 5, 18}, Total: 40}
+
+	populations := make(map[string]bool)
+	for _, name := range synthpop.ValidMetrics {
+		config.Distance = name
+		res, err := synthesizeChecked(constraint, microData, header, config)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if err := checkResult(res, constraint, microData); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if want := metrics[name](constraint.Values, res.Totals); math.Abs(res.Fitness-want) > 1e-9*math.Max(1, math.Abs(want)) {
+			return fmt.Errorf("%s: reported fitness %v, the metric of the totals is %v", name, res.Fitness, want)
+		}
+		populations[fmt.Sprint(res.Totals)] = true
+	}
+	if len(populations) == 1 {
+		return fmt.Errorf("all metrics produced the same population")
+	}
+	return nil
+}
+
+// selfTestAcceptance checks which worsening moves every acceptance rule keeps: the
+// Metropolis criterion keeps nearly all of them when hot and none when cold, greedy
+// search none, and threshold accepting those below a hot temperature. Improving
+// moves must be kept by all of them.
+func selfTestAcceptance(config synthpop.AnnealingConfig) error {
+	header := selfTestHeader(4)
+	microData := randomMicrodata(rand.New(rand.NewSource(5)), 100, 4)
+	constraint := synthpop.ConstraintData{ID: "acceptance", Values: []float64{20, 14, 9, 25}, Total: 30}
+
+	config.MaxIterations = 2000
+	config.CoolingRate = 1
+	config.MinTemp = 0
+	config.FitnessThreshold = 0
+	config.MinImprovement = 0
+	config.Change = config.MaxIterations
+	for _, c := range []struct {
+		rule        string
+		temp        float64
+		worseKept   bool // Whether worse moves must be kept
+		mostlyKept  bool // Whether nearly all moves must be kept
+		improvesFit bool // Whether the best fitness must improve on a random start
+	}{
+		{synthpop.AcceptMetropolis, 1e6, true, true, false},
+		{synthpop.AcceptMetropolis, 1e-9, false, false, true},
+		{synthpop.AcceptGreedy, 1e6, false, false, true},
+		{synthpop.AcceptThreshold, 1e6, true, true, false},
+	} {
+		config.Acceptance, config.InitialTemp = c.rule, c.temp
+		name := fmt.Sprintf("%s at temperature %g", c.rule, c.temp)
+		res, err := synthesizeChecked(constraint, microData, header, config)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		switch {
+		case c.worseKept && res.AcceptedWorse == 0:
+			return fmt.Errorf("%s kept no worse move in %d", name, res.Iterations)
+		case !c.worseKept && res.AcceptedWorse > 0:
+			return fmt.Errorf("%s kept %d worse moves", name, res.AcceptedWorse)
+		case c.mostlyKept && res.Reverted > res.Iterations/100:
+			return fmt.Errorf("%s reverted %d of %d moves", name, res.Reverted, res.Iterations)
+		case res.Accepted == 0:
+			return fmt.Errorf("%s kept no move in %d", name, res.Iterations)
+		case c.improvesFit && res.BestIteration == 0:
+			return fmt.Errorf("%s never improved on the initial population", name)
+		}
+	}
+	return nil
+}
+
+// selfTestIncrementalFitness checks the incremental fitness of random moves against
+// the full distance for every metric, alone and with variable weights and groups,
+// rounding tolerance and hard constraints, on dense and on sparse microdata
+func selfTestIncrementalFitness(config synthpop.AnnealingConfig) error {
+	const variables = 12
+	rng := rand.New(rand.NewSource(6))
+	header := selfTestHeader(variables)
+	dense := randomMicrodata(rng, 100, variables)
+	sparse := make([]synthpop.MicroData, 100)
+	for i := range sparse {
+		values := make([]float64, variables)
+		values[rng.Intn(variables)], values[rng.Intn(variables)] = 1, 2
+		sparse[i] = synthpop.MicroData{ID: "s" + strconv.Itoa(i), Values: values}
+	}
+	constraint := synthpop.ConstraintData{ID: "incremental", Values: make([]float64, variables), Total: 40}
+	for i := range constraint.Values {
+		constraint.Values[i] = float64(rng.Intn(int(constraint.Total)))
+	}
+	constraint.Values[3] = 0
+
+	config.Metrics = map[string]synthpop.MetricExpression{"SQUARES": {Term: "(t - c) * (t - c)", Final: "sqrt(s)"}}
+	checked := 0
+	for _, name := range append(synthpop.ValidMetrics, "SQUARES") {
+		config.Distance = name
+		for _, variant := range []func(*synthpop.AnnealingConfig){
+			func(c *synthpop.AnnealingConfig) {},
+			func(c *synthpop.AnnealingConfig) {
+				c.VariableWeights = map[string]float64{"v0": 2, "v5": 0.5}
+				c.VariableGroups = []synthpop.VariableGroup{{Name: "first", Variables: []string{"v0", "v1", "v2"}, Distance: "MANHATTEN", Weight: 3}}
+				c.RoundingBase = 3
+				c.HardConstraints = []string{"v4", "v1"}
+			},
+		} {
+			variantConfig := config
+			variant(&variantConfig)
+			for _, microData := range [][]synthpop.MicroData{dense, sparse} {
+				incremental, err := synthpop.CheckIncrementalFitness(variantConfig, header, constraint, microData, 500, rng)
+				if err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+				if incremental {
+					checked++
+				}
+			}
+		}
+	}
+	if checked == 0 {
+		return fmt.Errorf("no metric is evaluated incrementally")
+	}
+	return nil
+}
+
+// countCSVRows returns the number of rows after the header
+func countCSVRows(file string) (int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return 0, fmt.Errorf("error reading %s: %w", file, err)
+	}
+	return len(records) - 1, nil
+}
+
+// selftestCommand implements `selftest`: it runs the bundled scenarios and fails if
+// any of them does not behave as expected
+func selftestCommand(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: selftest")
+	}
+	synthpop.Printf("🔍 Self-test on %s/%s with %d CPUs (%s)\n", runtime.GOOS, runtime.GOARCH, runtime.NumCPU(), runtime.Version())
+
+	failed := 0
+	for _, test := range selfTests {
+		if err := test.run(selfTestConfig()); err != nil {
+			synthpop.Printf("❌ %s: %v\n", test.name, err)
+			failed++
+			continue
+		}
+		synthpop.Printf("✅ %s\n", test.name)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d scenarios failed", failed, len(selfTests))
+	}
+	synthpop.Printf("🏁 All %d scenarios passed\n", len(selfTests))
+	return nil
+}
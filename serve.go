@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"simulatedAnnealing/pkg/synthpop"
+)
+
+// serveCommand implements `serve [-grpc address] [-jobs n] [-output-root dir]`: it
+// runs the gRPC job service of pkg/synthpop/gosynthpop.proto until interrupted
+func serveCommand(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := flags.String("grpc", ":50051", "address of the gRPC service")
+	jobs := flags.Int("jobs", 1, "number of jobs run at once, the others are queued")
+	outputRoot := flags.String("output-root", "", "directory of the output directories of the jobs")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 || *jobs < 1 {
+		return fmt.Errorf("usage: serve [-grpc address] [-jobs n > 0] [-output-root dir]")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return synthpop.NewJobServer(*jobs, *outputRoot).ListenAndServe(ctx, *addr)
+}
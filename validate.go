@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"simulatedAnnealing/pkg/synthpop"
+)
+
+// validateCommand implements `validate [-a annealing config] [overrides] [-f config]...`:
+// it checks the configs and their input files as `run` would, without synthesizing
+func validateCommand(args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	var configFiles stringList
+	flags.Var(&configFiles, "f", "population config file (repeat to check several)")
+	annealingFile := flags.String("a", "annealing_config.json", "annealing config file")
+	overrides := addOverrideFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	configFiles = append(configFiles, flags.Args()...)
+	if len(configFiles) == 0 {
+		configFiles = stringList{"config.json"}
+	}
+
+	annealingConfig, err := synthpop.LoadAnnealingConfig(*annealingFile)
+	if err != nil {
+		return fmt.Errorf("annealing config error: %w", err)
+	}
+	if annealingConfig, err = overrides.applyAnnealing(annealingConfig); err != nil {
+		return err
+	}
+
+	controller := synthpop.NewController()
+	var failed []string
+	for _, configFile := range configFiles {
+		config, err := synthpop.LoadConfig(configFile)
+		if err == nil {
+			config, err = overrides.applyPopulation(config)
+		}
+		var in synthpop.Inputs
+		if err == nil {
+			in, err = controller.Check(context.Background(), config, annealingConfig)
+		}
+		if err != nil {
+			synthpop.Printf("❌ %s: %v\n", configFile, err)
+			failed = append(failed, configFile)
+			continue
+		}
+		synthpop.Printf("✅ %s: %d areas, %d microdata records, %d variables\n",
+			configFile, len(in.Constraints), len(in.MicroData), len(in.Header))
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("invalid configs: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
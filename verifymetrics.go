@@ -0,0 +1,180 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+
+	"simulatedAnnealing/pkg/synthpop"
+)
+
+// symmetricMetrics are the built-in metrics expected to give the same distance
+// with the two vectors swapped. KL divergence, chi-squared and the normalised
+// Euclidean distance weigh the errors by the constraints and are not symmetric.
+var symmetricMetrics = map[string]bool{
+	"EUCLIDEAN":    true,
+	"MANHATTEN":    true,
+	"COSINE":       true,
+	"JSDIVERGENCE": true,
+}
+
+// metricCaveats are the known departures of built-in metrics from the properties,
+// reported as warnings rather than failures
+var metricCaveats = map[string]string{
+	"KL_DIVERGENCE": "it compares counts rather than distributions, so it goes negative where the synthetic totals exceed the constraints",
+}
+
+// metricTolerance is the relative slack allowed for rounding in the comparisons
+const metricTolerance = 1e-9
+
+// metricProperty is one property checked on random vectors. check returns a
+// description of a counterexample, or "" when the vectors satisfy it.
+type metricProperty struct {
+	name  string
+	check func(distance synthpop.DistanceFunc, c, t []float64) string
+}
+
+// metricProperties are the properties every metric must have
+var metricProperties = []metricProperty{
+	{"non-negative", func(distance synthpop.DistanceFunc, c, t []float64) string {
+		if d := distance(c, t); d < 0 || math.IsNaN(d) {
+			return fmt.Sprintf("d(%v, %v) = %g", c, t, d)
+		}
+		return ""
+	}},
+	{"zero for identical vectors", func(distance synthpop.DistanceFunc, c, _ []float64) string {
+		if d := distance(c, c); math.Abs(d) > metricTolerance {
+			return fmt.Sprintf("d(%v, %v) = %g", c, c, d)
+		}
+		return ""
+	}},
+	{"increasing away from the constraints", func(distance synthpop.DistanceFunc, c, t []float64) string {
+		// Totals on the segment from the constraints to t, further and further out
+		previous := 0.0
+		for _, s := range []float64{0.25, 0.5, 1} {
+			mixed := make([]float64, len(c))
+			for i := range c {
+				mixed[i] = (1-s)*c[i] + s*t[i]
+			}
+			d := distance(c, mixed)
+			if d < previous-metricTolerance*math.Max(1, math.Abs(previous)) {
+				return fmt.Sprintf("d(%v, %v) = %g is below %g at a smaller step", c, mixed, d, previous)
+			}
+			previous = d
+		}
+		return ""
+	}},
+}
+
+// symmetryProperty checks d(c, t) = d(t, c)
+var symmetryProperty = metricProperty{"symmetric", func(distance synthpop.DistanceFunc, c, t []float64) string {
+	d1, d2 := distance(c, t), distance(t, c)
+	if math.Abs(d1-d2) > metricTolerance*math.Max(1, math.Abs(d1)) {
+		return fmt.Sprintf("d(%v, %v) = %g but d(%v, %v) = %g", c, t, d1, t, c, d2)
+	}
+	return ""
+}}
+
+// randomCounts returns a vector of 2 to 20 counts between 0 and 100, with a share of
+// zeros to exercise the zero constraints
+func randomCounts(rng *rand.Rand, n int) []float64 {
+	v := make([]float64, n)
+	for i := range v {
+		if rng.Intn(4) > 0 {
+			v[i] = float64(rng.Intn(101))
+		}
+	}
+	return v
+}
+
+// verifyMetricsCommand checks the properties of every metric the annealing config
+// can name, built in, registered or defined in its metrics section, on random
+// count vectors. Symmetry is required of the built-in metrics that should have it
+// and only reported for the others; the known caveats of built-in metrics are
+// warnings.
+func verifyMetricsCommand(args []string) error {
+	flags := flag.NewFlagSet("verify-metrics", flag.ContinueOnError)
+	annealingFile := flags.String("a", "", "annealing config file whose expression metrics are checked too")
+	trials := flags.Int("n", 1000, "random vector pairs per metric")
+	seed := flags.Int64("seed", 1, "seed of the random vectors")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 || *trials <= 0 {
+		return fmt.Errorf("usage: verify-metrics [-a annealing config] [-n trials > 0] [-seed seed]")
+	}
+	var config synthpop.AnnealingConfig
+	if *annealingFile != "" {
+		var err error
+		if config, err = synthpop.LoadAnnealingConfig(*annealingFile); err != nil {
+			return fmt.Errorf("annealing config error: %w", err)
+		}
+	}
+
+	names := synthpop.MetricNames(config)
+	synthpop.Printf("🔍 Checking %d metrics on %d random vector pairs\n", len(names), *trials)
+	failed := 0
+	for _, name := range names {
+		distance, err := synthpop.Metric(config, name)
+		if err != nil {
+			return err
+		}
+		properties := metricProperties
+		symmetric := symmetricMetrics[name]
+		if symmetric {
+			properties = append(properties[:len(properties):len(properties)], symmetryProperty)
+		}
+
+		// Every metric sees the same vectors
+		rng := rand.New(rand.NewSource(*seed))
+		counterexamples := make([]string, len(properties))
+		asymmetric := ""
+		for trial := 0; trial < *trials; trial++ {
+			n := 2 + rng.Intn(19)
+			c, t := randomCounts(rng, n), randomCounts(rng, n)
+			for i, p := range properties {
+				if counterexamples[i] == "" {
+					counterexamples[i] = p.check(distance, c, t)
+				}
+			}
+			if !symmetric && asymmetric == "" {
+				asymmetric = symmetryProperty.check(distance, c, t)
+			}
+		}
+
+		ok := true
+		caveat, known := metricCaveats[name]
+		for i, p := range properties {
+			if counterexamples[i] == "" {
+				continue
+			}
+			if known {
+				synthpop.Printf("⚠️ %s is not %s, as expected because %s: %s\n", name, p.name, caveat, counterexamples[i])
+			} else {
+				synthpop.Printf("❌ %s is not %s: %s\n", name, p.name, counterexamples[i])
+			}
+			ok = false
+		}
+		if !ok {
+			if !known {
+				failed++
+			}
+			continue
+		}
+		switch {
+		case symmetric:
+			synthpop.Printf("✅ %s (symmetric)\n", name)
+		case asymmetric != "":
+			synthpop.Printf("✅ %s (not symmetric: %s)\n", name, asymmetric)
+		default:
+			synthpop.Printf("✅ %s (symmetric on these vectors)\n", name)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d metrics failed", failed, len(names))
+	}
+	synthpop.Printf("🏁 All %d metrics passed\n", len(names))
+	return nil
+}